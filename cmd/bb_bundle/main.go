@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"os"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/bundle"
+	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/global"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/buildbarn/bb-storage/pkg/proto/configuration/bb_bundle"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// exportBundle writes a bundle to a file. The bundle is written to a
+// temporary file that is renamed, so that an interrupted export never
+// leaves an incomplete bundle behind.
+func exportBundle(outputPath string, export func(w io.Writer) error) error {
+	temporaryFilePath := outputPath + ".tmp"
+	f, err := os.Create(temporaryFilePath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = export(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temporaryFilePath)
+		return err
+	}
+	return os.Rename(temporaryFilePath, outputPath)
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("Usage: bb_bundle bb_bundle.jsonnet")
+	}
+	var configuration bb_bundle.ApplicationConfiguration
+	if err := util.UnmarshalConfigurationFromFile(os.Args[1], &configuration); err != nil {
+		log.Fatalf("Failed to read configuration from %s: %s", os.Args[1], err)
+	}
+	if _, err := global.ApplyConfiguration(configuration.Global); err != nil {
+		log.Fatal("Failed to apply global configuration options: ", err)
+	}
+
+	registry, err := blobstore_configuration.NewRegistryFromConfiguration(configuration.Global)
+	if err != nil {
+		log.Fatal("Failed to create storage registry: ", err)
+	}
+	contentAddressableStorage, actionCache, err := blobstore_configuration.NewCASAndACBlobAccessFromConfiguration(
+		registry,
+		configuration.Blobstore,
+		bb_grpc.DefaultClientFactory,
+		int(configuration.MaximumMessageSizeBytes))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+
+	switch operation := configuration.Operation.(type) {
+	case *bb_bundle.ApplicationConfiguration_ExportBundle:
+		instanceName, err := digest.NewInstanceName(operation.ExportBundle.InstanceName)
+		if err != nil {
+			log.Fatalf("Invalid instance name %#v: %s", operation.ExportBundle.InstanceName, err)
+		}
+		rootDigest, err := instanceName.NewDigestFromProto(operation.ExportBundle.RootDigest)
+		if err != nil {
+			log.Fatal("Invalid root digest: ", err)
+		}
+		if err := exportBundle(operation.ExportBundle.OutputPath, func(w io.Writer) error {
+			return bundle.Export(
+				ctx,
+				contentAddressableStorage,
+				actionCache,
+				operation.ExportBundle.RootType,
+				rootDigest,
+				int(configuration.MaximumMessageSizeBytes),
+				w)
+		}); err != nil {
+			log.Fatal("Failed to export bundle: ", err)
+		}
+		log.Printf("Exported %s to %s", rootDigest, operation.ExportBundle.OutputPath)
+	case *bb_bundle.ApplicationConfiguration_ImportBundle:
+		instanceName, err := digest.NewInstanceName(operation.ImportBundle.InstanceName)
+		if err != nil {
+			log.Fatalf("Invalid instance name %#v: %s", operation.ImportBundle.InstanceName, err)
+		}
+		f, err := os.Open(operation.ImportBundle.InputPath)
+		if err != nil {
+			log.Fatal("Failed to open bundle: ", err)
+		}
+		defer f.Close()
+		importedCount, err := bundle.Import(
+			ctx,
+			contentAddressableStorage,
+			actionCache,
+			instanceName,
+			int(configuration.MaximumMessageSizeBytes),
+			bufio.NewReader(f))
+		if err != nil {
+			log.Fatal("Failed to import bundle: ", err)
+		}
+		log.Printf("Imported %d objects from %s", importedCount, operation.ImportBundle.InputPath)
+	default:
+		log.Fatal("No operation specified")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/global"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/buildbarn/bb-storage/pkg/proto/configuration/bb_copy"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// readCheckpoint returns the number of lines of the digests file that
+// were processed by a previous invocation.
+func readCheckpoint(checkpointFilePath string) (int, error) {
+	if checkpointFilePath == "" {
+		return 0, nil
+	}
+	data, err := ioutil.ReadFile(checkpointFilePath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// writeCheckpoint records the number of lines of the digests file that
+// have been processed. The checkpoint is written to a temporary file
+// that is renamed, so that an interruption never leaves a truncated
+// checkpoint behind.
+func writeCheckpoint(checkpointFilePath string, linesProcessed int) error {
+	if checkpointFilePath == "" {
+		return nil
+	}
+	temporaryFilePath := checkpointFilePath + ".tmp"
+	if err := ioutil.WriteFile(temporaryFilePath, []byte(strconv.Itoa(linesProcessed)+"\n"), 0o666); err != nil {
+		return err
+	}
+	return os.Rename(temporaryFilePath, checkpointFilePath)
+}
+
+// copySummary contains statistics on the objects that were copied.
+type copySummary struct {
+	examinedCount int
+	copiedCount   int
+	copiedBytes   int64
+}
+
+// copyBatch copies the objects in a batch that are missing in the sink.
+func copyBatch(ctx context.Context, sink blobstore.BlobAccess, replicator replication.BlobReplicator, digests digest.Set, summary *copySummary) error {
+	missing, err := sink.FindMissing(ctx, digests)
+	if err != nil {
+		return util.StatusWrap(err, "Failed to determine which objects are missing")
+	}
+	if err := replicator.ReplicateMultiple(ctx, missing); err != nil {
+		return util.StatusWrap(err, "Failed to replicate objects")
+	}
+	summary.examinedCount += digests.Length()
+	summary.copiedCount += missing.Length()
+	for _, blobDigest := range missing.Items() {
+		summary.copiedBytes += blobDigest.GetSizeBytes()
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("Usage: bb_copy bb_copy.jsonnet")
+	}
+	var configuration bb_copy.ApplicationConfiguration
+	if err := util.UnmarshalConfigurationFromFile(os.Args[1], &configuration); err != nil {
+		log.Fatalf("Failed to read configuration from %s: %s", os.Args[1], err)
+	}
+	if _, err := global.ApplyConfiguration(configuration.Global); err != nil {
+		log.Fatal("Failed to apply global configuration options: ", err)
+	}
+
+	registry, err := blobstore_configuration.NewRegistryFromConfiguration(configuration.Global)
+	if err != nil {
+		log.Fatal("Failed to create storage registry: ", err)
+	}
+	blobAccessCreator := blobstore_configuration.NewCASBlobAccessCreator(
+		registry,
+		bb_grpc.DefaultClientFactory,
+		int(configuration.MaximumMessageSizeBytes))
+	source, err := blobstore_configuration.NewBlobAccessFromConfiguration(
+		configuration.Source,
+		blobAccessCreator)
+	if err != nil {
+		log.Fatal("Failed to create source: ", err)
+	}
+	sink, err := blobstore_configuration.NewBlobAccessFromConfiguration(
+		configuration.Sink,
+		blobAccessCreator)
+	if err != nil {
+		log.Fatal("Failed to create sink: ", err)
+	}
+	replicator, err := blobstore_configuration.NewBlobReplicatorFromConfiguration(
+		configuration.Replicator,
+		source.BlobAccess,
+		sink,
+		blobstore_configuration.NewCASBlobReplicatorCreator(registry, bb_grpc.DefaultClientFactory))
+	if err != nil {
+		log.Fatal("Failed to create replicator: ", err)
+	}
+
+	// Skip the objects that were copied by a previous invocation.
+	linesProcessed, err := readCheckpoint(configuration.CheckpointFilePath)
+	if err != nil {
+		log.Fatal("Failed to read checkpoint: ", err)
+	}
+	if linesProcessed > 0 {
+		log.Printf("Resuming after line %d of the digests file", linesProcessed)
+	}
+	f, err := os.Open(configuration.DigestsFilePath)
+	if err != nil {
+		log.Fatal("Failed to open digests file: ", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 0; line < linesProcessed; line++ {
+		if !scanner.Scan() {
+			log.Fatal("Digests file is shorter than the checkpoint")
+		}
+	}
+
+	// Copy objects in batches. Progress is only recorded after all
+	// objects in a batch have been copied.
+	ctx := context.Background()
+	start := time.Now()
+	var summary copySummary
+	for {
+		digests := digest.NewSetBuilder()
+		linesInBatch := 0
+		for digests.Length() < blobstore.RecommendedFindMissingDigestsCount && scanner.Scan() {
+			linesInBatch++
+			path := strings.TrimSpace(scanner.Text())
+			if path == "" {
+				continue
+			}
+			blobDigest, err := digest.NewDigestFromByteStreamReadPath(path)
+			if err != nil {
+				log.Fatalf("Invalid digest on line %d of the digests file: %s", linesProcessed+linesInBatch, err)
+			}
+			digests.Add(blobDigest)
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal("Failed to read digests file: ", err)
+		}
+		if linesInBatch == 0 {
+			break
+		}
+
+		if err := copyBatch(ctx, sink.BlobAccess, replicator, digests.Build(), &summary); err != nil {
+			log.Fatalf("Failed to copy objects after line %d of the digests file: %s", linesProcessed, err)
+		}
+		linesProcessed += linesInBatch
+		if err := writeCheckpoint(configuration.CheckpointFilePath, linesProcessed); err != nil {
+			log.Fatal("Failed to write checkpoint: ", err)
+		}
+	}
+
+	if configuration.CheckpointFilePath != "" {
+		if err := os.Remove(configuration.CheckpointFilePath); err != nil && !os.IsNotExist(err) {
+			log.Fatal("Failed to remove checkpoint: ", err)
+		}
+	}
+	log.Printf(
+		"Copied %d out of %d objects (%d bytes) in %s",
+		summary.copiedCount,
+		summary.examinedCount,
+		summary.copiedBytes,
+		time.Now().Sub(start))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+	"github.com/buildbarn/bb-storage/pkg/blockdevice"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/global"
+	"github.com/buildbarn/bb-storage/pkg/proto/configuration/bb_fsck"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// locationRecordStatusNames contains human readable descriptions of
+// the outcomes of validating entries in the key-location map.
+var locationRecordStatusNames = map[local.LocationRecordStatus]string{
+	local.LocationRecordStatusUnused:      "unused",
+	local.LocationRecordStatusValid:       "valid",
+	local.LocationRecordStatusMisplaced:   "stored at the wrong index",
+	local.LocationRecordStatusOutOfBounds: "referencing data outside of blocks",
+	local.LocationRecordStatusCorrupt:     "referencing data with a checksum mismatch",
+}
+
+// bb_fsck checks the consistency of the data stored by a persistent
+// LocalBlobAccess while it is offline. It validates every entry in the
+// key-location map against the blocks it references, and optionally
+// clears entries that fail validation.
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("Usage: bb_fsck bb_fsck.jsonnet")
+	}
+	var configuration bb_fsck.ApplicationConfiguration
+	if err := util.UnmarshalConfigurationFromFile(os.Args[1], &configuration); err != nil {
+		log.Fatalf("Failed to read configuration from %s: %s", os.Args[1], err)
+	}
+	if _, err := global.ApplyConfiguration(configuration.Global); err != nil {
+		log.Fatal("Failed to apply global configuration options: ", err)
+	}
+
+	localConfiguration := configuration.Local
+	persistent := localConfiguration.GetPersistent()
+	if persistent == nil {
+		log.Fatal("Local storage must have persistency enabled")
+	}
+	blocksOnBlockDevice := localConfiguration.GetBlocksOnBlockDevice()
+	if blocksOnBlockDevice == nil {
+		log.Fatal("Local storage must store blocks on a block device")
+	}
+	keyLocationMapOnBlockDevice := localConfiguration.GetKeyLocationMapOnBlockDevice()
+	if keyLocationMapOnBlockDevice == nil {
+		log.Fatal("Local storage must store the key-location map on a block device")
+	}
+
+	// Open the blocks block device with the same geometry as the
+	// storage daemon.
+	blockCount := blocksOnBlockDevice.SpareBlocks + localConfiguration.OldBlocks + localConfiguration.CurrentBlocks + localConfiguration.NewBlocks
+	var blockDevice blockdevice.BlockDevice
+	var sectorSizeBytes int
+	var blockSectorCount int64
+	if len(blocksOnBlockDevice.Sources) == 0 {
+		var sectorCount int64
+		var err error
+		blockDevice, sectorSizeBytes, sectorCount, err = blockdevice.NewBlockDeviceFromConfiguration(blocksOnBlockDevice.Source, false)
+		if err != nil {
+			log.Fatal("Failed to open blocks block device: ", err)
+		}
+		blockSectorCount = sectorCount / int64(blockCount)
+	} else {
+		var err error
+		blockDevice, sectorSizeBytes, blockSectorCount, err = blobstore_configuration.NewStripingBlockDeviceFromConfiguration(
+			blocksOnBlockDevice.Sources,
+			int64(blockCount),
+			false)
+		if err != nil {
+			log.Fatal("Failed to open blocks block devices: ", err)
+		}
+	}
+
+	// Reattach the blocks referenced by the persistent state.
+	persistentStateDirectory, err := filesystem.NewLocalDirectory(persistent.StateDirectoryPath)
+	if err != nil {
+		log.Fatal("Failed to open persistent state directory: ", err)
+	}
+	persistentState, err := local.NewDirectoryBackedPersistentStateStore(persistentStateDirectory).ReadPersistentState()
+	if err != nil {
+		log.Fatal("Failed to read persistent state: ", err)
+	}
+	if persistentState.BlobChecksums != (localConfiguration.BlobChecksums != nil) {
+		log.Print("Warning: Blob checksums are configured differently than when the blocks were written. The storage daemon will discard all blocks on startup.")
+	}
+	persistentBlockList, blocksCount := local.NewPersistentBlockList(
+		local.NewBlockDeviceBackedBlockAllocator(
+			blockDevice,
+			blobstore.CASReadBufferFactory,
+			sectorSizeBytes,
+			blockSectorCount,
+			int(blockCount)),
+		sectorSizeBytes,
+		blockSectorCount,
+		persistentState.OldestEpochId,
+		persistentState.Blocks)
+	if blocksCount < len(persistentState.Blocks) {
+		log.Printf("Only %d out of %d blocks referenced by the persistent state could be reattached", blocksCount, len(persistentState.Blocks))
+	}
+	blockWriteOffsetsBytes := make([]int64, 0, blocksCount)
+	for _, blockState := range persistentState.Blocks[:blocksCount] {
+		blockWriteOffsetsBytes = append(blockWriteOffsetsBytes, blockState.WriteOffsetBytes)
+	}
+
+	// Open the key-location map block device.
+	keyLocationMapBlockDevice, keyLocationMapSectorSizeBytes, keyLocationMapSectorCount, err := blockdevice.NewBlockDeviceFromConfiguration(keyLocationMapOnBlockDevice, false)
+	if err != nil {
+		log.Fatal("Failed to open key-location map block device: ", err)
+	}
+	recordsCount := int((int64(keyLocationMapSectorSizeBytes) * keyLocationMapSectorCount) / local.BlockDeviceBackedLocationRecordSize)
+	expectedRecordsCount := recordsCount
+	if localConfiguration.KeyLocationMapCuckooHashing {
+		// Entries are stored at indices in one of two tables,
+		// which LocationRecordChecker does not compute.
+		log.Print("Key-location map uses cuckoo hashing. Not validating the indices at which entries are stored.")
+		expectedRecordsCount = 0
+	} else if persistentState.KeyLocationMapRecordsCount > 0 && persistentState.KeyLocationMapRecordsCount != int64(recordsCount) {
+		// Migration of entries to a resized key-location map
+		// has not completed. Entries may be stored at indices
+		// corresponding to either size.
+		log.Print("Key-location map is being resized. Not validating the indices at which entries are stored.")
+		expectedRecordsCount = 0
+	}
+	checker := local.NewLocationRecordChecker(
+		local.NewBlockDeviceBackedLocationRecordArray(keyLocationMapBlockDevice, persistentBlockList),
+		expectedRecordsCount,
+		persistentState.KeyLocationMapHashInitialization,
+		persistentBlockList,
+		blockWriteOffsetsBytes,
+		persistentState.BlobChecksums)
+	if !persistentState.BlobChecksums {
+		log.Print("Blob checksums are disabled. Only the locations of blobs can be validated, not their contents.")
+	}
+
+	// Validate all entries in the key-location map. Clear entries
+	// that fail validation by overwriting them with zeros, which
+	// is how entries that were never written are represented.
+	statusCounts := map[local.LocationRecordStatus]int{}
+	var clearedRecord [local.BlockDeviceBackedLocationRecordSize]byte
+	for index := 0; index < recordsCount; index++ {
+		recordStatus, err := checker.CheckRecord(index)
+		if err != nil {
+			log.Fatal(err)
+		}
+		statusCounts[recordStatus]++
+		if recordStatus != local.LocationRecordStatusUnused && recordStatus != local.LocationRecordStatusValid {
+			log.Printf("Entry at index %d is %s", index, locationRecordStatusNames[recordStatus])
+			if configuration.Repair {
+				if _, err := keyLocationMapBlockDevice.WriteAt(clearedRecord[:], int64(index)*local.BlockDeviceBackedLocationRecordSize); err != nil {
+					log.Fatalf("Failed to clear entry at index %d: %s", index, err)
+				}
+			}
+		}
+	}
+	if configuration.Repair {
+		if err := keyLocationMapBlockDevice.Sync(); err != nil {
+			log.Fatal("Failed to synchronize key-location map block device: ", err)
+		}
+	}
+
+	inconsistentCount := 0
+	for recordStatus := local.LocationRecordStatusUnused; recordStatus <= local.LocationRecordStatusCorrupt; recordStatus++ {
+		log.Printf("Entries %s: %d", locationRecordStatusNames[recordStatus], statusCounts[recordStatus])
+		if recordStatus != local.LocationRecordStatusUnused && recordStatus != local.LocationRecordStatusValid {
+			inconsistentCount += statusCounts[recordStatus]
+		}
+	}
+	if inconsistentCount > 0 {
+		if configuration.Repair {
+			log.Printf("Cleared %d inconsistent entries", inconsistentCount)
+		} else {
+			log.Fatalf("Found %d inconsistent entries", inconsistentCount)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/global"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/buildbarn/bb-storage/pkg/proto/configuration/bb_import_disk_cache"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// diskCacheEntry is an object that was found in the disk cache.
+type diskCacheEntry struct {
+	path   string
+	digest digest.Digest
+}
+
+// findEntries scans a directory of Bazel's disk cache for objects.
+// Objects are stored in files named after their hash. Depending on the
+// version of Bazel, these files are either stored in the directory
+// directly or in subdirectories named after the first two characters
+// of the hash. Other files (e.g., temporary files) are ignored.
+func findEntries(directoryPath string, instanceName digest.InstanceName) ([]diskCacheEntry, error) {
+	var entries []diskCacheEntry
+	err := filepath.Walk(directoryPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if blobDigest, err := instanceName.NewDigest(info.Name(), info.Size()); err == nil {
+			entries = append(entries, diskCacheEntry{
+				path:   path,
+				digest: blobDigest,
+			})
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// importEntries uploads objects from the disk cache into storage.
+// Objects that are already present are skipped. Objects that fail to
+// upload (e.g., due to them being corrupted) are logged, but don't
+// cause the import to fail.
+func importEntries(ctx context.Context, blobAccess blobstore.BlobAccess, entries []diskCacheEntry, newBuffer func(digest.Digest, *os.File) buffer.Buffer, maximumConcurrency int) (int, error) {
+	importedCount := 0
+	for len(entries) > 0 {
+		// Determine which objects in the current batch are
+		// missing.
+		batchSize := len(entries)
+		if batchSize > blobstore.RecommendedFindMissingDigestsCount {
+			batchSize = blobstore.RecommendedFindMissingDigestsCount
+		}
+		batch := entries[:batchSize]
+		entries = entries[batchSize:]
+		digests := digest.NewSetBuilder()
+		for _, entry := range batch {
+			digests.Add(entry.digest)
+		}
+		missing, err := blobAccess.FindMissing(ctx, digests.Build())
+		if status.Code(err) == codes.Unimplemented {
+			// Backends of the Action Cache may not support
+			// existence checking. Upload all objects.
+			missing = digests.Build()
+		} else if err != nil {
+			return importedCount, util.StatusWrap(err, "Failed to determine which objects are missing")
+		}
+		missingDigests := map[digest.Digest]struct{}{}
+		for _, blobDigest := range missing.Items() {
+			missingDigests[blobDigest] = struct{}{}
+		}
+
+		// Upload the missing objects concurrently.
+		semaphore := make(chan struct{}, maximumConcurrency)
+		var wg sync.WaitGroup
+		var lock sync.Mutex
+		for _, entry := range batch {
+			if _, ok := missingDigests[entry.digest]; !ok {
+				continue
+			}
+			delete(missingDigests, entry.digest)
+			semaphore <- struct{}{}
+			wg.Add(1)
+			go func(entry diskCacheEntry) {
+				defer func() {
+					<-semaphore
+					wg.Done()
+				}()
+				f, err := os.Open(entry.path)
+				if err != nil {
+					log.Printf("Failed to open %#v: %s", entry.path, err)
+					return
+				}
+				if err := blobAccess.Put(ctx, entry.digest, newBuffer(entry.digest, f)); err != nil {
+					log.Printf("Failed to import %#v: %s", entry.path, err)
+					return
+				}
+				lock.Lock()
+				importedCount++
+				lock.Unlock()
+			}(entry)
+		}
+		wg.Wait()
+	}
+	return importedCount, nil
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("Usage: bb_import_disk_cache bb_import_disk_cache.jsonnet")
+	}
+	var configuration bb_import_disk_cache.ApplicationConfiguration
+	if err := util.UnmarshalConfigurationFromFile(os.Args[1], &configuration); err != nil {
+		log.Fatalf("Failed to read configuration from %s: %s", os.Args[1], err)
+	}
+	if _, err := global.ApplyConfiguration(configuration.Global); err != nil {
+		log.Fatal("Failed to apply global configuration options: ", err)
+	}
+
+	registry, err := blobstore_configuration.NewRegistryFromConfiguration(configuration.Global)
+	if err != nil {
+		log.Fatal("Failed to create storage registry: ", err)
+	}
+	contentAddressableStorage, actionCache, err := blobstore_configuration.NewCASAndACBlobAccessFromConfiguration(
+		registry,
+		configuration.Blobstore,
+		bb_grpc.DefaultClientFactory,
+		int(configuration.MaximumMessageSizeBytes))
+	if err != nil {
+		log.Fatal(err)
+	}
+	instanceName, err := digest.NewInstanceName(configuration.InstanceName)
+	if err != nil {
+		log.Fatalf("Invalid instance name %#v: %s", configuration.InstanceName, err)
+	}
+	maximumConcurrency := int(configuration.MaximumConcurrency)
+	if maximumConcurrency < 1 {
+		maximumConcurrency = 1
+	}
+	ctx := context.Background()
+
+	// Import objects into the Content Addressable Storage.
+	casEntries, err := findEntries(filepath.Join(configuration.DiskCachePath, "cas"), instanceName)
+	if err != nil {
+		log.Fatal("Failed to scan Content Addressable Storage directory: ", err)
+	}
+	importedCount, err := importEntries(
+		ctx,
+		contentAddressableStorage,
+		casEntries,
+		func(blobDigest digest.Digest, f *os.File) buffer.Buffer {
+			return buffer.NewCASBufferFromReader(blobDigest, f, buffer.UserProvided)
+		},
+		maximumConcurrency)
+	if err != nil {
+		log.Fatal("Failed to import Content Addressable Storage objects: ", err)
+	}
+	log.Printf("Imported %d out of %d Content Addressable Storage objects", importedCount, len(casEntries))
+
+	// Import objects into the Action Cache. Files in the disk cache
+	// are only named after the hash of the action, while the Action
+	// Cache is keyed by the full digest. Only Action Cache entries
+	// for which the Action message is present in the Content
+	// Addressable Storage directory can be imported.
+	actionSizes := map[string]int64{}
+	for _, entry := range casEntries {
+		actionSizes[entry.digest.GetHashString()] = entry.digest.GetSizeBytes()
+	}
+	acEntries, err := findEntries(filepath.Join(configuration.DiskCachePath, "ac"), instanceName)
+	if err != nil {
+		log.Fatal("Failed to scan Action Cache directory: ", err)
+	}
+	var importableACEntries []diskCacheEntry
+	for _, entry := range acEntries {
+		hash := entry.digest.GetHashString()
+		sizeBytes, ok := actionSizes[hash]
+		if !ok {
+			log.Printf("Skipping %#v, as the size of the corresponding Action message is unknown", entry.path)
+			continue
+		}
+		actionDigest, err := instanceName.NewDigest(hash, sizeBytes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		importableACEntries = append(importableACEntries, diskCacheEntry{
+			path:   entry.path,
+			digest: actionDigest,
+		})
+	}
+	importedCount, err = importEntries(
+		ctx,
+		actionCache,
+		importableACEntries,
+		func(blobDigest digest.Digest, f *os.File) buffer.Buffer {
+			return buffer.NewProtoBufferFromReader(&remoteexecution.ActionResult{}, f, buffer.UserProvided)
+		},
+		maximumConcurrency)
+	if err != nil {
+		log.Fatal("Failed to import Action Cache objects: ", err)
+	}
+	log.Printf("Imported %d out of %d Action Cache objects", importedCount, len(acEntries))
+}
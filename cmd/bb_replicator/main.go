@@ -2,19 +2,59 @@ package main
 
 import (
 	"log"
+	"net/http"
 	"os"
 
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/readfallback"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
 	"github.com/buildbarn/bb-storage/pkg/global"
 	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
 	"github.com/buildbarn/bb-storage/pkg/proto/configuration/bb_replicator"
 	replicator_pb "github.com/buildbarn/bb-storage/pkg/proto/replicator"
+	"github.com/buildbarn/bb-storage/pkg/reload"
 	"github.com/buildbarn/bb-storage/pkg/util"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// newSourceBlobAccess creates the backend from which objects are
+// replicated. When multiple sources are configured, they are chained
+// together, so that objects are read from the first source that
+// contains them.
+func newSourceBlobAccess(configuration *bb_replicator.ApplicationConfiguration, creator blobstore_configuration.BlobAccessCreator) (blobstore.BlobAccess, error) {
+	if len(configuration.Sources) == 0 {
+		info, err := blobstore_configuration.NewBlobAccessFromConfiguration(configuration.Source, creator)
+		if err != nil {
+			return nil, err
+		}
+		return info.BlobAccess, nil
+	}
+	if configuration.Source != nil {
+		return nil, status.Error(codes.InvalidArgument, "Source and sources cannot be specified at the same time")
+	}
+
+	var source blobstore.BlobAccess
+	for i := len(configuration.Sources) - 1; i >= 0; i-- {
+		info, err := blobstore_configuration.NewBlobAccessFromConfiguration(configuration.Sources[i], creator)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Source %d", i)
+		}
+		if source == nil {
+			source = info.BlobAccess
+		} else {
+			source = readfallback.NewReadFallbackBlobAccess(
+				info.BlobAccess,
+				source,
+				replication.NewNoopBlobReplicator(source))
+		}
+	}
+	return source, nil
+}
+
 func main() {
 	if len(os.Args) != 2 {
 		log.Fatal("Usage: bb_replicator bb_replicator.jsonnet")
@@ -28,12 +68,19 @@ func main() {
 		log.Fatal("Failed to apply global configuration options: ", err)
 	}
 
+	// Registered against the default mux, so that the storage
+	// topology and the state of replicators are exposed by the
+	// diagnostics HTTP server.
+	registry, err := blobstore_configuration.NewRegistryFromConfiguration(configuration.Global)
+	if err != nil {
+		log.Fatal("Failed to create storage registry: ", err)
+	}
+	registry.RegisterHTTPHandlers(http.DefaultServeMux)
 	blobAccessCreator := blobstore_configuration.NewCASBlobAccessCreator(
+		registry,
 		bb_grpc.DefaultClientFactory,
 		int(configuration.MaximumMessageSizeBytes))
-	source, err := blobstore_configuration.NewBlobAccessFromConfiguration(
-		configuration.Source,
-		blobAccessCreator)
+	source, err := newSourceBlobAccess(&configuration, blobAccessCreator)
 	if err != nil {
 		log.Fatal("Failed to create source: ", err)
 	}
@@ -45,9 +92,9 @@ func main() {
 	}
 	replicator, err := blobstore_configuration.NewBlobReplicatorFromConfiguration(
 		configuration.Replicator,
-		source.BlobAccess,
+		source,
 		sink,
-		blobstore_configuration.NewCASBlobReplicatorCreator(bb_grpc.DefaultClientFactory))
+		blobstore_configuration.NewCASBlobReplicatorCreator(registry, bb_grpc.DefaultClientFactory))
 	if err != nil {
 		log.Fatal("Failed to create replicator: ", err)
 	}
@@ -59,8 +106,19 @@ func main() {
 				configuration.GrpcServers,
 				func(s *grpc.Server) {
 					replicator_pb.RegisterReplicatorServer(s, replication.NewReplicatorServer(replicator))
-				}))
+				},
+				nil,
+				nil,
+				registry.GetReloadRegistry()))
 	}()
 
+	// Allow changing parts of the configuration at runtime, such as
+	// replication concurrency and drained shards, by reloading the
+	// configuration file upon receipt of SIGHUP or a POST request to
+	// /-/reload.
+	reloader := reload.NewConfigurationReloader(os.Args[1], &configuration, registry.GetReloadRegistry())
+	reloader.ReloadOnSIGHUP()
+	http.Handle("/-/reload", reloader)
+
 	lifecycleState.MarkReadyAndWait()
 }
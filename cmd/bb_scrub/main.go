@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/global"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/buildbarn/bb-storage/pkg/proto/configuration/bb_scrub"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// scrubSummary contains statistics on the objects that were scrubbed.
+type scrubSummary struct {
+	examinedCount int
+	missingCount  int
+	corruptCount  int
+	failedCount   int
+	evictedCount  int
+}
+
+// scrubber reads objects concurrently, validating their contents
+// against their digests.
+type scrubber struct {
+	ctx       context.Context
+	registry  *blobstore_configuration.Registry
+	evict     bool
+	semaphore chan struct{}
+	wg        sync.WaitGroup
+
+	lock    sync.Mutex
+	summary scrubSummary
+}
+
+// scrub an object in the background. Data integrity errors are
+// reported by buffers using code INTERNAL, which is how corrupted
+// objects are distinguished from objects that could not be read.
+func (s *scrubber) scrub(blobAccess blobstore.BlobAccess, blobDigest digest.Digest) {
+	s.semaphore <- struct{}{}
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			<-s.semaphore
+			s.wg.Done()
+		}()
+		err := blobAccess.Get(s.ctx, blobDigest).IntoWriter(ioutil.Discard)
+		code := status.Code(err)
+		evicted := false
+		if code == codes.Internal {
+			log.Printf("Object %s is corrupt: %s", blobDigest, err)
+			if s.evict {
+				if paths, err := s.registry.DeleteBlob(s.ctx, "cas", blobDigest); err != nil {
+					log.Printf("Failed to evict object %s: %s", blobDigest, err)
+				} else {
+					log.Printf("Evicted object %s from %s", blobDigest, strings.Join(paths, ", "))
+					evicted = true
+				}
+			}
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.summary.examinedCount++
+		switch code {
+		case codes.OK:
+		case codes.NotFound:
+			// Object was removed after it was enumerated.
+			s.summary.missingCount++
+		case codes.Internal:
+			s.summary.corruptCount++
+			if evicted {
+				s.summary.evictedCount++
+			}
+		default:
+			log.Printf("Failed to read object %s: %s", blobDigest, err)
+			s.summary.failedCount++
+		}
+	}()
+}
+
+func (s *scrubber) wait() scrubSummary {
+	s.wg.Wait()
+	return s.summary
+}
+
+// scrubDigestsFile scrubs all objects listed in a file. Objects are
+// processed in batches, whose existence is probed before reading them.
+func scrubDigestsFile(ctx context.Context, blobAccess blobstore.BlobAccess, digestsFilePath string, s *scrubber) error {
+	f, err := os.Open(digestsFilePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for {
+		digests := digest.NewSetBuilder()
+		for digests.Length() < blobstore.RecommendedFindMissingDigestsCount && scanner.Scan() {
+			line++
+			path := strings.TrimSpace(scanner.Text())
+			if path == "" {
+				continue
+			}
+			blobDigest, err := digest.NewDigestFromByteStreamReadPath(path)
+			if err != nil {
+				return util.StatusWrapf(err, "Invalid digest on line %d", line)
+			}
+			digests.Add(blobDigest)
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		if digests.Length() == 0 {
+			return nil
+		}
+
+		missing, err := blobAccess.FindMissing(ctx, digests.Build())
+		if err != nil {
+			return util.StatusWrap(err, "Failed to determine which objects are missing")
+		}
+		for _, blobDigest := range missing.Items() {
+			log.Printf("Object %s is missing", blobDigest)
+		}
+		present, _, _ := digest.GetDifferenceAndIntersection(digests.Build(), missing)
+		s.lock.Lock()
+		s.summary.examinedCount += missing.Length()
+		s.summary.missingCount += missing.Length()
+		s.lock.Unlock()
+		for _, blobDigest := range present.Items() {
+			s.scrub(blobAccess, blobDigest)
+		}
+	}
+}
+
+// bb_scrub reads objects stored in the Content Addressable Storage and
+// validates their contents against their digests. Unlike bb_fsck, it
+// can be used against any type of backend, including ones that are
+// online, such as Redis or remote storage daemons.
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("Usage: bb_scrub bb_scrub.jsonnet")
+	}
+	var configuration bb_scrub.ApplicationConfiguration
+	if err := util.UnmarshalConfigurationFromFile(os.Args[1], &configuration); err != nil {
+		log.Fatalf("Failed to read configuration from %s: %s", os.Args[1], err)
+	}
+	if _, err := global.ApplyConfiguration(configuration.Global); err != nil {
+		log.Fatal("Failed to apply global configuration options: ", err)
+	}
+
+	registry, err := blobstore_configuration.NewRegistryFromConfiguration(configuration.Global)
+	if err != nil {
+		log.Fatal("Failed to create storage registry: ", err)
+	}
+	storage, err := blobstore_configuration.NewBlobAccessFromConfiguration(
+		configuration.Storage,
+		blobstore_configuration.NewCASBlobAccessCreator(
+			registry,
+			bb_grpc.DefaultClientFactory,
+			int(configuration.MaximumMessageSizeBytes)))
+	if err != nil {
+		log.Fatal("Failed to create storage: ", err)
+	}
+	maximumConcurrency := int(configuration.MaximumConcurrency)
+	if maximumConcurrency < 1 {
+		maximumConcurrency = 1
+	}
+	ctx := context.Background()
+	s := &scrubber{
+		ctx:       ctx,
+		registry:  registry,
+		evict:     configuration.Evict,
+		semaphore: make(chan struct{}, maximumConcurrency),
+	}
+
+	switch digestsSource := configuration.DigestsSource.(type) {
+	case *bb_scrub.ApplicationConfiguration_DigestsFilePath:
+		err = scrubDigestsFile(ctx, storage.BlobAccess, digestsSource.DigestsFilePath, s)
+	case *bb_scrub.ApplicationConfiguration_Enumerate:
+		err = registry.EnumerateBlobs(ctx, "cas", func(blobAccess blobstore.BlobAccess, blobDigest digest.Digest) error {
+			s.scrub(blobAccess, blobDigest)
+			return nil
+		})
+	default:
+		log.Fatal("No digests source specified")
+	}
+	summary := s.wait()
+	if err != nil {
+		log.Fatal("Failed to obtain objects to scrub: ", err)
+	}
+
+	log.Printf(
+		"Examined %d objects: %d missing, %d corrupt, %d failed to read, %d evicted",
+		summary.examinedCount,
+		summary.missingCount,
+		summary.corruptCount,
+		summary.failedCount,
+		summary.evictedCount)
+	if summary.corruptCount > summary.evictedCount || summary.failedCount > 0 {
+		os.Exit(1)
+	}
+}
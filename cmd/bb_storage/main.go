@@ -1,19 +1,39 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go/service/s3"
+	remoteasset "github.com/bazelbuild/remote-apis/build/bazel/remote/asset/v1"
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/auditlog"
+	"github.com/buildbarn/bb-storage/pkg/auth"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/garbagecollection"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/grpcservers"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/httpservers"
 	"github.com/buildbarn/bb-storage/pkg/builder"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/cloud/aws"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/git"
 	"github.com/buildbarn/bb-storage/pkg/global"
 	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	bb_http "github.com/buildbarn/bb-storage/pkg/http"
+	"github.com/buildbarn/bb-storage/pkg/proto/admin"
 	"github.com/buildbarn/bb-storage/pkg/proto/configuration/bb_storage"
 	"github.com/buildbarn/bb-storage/pkg/proto/icas"
+	"github.com/buildbarn/bb-storage/pkg/reload"
 	"github.com/buildbarn/bb-storage/pkg/util"
 
 	"google.golang.org/genproto/googleapis/bytestream"
@@ -33,8 +53,19 @@ func main() {
 		log.Fatal("Failed to apply global configuration options: ", err)
 	}
 
-	// Storage access.
+	// Storage access. The registry keeps track of all storage
+	// backends, so that they can be inspected through the
+	// diagnostics HTTP server and managed by the administrative
+	// service and the garbage collector. Its handlers are
+	// registered against the default mux, so that they are exposed
+	// by the diagnostics HTTP server.
+	registry, err := blobstore_configuration.NewRegistryFromConfiguration(configuration.Global)
+	if err != nil {
+		log.Fatal("Failed to create storage registry: ", err)
+	}
+	registry.RegisterHTTPHandlers(http.DefaultServeMux)
 	contentAddressableStorage, actionCache, err := blobstore_configuration.NewCASAndACBlobAccessFromConfiguration(
+		registry,
 		configuration.Blobstore,
 		bb_grpc.DefaultClientFactory,
 		int(configuration.MaximumMessageSizeBytes))
@@ -49,6 +80,7 @@ func main() {
 		info, err := blobstore_configuration.NewBlobAccessFromConfiguration(
 			configuration.IndirectContentAddressableStorage,
 			blobstore_configuration.NewICASBlobAccessCreator(
+				registry,
 				bb_grpc.DefaultClientFactory,
 				int(configuration.MaximumMessageSizeBytes)))
 		if err != nil {
@@ -57,6 +89,87 @@ func main() {
 		indirectContentAddressableStorage = info.BlobAccess
 	}
 
+	// Remote Asset API: storage of mappings from URIs and
+	// qualifiers to digests.
+	var assetStore blobstore.BlobAccess
+	if configuration.AssetStore != nil {
+		info, err := blobstore_configuration.NewBlobAccessFromConfiguration(
+			configuration.AssetStore,
+			blobstore_configuration.NewAssetBlobAccessCreator(registry))
+		if err != nil {
+			log.Fatal("Failed to create Asset Store: ", err)
+		}
+		assetStore = info.BlobAccess
+	}
+	var assetFetcherHTTPClient *http.Client
+	var assetFetcherGitArchiver git.Archiver
+	if assetFetcher := configuration.AssetFetcher; assetFetcher != nil {
+		if assetStore == nil {
+			log.Fatal("An Asset Store must be configured to enable the asset fetcher")
+		}
+		if assetFetcher.MaximumSizeBytes <= 0 {
+			log.Fatal("The maximum size of blobs downloaded by the asset fetcher must be positive")
+		}
+		assetFetcherHTTPClient, err = bb_http.NewClientFromConfiguration(assetFetcher.HttpClient)
+		if err != nil {
+			log.Fatal("Failed to create asset fetcher HTTP client: ", err)
+		}
+		if gitConfiguration := assetFetcher.Git; gitConfiguration != nil {
+			gitPath := gitConfiguration.GitPath
+			if gitPath == "" {
+				gitPath = "git"
+			}
+			assetFetcherGitArchiver = git.NewCommandArchiver(gitPath, gitConfiguration.TemporaryDirectory)
+		}
+	}
+
+	// Options for ContentAddressableStorage.GetTree().
+	getTreeConcurrency := 1
+	var treeCache *grpcservers.TreeCache
+	if getTreeConfiguration := configuration.GetTree; getTreeConfiguration != nil {
+		getTreeConcurrency = int(getTreeConfiguration.MaximumConcurrency)
+		if getTreeConfiguration.CacheSize > 0 {
+			if getTreeConfiguration.CacheSizeBytes <= 0 {
+				log.Fatal("GetTree() cache size in bytes must be positive")
+			}
+			evictionSet, err := eviction.NewSetFromConfiguration(getTreeConfiguration.CacheReplacementPolicy)
+			if err != nil {
+				log.Fatal("Failed to create GetTree() cache replacement policy: ", err)
+			}
+			treeCache = grpcservers.NewTreeCache(
+				int(getTreeConfiguration.CacheSize),
+				getTreeConfiguration.CacheSizeBytes,
+				eviction.NewMetricsSet(evictionSet, "TreeCache"))
+		}
+	}
+
+	// Storage of ByteStream uploads that are in progress, so that
+	// they may be resumed.
+	var uploadStore grpcservers.UploadStore
+	if resumableUploadsConfiguration := configuration.ResumableUploads; resumableUploadsConfiguration != nil {
+		directory, err := filesystem.NewLocalDirectory(resumableUploadsConfiguration.DirectoryPath)
+		if err != nil {
+			log.Fatal("Failed to open resumable uploads directory: ", err)
+		}
+		expiration := resumableUploadsConfiguration.Expiration
+		if err := expiration.CheckValid(); err != nil {
+			log.Fatal("Failed to obtain resumable uploads expiration: ", err)
+		}
+		directoryUploadStore := grpcservers.NewDirectoryUploadStore(
+			directory,
+			clock.SystemClock,
+			expiration.AsDuration())
+		go func() {
+			for {
+				if err := directoryUploadStore.RemoveExpiredUploads(); err != nil {
+					util.DefaultErrorLogger.Log(util.StatusWrap(err, "Failed to remove expired uploads"))
+				}
+				time.Sleep(time.Minute)
+			}
+		}()
+		uploadStore = directoryUploadStore
+	}
+
 	// Create a trie for which instance names provide a writable
 	// Action Cache. Use that trie to both limit BlobAccess writes
 	// and determine the value of UpdateEnabled in GetCapabilities()
@@ -87,6 +200,264 @@ func main() {
 		buildQueue,
 		allowActionCacheUpdatesTrie.Contains)
 
+	// The HTTP servers below don't authenticate clients, meaning
+	// that access control can't be applied and audit events can't
+	// capture the client's identity. Refuse to expose storage
+	// through them if either is enabled, as that would bypass them.
+	if (configuration.AccessControl != nil || configuration.AuditLog != nil) &&
+		(configuration.HttpRemoteCache != nil || configuration.SignedUrl != nil || configuration.Archive != nil || configuration.BlobInspection != nil) {
+		log.Fatal("The HTTP remote cache, signed URL, archive and blob inspection servers can't be enabled in combination with access control or audit logging, as they don't authenticate clients")
+	}
+
+	// Buildbarn extension: HTTP server implementing Bazel's HTTP
+	// caching protocol.
+	if httpRemoteCacheConfiguration := configuration.HttpRemoteCache; httpRemoteCacheConfiguration != nil {
+		if httpRemoteCacheConfiguration.SizesCacheSize <= 0 {
+			log.Fatal("HTTP remote cache sizes cache size must be positive")
+		}
+		evictionSet, err := eviction.NewSetFromConfiguration(httpRemoteCacheConfiguration.SizesCacheReplacementPolicy)
+		if err != nil {
+			log.Fatal("Failed to create HTTP remote cache sizes cache replacement policy: ", err)
+		}
+		handler := httpservers.NewRemoteCacheHandler(
+			contentAddressableStorage,
+			actionCache,
+			int(configuration.MaximumMessageSizeBytes),
+			1<<16,
+			int(httpRemoteCacheConfiguration.SizesCacheSize),
+			eviction.NewMetricsSet(evictionSet, "RemoteCacheHandler"))
+		go func() {
+			log.Fatal(
+				"HTTP remote cache server failure: ",
+				http.ListenAndServe(httpRemoteCacheConfiguration.ListenAddress, handler))
+		}()
+	}
+
+	// Buildbarn extension: HTTP server that redirects clients to
+	// signed URLs of objects stored in S3.
+	if signedURLConfiguration := configuration.SignedUrl; signedURLConfiguration != nil {
+		if indirectContentAddressableStorage == nil {
+			log.Fatal("Signed URLs can only be issued if an Indirect Content Addressable Storage is configured")
+		}
+		sess, err := aws.NewSessionFromConfiguration(signedURLConfiguration.AwsSession)
+		if err != nil {
+			log.Fatal("Failed to create AWS session: ", err)
+		}
+		expiration := signedURLConfiguration.Expiration
+		if err := expiration.CheckValid(); err != nil {
+			log.Fatal("Failed to obtain signed URL expiration: ", err)
+		}
+		handler := httpservers.NewSignedURLHandler(
+			indirectContentAddressableStorage,
+			s3.New(sess),
+			expiration.AsDuration(),
+			int(configuration.MaximumMessageSizeBytes))
+		go func() {
+			log.Fatal(
+				"Signed URL server failure: ",
+				http.ListenAndServe(signedURLConfiguration.ListenAddress, handler))
+		}()
+	}
+
+	// Buildbarn extension: HTTP server that streams directory
+	// hierarchies as archives.
+	if archiveConfiguration := configuration.Archive; archiveConfiguration != nil {
+		if archiveConfiguration.MaximumEntries <= 0 {
+			log.Fatal("Maximum number of archive entries must be positive")
+		}
+		if archiveConfiguration.MaximumDepth <= 0 {
+			log.Fatal("Maximum archive depth must be positive")
+		}
+		handler := httpservers.NewArchiveHandler(
+			contentAddressableStorage,
+			int(configuration.MaximumMessageSizeBytes),
+			int(archiveConfiguration.MaximumEntries),
+			int(archiveConfiguration.MaximumDepth))
+		go func() {
+			log.Fatal(
+				"Archive server failure: ",
+				http.ListenAndServe(archiveConfiguration.ListenAddress, handler))
+		}()
+	}
+
+	// Buildbarn extension: HTTP server that renders REv2 messages
+	// stored in the CAS and AC as JSON.
+	if blobInspectionConfiguration := configuration.BlobInspection; blobInspectionConfiguration != nil {
+		handler := httpservers.NewBlobInspectionHandler(
+			contentAddressableStorage,
+			actionCache,
+			int(configuration.MaximumMessageSizeBytes))
+		go func() {
+			log.Fatal(
+				"Blob inspection server failure: ",
+				http.ListenAndServe(blobInspectionConfiguration.ListenAddress, handler))
+		}()
+	}
+
+	// Optional: Reachability-based garbage collection of the Content
+	// Addressable Storage.
+	if garbageCollectionConfiguration := configuration.GarbageCollection; garbageCollectionConfiguration != nil {
+		interval := garbageCollectionConfiguration.Interval
+		if err := interval.CheckValid(); err != nil {
+			log.Fatal("Failed to obtain garbage collection interval: ", err)
+		}
+		garbageCollector, err := garbagecollection.NewGarbageCollectorFromConfiguration(
+			garbageCollectionConfiguration,
+			registry.EnumerateBlobs,
+			registry.ExpireBlobs,
+			contentAddressableStorage,
+			int(configuration.MaximumMessageSizeBytes))
+		if err != nil {
+			log.Fatal("Failed to create garbage collector: ", err)
+		}
+		go func() {
+			for {
+				if stats, err := garbageCollector.Run(context.Background()); err != nil {
+					util.DefaultErrorLogger.Log(util.StatusWrap(err, "Garbage collection failed"))
+				} else {
+					log.Printf("Garbage collection completed: traversed %d action results, retained %d reachable objects, removed %d unreachable objects", stats.ActionResults, stats.ReachableBlobs, stats.ExpiredBlobs)
+				}
+				time.Sleep(interval.AsDuration())
+			}
+		}()
+	}
+
+	// Optional: Graceful draining of the gRPC servers, so that
+	// requests in flight are not aborted during rolling restarts.
+	var drainer *bb_grpc.ServerDrainer
+	if drainConfiguration := configuration.Drain; drainConfiguration != nil {
+		gracePeriod := drainConfiguration.GracePeriod
+		if err := gracePeriod.CheckValid(); err != nil {
+			log.Fatal("Failed to obtain drain grace period: ", err)
+		}
+		drainer = bb_grpc.NewServerDrainer(clock.SystemClock)
+		var drainOnce sync.Once
+		drain := func() {
+			drainOnce.Do(func() {
+				go func() {
+					log.Print("Draining gRPC servers")
+					drainer.Drain(gracePeriod.AsDuration())
+					if err := registry.FlushLocalPersistentState(); err != nil {
+						log.Fatal("Failed to flush local persistent state: ", err)
+					}
+					log.Print("Drained successfully")
+					os.Exit(0)
+				}()
+			})
+		}
+
+		// Registered against the default mux, so that it is
+		// exposed by the diagnostics HTTP server.
+		http.HandleFunc("/-/drain", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			drain()
+			w.WriteHeader(http.StatusAccepted)
+		})
+		if drainConfiguration.DrainOnSigterm {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGTERM)
+			go func() {
+				<-signals
+				drain()
+			}()
+		}
+	}
+
+	// Optional: Let the health checking service of the gRPC servers
+	// reflect the health of the storage backends.
+	var servingStatus *bb_grpc.ServingStatus
+	if healthCheckConfiguration := configuration.BackendHealthCheck; healthCheckConfiguration != nil {
+		interval := healthCheckConfiguration.Interval
+		if err := interval.CheckValid(); err != nil {
+			log.Fatal("Failed to obtain backend health check interval: ", err)
+		}
+		timeout := healthCheckConfiguration.Timeout
+		if err := timeout.CheckValid(); err != nil {
+			log.Fatal("Failed to obtain backend health check timeout: ", err)
+		}
+		instanceName, err := digest.NewInstanceName(healthCheckConfiguration.InstanceName)
+		if err != nil {
+			log.Fatalf("Invalid backend health check instance name %#v: %s", healthCheckConfiguration.InstanceName, err)
+		}
+		digestFunction, err := instanceName.GetDigestFunction(remoteexecution.DigestFunction_SHA256)
+		if err != nil {
+			log.Fatal("Failed to obtain backend health check digest function: ", err)
+		}
+		healthCheckers := []blobstore.HealthChecker{
+			blobstore.NewCASHealthChecker(contentAddressableStorage, digestFunction),
+			blobstore.NewACHealthChecker(actionCache, digestFunction, int(configuration.MaximumMessageSizeBytes)),
+		}
+		servingStatus = bb_grpc.NewServingStatus()
+		go func() {
+			for {
+				ctx, cancel := context.WithTimeout(context.Background(), timeout.AsDuration())
+				healthy := true
+				for _, healthChecker := range healthCheckers {
+					if err := healthChecker.CheckHealth(ctx); err != nil {
+						util.DefaultErrorLogger.Log(util.StatusWrap(err, "Backend health check failed"))
+						healthy = false
+						break
+					}
+				}
+				cancel()
+				servingStatus.SetServing(healthy)
+				time.Sleep(interval.AsDuration())
+			}
+		}()
+	}
+
+	// Optional: Restrict access to storage through the gRPC servers
+	// to identities that are listed for the instance name. This is
+	// applied after creating the health checkers, as these don't
+	// provide a client identity.
+	if accessControlConfiguration := configuration.AccessControl; accessControlConfiguration != nil {
+		authorizers, err := auth.NewAccessControlAuthorizersFromConfiguration(accessControlConfiguration, registry.GetReloadRegistry())
+		if err != nil {
+			log.Fatal("Failed to create access control authorizers: ", err)
+		}
+		contentAddressableStorage = blobstore.NewAuthorizingBlobAccess(
+			contentAddressableStorage,
+			authorizers.Read,
+			authorizers.Write)
+		actionCache = blobstore.NewAuthorizingBlobAccess(
+			actionCache,
+			authorizers.Read,
+			authorizers.ACUpdate)
+		if indirectContentAddressableStorage != nil {
+			indirectContentAddressableStorage = blobstore.NewAuthorizingBlobAccess(
+				indirectContentAddressableStorage,
+				authorizers.Read,
+				authorizers.Write)
+		}
+		if assetStore != nil {
+			assetStore = blobstore.NewAuthorizingBlobAccess(
+				assetStore,
+				authorizers.Read,
+				authorizers.Write)
+		}
+	}
+
+	// Optional: Generate audit events for operations performed
+	// through the gRPC servers. This is applied on top of access
+	// control, so that denied requests are logged as well.
+	if auditLogConfiguration := configuration.AuditLog; auditLogConfiguration != nil {
+		auditLogger, err := auditlog.NewLoggerFromConfiguration(auditLogConfiguration, bb_grpc.DefaultClientFactory)
+		if err != nil {
+			log.Fatal("Failed to create audit logger: ", err)
+		}
+		contentAddressableStorage = blobstore.NewAuditingBlobAccess(contentAddressableStorage, auditLogger, "cas")
+		actionCache = blobstore.NewAuditingBlobAccess(actionCache, auditLogger, "ac")
+		if indirectContentAddressableStorage != nil {
+			indirectContentAddressableStorage = blobstore.NewAuditingBlobAccess(indirectContentAddressableStorage, auditLogger, "icas")
+		}
+		if assetStore != nil {
+			assetStore = blobstore.NewAuditingBlobAccess(assetStore, auditLogger, "asset")
+		}
+	}
+
 	go func() {
 		log.Fatal(
 			"gRPC server failure: ",
@@ -97,17 +468,21 @@ func main() {
 						s,
 						grpcservers.NewActionCacheServer(
 							actionCache,
+							contentAddressableStorage,
 							int(configuration.MaximumMessageSizeBytes)))
 					remoteexecution.RegisterContentAddressableStorageServer(
 						s,
 						grpcservers.NewContentAddressableStorageServer(
 							contentAddressableStorage,
-							configuration.MaximumMessageSizeBytes))
+							configuration.MaximumMessageSizeBytes,
+							getTreeConcurrency,
+							treeCache))
 					bytestream.RegisterByteStreamServer(
 						s,
 						grpcservers.NewByteStreamServer(
 							contentAddressableStorage,
-							1<<16))
+							1<<16,
+							uploadStore))
 					if indirectContentAddressableStorage != nil {
 						icas.RegisterIndirectContentAddressableStorageServer(
 							s,
@@ -115,10 +490,74 @@ func main() {
 								indirectContentAddressableStorage,
 								int(configuration.MaximumMessageSizeBytes)))
 					}
+					if assetStore != nil {
+						assetKeyGenerator := grpcservers.NewAssetKeyGenerator(configuration.AssetKeyQualifierAllowlist)
+						assetFetchServer := grpcservers.NewAssetFetchServer(
+							assetStore,
+							assetKeyGenerator,
+							contentAddressableStorage,
+							clock.SystemClock,
+							int(configuration.MaximumMessageSizeBytes))
+						assetPushServer := grpcservers.NewAssetPushServer(
+							assetStore,
+							assetKeyGenerator,
+							clock.SystemClock)
+						if assetFetcherGitArchiver != nil {
+							assetFetchServer = grpcservers.NewGitFetchingAssetFetchServer(
+								assetFetchServer,
+								assetPushServer,
+								contentAddressableStorage,
+								assetFetcherGitArchiver,
+								configuration.AssetFetcher.MaximumSizeBytes,
+								util.DefaultErrorLogger)
+						}
+						if assetFetcherHTTPClient != nil {
+							assetFetchServer = grpcservers.NewHTTPFetchingAssetFetchServer(
+								assetFetchServer,
+								assetPushServer,
+								contentAddressableStorage,
+								assetFetcherHTTPClient,
+								configuration.AssetFetcher.MaximumSizeBytes,
+								util.DefaultErrorLogger)
+						}
+						remoteasset.RegisterFetchServer(s, assetFetchServer)
+						remoteasset.RegisterPushServer(s, assetPushServer)
+					}
 					remoteexecution.RegisterCapabilitiesServer(s, buildQueue)
 					remoteexecution.RegisterExecutionServer(s, buildQueue)
-				}))
+				},
+				drainer,
+				servingStatus,
+				registry.GetReloadRegistry()))
 	}()
 
+	// Optional: Administrative service for deleting blobs. This is
+	// served separately from the services above, so that it can be
+	// restricted to operators.
+	if len(configuration.AdminGrpcServers) > 0 {
+		go func() {
+			log.Fatal(
+				"Admin gRPC server failure: ",
+				bb_grpc.NewServersFromConfigurationAndServe(
+					configuration.AdminGrpcServers,
+					func(s *grpc.Server) {
+						admin.RegisterAdminServer(
+							s,
+							grpcservers.NewAdminServer(registry.DeleteBlob))
+					},
+					drainer,
+					servingStatus,
+					registry.GetReloadRegistry()))
+		}()
+	}
+
+	// Allow changing parts of the configuration at runtime, such as
+	// authorization policies, rate limits and drained shards, by
+	// reloading the configuration file upon receipt of SIGHUP or a
+	// POST request to /-/reload.
+	reloader := reload.NewConfigurationReloader(os.Args[1], &configuration, registry.GetReloadRegistry())
+	reloader.ReloadOnSIGHUP()
+	http.Handle("/-/reload", reloader)
+
 	lifecycleState.MarkReadyAndWait()
 }
@@ -0,0 +1,89 @@
+package auditlog
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/auditlog"
+	"github.com/buildbarn/bb-storage/pkg/random"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewSinkFromConfiguration creates a Sink based on a configuration
+// file.
+func NewSinkFromConfiguration(configuration *pb.SinkConfiguration, grpcClientFactory bb_grpc.ClientFactory) (Sink, error) {
+	if configuration == nil {
+		return nil, status.Error(codes.InvalidArgument, "No audit log sink configuration provided")
+	}
+	switch kind := configuration.Kind.(type) {
+	case *pb.SinkConfiguration_FilePath:
+		f, err := os.OpenFile(kind.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Failed to open audit log file %#v", kind.FilePath)
+		}
+		return NewFileSink(f), nil
+	case *pb.SinkConfiguration_Grpc:
+		client, err := grpcClientFactory.NewClientFromConfiguration(kind.Grpc)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to create audit log gRPC client")
+		}
+		return NewGRPCSink(client), nil
+	case *pb.SinkConfiguration_KafkaRestProxy:
+		timeout := kind.KafkaRestProxy.Timeout
+		if err := timeout.CheckValid(); err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to obtain Kafka REST Proxy timeout")
+		}
+		return NewKafkaRESTProxySink(
+			&http.Client{Timeout: timeout.AsDuration()},
+			kind.KafkaRestProxy.Url,
+			kind.KafkaRestProxy.Topic), nil
+	default:
+		return nil, status.Error(codes.InvalidArgument, "Configuration did not contain a supported audit log sink type")
+	}
+}
+
+// NewLoggerFromConfiguration creates a Logger based on a configuration
+// file. Audit events are written to the sink by a goroutine that is
+// started by this function.
+func NewLoggerFromConfiguration(configuration *pb.LoggerConfiguration, grpcClientFactory bb_grpc.ClientFactory) (Logger, error) {
+	if configuration.ReadSampleRate < 0 || configuration.ReadSampleRate > 1 {
+		return nil, status.Error(codes.InvalidArgument, "Read sample rate must be in range [0.0, 1.0]")
+	}
+	if configuration.MaximumQueueSize <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "Maximum queue size must be positive")
+	}
+	if configuration.MaximumBatchSize <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "Maximum batch size must be positive")
+	}
+	clientIdentifier, err := bb_grpc.NewClientIdentifierFromConfiguration(configuration.ClientIdentification)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to create client identifier")
+	}
+	sink, err := NewSinkFromConfiguration(configuration.Sink, grpcClientFactory)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := NewQueuedLogger(
+		sink,
+		clientIdentifier,
+		clock.SystemClock,
+		random.FastThreadSafeGenerator,
+		configuration.ReadSampleRate,
+		int(configuration.MaximumQueueSize),
+		int(configuration.MaximumBatchSize))
+	go func() {
+		for {
+			if err := logger.WriteBatch(context.Background()); err != nil {
+				util.DefaultErrorLogger.Log(util.StatusWrap(err, "Failed to write audit events"))
+			}
+		}
+	}()
+	return logger, nil
+}
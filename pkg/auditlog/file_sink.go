@@ -0,0 +1,44 @@
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/proto/auditlog"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+type fileSink struct {
+	w io.Writer
+}
+
+// NewFileSink creates a Sink that writes audit events to a file (or
+// any other io.Writer) as newline delimited JSON. Every batch of
+// events is written using a single call to Write(), so that events
+// are not interleaved with ones written by other processes appending
+// to the same file.
+func NewFileSink(w io.Writer) Sink {
+	return &fileSink{
+		w: w,
+	}
+}
+
+func (s *fileSink) WriteEvents(ctx context.Context, events []*auditlog.AuditEvent) error {
+	var b bytes.Buffer
+	for _, event := range events {
+		data, err := protojson.Marshal(event)
+		if err != nil {
+			return util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal audit event")
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	if _, err := s.w.Write(b.Bytes()); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to write audit events")
+	}
+	return nil
+}
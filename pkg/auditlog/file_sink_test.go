@@ -0,0 +1,47 @@
+package auditlog_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/auditlog"
+	auditlog_pb "github.com/buildbarn/bb-storage/pkg/proto/auditlog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSink(t *testing.T) {
+	var b bytes.Buffer
+	sink := auditlog.NewFileSink(&b)
+
+	require.NoError(t, sink.WriteEvents(context.Background(), []*auditlog_pb.AuditEvent{
+		{
+			ClientIdentity: "alice",
+			StorageType:    "cas",
+			Operation:      "get",
+			InstanceName:   "hello",
+			Digests: []*remoteexecution.Digest{{
+				Hash:      "8b1a9953c4611296a827abf8c47804d7",
+				SizeBytes: 5,
+			}},
+		},
+		{
+			ClientIdentity: "bob",
+			StorageType:    "ac",
+			Operation:      "put",
+		},
+	}))
+
+	// Every event should be written as a single line of JSON.
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	var event map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &event))
+	require.Equal(t, "alice", event["clientIdentity"])
+	require.Equal(t, "hello", event["instanceName"])
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &event))
+	require.Equal(t, "bob", event["clientIdentity"])
+}
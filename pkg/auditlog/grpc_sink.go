@@ -0,0 +1,42 @@
+package auditlog
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/proto/auditlog"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc"
+)
+
+type grpcSink struct {
+	client auditlog.AuditLogClient
+}
+
+// NewGRPCSink creates a Sink that streams audit events to a remote
+// service implementing the AuditLog gRPC service. Every batch of
+// events is sent as a separate call to Record().
+func NewGRPCSink(client grpc.ClientConnInterface) Sink {
+	return &grpcSink{
+		client: auditlog.NewAuditLogClient(client),
+	}
+}
+
+func (s *grpcSink) WriteEvents(ctx context.Context, events []*auditlog.AuditEvent) error {
+	ctxWithCancel, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := s.client.Record(ctxWithCancel)
+	if err != nil {
+		return util.StatusWrap(err, "Failed to start streaming audit events")
+	}
+	for _, event := range events {
+		if err := stream.Send(event); err != nil {
+			// The actual error is returned by CloseAndRecv().
+			break
+		}
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		return util.StatusWrap(err, "Failed to stream audit events")
+	}
+	return nil
+}
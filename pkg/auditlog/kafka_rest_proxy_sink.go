@@ -0,0 +1,77 @@
+package auditlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/buildbarn/bb-storage/pkg/proto/auditlog"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+type kafkaRESTProxyRecord struct {
+	Value json.RawMessage `json:"value"`
+}
+
+type kafkaRESTProxyRequest struct {
+	Records []kafkaRESTProxyRecord `json:"records"`
+}
+
+type kafkaRESTProxySink struct {
+	httpClient *http.Client
+	topicURL   string
+}
+
+// NewKafkaRESTProxySink creates a Sink that publishes audit events to
+// a Kafka topic, using the API provided by the Confluent REST Proxy.
+// Every audit event is stored as a separate record, whose value is
+// the JSON representation of the event.
+func NewKafkaRESTProxySink(httpClient *http.Client, baseURL, topic string) Sink {
+	return &kafkaRESTProxySink{
+		httpClient: httpClient,
+		topicURL:   strings.TrimSuffix(baseURL, "/") + "/topics/" + url.PathEscape(topic),
+	}
+}
+
+func (s *kafkaRESTProxySink) WriteEvents(ctx context.Context, events []*auditlog.AuditEvent) error {
+	request := kafkaRESTProxyRequest{
+		Records: make([]kafkaRESTProxyRecord, 0, len(events)),
+	}
+	for _, event := range events {
+		data, err := protojson.Marshal(event)
+		if err != nil {
+			return util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal audit event")
+		}
+		request.Records = append(request.Records, kafkaRESTProxyRecord{Value: data})
+	}
+	body, err := json.Marshal(&request)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.topicURL, bytes.NewReader(body))
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Unavailable, "Failed to publish audit events")
+	}
+	// Drain the response body, so that the connection can be reused.
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return status.Errorf(codes.Unavailable, "Publishing audit events failed with HTTP status %#v", resp.Status)
+	}
+	return nil
+}
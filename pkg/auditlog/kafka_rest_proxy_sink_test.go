@@ -0,0 +1,63 @@
+package auditlog_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/auditlog"
+	auditlog_pb "github.com/buildbarn/bb-storage/pkg/proto/auditlog"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestKafkaRESTProxySink(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Success", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodPost, r.Method)
+			require.Equal(t, "/topics/buildbarn-audit", r.URL.Path)
+			require.Equal(t, "application/vnd.kafka.json.v2+json", r.Header.Get("Content-Type"))
+
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			var request struct {
+				Records []struct {
+					Value map[string]interface{} `json:"value"`
+				} `json:"records"`
+			}
+			require.NoError(t, json.Unmarshal(body, &request))
+			require.Len(t, request.Records, 2)
+			require.Equal(t, "alice", request.Records[0].Value["clientIdentity"])
+			require.Equal(t, "bob", request.Records[1].Value["clientIdentity"])
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		sink := auditlog.NewKafkaRESTProxySink(server.Client(), server.URL+"/", "buildbarn-audit")
+		require.NoError(t, sink.WriteEvents(ctx, []*auditlog_pb.AuditEvent{
+			{ClientIdentity: "alice"},
+			{ClientIdentity: "bob"},
+		}))
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		sink := auditlog.NewKafkaRESTProxySink(server.Client(), server.URL, "buildbarn-audit")
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.Unavailable, "Publishing audit events failed with HTTP status \"404 Not Found\""),
+			sink.WriteEvents(ctx, []*auditlog_pb.AuditEvent{{ClientIdentity: "alice"}}))
+	})
+}
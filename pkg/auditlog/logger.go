@@ -0,0 +1,131 @@
+package auditlog
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/buildbarn/bb-storage/pkg/proto/auditlog"
+	"github.com/buildbarn/bb-storage/pkg/random"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	loggerPrometheusMetrics sync.Once
+
+	loggerEventsWritten = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "auditlog",
+			Name:      "events_written_total",
+			Help:      "Number of audit events that were written to the sink.",
+		})
+	loggerEventsDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "auditlog",
+			Name:      "events_dropped_total",
+			Help:      "Number of audit events that were dropped, either because the queue was full or because writing to the sink failed.",
+		},
+		[]string{"reason"})
+	loggerEventsDroppedQueueFull   = loggerEventsDropped.WithLabelValues("QueueFull")
+	loggerEventsDroppedWriteFailed = loggerEventsDropped.WithLabelValues("WriteFailed")
+)
+
+// Logger of audit events.
+type Logger interface {
+	// SampleRead returns whether an audit event should be generated
+	// for a read operation. As reads tend to be high volume, only a
+	// fraction of them may be logged.
+	SampleRead() bool
+	// Log an audit event. The timestamp and the identity of the
+	// client are filled in by the Logger, based on the context of
+	// the request.
+	Log(ctx context.Context, event *auditlog.AuditEvent)
+}
+
+// QueuedLogger is an implementation of Logger that queues audit
+// events, so that they can be written to a Sink in batches by a
+// separate goroutine. This ensures that requests are not delayed by
+// slow sinks. Events are discarded if the queue is full.
+type QueuedLogger struct {
+	sink             Sink
+	clientIdentifier bb_grpc.ClientIdentifier
+	clock            clock.Clock
+	generator        random.ThreadSafeGenerator
+	readSampleRate   float64
+	maximumBatchSize int
+
+	events chan *auditlog.AuditEvent
+}
+
+// NewQueuedLogger creates a QueuedLogger. WriteBatch() needs to be
+// called repeatedly to write queued events to the Sink.
+func NewQueuedLogger(sink Sink, clientIdentifier bb_grpc.ClientIdentifier, clock clock.Clock, generator random.ThreadSafeGenerator, readSampleRate float64, maximumQueueSize, maximumBatchSize int) *QueuedLogger {
+	loggerPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(loggerEventsWritten)
+		prometheus.MustRegister(loggerEventsDropped)
+	})
+
+	return &QueuedLogger{
+		sink:             sink,
+		clientIdentifier: clientIdentifier,
+		clock:            clock,
+		generator:        generator,
+		readSampleRate:   readSampleRate,
+		maximumBatchSize: maximumBatchSize,
+
+		events: make(chan *auditlog.AuditEvent, maximumQueueSize),
+	}
+}
+
+// SampleRead returns whether an audit event should be generated for a
+// read operation.
+func (l *QueuedLogger) SampleRead() bool {
+	// Convert the random number to a float in range [0.0, 1.0).
+	return float64(l.generator.Uint64()>>11)/(1<<53) < l.readSampleRate
+}
+
+// Log an audit event by adding it to the queue.
+func (l *QueuedLogger) Log(ctx context.Context, event *auditlog.AuditEvent) {
+	event.Timestamp = timestamppb.New(l.clock.Now())
+	event.ClientIdentity = l.clientIdentifier.IdentifyClient(ctx)
+	select {
+	case l.events <- event:
+	default:
+		loggerEventsDroppedQueueFull.Inc()
+	}
+}
+
+// WriteBatch waits for at least one audit event to be queued, and
+// writes all queued events to the Sink, up to the maximum batch size.
+// Events are discarded if writing them fails.
+func (l *QueuedLogger) WriteBatch(ctx context.Context) error {
+	var events []*auditlog.AuditEvent
+	select {
+	case <-ctx.Done():
+		return util.StatusFromContext(ctx)
+	case event := <-l.events:
+		events = append(events, event)
+	}
+GatherEvents:
+	for len(events) < l.maximumBatchSize {
+		select {
+		case event := <-l.events:
+			events = append(events, event)
+		default:
+			break GatherEvents
+		}
+	}
+
+	if err := l.sink.WriteEvents(ctx, events); err != nil {
+		loggerEventsDroppedWriteFailed.Add(float64(len(events)))
+		return err
+	}
+	loggerEventsWritten.Add(float64(len(events)))
+	return nil
+}
@@ -0,0 +1,105 @@
+package auditlog_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/auditlog"
+	auditlog_pb "github.com/buildbarn/bb-storage/pkg/proto/auditlog"
+	"github.com/buildbarn/bb-storage/pkg/random"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestQueuedLoggerSampleRead(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	sink := mock.NewMockSink(ctrl)
+	clientIdentifier := mock.NewMockClientIdentifier(ctrl)
+	clock := mock.NewMockClock(ctrl)
+
+	t.Run("Never", func(t *testing.T) {
+		logger := auditlog.NewQueuedLogger(sink, clientIdentifier, clock, random.FastThreadSafeGenerator, 0.0, 10, 10)
+		for i := 0; i < 100; i++ {
+			require.False(t, logger.SampleRead())
+		}
+	})
+
+	t.Run("Always", func(t *testing.T) {
+		logger := auditlog.NewQueuedLogger(sink, clientIdentifier, clock, random.FastThreadSafeGenerator, 1.0, 10, 10)
+		for i := 0; i < 100; i++ {
+			require.True(t, logger.SampleRead())
+		}
+	})
+}
+
+func TestQueuedLoggerWriteBatch(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	sink := mock.NewMockSink(ctrl)
+	clientIdentifier := mock.NewMockClientIdentifier(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	logger := auditlog.NewQueuedLogger(sink, clientIdentifier, clock, random.FastThreadSafeGenerator, 1.0, 3, 2)
+
+	// Log more events than fit in the queue. The last one should
+	// be dropped.
+	for i := 0; i < 4; i++ {
+		clock.EXPECT().Now().Return(time.Unix(1000+int64(i), 0))
+		clientIdentifier.EXPECT().IdentifyClient(ctx).Return("alice")
+		logger.Log(ctx, &auditlog_pb.AuditEvent{
+			StorageType:  "ac",
+			Operation:    "put",
+			InstanceName: "hello",
+			Digests: []*remoteexecution.Digest{{
+				Hash:      "8b1a9953c4611296a827abf8c47804d7",
+				SizeBytes: int64(i),
+			}},
+		})
+	}
+
+	newEvent := func(i int64) *auditlog_pb.AuditEvent {
+		return &auditlog_pb.AuditEvent{
+			Timestamp:      &timestamppb.Timestamp{Seconds: 1000 + i},
+			ClientIdentity: "alice",
+			StorageType:    "ac",
+			Operation:      "put",
+			InstanceName:   "hello",
+			Digests: []*remoteexecution.Digest{{
+				Hash:      "8b1a9953c4611296a827abf8c47804d7",
+				SizeBytes: i,
+			}},
+		}
+	}
+
+	// Events should be written in batches of at most two.
+	sink.EXPECT().WriteEvents(ctx, gomock.Len(2)).DoAndReturn(
+		func(ctx context.Context, events []*auditlog_pb.AuditEvent) error {
+			testutil.RequireEqualProto(t, newEvent(0), events[0])
+			testutil.RequireEqualProto(t, newEvent(1), events[1])
+			return nil
+		})
+	require.NoError(t, logger.WriteBatch(ctx))
+
+	// Errors of the sink should be propagated.
+	sink.EXPECT().WriteEvents(ctx, gomock.Len(1)).DoAndReturn(
+		func(ctx context.Context, events []*auditlog_pb.AuditEvent) error {
+			testutil.RequireEqualProto(t, newEvent(2), events[0])
+			return status.Error(codes.Unavailable, "Server offline")
+		})
+	testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Server offline"), logger.WriteBatch(ctx))
+
+	// With the queue being empty, WriteBatch() should block until
+	// the context is canceled.
+	ctxWithCancel, cancel := context.WithCancel(ctx)
+	cancel()
+	testutil.RequireEqualStatus(t, status.Error(codes.Canceled, "context canceled"), logger.WriteBatch(ctxWithCancel))
+}
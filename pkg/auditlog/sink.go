@@ -0,0 +1,13 @@
+package auditlog
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/proto/auditlog"
+)
+
+// Sink is a destination to which audit events are written, such as a
+// file or a remote service.
+type Sink interface {
+	WriteEvents(ctx context.Context, events []*auditlog.AuditEvent) error
+}
@@ -0,0 +1,15 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+// Authorizer determines whether the client that issued a request is
+// permitted to access a given instance name.
+type Authorizer interface {
+	// Authorize returns nil if access is permitted. Otherwise, it
+	// returns an UNAUTHENTICATED or PERMISSION_DENIED error.
+	Authorize(ctx context.Context, instanceName digest.InstanceName) error
+}
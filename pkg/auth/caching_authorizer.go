@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	cachingAuthorizerPrometheusMetrics sync.Once
+
+	cachingAuthorizerLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "auth",
+			Name:      "caching_authorizer_lookups_total",
+			Help:      "Number of authorization decisions that were looked up in the cache.",
+		},
+		[]string{"name", "result"})
+)
+
+type cachedDecision struct {
+	expirationTime time.Time
+	err            error
+}
+
+type cachingAuthorizer struct {
+	base             Authorizer
+	clientIdentifier bb_grpc.ClientIdentifier
+	clock            clock.Clock
+	cacheSize        int
+	cacheDuration    time.Duration
+
+	lock        sync.Mutex
+	decisions   map[string]cachedDecision
+	evictionSet eviction.Set
+
+	lookupsHit  prometheus.Counter
+	lookupsMiss prometheus.Counter
+}
+
+// NewCachingAuthorizer creates a decorator for Authorizer that caches
+// the decisions of the backing Authorizer for a fixed amount of time.
+// Decisions are cached per client identity and instance name. This
+// prevents authorizers that need to be consulted remotely from adding
+// latency to every request.
+//
+// As the cache is keyed by the identity that is returned by the
+// ClientIdentifier, it is important that this identity is derived
+// from credentials that are validated, and that it is the only input
+// the backing Authorizer uses to make decisions.
+//
+// Only decisions to grant access and errors with codes
+// UNAUTHENTICATED and PERMISSION_DENIED are cached. Other errors are
+// assumed to be transient.
+func NewCachingAuthorizer(base Authorizer, clientIdentifier bb_grpc.ClientIdentifier, clock clock.Clock, cacheSize int, cacheDuration time.Duration, evictionSet eviction.Set, name string) Authorizer {
+	cachingAuthorizerPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(cachingAuthorizerLookups)
+	})
+
+	return &cachingAuthorizer{
+		base:             base,
+		clientIdentifier: clientIdentifier,
+		clock:            clock,
+		cacheSize:        cacheSize,
+		cacheDuration:    cacheDuration,
+
+		decisions:   map[string]cachedDecision{},
+		evictionSet: evictionSet,
+
+		lookupsHit:  cachingAuthorizerLookups.WithLabelValues(name, "Hit"),
+		lookupsMiss: cachingAuthorizerLookups.WithLabelValues(name, "Miss"),
+	}
+}
+
+func (a *cachingAuthorizer) Authorize(ctx context.Context, instanceName digest.InstanceName) error {
+	// Prefix the identity with its length, so that keys remain
+	// unambiguous regardless of the characters the identity
+	// contains.
+	identity := a.clientIdentifier.IdentifyClient(ctx)
+	key := strconv.FormatInt(int64(len(identity)), 10) + ":" + identity + instanceName.String()
+
+	a.lock.Lock()
+	if decision, ok := a.decisions[key]; ok && a.clock.Now().Before(decision.expirationTime) {
+		a.evictionSet.Touch(key)
+		a.lock.Unlock()
+		a.lookupsHit.Inc()
+		return decision.err
+	}
+	a.lock.Unlock()
+	a.lookupsMiss.Inc()
+
+	err := a.base.Authorize(ctx, instanceName)
+	if code := status.Code(err); code != codes.OK && code != codes.Unauthenticated && code != codes.PermissionDenied {
+		return err
+	}
+
+	expirationTime := a.clock.Now().Add(a.cacheDuration)
+	a.lock.Lock()
+	if _, ok := a.decisions[key]; !ok {
+		// Free up space to insert the decision.
+		if len(a.decisions) >= a.cacheSize {
+			delete(a.decisions, a.evictionSet.Peek())
+			a.evictionSet.Remove()
+		}
+		a.evictionSet.Insert(key)
+	}
+	a.decisions[key] = cachedDecision{
+		expirationTime: expirationTime,
+		err:            err,
+	}
+	a.lock.Unlock()
+	return err
+}
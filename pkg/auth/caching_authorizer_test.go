@@ -0,0 +1,84 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCachingAuthorizer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseAuthorizer := mock.NewMockAuthorizer(ctrl)
+	clientIdentifier := mock.NewMockClientIdentifier(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	authorizer := auth.NewCachingAuthorizer(baseAuthorizer, clientIdentifier, clock, 2, time.Minute, eviction.NewLRUSet(), "Read")
+
+	instanceName := digest.MustNewInstanceName("hello")
+
+	t.Run("GrantCached", func(t *testing.T) {
+		// The first call should be forwarded.
+		clientIdentifier.EXPECT().IdentifyClient(ctx).Return("alice")
+		baseAuthorizer.EXPECT().Authorize(ctx, instanceName)
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		require.NoError(t, authorizer.Authorize(ctx, instanceName))
+
+		// Successive calls should be served from the cache.
+		clientIdentifier.EXPECT().IdentifyClient(ctx).Return("alice")
+		clock.EXPECT().Now().Return(time.Unix(1059, 0))
+		require.NoError(t, authorizer.Authorize(ctx, instanceName))
+
+		// Until the decision expires.
+		clientIdentifier.EXPECT().IdentifyClient(ctx).Return("alice")
+		clock.EXPECT().Now().Return(time.Unix(1060, 0))
+		baseAuthorizer.EXPECT().Authorize(ctx, instanceName).
+			Return(status.Error(codes.PermissionDenied, "Access revoked"))
+		clock.EXPECT().Now().Return(time.Unix(1060, 0))
+		testutil.RequireEqualStatus(t, status.Error(codes.PermissionDenied, "Access revoked"), authorizer.Authorize(ctx, instanceName))
+
+		// The denial should be cached as well.
+		clientIdentifier.EXPECT().IdentifyClient(ctx).Return("alice")
+		clock.EXPECT().Now().Return(time.Unix(1100, 0))
+		testutil.RequireEqualStatus(t, status.Error(codes.PermissionDenied, "Access revoked"), authorizer.Authorize(ctx, instanceName))
+	})
+
+	t.Run("PerIdentity", func(t *testing.T) {
+		// Decisions for other identities should not be reused.
+		clientIdentifier.EXPECT().IdentifyClient(ctx).Return("bob")
+		baseAuthorizer.EXPECT().Authorize(ctx, instanceName)
+		clock.EXPECT().Now().Return(time.Unix(1100, 0))
+		require.NoError(t, authorizer.Authorize(ctx, instanceName))
+	})
+
+	t.Run("TransientErrorNotCached", func(t *testing.T) {
+		clientIdentifier.EXPECT().IdentifyClient(ctx).Return("carol").Times(2)
+		baseAuthorizer.EXPECT().Authorize(ctx, instanceName).
+			Return(status.Error(codes.Unavailable, "Policy server offline"))
+		testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Policy server offline"), authorizer.Authorize(ctx, instanceName))
+
+		baseAuthorizer.EXPECT().Authorize(ctx, instanceName)
+		clock.EXPECT().Now().Return(time.Unix(1100, 0))
+		require.NoError(t, authorizer.Authorize(ctx, instanceName))
+	})
+
+	t.Run("Eviction", func(t *testing.T) {
+		// The cache has a size of two. Inserting the decision for
+		// "carol" should have caused the one for "alice" to be
+		// evicted.
+		clientIdentifier.EXPECT().IdentifyClient(ctx).Return("alice")
+		baseAuthorizer.EXPECT().Authorize(ctx, instanceName)
+		clock.EXPECT().Now().Return(time.Unix(1100, 0))
+		require.NoError(t, authorizer.Authorize(ctx, instanceName))
+	})
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/buildbarn/bb-storage/pkg/jwt"
+	auth_pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/auth"
+	grpc_pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
+	jwt_pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/jwt"
+	"github.com/buildbarn/bb-storage/pkg/reload"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// NewJWTAuthorizerFromConfiguration creates an Authorizer that matches
+// the claims of JSON Web Tokens against a list of rules, each
+// applying to an instance name prefix.
+func NewJWTAuthorizerFromConfiguration(authorizationHeaderParser *jwt.AuthorizationHeaderParser, rules []*jwt_pb.InstanceNamePrefixAuthorizationRule) (Authorizer, error) {
+	trie := digest.NewInstanceNameTrie()
+	claimsMatchers := make([]*jwt.ClaimsMatcher, 0, len(rules))
+	for _, rule := range rules {
+		instanceNamePrefix, err := digest.NewInstanceName(rule.InstanceNamePrefix)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Invalid instance name prefix %#v", rule.InstanceNamePrefix)
+		}
+		trie.Set(instanceNamePrefix, len(claimsMatchers))
+		claimsMatchers = append(claimsMatchers, jwt.NewClaimsMatcherFromConfiguration(rule.RequiredClaims))
+	}
+	return NewJWTAuthorizer(authorizationHeaderParser, trie, claimsMatchers), nil
+}
+
+// AccessControlAuthorizers contains the Authorizers that are created
+// from an access control configuration. Separate Authorizers are
+// provided for reading, writing to the Content Addressable Storage and
+// updating the Action Cache.
+type AccessControlAuthorizers struct {
+	Read     Authorizer
+	Write    Authorizer
+	ACUpdate Authorizer
+}
+
+func newIdentitySet(identities []string) map[string]struct{} {
+	identitySet := make(map[string]struct{}, len(identities))
+	for _, identity := range identities {
+		identitySet[identity] = struct{}{}
+	}
+	return identitySet
+}
+
+// NewAccessControlAuthorizersFromConfiguration creates Authorizers
+// that grant access based on the identity of the client, using a list
+// of rules that each apply to an instance name prefix.
+//
+// The Authorizers are reconfigured when the configuration of the
+// process is reloaded, by registering them against the provided
+// reload.Registry.
+func NewAccessControlAuthorizersFromConfiguration(configuration *auth_pb.AccessControlConfiguration, reloadRegistry *reload.Registry) (AccessControlAuthorizers, error) {
+	authorizers, err := newAccessControlAuthorizers(configuration)
+	if err != nil {
+		return AccessControlAuthorizers{}, err
+	}
+	read := NewReloadableAuthorizer(authorizers.Read)
+	write := NewReloadableAuthorizer(authorizers.Write)
+	acUpdate := NewReloadableAuthorizer(authorizers.ACUpdate)
+	reloadRegistry.Register(configuration, func(newConfiguration proto.Message) error {
+		authorizers, err := newAccessControlAuthorizers(newConfiguration.(*auth_pb.AccessControlConfiguration))
+		if err != nil {
+			return err
+		}
+		read.SetAuthorizer(authorizers.Read)
+		write.SetAuthorizer(authorizers.Write)
+		acUpdate.SetAuthorizer(authorizers.ACUpdate)
+		return nil
+	})
+	return AccessControlAuthorizers{
+		Read:     read,
+		Write:    write,
+		ACUpdate: acUpdate,
+	}, nil
+}
+
+func newAccessControlAuthorizers(configuration *auth_pb.AccessControlConfiguration) (AccessControlAuthorizers, error) {
+	clientIdentifier, err := bb_grpc.NewClientIdentifierFromConfiguration(configuration.ClientIdentification)
+	if err != nil {
+		return AccessControlAuthorizers{}, util.StatusWrap(err, "Failed to create client identifier")
+	}
+	trie := digest.NewInstanceNameTrie()
+	readIdentities := make([]map[string]struct{}, 0, len(configuration.Rules))
+	writeIdentities := make([]map[string]struct{}, 0, len(configuration.Rules))
+	acUpdateIdentities := make([]map[string]struct{}, 0, len(configuration.Rules))
+	for _, rule := range configuration.Rules {
+		instanceNamePrefix, err := digest.NewInstanceName(rule.InstanceNamePrefix)
+		if err != nil {
+			return AccessControlAuthorizers{}, util.StatusWrapf(err, "Invalid instance name prefix %#v", rule.InstanceNamePrefix)
+		}
+		trie.Set(instanceNamePrefix, len(readIdentities))
+		readIdentities = append(readIdentities, newIdentitySet(rule.ReadIdentities))
+		writeIdentities = append(writeIdentities, newIdentitySet(rule.WriteIdentities))
+		acUpdateIdentities = append(acUpdateIdentities, newIdentitySet(rule.AcUpdateIdentities))
+	}
+	authorizers := AccessControlAuthorizers{
+		Read:     NewIdentityAuthorizer(clientIdentifier, trie, readIdentities),
+		Write:    NewIdentityAuthorizer(clientIdentifier, trie, writeIdentities),
+		ACUpdate: NewIdentityAuthorizer(clientIdentifier, trie, acUpdateIdentities),
+	}
+
+	// Optional: caching of authorization decisions.
+	if cacheConfiguration := configuration.DecisionCache; cacheConfiguration != nil {
+		if authorizers.Read, err = newCachingAuthorizerFromConfiguration(authorizers.Read, clientIdentifier, cacheConfiguration, "Read"); err != nil {
+			return AccessControlAuthorizers{}, err
+		}
+		if authorizers.Write, err = newCachingAuthorizerFromConfiguration(authorizers.Write, clientIdentifier, cacheConfiguration, "Write"); err != nil {
+			return AccessControlAuthorizers{}, err
+		}
+		if authorizers.ACUpdate, err = newCachingAuthorizerFromConfiguration(authorizers.ACUpdate, clientIdentifier, cacheConfiguration, "ACUpdate"); err != nil {
+			return AccessControlAuthorizers{}, err
+		}
+	}
+	return authorizers, nil
+}
+
+// NewIdentityListAuthorizerFromConfiguration creates an Authorizer
+// that grants access to all instance names to a fixed list of
+// identities. If the list of identities is empty, access is denied to
+// all clients, in which case no client identification policy needs to
+// be provided.
+func NewIdentityListAuthorizerFromConfiguration(clientIdentification *grpc_pb.ClientIdentificationPolicy, identities []string) (Authorizer, error) {
+	if len(identities) == 0 {
+		return NewStaticAuthorizer(status.Error(codes.PermissionDenied, "No clients are permitted to perform this operation")), nil
+	}
+	clientIdentifier, err := bb_grpc.NewClientIdentifierFromConfiguration(clientIdentification)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to create client identifier")
+	}
+	trie := digest.NewInstanceNameTrie()
+	trie.Set(digest.EmptyInstanceName, 0)
+	return NewIdentityAuthorizer(clientIdentifier, trie, []map[string]struct{}{newIdentitySet(identities)}), nil
+}
+
+func newCachingAuthorizerFromConfiguration(base Authorizer, clientIdentifier bb_grpc.ClientIdentifier, configuration *auth_pb.AuthorizationDecisionCacheConfiguration, name string) (Authorizer, error) {
+	if configuration.CacheSize <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "Authorization decision cache size must be positive")
+	}
+	cacheDuration := configuration.CacheDuration
+	if err := cacheDuration.CheckValid(); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to obtain authorization decision cache duration")
+	}
+	evictionSet, err := eviction.NewSetFromConfiguration(configuration.CacheReplacementPolicy)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to create authorization decision cache replacement policy")
+	}
+	return NewCachingAuthorizer(
+		base,
+		clientIdentifier,
+		clock.SystemClock,
+		int(configuration.CacheSize),
+		cacheDuration.AsDuration(),
+		eviction.NewMetricsSet(evictionSet, "CachingAuthorizer"+name),
+		name), nil
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// IdentityWildcard can be placed in a list of allowed identities to
+// grant access to all clients, including ones whose identity cannot
+// be determined.
+const IdentityWildcard = "*"
+
+type identityAuthorizer struct {
+	clientIdentifier  bb_grpc.ClientIdentifier
+	rules             *digest.InstanceNameTrie
+	allowedIdentities []map[string]struct{}
+}
+
+// NewIdentityAuthorizer creates an Authorizer that grants access based
+// on the identity of the client, as determined by a ClientIdentifier.
+// The set of identities that is permitted is selected by looking up
+// the longest matching instance name prefix in a trie, whose values
+// are indices into the list of allowed identities. Access to instance
+// names for which no rule exists is denied.
+func NewIdentityAuthorizer(clientIdentifier bb_grpc.ClientIdentifier, rules *digest.InstanceNameTrie, allowedIdentities []map[string]struct{}) Authorizer {
+	return &identityAuthorizer{
+		clientIdentifier:  clientIdentifier,
+		rules:             rules,
+		allowedIdentities: allowedIdentities,
+	}
+}
+
+func (a *identityAuthorizer) Authorize(ctx context.Context, instanceName digest.InstanceName) error {
+	idx := a.rules.Get(instanceName)
+	if idx < 0 {
+		return status.Errorf(codes.PermissionDenied, "No authorization rule exists for instance name %#v", instanceName.String())
+	}
+	allowedIdentities := a.allowedIdentities[idx]
+	if _, ok := allowedIdentities[IdentityWildcard]; ok {
+		return nil
+	}
+
+	identity := a.clientIdentifier.IdentifyClient(ctx)
+	if identity == "" {
+		return status.Error(codes.Unauthenticated, "Failed to determine the identity of the client")
+	}
+	if _, ok := allowedIdentities[identity]; !ok {
+		return status.Errorf(codes.PermissionDenied, "Client %#v is not permitted to access instance name %#v", identity, instanceName.String())
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIdentityAuthorizer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	clientIdentifier := mock.NewMockClientIdentifier(ctrl)
+	rules := digest.NewInstanceNameTrie()
+	rules.Set(digest.MustNewInstanceName("public"), 0)
+	rules.Set(digest.MustNewInstanceName("team"), 1)
+	rules.Set(digest.MustNewInstanceName("team/secret"), 2)
+	authorizer := auth.NewIdentityAuthorizer(
+		clientIdentifier,
+		rules,
+		[]map[string]struct{}{
+			{"*": {}},
+			{"alice": {}, "bob": {}},
+			{"alice": {}},
+		})
+
+	t.Run("NoRule", func(t *testing.T) {
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.PermissionDenied, "No authorization rule exists for instance name \"other\""),
+			authorizer.Authorize(ctx, digest.MustNewInstanceName("other")))
+	})
+
+	t.Run("Wildcard", func(t *testing.T) {
+		// The identity of the client is irrelevant.
+		require.NoError(t, authorizer.Authorize(ctx, digest.MustNewInstanceName("public/project")))
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		clientIdentifier.EXPECT().IdentifyClient(ctx).Return("")
+
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.Unauthenticated, "Failed to determine the identity of the client"),
+			authorizer.Authorize(ctx, digest.MustNewInstanceName("team")))
+	})
+
+	t.Run("Permitted", func(t *testing.T) {
+		clientIdentifier.EXPECT().IdentifyClient(ctx).Return("bob")
+
+		require.NoError(t, authorizer.Authorize(ctx, digest.MustNewInstanceName("team/project")))
+	})
+
+	t.Run("LongestPrefixDenied", func(t *testing.T) {
+		clientIdentifier.EXPECT().IdentifyClient(ctx).Return("bob")
+
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.PermissionDenied, "Client \"bob\" is not permitted to access instance name \"team/secret\""),
+			authorizer.Authorize(ctx, digest.MustNewInstanceName("team/secret")))
+	})
+}
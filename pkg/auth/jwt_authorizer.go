@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/jwt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type jwtAuthorizer struct {
+	authorizationHeaderParser *jwt.AuthorizationHeaderParser
+	rules                     *digest.InstanceNameTrie
+	claimsMatchers            []*jwt.ClaimsMatcher
+}
+
+// NewJWTAuthorizer creates an Authorizer that grants access based on
+// the claims of a JSON Web Token provided in the "Authorization" header
+// of a gRPC request. The ClaimsMatcher that is used is selected by
+// looking up the longest matching instance name prefix in a trie,
+// whose values are indices into the list of ClaimsMatchers. Access to
+// instance names for which no rule exists is denied.
+func NewJWTAuthorizer(authorizationHeaderParser *jwt.AuthorizationHeaderParser, rules *digest.InstanceNameTrie, claimsMatchers []*jwt.ClaimsMatcher) Authorizer {
+	return &jwtAuthorizer{
+		authorizationHeaderParser: authorizationHeaderParser,
+		rules:                     rules,
+		claimsMatchers:            claimsMatchers,
+	}
+}
+
+func (a *jwtAuthorizer) Authorize(ctx context.Context, instanceName digest.InstanceName) error {
+	idx := a.rules.Get(instanceName)
+	if idx < 0 {
+		return status.Errorf(codes.PermissionDenied, "No authorization rule exists for instance name %#v", instanceName.String())
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	headers := md.Get("authorization")
+	if len(headers) == 0 {
+		return status.Error(codes.Unauthenticated, "No authorization header provided")
+	}
+	claims, err := a.authorizationHeaderParser.ParseAuthorizationHeader(headers[0])
+	if err != nil {
+		return err
+	}
+	if !a.claimsMatchers[idx].MatchClaims(claims) {
+		return status.Errorf(codes.PermissionDenied, "Token does not permit access to instance name %#v", instanceName.String())
+	}
+	return nil
+}
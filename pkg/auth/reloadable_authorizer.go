@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+// ReloadableAuthorizer is an Authorizer that forwards requests to
+// another Authorizer, which may be replaced at runtime.
+type ReloadableAuthorizer struct {
+	lock sync.RWMutex
+	base Authorizer
+}
+
+// NewReloadableAuthorizer creates a ReloadableAuthorizer that
+// initially forwards requests to a given Authorizer.
+func NewReloadableAuthorizer(base Authorizer) *ReloadableAuthorizer {
+	return &ReloadableAuthorizer{
+		base: base,
+	}
+}
+
+// Authorize a request by forwarding it to the current Authorizer.
+func (a *ReloadableAuthorizer) Authorize(ctx context.Context, instanceName digest.InstanceName) error {
+	a.lock.RLock()
+	base := a.base
+	a.lock.RUnlock()
+	return base.Authorize(ctx, instanceName)
+}
+
+// SetAuthorizer replaces the Authorizer to which requests are
+// forwarded.
+func (a *ReloadableAuthorizer) SetAuthorizer(base Authorizer) {
+	a.lock.Lock()
+	a.base = base
+	a.lock.Unlock()
+}
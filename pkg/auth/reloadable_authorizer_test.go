@@ -0,0 +1,44 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReloadableAuthorizer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	instanceName := digest.MustNewInstanceName("hello")
+	base1 := mock.NewMockAuthorizer(ctrl)
+	authorizer := auth.NewReloadableAuthorizer(base1)
+
+	t.Run("Initial", func(t *testing.T) {
+		base1.EXPECT().Authorize(ctx, instanceName)
+
+		require.NoError(t, authorizer.Authorize(ctx, instanceName))
+	})
+
+	t.Run("Replaced", func(t *testing.T) {
+		// Once replaced, requests should no longer be forwarded
+		// to the original Authorizer.
+		base2 := mock.NewMockAuthorizer(ctrl)
+		authorizer.SetAuthorizer(base2)
+		base2.EXPECT().Authorize(ctx, instanceName).
+			Return(status.Error(codes.PermissionDenied, "Permission denied"))
+
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.PermissionDenied, "Permission denied"),
+			authorizer.Authorize(ctx, instanceName))
+	})
+}
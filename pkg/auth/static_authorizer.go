@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+type staticAuthorizer struct {
+	err error
+}
+
+// NewStaticAuthorizer creates an Authorizer that returns the same
+// result for every request, regardless of the client and instance
+// name. It can be used to grant or deny access unconditionally.
+func NewStaticAuthorizer(err error) Authorizer {
+	return staticAuthorizer{
+		err: err,
+	}
+}
+
+func (a staticAuthorizer) Authorize(ctx context.Context, instanceName digest.InstanceName) error {
+	return a.err
+}
@@ -0,0 +1,82 @@
+package blobstore
+
+import (
+	"context"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type actionResultExpiringBlobAccess struct {
+	BlobAccess
+	clock                   clock.Clock
+	maximumMessageSizeBytes int
+	maximumAge              time.Duration
+	minimumTimestamp        time.Time
+}
+
+// NewActionResultExpiringBlobAccess creates a decorator for an Action
+// Cache (AC) that only returns ActionResult messages that were created
+// recently enough. Older entries are treated as if they were absent,
+// causing clients to rebuild the action. This makes it possible to
+// phase out results produced by faulty toolchains without wiping the
+// Action Cache entirely.
+//
+// The age of an ActionResult is derived from the worker completed
+// timestamp stored in its execution metadata. ActionResult messages
+// that lack this timestamp (e.g., because they were uploaded by
+// clients through UpdateActionResult()) are returned unconditionally,
+// as there is no way to determine their age.
+//
+// A maximum age of zero disables expiration based on age. A zero
+// minimum timestamp disables expiration based on a fixed point in time.
+func NewActionResultExpiringBlobAccess(base BlobAccess, clock clock.Clock, maximumMessageSizeBytes int, maximumAge time.Duration, minimumTimestamp time.Time) BlobAccess {
+	return &actionResultExpiringBlobAccess{
+		BlobAccess:              base,
+		clock:                   clock,
+		maximumMessageSizeBytes: maximumMessageSizeBytes,
+		maximumAge:              maximumAge,
+		minimumTimestamp:        minimumTimestamp,
+	}
+}
+
+func (ba *actionResultExpiringBlobAccess) checkExpiration(actionResult *remoteexecution.ActionResult) error {
+	workerCompletedTimestamp := actionResult.GetExecutionMetadata().GetWorkerCompletedTimestamp()
+	if workerCompletedTimestamp == nil {
+		return nil
+	}
+	if err := workerCompletedTimestamp.CheckValid(); err != nil {
+		return util.StatusWrapWithCode(err, codes.NotFound, "Action result contains an invalid worker completed timestamp")
+	}
+	workerCompletedTime := workerCompletedTimestamp.AsTime()
+	if workerCompletedTime.Before(ba.minimumTimestamp) {
+		return status.Errorf(codes.NotFound, "Action result was created at %s, which is before the minimum timestamp of %s", workerCompletedTime.UTC().Format(time.RFC3339), ba.minimumTimestamp.UTC().Format(time.RFC3339))
+	}
+	if ba.maximumAge > 0 {
+		if expirationTime := workerCompletedTime.Add(ba.maximumAge); !ba.clock.Now().Before(expirationTime) {
+			return status.Errorf(codes.NotFound, "Action result expired at %s", expirationTime.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+func (ba *actionResultExpiringBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	b1, b2 := ba.BlobAccess.Get(ctx, digest).CloneCopy(ba.maximumMessageSizeBytes)
+	actionResult, err := b1.ToProto(&remoteexecution.ActionResult{}, ba.maximumMessageSizeBytes)
+	if err != nil {
+		b2.Discard()
+		return buffer.NewBufferFromError(err)
+	}
+	if err := ba.checkExpiration(actionResult.(*remoteexecution.ActionResult)); err != nil {
+		b2.Discard()
+		return buffer.NewBufferFromError(err)
+	}
+	return b2
+}
@@ -0,0 +1,93 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestActionResultExpiringBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewActionResultExpiringBlobAccess(
+		baseBlobAccess,
+		clock,
+		1000,
+		7*24*time.Hour,
+		time.Unix(1600000000, 0))
+
+	actionDigest := digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 123)
+
+	t.Run("BackendFailure", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, actionDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Action not found")))
+
+		_, err := blobAccess.Get(ctx, actionDigest).ToProto(&remoteexecution.ActionResult{}, 1000)
+		require.Equal(t, status.Error(codes.NotFound, "Action not found"), err)
+	})
+
+	t.Run("NoExecutionMetadata", func(t *testing.T) {
+		// The age of action results without a worker completed
+		// timestamp cannot be determined. These should be
+		// returned as is.
+		actionResult := remoteexecution.ActionResult{
+			ExitCode: 1,
+		}
+		baseBlobAccess.EXPECT().Get(ctx, actionDigest).Return(buffer.NewProtoBufferFromProto(&actionResult, buffer.UserProvided))
+
+		actualResult, err := blobAccess.Get(ctx, actionDigest).ToProto(&remoteexecution.ActionResult{}, 1000)
+		require.NoError(t, err)
+		testutil.RequireEqualProto(t, &actionResult, actualResult)
+	})
+
+	t.Run("BeforeMinimumTimestamp", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, actionDigest).Return(buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{
+			ExecutionMetadata: &remoteexecution.ExecutedActionMetadata{
+				WorkerCompletedTimestamp: &timestamppb.Timestamp{Seconds: 1599999999},
+			},
+		}, buffer.UserProvided))
+
+		_, err := blobAccess.Get(ctx, actionDigest).ToProto(&remoteexecution.ActionResult{}, 1000)
+		require.Equal(t, status.Error(codes.NotFound, "Action result was created at 2020-09-13T12:26:39Z, which is before the minimum timestamp of 2020-09-13T12:26:40Z"), err)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, actionDigest).Return(buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{
+			ExecutionMetadata: &remoteexecution.ExecutedActionMetadata{
+				WorkerCompletedTimestamp: &timestamppb.Timestamp{Seconds: 1600000000},
+			},
+		}, buffer.UserProvided))
+		clock.EXPECT().Now().Return(time.Unix(1600604800, 0))
+
+		_, err := blobAccess.Get(ctx, actionDigest).ToProto(&remoteexecution.ActionResult{}, 1000)
+		require.Equal(t, status.Error(codes.NotFound, "Action result expired at 2020-09-20T12:26:40Z"), err)
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		actionResult := remoteexecution.ActionResult{
+			ExecutionMetadata: &remoteexecution.ExecutedActionMetadata{
+				WorkerCompletedTimestamp: &timestamppb.Timestamp{Seconds: 1600000000},
+			},
+		}
+		baseBlobAccess.EXPECT().Get(ctx, actionDigest).Return(buffer.NewProtoBufferFromProto(&actionResult, buffer.UserProvided))
+		clock.EXPECT().Now().Return(time.Unix(1600604799, 0))
+
+		actualResult, err := blobAccess.Get(ctx, actionDigest).ToProto(&remoteexecution.ActionResult{}, 1000)
+		require.NoError(t, err)
+		testutil.RequireEqualProto(t, &actionResult, actualResult)
+	})
+}
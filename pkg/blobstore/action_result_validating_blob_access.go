@@ -0,0 +1,176 @@
+package blobstore
+
+import (
+	"context"
+	"strings"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem/path"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type actionResultValidatingBlobAccess struct {
+	BlobAccess
+	maximumMessageSizeBytes int
+	maximumOutputs          int
+	maximumInlineSizeBytes  int64
+	allowNonZeroExitCodes   bool
+}
+
+// NewActionResultValidatingBlobAccess creates a decorator for an
+// Action Cache (AC) that validates ActionResult messages before they
+// are stored. This prevents malformed entries written by faulty clients
+// from being served to other clients later on.
+//
+// Paths of outputs must be well-formed relative paths, and may not be
+// specified more than once. Digests of outputs must be valid, and must
+// be consistent with the size of any data that is inlined. Exit codes
+// must be zero, unless allowNonZeroExitCodes is set. Finally, the
+// number of outputs and the amount of inlined data may not exceed the
+// configured limits. Limits that are zero are not enforced.
+func NewActionResultValidatingBlobAccess(base BlobAccess, maximumMessageSizeBytes, maximumOutputs int, maximumInlineSizeBytes int64, allowNonZeroExitCodes bool) BlobAccess {
+	return &actionResultValidatingBlobAccess{
+		BlobAccess:              base,
+		maximumMessageSizeBytes: maximumMessageSizeBytes,
+		maximumOutputs:          maximumOutputs,
+		maximumInlineSizeBytes:  maximumInlineSizeBytes,
+		allowNonZeroExitCodes:   allowNonZeroExitCodes,
+	}
+}
+
+// actionResultValidator keeps track of the state needed to validate a
+// single ActionResult message.
+type actionResultValidator struct {
+	instanceName    digest.InstanceName
+	paths           map[string]struct{}
+	symlinkTargets  map[string]string
+	inlineSizeBytes int64
+}
+
+func (v *actionResultValidator) addPath(p string, allowEmpty bool) error {
+	if p != "" || !allowEmpty {
+		for _, component := range strings.Split(p, "/") {
+			if _, ok := path.NewComponent(component); !ok {
+				return status.Errorf(codes.InvalidArgument, "Output path %#v is not a well-formed relative path", p)
+			}
+		}
+	}
+	if _, ok := v.paths[p]; ok {
+		return status.Errorf(codes.InvalidArgument, "Output path %#v is specified multiple times", p)
+	}
+	v.paths[p] = struct{}{}
+	return nil
+}
+
+func (v *actionResultValidator) addDigest(blobDigest *remoteexecution.Digest, inlineData []byte) error {
+	if blobDigest != nil {
+		if _, err := v.instanceName.NewDigestFromProto(blobDigest); err != nil {
+			return err
+		}
+		if len(inlineData) > 0 && int64(len(inlineData)) != blobDigest.SizeBytes {
+			return status.Errorf(codes.InvalidArgument, "Inline data is %d bytes in size, while the digest has a size of %d bytes", len(inlineData), blobDigest.SizeBytes)
+		}
+	}
+	v.inlineSizeBytes += int64(len(inlineData))
+	return nil
+}
+
+func (v *actionResultValidator) addSymlink(symlink *remoteexecution.OutputSymlink) error {
+	if symlink.Target == "" {
+		return status.Errorf(codes.InvalidArgument, "Output symbolic link %#v has an empty target", symlink.Path)
+	}
+	// For compatibility with older clients, symbolic links may be
+	// listed both in output_symlinks and in one of the legacy
+	// output_{file,directory}_symlinks fields. Only reject them if
+	// the targets are inconsistent.
+	if target, ok := v.symlinkTargets[symlink.Path]; ok {
+		if target != symlink.Target {
+			return status.Errorf(codes.InvalidArgument, "Output symbolic link %#v is specified multiple times with different targets", symlink.Path)
+		}
+		return nil
+	}
+	if err := v.addPath(symlink.Path, false); err != nil {
+		return err
+	}
+	v.symlinkTargets[symlink.Path] = symlink.Target
+	return nil
+}
+
+func (ba *actionResultValidatingBlobAccess) validate(instanceName digest.InstanceName, actionResult *remoteexecution.ActionResult) error {
+	v := actionResultValidator{
+		instanceName:   instanceName,
+		paths:          map[string]struct{}{},
+		symlinkTargets: map[string]string{},
+	}
+	for _, outputFile := range actionResult.OutputFiles {
+		if err := v.addPath(outputFile.Path, false); err != nil {
+			return err
+		}
+		if outputFile.Digest == nil {
+			return status.Errorf(codes.InvalidArgument, "Output file %#v has no digest", outputFile.Path)
+		}
+		if err := v.addDigest(outputFile.Digest, outputFile.Contents); err != nil {
+			return util.StatusWrapf(err, "Output file %#v", outputFile.Path)
+		}
+	}
+	for _, outputDirectory := range actionResult.OutputDirectories {
+		// Output directories may refer to the working directory
+		// itself, in which case the path is empty.
+		if err := v.addPath(outputDirectory.Path, true); err != nil {
+			return err
+		}
+		if outputDirectory.TreeDigest == nil {
+			return status.Errorf(codes.InvalidArgument, "Output directory %#v has no tree digest", outputDirectory.Path)
+		}
+		if err := v.addDigest(outputDirectory.TreeDigest, nil); err != nil {
+			return util.StatusWrapf(err, "Output directory %#v", outputDirectory.Path)
+		}
+	}
+	for _, symlinks := range [][]*remoteexecution.OutputSymlink{
+		actionResult.OutputSymlinks,
+		actionResult.OutputFileSymlinks,
+		actionResult.OutputDirectorySymlinks,
+	} {
+		for _, symlink := range symlinks {
+			if err := v.addSymlink(symlink); err != nil {
+				return err
+			}
+		}
+	}
+	if err := v.addDigest(actionResult.StdoutDigest, actionResult.StdoutRaw); err != nil {
+		return util.StatusWrap(err, "Standard output")
+	}
+	if err := v.addDigest(actionResult.StderrDigest, actionResult.StderrRaw); err != nil {
+		return util.StatusWrap(err, "Standard error")
+	}
+
+	if !ba.allowNonZeroExitCodes && actionResult.ExitCode != 0 {
+		return status.Errorf(codes.InvalidArgument, "Action terminated with non-zero exit code %d", actionResult.ExitCode)
+	}
+	if ba.maximumOutputs > 0 && len(v.paths) > ba.maximumOutputs {
+		return status.Errorf(codes.InvalidArgument, "Action result contains %d outputs, which exceeds the maximum of %d outputs", len(v.paths), ba.maximumOutputs)
+	}
+	if ba.maximumInlineSizeBytes > 0 && v.inlineSizeBytes > ba.maximumInlineSizeBytes {
+		return status.Errorf(codes.InvalidArgument, "Action result contains %d bytes of inline data, which exceeds the maximum of %d bytes", v.inlineSizeBytes, ba.maximumInlineSizeBytes)
+	}
+	return nil
+}
+
+func (ba *actionResultValidatingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	b1, b2 := b.CloneCopy(ba.maximumMessageSizeBytes)
+	actionResult, err := b1.ToProto(&remoteexecution.ActionResult{}, ba.maximumMessageSizeBytes)
+	if err != nil {
+		b2.Discard()
+		return err
+	}
+	if err := ba.validate(digest.GetInstanceName(), actionResult.(*remoteexecution.ActionResult)); err != nil {
+		b2.Discard()
+		return util.StatusWrap(err, "Invalid action result")
+	}
+	return ba.BlobAccess.Put(ctx, digest, b2)
+}
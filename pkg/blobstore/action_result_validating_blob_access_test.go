@@ -0,0 +1,177 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestActionResultValidatingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewActionResultValidatingBlobAccess(baseBlobAccess, 1000, 3, 10, false)
+
+	actionDigest := digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 123)
+	fileDigest := &remoteexecution.Digest{
+		Hash:      "8b1a9953c4611296a827abf8c47804d7",
+		SizeBytes: 5,
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		// Symbolic links may be listed both in the current and
+		// legacy fields, as long as they are consistent.
+		actionResult := &remoteexecution.ActionResult{
+			OutputFiles: []*remoteexecution.OutputFile{
+				{Path: "bazel-out/foo.o", Digest: fileDigest, Contents: []byte("Hello")},
+			},
+			OutputDirectories: []*remoteexecution.OutputDirectory{
+				{Path: "bazel-out/foo", TreeDigest: fileDigest},
+			},
+			OutputSymlinks: []*remoteexecution.OutputSymlink{
+				{Path: "bazel-out/bar", Target: "foo.o"},
+			},
+			OutputFileSymlinks: []*remoteexecution.OutputSymlink{
+				{Path: "bazel-out/bar", Target: "foo.o"},
+			},
+			StdoutDigest: fileDigest,
+		}
+		baseBlobAccess.EXPECT().Put(ctx, actionDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				m, err := b.ToProto(&remoteexecution.ActionResult{}, 1000)
+				require.NoError(t, err)
+				testutil.RequireEqualProto(t, actionResult, m)
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided)))
+	})
+
+	t.Run("MalformedPath", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Invalid action result: Output path \"bazel-out/../foo.o\" is not a well-formed relative path"),
+			blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{
+				OutputFiles: []*remoteexecution.OutputFile{
+					{Path: "bazel-out/../foo.o", Digest: fileDigest},
+				},
+			}, buffer.UserProvided)))
+	})
+
+	t.Run("AbsolutePath", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Invalid action result: Output path \"/tmp\" is not a well-formed relative path"),
+			blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{
+				OutputDirectories: []*remoteexecution.OutputDirectory{
+					{Path: "/tmp", TreeDigest: fileDigest},
+				},
+			}, buffer.UserProvided)))
+	})
+
+	t.Run("DuplicatePath", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Invalid action result: Output path \"foo\" is specified multiple times"),
+			blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{
+				OutputFiles: []*remoteexecution.OutputFile{
+					{Path: "foo", Digest: fileDigest},
+				},
+				OutputDirectories: []*remoteexecution.OutputDirectory{
+					{Path: "foo", TreeDigest: fileDigest},
+				},
+			}, buffer.UserProvided)))
+	})
+
+	t.Run("InconsistentSymlink", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Invalid action result: Output symbolic link \"foo\" is specified multiple times with different targets"),
+			blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{
+				OutputSymlinks: []*remoteexecution.OutputSymlink{
+					{Path: "foo", Target: "bar"},
+				},
+				OutputDirectorySymlinks: []*remoteexecution.OutputSymlink{
+					{Path: "foo", Target: "baz"},
+				},
+			}, buffer.UserProvided)))
+	})
+
+	t.Run("MissingDigest", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Invalid action result: Output file \"foo\" has no digest"),
+			blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{
+				OutputFiles: []*remoteexecution.OutputFile{
+					{Path: "foo"},
+				},
+			}, buffer.UserProvided)))
+	})
+
+	t.Run("MalformedDigest", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Invalid action result: Standard error: Unknown digest hash length: 24 characters"),
+			blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{
+				StderrDigest: &remoteexecution.Digest{
+					Hash:      "this is a malformed hash",
+					SizeBytes: 12,
+				},
+			}, buffer.UserProvided)))
+	})
+
+	t.Run("InlineSizeMismatch", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Invalid action result: Output file \"foo\": Inline data is 2 bytes in size, while the digest has a size of 5 bytes"),
+			blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{
+				OutputFiles: []*remoteexecution.OutputFile{
+					{Path: "foo", Digest: fileDigest, Contents: []byte("Hi")},
+				},
+			}, buffer.UserProvided)))
+	})
+
+	t.Run("NonZeroExitCode", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Invalid action result: Action terminated with non-zero exit code 1"),
+			blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{
+				ExitCode: 1,
+			}, buffer.UserProvided)))
+	})
+
+	t.Run("TooManyOutputs", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Invalid action result: Action result contains 4 outputs, which exceeds the maximum of 3 outputs"),
+			blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{
+				OutputFiles: []*remoteexecution.OutputFile{
+					{Path: "a", Digest: fileDigest},
+					{Path: "b", Digest: fileDigest},
+					{Path: "c", Digest: fileDigest},
+					{Path: "d", Digest: fileDigest},
+				},
+			}, buffer.UserProvided)))
+	})
+
+	t.Run("TooMuchInlineData", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Invalid action result: Action result contains 11 bytes of inline data, which exceeds the maximum of 10 bytes"),
+			blobAccess.Put(ctx, actionDigest, buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{
+				StdoutRaw: []byte("Hello"),
+				StderrRaw: []byte("world!"),
+			}, buffer.UserProvided)))
+	})
+}
@@ -0,0 +1,109 @@
+package blobstore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+)
+
+// ActiveOperation describes a single operation against a BlobAccess
+// that is currently in progress, as reported by
+// ActiveOperationTracker.GetActiveOperations().
+type ActiveOperation struct {
+	// The name of the operation (i.e., "Get", "Put" or
+	// "FindMissing").
+	Operation string `json:"operation"`
+	// The digest of the blob being accessed. For FindMissing(),
+	// this is the first digest that is part of the request.
+	Digest string `json:"digest"`
+	// For FindMissing(), the number of digests that are part of
+	// the request.
+	DigestsCount int `json:"digests_count,omitempty"`
+	// The path of the backend in the storage configuration.
+	Backend string `json:"backend"`
+	// The correlation ID of the request that caused the operation
+	// to be performed, if any.
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// The time at which the operation started.
+	StartTime time.Time `json:"start_time"`
+	// The amount of time that has elapsed since the operation
+	// started, in seconds.
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+type activeOperation struct {
+	operation     string
+	digest        string
+	digestsCount  int
+	getBackend    func() string
+	correlationID string
+	startTime     time.Time
+}
+
+// ActiveOperationTracker keeps track of operations against BlobAccess
+// objects that are currently in progress. Operations are registered by
+// instances of ActiveOperationTrackingBlobAccess. It can be used to
+// diagnose operations that hang, similar to MySQL's "SHOW PROCESSLIST".
+type ActiveOperationTracker struct {
+	clock clock.Clock
+
+	lock       sync.Mutex
+	nextID     uint64
+	operations map[uint64]*activeOperation
+}
+
+// NewActiveOperationTracker creates an ActiveOperationTracker that
+// initially tracks no operations.
+func NewActiveOperationTracker(clock clock.Clock) *ActiveOperationTracker {
+	return &ActiveOperationTracker{
+		clock:      clock,
+		operations: map[uint64]*activeOperation{},
+	}
+}
+
+func (t *ActiveOperationTracker) start(operation *activeOperation) uint64 {
+	operation.startTime = t.clock.Now()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	id := t.nextID
+	t.nextID++
+	t.operations[id] = operation
+	return id
+}
+
+func (t *ActiveOperationTracker) finish(id uint64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.operations, id)
+}
+
+// GetActiveOperations returns a list of all operations that are
+// currently in progress, sorted by start time.
+func (t *ActiveOperationTracker) GetActiveOperations() []ActiveOperation {
+	t.lock.Lock()
+	operations := make([]*activeOperation, 0, len(t.operations))
+	for _, operation := range t.operations {
+		operations = append(operations, operation)
+	}
+	t.lock.Unlock()
+
+	sort.Slice(operations, func(i, j int) bool {
+		return operations[i].startTime.Before(operations[j].startTime)
+	})
+	now := t.clock.Now()
+	activeOperations := make([]ActiveOperation, 0, len(operations))
+	for _, operation := range operations {
+		activeOperations = append(activeOperations, ActiveOperation{
+			Operation:      operation.operation,
+			Digest:         operation.digest,
+			DigestsCount:   operation.digestsCount,
+			Backend:        operation.getBackend(),
+			CorrelationID:  operation.correlationID,
+			StartTime:      operation.startTime,
+			ElapsedSeconds: now.Sub(operation.startTime).Seconds(),
+		})
+	}
+	return activeOperations
+}
@@ -0,0 +1,81 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/logging"
+)
+
+type activeOperationTrackingBlobAccess struct {
+	BlobAccess
+	tracker    *ActiveOperationTracker
+	getBackend func() string
+}
+
+// NewActiveOperationTrackingBlobAccess creates a decorator for
+// BlobAccess that registers all operations against an
+// ActiveOperationTracker for as long as they are in progress.
+//
+// The path of the backend is obtained by calling getBackend while the
+// list of active operations is requested, as opposed to when this
+// decorator is created. This permits the path to be determined by
+// storage backends that are still in the process of being constructed.
+func NewActiveOperationTrackingBlobAccess(base BlobAccess, tracker *ActiveOperationTracker, getBackend func() string) BlobAccess {
+	return &activeOperationTrackingBlobAccess{
+		BlobAccess: base,
+		tracker:    tracker,
+		getBackend: getBackend,
+	}
+}
+
+func (ba *activeOperationTrackingBlobAccess) start(ctx context.Context, operation string, blobDigest digest.Digest, digestsCount int) uint64 {
+	return ba.tracker.start(&activeOperation{
+		operation:     operation,
+		digest:        blobDigest.String(),
+		digestsCount:  digestsCount,
+		getBackend:    ba.getBackend,
+		correlationID: logging.GetCorrelationIDFromContext(ctx),
+	})
+}
+
+func (ba *activeOperationTrackingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	// Reading the buffer is considered to be part of the operation.
+	id := ba.start(ctx, "Get", blobDigest, 0)
+	return buffer.WithErrorHandler(
+		ba.BlobAccess.Get(ctx, blobDigest),
+		activeOperationTrackingErrorHandler{
+			tracker: ba.tracker,
+			id:      id,
+		})
+}
+
+func (ba *activeOperationTrackingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	id := ba.start(ctx, "Put", blobDigest, 0)
+	defer ba.tracker.finish(id)
+	return ba.BlobAccess.Put(ctx, blobDigest, b)
+}
+
+func (ba *activeOperationTrackingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	if digests.Empty() {
+		return digest.EmptySet, nil
+	}
+	firstDigest, _ := digests.First()
+	id := ba.start(ctx, "FindMissing", firstDigest, digests.Length())
+	defer ba.tracker.finish(id)
+	return ba.BlobAccess.FindMissing(ctx, digests)
+}
+
+type activeOperationTrackingErrorHandler struct {
+	tracker *ActiveOperationTracker
+	id      uint64
+}
+
+func (eh activeOperationTrackingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	return nil, err
+}
+
+func (eh activeOperationTrackingErrorHandler) Done() {
+	eh.tracker.finish(eh.id)
+}
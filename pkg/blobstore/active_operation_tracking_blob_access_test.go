@@ -0,0 +1,84 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/logging"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActiveOperationTrackingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	tracker := blobstore.NewActiveOperationTracker(clock)
+	blobAccess := blobstore.NewActiveOperationTrackingBlobAccess(baseBlobAccess, tracker, func() string {
+		return "cas_mirrored/cas_local[0]"
+	})
+
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Get", func(t *testing.T) {
+		// The operation should be listed until the buffer
+		// returned by Get() has been consumed.
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		baseBlobAccess.EXPECT().Get(gomock.Any(), blobDigest).
+			Return(buffer.NewCASBufferFromReader(blobDigest, ioutil.NopCloser(bytes.NewBufferString("Hello")), buffer.UserProvided))
+		b := blobAccess.Get(logging.NewContextWithCorrelationID(ctx, "e6f5c4b1"), blobDigest)
+
+		clock.EXPECT().Now().Return(time.Unix(1002, 500000000))
+		require.Equal(t, []blobstore.ActiveOperation{
+			{
+				Operation:      "Get",
+				Digest:         "8b1a9953c4611296a827abf8c47804d7-5-hello",
+				Backend:        "cas_mirrored/cas_local[0]",
+				CorrelationID:  "e6f5c4b1",
+				StartTime:      time.Unix(1000, 0),
+				ElapsedSeconds: 2.5,
+			},
+		}, tracker.GetActiveOperations())
+
+		data, err := b.ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+
+		clock.EXPECT().Now().Return(time.Unix(1003, 0))
+		require.Empty(t, tracker.GetActiveOperations())
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		// The operation should be listed while the backend is
+		// processing the request.
+		digests := digest.NewSetBuilder().
+			Add(blobDigest).
+			Add(digest.MustNewDigest("hello", "6fc422233a40a75a1f028e11c3cd1140", 7)).
+			Build()
+		clock.EXPECT().Now().Return(time.Unix(1010, 0))
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) (digest.Set, error) {
+				clock.EXPECT().Now().Return(time.Unix(1011, 0))
+				activeOperations := tracker.GetActiveOperations()
+				require.Len(t, activeOperations, 1)
+				require.Equal(t, "FindMissing", activeOperations[0].Operation)
+				require.Equal(t, 2, activeOperations[0].DigestsCount)
+				return digest.EmptySet, nil
+			})
+
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+
+		clock.EXPECT().Now().Return(time.Unix(1012, 0))
+		require.Empty(t, tracker.GetActiveOperations())
+	})
+}
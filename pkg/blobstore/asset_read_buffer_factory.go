@@ -0,0 +1,28 @@
+package blobstore
+
+import (
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/asset"
+)
+
+type assetReadBufferFactory struct{}
+
+func (f assetReadBufferFactory) NewBufferFromByteSlice(digest digest.Digest, data []byte, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	return buffer.NewProtoBufferFromByteSlice(&asset.Asset{}, data, buffer.BackendProvided(dataIntegrityCallback))
+}
+
+func (f assetReadBufferFactory) NewBufferFromReader(digest digest.Digest, r io.ReadCloser, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	return buffer.NewProtoBufferFromReader(&asset.Asset{}, r, buffer.BackendProvided(dataIntegrityCallback))
+}
+
+func (f assetReadBufferFactory) NewBufferFromReaderAt(digest digest.Digest, r buffer.ReadAtCloser, sizeBytes int64, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	return f.NewBufferFromReader(digest, newReaderFromReaderAt(r), dataIntegrityCallback)
+}
+
+// AssetReadBufferFactory is capable of creating identifiers and buffers
+// for objects stored in the Asset Store, which holds the mappings of
+// assets pushed through the Remote Asset API.
+var AssetReadBufferFactory ReadBufferFactory = assetReadBufferFactory{}
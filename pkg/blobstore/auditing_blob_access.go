@@ -0,0 +1,97 @@
+package blobstore
+
+import (
+	"context"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/auditlog"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	auditlog_pb "github.com/buildbarn/bb-storage/pkg/proto/auditlog"
+
+	"google.golang.org/grpc/status"
+)
+
+type auditingBlobAccess struct {
+	base            BlobAccess
+	logger          auditlog.Logger
+	storageTypeName string
+}
+
+// NewAuditingBlobAccess creates a decorator for BlobAccess that
+// generates audit events for operations performed against storage,
+// capturing which client accessed which objects. Write operations are
+// always logged, while only a sample of read operations is logged.
+func NewAuditingBlobAccess(base BlobAccess, logger auditlog.Logger, storageTypeName string) BlobAccess {
+	return &auditingBlobAccess{
+		base:            base,
+		logger:          logger,
+		storageTypeName: storageTypeName,
+	}
+}
+
+func (ba *auditingBlobAccess) newEvent(operation string, instanceName digest.InstanceName, digests []*remoteexecution.Digest, err error) *auditlog_pb.AuditEvent {
+	return &auditlog_pb.AuditEvent{
+		StorageType:  ba.storageTypeName,
+		Operation:    operation,
+		InstanceName: instanceName.String(),
+		Digests:      digests,
+		Status:       status.Convert(err).Proto(),
+	}
+}
+
+func (ba *auditingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	b := ba.base.Get(ctx, digest)
+	if !ba.logger.SampleRead() {
+		return b
+	}
+	// Only log the event once the buffer has been consumed, so that
+	// the outcome of the operation is known.
+	return buffer.WithErrorHandler(b, &auditingErrorHandler{
+		blobAccess: ba,
+		ctx:        ctx,
+		digest:     digest,
+	})
+}
+
+func (ba *auditingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	err := ba.base.Put(ctx, digest, b)
+	ba.logger.Log(ctx, ba.newEvent("put", digest.GetInstanceName(), []*remoteexecution.Digest{digest.GetProto()}, err))
+	return err
+}
+
+func (ba *auditingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	missing, err := ba.base.FindMissing(ctx, digests)
+	if ba.logger.SampleRead() {
+		// Generate a separate event for every instance name.
+		var instanceNames []digest.InstanceName
+		digestsPerInstanceName := map[digest.InstanceName][]*remoteexecution.Digest{}
+		for _, blobDigest := range digests.Items() {
+			instanceName := blobDigest.GetInstanceName()
+			if _, ok := digestsPerInstanceName[instanceName]; !ok {
+				instanceNames = append(instanceNames, instanceName)
+			}
+			digestsPerInstanceName[instanceName] = append(digestsPerInstanceName[instanceName], blobDigest.GetProto())
+		}
+		for _, instanceName := range instanceNames {
+			ba.logger.Log(ctx, ba.newEvent("find_missing", instanceName, digestsPerInstanceName[instanceName], err))
+		}
+	}
+	return missing, err
+}
+
+type auditingErrorHandler struct {
+	blobAccess *auditingBlobAccess
+	ctx        context.Context
+	digest     digest.Digest
+	err        error
+}
+
+func (eh *auditingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	eh.err = err
+	return nil, err
+}
+
+func (eh *auditingErrorHandler) Done() {
+	eh.blobAccess.logger.Log(eh.ctx, eh.blobAccess.newEvent("get", eh.digest.GetInstanceName(), []*remoteexecution.Digest{eh.digest.GetProto()}, eh.err))
+}
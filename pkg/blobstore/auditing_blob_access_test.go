@@ -0,0 +1,145 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/auditlog"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
+)
+
+func TestAuditingBlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	logger := mock.NewMockLogger(ctrl)
+	blobAccess := blobstore.NewAuditingBlobAccess(baseBlobAccess, logger, "cas")
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("NotSampled", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		logger.EXPECT().SampleRead().Return(false)
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		logger.EXPECT().SampleRead().Return(true)
+		logger.EXPECT().Log(ctx, testutil.EqProto(t, &auditlog.AuditEvent{
+			StorageType:  "cas",
+			Operation:    "get",
+			InstanceName: "hello",
+			Digests: []*remoteexecution.Digest{{
+				Hash:      "8b1a9953c4611296a827abf8c47804d7",
+				SizeBytes: 5,
+			}},
+		}))
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewBufferFromError(grpc_status.Error(codes.NotFound, "Object not found")))
+		logger.EXPECT().SampleRead().Return(true)
+		logger.EXPECT().Log(ctx, testutil.EqProto(t, &auditlog.AuditEvent{
+			StorageType:  "cas",
+			Operation:    "get",
+			InstanceName: "hello",
+			Digests: []*remoteexecution.Digest{{
+				Hash:      "8b1a9953c4611296a827abf8c47804d7",
+				SizeBytes: 5,
+			}},
+			Status: &status.Status{
+				Code:    int32(codes.NotFound),
+				Message: "Object not found",
+			},
+		}))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		testutil.RequireEqualStatus(t, grpc_status.Error(codes.NotFound, "Object not found"), err)
+	})
+}
+
+func TestAuditingBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	logger := mock.NewMockLogger(ctrl)
+	blobAccess := blobstore.NewAuditingBlobAccess(baseBlobAccess, logger, "ac")
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	// Writes should always be logged, regardless of sampling.
+	baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			b.Discard()
+			return grpc_status.Error(codes.PermissionDenied, "Access denied")
+		})
+	logger.EXPECT().Log(ctx, testutil.EqProto(t, &auditlog.AuditEvent{
+		StorageType:  "ac",
+		Operation:    "put",
+		InstanceName: "hello",
+		Digests: []*remoteexecution.Digest{{
+			Hash:      "8b1a9953c4611296a827abf8c47804d7",
+			SizeBytes: 5,
+		}},
+		Status: &status.Status{
+			Code:    int32(codes.PermissionDenied),
+			Message: "Access denied",
+		},
+	}))
+
+	testutil.RequireEqualStatus(
+		t,
+		grpc_status.Error(codes.PermissionDenied, "Access denied"),
+		blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+}
+
+func TestAuditingBlobAccessFindMissing(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	logger := mock.NewMockLogger(ctrl)
+	blobAccess := blobstore.NewAuditingBlobAccess(baseBlobAccess, logger, "cas")
+	digests := digest.NewSetBuilder().
+		Add(digest.MustNewDigest("a", "8b1a9953c4611296a827abf8c47804d7", 5)).
+		Add(digest.MustNewDigest("b", "8b1a9953c4611296a827abf8c47804d7", 5)).
+		Build()
+
+	// A separate event should be generated for every instance name.
+	baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+	logger.EXPECT().SampleRead().Return(true)
+	for _, instanceName := range []string{"a", "b"} {
+		logger.EXPECT().Log(ctx, testutil.EqProto(t, &auditlog.AuditEvent{
+			StorageType:  "cas",
+			Operation:    "find_missing",
+			InstanceName: instanceName,
+			Digests: []*remoteexecution.Digest{{
+				Hash:      "8b1a9953c4611296a827abf8c47804d7",
+				SizeBytes: 5,
+			}},
+		}))
+	}
+
+	missing, err := blobAccess.FindMissing(ctx, digests)
+	require.NoError(t, err)
+	require.Equal(t, digest.EmptySet, missing)
+}
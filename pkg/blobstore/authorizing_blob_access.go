@@ -0,0 +1,59 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+type authorizingBlobAccess struct {
+	base            BlobAccess
+	readAuthorizer  auth.Authorizer
+	writeAuthorizer auth.Authorizer
+}
+
+// NewAuthorizingBlobAccess creates a decorator for BlobAccess that
+// checks whether the client is permitted to access the instance name
+// of a request prior to forwarding it. Get() and FindMissing() are
+// checked against the read authorizer, while Put() is checked against
+// the write authorizer.
+func NewAuthorizingBlobAccess(base BlobAccess, readAuthorizer, writeAuthorizer auth.Authorizer) BlobAccess {
+	return &authorizingBlobAccess{
+		base:            base,
+		readAuthorizer:  readAuthorizer,
+		writeAuthorizer: writeAuthorizer,
+	}
+}
+
+func (ba *authorizingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	if err := ba.readAuthorizer.Authorize(ctx, digest.GetInstanceName()); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return ba.base.Get(ctx, digest)
+}
+
+func (ba *authorizingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if err := ba.writeAuthorizer.Authorize(ctx, digest.GetInstanceName()); err != nil {
+		b.Discard()
+		return err
+	}
+	return ba.base.Put(ctx, digest, b)
+}
+
+func (ba *authorizingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Requests tend to use a single instance name. Only authorize
+	// every instance name once.
+	authorized := map[digest.InstanceName]struct{}{}
+	for _, blobDigest := range digests.Items() {
+		instanceName := blobDigest.GetInstanceName()
+		if _, ok := authorized[instanceName]; !ok {
+			if err := ba.readAuthorizer.Authorize(ctx, instanceName); err != nil {
+				return digest.EmptySet, err
+			}
+			authorized[instanceName] = struct{}{}
+		}
+	}
+	return ba.base.FindMissing(ctx, digests)
+}
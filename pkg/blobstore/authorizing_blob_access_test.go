@@ -0,0 +1,114 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthorizingBlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	readAuthorizer := mock.NewMockAuthorizer(ctrl)
+	writeAuthorizer := mock.NewMockAuthorizer(ctrl)
+	blobAccess := blobstore.NewAuthorizingBlobAccess(baseBlobAccess, readAuthorizer, writeAuthorizer)
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Denied", func(t *testing.T) {
+		readAuthorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("hello")).
+			Return(status.Error(codes.PermissionDenied, "Token does not permit access to instance name \"hello\""))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		testutil.RequireEqualStatus(t, status.Error(codes.PermissionDenied, "Token does not permit access to instance name \"hello\""), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		readAuthorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("hello"))
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+}
+
+func TestAuthorizingBlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	readAuthorizer := mock.NewMockAuthorizer(ctrl)
+	writeAuthorizer := mock.NewMockAuthorizer(ctrl)
+	blobAccess := blobstore.NewAuthorizingBlobAccess(baseBlobAccess, readAuthorizer, writeAuthorizer)
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Denied", func(t *testing.T) {
+		writeAuthorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("hello")).
+			Return(status.Error(codes.Unauthenticated, "No authorization header provided"))
+		r := mock.NewMockReadCloser(ctrl)
+		r.EXPECT().Close()
+
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.Unauthenticated, "No authorization header provided"),
+			blobAccess.Put(ctx, blobDigest, buffer.NewCASBufferFromReader(blobDigest, r, buffer.UserProvided)))
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		writeAuthorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("hello"))
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+}
+
+func TestAuthorizingBlobAccessFindMissing(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	readAuthorizer := mock.NewMockAuthorizer(ctrl)
+	writeAuthorizer := mock.NewMockAuthorizer(ctrl)
+	blobAccess := blobstore.NewAuthorizingBlobAccess(baseBlobAccess, readAuthorizer, writeAuthorizer)
+	digests := digest.NewSetBuilder().
+		Add(digest.MustNewDigest("a", "8b1a9953c4611296a827abf8c47804d7", 5)).
+		Add(digest.MustNewDigest("a", "6fc422233a40a75a1f028e11c3cd1140", 7)).
+		Add(digest.MustNewDigest("b", "8b1a9953c4611296a827abf8c47804d7", 5)).
+		Build()
+
+	t.Run("Denied", func(t *testing.T) {
+		readAuthorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("a"))
+		readAuthorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("b")).
+			Return(status.Error(codes.PermissionDenied, "No authorization rule exists for instance name \"b\""))
+
+		_, err := blobAccess.FindMissing(ctx, digests)
+		testutil.RequireEqualStatus(t, status.Error(codes.PermissionDenied, "No authorization rule exists for instance name \"b\""), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Every instance name should only be authorized once.
+		readAuthorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("a"))
+		readAuthorizer.EXPECT().Authorize(ctx, digest.MustNewInstanceName("b"))
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+}
@@ -0,0 +1,17 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+// BlobDeleter is implemented by backends that are capable of removing
+// individual objects. It is used by the administrative gRPC service to
+// purge blobs that must no longer be served (e.g., due to legal
+// requests or leaked secrets), without wiping all of storage.
+type BlobDeleter interface {
+	// Delete an object from the backend. Deleting objects that are
+	// absent is not an error.
+	Delete(ctx context.Context, digest digest.Digest) error
+}
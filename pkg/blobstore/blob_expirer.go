@@ -0,0 +1,21 @@
+package blobstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+// BlobExpirer is implemented by backends that are capable of removing
+// objects in bulk, based on their age. It is used by reachability-based
+// garbage collection to remove objects that are no longer referenced,
+// as an alternative to relying on the backend's own eviction policy.
+type BlobExpirer interface {
+	// ExpireBlobs removes all objects from the backend that were
+	// last modified before a given point in time, and for which
+	// the provided function returns false. Objects whose keys
+	// cannot be converted to digests are left untouched. The
+	// number of objects removed is returned.
+	ExpireBlobs(ctx context.Context, modifiedBefore time.Time, isRetained func(blobDigest digest.Digest) bool) (int64, error)
+}
@@ -0,0 +1,235 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	bloomFilterBlobAccessPrometheusMetrics sync.Once
+
+	bloomFilterBlobAccessFindMissingDigestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "bloom_filter_blob_access_find_missing_digests_total",
+			Help:      "Number of digests provided to FindMissing(), and whether the Bloom filter could determine they were missing.",
+		},
+		[]string{"name", "result"})
+	bloomFilterBlobAccessRebuildsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "bloom_filter_blob_access_rebuilds_total",
+			Help:      "Number of times the Bloom filter was rebuilt by scanning the backend.",
+		},
+		[]string{"name", "result"})
+	bloomFilterBlobAccessKeys = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "bloom_filter_blob_access_keys",
+			Help:      "Number of keys inserted into the Bloom filter that is currently in use.",
+		},
+		[]string{"name"})
+)
+
+// bloomFilter is a simple Bloom filter of keys. Bit positions are
+// derived from the SHA-256 hash of the key using double hashing.
+type bloomFilter struct {
+	bits          []uint64
+	sizeBits      uint64
+	hashFunctions int
+	keys          int
+}
+
+func newBloomFilter(sizeBits uint64, hashFunctions int) *bloomFilter {
+	return &bloomFilter{
+		bits:          make([]uint64, (sizeBits+63)/64),
+		sizeBits:      sizeBits,
+		hashFunctions: hashFunctions,
+	}
+}
+
+func (bf *bloomFilter) getHashes(key string) (uint64, uint64) {
+	h := sha256.Sum256([]byte(key))
+	return binary.LittleEndian.Uint64(h[:8]), binary.LittleEndian.Uint64(h[8:16]) | 1
+}
+
+func (bf *bloomFilter) add(key string) {
+	h1, h2 := bf.getHashes(key)
+	for i := 0; i < bf.hashFunctions; i++ {
+		bit := (h1 + uint64(i)*h2) % bf.sizeBits
+		bf.bits[bit/64] |= 1 << (bit % 64)
+	}
+	bf.keys++
+}
+
+func (bf *bloomFilter) mayContain(key string) bool {
+	h1, h2 := bf.getHashes(key)
+	for i := 0; i < bf.hashFunctions; i++ {
+		bit := (h1 + uint64(i)*h2) % bf.sizeBits
+		if bf.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// BloomFilterBlobAccess is a BlobAccess that keeps track of the objects
+// stored in the backend using a Bloom filter. The Bloom filter needs to
+// be (re)built periodically by calling Rebuild().
+type BloomFilterBlobAccess interface {
+	BlobAccess
+
+	// Rebuild the Bloom filter by enumerating all objects stored in
+	// the backend. Objects written through this decorator while the
+	// rebuild is in progress are retained.
+	Rebuild(ctx context.Context) error
+}
+
+type bloomFilterBlobAccess struct {
+	BlobAccess
+	keyEnumerator   KeyEnumerator
+	digestKeyFormat digest.KeyFormat
+	sizeBits        uint64
+	hashFunctions   int
+
+	lock    sync.RWMutex
+	current *bloomFilter
+	pending *bloomFilter
+
+	findMissingDigestsDefinitelyMissing prometheus.Counter
+	findMissingDigestsMaybePresent      prometheus.Counter
+	findMissingDigestsNotBuilt          prometheus.Counter
+	rebuildsSucceeded                   prometheus.Counter
+	rebuildsFailed                      prometheus.Counter
+	keys                                prometheus.Gauge
+}
+
+// NewBloomFilterBlobAccess creates a decorator for BlobAccess that is
+// capable of answering FindMissing() calls for objects that are
+// definitely absent without contacting the backend. This is useful for
+// workloads where most FindMissing() calls are for objects that have
+// just been created, and thus have not been uploaded yet.
+//
+// The Bloom filter is populated by enumerating all objects in the
+// backend, and by observing Put() calls. Objects written to the
+// backend through other paths are only picked up by the next rebuild.
+// Until then, they may be reported as missing, causing clients to
+// upload them redundantly. Until the first rebuild completes, all calls
+// are forwarded to the backend.
+func NewBloomFilterBlobAccess(base BlobAccess, keyEnumerator KeyEnumerator, digestKeyFormat digest.KeyFormat, sizeBits uint64, hashFunctions int, name string) BloomFilterBlobAccess {
+	bloomFilterBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(bloomFilterBlobAccessFindMissingDigestsTotal)
+		prometheus.MustRegister(bloomFilterBlobAccessRebuildsTotal)
+		prometheus.MustRegister(bloomFilterBlobAccessKeys)
+	})
+
+	return &bloomFilterBlobAccess{
+		BlobAccess:      base,
+		keyEnumerator:   keyEnumerator,
+		digestKeyFormat: digestKeyFormat,
+		sizeBits:        sizeBits,
+		hashFunctions:   hashFunctions,
+
+		findMissingDigestsDefinitelyMissing: bloomFilterBlobAccessFindMissingDigestsTotal.WithLabelValues(name, "DefinitelyMissing"),
+		findMissingDigestsMaybePresent:      bloomFilterBlobAccessFindMissingDigestsTotal.WithLabelValues(name, "MaybePresent"),
+		findMissingDigestsNotBuilt:          bloomFilterBlobAccessFindMissingDigestsTotal.WithLabelValues(name, "NotBuilt"),
+		rebuildsSucceeded:                   bloomFilterBlobAccessRebuildsTotal.WithLabelValues(name, "Succeeded"),
+		rebuildsFailed:                      bloomFilterBlobAccessRebuildsTotal.WithLabelValues(name, "Failed"),
+		keys:                                bloomFilterBlobAccessKeys.WithLabelValues(name),
+	}
+}
+
+func (ba *bloomFilterBlobAccess) Rebuild(ctx context.Context) error {
+	// Install a new Bloom filter that receives keys of objects
+	// written while the backend is being enumerated.
+	filter := newBloomFilter(ba.sizeBits, ba.hashFunctions)
+	ba.lock.Lock()
+	if ba.pending != nil {
+		ba.lock.Unlock()
+		return status.Error(codes.FailedPrecondition, "Bloom filter is already being rebuilt")
+	}
+	ba.pending = filter
+	ba.lock.Unlock()
+
+	err := ba.keyEnumerator.EnumerateKeys(ctx, func(key string) error {
+		ba.lock.Lock()
+		filter.add(key)
+		ba.lock.Unlock()
+		return nil
+	})
+
+	ba.lock.Lock()
+	ba.pending = nil
+	if err != nil {
+		ba.lock.Unlock()
+		ba.rebuildsFailed.Inc()
+		return util.StatusWrap(err, "Failed to enumerate objects in backend")
+	}
+	ba.current = filter
+	keys := filter.keys
+	ba.lock.Unlock()
+
+	ba.rebuildsSucceeded.Inc()
+	ba.keys.Set(float64(keys))
+	return nil
+}
+
+func (ba *bloomFilterBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	if err := ba.BlobAccess.Put(ctx, blobDigest, b); err != nil {
+		return err
+	}
+
+	// Only insert the key after the write has completed, as a
+	// concurrent rebuild may otherwise miss it.
+	key := blobDigest.GetKey(ba.digestKeyFormat)
+	ba.lock.Lock()
+	if ba.current != nil {
+		ba.current.add(key)
+	}
+	if ba.pending != nil {
+		ba.pending.add(key)
+	}
+	ba.lock.Unlock()
+	return nil
+}
+
+func (ba *bloomFilterBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	ba.lock.RLock()
+	if ba.current == nil {
+		ba.lock.RUnlock()
+		ba.findMissingDigestsNotBuilt.Add(float64(digests.Length()))
+		return ba.BlobAccess.FindMissing(ctx, digests)
+	}
+	definitelyMissing := digest.NewSetBuilder()
+	maybePresent := digest.NewSetBuilder()
+	for _, blobDigest := range digests.Items() {
+		if ba.current.mayContain(blobDigest.GetKey(ba.digestKeyFormat)) {
+			maybePresent.Add(blobDigest)
+		} else {
+			definitelyMissing.Add(blobDigest)
+		}
+	}
+	ba.lock.RUnlock()
+	ba.findMissingDigestsDefinitelyMissing.Add(float64(definitelyMissing.Length()))
+	ba.findMissingDigestsMaybePresent.Add(float64(maybePresent.Length()))
+
+	// Only query the backend for digests that may be present.
+	missing, err := ba.BlobAccess.FindMissing(ctx, maybePresent.Build())
+	if err != nil {
+		return digest.EmptySet, err
+	}
+	return digest.GetUnion([]digest.Set{missing, definitelyMissing.Build()}), nil
+}
@@ -0,0 +1,99 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBloomFilterBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	keyEnumerator := mock.NewMockKeyEnumerator(ctrl)
+	blobAccess := blobstore.NewBloomFilterBlobAccess(baseBlobAccess, keyEnumerator, digest.KeyWithoutInstance, 1024, 7, "cas")
+
+	digest1 := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digest2 := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	digest3 := digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 0)
+	allDigests := digest.NewSetBuilder().Add(digest1).Add(digest2).Add(digest3).Build()
+
+	t.Run("NotBuilt", func(t *testing.T) {
+		// Before the Bloom filter has been built, all calls
+		// should be forwarded to the backend.
+		baseBlobAccess.EXPECT().FindMissing(ctx, allDigests).Return(digest3.ToSingletonSet(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, allDigests)
+		require.NoError(t, err)
+		require.Equal(t, digest3.ToSingletonSet(), missing)
+	})
+
+	t.Run("RebuildFailure", func(t *testing.T) {
+		keyEnumerator.EXPECT().EnumerateKeys(ctx, gomock.Any()).
+			Return(status.Error(codes.Internal, "Failed to read directory: I/O error"))
+
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.Internal, "Failed to enumerate objects in backend: Failed to read directory: I/O error"),
+			blobAccess.Rebuild(ctx))
+	})
+
+	t.Run("RebuildSuccess", func(t *testing.T) {
+		// Objects written while the rebuild is in progress
+		// should also be added to the Bloom filter.
+		keyEnumerator.EXPECT().EnumerateKeys(ctx, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, f func(key string) error) error {
+				baseBlobAccess.EXPECT().Put(ctx, digest2, gomock.Any()).
+					DoAndReturn(func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+						b.Discard()
+						return nil
+					})
+				require.NoError(t, blobAccess.Put(ctx, digest2, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+
+				return f(digest1.GetKey(digest.KeyWithoutInstance))
+			})
+		require.NoError(t, blobAccess.Rebuild(ctx))
+
+		// Only objects that may be present should be looked up
+		// in the backend.
+		maybePresent := digest.NewSetBuilder().Add(digest1).Add(digest2).Build()
+		baseBlobAccess.EXPECT().FindMissing(ctx, maybePresent).Return(digest2.ToSingletonSet(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, allDigests)
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(digest2).Add(digest3).Build(), missing)
+	})
+
+	t.Run("PutAfterRebuild", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Put(ctx, digest3, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		require.NoError(t, blobAccess.Put(ctx, digest3, buffer.NewValidatedBufferFromByteSlice(nil)))
+
+		baseBlobAccess.EXPECT().FindMissing(ctx, allDigests).Return(digest.EmptySet, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, allDigests)
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+
+	t.Run("FindMissingFailure", func(t *testing.T) {
+		baseBlobAccess.EXPECT().FindMissing(ctx, digest1.ToSingletonSet()).
+			Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+
+		_, err := blobAccess.FindMissing(ctx, digest1.ToSingletonSet())
+		testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Server offline"), err)
+	})
+}
@@ -6,6 +6,8 @@ import (
 
 	"github.com/buildbarn/bb-storage/pkg/digest"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -13,30 +15,49 @@ const (
 	defaultChunkSizeBytes = 64 * 1024
 )
 
+// casClonedBufferResult is handed out to consumers of casClonedBuffer
+// that are waiting for the last consumer to arrive. Consumers either
+// receive a ChunkReader that is shared with all other consumers, or
+// the full contents of the buffer as a byte slice.
+type casClonedBufferResult struct {
+	chunkReader ChunkReader
+	data        []byte
+	err         error
+}
+
 type casClonedBuffer struct {
 	base   Buffer
 	digest digest.Digest
 	source Source
 
-	lock                  sync.Mutex
-	consumersRemaining    uint
-	consumersWaiting      []chan ChunkReader
-	needsValidation       bool
-	maximumChunkSizeBytes int
+	lock                      sync.Mutex
+	consumersRemaining        uint
+	consumersWaiting          []chan casClonedBufferResult
+	needsValidation           bool
+	maximumChunkSizeBytes     int
+	onlyByteSliceConsumers    bool
+	maximumByteSliceSizeBytes int
 }
 
 // newCASClonedBuffer creates a decorator for CAS-backed buffer objects
 // that permits concurrent access to the same buffer. All consumers will
 // be synchronized, meaning that they will get access to the buffer's
 // contents at the same pace.
+//
+// In case all consumers request the contents of the buffer as a byte
+// slice, the data is read and validated once, and the resulting byte
+// slice is shared between all consumers. This prevents the data from
+// being buffered multiple times when it is written into multiple
+// storage backends (e.g., by MirroredBlobAccess).
 func newCASClonedBuffer(base Buffer, digest digest.Digest, source Source) Buffer {
 	return &casClonedBuffer{
 		base:   base,
 		digest: digest,
 		source: source,
 
-		consumersRemaining:    1,
-		maximumChunkSizeBytes: -1,
+		consumersRemaining:     1,
+		maximumChunkSizeBytes:  -1,
+		onlyByteSliceConsumers: true,
 	}
 }
 
@@ -44,7 +65,10 @@ func (b *casClonedBuffer) GetSizeBytes() (int64, error) {
 	return b.digest.GetSizeBytes(), nil
 }
 
-func (b *casClonedBuffer) toChunkReader(needsValidation bool, maximumChunkSizeBytes int) ChunkReader {
+// consume registers the calling consumer, providing the constraints
+// that it desires. Once all consumers have registered, either a shared
+// ChunkReader or a shared byte slice is handed out to all of them.
+func (b *casClonedBuffer) consume(needsValidation bool, maximumChunkSizeBytes int, byteSliceMaximumSizeBytes int, wantsByteSlice bool) casClonedBufferResult {
 	b.lock.Lock()
 	if b.consumersRemaining == 0 {
 		panic("Attempted to obtain a chunk reader for a buffer that is already fully consumed")
@@ -56,37 +80,57 @@ func (b *casClonedBuffer) toChunkReader(needsValidation bool, maximumChunkSizeBy
 	if b.maximumChunkSizeBytes < 0 || b.maximumChunkSizeBytes > maximumChunkSizeBytes {
 		b.maximumChunkSizeBytes = maximumChunkSizeBytes
 	}
+	if wantsByteSlice {
+		if b.maximumByteSliceSizeBytes < byteSliceMaximumSizeBytes {
+			b.maximumByteSliceSizeBytes = byteSliceMaximumSizeBytes
+		}
+	} else {
+		b.onlyByteSliceConsumers = false
+	}
 
-	// Create the underlying ChunkReader in case all consumers have
-	// supplied their constraints.
+	// Create the underlying ChunkReader or byte slice in case all
+	// consumers have supplied their constraints.
 	if b.consumersRemaining == 0 {
-		// If there is at least one consumer that needs checksum
-		// validation, we use checksum validation for everyone.
-		var r ChunkReader
-		if b.needsValidation {
-			r = b.base.ToChunkReader(0, b.maximumChunkSizeBytes)
+		var result casClonedBufferResult
+		if b.onlyByteSliceConsumers {
+			// All consumers want a byte slice. Read and
+			// validate the data once, and let all consumers
+			// share the same copy.
+			result.data, result.err = b.base.ToByteSlice(b.maximumByteSliceSizeBytes)
 		} else {
-			r = b.base.toUnvalidatedChunkReader(0, b.maximumChunkSizeBytes)
+			// If there is at least one consumer that needs
+			// checksum validation, we use checksum
+			// validation for everyone.
+			var r ChunkReader
+			if b.needsValidation {
+				r = b.base.ToChunkReader(0, b.maximumChunkSizeBytes)
+			} else {
+				r = b.base.toUnvalidatedChunkReader(0, b.maximumChunkSizeBytes)
+			}
+			result.chunkReader = newMultiplexedChunkReader(r, len(b.consumersWaiting))
 		}
 
-		// Give all consumers their own ChunkReader.
-		rMultiplexed := newMultiplexedChunkReader(r, len(b.consumersWaiting))
+		// Give all consumers their own results.
 		for _, c := range b.consumersWaiting {
-			c <- rMultiplexed
+			c <- result
 		}
 		b.lock.Unlock()
-		return rMultiplexed
+		return result
 	}
 
 	// There are other consumers that still have to supply their
 	// constraints. Let the last consumer create the ChunkReader and
 	// hand it out.
-	c := make(chan ChunkReader, 1)
+	c := make(chan casClonedBufferResult, 1)
 	b.consumersWaiting = append(b.consumersWaiting, c)
 	b.lock.Unlock()
 	return <-c
 }
 
+func (b *casClonedBuffer) toChunkReader(needsValidation bool, maximumChunkSizeBytes int) ChunkReader {
+	return b.consume(needsValidation, maximumChunkSizeBytes, 0, false).chunkReader
+}
+
 func (b *casClonedBuffer) IntoWriter(w io.Writer) error {
 	return intoWriterViaChunkReader(b.toChunkReader(true, defaultChunkSizeBytes), w)
 }
@@ -100,7 +144,21 @@ func (b *casClonedBuffer) ToProto(m proto.Message, maximumSizeBytes int) (proto.
 }
 
 func (b *casClonedBuffer) ToByteSlice(maximumSizeBytes int) ([]byte, error) {
-	return toByteSliceViaChunkReader(b.toChunkReader(true, defaultChunkSizeBytes), b.digest, maximumSizeBytes)
+	result := b.consume(true, defaultChunkSizeBytes, maximumSizeBytes, true)
+	if result.chunkReader != nil {
+		// At least one of the other consumers requested a
+		// ChunkReader, meaning we need to gather the data
+		// ourselves.
+		return toByteSliceViaChunkReader(result.chunkReader, b.digest, maximumSizeBytes)
+	}
+
+	// Data was gathered on our behalf. Still apply the size limit
+	// that was provided by this consumer, as it may be lower than
+	// the one that was used to obtain the data.
+	if expectedSizeBytes := b.digest.GetSizeBytes(); expectedSizeBytes > int64(maximumSizeBytes) {
+		return nil, status.Errorf(codes.InvalidArgument, "Buffer is %d bytes in size, while a maximum of %d bytes is permitted", expectedSizeBytes, maximumSizeBytes)
+	}
+	return result.data, result.err
 }
 
 func (b *casClonedBuffer) ToChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
@@ -748,6 +748,118 @@ func TestNewCASBufferFromChunkReaderCloneStream(t *testing.T) {
 	})
 }
 
+func TestNewCASBufferFromChunkReaderCloneStreamSharedByteSlice(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	helloDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Shared", func(t *testing.T) {
+		// When all consumers request a byte slice, the data
+		// should only be gathered once. Both consumers should
+		// receive the same copy.
+		chunkReader := mock.NewMockChunkReader(ctrl)
+		chunkReader.EXPECT().Read().Return([]byte("Hello"), nil)
+		chunkReader.EXPECT().Read().Return(nil, io.EOF)
+		chunkReader.EXPECT().Close()
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		b1, b2 := buffer.NewCASBufferFromChunkReader(
+			helloDigest,
+			chunkReader,
+			buffer.BackendProvided(dataIntegrityCallback.Call)).CloneStream()
+		results := make(chan []byte, 2)
+
+		go func() {
+			data, err := b1.ToByteSlice(10)
+			require.NoError(t, err)
+			results <- data
+		}()
+
+		go func() {
+			data, err := b2.ToByteSlice(5)
+			require.NoError(t, err)
+			results <- data
+		}()
+
+		data1, data2 := <-results, <-results
+		require.Equal(t, []byte("Hello"), data1)
+		require.Equal(t, []byte("Hello"), data2)
+		require.Equal(t, &data1[0], &data2[0])
+	})
+
+	t.Run("SizeLimitPerConsumer", func(t *testing.T) {
+		// Size limits should be applied for every consumer
+		// individually, even if the data is gathered once.
+		chunkReader := mock.NewMockChunkReader(ctrl)
+		chunkReader.EXPECT().Read().Return([]byte("Hello"), nil)
+		chunkReader.EXPECT().Read().Return(nil, io.EOF)
+		chunkReader.EXPECT().Close()
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		b1, b2 := buffer.NewCASBufferFromChunkReader(
+			helloDigest,
+			chunkReader,
+			buffer.BackendProvided(dataIntegrityCallback.Call)).CloneStream()
+		done := make(chan struct{}, 2)
+
+		go func() {
+			data, err := b1.ToByteSlice(10)
+			require.NoError(t, err)
+			require.Equal(t, []byte("Hello"), data)
+			done <- struct{}{}
+		}()
+
+		go func() {
+			_, err := b2.ToByteSlice(4)
+			require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is 5 bytes in size, while a maximum of 4 bytes is permitted"), err)
+			done <- struct{}{}
+		}()
+
+		<-done
+		<-done
+	})
+
+	t.Run("MixedConsumers", func(t *testing.T) {
+		// If one of the consumers requests a ChunkReader, the
+		// data must be streamed to all consumers.
+		chunkReader := mock.NewMockChunkReader(ctrl)
+		chunkReader.EXPECT().Read().Return([]byte("Hello"), nil)
+		chunkReader.EXPECT().Read().Return(nil, io.EOF)
+		chunkReader.EXPECT().Close()
+		dataIntegrityCallback := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback.EXPECT().Call(true)
+
+		b1, b2 := buffer.NewCASBufferFromChunkReader(
+			helloDigest,
+			chunkReader,
+			buffer.BackendProvided(dataIntegrityCallback.Call)).CloneStream()
+		done := make(chan struct{}, 2)
+
+		go func() {
+			data, err := b1.ToByteSlice(10)
+			require.NoError(t, err)
+			require.Equal(t, []byte("Hello"), data)
+			done <- struct{}{}
+		}()
+
+		go func() {
+			r := b2.ToChunkReader(0, 10)
+			chunk, err := r.Read()
+			require.NoError(t, err)
+			require.Equal(t, []byte("Hello"), chunk)
+			_, err = r.Read()
+			require.Equal(t, io.EOF, err)
+			r.Close()
+			done <- struct{}{}
+		}()
+
+		<-done
+		<-done
+	})
+}
+
 func TestNewCASBufferFromChunkReaderDiscard(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
@@ -0,0 +1,139 @@
+package buffer_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewValidatedBufferFromReleasableByteSliceIntoWriter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	releaser := mock.NewMockByteSliceReleaser(ctrl)
+	releaser.EXPECT().Call(false)
+	writer := bytes.NewBuffer(nil)
+
+	err := buffer.NewValidatedBufferFromReleasableByteSlice([]byte("Hello"), releaser.Call).IntoWriter(writer)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), writer.Bytes())
+}
+
+func TestNewValidatedBufferFromReleasableByteSliceReadAt(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	releaser := mock.NewMockByteSliceReleaser(ctrl)
+	releaser.EXPECT().Call(false)
+
+	var p [3]byte
+	n, err := buffer.NewValidatedBufferFromReleasableByteSlice([]byte("Hello"), releaser.Call).ReadAt(p[:], 2)
+	require.Equal(t, 3, n)
+	require.NoError(t, err)
+	require.Equal(t, []byte("llo"), p[:])
+}
+
+func TestNewValidatedBufferFromReleasableByteSliceToProto(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	t.Run("Success", func(t *testing.T) {
+		// Unmarshaling copies the data, meaning the underlying
+		// memory may be reused.
+		releaser := mock.NewMockByteSliceReleaser(ctrl)
+		releaser.EXPECT().Call(false)
+
+		actionResult, err := buffer.NewValidatedBufferFromReleasableByteSlice(exampleActionResultBytes, releaser.Call).
+			ToProto(&remoteexecution.ActionResult{}, 10000)
+		require.NoError(t, err)
+		testutil.RequireEqualProto(t, &exampleActionResultMessage, actionResult)
+	})
+
+	t.Run("TooBig", func(t *testing.T) {
+		releaser := mock.NewMockByteSliceReleaser(ctrl)
+		releaser.EXPECT().Call(false)
+
+		_, err := buffer.NewValidatedBufferFromReleasableByteSlice(exampleActionResultBytes, releaser.Call).
+			ToProto(&remoteexecution.ActionResult{}, 10)
+		testutil.RequireEqualStatus(t, status.Errorf(codes.InvalidArgument, "Buffer is %d bytes in size, while a maximum of 10 bytes is permitted", len(exampleActionResultBytes)), err)
+	})
+}
+
+func TestNewValidatedBufferFromReleasableByteSliceToByteSlice(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	t.Run("Success", func(t *testing.T) {
+		// The caller obtains a reference to the underlying
+		// memory, meaning it may not be reused.
+		releaser := mock.NewMockByteSliceReleaser(ctrl)
+		releaser.EXPECT().Call(true)
+
+		data, err := buffer.NewValidatedBufferFromReleasableByteSlice([]byte("Hello"), releaser.Call).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("TooBig", func(t *testing.T) {
+		releaser := mock.NewMockByteSliceReleaser(ctrl)
+		releaser.EXPECT().Call(false)
+
+		_, err := buffer.NewValidatedBufferFromReleasableByteSlice([]byte("Hello"), releaser.Call).ToByteSlice(4)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is 5 bytes in size, while a maximum of 4 bytes is permitted"), err)
+	})
+}
+
+func TestNewValidatedBufferFromReleasableByteSliceToChunkReader(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	t.Run("Success", func(t *testing.T) {
+		releaser := mock.NewMockByteSliceReleaser(ctrl)
+		r := buffer.NewValidatedBufferFromReleasableByteSlice([]byte("Hello"), releaser.Call).ToChunkReader(1, 2)
+
+		chunk, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte("el"), chunk)
+		chunk, err = r.Read()
+		require.NoError(t, err)
+		require.Equal(t, []byte("lo"), chunk)
+		_, err = r.Read()
+		require.Equal(t, io.EOF, err)
+
+		// The memory should only be released upon closure.
+		releaser.EXPECT().Call(false)
+		r.Close()
+	})
+
+	t.Run("InvalidOffset", func(t *testing.T) {
+		releaser := mock.NewMockByteSliceReleaser(ctrl)
+		releaser.EXPECT().Call(false)
+
+		r := buffer.NewValidatedBufferFromReleasableByteSlice([]byte("Hello"), releaser.Call).ToChunkReader(6, 2)
+		_, err := r.Read()
+		require.Equal(t, status.Error(codes.InvalidArgument, "Buffer is 5 bytes in size, while a read at offset 6 was requested"), err)
+		r.Close()
+	})
+}
+
+func TestNewValidatedBufferFromReleasableByteSliceCloneStream(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// The releaser should only be called after both consumers are
+	// done. As one of them obtained a byte slice, the memory has
+	// escaped.
+	releaser := mock.NewMockByteSliceReleaser(ctrl)
+	b1, b2 := buffer.NewValidatedBufferFromReleasableByteSlice([]byte("Hello"), releaser.Call).CloneStream()
+
+	data, err := b1.ToByteSlice(10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+
+	releaser.EXPECT().Call(true)
+	b2.Discard()
+}
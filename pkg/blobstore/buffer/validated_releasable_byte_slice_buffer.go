@@ -0,0 +1,150 @@
+package buffer
+
+import (
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/atomic"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// ByteSliceReleaser is a callback that is invoked by buffers created
+// through NewValidatedBufferFromReleasableByteSlice() once all
+// consumers of the buffer are done accessing its contents.
+//
+// If escaped is true, a reference to the underlying byte slice was
+// handed out to one of the consumers (e.g., by calling ToByteSlice()).
+// In that case the byte slice may remain in use indefinitely, meaning
+// that the memory it references may not be reused.
+type ByteSliceReleaser func(escaped bool)
+
+type validatedReleasableByteSliceBuffer struct {
+	data       []byte
+	releaser   ByteSliceReleaser
+	cloneCount atomic.Int32
+	escaped    atomic.Uint32
+}
+
+// NewValidatedBufferFromReleasableByteSlice creates a Buffer that is
+// backed by a slice of bytes, similar to
+// NewValidatedBufferFromByteSlice(). The difference is that a callback
+// is invoked once the buffer is consumed. This permits the owner of
+// the memory to reuse it, as long as no references to it have
+// escaped.
+//
+// ChunkReaders returned by this buffer hand out slices that refer to
+// the underlying memory directly. These slices may only be used until
+// the next call to Read() or Close().
+func NewValidatedBufferFromReleasableByteSlice(data []byte, releaser ByteSliceReleaser) Buffer {
+	return &validatedReleasableByteSliceBuffer{
+		data:     data,
+		releaser: releaser,
+	}
+}
+
+func (b *validatedReleasableByteSliceBuffer) GetSizeBytes() (int64, error) {
+	return int64(len(b.data)), nil
+}
+
+func (b *validatedReleasableByteSliceBuffer) IntoWriter(w io.Writer) error {
+	// io.Writer implementations may not retain the slice, meaning
+	// that the data does not escape.
+	defer b.Discard()
+	_, err := w.Write(b.data)
+	return err
+}
+
+func (b *validatedReleasableByteSliceBuffer) ReadAt(p []byte, off int64) (int, error) {
+	defer b.Discard()
+	return NewValidatedBufferFromByteSlice(b.data).ReadAt(p, off)
+}
+
+func (b *validatedReleasableByteSliceBuffer) ToProto(m proto.Message, maximumSizeBytes int) (proto.Message, error) {
+	defer b.Discard()
+	if len(b.data) > maximumSizeBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "Buffer is %d bytes in size, while a maximum of %d bytes is permitted", len(b.data), maximumSizeBytes)
+	}
+	// Unmarshaling copies all fields out of the input, meaning
+	// that the data does not escape.
+	if err := proto.Unmarshal(b.data, m); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to unmarshal message")
+	}
+	return m, nil
+}
+
+func (b *validatedReleasableByteSliceBuffer) ToByteSlice(maximumSizeBytes int) ([]byte, error) {
+	if len(b.data) > maximumSizeBytes {
+		b.Discard()
+		return nil, status.Errorf(codes.InvalidArgument, "Buffer is %d bytes in size, while a maximum of %d bytes is permitted", len(b.data), maximumSizeBytes)
+	}
+	// The caller obtains a reference to the underlying data that
+	// may be retained indefinitely.
+	b.escaped.Store(1)
+	data := b.data
+	b.Discard()
+	return data, nil
+}
+
+func (b *validatedReleasableByteSliceBuffer) ToChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
+	return b.toUnvalidatedChunkReader(off, maximumChunkSizeBytes)
+}
+
+func (b *validatedReleasableByteSliceBuffer) ToReader() io.ReadCloser {
+	return b.toUnvalidatedReader(0)
+}
+
+func (b *validatedReleasableByteSliceBuffer) CloneCopy(maximumSizeBytes int) (Buffer, Buffer) {
+	b.cloneCount.Add(1)
+	return b, b
+}
+
+func (b *validatedReleasableByteSliceBuffer) CloneStream() (Buffer, Buffer) {
+	b.cloneCount.Add(1)
+	return b, b
+}
+
+func (b *validatedReleasableByteSliceBuffer) Discard() {
+	if b.cloneCount.Add(-1) < 0 {
+		// There are no more cloned instances of this buffer.
+		b.releaser(b.escaped.Load() != 0)
+		b.releaser = nil
+	}
+}
+
+func (b *validatedReleasableByteSliceBuffer) applyErrorHandler(errorHandler ErrorHandler) (Buffer, bool) {
+	// The buffer is in a known good state. Terminate the error
+	// handler directly. There is no need to return a wrapped buffer.
+	errorHandler.Done()
+	return b, false
+}
+
+func (b *validatedReleasableByteSliceBuffer) toUnvalidatedChunkReader(off int64, maximumChunkSizeBytes int) ChunkReader {
+	if err := validateReaderOffset(int64(len(b.data)), off); err != nil {
+		b.Discard()
+		return newErrorChunkReader(err)
+	}
+	return &releasableByteSliceChunkReader{
+		byteSliceChunkReader: byteSliceChunkReader{
+			maximumChunkSizeBytes: maximumChunkSizeBytes,
+			data:                  b.data[off:],
+		},
+		b: b,
+	}
+}
+
+func (b *validatedReleasableByteSliceBuffer) toUnvalidatedReader(off int64) io.ReadCloser {
+	return newChunkReaderBackedReader(b.toUnvalidatedChunkReader(off, defaultChunkSizeBytes))
+}
+
+type releasableByteSliceChunkReader struct {
+	byteSliceChunkReader
+	b *validatedReleasableByteSliceBuffer
+}
+
+func (r *releasableByteSliceChunkReader) Close() {
+	r.byteSliceChunkReader.Close()
+	r.b.Discard()
+}
@@ -0,0 +1,211 @@
+package bundle_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/bundle"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	bundle_pb "github.com/buildbarn/bb-storage/pkg/proto/bundle"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func getEntryNames(t *testing.T, data []byte) []string {
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	// An action result that has an output file and an output
+	// directory containing two files, one of which is the same as
+	// the output file.
+	tree := &remoteexecution.Tree{
+		Root: &remoteexecution.Directory{
+			Files: []*remoteexecution.FileNode{
+				{
+					Name: "hello",
+					Digest: &remoteexecution.Digest{
+						Hash:      "8b1a9953c4611296a827abf8c47804d7",
+						SizeBytes: 5,
+					},
+				},
+				{
+					Name: "hello_world",
+					Digest: &remoteexecution.Digest{
+						Hash:      "3e25960a79dbc69b674cd4ec67a72c62",
+						SizeBytes: 11,
+					},
+				},
+			},
+		},
+	}
+	treeData, err := proto.Marshal(tree)
+	require.NoError(t, err)
+	treeHash := md5.Sum(treeData)
+	treeHashString := hex.EncodeToString(treeHash[:])
+	actionResult := &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{
+				Path: "hello",
+				Digest: &remoteexecution.Digest{
+					Hash:      "8b1a9953c4611296a827abf8c47804d7",
+					SizeBytes: 5,
+				},
+			},
+		},
+		OutputDirectories: []*remoteexecution.OutputDirectory{
+			{
+				Path: "directory",
+				TreeDigest: &remoteexecution.Digest{
+					Hash:      treeHashString,
+					SizeBytes: int64(len(treeData)),
+				},
+			},
+		},
+	}
+
+	// Export the action result.
+	sourceContentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	sourceActionCache := mock.NewMockBlobAccess(ctrl)
+	actionDigest := digest.MustNewDigest("source", "d41d8cd98f00b204e9800998ecf8427e", 123)
+	sourceActionCache.EXPECT().Get(ctx, actionDigest).Return(buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided))
+	sourceTreeDigest := digest.MustNewDigest("source", treeHashString, int64(len(treeData)))
+	sourceContentAddressableStorage.EXPECT().Get(ctx, sourceTreeDigest).
+		DoAndReturn(func(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+			return buffer.NewValidatedBufferFromByteSlice(treeData)
+		}).Times(2)
+	sourceContentAddressableStorage.EXPECT().Get(ctx, digest.MustNewDigest("source", "8b1a9953c4611296a827abf8c47804d7", 5)).
+		Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+	sourceContentAddressableStorage.EXPECT().Get(ctx, digest.MustNewDigest("source", "3e25960a79dbc69b674cd4ec67a72c62", 11)).
+		Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+
+	var b bytes.Buffer
+	require.NoError(t, bundle.Export(ctx, sourceContentAddressableStorage, sourceActionCache, bundle_pb.Manifest_ACTION_RESULT, actionDigest, 10000, &b))
+	require.Equal(t, []string{
+		"manifest.json",
+		"cas/8b1a9953c4611296a827abf8c47804d7-5",
+		"cas/" + sourceTreeDigest.GetKey(digest.KeyWithoutInstance),
+		"cas/3e25960a79dbc69b674cd4ec67a72c62-11",
+		"ac/d41d8cd98f00b204e9800998ecf8427e-123",
+	}, getEntryNames(t, b.Bytes()))
+
+	t.Run("ImportSuccess", func(t *testing.T) {
+		// Import the bundle under a different instance name.
+		// Objects that are already present should be skipped.
+		sinkContentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+		sinkActionCache := mock.NewMockBlobAccess(ctrl)
+		sinkTreeDigest := digest.MustNewDigest("sink", treeHashString, int64(len(treeData)))
+		sinkContentAddressableStorage.EXPECT().FindMissing(ctx, digest.NewSetBuilder().
+			Add(digest.MustNewDigest("sink", "8b1a9953c4611296a827abf8c47804d7", 5)).
+			Add(digest.MustNewDigest("sink", "3e25960a79dbc69b674cd4ec67a72c62", 11)).
+			Add(sinkTreeDigest).
+			Build()).
+			Return(digest.NewSetBuilder().
+				Add(digest.MustNewDigest("sink", "3e25960a79dbc69b674cd4ec67a72c62", 11)).
+				Add(sinkTreeDigest).
+				Build(), nil)
+		sinkContentAddressableStorage.EXPECT().Put(ctx, sinkTreeDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(10000)
+				require.NoError(t, err)
+				require.Equal(t, treeData, data)
+				return nil
+			})
+		sinkContentAddressableStorage.EXPECT().Put(ctx, digest.MustNewDigest("sink", "3e25960a79dbc69b674cd4ec67a72c62", 11), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(10000)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello world"), data)
+				return nil
+			})
+		sinkActionCache.EXPECT().Put(ctx, digest.MustNewDigest("sink", "d41d8cd98f00b204e9800998ecf8427e", 123), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				m, err := b.ToProto(&remoteexecution.ActionResult{}, 10000)
+				require.NoError(t, err)
+				testutil.RequireEqualProto(t, actionResult, m)
+				return nil
+			})
+
+		importedCount, err := bundle.Import(ctx, sinkContentAddressableStorage, sinkActionCache, digest.MustNewInstanceName("sink"), 10000, bytes.NewReader(b.Bytes()))
+		require.NoError(t, err)
+		require.Equal(t, 3, importedCount)
+	})
+
+	t.Run("ImportMissingManifest", func(t *testing.T) {
+		var invalid bytes.Buffer
+		tw := tar.NewWriter(&invalid)
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     "cas/8b1a9953c4611296a827abf8c47804d7-5",
+			Size:     5,
+		}))
+		_, err := tw.Write([]byte("Hello"))
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+
+		_, err = bundle.Import(ctx, mock.NewMockBlobAccess(ctrl), mock.NewMockBlobAccess(ctrl), digest.MustNewInstanceName("sink"), 10000, &invalid)
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Bundle starts with entry \"cas/8b1a9953c4611296a827abf8c47804d7-5\", while \"manifest.json\" was expected"), err)
+	})
+
+	t.Run("ImportTruncated", func(t *testing.T) {
+		// Only copy the manifest. All objects listed in the
+		// manifest are absent.
+		var truncated bytes.Buffer
+		tr := tar.NewReader(bytes.NewReader(b.Bytes()))
+		header, err := tr.Next()
+		require.NoError(t, err)
+		tw := tar.NewWriter(&truncated)
+		require.NoError(t, tw.WriteHeader(header))
+		_, err = io.Copy(tw, tr)
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+
+		sinkContentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+		sinkContentAddressableStorage.EXPECT().FindMissing(ctx, gomock.Any()).Return(digest.EmptySet, nil)
+
+		_, err = bundle.Import(ctx, sinkContentAddressableStorage, mock.NewMockBlobAccess(ctrl), digest.MustNewInstanceName("sink"), 10000, &truncated)
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Bundle does not contain 4 objects listed in the manifest"), err)
+	})
+}
+
+func TestExportMissingObject(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	// Bundles must be complete. Exporting should fail if objects
+	// are absent.
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	directoryDigest := digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 123)
+	contentAddressableStorage.EXPECT().Get(ctx, directoryDigest).
+		Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+	var b bytes.Buffer
+	testutil.RequireEqualStatus(
+		t,
+		status.Error(codes.NotFound, "Failed to obtain directory \"d41d8cd98f00b204e9800998ecf8427e-123-hello\": Object not found"),
+		bundle.Export(ctx, contentAddressableStorage, mock.NewMockBlobAccess(ctrl), bundle_pb.Manifest_DIRECTORY, directoryDigest, 10000, &b))
+	require.Empty(t, b.Bytes())
+}
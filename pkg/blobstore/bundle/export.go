@@ -0,0 +1,240 @@
+package bundle
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	bundle_pb "github.com/buildbarn/bb-storage/pkg/proto/bundle"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const manifestName = "manifest.json"
+
+// getEntryName returns the name of the tar entry under which an
+// object is stored. Instance names are not part of the name, as
+// bundles may be imported under a different instance name.
+func getEntryName(prefix string, blobDigest digest.Digest) string {
+	return prefix + blobDigest.GetKey(digest.KeyWithoutInstance)
+}
+
+// objectCollector gathers the digests of all objects in the Content
+// Addressable Storage that are reachable from a root object.
+// Duplicate digests are discarded, while the order in which digests
+// are encountered is preserved.
+type objectCollector struct {
+	contentAddressableStorage blobstore.BlobAccess
+	maximumMessageSizeBytes   int
+	seen                      map[digest.Digest]struct{}
+	digests                   []digest.Digest
+}
+
+// add a digest to the list of objects. It returns true if the digest
+// was not encountered before, meaning that the objects referenced by
+// it still need to be collected.
+func (c *objectCollector) add(blobDigest digest.Digest) bool {
+	if _, ok := c.seen[blobDigest]; ok {
+		return false
+	}
+	c.seen[blobDigest] = struct{}{}
+	c.digests = append(c.digests, blobDigest)
+	return true
+}
+
+func (c *objectCollector) addProto(instanceName digest.InstanceName, blobDigest *remoteexecution.Digest) error {
+	derivedDigest, err := instanceName.NewDigestFromProto(blobDigest)
+	if err != nil {
+		return err
+	}
+	c.add(derivedDigest)
+	return nil
+}
+
+func (c *objectCollector) addDirectoryFiles(instanceName digest.InstanceName, directory *remoteexecution.Directory) error {
+	if directory == nil {
+		return nil
+	}
+	for _, file := range directory.Files {
+		if err := c.addProto(instanceName, file.Digest); err != nil {
+			return util.StatusWrapf(err, "Invalid digest for file %#v", file.Name)
+		}
+	}
+	return nil
+}
+
+func (c *objectCollector) addTree(ctx context.Context, treeDigest digest.Digest) error {
+	if !c.add(treeDigest) {
+		return nil
+	}
+	treeMessage, err := c.contentAddressableStorage.Get(ctx, treeDigest).ToProto(&remoteexecution.Tree{}, c.maximumMessageSizeBytes)
+	if err != nil {
+		return util.StatusWrapf(err, "Failed to obtain tree %#v", treeDigest.String())
+	}
+	tree := treeMessage.(*remoteexecution.Tree)
+	instanceName := treeDigest.GetInstanceName()
+	if err := c.addDirectoryFiles(instanceName, tree.Root); err != nil {
+		return util.StatusWrapf(err, "Tree %#v", treeDigest.String())
+	}
+	for _, child := range tree.Children {
+		if err := c.addDirectoryFiles(instanceName, child); err != nil {
+			return util.StatusWrapf(err, "Tree %#v", treeDigest.String())
+		}
+	}
+	return nil
+}
+
+func (c *objectCollector) addDirectory(ctx context.Context, directoryDigest digest.Digest) error {
+	if !c.add(directoryDigest) {
+		return nil
+	}
+	directoryMessage, err := c.contentAddressableStorage.Get(ctx, directoryDigest).ToProto(&remoteexecution.Directory{}, c.maximumMessageSizeBytes)
+	if err != nil {
+		return util.StatusWrapf(err, "Failed to obtain directory %#v", directoryDigest.String())
+	}
+	directory := directoryMessage.(*remoteexecution.Directory)
+	instanceName := directoryDigest.GetInstanceName()
+	if err := c.addDirectoryFiles(instanceName, directory); err != nil {
+		return util.StatusWrapf(err, "Directory %#v", directoryDigest.String())
+	}
+	for _, child := range directory.Directories {
+		childDigest, err := instanceName.NewDigestFromProto(child.Digest)
+		if err != nil {
+			return util.StatusWrapf(err, "Directory %#v: Invalid digest for directory %#v", directoryDigest.String(), child.Name)
+		}
+		if err := c.addDirectory(ctx, childDigest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *objectCollector) addActionResult(ctx context.Context, instanceName digest.InstanceName, actionResult *remoteexecution.ActionResult) error {
+	for _, outputFile := range actionResult.OutputFiles {
+		if err := c.addProto(instanceName, outputFile.Digest); err != nil {
+			return util.StatusWrapf(err, "Invalid digest for output file %#v", outputFile.Path)
+		}
+	}
+	for _, outputDirectory := range actionResult.OutputDirectories {
+		treeDigest, err := instanceName.NewDigestFromProto(outputDirectory.TreeDigest)
+		if err != nil {
+			return util.StatusWrapf(err, "Invalid digest for output directory %#v", outputDirectory.Path)
+		}
+		if err := c.addTree(ctx, treeDigest); err != nil {
+			return err
+		}
+	}
+	if actionResult.StdoutDigest != nil {
+		if err := c.addProto(instanceName, actionResult.StdoutDigest); err != nil {
+			return util.StatusWrap(err, "Invalid standard output digest")
+		}
+	}
+	if actionResult.StderrDigest != nil {
+		if err := c.addProto(instanceName, actionResult.StderrDigest); err != nil {
+			return util.StatusWrap(err, "Invalid standard error digest")
+		}
+	}
+	return nil
+}
+
+func writeEntry(tw *tar.Writer, name string, sizeBytes int64) error {
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o644,
+		Size:     sizeBytes,
+	})
+}
+
+// Export writes a bundle containing a root object and all objects
+// reachable from it to a writer. Objects stored in the Content
+// Addressable Storage are streamed into the bundle, meaning that only
+// the REv2 messages that need to be traversed are held in memory.
+//
+// All reachable objects must be present. Exporting fails if any of
+// them are missing, as the resulting bundle would be incomplete.
+func Export(ctx context.Context, contentAddressableStorage, actionCache blobstore.BlobAccess, rootType bundle_pb.Manifest_RootType, rootDigest digest.Digest, maximumMessageSizeBytes int, w io.Writer) error {
+	c := objectCollector{
+		contentAddressableStorage: contentAddressableStorage,
+		maximumMessageSizeBytes:   maximumMessageSizeBytes,
+		seen:                      map[digest.Digest]struct{}{},
+	}
+	manifest := &bundle_pb.Manifest{
+		RootType:   rootType,
+		RootDigest: rootDigest.GetProto(),
+	}
+	var actionResultData []byte
+	switch rootType {
+	case bundle_pb.Manifest_ACTION_RESULT:
+		data, err := actionCache.Get(ctx, rootDigest).ToByteSlice(maximumMessageSizeBytes)
+		if err != nil {
+			return util.StatusWrap(err, "Failed to obtain action result")
+		}
+		var actionResult remoteexecution.ActionResult
+		if err := proto.Unmarshal(data, &actionResult); err != nil {
+			return util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to unmarshal action result")
+		}
+		if err := c.addActionResult(ctx, rootDigest.GetInstanceName(), &actionResult); err != nil {
+			return err
+		}
+		actionResultData = data
+		manifest.ActionCacheBlobs = append(manifest.ActionCacheBlobs, rootDigest.GetProto())
+	case bundle_pb.Manifest_TREE:
+		if err := c.addTree(ctx, rootDigest); err != nil {
+			return err
+		}
+	case bundle_pb.Manifest_DIRECTORY:
+		if err := c.addDirectory(ctx, rootDigest); err != nil {
+			return err
+		}
+	default:
+		return status.Error(codes.InvalidArgument, "Unknown root type")
+	}
+	for _, blobDigest := range c.digests {
+		manifest.ContentAddressableStorageBlobs = append(manifest.ContentAddressableStorageBlobs, blobDigest.GetProto())
+	}
+
+	// Write the manifest, followed by the objects in the Content
+	// Addressable Storage and the Action Cache.
+	tw := tar.NewWriter(w)
+	manifestData, err := protojson.MarshalOptions{Multiline: true}.Marshal(manifest)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal manifest")
+	}
+	if err := writeEntry(tw, manifestName, int64(len(manifestData))); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to write manifest")
+	}
+	if _, err := tw.Write(manifestData); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to write manifest")
+	}
+	for _, blobDigest := range c.digests {
+		if err := writeEntry(tw, getEntryName("cas/", blobDigest), blobDigest.GetSizeBytes()); err != nil {
+			return util.StatusWrapfWithCode(err, codes.Internal, "Failed to write object %#v", blobDigest.String())
+		}
+		r := contentAddressableStorage.Get(ctx, blobDigest).ToReader()
+		_, err := io.Copy(tw, r)
+		r.Close()
+		if err != nil {
+			return util.StatusWrapf(err, "Failed to write object %#v", blobDigest.String())
+		}
+	}
+	if actionResultData != nil {
+		if err := writeEntry(tw, getEntryName("ac/", rootDigest), int64(len(actionResultData))); err != nil {
+			return util.StatusWrapWithCode(err, codes.Internal, "Failed to write action result")
+		}
+		if _, err := tw.Write(actionResultData); err != nil {
+			return util.StatusWrapWithCode(err, codes.Internal, "Failed to write action result")
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to finalize bundle")
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package bundle
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"io/ioutil"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	bundle_pb "github.com/buildbarn/bb-storage/pkg/proto/bundle"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// getManifestDigests converts a list of digests contained in a
+// manifest to a map that is keyed by the name of the tar entry under
+// which the object is stored.
+func getManifestDigests(instanceName digest.InstanceName, prefix string, blobDigests []*remoteexecution.Digest) (map[string]digest.Digest, error) {
+	m := make(map[string]digest.Digest, len(blobDigests))
+	for _, blobDigest := range blobDigests {
+		derivedDigest, err := instanceName.NewDigestFromProto(blobDigest)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Manifest contains an invalid digest")
+		}
+		m[getEntryName(prefix, derivedDigest)] = derivedDigest
+	}
+	return m, nil
+}
+
+// Import reads a bundle that was created by Export() from a reader,
+// and stores the objects contained in it under a given instance name.
+// Objects that are already present in the Content Addressable Storage
+// are skipped. The number of objects that were stored is returned.
+//
+// Objects in the Content Addressable Storage are validated against
+// their digest while being stored. The bundle is rejected if it
+// contains objects that are not listed in its manifest, or if objects
+// listed in its manifest are absent.
+func Import(ctx context.Context, contentAddressableStorage, actionCache blobstore.BlobAccess, instanceName digest.InstanceName, maximumMessageSizeBytes int, r io.Reader) (int, error) {
+	// Read the manifest, which needs to be the first entry.
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err != nil {
+		return 0, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to read manifest")
+	}
+	if header.Name != manifestName {
+		return 0, status.Errorf(codes.InvalidArgument, "Bundle starts with entry %#v, while %#v was expected", header.Name, manifestName)
+	}
+	if header.Size > int64(maximumMessageSizeBytes) {
+		return 0, status.Errorf(codes.InvalidArgument, "Manifest is %d bytes in size, while a maximum of %d bytes is permitted", header.Size, maximumMessageSizeBytes)
+	}
+	manifestData, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return 0, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to read manifest")
+	}
+	var manifest bundle_pb.Manifest
+	if err := protojson.Unmarshal(manifestData, &manifest); err != nil {
+		return 0, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to unmarshal manifest")
+	}
+	casDigests, err := getManifestDigests(instanceName, "cas/", manifest.ContentAddressableStorageBlobs)
+	if err != nil {
+		return 0, err
+	}
+	acDigests, err := getManifestDigests(instanceName, "ac/", manifest.ActionCacheBlobs)
+	if err != nil {
+		return 0, err
+	}
+
+	// Determine which objects in the Content Addressable Storage
+	// are missing, so that only those are stored.
+	missing := map[digest.Digest]struct{}{}
+	batch := digest.NewSetBuilder()
+	flushBatch := func() error {
+		missingBatch, err := contentAddressableStorage.FindMissing(ctx, batch.Build())
+		if err != nil {
+			return util.StatusWrap(err, "Failed to determine which objects are missing")
+		}
+		for _, blobDigest := range missingBatch.Items() {
+			missing[blobDigest] = struct{}{}
+		}
+		batch = digest.NewSetBuilder()
+		return nil
+	}
+	for _, blobDigest := range casDigests {
+		batch.Add(blobDigest)
+		if batch.Length() >= blobstore.RecommendedFindMissingDigestsCount {
+			if err := flushBatch(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if batch.Length() > 0 {
+		if err := flushBatch(); err != nil {
+			return 0, err
+		}
+	}
+
+	// Store all objects in the order in which they appear in the
+	// bundle. Objects in the Action Cache are placed at the end of
+	// the bundle, meaning that they are only stored after the
+	// objects they reference.
+	importedCount := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return importedCount, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to read bundle")
+		}
+		if blobDigest, ok := casDigests[header.Name]; ok {
+			delete(casDigests, header.Name)
+			if _, ok := missing[blobDigest]; !ok {
+				continue
+			}
+			if err := contentAddressableStorage.Put(ctx, blobDigest, buffer.NewCASBufferFromReader(blobDigest, ioutil.NopCloser(tr), buffer.UserProvided)); err != nil {
+				return importedCount, util.StatusWrapf(err, "Failed to store object %#v", blobDigest.String())
+			}
+		} else if blobDigest, ok := acDigests[header.Name]; ok {
+			delete(acDigests, header.Name)
+			if err := actionCache.Put(ctx, blobDigest, buffer.NewProtoBufferFromReader(&remoteexecution.ActionResult{}, ioutil.NopCloser(tr), buffer.UserProvided)); err != nil {
+				return importedCount, util.StatusWrapf(err, "Failed to store action result %#v", blobDigest.String())
+			}
+		} else {
+			return importedCount, status.Errorf(codes.InvalidArgument, "Bundle contains entry %#v, which is not listed in the manifest", header.Name)
+		}
+		importedCount++
+	}
+	if n := len(casDigests) + len(acDigests); n > 0 {
+		return importedCount, status.Errorf(codes.InvalidArgument, "Bundle does not contain %d objects listed in the manifest", n)
+	}
+	return importedCount, nil
+}
@@ -0,0 +1,257 @@
+package chunking
+
+import (
+	"context"
+	"io"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/icas"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type chunkingBlobAccess struct {
+	blobAccess                        blobstore.BlobAccess
+	indirectContentAddressableStorage blobstore.BlobAccess
+	cutoffSizeBytes                   int64
+	minimumChunkSizeBytes             int
+	averageChunkSizeBytes             int
+	maximumChunkSizeBytes             int
+	maximumMessageSizeBytes           int
+}
+
+// NewChunkingBlobAccess creates a decorator for the Content Addressable
+// Storage (CAS) that splits objects exceeding a cutoff size into
+// chunks, using the FastCDC content-defined chunking algorithm. Chunks
+// are stored in the backend as separate objects, while the list of
+// chunks of every object is stored in the Indirect Content Addressable
+// Storage (ICAS). Objects are reassembled when read.
+//
+// Because chunk boundaries are determined by the contents of objects,
+// objects that differ only slightly share most of their chunks. This
+// permits deduplication of large objects, such as container image
+// layers.
+//
+// The maximum chunk size may not exceed the cutoff size, as that
+// would cause chunks to be treated as chunked objects themselves.
+func NewChunkingBlobAccess(blobAccess, indirectContentAddressableStorage blobstore.BlobAccess, cutoffSizeBytes int64, minimumChunkSizeBytes, averageChunkSizeBytes, maximumChunkSizeBytes, maximumMessageSizeBytes int) blobstore.BlobAccess {
+	return &chunkingBlobAccess{
+		blobAccess:                        blobAccess,
+		indirectContentAddressableStorage: indirectContentAddressableStorage,
+		cutoffSizeBytes:                   cutoffSizeBytes,
+		minimumChunkSizeBytes:             minimumChunkSizeBytes,
+		averageChunkSizeBytes:             averageChunkSizeBytes,
+		maximumChunkSizeBytes:             maximumChunkSizeBytes,
+		maximumMessageSizeBytes:           maximumMessageSizeBytes,
+	}
+}
+
+// getChunkDigests loads the list of chunks of an object from the ICAS.
+func (ba *chunkingBlobAccess) getChunkDigests(ctx context.Context, blobDigest digest.Digest) ([]digest.Digest, error) {
+	referenceMessage, err := ba.indirectContentAddressableStorage.Get(ctx, blobDigest).ToProto(&icas.Reference{}, ba.maximumMessageSizeBytes)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Failed to load list of chunks")
+	}
+	reference := referenceMessage.(*icas.Reference)
+	medium, ok := reference.Medium.(*icas.Reference_Chunks_)
+	if !ok {
+		return nil, status.Error(codes.Internal, "Reference does not contain a list of chunks")
+	}
+
+	instanceName := blobDigest.GetInstanceName()
+	chunkDigests := make([]digest.Digest, 0, len(medium.Chunks.Digests))
+	totalSizeBytes := int64(0)
+	for i, chunk := range medium.Chunks.Digests {
+		chunkDigest, err := instanceName.NewDigestFromProto(chunk)
+		if err != nil {
+			return nil, util.StatusWrapfWithCode(err, codes.Internal, "Invalid digest for chunk at index %d", i)
+		}
+		chunkDigests = append(chunkDigests, chunkDigest)
+		totalSizeBytes += chunkDigest.GetSizeBytes()
+	}
+	if totalSizeBytes != blobDigest.GetSizeBytes() {
+		return nil, status.Errorf(codes.Internal, "Chunks have a total size of %d bytes, while the object is %d bytes in size", totalSizeBytes, blobDigest.GetSizeBytes())
+	}
+	return chunkDigests, nil
+}
+
+// chunkReader is a buffer.ChunkReader that concatenates the contents
+// of chunks. Chunks are only requested from the backend when needed,
+// so that objects can be streamed.
+type chunkReader struct {
+	ctx                   context.Context
+	blobAccess            blobstore.BlobAccess
+	chunkDigests          []digest.Digest
+	maximumChunkSizeBytes int
+	r                     buffer.ChunkReader
+}
+
+func (r *chunkReader) Read() ([]byte, error) {
+	for {
+		if r.r == nil {
+			if len(r.chunkDigests) == 0 {
+				return nil, io.EOF
+			}
+			r.r = r.blobAccess.Get(r.ctx, r.chunkDigests[0]).ToChunkReader(0, r.maximumChunkSizeBytes)
+			r.chunkDigests = r.chunkDigests[1:]
+		}
+		data, err := r.r.Read()
+		if err != io.EOF {
+			return data, err
+		}
+		r.r.Close()
+		r.r = nil
+	}
+}
+
+func (r *chunkReader) Close() {
+	if r.r != nil {
+		r.r.Close()
+		r.r = nil
+	}
+}
+
+func (ba *chunkingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	if blobDigest.GetSizeBytes() <= ba.cutoffSizeBytes {
+		return ba.blobAccess.Get(ctx, blobDigest)
+	}
+	chunkDigests, err := ba.getChunkDigests(ctx, blobDigest)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.NewCASBufferFromChunkReader(
+		blobDigest,
+		&chunkReader{
+			ctx:                   ctx,
+			blobAccess:            ba.blobAccess,
+			chunkDigests:          chunkDigests,
+			maximumChunkSizeBytes: ba.maximumChunkSizeBytes,
+		},
+		buffer.BackendProvided(buffer.Irreparable(blobDigest)))
+}
+
+func (ba *chunkingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	if blobDigest.GetSizeBytes() <= ba.cutoffSizeBytes {
+		return ba.blobAccess.Put(ctx, blobDigest, b)
+	}
+
+	// Split the object into chunks, only storing the chunks that
+	// are not present yet.
+	r := b.ToReader()
+	defer r.Close()
+	chunker := NewFastCDCChunker(r, ba.minimumChunkSizeBytes, ba.averageChunkSizeBytes, ba.maximumChunkSizeBytes)
+	digestFunction := blobDigest.GetDigestFunction()
+	var chunks []*remoteexecution.Digest
+	for {
+		chunk, err := chunker.ReadChunk()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		generator := digestFunction.NewGenerator()
+		generator.Write(chunk)
+		chunkDigest := generator.Sum()
+		missing, err := ba.blobAccess.FindMissing(ctx, chunkDigest.ToSingletonSet())
+		if err != nil {
+			return util.StatusWrapf(err, "Failed to determine whether chunk %#v is present", chunkDigest.String())
+		}
+		if !missing.Empty() {
+			// The chunk is copied, as the chunker reuses its
+			// buffer, while the backend may retain the data.
+			if err := ba.blobAccess.Put(ctx, chunkDigest, buffer.NewValidatedBufferFromByteSlice(append([]byte(nil), chunk...))); err != nil {
+				return util.StatusWrapf(err, "Failed to store chunk %#v", chunkDigest.String())
+			}
+		}
+		chunks = append(chunks, chunkDigest.GetProto())
+	}
+
+	// Only store the list of chunks after the object has been read
+	// in its entirety. This ensures that the object's contents
+	// have been validated against its digest.
+	if err := ba.indirectContentAddressableStorage.Put(
+		ctx,
+		blobDigest,
+		buffer.NewProtoBufferFromProto(&icas.Reference{
+			Medium: &icas.Reference_Chunks_{
+				Chunks: &icas.Reference_Chunks{
+					Digests: chunks,
+				},
+			},
+			SizeBytes: blobDigest.GetSizeBytes(),
+		}, buffer.UserProvided)); err != nil {
+		return util.StatusWrap(err, "Failed to store list of chunks")
+	}
+	return nil
+}
+
+func (ba *chunkingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Split up digests by size.
+	unchunkedDigests := digest.NewSetBuilder()
+	chunkedDigests := digest.NewSetBuilder()
+	for _, blobDigest := range digests.Items() {
+		if blobDigest.GetSizeBytes() <= ba.cutoffSizeBytes {
+			unchunkedDigests.Add(blobDigest)
+		} else {
+			chunkedDigests.Add(blobDigest)
+		}
+	}
+
+	missingUnchunked, err := ba.blobAccess.FindMissing(ctx, unchunkedDigests.Build())
+	if err != nil {
+		return digest.EmptySet, err
+	}
+	if chunkedDigests.Length() == 0 {
+		return missingUnchunked, nil
+	}
+	allChunkedDigests := chunkedDigests.Build()
+	missingChunked, err := ba.indirectContentAddressableStorage.FindMissing(ctx, allChunkedDigests)
+	if err != nil {
+		return digest.EmptySet, util.StatusWrap(err, "Failed to determine which lists of chunks are present")
+	}
+
+	// Objects whose list of chunks is present may still be
+	// incomplete, as chunks may have been removed from the backend
+	// independently. Report these objects as missing, so that
+	// clients upload them once more.
+	presentChunked, _, _ := digest.GetDifferenceAndIntersection(allChunkedDigests, missingChunked)
+	missingIncomplete := digest.NewSetBuilder()
+	chunkDigestsPerObject := make(map[digest.Digest][]digest.Digest, presentChunked.Length())
+	allChunkDigests := digest.NewSetBuilder()
+	for _, blobDigest := range presentChunked.Items() {
+		chunkDigests, err := ba.getChunkDigests(ctx, blobDigest)
+		if status.Code(err) == codes.NotFound {
+			// List of chunks was removed in the meantime.
+			missingIncomplete.Add(blobDigest)
+			continue
+		} else if err != nil {
+			return digest.EmptySet, util.StatusWrapf(err, "Object %#v", blobDigest.String())
+		}
+		chunkDigestsPerObject[blobDigest] = chunkDigests
+		for _, chunkDigest := range chunkDigests {
+			allChunkDigests.Add(chunkDigest)
+		}
+	}
+	missingChunks, err := ba.blobAccess.FindMissing(ctx, allChunkDigests.Build())
+	if err != nil {
+		return digest.EmptySet, util.StatusWrap(err, "Failed to determine which chunks are present")
+	}
+	missingChunksMap := make(map[digest.Digest]struct{}, missingChunks.Length())
+	for _, chunkDigest := range missingChunks.Items() {
+		missingChunksMap[chunkDigest] = struct{}{}
+	}
+	for blobDigest, chunkDigests := range chunkDigestsPerObject {
+		for _, chunkDigest := range chunkDigests {
+			if _, ok := missingChunksMap[chunkDigest]; ok {
+				missingIncomplete.Add(blobDigest)
+				break
+			}
+		}
+	}
+	return digest.GetUnion([]digest.Set{missingUnchunked, missingChunked, missingIncomplete.Build()}), nil
+}
@@ -0,0 +1,153 @@
+package chunking_test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/chunking"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/icas"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeStorage is a simple in-memory implementation of BlobAccess's
+// operations, which can be installed on a MockBlobAccess.
+type fakeStorage map[digest.Digest][]byte
+
+func (s fakeStorage) install(blobAccess *mock.MockBlobAccess, m proto.Message) {
+	blobAccess.EXPECT().Get(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+			data, ok := s[blobDigest]
+			if !ok {
+				return buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found"))
+			}
+			if m != nil {
+				return buffer.NewProtoBufferFromByteSlice(proto.Clone(m), data, buffer.UserProvided)
+			}
+			return buffer.NewCASBufferFromByteSlice(blobDigest, data, buffer.UserProvided)
+		}).AnyTimes()
+	blobAccess.EXPECT().Put(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(1 << 20)
+			if err != nil {
+				return err
+			}
+			s[blobDigest] = data
+			return nil
+		}).AnyTimes()
+	blobAccess.EXPECT().FindMissing(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, digests digest.Set) (digest.Set, error) {
+			missing := digest.NewSetBuilder()
+			for _, blobDigest := range digests.Items() {
+				if _, ok := s[blobDigest]; !ok {
+					missing.Add(blobDigest)
+				}
+			}
+			return missing.Build(), nil
+		}).AnyTimes()
+}
+
+func getDigest(data []byte) digest.Digest {
+	generator := digest.MustNewFunction("hello", remoteexecution.DigestFunction_SHA256).NewGenerator()
+	generator.Write(data)
+	return generator.Sum()
+}
+
+func TestChunkingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	baseStorage := fakeStorage{}
+	baseStorage.install(baseBlobAccess, nil)
+	indirectContentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	indirectStorage := fakeStorage{}
+	indirectStorage.install(indirectContentAddressableStorage, &icas.Reference{})
+	blobAccess := chunking.NewChunkingBlobAccess(baseBlobAccess, indirectContentAddressableStorage, 4096, 256, 1024, 4096, 1<<20)
+
+	largeData := make([]byte, 1<<16)
+	rand.New(rand.NewSource(123)).Read(largeData)
+	largeDigest := getDigest(largeData)
+
+	t.Run("Small", func(t *testing.T) {
+		// Objects below the cutoff size should be stored as is.
+		smallDigest := getDigest([]byte("Hello"))
+		require.NoError(t, blobAccess.Put(ctx, smallDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+		require.Equal(t, []byte("Hello"), baseStorage[smallDigest])
+		require.Empty(t, indirectStorage)
+
+		data, err := blobAccess.Get(ctx, smallDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("LargeCorrupted", func(t *testing.T) {
+		// The list of chunks should not be stored if the
+		// object's contents do not match its digest.
+		corruptedData := append([]byte(nil), largeData...)
+		corruptedData[1000] ^= 1
+		err := blobAccess.Put(ctx, largeDigest, buffer.NewCASBufferFromByteSlice(largeDigest, corruptedData, buffer.UserProvided))
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+		require.Empty(t, indirectStorage)
+
+		missing, err := blobAccess.FindMissing(ctx, largeDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, largeDigest.ToSingletonSet(), missing)
+	})
+
+	t.Run("LargeSuccess", func(t *testing.T) {
+		// Objects above the cutoff size should be split into
+		// chunks that can be reassembled.
+		require.NoError(t, blobAccess.Put(ctx, largeDigest, buffer.NewCASBufferFromByteSlice(largeDigest, largeData, buffer.UserProvided)))
+		require.Len(t, indirectStorage, 1)
+		_, ok := baseStorage[largeDigest]
+		require.False(t, ok)
+
+		data, err := blobAccess.Get(ctx, largeDigest).ToByteSlice(1 << 20)
+		require.NoError(t, err)
+		require.Equal(t, largeData, data)
+
+		missing, err := blobAccess.FindMissing(ctx, largeDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+
+	t.Run("Deduplication", func(t *testing.T) {
+		// Storing an object that only differs slightly should
+		// only cause a small number of chunks to be stored.
+		modifiedData := append(append(append([]byte(nil), largeData[:30000]...), []byte("Hello world")...), largeData[30000:]...)
+		modifiedDigest := getDigest(modifiedData)
+		chunkCount := len(baseStorage)
+		require.NoError(t, blobAccess.Put(ctx, modifiedDigest, buffer.NewCASBufferFromByteSlice(modifiedDigest, modifiedData, buffer.UserProvided)))
+		require.LessOrEqual(t, len(baseStorage)-chunkCount, 3)
+
+		data, err := blobAccess.Get(ctx, modifiedDigest).ToByteSlice(1 << 20)
+		require.NoError(t, err)
+		require.Equal(t, modifiedData, data)
+	})
+
+	t.Run("MissingChunk", func(t *testing.T) {
+		// If one of the chunks has been removed, the object
+		// should be reported as missing.
+		m, err := indirectContentAddressableStorage.Get(ctx, largeDigest).ToProto(&icas.Reference{}, 1<<20)
+		require.NoError(t, err)
+		chunkDigest, err := digest.MustNewInstanceName("hello").NewDigestFromProto(m.(*icas.Reference).Medium.(*icas.Reference_Chunks_).Chunks.Digests[3])
+		require.NoError(t, err)
+		delete(baseStorage, chunkDigest)
+
+		missing, err := blobAccess.FindMissing(ctx, largeDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, largeDigest.ToSingletonSet(), missing)
+
+		_, err = blobAccess.Get(ctx, largeDigest).ToByteSlice(1 << 20)
+		require.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
@@ -0,0 +1,124 @@
+package chunking
+
+import (
+	"io"
+	"math/bits"
+)
+
+// gearTable contains the random values that are used by the Gear
+// rolling hash. The values are generated using SplitMix64 with a fixed
+// seed. They must never be changed, as that would cause chunk
+// boundaries of objects that are already stored to differ from those
+// of newly written objects, thereby preventing deduplication.
+var gearTable [256]uint64
+
+func init() {
+	state := uint64(0x6275696c6462626e)
+	for i := range gearTable {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gearTable[i] = z ^ (z >> 31)
+	}
+}
+
+// FastCDCChunker splits the data returned by a reader into chunks,
+// using the FastCDC content-defined chunking algorithm. As chunk
+// boundaries are determined by the data itself, inserting or removing
+// data only affects the chunks surrounding the modification.
+//
+// This implementation uses normalized chunking, where a stricter
+// condition for placing chunk boundaries is used before the average
+// chunk size is reached, and a looser condition is used afterwards.
+// This causes chunk sizes to be distributed more closely around the
+// average chunk size.
+//
+// More details: "FastCDC: a Fast and Efficient Content-Defined
+// Chunking Approach for Data Deduplication", USENIX ATC '16.
+type FastCDCChunker struct {
+	r                io.Reader
+	minimumSizeBytes int
+	averageSizeBytes int
+	maskSmall        uint64
+	maskLarge        uint64
+
+	buffer  []byte
+	start   int
+	end     int
+	readErr error
+}
+
+// NewFastCDCChunker creates a FastCDCChunker that reads data from a
+// reader. The average chunk size must be a power of two, and chunk
+// sizes must satisfy 0 < minimum <= average <= maximum.
+func NewFastCDCChunker(r io.Reader, minimumSizeBytes, averageSizeBytes, maximumSizeBytes int) *FastCDCChunker {
+	averageBits := bits.Len(uint(averageSizeBytes)) - 1
+	return &FastCDCChunker{
+		r:                r,
+		minimumSizeBytes: minimumSizeBytes,
+		averageSizeBytes: averageSizeBytes,
+		// Only use the most significant bits of the hash, as
+		// the least significant bits only depend on the last
+		// few bytes of input.
+		maskSmall: ^uint64(0) << (64 - averageBits - 2),
+		maskLarge: ^uint64(0) << (64 - averageBits + 2),
+		buffer:    make([]byte, maximumSizeBytes),
+	}
+}
+
+// findBoundary returns the size of the chunk at the start of the data.
+func (c *FastCDCChunker) findBoundary(data []byte) int {
+	n := len(data)
+	if n <= c.minimumSizeBytes {
+		return n
+	}
+	normal := c.averageSizeBytes
+	if normal > n {
+		normal = n
+	}
+	var hash uint64
+	i := c.minimumSizeBytes
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&c.maskSmall == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&c.maskLarge == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// ReadChunk returns the next chunk of data. The chunk is only valid
+// until the next call to ReadChunk(). io.EOF is returned after all data
+// has been returned.
+func (c *FastCDCChunker) ReadChunk() ([]byte, error) {
+	// Ensure the buffer contains enough data to hold a chunk of
+	// the maximum size, or all of the remaining data.
+	if c.end-c.start < len(c.buffer) && c.readErr == nil {
+		c.end = copy(c.buffer, c.buffer[c.start:c.end])
+		c.start = 0
+		n, err := io.ReadFull(c.r, c.buffer[c.end:])
+		c.end += n
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		c.readErr = err
+	}
+	if c.readErr != nil && c.readErr != io.EOF {
+		return nil, c.readErr
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	chunk := c.buffer[c.start:c.end]
+	chunk = chunk[:c.findBoundary(chunk)]
+	c.start += len(chunk)
+	return chunk, nil
+}
@@ -0,0 +1,87 @@
+package chunking_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/chunking"
+	"github.com/stretchr/testify/require"
+)
+
+func readAllChunks(t *testing.T, chunker *chunking.FastCDCChunker) [][]byte {
+	var chunks [][]byte
+	for {
+		chunk, err := chunker.ReadChunk()
+		if err == io.EOF {
+			return chunks
+		}
+		require.NoError(t, err)
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+}
+
+func TestFastCDCChunker(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(123)).Read(data)
+
+	t.Run("Empty", func(t *testing.T) {
+		chunker := chunking.NewFastCDCChunker(bytes.NewReader(nil), 256, 1024, 4096)
+		_, err := chunker.ReadChunk()
+		require.Equal(t, io.EOF, err)
+	})
+
+	t.Run("ChunkSizes", func(t *testing.T) {
+		// All chunks except the last one should respect the
+		// minimum and maximum size. Concatenating all chunks
+		// should yield the original data. Short reads should
+		// not influence the results.
+		chunks := readAllChunks(t, chunking.NewFastCDCChunker(iotest.HalfReader(bytes.NewReader(data)), 256, 1024, 4096))
+		require.Equal(t, chunks, readAllChunks(t, chunking.NewFastCDCChunker(bytes.NewReader(data), 256, 1024, 4096)))
+		for _, chunk := range chunks[:len(chunks)-1] {
+			require.GreaterOrEqual(t, len(chunk), 256)
+			require.LessOrEqual(t, len(chunk), 4096)
+		}
+		require.Equal(t, data, bytes.Join(chunks, nil))
+
+		// Normalized chunking should cause the number of chunks
+		// to be close to what the average size suggests.
+		require.Greater(t, len(chunks), len(data)/2048)
+		require.Less(t, len(chunks), len(data)/512)
+	})
+
+	t.Run("ContentDefined", func(t *testing.T) {
+		// Inserting data in the middle should only alter the
+		// chunks surrounding the modification.
+		original := readAllChunks(t, chunking.NewFastCDCChunker(bytes.NewReader(data), 256, 1024, 4096))
+		originalChunks := map[string]struct{}{}
+		for _, chunk := range original {
+			originalChunks[string(chunk)] = struct{}{}
+		}
+
+		modifiedData := append(append(append([]byte(nil), data[:500000]...), []byte("Hello world")...), data[500000:]...)
+		modified := readAllChunks(t, chunking.NewFastCDCChunker(bytes.NewReader(modifiedData), 256, 1024, 4096))
+		require.Equal(t, modifiedData, bytes.Join(modified, nil))
+		changedChunks := 0
+		for _, chunk := range modified {
+			if _, ok := originalChunks[string(chunk)]; !ok {
+				changedChunks++
+			}
+		}
+		require.GreaterOrEqual(t, changedChunks, 1)
+		require.LessOrEqual(t, changedChunks, 3)
+	})
+
+	t.Run("ReadFailure", func(t *testing.T) {
+		chunker := chunking.NewFastCDCChunker(iotest.TimeoutReader(bytes.NewReader(data)), 256, 1024, 4096)
+		_, err := chunker.ReadChunk()
+		require.NoError(t, err)
+		for err == nil {
+			_, err = chunker.ReadChunk()
+		}
+		require.True(t, errors.Is(err, iotest.ErrTimeout))
+	})
+}
@@ -0,0 +1,234 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	circuitBreakingBlobAccessPrometheusMetrics sync.Once
+
+	circuitBreakingBlobAccessState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "circuit_breaking_blob_access_state",
+			Help:      "Current state of the circuit breaker, where the gauge for the active state is set to one.",
+		},
+		[]string{"name", "state"})
+	circuitBreakingBlobAccessTripsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "circuit_breaking_blob_access_trips_total",
+			Help:      "Number of times the circuit breaker transitioned to the open state.",
+		},
+		[]string{"name"})
+	circuitBreakingBlobAccessRejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "circuit_breaking_blob_access_rejected_total",
+			Help:      "Number of operations that failed fast, because the circuit breaker was open.",
+		},
+		[]string{"name", "operation"})
+)
+
+type circuitBreakerState int
+
+const (
+	circuitBreakerStateClosed circuitBreakerState = iota
+	circuitBreakerStateOpen
+	circuitBreakerStateHalfOpen
+)
+
+var circuitBreakerStateNames = [...]string{"closed", "open", "half_open"}
+
+type circuitBreakingBlobAccess struct {
+	base             BlobAccess
+	clock            clock.Clock
+	failureThreshold int
+	coolDown         time.Duration
+	maximumProbes    int
+
+	lock                sync.Mutex
+	state               circuitBreakerState
+	consecutiveFailures int
+	openedAt            time.Time
+	probesInFlight      int
+
+	stateGauges              [len(circuitBreakerStateNames)]prometheus.Gauge
+	tripsTotal               prometheus.Counter
+	getRejectedTotal         prometheus.Counter
+	putRejectedTotal         prometheus.Counter
+	findMissingRejectedTotal prometheus.Counter
+}
+
+// NewCircuitBreakingBlobAccess creates a decorator for BlobAccess that
+// stops forwarding requests to a backend that is failing. This
+// prevents clients from having to wait for timeouts to expire when a
+// backend is unresponsive.
+//
+// After failureThreshold consecutive operations have failed with an
+// error that indicates that the backend is unhealthy, the circuit
+// breaker opens. Operations then fail immediately with UNAVAILABLE for
+// the duration of coolDown. Afterwards, the circuit breaker becomes
+// half-open, permitting up to maximumProbes operations to be forwarded
+// to the backend. If one of these succeeds, the circuit breaker closes.
+// If one of them fails, it opens once again.
+func NewCircuitBreakingBlobAccess(base BlobAccess, clock clock.Clock, failureThreshold int, coolDown time.Duration, maximumProbes int, name string) BlobAccess {
+	circuitBreakingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(circuitBreakingBlobAccessState)
+		prometheus.MustRegister(circuitBreakingBlobAccessTripsTotal)
+		prometheus.MustRegister(circuitBreakingBlobAccessRejectedTotal)
+	})
+
+	ba := &circuitBreakingBlobAccess{
+		base:             base,
+		clock:            clock,
+		failureThreshold: failureThreshold,
+		coolDown:         coolDown,
+		maximumProbes:    maximumProbes,
+
+		tripsTotal:               circuitBreakingBlobAccessTripsTotal.WithLabelValues(name),
+		getRejectedTotal:         circuitBreakingBlobAccessRejectedTotal.WithLabelValues(name, "Get"),
+		putRejectedTotal:         circuitBreakingBlobAccessRejectedTotal.WithLabelValues(name, "Put"),
+		findMissingRejectedTotal: circuitBreakingBlobAccessRejectedTotal.WithLabelValues(name, "FindMissing"),
+	}
+	for i, stateName := range circuitBreakerStateNames {
+		ba.stateGauges[i] = circuitBreakingBlobAccessState.WithLabelValues(name, stateName)
+	}
+	ba.setState(circuitBreakerStateClosed)
+	return ba
+}
+
+// isBackendFailure returns whether an error indicates that the backend
+// is unhealthy. Errors such as NOT_FOUND and INVALID_ARGUMENT are
+// returned by healthy backends, while CANCELED is caused by the client.
+func isBackendFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Internal, codes.Unavailable, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ba *circuitBreakingBlobAccess) setState(state circuitBreakerState) {
+	ba.stateGauges[ba.state].Set(0)
+	ba.state = state
+	ba.stateGauges[ba.state].Set(1)
+}
+
+// startOperation is called prior to forwarding an operation to the
+// backend. It returns whether the operation is permitted, and whether
+// the operation acts as a probe.
+func (ba *circuitBreakingBlobAccess) startOperation() (bool, bool) {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	if ba.state == circuitBreakerStateOpen && !ba.clock.Now().Before(ba.openedAt.Add(ba.coolDown)) {
+		ba.setState(circuitBreakerStateHalfOpen)
+	}
+	switch ba.state {
+	case circuitBreakerStateClosed:
+		return true, false
+	case circuitBreakerStateHalfOpen:
+		if ba.probesInFlight < ba.maximumProbes {
+			ba.probesInFlight++
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// finishOperation is called after an operation has completed, updating
+// the state of the circuit breaker based on its outcome.
+func (ba *circuitBreakingBlobAccess) finishOperation(isProbe bool, err error) {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	if isProbe {
+		ba.probesInFlight--
+	}
+	if !isBackendFailure(err) {
+		ba.consecutiveFailures = 0
+		if isProbe && ba.state == circuitBreakerStateHalfOpen {
+			ba.setState(circuitBreakerStateClosed)
+		}
+		return
+	}
+
+	ba.consecutiveFailures++
+	if (isProbe && ba.state == circuitBreakerStateHalfOpen) ||
+		(ba.state == circuitBreakerStateClosed && ba.consecutiveFailures >= ba.failureThreshold) {
+		ba.setState(circuitBreakerStateOpen)
+		ba.openedAt = ba.clock.Now()
+		ba.tripsTotal.Inc()
+	}
+}
+
+func newCircuitBreakerOpenError() error {
+	return status.Error(codes.Unavailable, "Circuit breaker is open, as the backend is failing")
+}
+
+func (ba *circuitBreakingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	permitted, isProbe := ba.startOperation()
+	if !permitted {
+		ba.getRejectedTotal.Inc()
+		return buffer.NewBufferFromError(newCircuitBreakerOpenError())
+	}
+	return buffer.WithErrorHandler(
+		ba.base.Get(ctx, digest),
+		&circuitBreakingErrorHandler{
+			blobAccess: ba,
+			isProbe:    isProbe,
+		})
+}
+
+func (ba *circuitBreakingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	permitted, isProbe := ba.startOperation()
+	if !permitted {
+		b.Discard()
+		ba.putRejectedTotal.Inc()
+		return newCircuitBreakerOpenError()
+	}
+	err := ba.base.Put(ctx, digest, b)
+	ba.finishOperation(isProbe, err)
+	return err
+}
+
+func (ba *circuitBreakingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	permitted, isProbe := ba.startOperation()
+	if !permitted {
+		ba.findMissingRejectedTotal.Inc()
+		return digest.EmptySet, newCircuitBreakerOpenError()
+	}
+	missing, err := ba.base.FindMissing(ctx, digests)
+	ba.finishOperation(isProbe, err)
+	return missing, err
+}
+
+type circuitBreakingErrorHandler struct {
+	blobAccess *circuitBreakingBlobAccess
+	isProbe    bool
+	err        error
+}
+
+func (eh *circuitBreakingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	eh.err = err
+	return nil, err
+}
+
+func (eh *circuitBreakingErrorHandler) Done() {
+	eh.blobAccess.finishOperation(eh.isProbe, eh.err)
+}
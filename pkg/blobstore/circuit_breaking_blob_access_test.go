@@ -0,0 +1,107 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCircuitBreakingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewCircuitBreakingBlobAccess(baseBlobAccess, clock, 3, time.Minute, 1, "cas")
+
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digests := blobDigest.ToSingletonSet()
+	openErr := status.Error(codes.Unavailable, "Circuit breaker is open, as the backend is failing")
+
+	// Errors returned by healthy backends should not cause the
+	// circuit breaker to open.
+	baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+		Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found"))).
+		Times(5)
+	for i := 0; i < 5; i++ {
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "Object not found"), err)
+	}
+
+	// Successes should reset the number of consecutive failures.
+	baseBlobAccess.EXPECT().FindMissing(ctx, digests).
+		Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline")).
+		Times(2)
+	for i := 0; i < 2; i++ {
+		_, err := blobAccess.FindMissing(ctx, digests)
+		testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Server offline"), err)
+	}
+	baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+	_, err := blobAccess.FindMissing(ctx, digests)
+	require.NoError(t, err)
+
+	// Three consecutive failures should cause the circuit breaker
+	// to open. Failures of buffers returned by Get() should also be
+	// taken into account.
+	baseBlobAccess.EXPECT().FindMissing(ctx, digests).
+		Return(digest.EmptySet, status.Error(codes.DeadlineExceeded, "Context deadline exceeded")).
+		Times(2)
+	for i := 0; i < 2; i++ {
+		_, err := blobAccess.FindMissing(ctx, digests)
+		testutil.RequireEqualStatus(t, status.Error(codes.DeadlineExceeded, "Context deadline exceeded"), err)
+	}
+	baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+		Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+	clock.EXPECT().Now().Return(time.Unix(1000, 0))
+	_, err = blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+	testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Server offline"), err)
+
+	// While open, requests should fail immediately.
+	clock.EXPECT().Now().Return(time.Unix(1059, 0)).Times(3)
+	_, err = blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+	testutil.RequireEqualStatus(t, openErr, err)
+	testutil.RequireEqualStatus(t, openErr, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	_, err = blobAccess.FindMissing(ctx, digests)
+	testutil.RequireEqualStatus(t, openErr, err)
+
+	// After the cool down period, a single probe should be let
+	// through. If it fails, the circuit breaker opens once again.
+	clock.EXPECT().Now().Return(time.Unix(1060, 0)).Times(2)
+	baseBlobAccess.EXPECT().FindMissing(ctx, digests).
+		Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+	_, err = blobAccess.FindMissing(ctx, digests)
+	testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Server offline"), err)
+
+	clock.EXPECT().Now().Return(time.Unix(1100, 0))
+	_, err = blobAccess.FindMissing(ctx, digests)
+	testutil.RequireEqualStatus(t, openErr, err)
+
+	// While a probe is in flight, other requests should be
+	// rejected. A successful probe closes the circuit breaker.
+	clock.EXPECT().Now().Return(time.Unix(1120, 0))
+	baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+			_, err := blobAccess.FindMissing(ctx, digests)
+			testutil.RequireEqualStatus(t, openErr, err)
+
+			data, err := b.ToByteSlice(100)
+			require.NoError(t, err)
+			require.Equal(t, []byte("Hello"), data)
+			return nil
+		})
+	require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+	_, err = blobAccess.FindMissing(ctx, digests)
+	require.NoError(t, err)
+}
@@ -2,6 +2,8 @@ package completenesschecking
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
@@ -13,71 +15,103 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-// findMissingQueue is a helper for calling BlobAccess.FindMissing() in
-// batches, as opposed to calling it for individual digests.
-type findMissingQueue struct {
-	context                   context.Context
-	instanceName              digest.InstanceName
-	contentAddressableStorage blobstore.BlobAccess
-	batchSize                 int
-
-	pending digest.SetBuilder
-}
-
 // deriveDigest converts a digest embedded into an action result from
 // the wire format to an in-memory representation. If that fails, we
 // assume that some data corruption has occurred. In that case, we
 // should destroy the action result.
-func (q *findMissingQueue) deriveDigest(blobDigest *remoteexecution.Digest) (digest.Digest, error) {
-	derivedDigest, err := q.instanceName.NewDigestFromProto(blobDigest)
+func deriveDigest(instanceName digest.InstanceName, blobDigest *remoteexecution.Digest) (digest.Digest, error) {
+	derivedDigest, err := instanceName.NewDigestFromProto(blobDigest)
 	if err != nil {
 		return digest.BadDigest, util.StatusWrapWithCode(err, codes.NotFound, "Action result contained malformed digest")
 	}
 	return derivedDigest, err
 }
 
+// digestCollector is a helper for gathering all digests referenced by
+// an action result. Duplicate digests are discarded, while the order in
+// which digests are encountered is preserved. This ensures that batches
+// passed to BlobAccess.FindMissing() are deterministic.
+type digestCollector struct {
+	instanceName digest.InstanceName
+	seen         map[digest.Digest]struct{}
+	digests      []digest.Digest
+}
+
+func newDigestCollector(instanceName digest.InstanceName) *digestCollector {
+	return &digestCollector{
+		instanceName: instanceName,
+		seen:         map[digest.Digest]struct{}{},
+	}
+}
+
 // Add a digest to the list of digests that are pending to be checked
 // for existence in the Content Addressable Storage.
-func (q *findMissingQueue) add(blobDigest *remoteexecution.Digest) error {
+func (dc *digestCollector) add(blobDigest *remoteexecution.Digest) error {
 	if blobDigest != nil {
-		derivedDigest, err := q.deriveDigest(blobDigest)
+		derivedDigest, err := deriveDigest(dc.instanceName, blobDigest)
 		if err != nil {
 			return err
 		}
-
-		if q.pending.Length() >= q.batchSize {
-			if err := q.finalize(); err != nil {
-				return err
-			}
-			q.pending = digest.NewSetBuilder()
+		if _, ok := dc.seen[derivedDigest]; !ok {
+			dc.seen[derivedDigest] = struct{}{}
+			dc.digests = append(dc.digests, derivedDigest)
 		}
-		q.pending.Add(derivedDigest)
 	}
 	return nil
 }
 
 // AddDirectory adds all digests contained with a directory to the list
 // of digests pending to be checked for existence.
-func (q *findMissingQueue) addDirectory(directory *remoteexecution.Directory) error {
+func (dc *digestCollector) addDirectory(directory *remoteexecution.Directory) error {
 	if directory == nil {
 		return nil
 	}
 	for _, child := range directory.Files {
-		if err := q.add(child.Digest); err != nil {
+		if err := dc.add(child.Digest); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// Finalize by checking the last batch of digests for existence.
-func (q *findMissingQueue) finalize() error {
-	missing, err := q.contentAddressableStorage.FindMissing(q.context, q.pending.Build())
-	if err != nil {
-		return util.StatusWrap(err, "Failed to determine existence of child objects")
+// runConcurrently calls a function for every index in [0, count),
+// using at most maximumConcurrency goroutines at a time. No further
+// calls are started after one of them has failed. The error returned
+// by the call with the lowest index is returned, so that the results
+// do not depend on the order in which the calls complete.
+func runConcurrently(count, maximumConcurrency int, f func(i int) error) error {
+	if count == 1 {
+		return f(0)
+	}
+
+	errs := make([]error, count)
+	var failed uint32
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maximumConcurrency)
+	for i := 0; i < count; i++ {
+		semaphore <- struct{}{}
+		if atomic.LoadUint32(&failed) != 0 {
+			<-semaphore
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			if err := f(i); err != nil {
+				errs[i] = err
+				atomic.StoreUint32(&failed, 1)
+			}
+		}(i)
 	}
-	if digest, ok := missing.First(); ok {
-		return status.Errorf(codes.NotFound, "Object %s referenced by the action result is not present in the Content Addressable Storage", digest)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -86,6 +120,7 @@ type completenessCheckingBlobAccess struct {
 	blobstore.BlobAccess
 	contentAddressableStorage blobstore.BlobAccess
 	batchSize                 int
+	maximumConcurrency        int
 	maximumMessageSizeBytes   int
 }
 
@@ -103,23 +138,25 @@ type completenessCheckingBlobAccess struct {
 // needs to be rebuilt. By calling it, Bazel indicates that all
 // associated output files must remain present during the build for
 // forward progress to be made.
-func NewCompletenessCheckingBlobAccess(actionCache, contentAddressableStorage blobstore.BlobAccess, batchSize, maximumMessageSizeBytes int) blobstore.BlobAccess {
+//
+// Tree objects referenced by the ActionResult are fetched in parallel.
+// Once all digests have been collected, they are checked for existence
+// by calling FindMissing() in batches of at most batchSize digests.
+// These batches are also processed in parallel. The number of
+// concurrent requests issued against the Content Addressable Storage
+// is bounded by maximumConcurrency.
+func NewCompletenessCheckingBlobAccess(actionCache, contentAddressableStorage blobstore.BlobAccess, batchSize, maximumConcurrency, maximumMessageSizeBytes int) blobstore.BlobAccess {
 	return &completenessCheckingBlobAccess{
 		BlobAccess:                actionCache,
 		contentAddressableStorage: contentAddressableStorage,
 		batchSize:                 batchSize,
+		maximumConcurrency:        maximumConcurrency,
 		maximumMessageSizeBytes:   maximumMessageSizeBytes,
 	}
 }
 
 func (ba *completenessCheckingBlobAccess) checkCompleteness(ctx context.Context, instanceName digest.InstanceName, actionResult *remoteexecution.ActionResult) error {
-	findMissingQueue := findMissingQueue{
-		context:                   ctx,
-		instanceName:              instanceName,
-		contentAddressableStorage: ba.contentAddressableStorage,
-		batchSize:                 ba.batchSize,
-		pending:                   digest.NewSetBuilder(),
-	}
+	digestCollector := newDigestCollector(instanceName)
 
 	// Iterate over all remoteexecution.Digest fields contained
 	// within the ActionResult. Check the existence of output
@@ -127,45 +164,74 @@ func (ba *completenessCheckingBlobAccess) checkCompleteness(ctx context.Context,
 	// later on. GetTree() may not necessarily cause those objects
 	// to be touched.
 	for _, outputFile := range actionResult.OutputFiles {
-		if err := findMissingQueue.add(outputFile.Digest); err != nil {
+		if err := digestCollector.add(outputFile.Digest); err != nil {
 			return err
 		}
 	}
+	treeDigests := make([]digest.Digest, 0, len(actionResult.OutputDirectories))
 	for _, outputDirectory := range actionResult.OutputDirectories {
-		if err := findMissingQueue.add(outputDirectory.TreeDigest); err != nil {
+		if err := digestCollector.add(outputDirectory.TreeDigest); err != nil {
+			return err
+		}
+		treeDigest, err := deriveDigest(instanceName, outputDirectory.TreeDigest)
+		if err != nil {
 			return err
 		}
+		treeDigests = append(treeDigests, treeDigest)
 	}
-	if err := findMissingQueue.add(actionResult.StdoutDigest); err != nil {
+	if err := digestCollector.add(actionResult.StdoutDigest); err != nil {
 		return err
 	}
-	if err := findMissingQueue.add(actionResult.StderrDigest); err != nil {
+	if err := digestCollector.add(actionResult.StderrDigest); err != nil {
 		return err
 	}
 
-	// Iterate over all remoteexecution.Digest fields contained
-	// within output directories (remoteexecution.Tree objects)
-	// referenced by the ActionResult.
-	for _, outputDirectory := range actionResult.OutputDirectories {
-		treeDigest, err := findMissingQueue.deriveDigest(outputDirectory.TreeDigest)
-		if err != nil {
-			return err
-		}
-		treeMessage, err := ba.contentAddressableStorage.Get(ctx, treeDigest).ToProto(&remoteexecution.Tree{}, ba.maximumMessageSizeBytes)
+	// Fetch all output directories (remoteexecution.Tree objects)
+	// referenced by the ActionResult in parallel, and iterate over
+	// all remoteexecution.Digest fields contained within them.
+	trees := make([]*remoteexecution.Tree, len(treeDigests))
+	if err := runConcurrently(len(treeDigests), ba.maximumConcurrency, func(i int) error {
+		treeMessage, err := ba.contentAddressableStorage.Get(ctx, treeDigests[i]).ToProto(&remoteexecution.Tree{}, ba.maximumMessageSizeBytes)
 		if err != nil {
-			return util.StatusWrapf(err, "Failed to fetch output directory %#v", outputDirectory.Path)
+			return util.StatusWrapf(err, "Failed to fetch output directory %#v", actionResult.OutputDirectories[i].Path)
 		}
-		tree := treeMessage.(*remoteexecution.Tree)
-		if err := findMissingQueue.addDirectory(tree.Root); err != nil {
+		trees[i] = treeMessage.(*remoteexecution.Tree)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, tree := range trees {
+		if err := digestCollector.addDirectory(tree.Root); err != nil {
 			return err
 		}
 		for _, child := range tree.Children {
-			if err := findMissingQueue.addDirectory(child); err != nil {
+			if err := digestCollector.addDirectory(child); err != nil {
 				return err
 			}
 		}
 	}
-	return findMissingQueue.finalize()
+
+	// Check the existence of all collected digests by calling
+	// FindMissing() on batches in parallel.
+	digests := digestCollector.digests
+	return runConcurrently((len(digests)+ba.batchSize-1)/ba.batchSize, ba.maximumConcurrency, func(i int) error {
+		end := (i + 1) * ba.batchSize
+		if end > len(digests) {
+			end = len(digests)
+		}
+		batch := digest.NewSetBuilder()
+		for _, blobDigest := range digests[i*ba.batchSize : end] {
+			batch.Add(blobDigest)
+		}
+		missing, err := ba.contentAddressableStorage.FindMissing(ctx, batch.Build())
+		if err != nil {
+			return util.StatusWrap(err, "Failed to determine existence of child objects")
+		}
+		if digest, ok := missing.First(); ok {
+			return status.Errorf(codes.NotFound, "Object %s referenced by the action result is not present in the Content Addressable Storage", digest)
+		}
+		return nil
+	})
 }
 
 func (ba *completenessCheckingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
@@ -26,6 +26,7 @@ func TestCompletenessCheckingBlobAccess(t *testing.T) {
 		actionCache,
 		contentAddressableStorage,
 		5,
+		2,
 		1000)
 
 	actionDigest := digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 123)
@@ -255,4 +256,118 @@ func TestCompletenessCheckingBlobAccess(t *testing.T) {
 		require.NoError(t, err)
 		testutil.RequireEqualProto(t, &actionResult, actualResult)
 	})
+
+	t.Run("Deduplication", func(t *testing.T) {
+		// Digests that are referenced multiple times should
+		// only be checked for existence once. Both Tree objects
+		// are fetched in parallel. Even though the order in
+		// which they complete is undefined, the batches passed
+		// to FindMissing() should be deterministic.
+		actionResult := remoteexecution.ActionResult{
+			OutputFiles: []*remoteexecution.OutputFile{
+				{
+					Path: "bazel-out/foo.o",
+					Digest: &remoteexecution.Digest{
+						Hash:      "38837949e2518a6e8a912ffb29942788",
+						SizeBytes: 10,
+					},
+				},
+			},
+			OutputDirectories: []*remoteexecution.OutputDirectory{
+				{
+					Path: "bazel-out/foo",
+					TreeDigest: &remoteexecution.Digest{
+						Hash:      "8b1a9953c4611296a827abf8c47804d7",
+						SizeBytes: 5,
+					},
+				},
+				{
+					Path: "bazel-out/bar",
+					TreeDigest: &remoteexecution.Digest{
+						Hash:      "7a3435d88e819881cbe9d430a340d157",
+						SizeBytes: 10,
+					},
+				},
+			},
+			StdoutDigest: &remoteexecution.Digest{
+				Hash:      "38837949e2518a6e8a912ffb29942788",
+				SizeBytes: 10,
+			},
+		}
+		dataIntegrityCallback1 := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback1.EXPECT().Call(true)
+		actionCache.EXPECT().Get(ctx, actionDigest).Return(
+			buffer.NewProtoBufferFromProto(
+				&actionResult,
+				buffer.BackendProvided(dataIntegrityCallback1.Call)))
+		dataIntegrityCallback2 := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback2.EXPECT().Call(true)
+		contentAddressableStorage.EXPECT().Get(
+			ctx,
+			digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5),
+		).Return(buffer.NewProtoBufferFromProto(&remoteexecution.Tree{
+			Root: &remoteexecution.Directory{
+				Files: []*remoteexecution.FileNode{
+					{
+						Digest: &remoteexecution.Digest{
+							Hash:      "eda14e187a768b38eda999457c9cca1e",
+							SizeBytes: 6,
+						},
+					},
+					{
+						Digest: &remoteexecution.Digest{
+							Hash:      "38837949e2518a6e8a912ffb29942788",
+							SizeBytes: 10,
+						},
+					},
+				},
+			},
+		}, buffer.BackendProvided(dataIntegrityCallback2.Call)))
+		dataIntegrityCallback3 := mock.NewMockDataIntegrityCallback(ctrl)
+		dataIntegrityCallback3.EXPECT().Call(true)
+		contentAddressableStorage.EXPECT().Get(
+			ctx,
+			digest.MustNewDigest("hello", "7a3435d88e819881cbe9d430a340d157", 10),
+		).Return(buffer.NewProtoBufferFromProto(&remoteexecution.Tree{
+			Root: &remoteexecution.Directory{
+				Files: []*remoteexecution.FileNode{
+					{
+						Digest: &remoteexecution.Digest{
+							Hash:      "eda14e187a768b38eda999457c9cca1e",
+							SizeBytes: 6,
+						},
+					},
+					{
+						Digest: &remoteexecution.Digest{
+							Hash:      "6c396013ff0ebff6a2a96cdc20a4ba4c",
+							SizeBytes: 5,
+						},
+					},
+					{
+						Digest: &remoteexecution.Digest{
+							Hash:      "136de6de72514772b9302d4776e5c3d2",
+							SizeBytes: 4,
+						},
+					},
+				},
+			},
+		}, buffer.BackendProvided(dataIntegrityCallback3.Call)))
+		contentAddressableStorage.EXPECT().FindMissing(
+			ctx,
+			digest.NewSetBuilder().
+				Add(digest.MustNewDigest("hello", "38837949e2518a6e8a912ffb29942788", 10)).
+				Add(digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)).
+				Add(digest.MustNewDigest("hello", "7a3435d88e819881cbe9d430a340d157", 10)).
+				Add(digest.MustNewDigest("hello", "eda14e187a768b38eda999457c9cca1e", 6)).
+				Add(digest.MustNewDigest("hello", "6c396013ff0ebff6a2a96cdc20a4ba4c", 5)).
+				Build(),
+		).Return(digest.EmptySet, nil)
+		contentAddressableStorage.EXPECT().FindMissing(
+			ctx,
+			digest.MustNewDigest("hello", "136de6de72514772b9302d4776e5c3d2", 4).ToSingletonSet(),
+		).Return(digest.MustNewDigest("hello", "136de6de72514772b9302d4776e5c3d2", 4).ToSingletonSet(), nil)
+
+		_, err := completenessCheckingBlobAccess.Get(ctx, actionDigest).ToProto(&remoteexecution.ActionResult{}, 1000)
+		require.Equal(t, err, status.Error(codes.NotFound, "Object 136de6de72514772b9302d4776e5c3d2-4-hello referenced by the action result is not present in the Content Addressable Storage"))
+	})
 }
@@ -0,0 +1,120 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/buildbarn/bb-storage/pkg/zstd"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	compressionMethodNone      = 0
+	compressionMethodZstandard = 1
+
+	// CompressionOverheadSizeBytes is the maximum number of bytes by
+	// which objects grow when stored by CompressingBlobAccess.
+	CompressionOverheadSizeBytes = 1
+
+	// compressionMaximumWindowSizeBytes is the maximum window size
+	// of Zstandard frames that are decompressed. It is larger than
+	// the window size used by zstd.NewWriterLevel(), so that objects
+	// remain readable if the compressor is changed.
+	compressionMaximumWindowSizeBytes = 8 * 1024 * 1024
+)
+
+type compressingBlobAccess struct {
+	base              BlobAccess
+	readBufferFactory ReadBufferFactory
+	minimumSizeBytes  int64
+	maximumSizeBytes  int
+	level             int
+}
+
+// NewCompressingBlobAccess creates a decorator for BlobAccess that
+// compresses objects using Zstandard before they are written to the
+// backend, and decompresses them when read. Every stored object is
+// prefixed with a byte indicating whether it is compressed. Objects
+// smaller than minimumSizeBytes, and objects that do not shrink when
+// compressed, are stored as is, as compressing them would only waste
+// CPU time.
+//
+// Objects are compressed in memory, as backends need to know the size
+// of objects before they are written. Objects larger than
+// maximumSizeBytes are rejected. The backend should be created with a
+// ReadBufferFactory obtained through NewUnvalidatedReadBufferFactory(),
+// as compressed objects cannot be validated against the digest of the
+// object.
+//
+// The compression level is passed on to zstd.NewWriterLevel(), and
+// must be between zstd.MinimumLevel and zstd.MaximumLevel.
+func NewCompressingBlobAccess(base BlobAccess, readBufferFactory ReadBufferFactory, minimumSizeBytes int64, maximumSizeBytes, level int) BlobAccess {
+	return &compressingBlobAccess{
+		base:              base,
+		readBufferFactory: readBufferFactory,
+		minimumSizeBytes:  minimumSizeBytes,
+		maximumSizeBytes:  maximumSizeBytes,
+		level:             level,
+	}
+}
+
+func (ba *compressingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	stored, err := ba.base.Get(ctx, blobDigest).ToByteSlice(ba.maximumSizeBytes + CompressionOverheadSizeBytes)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	if len(stored) < CompressionOverheadSizeBytes {
+		return buffer.NewBufferFromError(status.Error(codes.Internal, "Stored object does not contain a compression method"))
+	}
+	switch compressionMethod := stored[0]; compressionMethod {
+	case compressionMethodNone:
+		return ba.readBufferFactory.NewBufferFromByteSlice(blobDigest, stored[CompressionOverheadSizeBytes:], func(dataIsValid bool) {})
+	case compressionMethodZstandard:
+		data, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(stored[CompressionOverheadSizeBytes:]), compressionMaximumWindowSizeBytes))
+		if err != nil {
+			return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.Internal, "Failed to decompress object"))
+		}
+		return ba.readBufferFactory.NewBufferFromByteSlice(blobDigest, data, func(dataIsValid bool) {})
+	default:
+		return buffer.NewBufferFromError(status.Errorf(codes.Internal, "Stored object uses unknown compression method %d", compressionMethod))
+	}
+}
+
+func (ba *compressingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	data, err := b.ToByteSlice(ba.maximumSizeBytes)
+	if err != nil {
+		return err
+	}
+
+	if int64(len(data)) >= ba.minimumSizeBytes {
+		var compressed bytes.Buffer
+		compressed.WriteByte(compressionMethodZstandard)
+		w, err := zstd.NewWriterLevel(&compressed, ba.level)
+		if err != nil {
+			return util.StatusWrapWithCode(err, codes.Internal, "Failed to create compressor")
+		}
+		if _, err := w.Write(data); err != nil {
+			return util.StatusWrapWithCode(err, codes.Internal, "Failed to compress object")
+		}
+		if err := w.Close(); err != nil {
+			return util.StatusWrapWithCode(err, codes.Internal, "Failed to compress object")
+		}
+		if compressed.Len() < len(data)+CompressionOverheadSizeBytes {
+			return ba.base.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(compressed.Bytes()))
+		}
+	}
+
+	stored := make([]byte, 0, len(data)+CompressionOverheadSizeBytes)
+	stored = append(append(stored, compressionMethodNone), data...)
+	return ba.base.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(stored))
+}
+
+func (ba *compressingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	return ba.base.FindMissing(ctx, digests)
+}
@@ -0,0 +1,117 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/buildbarn/bb-storage/pkg/zstd"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCompressingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewCompressingBlobAccess(baseBlobAccess, blobstore.CASReadBufferFactory, 100, 10000, zstd.MaximumLevel)
+
+	compressibleData := bytes.Repeat([]byte("Hello world "), 500)
+	compressibleDigest := digest.MustNewDigest("hello", "6453567b2983e547c844cadea65a397f", 6000)
+	incompressibleData := make([]byte, 1000)
+	rand.New(rand.NewSource(123)).Read(incompressibleData)
+	blobDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("PutAndGetCompressed", func(t *testing.T) {
+		var stored []byte
+		baseBlobAccess.EXPECT().Put(ctx, compressibleDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				var err error
+				stored, err = b.ToByteSlice(10000)
+				require.NoError(t, err)
+				return nil
+			})
+		require.NoError(t, blobAccess.Put(ctx, compressibleDigest, buffer.NewValidatedBufferFromByteSlice(compressibleData)))
+		require.Equal(t, byte(1), stored[0])
+		require.Less(t, len(stored), len(compressibleData))
+
+		// Reading the object back should yield the original
+		// data, which is validated against the digest.
+		baseBlobAccess.EXPECT().Get(ctx, compressibleDigest).Return(buffer.NewValidatedBufferFromByteSlice(stored))
+		data, err := blobAccess.Get(ctx, compressibleDigest).ToByteSlice(10000)
+		require.NoError(t, err)
+		require.Equal(t, compressibleData, data)
+	})
+
+	t.Run("PutBelowMinimumSize", func(t *testing.T) {
+		// Small objects should be stored uncompressed.
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(10000)
+				require.NoError(t, err)
+				require.Equal(t, []byte("\x00Hello world"), data)
+				return nil
+			})
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+
+	t.Run("PutIncompressible", func(t *testing.T) {
+		// Objects that do not shrink when compressed should be
+		// stored uncompressed.
+		incompressibleDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 1000)
+		baseBlobAccess.EXPECT().Put(ctx, incompressibleDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(10000)
+				require.NoError(t, err)
+				require.Equal(t, append([]byte{0}, incompressibleData...), data)
+				return nil
+			})
+		require.NoError(t, blobAccess.Put(ctx, incompressibleDigest, buffer.NewValidatedBufferFromByteSlice(incompressibleData)))
+	})
+
+	t.Run("PutTooLarge", func(t *testing.T) {
+		largeDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 20000)
+		err := blobAccess.Put(ctx, largeDigest, buffer.NewValidatedBufferFromByteSlice(make([]byte, 20000)))
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Buffer is 20000 bytes in size, while a maximum of 10000 bytes is permitted"), err)
+	})
+
+	t.Run("GetUncompressed", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("\x00Hello world")))
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10000)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("GetEmpty", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice(nil))
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10000)
+		testutil.RequireEqualStatus(t, status.Error(codes.Internal, "Stored object does not contain a compression method"), err)
+	})
+
+	t.Run("GetUnknownCompressionMethod", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("\x07Hello world")))
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10000)
+		testutil.RequireEqualStatus(t, status.Error(codes.Internal, "Stored object uses unknown compression method 7"), err)
+	})
+
+	t.Run("GetCorrupted", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("\x01Hello world")))
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10000)
+		testutil.RequireEqualStatus(t, status.Error(codes.Internal, "Failed to decompress object: Zstandard frame has invalid magic number 0x6c6c6548"), err)
+	})
+
+	t.Run("GetBackendFailure", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(10000)
+		testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Server offline"), err)
+	})
+}
@@ -1,17 +1,28 @@
 package configuration
 
 import (
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/auth"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/completenesschecking"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/grpcclients"
+	"github.com/buildbarn/bb-storage/pkg/clock"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/buildbarn/bb-storage/pkg/grpc"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/util"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// completenessCheckingMaximumConcurrency is the maximum number of
+// Tree objects that CompletenessCheckingBlobAccess fetches, or the
+// maximum number of FindMissing() batches that it issues against the
+// Content Addressable Storage in parallel for a single ActionResult.
+const completenessCheckingMaximumConcurrency = 10
+
 type acBlobAccessCreator struct {
 	acBlobReplicatorCreator
 
@@ -23,8 +34,11 @@ type acBlobAccessCreator struct {
 // NewACBlobAccessCreator creates a BlobAccessCreator that can be
 // provided to NewBlobAccessFromConfiguration() to construct a
 // BlobAccess that is suitable for accessing the Action Cache.
-func NewACBlobAccessCreator(contentAddressableStorage BlobAccessInfo, grpcClientFactory grpc.ClientFactory, maximumMessageSizeBytes int) BlobAccessCreator {
+func NewACBlobAccessCreator(registry *Registry, contentAddressableStorage BlobAccessInfo, grpcClientFactory grpc.ClientFactory, maximumMessageSizeBytes int) BlobAccessCreator {
 	return &acBlobAccessCreator{
+		acBlobReplicatorCreator: acBlobReplicatorCreator{
+			registry: registry,
+		},
 		contentAddressableStorage: contentAddressableStorage,
 		grpcClientFactory:         grpcClientFactory,
 		maximumMessageSizeBytes:   maximumMessageSizeBytes,
@@ -45,6 +59,48 @@ func (bac *acBlobAccessCreator) GetStorageTypeName() string {
 
 func (bac *acBlobAccessCreator) NewCustomBlobAccess(configuration *pb.BlobAccessConfiguration) (BlobAccessInfo, string, error) {
 	switch backend := configuration.Backend.(type) {
+	case *pb.BlobAccessConfiguration_ActionResultExpiring:
+		var maximumAge time.Duration
+		if backend.ActionResultExpiring.MaximumAge != nil {
+			if err := backend.ActionResultExpiring.MaximumAge.CheckValid(); err != nil {
+				return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to obtain maximum age")
+			}
+			maximumAge = backend.ActionResultExpiring.MaximumAge.AsDuration()
+		}
+		var minimumTimestamp time.Time
+		if backend.ActionResultExpiring.MinimumTimestamp != nil {
+			if err := backend.ActionResultExpiring.MinimumTimestamp.CheckValid(); err != nil {
+				return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to obtain minimum timestamp")
+			}
+			minimumTimestamp = backend.ActionResultExpiring.MinimumTimestamp.AsTime()
+		}
+		base, err := NewNestedBlobAccess(backend.ActionResultExpiring.Backend, bac)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		return BlobAccessInfo{
+			BlobAccess: blobstore.NewActionResultExpiringBlobAccess(
+				base.BlobAccess,
+				clock.SystemClock,
+				bac.maximumMessageSizeBytes,
+				maximumAge,
+				minimumTimestamp),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "action_result_expiring", nil
+	case *pb.BlobAccessConfiguration_ActionResultValidating:
+		base, err := NewNestedBlobAccess(backend.ActionResultValidating.Backend, bac)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		return BlobAccessInfo{
+			BlobAccess: blobstore.NewActionResultValidatingBlobAccess(
+				base.BlobAccess,
+				bac.maximumMessageSizeBytes,
+				int(backend.ActionResultValidating.MaximumOutputs),
+				backend.ActionResultValidating.MaximumInlineSizeBytes,
+				backend.ActionResultValidating.AllowNonZeroExitCodes),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "action_result_validating", nil
 	case *pb.BlobAccessConfiguration_CompletenessChecking:
 		base, err := NewNestedBlobAccess(backend.CompletenessChecking, bac)
 		if err != nil {
@@ -55,9 +111,25 @@ func (bac *acBlobAccessCreator) NewCustomBlobAccess(configuration *pb.BlobAccess
 				base.BlobAccess,
 				bac.contentAddressableStorage.BlobAccess,
 				blobstore.RecommendedFindMissingDigestsCount,
+				completenessCheckingMaximumConcurrency,
 				bac.maximumMessageSizeBytes),
 			DigestKeyFormat: base.DigestKeyFormat.Combine(bac.contentAddressableStorage.DigestKeyFormat),
 		}, "completeness_checking", nil
+	case *pb.BlobAccessConfiguration_WriteOnce:
+		overwriteAuthorizer, err := auth.NewIdentityListAuthorizerFromConfiguration(
+			backend.WriteOnce.ClientIdentification,
+			backend.WriteOnce.OverwriteIdentities)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		base, err := NewNestedBlobAccess(backend.WriteOnce.Backend, bac)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		return BlobAccessInfo{
+			BlobAccess:      blobstore.NewWriteOnceBlobAccess(base.BlobAccess, overwriteAuthorizer),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "write_once", nil
 	case *pb.BlobAccessConfiguration_Grpc:
 		client, err := bac.grpcClientFactory.NewClientFromConfiguration(backend.Grpc)
 		if err != nil {
@@ -9,13 +9,23 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-type acBlobReplicatorCreator struct{}
+type acBlobReplicatorCreator struct {
+	registry *Registry
+}
+
+// NewACBlobReplicatorCreator creates a BlobReplicatorCreator that can
+// be provided to NewBlobReplicatorFromConfiguration() to construct a
+// BlobReplicator that is suitable for replicating Action Cache objects.
+func NewACBlobReplicatorCreator(registry *Registry) BlobReplicatorCreator {
+	return acBlobReplicatorCreator{
+		registry: registry,
+	}
+}
+
+func (brc acBlobReplicatorCreator) GetRegistry() *Registry {
+	return brc.registry
+}
 
 func (brc acBlobReplicatorCreator) NewCustomBlobReplicator(configuration *pb.BlobReplicatorConfiguration, source blobstore.BlobAccess, sink BlobAccessInfo) (replication.BlobReplicator, error) {
 	return nil, status.Error(codes.InvalidArgument, "Configuration did not contain a supported replicator")
 }
-
-// ACBlobReplicatorCreator is a BlobReplicatorCreator that can be
-// provided to NewBlobReplicatorFromConfiguration() to construct a
-// BlobReplicator that is suitable for replicating Action Cache objects.
-var ACBlobReplicatorCreator BlobReplicatorCreator = acBlobReplicatorCreator{}
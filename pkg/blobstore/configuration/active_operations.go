@@ -0,0 +1,17 @@
+package configuration
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func (reg *Registry) serveActiveOperations(w http.ResponseWriter, r *http.Request) {
+	if reg.activeOperationTracker == nil {
+		http.Error(w, "Tracking of active operations is not enabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	e.Encode(reg.activeOperationTracker.GetActiveOperations())
+}
@@ -0,0 +1,48 @@
+package configuration
+
+import (
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type assetBlobAccessCreator struct {
+	assetBlobReplicatorCreator
+}
+
+// NewAssetBlobAccessCreator creates a BlobAccessCreator that can be
+// provided to NewBlobAccessFromConfiguration() to construct a
+// BlobAccess that is suitable for accessing the Asset Store. The Asset
+// Store contains mappings from URIs and qualifiers to digests of
+// objects in the Content Addressable Storage, as pushed through the
+// Remote Asset API.
+func NewAssetBlobAccessCreator(registry *Registry) BlobAccessCreator {
+	return &assetBlobAccessCreator{
+		assetBlobReplicatorCreator: assetBlobReplicatorCreator{
+			registry: registry,
+		},
+	}
+}
+
+func (bac *assetBlobAccessCreator) GetBaseDigestKeyFormat() digest.KeyFormat {
+	return digest.KeyWithInstance
+}
+
+func (bac *assetBlobAccessCreator) GetReadBufferFactory() blobstore.ReadBufferFactory {
+	return blobstore.AssetReadBufferFactory
+}
+
+func (bac *assetBlobAccessCreator) GetStorageTypeName() string {
+	return "asset"
+}
+
+func (bac *assetBlobAccessCreator) NewCustomBlobAccess(configuration *pb.BlobAccessConfiguration) (BlobAccessInfo, string, error) {
+	return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Configuration did not contain a supported storage backend")
+}
+
+func (bac *assetBlobAccessCreator) WrapTopLevelBlobAccess(blobAccess blobstore.BlobAccess) blobstore.BlobAccess {
+	return blobAccess
+}
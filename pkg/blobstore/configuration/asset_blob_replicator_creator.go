@@ -0,0 +1,32 @@
+package configuration
+
+import (
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type assetBlobReplicatorCreator struct {
+	registry *Registry
+}
+
+// NewAssetBlobReplicatorCreator creates a BlobReplicatorCreator that
+// can be provided to NewBlobReplicatorFromConfiguration() to construct
+// a BlobReplicator that is suitable for replicating Asset Store
+// objects.
+func NewAssetBlobReplicatorCreator(registry *Registry) BlobReplicatorCreator {
+	return assetBlobReplicatorCreator{
+		registry: registry,
+	}
+}
+
+func (brc assetBlobReplicatorCreator) GetRegistry() *Registry {
+	return brc.registry
+}
+
+func (brc assetBlobReplicatorCreator) NewCustomBlobReplicator(configuration *pb.BlobReplicatorConfiguration, source blobstore.BlobAccess, sink BlobAccessInfo) (replication.BlobReplicator, error) {
+	return nil, status.Error(codes.InvalidArgument, "Configuration did not contain a supported replicator")
+}
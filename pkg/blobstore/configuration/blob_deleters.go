@@ -0,0 +1,94 @@
+package configuration
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// blobDeleterFunc is a BlobDeleter that is backed by a function.
+type blobDeleterFunc func(ctx context.Context, blobDigest digest.Digest) error
+
+func (f blobDeleterFunc) Delete(ctx context.Context, blobDigest digest.Digest) error {
+	return f(ctx, blobDigest)
+}
+
+// deletingBlobAccess is a decorator for BlobAccess that adds support
+// for deleting blobs. It is used by backends that are composed of
+// multiple objects, such as "local", where the outermost decorator
+// does not forward calls to Delete().
+type deletingBlobAccess struct {
+	blobstore.BlobAccess
+	blobstore.BlobDeleter
+}
+
+type registeredBlobDeleter struct {
+	getPath     func() string
+	blobDeleter blobstore.BlobDeleter
+}
+
+func (reg *Registry) registerBlobDeleter(storageType string, getPath func() string, blobDeleter blobstore.BlobDeleter) {
+	reg.blobDeletersLock.Lock()
+	defer reg.blobDeletersLock.Unlock()
+	reg.blobDeleters[storageType] = append(reg.blobDeleters[storageType], registeredBlobDeleter{
+		getPath:     getPath,
+		blobDeleter: blobDeleter,
+	})
+}
+
+// registerNonDeletingBackend records that a storage backend holds data,
+// but is not capable of deleting individual blobs. This causes
+// DeleteBlob() to fail for the storage type, as it would otherwise
+// report success while a copy of the blob remains.
+func (reg *Registry) registerNonDeletingBackend(storageType string, getPath func() string) {
+	reg.blobDeletersLock.Lock()
+	defer reg.blobDeletersLock.Unlock()
+	reg.nonDeletingBackends[storageType] = append(reg.nonDeletingBackends[storageType], getPath)
+}
+
+// DeleteBlob removes a blob from all storage backends of a given
+// storage type, returning the topology paths of the backends from
+// which it was deleted. If any of the backends holding data is not
+// capable of deleting blobs (e.g., "grpc"), no attempt is
+// made to delete the blob, and FAILED_PRECONDITION is returned.
+//
+// Deletion is attempted against all backends, even if some of them
+// fail, so that as much data is purged as possible. The first error
+// is returned in that case.
+func (reg *Registry) DeleteBlob(ctx context.Context, storageType string, blobDigest digest.Digest) ([]string, error) {
+	reg.blobDeletersLock.Lock()
+	registered := append([]registeredBlobDeleter(nil), reg.blobDeleters[storageType]...)
+	nonDeleting := append([]func() string(nil), reg.nonDeletingBackends[storageType]...)
+	reg.blobDeletersLock.Unlock()
+	if len(nonDeleting) > 0 {
+		nonDeletingPaths := make([]string, 0, len(nonDeleting))
+		for _, getPath := range nonDeleting {
+			nonDeletingPaths = append(nonDeletingPaths, strconv.Quote(getPath()))
+		}
+		return nil, status.Errorf(codes.FailedPrecondition, "Storage backends %s of storage type %#v don't support deleting blobs", strings.Join(nonDeletingPaths, ", "), storageType)
+	}
+	if len(registered) == 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "None of the storage backends of storage type %#v support deleting blobs", storageType)
+	}
+
+	var paths []string
+	var firstErr error
+	for _, r := range registered {
+		path := r.getPath()
+		if err := r.blobDeleter.Delete(ctx, blobDigest); err != nil {
+			if firstErr == nil {
+				firstErr = util.StatusWrapf(err, "Backend %#v", path)
+			}
+		} else {
+			paths = append(paths, path)
+		}
+	}
+	return paths, firstErr
+}
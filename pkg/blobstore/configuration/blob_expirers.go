@@ -0,0 +1,81 @@
+package configuration
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type registeredBlobExpirer struct {
+	getPath     func() string
+	blobExpirer blobstore.BlobExpirer
+}
+
+func (reg *Registry) registerBlobExpirer(storageType string, getPath func() string, blobExpirer blobstore.BlobExpirer) {
+	reg.blobExpirersLock.Lock()
+	defer reg.blobExpirersLock.Unlock()
+	reg.blobExpirers[storageType] = append(reg.blobExpirers[storageType], registeredBlobExpirer{
+		getPath:     getPath,
+		blobExpirer: blobExpirer,
+	})
+}
+
+// registerNonExpirableBackend records that a storage backend stores
+// objects under digests that are not referenced by clients directly.
+// For example, "chunking" stores the chunks of large objects in its
+// backend, while the lists of chunks are stored in an Indirect Content
+// Addressable Storage. This causes ExpireBlobs() to fail for the
+// storage type, as it would otherwise remove objects that are only
+// reachable through such lists.
+func (reg *Registry) registerNonExpirableBackend(storageType string, getPath func() string) {
+	reg.blobExpirersLock.Lock()
+	defer reg.blobExpirersLock.Unlock()
+	reg.nonExpirableBackends[storageType] = append(reg.nonExpirableBackends[storageType], getPath)
+}
+
+// ExpireBlobs removes objects that were last modified before a given
+// point in time and are not retained from all storage backends of a
+// given storage type that support expiring objects, returning the
+// total number of objects removed. Backends that don't support
+// expiring objects (e.g., "local") are left untouched. If any of the
+// backends stores objects that are not referenced by clients directly
+// (e.g., "chunking"), no objects are removed, and FAILED_PRECONDITION
+// is returned.
+//
+// Expiration is attempted against all backends, even if some of them
+// fail. The first error is returned in that case.
+func (reg *Registry) ExpireBlobs(ctx context.Context, storageType string, modifiedBefore time.Time, isRetained func(blobDigest digest.Digest) bool) (int64, error) {
+	reg.blobExpirersLock.Lock()
+	registered := append([]registeredBlobExpirer(nil), reg.blobExpirers[storageType]...)
+	nonExpirable := append([]func() string(nil), reg.nonExpirableBackends[storageType]...)
+	reg.blobExpirersLock.Unlock()
+	if len(nonExpirable) > 0 {
+		nonExpirablePaths := make([]string, 0, len(nonExpirable))
+		for _, getPath := range nonExpirable {
+			nonExpirablePaths = append(nonExpirablePaths, strconv.Quote(getPath()))
+		}
+		return 0, status.Errorf(codes.FailedPrecondition, "Storage backends %s of storage type %#v store objects that are not referenced by clients directly, meaning that their reachability cannot be determined", strings.Join(nonExpirablePaths, ", "), storageType)
+	}
+	if len(registered) == 0 {
+		return 0, status.Errorf(codes.FailedPrecondition, "None of the storage backends of storage type %#v support expiring blobs", storageType)
+	}
+
+	expired := int64(0)
+	var firstErr error
+	for _, r := range registered {
+		n, err := r.blobExpirer.ExpireBlobs(ctx, modifiedBefore, isRetained)
+		expired += n
+		if err != nil && firstErr == nil {
+			firstErr = util.StatusWrapf(err, "Backend %#v", r.getPath())
+		}
+	}
+	return expired, firstErr
+}
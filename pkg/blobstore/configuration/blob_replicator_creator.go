@@ -11,6 +11,9 @@ import (
 // BlobReplicator of a specific kind (e.g., Action Cache, Content
 // Addressable Storage).
 type BlobReplicatorCreator interface {
+	// GetRegistry() returns the Registry against which storage
+	// backends and replicators are registered after construction.
+	GetRegistry() *Registry
 	// NewCustomBlobReplicator() can be used as a fallback to create
 	// BlobReplicator instances that only apply to this storage
 	// type. For example, sending replication requests over gRPC is
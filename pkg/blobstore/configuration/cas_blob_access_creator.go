@@ -1,14 +1,15 @@
 package configuration
 
 import (
-	"net/http"
-
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/chunking"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/grpcclients"
 	"github.com/buildbarn/bb-storage/pkg/cloud/aws"
+	"github.com/buildbarn/bb-storage/pkg/cloud/gcp"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/buildbarn/bb-storage/pkg/grpc"
+	bb_http "github.com/buildbarn/bb-storage/pkg/http"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/util"
 	"github.com/google/uuid"
@@ -17,6 +18,10 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+// gcsReadOnlyScope is the OAuth2 scope that is requested when loading
+// objects referenced by the ICAS from Google Cloud Storage.
+const gcsReadOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
 type casBlobAccessCreator struct {
 	casBlobReplicatorCreator
 
@@ -27,9 +32,10 @@ type casBlobAccessCreator struct {
 // provided to NewBlobAccessFromConfiguration() to construct a
 // BlobAccess that is suitable for accessing the Content Addressable
 // Storage.
-func NewCASBlobAccessCreator(grpcClientFactory grpc.ClientFactory, maximumMessageSizeBytes int) BlobAccessCreator {
+func NewCASBlobAccessCreator(registry *Registry, grpcClientFactory grpc.ClientFactory, maximumMessageSizeBytes int) BlobAccessCreator {
 	return &casBlobAccessCreator{
 		casBlobReplicatorCreator: casBlobReplicatorCreator{
+			registry:          registry,
 			grpcClientFactory: grpcClientFactory,
 		},
 		maximumMessageSizeBytes: maximumMessageSizeBytes,
@@ -50,6 +56,63 @@ func (bac *casBlobAccessCreator) GetStorageTypeName() string {
 
 func (bac *casBlobAccessCreator) NewCustomBlobAccess(configuration *pb.BlobAccessConfiguration) (BlobAccessInfo, string, error) {
 	switch backend := configuration.Backend.(type) {
+	case *pb.BlobAccessConfiguration_Chunking:
+		minimumChunkSizeBytes := backend.Chunking.MinimumChunkSizeBytes
+		averageChunkSizeBytes := backend.Chunking.AverageChunkSizeBytes
+		maximumChunkSizeBytes := backend.Chunking.MaximumChunkSizeBytes
+		if minimumChunkSizeBytes <= 0 || minimumChunkSizeBytes > averageChunkSizeBytes || averageChunkSizeBytes > maximumChunkSizeBytes {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Chunk sizes must satisfy 0 < minimum <= average <= maximum")
+		}
+		if averageChunkSizeBytes&(averageChunkSizeBytes-1) != 0 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Average chunk size must be a power of two")
+		}
+		if maximumChunkSizeBytes > backend.Chunking.CutoffSizeBytes {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Maximum chunk size may not exceed the cutoff size")
+		}
+		base, err := NewNestedBlobAccess(backend.Chunking.Backend, bac)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		// Lists of chunks are stored as Reference messages in an
+		// Indirect Content Addressable Storage (ICAS).
+		indirectContentAddressableStorage, err := NewNestedBlobAccess(
+			backend.Chunking.IndirectContentAddressableStorage,
+			NewICASBlobAccessCreator(
+				bac.registry,
+				bac.grpcClientFactory,
+				bac.maximumMessageSizeBytes))
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		return BlobAccessInfo{
+			BlobAccess: chunking.NewChunkingBlobAccess(
+				base.BlobAccess,
+				indirectContentAddressableStorage.BlobAccess,
+				backend.Chunking.CutoffSizeBytes,
+				int(minimumChunkSizeBytes),
+				int(averageChunkSizeBytes),
+				int(maximumChunkSizeBytes),
+				bac.maximumMessageSizeBytes),
+			DigestKeyFormat: base.DigestKeyFormat.Combine(indirectContentAddressableStorage.DigestKeyFormat),
+		}, "chunking", nil
+	case *pb.BlobAccessConfiguration_DigestTranslating:
+		base, err := NewNestedBlobAccess(backend.DigestTranslating.Backend, bac)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		for _, digestFunction := range backend.DigestTranslating.DigestFunctions {
+			if _, err := digest.EmptyInstanceName.GetDigestFunction(digestFunction); err != nil {
+				return BlobAccessInfo{}, "", util.StatusWrapf(err, "Invalid digest function %s", digestFunction)
+			}
+		}
+		translationIndex, err := digest.NewTranslationIndexFromConfiguration(backend.DigestTranslating.TranslationIndex, base.DigestKeyFormat, "DigestTranslatingBlobAccess")
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		return BlobAccessInfo{
+			BlobAccess:      blobstore.NewDigestTranslatingBlobAccess(base.BlobAccess, translationIndex, backend.DigestTranslating.DigestFunctions),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "digest_translating", nil
 	case *pb.BlobAccessConfiguration_ExistenceCaching:
 		base, err := NewNestedBlobAccess(backend.ExistenceCaching.Backend, bac)
 		if err != nil {
@@ -59,8 +122,15 @@ func (bac *casBlobAccessCreator) NewCustomBlobAccess(configuration *pb.BlobAcces
 		if err != nil {
 			return BlobAccessInfo{}, "", err
 		}
+		var missingCache *digest.ExistenceCache
+		if missingCacheConfiguration := backend.ExistenceCaching.MissingCache; missingCacheConfiguration != nil {
+			missingCache, err = digest.NewExistenceCacheFromConfiguration(missingCacheConfiguration, base.DigestKeyFormat, "ExistenceCachingBlobAccessMissing")
+			if err != nil {
+				return BlobAccessInfo{}, "", err
+			}
+		}
 		return BlobAccessInfo{
-			BlobAccess:      blobstore.NewExistenceCachingBlobAccess(base.BlobAccess, existenceCache),
+			BlobAccess:      blobstore.NewExistenceCachingBlobAccess(base.BlobAccess, existenceCache, missingCache),
 			DigestKeyFormat: base.DigestKeyFormat,
 		}, "existence_caching", nil
 	case *pb.BlobAccessConfiguration_Grpc:
@@ -84,6 +154,7 @@ func (bac *casBlobAccessCreator) NewCustomBlobAccess(configuration *pb.BlobAcces
 		base, err := NewNestedBlobAccess(
 			backend.ReferenceExpanding.IndirectContentAddressableStorage,
 			NewICASBlobAccessCreator(
+				bac.registry,
 				bac.grpcClientFactory,
 				bac.maximumMessageSizeBytes))
 		if err != nil {
@@ -93,11 +164,23 @@ func (bac *casBlobAccessCreator) NewCustomBlobAccess(configuration *pb.BlobAcces
 		if err != nil {
 			return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to create AWS session")
 		}
+		httpClient, err := bb_http.NewClientFromConfiguration(backend.ReferenceExpanding.HttpClient)
+		if err != nil {
+			return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to create HTTP client")
+		}
+		gcsHTTPClient := httpClient
+		if gcpClientOptions := backend.ReferenceExpanding.GcpClientOptions; gcpClientOptions != nil {
+			gcsHTTPClient, err = gcp.NewHTTPClientFromConfiguration(gcpClientOptions, httpClient, gcsReadOnlyScope)
+			if err != nil {
+				return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to create Google Cloud Storage HTTP client")
+			}
+		}
 		return BlobAccessInfo{
 			BlobAccess: blobstore.NewReferenceExpandingBlobAccess(
 				base.BlobAccess,
-				http.DefaultClient,
+				httpClient,
 				s3.New(sess),
+				gcsHTTPClient,
 				bac.maximumMessageSizeBytes),
 			DigestKeyFormat: base.DigestKeyFormat,
 		}, "reference_expanding", nil
@@ -11,6 +11,7 @@ import (
 )
 
 type casBlobReplicatorCreator struct {
+	registry          *Registry
 	grpcClientFactory grpc.ClientFactory
 }
 
@@ -18,12 +19,17 @@ type casBlobReplicatorCreator struct {
 // be provided to NewBlobReplicatorFromConfiguration() to construct a
 // BlobReplicator that is suitable for replicating Content Addressable
 // Storage objects.
-func NewCASBlobReplicatorCreator(grpcClientFactory grpc.ClientFactory) BlobReplicatorCreator {
+func NewCASBlobReplicatorCreator(registry *Registry, grpcClientFactory grpc.ClientFactory) BlobReplicatorCreator {
 	return &casBlobReplicatorCreator{
+		registry:          registry,
 		grpcClientFactory: grpcClientFactory,
 	}
 }
 
+func (brc *casBlobReplicatorCreator) GetRegistry() *Registry {
+	return brc.registry
+}
+
 func (brc *casBlobReplicatorCreator) NewCustomBlobReplicator(configuration *pb.BlobReplicatorConfiguration, source blobstore.BlobAccess, sink BlobAccessInfo) (replication.BlobReplicator, error) {
 	switch mode := configuration.Mode.(type) {
 	case *pb.BlobReplicatorConfiguration_Remote:
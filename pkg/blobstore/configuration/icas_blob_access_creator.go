@@ -22,8 +22,11 @@ type icasBlobAccessCreator struct {
 // provided to NewBlobAccessFromConfiguration() to construct a
 // BlobAccess that is suitable for accessing the Indirect Content
 // Addressable Storage.
-func NewICASBlobAccessCreator(grpcClientFactory grpc.ClientFactory, maximumMessageSizeBytes int) BlobAccessCreator {
+func NewICASBlobAccessCreator(registry *Registry, grpcClientFactory grpc.ClientFactory, maximumMessageSizeBytes int) BlobAccessCreator {
 	return &icasBlobAccessCreator{
+		icasBlobReplicatorCreator: icasBlobReplicatorCreator{
+			registry: registry,
+		},
 		grpcClientFactory:       grpcClientFactory,
 		maximumMessageSizeBytes: maximumMessageSizeBytes,
 	}
@@ -9,14 +9,24 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-type icasBlobReplicatorCreator struct{}
-
-func (brc icasBlobReplicatorCreator) NewCustomBlobReplicator(configuration *pb.BlobReplicatorConfiguration, source blobstore.BlobAccess, sink BlobAccessInfo) (replication.BlobReplicator, error) {
-	return nil, status.Error(codes.InvalidArgument, "Configuration did not contain a supported replicator")
+type icasBlobReplicatorCreator struct {
+	registry *Registry
 }
 
-// ICASBlobReplicatorCreator is a BlobReplicatorCreator that can be
-// provided to NewBlobReplicatorFromConfiguration() to construct a
+// NewICASBlobReplicatorCreator creates a BlobReplicatorCreator that can
+// be provided to NewBlobReplicatorFromConfiguration() to construct a
 // BlobReplicator that is suitable for replicating Indirect Content
 // Addressable Storage objects.
-var ICASBlobReplicatorCreator BlobReplicatorCreator = icasBlobReplicatorCreator{}
+func NewICASBlobReplicatorCreator(registry *Registry) BlobReplicatorCreator {
+	return icasBlobReplicatorCreator{
+		registry: registry,
+	}
+}
+
+func (brc icasBlobReplicatorCreator) GetRegistry() *Registry {
+	return brc.registry
+}
+
+func (brc icasBlobReplicatorCreator) NewCustomBlobReplicator(configuration *pb.BlobReplicatorConfiguration, source blobstore.BlobAccess, sink BlobAccessInfo) (replication.BlobReplicator, error) {
+	return nil, status.Error(codes.InvalidArgument, "Configuration did not contain a supported replicator")
+}
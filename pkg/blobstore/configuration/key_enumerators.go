@@ -0,0 +1,80 @@
+package configuration
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type registeredKeyEnumerator struct {
+	getPath       func() string
+	blobAccess    blobstore.BlobAccess
+	keyEnumerator blobstore.KeyEnumerator
+}
+
+func (reg *Registry) registerKeyEnumerator(storageType string, getPath func() string, blobAccess blobstore.BlobAccess, keyEnumerator blobstore.KeyEnumerator) {
+	reg.keyEnumeratorsLock.Lock()
+	defer reg.keyEnumeratorsLock.Unlock()
+	reg.keyEnumerators[storageType] = append(reg.keyEnumerators[storageType], registeredKeyEnumerator{
+		getPath:       getPath,
+		blobAccess:    blobAccess,
+		keyEnumerator: keyEnumerator,
+	})
+}
+
+// registerNonEnumeratingBackend records that a storage backend holds
+// data, but is not capable of enumerating its contents. This causes
+// EnumerateBlobs() to fail for the storage type, as callers such as the
+// garbage collector would otherwise act on an incomplete listing.
+func (reg *Registry) registerNonEnumeratingBackend(storageType string, getPath func() string) {
+	reg.keyEnumeratorsLock.Lock()
+	defer reg.keyEnumeratorsLock.Unlock()
+	reg.nonEnumeratingBackends[storageType] = append(reg.nonEnumeratingBackends[storageType], getPath)
+}
+
+// EnumerateBlobs calls the provided function for every object stored
+// in all storage backends of a given storage type. The function is
+// also provided with the backend in which the object is stored, so that
+// its contents may be loaded. If any of the backends holding data is
+// not capable of enumerating its contents (e.g., "local", "redis" or
+// "grpc"), no objects are reported, and FAILED_PRECONDITION is
+// returned.
+//
+// Objects stored in multiple backends (e.g., due to mirroring) are
+// reported multiple times.
+func (reg *Registry) EnumerateBlobs(ctx context.Context, storageType string, f func(blobAccess blobstore.BlobAccess, blobDigest digest.Digest) error) error {
+	reg.keyEnumeratorsLock.Lock()
+	registered := append([]registeredKeyEnumerator(nil), reg.keyEnumerators[storageType]...)
+	nonEnumerating := append([]func() string(nil), reg.nonEnumeratingBackends[storageType]...)
+	reg.keyEnumeratorsLock.Unlock()
+	if len(nonEnumerating) > 0 {
+		nonEnumeratingPaths := make([]string, 0, len(nonEnumerating))
+		for _, getPath := range nonEnumerating {
+			nonEnumeratingPaths = append(nonEnumeratingPaths, strconv.Quote(getPath()))
+		}
+		return status.Errorf(codes.FailedPrecondition, "Storage backends %s of storage type %#v don't support enumerating their contents", strings.Join(nonEnumeratingPaths, ", "), storageType)
+	}
+	if len(registered) == 0 {
+		return status.Errorf(codes.FailedPrecondition, "None of the storage backends of storage type %#v support enumerating their contents", storageType)
+	}
+
+	for _, r := range registered {
+		if err := r.keyEnumerator.EnumerateKeys(ctx, func(key string) error {
+			blobDigest, err := digest.NewDigestFromKey(key)
+			if err != nil {
+				return err
+			}
+			return f(r.blobAccess, blobDigest)
+		}); err != nil {
+			return util.StatusWrapf(err, "Backend %#v", r.getPath())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package configuration
+
+func (reg *Registry) registerLocalFlusher(flush func() error) {
+	reg.localFlushersLock.Lock()
+	defer reg.localFlushersLock.Unlock()
+	reg.localFlushers = append(reg.localFlushers, flush)
+}
+
+// FlushLocalPersistentState synchronizes the data of all instances of
+// LocalBlobAccess that have persistency enabled, and writes their
+// persistent state to disk. This function should be called prior to
+// shutting down gracefully, after requests have stopped being
+// processed.
+func (reg *Registry) FlushLocalPersistentState() error {
+	reg.localFlushersLock.Lock()
+	defer reg.localFlushersLock.Unlock()
+	for _, flush := range reg.localFlushers {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package configuration
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (reg *Registry) serveLocalSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := reg.createLocalSnapshots(r.FormValue("storage_type")); err != nil {
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func (reg *Registry) registerLocalSnapshotCreator(storageType string, createSnapshot func() error) {
+	reg.localSnapshotLock.Lock()
+	defer reg.localSnapshotLock.Unlock()
+	reg.localSnapshotCreators[storageType] = append(reg.localSnapshotCreators[storageType], createSnapshot)
+}
+
+func (reg *Registry) createLocalSnapshots(storageType string) error {
+	// Hold the lock while creating snapshots, so that concurrent
+	// requests don't write into the same directory.
+	reg.localSnapshotLock.Lock()
+	defer reg.localSnapshotLock.Unlock()
+	createSnapshots, ok := reg.localSnapshotCreators[storageType]
+	if !ok {
+		return status.Errorf(codes.NotFound, "No local storage with snapshots enabled is configured for storage type %#v", storageType)
+	}
+	for _, createSnapshot := range createSnapshots {
+		if err := createSnapshot(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,29 +1,44 @@
 package configuration
 
 import (
+	"context"
 	"fmt"
-	"sync"
+	"log"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/buildbarn/bb-storage/pkg/auth"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/readcaching"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/readfallback"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/sharding"
 	"github.com/buildbarn/bb-storage/pkg/blockdevice"
 	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/cloud/aws"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/global"
 	"github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/buildbarn/bb-storage/pkg/jwt"
+	"github.com/buildbarn/bb-storage/pkg/memcached"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	blockdevice_pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blockdevice"
 	"github.com/buildbarn/bb-storage/pkg/random"
 	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/buildbarn/bb-storage/pkg/zstd"
 	"github.com/go-redis/redis/extra/redisotel"
 	"github.com/go-redis/redis/v8"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 // BlobAccessInfo contains an instance of BlobAccess and information
@@ -32,6 +47,10 @@ import (
 type BlobAccessInfo struct {
 	BlobAccess      blobstore.BlobAccess
 	DigestKeyFormat digest.KeyFormat
+
+	// KeyEnumerator is set for backends that are capable of
+	// listing the keys of all objects stored within them.
+	KeyEnumerator blobstore.KeyEnumerator
 }
 
 func newRedisClient(opt *redis.Options) *redis.Client {
@@ -44,11 +63,298 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 	readBufferFactory := creator.GetReadBufferFactory()
 	storageTypeName := creator.GetStorageTypeName()
 	switch backend := configuration.Backend.(type) {
+	case *pb.BlobAccessConfiguration_BloomFilter:
+		if backend.BloomFilter.ExpectedObjects == 0 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Expected number of objects must be positive")
+		}
+		falsePositiveRate := backend.BloomFilter.FalsePositiveRate
+		if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "False positive rate must be between 0 and 1")
+		}
+		if err := backend.BloomFilter.RebuildInterval.CheckValid(); err != nil {
+			return BlobAccessInfo{}, "", util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to obtain rebuild interval")
+		}
+		rebuildInterval := backend.BloomFilter.RebuildInterval.AsDuration()
+
+		base, err := NewNestedBlobAccess(backend.BloomFilter.Backend, creator)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		if base.KeyEnumerator == nil {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Backend is not capable of enumerating its contents")
+		}
+
+		// Size the Bloom filter optimally for the desired false
+		// positive rate.
+		expectedObjects := float64(backend.BloomFilter.ExpectedObjects)
+		sizeBits := math.Ceil(-expectedObjects * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+		hashFunctions := int(math.Max(1, math.Round(sizeBits/expectedObjects*math.Ln2)))
+		blobAccess := blobstore.NewBloomFilterBlobAccess(
+			base.BlobAccess,
+			base.KeyEnumerator,
+			base.DigestKeyFormat,
+			uint64(sizeBits),
+			hashFunctions,
+			storageTypeName)
+		go func() {
+			for {
+				if err := blobAccess.Rebuild(context.Background()); err != nil {
+					util.DefaultErrorLogger.Log(util.StatusWrap(err, "Failed to rebuild Bloom filter"))
+				}
+				time.Sleep(rebuildInterval)
+			}
+		}()
+		return BlobAccessInfo{
+			BlobAccess:      blobAccess,
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "bloom_filter", nil
+	case *pb.BlobAccessConfiguration_CircuitBreaking:
+		if backend.CircuitBreaking.FailureThreshold == 0 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Failure threshold must be positive")
+		}
+		if err := backend.CircuitBreaking.CoolDown.CheckValid(); err != nil {
+			return BlobAccessInfo{}, "", util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to obtain cool down")
+		}
+		maximumProbes := 1
+		if backend.CircuitBreaking.MaximumProbes > 0 {
+			maximumProbes = int(backend.CircuitBreaking.MaximumProbes)
+		}
+		base, err := NewNestedBlobAccess(backend.CircuitBreaking.Backend, creator)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		return BlobAccessInfo{
+			BlobAccess: blobstore.NewCircuitBreakingBlobAccess(
+				base.BlobAccess,
+				clock.SystemClock,
+				int(backend.CircuitBreaking.FailureThreshold),
+				backend.CircuitBreaking.CoolDown.AsDuration(),
+				maximumProbes,
+				storageTypeName),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "circuit_breaking", nil
+	case *pb.BlobAccessConfiguration_Directory:
+		directory, err := filesystem.NewLocalDirectory(backend.Directory.Path)
+		if err != nil {
+			return BlobAccessInfo{}, "", util.StatusWrapf(err, "Failed to open directory %#v", backend.Directory.Path)
+		}
+		digestKeyFormat := creator.GetBaseDigestKeyFormat()
+		blobAccess := blobstore.NewDirectoryBlobAccess(directory, readBufferFactory, digestKeyFormat, backend.Directory.Sync)
+		return BlobAccessInfo{
+			BlobAccess:      blobAccess,
+			DigestKeyFormat: digestKeyFormat,
+			KeyEnumerator:   blobAccess,
+		}, "directory", nil
+	case *pb.BlobAccessConfiguration_Encrypting:
+		if backend.Encrypting.MaximumSizeBytes <= 0 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Maximum size must be positive")
+		}
+		keys := make(map[uint32][]byte, len(backend.Encrypting.Keys))
+		for _, key := range backend.Encrypting.Keys {
+			if _, ok := keys[key.Id]; ok {
+				return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Key with ID %d is specified multiple times", key.Id)
+			}
+			keys[key.Id] = key.Key
+		}
+
+		// Encrypted objects cannot be validated by the backend.
+		base, err := NewNestedBlobAccess(
+			backend.Encrypting.Backend,
+			newUnvalidatedBlobAccessCreator(creator, backend.Encrypting.MaximumSizeBytes+blobstore.EncryptionOverheadSizeBytes))
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		blobAccess, err := blobstore.NewEncryptingBlobAccess(
+			base.BlobAccess,
+			readBufferFactory,
+			base.DigestKeyFormat,
+			keys,
+			backend.Encrypting.CurrentKeyId,
+			// Contents of CAS objects are fully determined
+			// by their digests, meaning that nonces can be
+			// derived from them safely.
+			storageTypeName == "cas",
+			int(backend.Encrypting.MaximumSizeBytes))
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		return BlobAccessInfo{
+			BlobAccess:      blobAccess,
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "encrypting", nil
+	case *pb.BlobAccessConfiguration_Compressing:
+		if backend.Compressing.MaximumSizeBytes <= 0 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Maximum size must be positive")
+		}
+		level := int(backend.Compressing.Level)
+		if level == 0 {
+			level = zstd.DefaultLevel
+		} else if level < zstd.MinimumLevel || level > zstd.MaximumLevel {
+			return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Compression level must be between %d and %d", zstd.MinimumLevel, zstd.MaximumLevel)
+		}
+
+		// Compressed objects cannot be validated by the backend.
+		base, err := NewNestedBlobAccess(
+			backend.Compressing.Backend,
+			newUnvalidatedBlobAccessCreator(creator, backend.Compressing.MaximumSizeBytes+blobstore.CompressionOverheadSizeBytes))
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		return BlobAccessInfo{
+			BlobAccess: blobstore.NewCompressingBlobAccess(
+				base.BlobAccess,
+				readBufferFactory,
+				backend.Compressing.MinimumSizeBytes,
+				int(backend.Compressing.MaximumSizeBytes),
+				level),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "compressing", nil
 	case *pb.BlobAccessConfiguration_Error:
 		return BlobAccessInfo{
 			BlobAccess:      blobstore.NewErrorBlobAccess(status.ErrorProto(backend.Error)),
 			DigestKeyFormat: digest.KeyWithoutInstance,
 		}, "error", nil
+	case *pb.BlobAccessConfiguration_FaultInjecting:
+		getPolicy, err := newFaultInjectionPolicyFromConfiguration(backend.FaultInjecting.Get)
+		if err != nil {
+			return BlobAccessInfo{}, "", util.StatusWrap(err, "Invalid policy for Get()")
+		}
+		putPolicy, err := newFaultInjectionPolicyFromConfiguration(backend.FaultInjecting.Put)
+		if err != nil {
+			return BlobAccessInfo{}, "", util.StatusWrap(err, "Invalid policy for Put()")
+		}
+		findMissingPolicy, err := newFaultInjectionPolicyFromConfiguration(backend.FaultInjecting.FindMissing)
+		if err != nil {
+			return BlobAccessInfo{}, "", util.StatusWrap(err, "Invalid policy for FindMissing()")
+		}
+		base, err := NewNestedBlobAccess(backend.FaultInjecting.Backend, creator)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		return BlobAccessInfo{
+			BlobAccess: blobstore.NewFaultInjectingBlobAccess(
+				base.BlobAccess,
+				clock.SystemClock,
+				random.FastThreadSafeGenerator,
+				readBufferFactory,
+				getPolicy,
+				putPolicy,
+				findMissingPolicy,
+				storageTypeName),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "fault_injecting", nil
+	case *pb.BlobAccessConfiguration_QuotaEnforcing:
+		base, err := NewNestedBlobAccess(backend.QuotaEnforcing.Backend, creator)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		quotas := make([]blobstore.Quota, 0, len(backend.QuotaEnforcing.Quotas))
+		for _, quota := range backend.QuotaEnforcing.Quotas {
+			instanceNamePrefix, err := digest.NewInstanceName(quota.InstanceNamePrefix)
+			if err != nil {
+				return BlobAccessInfo{}, "", util.StatusWrapf(err, "Invalid instance name prefix %#v", quota.InstanceNamePrefix)
+			}
+			quotas = append(quotas, blobstore.Quota{
+				InstanceNamePrefix: instanceNamePrefix,
+				MaximumSizeBytes:   quota.MaximumSizeBytes,
+			})
+		}
+		blobAccess, err := blobstore.NewQuotaEnforcingBlobAccess(base.BlobAccess, quotas, storageTypeName)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		creator.GetRegistry().registerQuotaEnforcingBlobAccess(storageTypeName, blobAccess)
+		return BlobAccessInfo{
+			BlobAccess:      blobAccess,
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "quota_enforcing", nil
+	case *pb.BlobAccessConfiguration_ReadOnly:
+		base, err := NewNestedBlobAccess(backend.ReadOnly, creator)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		return BlobAccessInfo{
+			BlobAccess:      blobstore.NewReadOnlyBlobAccess(base.BlobAccess),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "read_only", nil
+	case *pb.BlobAccessConfiguration_WriteOnly:
+		base, err := NewNestedBlobAccess(backend.WriteOnly, creator)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		return BlobAccessInfo{
+			BlobAccess:      blobstore.NewWriteOnlyBlobAccess(base.BlobAccess),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "write_only", nil
+	case *pb.BlobAccessConfiguration_Prioritizing:
+		base, err := NewNestedBlobAccess(backend.Prioritizing.Backend, creator)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		if backend.Prioritizing.MaximumConcurrency == 0 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Maximum concurrency must be positive")
+		}
+		classNames := make([]string, 0, len(backend.Prioritizing.Classes))
+		weights := make([]int, 0, len(backend.Prioritizing.Classes))
+		classIndices := map[string]int{}
+		headerValues := map[string]int{}
+		toolNames := map[string]int{}
+		for i, class := range backend.Prioritizing.Classes {
+			if _, ok := classIndices[class.Name]; ok {
+				return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Multiple priority classes have name %#v", class.Name)
+			}
+			if class.Weight == 0 {
+				return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Priority class %#v must have a positive weight", class.Name)
+			}
+			classIndices[class.Name] = i
+			classNames = append(classNames, class.Name)
+			weights = append(weights, int(class.Weight))
+			for _, value := range class.MetadataHeaderValues {
+				headerValues[value] = i
+			}
+			for _, toolName := range class.ToolNames {
+				toolNames[toolName] = i
+			}
+		}
+		defaultClass, ok := classIndices[backend.Prioritizing.DefaultClass]
+		if !ok {
+			return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Default priority class %#v does not exist", backend.Prioritizing.DefaultClass)
+		}
+		return BlobAccessInfo{
+			BlobAccess: blobstore.NewPrioritizingBlobAccess(
+				base.BlobAccess,
+				blobstore.NewMetadataPriorityClassifier(
+					backend.Prioritizing.MetadataHeader,
+					headerValues,
+					toolNames,
+					defaultClass),
+				classNames,
+				weights,
+				int(backend.Prioritizing.MaximumConcurrency),
+				storageTypeName),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "prioritizing", nil
+	case *pb.BlobAccessConfiguration_Authorizing:
+		base, err := NewNestedBlobAccess(backend.Authorizing.Backend, creator)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		authorizationHeaderParser, err := jwt.NewAuthorizationHeaderParserFromConfiguration(backend.Authorizing.AuthorizationHeaderParser)
+		if err != nil {
+			return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to create authorization header parser")
+		}
+		readAuthorizer, err := auth.NewJWTAuthorizerFromConfiguration(authorizationHeaderParser, backend.Authorizing.ReadRules)
+		if err != nil {
+			return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to create read authorizer")
+		}
+		writeAuthorizer, err := auth.NewJWTAuthorizerFromConfiguration(authorizationHeaderParser, backend.Authorizing.WriteRules)
+		if err != nil {
+			return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to create write authorizer")
+		}
+		return BlobAccessInfo{
+			BlobAccess:      blobstore.NewAuthorizingBlobAccess(base.BlobAccess, readAuthorizer, writeAuthorizer),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "authorizing", nil
 	case *pb.BlobAccessConfiguration_ReadCaching:
 		slow, err := NewNestedBlobAccess(backend.ReadCaching.Slow, creator)
 		if err != nil {
@@ -66,6 +372,96 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 			BlobAccess:      readcaching.NewReadCachingBlobAccess(slow.BlobAccess, fast.BlobAccess, replicator),
 			DigestKeyFormat: slow.DigestKeyFormat,
 		}, "read_caching", nil
+	case *pb.BlobAccessConfiguration_Memcached:
+		if len(backend.Memcached.Servers) == 0 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "At least one memcached server must be provided")
+		}
+		if backend.Memcached.MaximumValueSizeBytes <= 0 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Maximum value size must be positive")
+		}
+
+		var dialTimeout time.Duration
+		if backend.Memcached.DialTimeout != nil {
+			if err := backend.Memcached.DialTimeout.CheckValid(); err != nil {
+				return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to obtain dial timeout configuration")
+			}
+			dialTimeout = backend.Memcached.DialTimeout.AsDuration()
+		}
+
+		var expiration time.Duration
+		if backend.Memcached.Expiration != nil {
+			if err := backend.Memcached.Expiration.CheckValid(); err != nil {
+				return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to obtain expiration")
+			}
+			expiration = backend.Memcached.Expiration.AsDuration()
+		}
+
+		clients := make(map[string]memcached.Client, len(backend.Memcached.Servers))
+		for _, address := range backend.Memcached.Servers {
+			if _, ok := clients[address]; ok {
+				return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Memcached server %#v is specified multiple times", address)
+			}
+			clients[address] = memcached.NewServerClient(address, dialTimeout, int(backend.Memcached.MaximumIdleConnectionsPerServer))
+		}
+
+		digestKeyFormat := creator.GetBaseDigestKeyFormat()
+		return BlobAccessInfo{
+			BlobAccess: blobstore.NewMemcachedBlobAccess(
+				memcached.NewConsistentHashingClient(clients, 160),
+				readBufferFactory,
+				digestKeyFormat,
+				int(backend.Memcached.MaximumValueSizeBytes),
+				uint32(expiration.Seconds())),
+			DigestKeyFormat: digestKeyFormat,
+		}, "memcached", nil
+	case *pb.BlobAccessConfiguration_Retrying:
+		if backend.Retrying.MaximumAttempts == 0 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Maximum number of attempts must be positive")
+		}
+		if err := backend.Retrying.InitialBackoff.CheckValid(); err != nil {
+			return BlobAccessInfo{}, "", util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to obtain initial backoff")
+		}
+		if err := backend.Retrying.MaximumBackoff.CheckValid(); err != nil {
+			return BlobAccessInfo{}, "", util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to obtain maximum backoff")
+		}
+		backoffMultiplier := 2.0
+		if backend.Retrying.BackoffMultiplier != 0 {
+			if backend.Retrying.BackoffMultiplier < 1 {
+				return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Backoff multiplier must be at least 1")
+			}
+			backoffMultiplier = backend.Retrying.BackoffMultiplier
+		}
+		retryableCodes := map[codes.Code]struct{}{}
+		for _, name := range backend.Retrying.RetryableStatusCodes {
+			var code codes.Code
+			if err := code.UnmarshalJSON([]byte(strconv.Quote(name))); err != nil {
+				return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Invalid status code %#v", name)
+			}
+			retryableCodes[code] = struct{}{}
+		}
+		if len(retryableCodes) == 0 {
+			retryableCodes[codes.Unavailable] = struct{}{}
+		}
+
+		base, err := NewNestedBlobAccess(backend.Retrying.Backend, creator)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		return BlobAccessInfo{
+			BlobAccess: blobstore.NewRetryingBlobAccess(
+				base.BlobAccess,
+				clock.SystemClock,
+				random.FastThreadSafeGenerator,
+				blobstore.RetryPolicy{
+					MaximumAttempts:   int(backend.Retrying.MaximumAttempts),
+					InitialBackoff:    backend.Retrying.InitialBackoff.AsDuration(),
+					MaximumBackoff:    backend.Retrying.MaximumBackoff.AsDuration(),
+					BackoffMultiplier: backoffMultiplier,
+					RetryableCodes:    retryableCodes,
+				},
+				int(backend.Retrying.MaximumPutSizeBytes)),
+			DigestKeyFormat: base.DigestKeyFormat,
+		}, "retrying", nil
 	case *pb.BlobAccessConfiguration_Redis:
 		tlsConfig, err := util.NewTLSConfigFromClientConfiguration(backend.Redis.Tls)
 		if err != nil {
@@ -173,14 +569,52 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 			BlobAccess:      blobstore.NewRemoteBlobAccess(backend.Remote.Address, storageTypeName, readBufferFactory),
 			DigestKeyFormat: digest.KeyWithInstance,
 		}, "remote", nil
+	case *pb.BlobAccessConfiguration_S3:
+		if partSizeBytes := backend.S3.MultipartUploadPartSizeBytes; partSizeBytes < 5*1024*1024 {
+			return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Multipart upload part size of %d bytes is below the minimum of 5 MiB", partSizeBytes)
+		}
+		if backend.S3.MaximumConcurrency <= 0 {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Maximum concurrency must be positive")
+		}
+		var refreshInterval time.Duration
+		if backend.S3.RefreshInterval != nil {
+			if err := backend.S3.RefreshInterval.CheckValid(); err != nil {
+				return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to obtain refresh interval")
+			}
+			refreshInterval = backend.S3.RefreshInterval.AsDuration()
+		}
+		sess, err := aws.NewSessionFromConfiguration(backend.S3.AwsSession)
+		if err != nil {
+			return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to create AWS session")
+		}
+		digestKeyFormat := creator.GetBaseDigestKeyFormat()
+		blobAccess := blobstore.NewS3BlobAccess(
+			s3.New(sess),
+			readBufferFactory,
+			digestKeyFormat,
+			backend.S3.Bucket,
+			backend.S3.KeyPrefix,
+			backend.S3.MultipartUploadPartSizeBytes,
+			int(backend.S3.MaximumConcurrency),
+			clock.SystemClock,
+			refreshInterval)
+		return BlobAccessInfo{
+			BlobAccess:      blobAccess,
+			DigestKeyFormat: digestKeyFormat,
+			KeyEnumerator:   blobAccess,
+		}, "s3", nil
 	case *pb.BlobAccessConfiguration_Sharding:
 		backends := make([]blobstore.BlobAccess, 0, len(backend.Sharding.Shards))
+		readBackends := make([]blobstore.BlobAccess, 0, len(backend.Sharding.Shards))
 		weights := make([]uint32, 0, len(backend.Sharding.Shards))
+		drained := make([]bool, 0, len(backend.Sharding.Shards))
+		localZone := backend.Sharding.Zone
 		var combinedDigestKeyFormat *digest.KeyFormat
 		for _, shard := range backend.Sharding.Shards {
 			if shard.Backend == nil {
 				// Drained backend.
 				backends = append(backends, nil)
+				readBackends = append(readBackends, nil)
 			} else {
 				// Undrained backend.
 				backend, err := NewNestedBlobAccess(shard.Backend, creator)
@@ -194,21 +628,79 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 					newDigestKeyFormat := combinedDigestKeyFormat.Combine(backend.DigestKeyFormat)
 					combinedDigestKeyFormat = &newDigestKeyFormat
 				}
+
+				// If the canonical backend of the shard is
+				// located in another zone, prefer reading
+				// from a replica in the local zone.
+				readBackend := backend.BlobAccess
+				if localZone != "" && shard.Zone != localZone {
+					for _, replica := range shard.ZonalReplicas {
+						if replica.Zone == localZone {
+							replicaBackend, err := NewNestedBlobAccess(replica.Backend, creator)
+							if err != nil {
+								return BlobAccessInfo{}, "", err
+							}
+							readBackend = replicaBackend.BlobAccess
+							newDigestKeyFormat := combinedDigestKeyFormat.Combine(replicaBackend.DigestKeyFormat)
+							combinedDigestKeyFormat = &newDigestKeyFormat
+							break
+						}
+					}
+				}
+				readBackends = append(readBackends, readBackend)
 			}
 
 			if shard.Weight == 0 {
 				return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Shards must have positive weights")
 			}
 			weights = append(weights, shard.Weight)
+			drained = append(drained, shard.Backend == nil || shard.Drained)
 		}
+		shardWeights := make([]string, 0, len(weights))
+		var drainedShards []string
+		for i, weight := range weights {
+			shardWeights = append(shardWeights, strconv.FormatUint(uint64(weight), 10))
+			if drained[i] {
+				drainedShards = append(drainedShards, strconv.FormatInt(int64(i), 10))
+			}
+		}
+		creator.GetRegistry().annotateTopology("shard_weights", strings.Join(shardWeights, ","))
+		creator.GetRegistry().annotateTopology("drained_shards", strings.Join(drainedShards, ","))
 		if combinedDigestKeyFormat == nil {
 			return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Cannot create sharding blob access without any undrained backends")
 		}
+		var shardPermuter sharding.ShardPermuter
+		switch backend.Sharding.ShardPermuter {
+		case pb.ShardingBlobAccessConfiguration_WEIGHTED:
+			shardPermuter = sharding.NewWeightedShardPermuter(weights)
+		case pb.ShardingBlobAccessConfiguration_RENDEZVOUS:
+			shardPermuter = sharding.NewRendezvousShardPermuter(weights)
+		default:
+			return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Unknown shard permuter")
+		}
+		creator.GetRegistry().annotateTopology("shard_permuter", backend.Sharding.ShardPermuter.String())
+		shardingBlobAccess := sharding.NewShardingBlobAccess(
+			backends,
+			readBackends,
+			shardPermuter,
+			backend.Sharding.HashInitialization)
+		if err := shardingBlobAccess.SetDrainedShards(drained); err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+
+		// Permit draining and undraining shards by reloading the
+		// configuration. Other changes to the set of shards
+		// require a restart.
+		creator.GetRegistry().reloadRegistry.Register(backend.Sharding, func(newConfiguration proto.Message) error {
+			newShards := newConfiguration.(*pb.ShardingBlobAccessConfiguration).Shards
+			newDrained := make([]bool, 0, len(newShards))
+			for _, shard := range newShards {
+				newDrained = append(newDrained, shard.Backend == nil || shard.Drained)
+			}
+			return shardingBlobAccess.SetDrainedShards(newDrained)
+		})
 		return BlobAccessInfo{
-			BlobAccess: sharding.NewShardingBlobAccess(
-				backends,
-				sharding.NewWeightedShardPermuter(weights),
-				backend.Sharding.HashInitialization),
+			BlobAccess:      shardingBlobAccess,
 			DigestKeyFormat: *combinedDigestKeyFormat,
 		}, "sharding", nil
 	case *pb.BlobAccessConfiguration_SizeDistinguishing:
@@ -225,6 +717,9 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 			DigestKeyFormat: small.DigestKeyFormat.Combine(large.DigestKeyFormat),
 		}, "size_distinguishing", nil
 	case *pb.BlobAccessConfiguration_Mirrored:
+		if len(backend.Mirrored.Backends) > 0 {
+			return newNWayMirroredBlobAccess(backend.Mirrored, creator)
+		}
 		backendA, err := NewNestedBlobAccess(backend.Mirrored.BackendA, creator)
 		if err != nil {
 			return BlobAccessInfo{}, "", err
@@ -241,19 +736,29 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 		if err != nil {
 			return BlobAccessInfo{}, "", err
 		}
+		readPreference, err := newMirroredReadPreference(backend.Mirrored, 2)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
 		return BlobAccessInfo{
-			BlobAccess:      mirrored.NewMirroredBlobAccess(backendA.BlobAccess, backendB.BlobAccess, replicatorAToB, replicatorBToA),
+			BlobAccess:      mirrored.NewMirroredBlobAccess(backendA.BlobAccess, backendB.BlobAccess, replicatorAToB, replicatorBToA, readPreference),
 			DigestKeyFormat: backendA.DigestKeyFormat.Combine(backendB.DigestKeyFormat),
 		}, "mirrored", nil
 	case *pb.BlobAccessConfiguration_Local:
 		digestKeyFormat := creator.GetBaseDigestKeyFormat()
 		persistent := backend.Local.Persistent
+		blobChecksums := backend.Local.BlobChecksums
+		largeBlobPool := backend.Local.LargeBlobPool
+		if largeBlobPool != nil && persistent != nil {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "A large blob pool cannot be combined with persistency")
+		}
 
 		// Create the backing store for blocks of data.
 		var backendType string
 		var sectorSizeBytes int
 		var blockSectorCount int64
 		var blockAllocator local.BlockAllocator
+		var blocksBlockDevice blockdevice.BlockDevice
 		dataSyncer := func() error { return nil }
 		switch blocksBackend := backend.Local.BlocksBackend.(type) {
 		case *pb.LocalBlobAccessConfiguration_BlocksInMemory_:
@@ -264,8 +769,12 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 			// Use a sector size of 1 byte to achieve
 			// maximum storage density.
 			sectorSizeBytes = 1
-			blockSectorCount = blocksBackend.BlocksInMemory.BlockSizeBytes
-			blockAllocator = local.NewInMemoryBlockAllocator(int(blocksBackend.BlocksInMemory.BlockSizeBytes))
+			blockSizeBytes, err := getInMemoryBlockSizeBytes(backend.Local, blocksBackend.BlocksInMemory)
+			if err != nil {
+				return BlobAccessInfo{}, "", err
+			}
+			blockSectorCount = blockSizeBytes
+			blockAllocator = local.NewInMemoryBlockAllocator(int(blockSizeBytes))
 		case *pb.LocalBlobAccessConfiguration_BlocksOnBlockDevice_:
 			backendType = "local_block_device"
 			// Data may be stored on a block device that is
@@ -273,18 +782,49 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 			// block size based on the size of the block
 			// device and the number of blocks.
 			blocksOnBlockDevice := blocksBackend.BlocksOnBlockDevice
+			blockCount := blocksOnBlockDevice.SpareBlocks + backend.Local.OldBlocks + backend.Local.CurrentBlocks + backend.Local.NewBlocks
+			if largeBlobPool != nil {
+				blockCount += largeBlobPool.OldBlocks + largeBlobPool.CurrentBlocks + largeBlobPool.NewBlocks
+			}
+			slowTier := blocksOnBlockDevice.SlowTier
+			if slowTier != nil {
+				if persistent != nil {
+					return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Storing blocks on a slow tier cannot be combined with persistency")
+				}
+				if largeBlobPool != nil {
+					return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Storing blocks on a slow tier cannot be combined with a large blob pool")
+				}
+				if slowTier.Blocks <= 0 || slowTier.Blocks >= backend.Local.OldBlocks+backend.Local.CurrentBlocks+backend.Local.NewBlocks {
+					return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "The number of blocks stored on the slow tier must be positive and less than the total number of blocks")
+				}
+				blockCount -= slowTier.Blocks
+			}
+
 			var blockDevice blockdevice.BlockDevice
-			var sectorCount int64
 			var err error
-			blockDevice, sectorSizeBytes, sectorCount, err = blockdevice.NewBlockDeviceFromConfiguration(
-				blocksOnBlockDevice.Source,
-				persistent == nil)
-			if err != nil {
-				return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to open blocks block device")
+			if len(blocksOnBlockDevice.Sources) == 0 {
+				var sectorCount int64
+				blockDevice, sectorSizeBytes, sectorCount, err = blockdevice.NewBlockDeviceFromConfiguration(
+					blocksOnBlockDevice.Source,
+					persistent == nil)
+				if err != nil {
+					return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to open blocks block device")
+				}
+				blockSectorCount = sectorCount / int64(blockCount)
+			} else {
+				if blocksOnBlockDevice.Source != nil {
+					return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Source and sources cannot be specified at the same time")
+				}
+				blockDevice, sectorSizeBytes, blockSectorCount, err = NewStripingBlockDeviceFromConfiguration(
+					blocksOnBlockDevice.Sources,
+					int64(blockCount),
+					persistent == nil)
+				if err != nil {
+					return BlobAccessInfo{}, "", err
+				}
 			}
+			blocksBlockDevice = blockDevice
 			dataSyncer = blockDevice.Sync
-			blockCount := blocksOnBlockDevice.SpareBlocks + backend.Local.OldBlocks + backend.Local.CurrentBlocks + backend.Local.NewBlocks
-			blockSectorCount = sectorCount / int64(blockCount)
 
 			cachedReadBufferFactory := readBufferFactory
 			if cacheConfiguration := blocksOnBlockDevice.DataIntegrityValidationCache; cacheConfiguration != nil {
@@ -297,19 +837,65 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 					dataIntegrityCheckingCache)
 			}
 
-			blockAllocator = local.NewBlockDeviceBackedBlockAllocator(
-				blockDevice,
-				cachedReadBufferFactory,
-				sectorSizeBytes,
-				blockSectorCount,
-				int(blockCount))
+			if slowTier == nil {
+				blockAllocator = local.NewBlockDeviceBackedBlockAllocator(
+					blockDevice,
+					cachedReadBufferFactory,
+					sectorSizeBytes,
+					blockSectorCount,
+					int(blockCount))
+			} else {
+				slowBlockDevice, slowSectorSizeBytes, slowSectorCount, err := blockdevice.NewBlockDeviceFromConfiguration(slowTier.Source, true)
+				if err != nil {
+					return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to open slow tier blocks block device")
+				}
+				if slowSectorSizeBytes != sectorSizeBytes {
+					return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Slow tier block device has sector size %d, while the blocks block device has sector size %d", slowSectorSizeBytes, sectorSizeBytes)
+				}
+				slowBlockCount := blocksOnBlockDevice.SpareBlocks + slowTier.Blocks
+				if slowSectorCount < int64(slowBlockCount)*blockSectorCount {
+					return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Slow tier block device is too small to hold %d blocks of %d sectors", slowBlockCount, blockSectorCount)
+				}
+				blockAllocator = local.NewTieredBlockAllocator(
+					blockDevice,
+					int(blockCount),
+					slowBlockDevice,
+					int(slowBlockCount),
+					cachedReadBufferFactory,
+					sectorSizeBytes,
+					blockSectorCount,
+					int(backend.Local.OldBlocks+backend.Local.CurrentBlocks+backend.Local.NewBlocks-slowTier.Blocks),
+					util.DefaultErrorLogger)
+			}
 		default:
 			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Blocks backend not specified")
 		}
 
-		var globalLock sync.RWMutex
+		// Open the block device backing the key-location map ahead
+		// of creating the BlockList, as its contents may need to be
+		// restored from a snapshot before persistent state is loaded.
+		var keyLocationMapBlockDevice blockdevice.BlockDevice
+		var keyLocationMapSectorSizeBytes int
+		var keyLocationMapSizeBytes int64
+		if keyLocationMapOnBlockDevice := backend.Local.GetKeyLocationMapOnBlockDevice(); keyLocationMapOnBlockDevice != nil {
+			blockDevice, sectorSizeBytes, sectorCount, err := blockdevice.NewBlockDeviceFromConfiguration(
+				keyLocationMapOnBlockDevice,
+				persistent == nil)
+			if err != nil {
+				return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to open key-location map block device")
+			}
+			keyLocationMapBlockDevice = blockDevice
+			keyLocationMapSectorSizeBytes = sectorSizeBytes
+			keyLocationMapSizeBytes = int64(sectorSizeBytes) * sectorCount
+		}
+
+		globalLock := local.NewShardedRWMutex(runtime.NumCPU())
 		var blockList local.BlockList
 		var keyLocationMapHashInitialization uint64
+		var keyLocationMapPreviousRecordsCount int64
+		var persistentBlockList *local.PersistentBlockList
+		var persistentStateStore local.PersistentStateStore
+		var snapshotDirectory filesystem.Directory
 		initialBlockCount := 0
 		if persistent == nil {
 			// Persistency is disabled. Provide a simple
@@ -326,19 +912,47 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 			if err != nil {
 				return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to open persistent state directory")
 			}
-			persistentStateStore := local.NewDirectoryBackedPersistentStateStore(persistentStateDirectory)
+			persistentStateStore = local.NewDirectoryBackedPersistentStateStore(persistentStateDirectory)
+			if persistent.SnapshotDirectoryPath != "" {
+				if blocksBlockDevice == nil || keyLocationMapBlockDevice == nil {
+					return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Snapshots can only be enabled if both blocks and the key-location map are stored on a block device")
+				}
+				snapshotDirectory, err = filesystem.NewLocalDirectory(persistent.SnapshotDirectoryPath)
+				if err != nil {
+					return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to open snapshot directory")
+				}
+
+				// Seed storage from a snapshot if no
+				// persistent state is present yet.
+				hasPersistentState, err := local.DirectoryContainsPersistentState(persistentStateDirectory)
+				if err != nil {
+					return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to check for presence of persistent state")
+				}
+				if !hasPersistentState {
+					if err := local.RestoreSnapshot(snapshotDirectory, blocksBlockDevice, keyLocationMapBlockDevice, keyLocationMapSizeBytes, persistentStateStore); err == nil {
+						log.Printf("Restored %s storage from snapshot in %#v", storageTypeName, persistent.SnapshotDirectoryPath)
+					} else if status.Code(err) != codes.NotFound {
+						return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to restore snapshot")
+					}
+				}
+			}
 			persistentState, err := persistentStateStore.ReadPersistentState()
 			if err != nil {
 				return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to reload persistent state")
 			}
 			keyLocationMapHashInitialization = persistentState.KeyLocationMapHashInitialization
+			keyLocationMapPreviousRecordsCount = persistentState.KeyLocationMapRecordsCount
+			if persistentState.BlobChecksums != (blobChecksums != nil) {
+				// Blocks were written with a different
+				// layout. Discard them.
+				persistentState.Blocks = nil
+			}
 
 			// Create a persistent BlockList. This will
 			// attempt to reattach the old blocks. The
 			// number of valid blocks is returned, so that
 			// the dimensions of the OldNewCurrentLocationBlobMap
 			// can be set properly.
-			var persistentBlockList *local.PersistentBlockList
 			persistentBlockList, initialBlockCount = local.NewPersistentBlockList(
 				blockAllocator,
 				sectorSizeBytes,
@@ -346,7 +960,186 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 				persistentState.OldestEpochId,
 				persistentState.Blocks)
 			blockList = persistentBlockList
+		}
+
+		maximumBlobSizeBytes := int64(sectorSizeBytes) * blockSectorCount
+		if blobChecksums != nil {
+			blockList = local.NewChecksummingBlockList(blockList)
+			maximumBlobSizeBytes -= local.BlobChecksumSizeBytes
+		}
 
+		refreshPolicy, err := newBlobRefreshPolicyFromConfiguration(backend.Local)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		locationBlobMap := local.NewOldCurrentNewLocationBlobMap(
+			blockList,
+			util.DefaultErrorLogger,
+			storageTypeName,
+			maximumBlobSizeBytes,
+			int(backend.Local.OldBlocks),
+			int(backend.Local.CurrentBlocks),
+			int(backend.Local.NewBlocks),
+			initialBlockCount,
+			refreshPolicy)
+
+		// Create the backing store for the key-location map.
+		var locationRecordArraySize int
+		var locationRecordArray local.LocationRecordArray
+		var growableLocationRecordArray local.GrowableLocationRecordArray
+		var keyLocationMapInMemory *pb.LocalBlobAccessConfiguration_KeyLocationMapInMemory
+		keyLocationMapFlusher := func() error { return nil }
+		switch keyLocationMapBackend := backend.Local.KeyLocationMapBackend.(type) {
+		case *pb.LocalBlobAccessConfiguration_KeyLocationMapInMemory_:
+			keyLocationMapInMemory = keyLocationMapBackend.KeyLocationMapInMemory
+			locationRecordArraySize = int(keyLocationMapInMemory.Entries)
+			growableLocationRecordArray = local.NewInMemoryLocationRecordArray(
+				locationRecordArraySize,
+				locationBlobMap)
+			locationRecordArray = growableLocationRecordArray
+		case *pb.LocalBlobAccessConfiguration_KeyLocationMapOnBlockDevice:
+			locationRecordArraySize = int(keyLocationMapSizeBytes / local.BlockDeviceBackedLocationRecordSize)
+			if writeBatching := backend.Local.KeyLocationMapWriteBatching; writeBatching == nil {
+				locationRecordArray = local.NewBlockDeviceBackedLocationRecordArray(
+					keyLocationMapBlockDevice,
+					locationBlobMap)
+			} else {
+				// Keep entries in memory, and flush them
+				// to the block device periodically.
+				if err := writeBatching.FlushInterval.CheckValid(); err != nil {
+					return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to obtain key-location map flush interval")
+				}
+				flushInterval := writeBatching.FlushInterval.AsDuration()
+				if flushInterval <= 0 {
+					return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Key-location map flush interval must be positive")
+				}
+				writeBatchingLocationRecordArray := local.NewWriteBatchingLocationRecordArray(
+					keyLocationMapBlockDevice,
+					locationBlobMap,
+					keyLocationMapSectorSizeBytes,
+					int(writeBatching.MaximumDirtyEntries),
+					globalLock,
+					storageTypeName)
+				locationRecordArray = writeBatchingLocationRecordArray
+				keyLocationMapFlusher = writeBatchingLocationRecordArray.Flush
+				go func() {
+					for {
+						time.Sleep(flushInterval)
+						if err := writeBatchingLocationRecordArray.Flush(); err != nil {
+							util.DefaultErrorLogger.Log(util.StatusWrap(err, "Failed to flush key-location map"))
+						}
+					}
+				}()
+			}
+		default:
+			return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Key-location map backend not specified")
+		}
+
+		var keyLocationMap local.KeyLocationMap
+		keyLocationMapRecordsCount := func() int64 { return int64(locationRecordArraySize) }
+		if backend.Local.KeyLocationMapCuckooHashing {
+			if keyLocationMapInMemory != nil && keyLocationMapInMemory.MaximumLoadFactor > 0 {
+				return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Cuckoo hashing cannot be combined with automatic growing of the key-location map")
+			}
+			if locationRecordArraySize < 2 {
+				return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Cuckoo hashing requires the key-location map to have at least two entries")
+			}
+			keyLocationMap = local.NewCuckooKeyLocationMap(
+				locationRecordArray,
+				locationRecordArraySize,
+				keyLocationMapHashInitialization,
+				int(backend.Local.KeyLocationMapMaximumPutAttempts),
+				storageTypeName)
+		} else if keyLocationMapInMemory != nil && keyLocationMapInMemory.MaximumLoadFactor > 0 {
+			// Grow the key-location map automatically once
+			// it becomes too full.
+			if keyLocationMapInMemory.MaximumLoadFactor >= 1 {
+				return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Maximum load factor of the key-location map must be less than one")
+			}
+			if keyLocationMapInMemory.MaximumEntries < keyLocationMapInMemory.Entries {
+				return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Maximum number of entries of the key-location map must be at least as large as the initial number of entries")
+			}
+			automaticallyResizingKeyLocationMap := local.NewAutomaticallyResizingKeyLocationMap(
+				growableLocationRecordArray,
+				locationRecordArraySize,
+				int(keyLocationMapInMemory.MaximumEntries),
+				keyLocationMapInMemory.MaximumLoadFactor,
+				keyLocationMapHashInitialization,
+				backend.Local.KeyLocationMapMaximumGetAttempts,
+				int(backend.Local.KeyLocationMapMaximumPutAttempts),
+				storageTypeName)
+			keyLocationMap = automaticallyResizingKeyLocationMap
+			keyLocationMapRecordsCount = func() int64 { return int64(automaticallyResizingKeyLocationMap.GetRecordsCount()) }
+			go func() {
+				for {
+					globalLock.Lock()
+					migrating, err := automaticallyResizingKeyLocationMap.GrowAndMigrateRecords(1024)
+					globalLock.Unlock()
+					if err != nil {
+						util.DefaultErrorLogger.Log(util.StatusWrap(err, "Failed to grow key-location map"))
+						time.Sleep(10 * time.Second)
+					} else if !migrating {
+						time.Sleep(time.Second)
+					}
+				}
+			}()
+		} else if keyLocationMapPreviousRecordsCount > 0 && keyLocationMapPreviousRecordsCount < int64(locationRecordArraySize) {
+			// The key-location map has grown since the
+			// persistent state was last written. Migrate
+			// existing entries to their new locations in
+			// the background, as opposed to discarding them.
+			resizingKeyLocationMap := local.NewResizingKeyLocationMap(
+				locationRecordArray,
+				int(keyLocationMapPreviousRecordsCount),
+				locationRecordArraySize,
+				keyLocationMapHashInitialization,
+				backend.Local.KeyLocationMapMaximumGetAttempts,
+				int(backend.Local.KeyLocationMapMaximumPutAttempts),
+				storageTypeName)
+			keyLocationMap = resizingKeyLocationMap
+			keyLocationMapRecordsCount = resizingKeyLocationMap.GetPersistentRecordsCount
+			go func() {
+				for {
+					globalLock.Lock()
+					done, err := resizingKeyLocationMap.MigrateRecords(1024)
+					globalLock.Unlock()
+					if err != nil {
+						util.DefaultErrorLogger.Log(util.StatusWrap(err, "Failed to migrate key-location map entries"))
+						time.Sleep(10 * time.Second)
+					} else if done {
+						return
+					}
+				}
+			}()
+		} else {
+			keyLocationMap = local.NewHashingKeyLocationMap(
+				locationRecordArray,
+				locationRecordArraySize,
+				keyLocationMapHashInitialization,
+				backend.Local.KeyLocationMapMaximumGetAttempts,
+				int(backend.Local.KeyLocationMapMaximumPutAttempts),
+				storageTypeName)
+		}
+
+		if blobChecksums != nil && blobChecksums.ScrubbingRecordsPerSecond > 0 {
+			// Start a goroutine that continuously validates
+			// the checksums of blobs in the background.
+			scrubber := local.NewBlobChecksumScrubber(
+				locationRecordArray,
+				func() int { return int(keyLocationMapRecordsCount()) },
+				locationBlobMap,
+				globalLock,
+				storageTypeName)
+			go func() {
+				for range time.Tick(time.Second) {
+					if err := scrubber.ScrubRecords(int(blobChecksums.ScrubbingRecordsPerSecond)); err != nil {
+						util.DefaultErrorLogger.Log(util.StatusWrap(err, "Failed to scrub blobs"))
+					}
+				}
+			}()
+		}
+
+		if persistent != nil {
 			// Start goroutines that update the persistent
 			// state file when writes and block releases
 			// occur.
@@ -356,13 +1149,15 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 			minimumEpochInterval := persistent.MinimumEpochInterval.AsDuration()
 			periodicSyncer := local.NewPeriodicSyncer(
 				persistentBlockList,
-				&globalLock,
+				globalLock,
 				persistentStateStore,
 				clock.SystemClock,
 				util.DefaultErrorLogger,
 				10*time.Second,
 				minimumEpochInterval,
 				keyLocationMapHashInitialization,
+				keyLocationMapRecordsCount,
+				blobChecksums != nil,
 				dataSyncer)
 			go func() {
 				for {
@@ -374,56 +1169,96 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 					periodicSyncer.ProcessBlockPut()
 				}
 			}()
-		}
 
-		locationBlobMap := local.NewOldCurrentNewLocationBlobMap(
-			blockList,
-			util.DefaultErrorLogger,
-			storageTypeName,
-			int64(sectorSizeBytes)*blockSectorCount,
-			int(backend.Local.OldBlocks),
-			int(backend.Local.CurrentBlocks),
-			int(backend.Local.NewBlocks),
-			initialBlockCount)
+			creator.GetRegistry().registerLocalFlusher(func() error {
+				if err := periodicSyncer.Synchronize(); err != nil {
+					return err
+				}
+				if err := keyLocationMapFlusher(); err != nil {
+					return util.StatusWrap(err, "Failed to flush key-location map")
+				}
+				return nil
+			})
+			if snapshotDirectory != nil {
+				creator.GetRegistry().registerLocalSnapshotCreator(storageTypeName, func() error {
+					persistentState, blockContents, err := periodicSyncer.CreateSnapshot()
+					if err != nil {
+						return err
+					}
+					// Ensure entries referencing data in
+					// the snapshot are part of the copy of
+					// the key-location map.
+					if err := keyLocationMapFlusher(); err != nil {
+						for _, b := range blockContents {
+							b.Discard()
+						}
+						return util.StatusWrap(err, "Failed to flush key-location map")
+					}
+					return local.WriteSnapshot(snapshotDirectory, persistentState, blockContents, keyLocationMapBlockDevice, keyLocationMapSizeBytes)
+				})
+			}
+		}
 
-		// Create the backing store for the key-location map.
-		var locationRecordArraySize int
-		var locationRecordArray local.LocationRecordArray
-		switch keyLocationMapBackend := backend.Local.KeyLocationMapBackend.(type) {
-		case *pb.LocalBlobAccessConfiguration_KeyLocationMapInMemory_:
-			locationRecordArraySize = int(keyLocationMapBackend.KeyLocationMapInMemory.Entries)
-			locationRecordArray = local.NewInMemoryLocationRecordArray(
-				locationRecordArraySize,
-				locationBlobMap)
-		case *pb.LocalBlobAccessConfiguration_KeyLocationMapOnBlockDevice:
-			blockDevice, sectorSizeBytes, sectorCount, err := blockdevice.NewBlockDeviceFromConfiguration(
-				keyLocationMapBackend.KeyLocationMapOnBlockDevice,
-				persistent == nil)
+		keyBlobMapBackedBlobAccess := local.NewKeyBlobMapBackedBlobAccess(
+			local.NewLocationBasedKeyBlobMap(
+				keyLocationMap,
+				locationBlobMap),
+			digestKeyFormat,
+			globalLock,
+			storageTypeName)
+		blobDeleter := keyBlobMapBackedBlobAccess.(blobstore.BlobDeleter)
+		var blobAccess blobstore.BlobAccess = &statisticsReportingBlobAccess{
+			BlobAccess:  keyBlobMapBackedBlobAccess,
+			BlobDeleter: blobDeleter,
+			getStatistics: func(ctx context.Context) (blobstore.StorageStatistics, error) {
+				globalLock.RLock()
+				locationBlobMapStatistics := locationBlobMap.GetStatistics()
+				globalLock.RUnlock()
+				locationRecordArrayStatistics, err := local.GetLocationRecordArrayStatistics(
+					locationRecordArray,
+					func() int { return int(keyLocationMapRecordsCount()) },
+					globalLock)
+				if err != nil {
+					return blobstore.StorageStatistics{}, util.StatusWrap(err, "Failed to obtain key-location map statistics")
+				}
+				return blobstore.StorageStatistics{
+					ObjectsCount: int64(locationRecordArrayStatistics.ValidRecordsCount),
+					SizeBytes:    &locationRecordArrayStatistics.ValidRecordsSizeBytes,
+					Details: localStatistics{
+						BlockSizeBytes:            int64(sectorSizeBytes) * blockSectorCount,
+						LocationBlobMapStatistics: locationBlobMapStatistics,
+						KeyLocationMap:            locationRecordArrayStatistics,
+					},
+				}, nil
+			},
+		}
+		if largeBlobPool != nil {
+			// Store large blobs in a separate set of blocks,
+			// so that they can't displace small blobs.
+			largeBlobAccess, err := newLargeBlobPoolFromConfiguration(
+				backend.Local,
+				blockAllocator,
+				sectorSizeBytes,
+				blockSectorCount,
+				digestKeyFormat,
+				storageTypeName+"_large_blob_pool")
 			if err != nil {
-				return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to open key-location map block device")
+				return BlobAccessInfo{}, "", err
+			}
+			largeBlobDeleter := largeBlobAccess.(blobstore.BlobDeleter)
+			cutoffSizeBytes := largeBlobPool.CutoffSizeBytes
+			blobAccess = &deletingBlobAccess{
+				BlobAccess: blobstore.NewSizeDistinguishingBlobAccess(blobAccess, largeBlobAccess, cutoffSizeBytes),
+				BlobDeleter: blobDeleterFunc(func(ctx context.Context, blobDigest digest.Digest) error {
+					if blobDigest.GetSizeBytes() <= cutoffSizeBytes {
+						return blobDeleter.Delete(ctx, blobDigest)
+					}
+					return largeBlobDeleter.Delete(ctx, blobDigest)
+				}),
 			}
-			locationRecordArraySize = int((int64(sectorSizeBytes) * sectorCount) / local.BlockDeviceBackedLocationRecordSize)
-			locationRecordArray = local.NewBlockDeviceBackedLocationRecordArray(
-				blockDevice,
-				locationBlobMap)
-		default:
-			return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "Key-location map backend not specified")
 		}
-
 		return BlobAccessInfo{
-			BlobAccess: local.NewKeyBlobMapBackedBlobAccess(
-				local.NewLocationBasedKeyBlobMap(
-					local.NewHashingKeyLocationMap(
-						locationRecordArray,
-						locationRecordArraySize,
-						keyLocationMapHashInitialization,
-						backend.Local.KeyLocationMapMaximumGetAttempts,
-						int(backend.Local.KeyLocationMapMaximumPutAttempts),
-						storageTypeName),
-					locationBlobMap),
-				digestKeyFormat,
-				&globalLock,
-				storageTypeName),
+			BlobAccess:      blobAccess,
 			DigestKeyFormat: digestKeyFormat,
 		}, backendType, nil
 	case *pb.BlobAccessConfiguration_ReadFallback:
@@ -439,8 +1274,17 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 		if err != nil {
 			return BlobAccessInfo{}, "", err
 		}
+		var blobAccess blobstore.BlobAccess
+		if speculativeReadDelay := backend.ReadFallback.SpeculativeReadDelay; speculativeReadDelay != nil {
+			if err := speculativeReadDelay.CheckValid(); err != nil {
+				return BlobAccessInfo{}, "", util.StatusWrap(err, "Failed to obtain speculative read delay")
+			}
+			blobAccess = readfallback.NewSpeculativeReadFallbackBlobAccess(primary.BlobAccess, secondary.BlobAccess, replicator, readBufferFactory, clock.SystemClock, speculativeReadDelay.AsDuration())
+		} else {
+			blobAccess = readfallback.NewReadFallbackBlobAccess(primary.BlobAccess, secondary.BlobAccess, replicator)
+		}
 		return BlobAccessInfo{
-			BlobAccess:      readfallback.NewReadFallbackBlobAccess(primary.BlobAccess, secondary.BlobAccess, replicator),
+			BlobAccess:      blobAccess,
 			DigestKeyFormat: primary.DigestKeyFormat.Combine(secondary.DigestKeyFormat),
 		}, "read_fallback", nil
 	case *pb.BlobAccessConfiguration_Demultiplexing:
@@ -488,6 +1332,167 @@ func newNestedBlobAccessBare(configuration *pb.BlobAccessConfiguration, creator
 	return creator.NewCustomBlobAccess(configuration)
 }
 
+// newNWayMirroredBlobAccess creates a MirroredBlobAccess for an
+// arbitrary number of backends, creating a replicator for every pair of
+// backends.
+func newNWayMirroredBlobAccess(configuration *pb.MirroredBlobAccessConfiguration, creator BlobAccessCreator) (BlobAccessInfo, string, error) {
+	if configuration.BackendA != nil || configuration.BackendB != nil {
+		return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Backends A and B cannot be combined with a list of backends")
+	}
+	n := len(configuration.Backends)
+	if n > mirrored.MaximumBackends {
+		return BlobAccessInfo{}, "", status.Errorf(codes.InvalidArgument, "At most %d backends may be mirrored", mirrored.MaximumBackends)
+	}
+	writeQuorum := n
+	if configuration.WriteQuorum != 0 {
+		if int(configuration.WriteQuorum) > n {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Write quorum cannot exceed the number of backends")
+		}
+		writeQuorum = int(configuration.WriteQuorum)
+	}
+	readFanOut := n
+	if configuration.ReadFanOut != 0 {
+		if int(configuration.ReadFanOut) > n {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Read fan-out cannot exceed the number of backends")
+		}
+		readFanOut = int(configuration.ReadFanOut)
+	}
+	readQuorum := 1
+	if configuration.ReadQuorum != 0 {
+		if int(configuration.ReadQuorum) > n {
+			return BlobAccessInfo{}, "", status.Error(codes.InvalidArgument, "Read quorum cannot exceed the number of backends")
+		}
+		readQuorum = int(configuration.ReadQuorum)
+	}
+	readPreference, err := newMirroredReadPreference(configuration, n)
+	if err != nil {
+		return BlobAccessInfo{}, "", err
+	}
+
+	backends := make([]BlobAccessInfo, 0, n)
+	blobAccesses := make([]blobstore.BlobAccess, 0, n)
+	for _, backendConfiguration := range configuration.Backends {
+		backend, err := NewNestedBlobAccess(backendConfiguration, creator)
+		if err != nil {
+			return BlobAccessInfo{}, "", err
+		}
+		backends = append(backends, backend)
+		blobAccesses = append(blobAccesses, backend.BlobAccess)
+	}
+	digestKeyFormat := backends[0].DigestKeyFormat
+	replicators := make([][]replication.BlobReplicator, n)
+	for i, source := range backends {
+		digestKeyFormat = digestKeyFormat.Combine(source.DigestKeyFormat)
+		replicators[i] = make([]replication.BlobReplicator, n)
+		for j, sink := range backends {
+			if i != j {
+				replicator, err := NewBlobReplicatorFromConfiguration(configuration.Replicator, source.BlobAccess, sink, creator)
+				if err != nil {
+					return BlobAccessInfo{}, "", err
+				}
+				replicators[i][j] = replicator
+			}
+		}
+	}
+	return BlobAccessInfo{
+		BlobAccess: mirrored.NewNWayMirroredBlobAccess(
+			blobAccesses,
+			replicators,
+			writeQuorum,
+			readFanOut,
+			readQuorum,
+			int(configuration.MaximumQuorumReadSizeBytes),
+			readPreference),
+		DigestKeyFormat: digestKeyFormat,
+	}, "mirrored", nil
+}
+
+// newMirroredReadPreference creates the ReadPreference that is used by
+// MirroredBlobAccess to determine which backend to read from first.
+func newMirroredReadPreference(configuration *pb.MirroredBlobAccessConfiguration, backendsCount int) (mirrored.ReadPreference, error) {
+	switch configuration.ReadPreference {
+	case pb.MirroredBlobAccessConfiguration_ROUND_ROBIN:
+		candidates := make([]int, 0, backendsCount)
+		for i := 0; i < backendsCount; i++ {
+			candidates = append(candidates, i)
+		}
+		return mirrored.NewRoundRobinReadPreference(candidates), nil
+	case pb.MirroredBlobAccessConfiguration_PRIMARY:
+		return mirrored.NewRoundRobinReadPreference([]int{0}), nil
+	case pb.MirroredBlobAccessConfiguration_LOWEST_LATENCY:
+		smoothingFactor := configuration.LatencySmoothingFactor
+		if smoothingFactor == 0 {
+			smoothingFactor = 0.1
+		} else if smoothingFactor < 0 || smoothingFactor > 1 {
+			return nil, status.Error(codes.InvalidArgument, "Latency smoothing factor must be between 0 and 1")
+		}
+		return mirrored.NewLowestLatencyReadPreference(clock.SystemClock, backendsCount, smoothingFactor), nil
+	case pb.MirroredBlobAccessConfiguration_LOCALITY:
+		if len(configuration.BackendZones) != backendsCount {
+			return nil, status.Errorf(codes.InvalidArgument, "Expected %d backend zones, while %d were provided", backendsCount, len(configuration.BackendZones))
+		}
+		if configuration.Zone == "" {
+			return nil, status.Error(codes.InvalidArgument, "No zone provided")
+		}
+		var candidates []int
+		for i, zone := range configuration.BackendZones {
+			if zone == configuration.Zone {
+				candidates = append(candidates, i)
+			}
+		}
+		if len(candidates) == 0 {
+			for i := 0; i < backendsCount; i++ {
+				candidates = append(candidates, i)
+			}
+		}
+		return mirrored.NewRoundRobinReadPreference(candidates), nil
+	default:
+		return nil, status.Error(codes.InvalidArgument, "Unknown read preference")
+	}
+}
+
+// newFaultInjectionPolicyFromConfiguration converts the configuration of
+// faults to inject into a single type of operation to a
+// FaultInjectionPolicy.
+func newFaultInjectionPolicyFromConfiguration(configuration *pb.FaultInjectingBlobAccessConfiguration_Policy) (blobstore.FaultInjectionPolicy, error) {
+	if configuration == nil {
+		return blobstore.FaultInjectionPolicy{}, nil
+	}
+	var policy blobstore.FaultInjectionPolicy
+	if configuration.MinimumLatency != nil {
+		if err := configuration.MinimumLatency.CheckValid(); err != nil {
+			return blobstore.FaultInjectionPolicy{}, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to obtain minimum latency")
+		}
+		policy.MinimumLatency = configuration.MinimumLatency.AsDuration()
+	}
+	if configuration.MaximumLatency != nil {
+		if err := configuration.MaximumLatency.CheckValid(); err != nil {
+			return blobstore.FaultInjectionPolicy{}, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to obtain maximum latency")
+		}
+		policy.MaximumLatency = configuration.MaximumLatency.AsDuration()
+	}
+	if policy.MaximumLatency < policy.MinimumLatency {
+		return blobstore.FaultInjectionPolicy{}, status.Error(codes.InvalidArgument, "Maximum latency must be at least the minimum latency")
+	}
+	if p := configuration.ErrorProbability; p < 0 || p > 1 {
+		return blobstore.FaultInjectionPolicy{}, status.Error(codes.InvalidArgument, "Error probability must be between 0 and 1")
+	}
+	policy.ErrorProbability = configuration.ErrorProbability
+	if configuration.Error != nil {
+		policy.Error = status.ErrorProto(configuration.Error)
+		if policy.Error == nil {
+			return blobstore.FaultInjectionPolicy{}, status.Error(codes.InvalidArgument, "Error must have a non-zero status code")
+		}
+	} else {
+		policy.Error = status.Error(codes.Unavailable, "Fault injected")
+	}
+	if p := configuration.TruncationProbability; p < 0 || p > 1 {
+		return blobstore.FaultInjectionPolicy{}, status.Error(codes.InvalidArgument, "Truncation probability must be between 0 and 1")
+	}
+	policy.TruncationProbability = configuration.TruncationProbability
+	return policy, nil
+}
+
 // NewNestedBlobAccess may be called by
 // BlobAccessCreator.NewCustomBlobAccess() to create BlobAccess
 // objects for instances nested inside the configuration.
@@ -496,13 +1501,41 @@ func NewNestedBlobAccess(configuration *pb.BlobAccessConfiguration, creator Blob
 		return BlobAccessInfo{}, status.Error(codes.InvalidArgument, "Storage configuration not specified")
 	}
 
+	registry := creator.GetRegistry()
+	topologyNode := registry.pushTopologyNode(creator.GetStorageTypeName())
 	backend, backendType, err := newNestedBlobAccessBare(configuration, creator)
+	name := fmt.Sprintf("%s_%s", creator.GetStorageTypeName(), backendType)
+	registry.popTopologyNode(backendType, name, backend.DigestKeyFormat, err == nil)
 	if err != nil {
 		return BlobAccessInfo{}, err
 	}
+	if blobDeleter, ok := backend.BlobAccess.(blobstore.BlobDeleter); ok {
+		registry.registerBlobDeleter(creator.GetStorageTypeName(), topologyNode.getPath, blobDeleter)
+	} else if topologyNode.holdsData() {
+		registry.registerNonDeletingBackend(creator.GetStorageTypeName(), topologyNode.getPath)
+	}
+	if statisticsReporter, ok := backend.BlobAccess.(blobstore.StatisticsReporter); ok {
+		registry.registerStatisticsReporter(creator.GetStorageTypeName(), topologyNode.getPath, statisticsReporter)
+	}
+	if blobExpirer, ok := backend.BlobAccess.(blobstore.BlobExpirer); ok {
+		registry.registerBlobExpirer(creator.GetStorageTypeName(), topologyNode.getPath, blobExpirer)
+	}
+	if backendType == "chunking" {
+		registry.registerNonExpirableBackend(creator.GetStorageTypeName(), topologyNode.getPath)
+	}
+	blobAccess := blobstore.NewMetricsBlobAccess(backend.BlobAccess, clock.SystemClock, name, registry.metricsInstanceNamePrefixes)
+	if registry.activeOperationTracker != nil {
+		blobAccess = blobstore.NewActiveOperationTrackingBlobAccess(blobAccess, registry.activeOperationTracker, topologyNode.getPath)
+	}
+	if backend.KeyEnumerator != nil {
+		registry.registerKeyEnumerator(creator.GetStorageTypeName(), topologyNode.getPath, blobAccess, backend.KeyEnumerator)
+	} else if topologyNode.holdsData() {
+		registry.registerNonEnumeratingBackend(creator.GetStorageTypeName(), topologyNode.getPath)
+	}
 	return BlobAccessInfo{
-		BlobAccess:      blobstore.NewMetricsBlobAccess(backend.BlobAccess, clock.SystemClock, fmt.Sprintf("%s_%s", creator.GetStorageTypeName(), backendType)),
+		BlobAccess:      blobAccess,
 		DigestKeyFormat: backend.DigestKeyFormat,
+		KeyEnumerator:   backend.KeyEnumerator,
 	}, nil
 }
 
@@ -523,10 +1556,10 @@ func NewBlobAccessFromConfiguration(configuration *pb.BlobAccessConfiguration, c
 // create BlobAccess objects for both the Content Addressable Storage
 // and Action Cache. Most Buildbarn components tend to require access to
 // both these data stores.
-func NewCASAndACBlobAccessFromConfiguration(configuration *pb.BlobstoreConfiguration, grpcClientFactory grpc.ClientFactory, maximumMessageSizeBytes int) (blobstore.BlobAccess, blobstore.BlobAccess, error) {
+func NewCASAndACBlobAccessFromConfiguration(registry *Registry, configuration *pb.BlobstoreConfiguration, grpcClientFactory grpc.ClientFactory, maximumMessageSizeBytes int) (blobstore.BlobAccess, blobstore.BlobAccess, error) {
 	contentAddressableStorage, err := NewBlobAccessFromConfiguration(
 		configuration.GetContentAddressableStorage(),
-		NewCASBlobAccessCreator(grpcClientFactory, maximumMessageSizeBytes))
+		NewCASBlobAccessCreator(registry, grpcClientFactory, maximumMessageSizeBytes))
 	if err != nil {
 		return nil, nil, util.StatusWrap(err, "Failed to create Content Addressable Storage")
 	}
@@ -534,6 +1567,7 @@ func NewCASAndACBlobAccessFromConfiguration(configuration *pb.BlobstoreConfigura
 	actionCache, err := NewBlobAccessFromConfiguration(
 		configuration.GetActionCache(),
 		NewACBlobAccessCreator(
+			registry,
 			contentAddressableStorage,
 			grpcClientFactory,
 			maximumMessageSizeBytes))
@@ -543,3 +1577,184 @@ func NewCASAndACBlobAccessFromConfiguration(configuration *pb.BlobstoreConfigura
 
 	return contentAddressableStorage.BlobAccess, actionCache.BlobAccess, nil
 }
+
+// NewStripingBlockDeviceFromConfiguration opens a list of block
+// devices and combines them into a single block device, onto which a
+// given number of blocks may be stored. Blocks are striped across the
+// block devices. The sector size and number of sectors per block are
+// returned.
+func NewStripingBlockDeviceFromConfiguration(configurations []*blockdevice_pb.Configuration, blockCount int64, mayZeroInitialize bool) (blockdevice.BlockDevice, int, int64, error) {
+	blockDevices := make([]blockdevice.BlockDevice, 0, len(configurations))
+	sectorSizeBytes := 0
+	minimumSectorCount := int64(0)
+	for i, configuration := range configurations {
+		blockDevice, currentSectorSizeBytes, sectorCount, err := blockdevice.NewBlockDeviceFromConfiguration(configuration, mayZeroInitialize)
+		if err != nil {
+			return nil, 0, 0, util.StatusWrapf(err, "Failed to open blocks block device %d", i)
+		}
+		if i == 0 {
+			sectorSizeBytes = currentSectorSizeBytes
+			minimumSectorCount = sectorCount
+		} else {
+			if currentSectorSizeBytes != sectorSizeBytes {
+				return nil, 0, 0, status.Errorf(codes.InvalidArgument, "Blocks block device %d has sector size %d, while blocks block device 0 has sector size %d", i, currentSectorSizeBytes, sectorSizeBytes)
+			}
+			if minimumSectorCount > sectorCount {
+				minimumSectorCount = sectorCount
+			}
+		}
+		blockDevices = append(blockDevices, blockDevice)
+	}
+
+	// Distribute blocks evenly across all block devices. Use the
+	// block size as the stripe size, so that blocks are never split
+	// across block devices.
+	blockDeviceCount := int64(len(blockDevices))
+	blocksPerBlockDevice := (blockCount + blockDeviceCount - 1) / blockDeviceCount
+	blockSectorCount := minimumSectorCount / blocksPerBlockDevice
+	return blockdevice.NewStripingBlockDevice(blockDevices, blockSectorCount*int64(sectorSizeBytes)), sectorSizeBytes, blockSectorCount, nil
+}
+
+// newFrequencySketchParametersFromConfiguration validates the
+// parameters of a frequency sketch used by a BlobRefreshPolicy.
+func newFrequencySketchParametersFromConfiguration(configuration *pb.LocalBlobAccessConfiguration_FrequencySketch) (int, uint64, error) {
+	if configuration == nil || configuration.Width == 0 || configuration.AgingInterval == 0 {
+		return 0, 0, status.Error(codes.InvalidArgument, "Frequency sketch width and aging interval must be positive")
+	}
+	return int(configuration.Width), configuration.AgingInterval, nil
+}
+
+// newBlobRefreshPolicyFromConfiguration creates the BlobRefreshPolicy
+// that is used by LocalBlobAccess to decide which blobs to refresh.
+func newBlobRefreshPolicyFromConfiguration(configuration *pb.LocalBlobAccessConfiguration) (local.BlobRefreshPolicy, error) {
+	switch policy := configuration.RefreshPolicy.(type) {
+	case nil, *pb.LocalBlobAccessConfiguration_FifoRefreshPolicy:
+		return local.FIFOBlobRefreshPolicy, nil
+	case *pb.LocalBlobAccessConfiguration_LruRefreshPolicy:
+		return local.LRUBlobRefreshPolicy, nil
+	case *pb.LocalBlobAccessConfiguration_SegmentedLruRefreshPolicy_:
+		sketchWidth, agingInterval, err := newFrequencySketchParametersFromConfiguration(policy.SegmentedLruRefreshPolicy.Sketch)
+		if err != nil {
+			return nil, err
+		}
+		return local.NewSegmentedLRUBlobRefreshPolicy(sketchWidth, agingInterval), nil
+	case *pb.LocalBlobAccessConfiguration_TinyLfuRefreshPolicy_:
+		sketchWidth, agingInterval, err := newFrequencySketchParametersFromConfiguration(policy.TinyLfuRefreshPolicy.Sketch)
+		if err != nil {
+			return nil, err
+		}
+		return local.NewTinyLFUBlobRefreshPolicy(sketchWidth, agingInterval, policy.TinyLfuRefreshPolicy.MinimumPreviousAccesses), nil
+	default:
+		return nil, status.Error(codes.InvalidArgument, "Unknown refresh policy")
+	}
+}
+
+// getInMemoryBlockSizeBytes computes the size of blocks of
+// LocalBlobAccess when stored in memory. If configured, the size is
+// limited such that all blocks fit in a fraction of the memory limit of
+// the container.
+func getInMemoryBlockSizeBytes(configuration *pb.LocalBlobAccessConfiguration, blocksInMemory *pb.LocalBlobAccessConfiguration_BlocksInMemory) (int64, error) {
+	blockSizeBytes := blocksInMemory.BlockSizeBytes
+	fraction := blocksInMemory.ContainerMemoryLimitFraction
+	if fraction == 0 {
+		return blockSizeBytes, nil
+	}
+	if fraction < 0 || fraction > 1 {
+		return 0, status.Errorf(codes.InvalidArgument, "Container memory limit fraction must be in range (0, 1], not %f", fraction)
+	}
+	containerMemoryLimit, err := global.GetContainerMemoryLimit(global.CgroupFilesystemPath)
+	if err != nil {
+		return 0, util.StatusWrap(err, "Failed to obtain container memory limit")
+	}
+
+	blockCount := int64(configuration.OldBlocks + configuration.CurrentBlocks + configuration.NewBlocks)
+	if largeBlobPool := configuration.LargeBlobPool; largeBlobPool != nil {
+		blockCount += int64(largeBlobPool.OldBlocks + largeBlobPool.CurrentBlocks + largeBlobPool.NewBlocks)
+	}
+	if blockCount <= 0 {
+		return 0, status.Error(codes.InvalidArgument, "The total number of blocks must be positive")
+	}
+	maximumBlockSizeBytes := int64(float64(containerMemoryLimit)*fraction) / blockCount
+	if maximumBlockSizeBytes <= 0 {
+		return 0, status.Errorf(codes.FailedPrecondition, "Container memory limit of %d bytes is too small to store %d blocks", containerMemoryLimit, blockCount)
+	}
+	if blockSizeBytes == 0 || blockSizeBytes > maximumBlockSizeBytes {
+		if blockSizeBytes != 0 {
+			log.Printf("Reducing block size from %d to %d bytes, so that all blocks fit in the container memory limit", blockSizeBytes, maximumBlockSizeBytes)
+		}
+		blockSizeBytes = maximumBlockSizeBytes
+	}
+	return blockSizeBytes, nil
+}
+
+// newLargeBlobPoolFromConfiguration creates the storage backend for
+// the large blob pool of LocalBlobAccess. Its blocks are obtained from
+// the same BlockAllocator as the ones used for regular blobs, but it
+// uses its own BlockList and key-location map.
+func newLargeBlobPoolFromConfiguration(configuration *pb.LocalBlobAccessConfiguration, blockAllocator local.BlockAllocator, sectorSizeBytes int, blockSectorCount int64, digestKeyFormat digest.KeyFormat, name string) (blobstore.BlobAccess, error) {
+	largeBlobPool := configuration.LargeBlobPool
+	if largeBlobPool.KeyLocationMapEntries <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "The key-location map of the large blob pool must have a positive number of entries")
+	}
+
+	var blockList local.BlockList = local.NewVolatileBlockList(
+		blockAllocator,
+		sectorSizeBytes,
+		blockSectorCount)
+	maximumBlobSizeBytes := int64(sectorSizeBytes) * blockSectorCount
+	if configuration.BlobChecksums != nil {
+		blockList = local.NewChecksummingBlockList(blockList)
+		maximumBlobSizeBytes -= local.BlobChecksumSizeBytes
+	}
+	if largeBlobPool.CutoffSizeBytes < 0 || largeBlobPool.CutoffSizeBytes >= maximumBlobSizeBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "The cutoff size of the large blob pool must be between 0 and %d bytes", maximumBlobSizeBytes)
+	}
+
+	refreshPolicy, err := newBlobRefreshPolicyFromConfiguration(configuration)
+	if err != nil {
+		return nil, err
+	}
+	locationBlobMap := local.NewOldCurrentNewLocationBlobMap(
+		blockList,
+		util.DefaultErrorLogger,
+		name,
+		maximumBlobSizeBytes,
+		int(largeBlobPool.OldBlocks),
+		int(largeBlobPool.CurrentBlocks),
+		int(largeBlobPool.NewBlocks),
+		/* initialBlocksCount = */ 0,
+		refreshPolicy)
+	recordsCount := int(largeBlobPool.KeyLocationMapEntries)
+	locationRecordArray := local.NewInMemoryLocationRecordArray(recordsCount, locationBlobMap)
+	lock := local.NewShardedRWMutex(runtime.NumCPU())
+
+	if blobChecksums := configuration.BlobChecksums; blobChecksums != nil && blobChecksums.ScrubbingRecordsPerSecond > 0 {
+		scrubber := local.NewBlobChecksumScrubber(
+			locationRecordArray,
+			func() int { return recordsCount },
+			locationBlobMap,
+			lock,
+			name)
+		go func() {
+			for range time.Tick(time.Second) {
+				if err := scrubber.ScrubRecords(int(blobChecksums.ScrubbingRecordsPerSecond)); err != nil {
+					util.DefaultErrorLogger.Log(util.StatusWrap(err, "Failed to scrub blobs in large blob pool"))
+				}
+			}
+		}()
+	}
+
+	return local.NewKeyBlobMapBackedBlobAccess(
+		local.NewLocationBasedKeyBlobMap(
+			local.NewHashingKeyLocationMap(
+				locationRecordArray,
+				recordsCount,
+				random.CryptoThreadSafeGenerator.Uint64(),
+				configuration.KeyLocationMapMaximumGetAttempts,
+				int(configuration.KeyLocationMapMaximumPutAttempts),
+				name),
+			locationBlobMap),
+		digestKeyFormat,
+		lock,
+		name), nil
+}
@@ -1,13 +1,20 @@
 package configuration
 
 import (
+	"context"
+	"time"
+
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
+	"github.com/buildbarn/bb-storage/pkg/clock"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/util"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 // NewBlobReplicatorFromConfiguration creates a BlobReplicator object
@@ -17,16 +24,65 @@ func NewBlobReplicatorFromConfiguration(configuration *pb.BlobReplicatorConfigur
 		return nil, status.Error(codes.InvalidArgument, "Replicator configuration not specified")
 	}
 	switch mode := configuration.Mode.(type) {
+	case *pb.BlobReplicatorConfiguration_Asynchronous:
+		base, err := NewBlobReplicatorFromConfiguration(mode.Asynchronous.Base, source, sink, creator)
+		if err != nil {
+			return nil, err
+		}
+		if mode.Asynchronous.MaximumQueueSize == 0 {
+			return nil, status.Error(codes.InvalidArgument, "Maximum queue size must be positive")
+		}
+		stateDirectory, err := filesystem.NewLocalDirectory(mode.Asynchronous.StateDirectoryPath)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to open state directory")
+		}
+		// Register the base replicator, so that pausing causes
+		// the workers to stop replicating objects.
+		base = creator.GetRegistry().registerBlobReplicator("asynchronous", base)
+		replicator, err := replication.NewAsynchronousBlobReplicator(source, base, clock.SystemClock, stateDirectory, int(mode.Asynchronous.MaximumQueueSize))
+		if err != nil {
+			return nil, err
+		}
+		for i := uint32(0); i < mode.Asynchronous.Workers; i++ {
+			go func() {
+				for {
+					if err := replicator.ProcessSingle(context.Background()); err != nil {
+						util.DefaultErrorLogger.Log(err)
+					}
+				}
+			}()
+		}
+		return replicator, nil
 	case *pb.BlobReplicatorConfiguration_Deduplicating:
 		base, err := NewBlobReplicatorFromConfiguration(mode.Deduplicating, source, sink, creator)
 		if err != nil {
 			return nil, err
 		}
-		return replication.NewDeduplicatingBlobReplicator(base, sink.BlobAccess, sink.DigestKeyFormat), nil
+		return creator.GetRegistry().registerBlobReplicator(
+			"deduplicating",
+			replication.NewDeduplicatingBlobReplicator(base, sink.BlobAccess, sink.DigestKeyFormat)), nil
 	case *pb.BlobReplicatorConfiguration_Local:
 		return replication.NewLocalBlobReplicator(source, sink.BlobAccess), nil
 	case *pb.BlobReplicatorConfiguration_Noop:
 		return replication.NewNoopBlobReplicator(source), nil
+	case *pb.BlobReplicatorConfiguration_Prioritized:
+		base, err := NewBlobReplicatorFromConfiguration(mode.Prioritized.Base, source, sink, creator)
+		if err != nil {
+			return nil, err
+		}
+		if mode.Prioritized.MaximumConcurrency == 0 {
+			return nil, status.Error(codes.InvalidArgument, "Maximum concurrency must be positive")
+		}
+		replicator := replication.NewPrioritizedBlobReplicator(base, int(mode.Prioritized.MaximumConcurrency))
+		creator.GetRegistry().reloadRegistry.Register(mode.Prioritized, func(newConfiguration proto.Message) error {
+			maximumConcurrency := newConfiguration.(*pb.PrioritizedBlobReplicatorConfiguration).MaximumConcurrency
+			if maximumConcurrency == 0 {
+				return status.Error(codes.InvalidArgument, "Maximum concurrency must be positive")
+			}
+			replicator.SetMaximumConcurrency(int(maximumConcurrency))
+			return nil
+		})
+		return replicator, nil
 	case *pb.BlobReplicatorConfiguration_Queued:
 		base, err := NewBlobReplicatorFromConfiguration(mode.Queued.Base, source, sink, creator)
 		if err != nil {
@@ -36,8 +92,80 @@ func NewBlobReplicatorFromConfiguration(configuration *pb.BlobReplicatorConfigur
 		if err != nil {
 			return nil, err
 		}
-		return replication.NewQueuedBlobReplicator(source, base, existenceCache), nil
+		return creator.GetRegistry().registerBlobReplicator(
+			"queued",
+			replication.NewQueuedBlobReplicator(source, base, existenceCache)), nil
+	case *pb.BlobReplicatorConfiguration_Scheduled:
+		base, err := NewBlobReplicatorFromConfiguration(mode.Scheduled.Base, source, sink, creator)
+		if err != nil {
+			return nil, err
+		}
+		windows, location, err := newReplicationScheduleFromConfiguration(mode.Scheduled)
+		if err != nil {
+			return nil, err
+		}
+		replicator := replication.NewScheduledBlobReplicator(base, clock.SystemClock, windows, location, int(mode.Scheduled.OffPeakConcurrency))
+		creator.GetRegistry().reloadRegistry.Register(mode.Scheduled, func(newConfiguration proto.Message) error {
+			newScheduled := newConfiguration.(*pb.ScheduledBlobReplicatorConfiguration)
+			windows, location, err := newReplicationScheduleFromConfiguration(newScheduled)
+			if err != nil {
+				return err
+			}
+			replicator.SetSchedule(windows, location, int(newScheduled.OffPeakConcurrency))
+			return nil
+		})
+		return replicator, nil
 	default:
 		return creator.NewCustomBlobReplicator(configuration, source, sink)
 	}
 }
+
+// newReplicationScheduleFromConfiguration converts the windows and
+// time zone of a ScheduledBlobReplicator configuration.
+func newReplicationScheduleFromConfiguration(configuration *pb.ScheduledBlobReplicatorConfiguration) ([]replication.ReplicationWindow, *time.Location, error) {
+	windows := make([]replication.ReplicationWindow, 0, len(configuration.Windows))
+	for i, windowConfiguration := range configuration.Windows {
+		window, err := newReplicationWindowFromConfiguration(windowConfiguration)
+		if err != nil {
+			return nil, nil, util.StatusWrapf(err, "Window at index %d", i)
+		}
+		windows = append(windows, window)
+	}
+	location := time.UTC
+	if timeZone := configuration.TimeZone; timeZone != "" {
+		var err error
+		location, err = time.LoadLocation(timeZone)
+		if err != nil {
+			return nil, nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to load time zone")
+		}
+	}
+	return windows, location, nil
+}
+
+// newReplicationWindowFromConfiguration converts the configuration of a
+// window during which ScheduledBlobReplicator lets replication run at
+// full speed to a ReplicationWindow.
+func newReplicationWindowFromConfiguration(configuration *pb.ScheduledBlobReplicatorConfiguration_Window) (replication.ReplicationWindow, error) {
+	var window replication.ReplicationWindow
+	for _, day := range configuration.DaysOfWeek {
+		if day > 6 {
+			return replication.ReplicationWindow{}, status.Errorf(codes.InvalidArgument, "Invalid day of the week %d", day)
+		}
+		window.DaysOfWeek = append(window.DaysOfWeek, time.Weekday(day))
+	}
+	if err := configuration.Start.CheckValid(); err != nil {
+		return replication.ReplicationWindow{}, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to obtain start")
+	}
+	window.Start = configuration.Start.AsDuration()
+	if window.Start < 0 || window.Start >= 24*time.Hour {
+		return replication.ReplicationWindow{}, status.Error(codes.InvalidArgument, "Start must be between 0 and 24 hours")
+	}
+	if err := configuration.Duration.CheckValid(); err != nil {
+		return replication.ReplicationWindow{}, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to obtain duration")
+	}
+	window.Duration = configuration.Duration.AsDuration()
+	if window.Duration <= 0 {
+		return replication.ReplicationWindow{}, status.Error(codes.InvalidArgument, "Duration must be positive")
+	}
+	return window, nil
+}
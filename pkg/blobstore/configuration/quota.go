@@ -0,0 +1,75 @@
+package configuration
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (reg *Registry) serveQuota(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		e.Encode(reg.getQuotaUsage())
+	case http.MethodPost:
+		if err := reg.resetQuotaUsage(r.FormValue("storage_type"), r.FormValue("instance_name_prefix")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (reg *Registry) registerQuotaEnforcingBlobAccess(storageType string, blobAccess blobstore.QuotaEnforcingBlobAccess) {
+	reg.quotaLock.Lock()
+	defer reg.quotaLock.Unlock()
+	reg.quotaEnforcingBlobAccesses[storageType] = append(reg.quotaEnforcingBlobAccesses[storageType], blobAccess)
+}
+
+func (reg *Registry) getQuotaUsage() map[string][]blobstore.QuotaUsage {
+	reg.quotaLock.Lock()
+	defer reg.quotaLock.Unlock()
+	usage := map[string][]blobstore.QuotaUsage{}
+	for storageType, blobAccesses := range reg.quotaEnforcingBlobAccesses {
+		for _, blobAccess := range blobAccesses {
+			usage[storageType] = append(usage[storageType], blobAccess.GetUsage()...)
+		}
+	}
+	return usage
+}
+
+func (reg *Registry) resetQuotaUsage(storageType, instanceNamePrefix string) error {
+	parsedInstanceNamePrefix, err := digest.NewInstanceName(instanceNamePrefix)
+	if err != nil {
+		return err
+	}
+
+	reg.quotaLock.Lock()
+	defer reg.quotaLock.Unlock()
+	blobAccesses, ok := reg.quotaEnforcingBlobAccesses[storageType]
+	if !ok {
+		return status.Errorf(codes.NotFound, "No quotas are configured for storage type %#v", storageType)
+	}
+	// Reset usage of all backends having a quota for the instance
+	// name prefix, failing only if none of them have one.
+	var lastErr error
+	found := false
+	for _, blobAccess := range blobAccesses {
+		if err := blobAccess.ResetUsage(parsedInstanceNamePrefix); err == nil {
+			found = true
+		} else {
+			lastErr = err
+		}
+	}
+	if !found {
+		return lastErr
+	}
+	return nil
+}
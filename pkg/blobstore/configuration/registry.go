@@ -0,0 +1,160 @@
+package configuration
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	global_pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/global"
+	"github.com/buildbarn/bb-storage/pkg/reload"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// Registry keeps track of storage backends and replicators that have
+// been constructed by NewNestedBlobAccess() and
+// NewBlobReplicatorFromConfiguration(), so that they can be managed
+// after construction. This is used to expose the storage topology,
+// quotas and statistics through the diagnostics HTTP server, to delete
+// blobs through the administrative gRPC service, to perform
+// reachability-based garbage collection, and to reconfigure components
+// when the configuration of the process is reloaded.
+//
+// A single Registry should be shared by all BlobAccessCreators of a
+// process, so that the diagnostics HTTP server displays all storage
+// backends.
+type Registry struct {
+	// Keeps track of all operations against storage backends that
+	// are in progress. It is nil if tracking is disabled, as
+	// tracking operations introduces some overhead.
+	activeOperationTracker *blobstore.ActiveOperationTracker
+
+	// Instance name prefixes by which the operation metrics of
+	// storage backends are labeled.
+	metricsInstanceNamePrefixes []digest.InstanceName
+
+	// Components that can be reconfigured when the configuration
+	// of the process is reloaded.
+	reloadRegistry *reload.Registry
+
+	// The topology is tracked by maintaining a stack of BlobAccess
+	// objects that are in the process of being constructed. Nodes
+	// are attached to their parent upon successful construction.
+	// BlobAccess objects are constructed from a single goroutine
+	// during startup, meaning that a single stack is sufficient.
+	topologyLock  sync.Mutex
+	topologyStack []*TopologyNode
+	topologyRoots []*TopologyNode
+
+	// Instances of QuotaEnforcingBlobAccess, grouped by storage
+	// type, so that their usage may be inspected and reset.
+	quotaLock                  sync.Mutex
+	quotaEnforcingBlobAccesses map[string][]blobstore.QuotaEnforcingBlobAccess
+
+	// Instances of IntrospectableBlobReplicator, so that their
+	// progress may be inspected and replication may be paused.
+	replicatorsLock sync.Mutex
+	replicators     []registeredBlobReplicator
+
+	// Functions for creating snapshots of instances of
+	// LocalBlobAccess that have persistency and snapshots enabled,
+	// grouped by storage type.
+	localSnapshotLock     sync.Mutex
+	localSnapshotCreators map[string][]func() error
+
+	// Functions for flushing the state of instances of
+	// LocalBlobAccess that have persistency enabled.
+	localFlushersLock sync.Mutex
+	localFlushers     []func() error
+
+	// Storage backends that are capable of reporting statistics,
+	// deleting individual blobs, expiring blobs in bulk and
+	// enumerating their contents, grouped by storage type. The
+	// paths of storage backends that hold data, but are not capable
+	// of deleting individual blobs or enumerating their contents,
+	// are tracked as well, as are the paths of storage backends
+	// whose contents cannot be expired based on reachability.
+	statisticsReportersLock sync.Mutex
+	statisticsReporters     map[string][]registeredStatisticsReporter
+	blobDeletersLock        sync.Mutex
+	blobDeleters            map[string][]registeredBlobDeleter
+	nonDeletingBackends     map[string][]func() string
+	blobExpirersLock        sync.Mutex
+	blobExpirers            map[string][]registeredBlobExpirer
+	nonExpirableBackends    map[string][]func() string
+	keyEnumeratorsLock      sync.Mutex
+	keyEnumerators          map[string][]registeredKeyEnumerator
+	nonEnumeratingBackends  map[string][]func() string
+}
+
+// NewRegistry creates a Registry that does not track any storage
+// backends or replicators yet. If an ActiveOperationTracker is
+// provided, operations against storage backends constructed using the
+// Registry are tracked, so that they can be listed through the
+// diagnostics HTTP server.
+//
+// Operation metrics of storage backends are labeled by the longest
+// matching instance name prefix. By default, operation metrics are not
+// labeled by instance name, as doing so for arbitrary instance names
+// could lead to unbounded cardinality.
+func NewRegistry(activeOperationTracker *blobstore.ActiveOperationTracker, metricsInstanceNamePrefixes []digest.InstanceName) *Registry {
+	return &Registry{
+		activeOperationTracker:      activeOperationTracker,
+		metricsInstanceNamePrefixes: metricsInstanceNamePrefixes,
+		reloadRegistry:              reload.NewRegistry(),
+		quotaEnforcingBlobAccesses:  map[string][]blobstore.QuotaEnforcingBlobAccess{},
+		localSnapshotCreators:       map[string][]func() error{},
+		statisticsReporters:         map[string][]registeredStatisticsReporter{},
+		blobDeleters:                map[string][]registeredBlobDeleter{},
+		nonDeletingBackends:         map[string][]func() string{},
+		blobExpirers:                map[string][]registeredBlobExpirer{},
+		nonExpirableBackends:        map[string][]func() string{},
+		keyEnumerators:              map[string][]registeredKeyEnumerator{},
+		nonEnumeratingBackends:      map[string][]func() string{},
+	}
+}
+
+// NewRegistryFromConfiguration creates a Registry based on the global
+// configuration of the process. Operations against storage backends
+// are only tracked if they can be listed through the diagnostics HTTP
+// server.
+func NewRegistryFromConfiguration(configuration *global_pb.Configuration) (*Registry, error) {
+	var activeOperationTracker *blobstore.ActiveOperationTracker
+	if configuration.GetDiagnosticsHttpServer().GetEnableBlobstoreActiveOperations() {
+		activeOperationTracker = blobstore.NewActiveOperationTracker(clock.SystemClock)
+	}
+
+	instanceNamePrefixes := configuration.GetBlobstoreMetricsInstanceNamePrefixes()
+	metricsInstanceNamePrefixes := make([]digest.InstanceName, 0, len(instanceNamePrefixes))
+	for _, instanceNamePrefix := range instanceNamePrefixes {
+		metricsInstanceNamePrefix, err := digest.NewInstanceName(instanceNamePrefix)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Invalid blobstore metrics instance name prefix %#v", instanceNamePrefix)
+		}
+		metricsInstanceNamePrefixes = append(metricsInstanceNamePrefixes, metricsInstanceNamePrefix)
+	}
+	return NewRegistry(activeOperationTracker, metricsInstanceNamePrefixes), nil
+}
+
+// GetReloadRegistry returns the registry of components that can be
+// reconfigured when the configuration of the process is reloaded. It
+// should be provided to other components that support reloading, and
+// to the ConfigurationReloader of the process.
+func (reg *Registry) GetReloadRegistry() *reload.Registry {
+	return reg.reloadRegistry
+}
+
+// RegisterHTTPHandlers registers HTTP handlers for inspecting and
+// managing the storage backends and replicators tracked by the
+// Registry against a mux. Applications register these against the
+// default mux, so that the diagnostics HTTP server can forward traffic
+// to them if enabled.
+func (reg *Registry) RegisterHTTPHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/blobstore/active_operations", reg.serveActiveOperations)
+	mux.HandleFunc("/debug/blobstore/local/snapshot", reg.serveLocalSnapshot)
+	mux.HandleFunc("/debug/blobstore/quota", reg.serveQuota)
+	mux.HandleFunc("/debug/blobstore/replication", reg.serveReplication)
+	mux.HandleFunc("/debug/blobstore/statistics", reg.serveStatistics)
+	mux.HandleFunc("/debug/blobstore/topology", reg.serveTopology)
+}
@@ -0,0 +1,98 @@
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReplicatorState describes the state of a single replicator that was
+// constructed by NewBlobReplicatorFromConfiguration().
+type ReplicatorState struct {
+	Name        string                       `json:"name"`
+	StorageType string                       `json:"storage_type,omitempty"`
+	Status      replication.ReplicatorStatus `json:"status"`
+}
+
+type registeredBlobReplicator struct {
+	name           string
+	storageType    string
+	blobReplicator replication.IntrospectableBlobReplicator
+}
+
+func (reg *Registry) serveReplication(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		e.Encode(reg.GetReplicatorStates())
+	case http.MethodPost:
+		var paused bool
+		switch action := r.FormValue("action"); action {
+		case "pause":
+			paused = true
+		case "resume":
+			paused = false
+		default:
+			http.Error(w, fmt.Sprintf("Unknown action %#v", action), http.StatusBadRequest)
+			return
+		}
+		if err := reg.setReplicatorPaused(r.FormValue("name"), paused); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// registerBlobReplicator wraps a BlobReplicator, so that it can be
+// inspected through the diagnostics HTTP server. Replicators are named
+// after their type, followed by a sequence number.
+func (reg *Registry) registerBlobReplicator(replicatorType string, blobReplicator replication.BlobReplicator) replication.BlobReplicator {
+	introspectableBlobReplicator := replication.NewIntrospectableBlobReplicator(blobReplicator)
+	storageType := reg.getCurrentStorageType()
+
+	reg.replicatorsLock.Lock()
+	defer reg.replicatorsLock.Unlock()
+	reg.replicators = append(reg.replicators, registeredBlobReplicator{
+		name:           fmt.Sprintf("%s-%d", replicatorType, len(reg.replicators)),
+		storageType:    storageType,
+		blobReplicator: introspectableBlobReplicator,
+	})
+	return introspectableBlobReplicator
+}
+
+// GetReplicatorStates returns the state of all queued, deduplicating
+// and asynchronous replicators that have been constructed by this
+// Registry.
+func (reg *Registry) GetReplicatorStates() []ReplicatorState {
+	reg.replicatorsLock.Lock()
+	defer reg.replicatorsLock.Unlock()
+	states := make([]ReplicatorState, 0, len(reg.replicators))
+	for _, r := range reg.replicators {
+		states = append(states, ReplicatorState{
+			Name:        r.name,
+			StorageType: r.storageType,
+			Status:      r.blobReplicator.GetStatus(),
+		})
+	}
+	return states
+}
+
+func (reg *Registry) setReplicatorPaused(name string, paused bool) error {
+	reg.replicatorsLock.Lock()
+	defer reg.replicatorsLock.Unlock()
+	for _, r := range reg.replicators {
+		if r.name == name {
+			r.blobReplicator.SetPaused(paused)
+			return nil
+		}
+	}
+	return status.Errorf(codes.NotFound, "No replicator with name %#v exists", name)
+}
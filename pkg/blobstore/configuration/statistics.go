@@ -0,0 +1,88 @@
+package configuration
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+)
+
+type registeredStatisticsReporter struct {
+	getPath            func() string
+	statisticsReporter blobstore.StatisticsReporter
+}
+
+func (reg *Registry) serveStatistics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	e.Encode(reg.getStatistics(r.Context()))
+}
+
+func (reg *Registry) registerStatisticsReporter(storageType string, getPath func() string, statisticsReporter blobstore.StatisticsReporter) {
+	reg.statisticsReportersLock.Lock()
+	defer reg.statisticsReportersLock.Unlock()
+	reg.statisticsReporters[storageType] = append(reg.statisticsReporters[storageType], registeredStatisticsReporter{
+		getPath:            getPath,
+		statisticsReporter: statisticsReporter,
+	})
+}
+
+// backendStatistics is the JSON representation of the statistics of a
+// single backend that is displayed by the diagnostics HTTP server.
+type backendStatistics struct {
+	Backend    string                       `json:"backend"`
+	Statistics *blobstore.StorageStatistics `json:"statistics,omitempty"`
+	Error      string                       `json:"error,omitempty"`
+}
+
+func (reg *Registry) getStatistics(ctx context.Context) map[string][]backendStatistics {
+	reg.statisticsReportersLock.Lock()
+	registered := make(map[string][]registeredStatisticsReporter, len(reg.statisticsReporters))
+	for storageType, reporters := range reg.statisticsReporters {
+		registered[storageType] = append([]registeredStatisticsReporter(nil), reporters...)
+	}
+	reg.statisticsReportersLock.Unlock()
+
+	// Backends are queried without holding the lock, as computing
+	// statistics may take some time.
+	allStatistics := map[string][]backendStatistics{}
+	for storageType, reporters := range registered {
+		for _, r := range reporters {
+			s := backendStatistics{Backend: r.getPath()}
+			if statistics, err := r.statisticsReporter.GetStatistics(ctx); err == nil {
+				s.Statistics = &statistics
+			} else {
+				s.Error = err.Error()
+			}
+			allStatistics[storageType] = append(allStatistics[storageType], s)
+		}
+	}
+	return allStatistics
+}
+
+// statisticsReportingBlobAccess is a decorator for BlobAccess that
+// adds support for reporting statistics. It is used by backends that
+// are composed of multiple objects, such as "local", where only the
+// configuration code has access to all objects needed to compute
+// statistics. As the decorator hides any other methods of the
+// BlobAccess, deletion of blobs is forwarded to a separate BlobDeleter.
+type statisticsReportingBlobAccess struct {
+	blobstore.BlobAccess
+	blobstore.BlobDeleter
+	getStatistics func(ctx context.Context) (blobstore.StorageStatistics, error)
+}
+
+func (ba *statisticsReportingBlobAccess) GetStatistics(ctx context.Context) (blobstore.StorageStatistics, error) {
+	return ba.getStatistics(ctx)
+}
+
+// localStatistics contains the backend specific statistics reported
+// for LocalBlobAccess.
+type localStatistics struct {
+	BlockSizeBytes int64 `json:"block_size_bytes"`
+	local.LocationBlobMapStatistics
+	KeyLocationMap local.LocationRecordArrayStatistics `json:"key_location_map"`
+}
@@ -0,0 +1,153 @@
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+)
+
+// TopologyNode describes a single BlobAccess that was constructed by
+// NewNestedBlobAccess(), including the backends nested inside it. It
+// can be used to inspect which storage topology a process is actually
+// running, as opposed to what its configuration was intended to
+// describe.
+type TopologyNode struct {
+	StorageType     string            `json:"storage_type"`
+	BackendType     string            `json:"backend_type"`
+	Name            string            `json:"name"`
+	DigestKeyFormat string            `json:"digest_key_format"`
+	Attributes      map[string]string `json:"attributes,omitempty"`
+	Children        []*TopologyNode   `json:"children,omitempty"`
+
+	parent   *TopologyNode
+	registry *Registry
+}
+
+func (reg *Registry) serveTopology(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	e.Encode(reg.GetTopology())
+}
+
+// GetTopology returns the topologies of all top-level BlobAccess
+// objects that have been constructed using this Registry.
+func (reg *Registry) GetTopology() []*TopologyNode {
+	reg.topologyLock.Lock()
+	defer reg.topologyLock.Unlock()
+	return append([]*TopologyNode(nil), reg.topologyRoots...)
+}
+
+func (reg *Registry) pushTopologyNode(storageType string) *TopologyNode {
+	reg.topologyLock.Lock()
+	defer reg.topologyLock.Unlock()
+	node := &TopologyNode{
+		StorageType: storageType,
+		Attributes:  map[string]string{},
+		registry:    reg,
+	}
+	if len(reg.topologyStack) > 0 {
+		node.parent = reg.topologyStack[len(reg.topologyStack)-1]
+	}
+	reg.topologyStack = append(reg.topologyStack, node)
+	return node
+}
+
+func (reg *Registry) popTopologyNode(backendType, name string, digestKeyFormat digest.KeyFormat, succeeded bool) {
+	reg.topologyLock.Lock()
+	defer reg.topologyLock.Unlock()
+	node := reg.topologyStack[len(reg.topologyStack)-1]
+	reg.topologyStack = reg.topologyStack[:len(reg.topologyStack)-1]
+	if !succeeded {
+		return
+	}
+
+	node.BackendType = backendType
+	node.Name = name
+	if digestKeyFormat == digest.KeyWithInstance {
+		node.DigestKeyFormat = "with_instance"
+	} else {
+		node.DigestKeyFormat = "without_instance"
+	}
+	if len(reg.topologyStack) > 0 {
+		parent := reg.topologyStack[len(reg.topologyStack)-1]
+		parent.Children = append(parent.Children, node)
+	} else {
+		reg.topologyRoots = append(reg.topologyRoots, node)
+	}
+}
+
+// getPath returns the path of a node in the topology, consisting of the
+// names of the node and its ancestors (e.g.,
+// "cas_sharding/cas_grpc[1]"). If a parent has multiple children, the
+// index of the child is included to disambiguate them.
+func (n *TopologyNode) getPath() string {
+	n.registry.topologyLock.Lock()
+	defer n.registry.topologyLock.Unlock()
+	var components []string
+	for ; n != nil; n = n.parent {
+		component := n.Name
+		if component == "" {
+			component = n.StorageType
+		}
+		if p := n.parent; p != nil && len(p.Children) > 1 {
+			for i, child := range p.Children {
+				if child == n {
+					component = fmt.Sprintf("%s[%d]", component, i)
+					break
+				}
+			}
+		}
+		components = append(components, component)
+	}
+	for i, j := 0, len(components)-1; i < j; i, j = i+1, j-1 {
+		components[i], components[j] = components[j], components[i]
+	}
+	return strings.Join(components, "/")
+}
+
+// holdsData returns whether the BlobAccess corresponding to a node in
+// the topology holds data itself, as opposed to only forwarding
+// requests to backends of the same storage type. This is the case for
+// leaf backends (e.g., "local", "redis"), but also for backends that
+// store data in backends of a different storage type (e.g.,
+// "reference_expanding", "chunking").
+func (n *TopologyNode) holdsData() bool {
+	n.registry.topologyLock.Lock()
+	defer n.registry.topologyLock.Unlock()
+	if len(n.Children) == 0 {
+		return true
+	}
+	for _, child := range n.Children {
+		if child.StorageType != n.StorageType {
+			return true
+		}
+	}
+	return false
+}
+
+// annotateTopology attaches a key-value pair to the BlobAccess that is
+// currently being constructed, so that it is displayed as part of the
+// topology. This can be used to expose properties that are not
+// reflected by the structure of the topology, such as shard weights.
+func (reg *Registry) annotateTopology(key, value string) {
+	reg.topologyLock.Lock()
+	defer reg.topologyLock.Unlock()
+	if len(reg.topologyStack) > 0 {
+		reg.topologyStack[len(reg.topologyStack)-1].Attributes[key] = value
+	}
+}
+
+// getCurrentStorageType returns the storage type of the BlobAccess that
+// is currently being constructed, if any.
+func (reg *Registry) getCurrentStorageType() string {
+	reg.topologyLock.Lock()
+	defer reg.topologyLock.Unlock()
+	if len(reg.topologyStack) > 0 {
+		return reg.topologyStack[len(reg.topologyStack)-1].StorageType
+	}
+	return ""
+}
@@ -0,0 +1,26 @@
+package configuration
+
+import (
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+)
+
+type unvalidatedBlobAccessCreator struct {
+	BlobAccessCreator
+
+	readBufferFactory blobstore.ReadBufferFactory
+}
+
+// newUnvalidatedBlobAccessCreator creates a decorator for
+// BlobAccessCreator that causes backends to return objects without
+// validating them. This is used by decorators that store transformed
+// objects in their backends, such as EncryptingBlobAccess.
+func newUnvalidatedBlobAccessCreator(base BlobAccessCreator, maximumSizeBytes int64) BlobAccessCreator {
+	return &unvalidatedBlobAccessCreator{
+		BlobAccessCreator: base,
+		readBufferFactory: blobstore.NewUnvalidatedReadBufferFactory(maximumSizeBytes),
+	}
+}
+
+func (bac *unvalidatedBlobAccessCreator) GetReadBufferFactory() blobstore.ReadBufferFactory {
+	return bac.readBufferFactory
+}
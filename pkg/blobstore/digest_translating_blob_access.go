@@ -0,0 +1,121 @@
+package blobstore
+
+import (
+	"context"
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+)
+
+type digestTranslatingBlobAccess struct {
+	BlobAccess
+	translationIndex *digest.TranslationIndex
+	digestFunctions  []remoteexecution.DigestFunction_Value
+}
+
+// NewDigestTranslatingBlobAccess creates a decorator for BlobAccess
+// that allows clients that use different digest functions to share a
+// single Content Addressable Storage (CAS). This may be useful when
+// migrating clients from one digest function to another, as it
+// prevents the need for storing objects twice.
+//
+// When objects are written, digests of the data are computed using
+// all of the provided digest functions. These are stored in a
+// TranslationIndex. Subsequent reads and existence checks for any of
+// these digests are forwarded to the backend using the digest under
+// which the object was originally written. Data returned by reads is
+// validated against the digest that was requested.
+//
+// The list of digest functions should contain all digest functions
+// used by clients. This ensures that stale entries in the
+// TranslationIndex are overwritten when objects are written again.
+func NewDigestTranslatingBlobAccess(base BlobAccess, translationIndex *digest.TranslationIndex, digestFunctions []remoteexecution.DigestFunction_Value) BlobAccess {
+	return &digestTranslatingBlobAccess{
+		BlobAccess:       base,
+		translationIndex: translationIndex,
+		digestFunctions:  digestFunctions,
+	}
+}
+
+func (ba *digestTranslatingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	translatedDigest, ok := ba.translationIndex.Lookup(blobDigest)
+	if !ok || translatedDigest == blobDigest {
+		return ba.BlobAccess.Get(ctx, blobDigest)
+	}
+	return buffer.NewCASBufferFromReader(
+		blobDigest,
+		ba.BlobAccess.Get(ctx, translatedDigest).ToReader(),
+		buffer.BackendProvided(buffer.Irreparable(blobDigest)))
+}
+
+func (ba *digestTranslatingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	instanceName := blobDigest.GetInstanceName()
+	generators := make([]*digest.Generator, 0, len(ba.digestFunctions))
+	writers := make([]io.Writer, 0, len(ba.digestFunctions))
+	for _, digestFunction := range ba.digestFunctions {
+		f, err := instanceName.GetDigestFunction(digestFunction)
+		if err != nil {
+			b.Discard()
+			return util.StatusWrapf(err, "Failed to obtain digest function %s", digestFunction)
+		}
+		generator := f.NewGenerator()
+		generators = append(generators, generator)
+		writers = append(writers, generator)
+	}
+
+	// Compute digests of the data using all of the digest
+	// functions, while writing it into the backend.
+	b1, b2 := b.CloneStream()
+	errChan := make(chan error, 1)
+	go func() {
+		r := b2.ToReader()
+		_, err := io.Copy(io.MultiWriter(writers...), r)
+		r.Close()
+		errChan <- err
+	}()
+	putErr := ba.BlobAccess.Put(ctx, blobDigest, b1)
+	hashErr := <-errChan
+	if putErr != nil {
+		return putErr
+	}
+	if hashErr != nil {
+		return hashErr
+	}
+
+	for _, generator := range generators {
+		ba.translationIndex.Add(generator.Sum(), blobDigest)
+	}
+	return nil
+}
+
+func (ba *digestTranslatingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Translate all digests for which translations are known.
+	// Multiple digests may translate to the same digest.
+	translatedDigests := digest.NewSetBuilder()
+	originalDigests := map[digest.Digest][]digest.Digest{}
+	for _, blobDigest := range digests.Items() {
+		translatedDigest, ok := ba.translationIndex.Lookup(blobDigest)
+		if !ok {
+			translatedDigest = blobDigest
+		}
+		translatedDigests.Add(translatedDigest)
+		originalDigests[translatedDigest] = append(originalDigests[translatedDigest], blobDigest)
+	}
+
+	translatedMissing, err := ba.BlobAccess.FindMissing(ctx, translatedDigests.Build())
+	if err != nil {
+		return digest.EmptySet, err
+	}
+
+	missing := digest.NewSetBuilder()
+	for _, translatedDigest := range translatedMissing.Items() {
+		for _, blobDigest := range originalDigests[translatedDigest] {
+			missing.Add(blobDigest)
+		}
+	}
+	return missing.Build(), nil
+}
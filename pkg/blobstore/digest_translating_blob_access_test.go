@@ -0,0 +1,112 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDigestTranslatingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewDigestTranslatingBlobAccess(
+		baseBlobAccess,
+		digest.NewTranslationIndex(digest.KeyWithoutInstance, 10, eviction.NewLRUSet()),
+		[]remoteexecution.DigestFunction_Value{
+			remoteexecution.DigestFunction_SHA1,
+			remoteexecution.DigestFunction_SHA256,
+		})
+
+	sha1Digest := digest.MustNewDigest("hello", "f7ff9e8b7bb2e09b70935a5d785e0cc5d9d0abf0", 5)
+	sha256Digest := digest.MustNewDigest("hello", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+
+	t.Run("GetUntranslated", func(t *testing.T) {
+		// Prior to writing, no translations are known. Requests
+		// should be forwarded as is.
+		baseBlobAccess.EXPECT().Get(ctx, sha1Digest).Return(
+			buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		_, err := blobAccess.Get(ctx, sha1Digest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("PutFailure", func(t *testing.T) {
+		// Translations should not be recorded when writes fail.
+		baseBlobAccess.EXPECT().Put(ctx, sha256Digest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Internal, "Server on fire")
+			})
+
+		err := blobAccess.Put(ctx, sha256Digest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		require.Equal(t, status.Error(codes.Internal, "Server on fire"), err)
+
+		baseBlobAccess.EXPECT().FindMissing(ctx, sha1Digest.ToSingletonSet()).Return(sha1Digest.ToSingletonSet(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, sha1Digest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, sha1Digest.ToSingletonSet(), missing)
+	})
+
+	t.Run("PutSuccess", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Put(ctx, sha256Digest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, sha256Digest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("GetTranslated", func(t *testing.T) {
+		// Requests for the SHA-1 digest should now be forwarded
+		// using the SHA-256 digest. The data should be validated
+		// against the SHA-1 digest.
+		baseBlobAccess.EXPECT().Get(ctx, sha256Digest).Return(
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, sha1Digest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("GetIdentity", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, sha256Digest).Return(
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, sha256Digest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		// Both digests translate to the same digest. Only a
+		// single digest should be queried, while both should
+		// be reported as missing.
+		otherDigest := digest.MustNewDigest("hello", "0a4d55a8d778e5022fab701977c5d840bbc486d0", 5)
+		baseBlobAccess.EXPECT().FindMissing(
+			ctx,
+			digest.NewSetBuilder().Add(sha256Digest).Add(otherDigest).Build(),
+		).Return(sha256Digest.ToSingletonSet(), nil)
+
+		missing, err := blobAccess.FindMissing(
+			ctx,
+			digest.NewSetBuilder().Add(sha1Digest).Add(sha256Digest).Add(otherDigest).Build())
+		require.NoError(t, err)
+		require.Equal(t, digest.NewSetBuilder().Add(sha1Digest).Add(sha256Digest).Build(), missing)
+	})
+}
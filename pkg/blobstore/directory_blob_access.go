@@ -0,0 +1,274 @@
+package blobstore
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/filesystem/path"
+	"github.com/buildbarn/bb-storage/pkg/logging"
+	"github.com/buildbarn/bb-storage/pkg/random"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+)
+
+type directoryBlobAccess struct {
+	directory         filesystem.Directory
+	readBufferFactory ReadBufferFactory
+	digestKeyFormat   digest.KeyFormat
+	sync              bool
+}
+
+// NewDirectoryBlobAccess creates a BlobAccess that stores blobs as
+// regular files in a directory on a file system. To keep directory
+// sizes manageable, files are placed in two levels of subdirectories,
+// named after the first four characters of the hash of the blob (e.g.,
+// "ab/cd/abcdef...").
+//
+// Blobs are first written to a temporary file, which is renamed to its
+// final location afterwards. This ensures that partially written
+// blobs are never observed. If sync is set, files and directories are
+// synchronized to disk before writes are acknowledged.
+//
+// This backend does not perform any eviction of blobs. It is mainly
+// intended for small installations, where setting up LocalBlobAccess
+// is not worth the effort.
+func NewDirectoryBlobAccess(directory filesystem.Directory, readBufferFactory ReadBufferFactory, digestKeyFormat digest.KeyFormat, sync bool) EnumerableBlobAccess {
+	return &directoryBlobAccess{
+		directory:         directory,
+		readBufferFactory: readBufferFactory,
+		digestKeyFormat:   digestKeyFormat,
+		sync:              sync,
+	}
+}
+
+// getLocation returns the names of the subdirectories in which a blob
+// is stored, and the name of the file itself. Keys may contain slashes
+// in case the instance name is part of the key, which is why they are
+// escaped.
+func (ba *directoryBlobAccess) getLocation(blobDigest digest.Digest) ([]path.Component, path.Component) {
+	hash := blobDigest.GetHashString()
+	return []path.Component{
+			path.MustNewComponent(hash[0:2]),
+			path.MustNewComponent(hash[2:4]),
+		},
+		path.MustNewComponent(url.PathEscape(blobDigest.GetKey(ba.digestKeyFormat)))
+}
+
+// enterDirectories opens the subdirectory in which a blob is stored. If
+// create is set, any missing directories are created.
+func (ba *directoryBlobAccess) enterDirectories(components []path.Component, create bool) (filesystem.DirectoryCloser, error) {
+	d := filesystem.NopDirectoryCloser(ba.directory)
+	for _, component := range components {
+		child, err := d.EnterDirectory(component)
+		if create && os.IsNotExist(err) {
+			if err := d.Mkdir(component, 0o777); err != nil && !os.IsExist(err) {
+				d.Close()
+				return nil, err
+			}
+			child, err = d.EnterDirectory(component)
+		}
+		d.Close()
+		if err != nil {
+			return nil, err
+		}
+		d = child
+	}
+	return d, nil
+}
+
+func (ba *directoryBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	directories, name := ba.getLocation(blobDigest)
+	d, err := ba.enterDirectories(directories, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.NotFound, "Blob not found"))
+		}
+		return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.Internal, "Failed to open directory"))
+	}
+	defer d.Close()
+
+	f, err := d.OpenRead(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.NotFound, "Blob not found"))
+		}
+		return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.Internal, "Failed to open file"))
+	}
+	return ba.readBufferFactory.NewBufferFromReader(
+		blobDigest,
+		newReaderFromReaderAt(f),
+		func(dataIsValid bool) {
+			if !dataIsValid {
+				if err := ba.remove(directories, name); err == nil {
+					logging.Printf(ctx, "Blob %#v was malformed and has been deleted from the directory successfully", blobDigest.String())
+				} else {
+					logging.Printf(ctx, "Blob %#v was malformed and could not be deleted from the directory: %s", blobDigest.String(), err)
+				}
+			}
+		})
+}
+
+func (ba *directoryBlobAccess) remove(directories []path.Component, name path.Component) error {
+	d, err := ba.enterDirectories(directories, false)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Remove(name)
+}
+
+func (ba *directoryBlobAccess) Delete(ctx context.Context, blobDigest digest.Digest) error {
+	directories, name := ba.getLocation(blobDigest)
+	if err := ba.remove(directories, name); err != nil && !os.IsNotExist(err) {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to remove file")
+	}
+	return nil
+}
+
+func (ba *directoryBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	directories, name := ba.getLocation(blobDigest)
+	d, err := ba.enterDirectories(directories, true)
+	if err != nil {
+		b.Discard()
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to create directory")
+	}
+	defer d.Close()
+
+	// Write the blob to a temporary file. Use a random name, so
+	// that concurrent writes of the same blob don't collide.
+	temporaryName := path.MustNewComponent(fmt.Sprintf("%s.tmp.%016x", name.String(), random.FastThreadSafeGenerator.Uint64()))
+	f, err := d.OpenAppend(temporaryName, filesystem.CreateExcl(0o666))
+	if err != nil {
+		b.Discard()
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to create temporary file")
+	}
+	if err := b.IntoWriter(f); err != nil {
+		f.Close()
+		d.Remove(temporaryName)
+		return err
+	}
+	if ba.sync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			d.Remove(temporaryName)
+			return util.StatusWrapWithCode(err, codes.Internal, "Failed to synchronize temporary file")
+		}
+	}
+	if err := f.Close(); err != nil {
+		d.Remove(temporaryName)
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to close temporary file")
+	}
+
+	// Move the temporary file to its final location.
+	if err := d.Rename(temporaryName, d, name); err != nil {
+		d.Remove(temporaryName)
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to rename temporary file")
+	}
+	if ba.sync {
+		if err := d.Sync(); err != nil {
+			return util.StatusWrapWithCode(err, codes.Internal, "Failed to synchronize directory")
+		}
+	}
+	return nil
+}
+
+func (ba *directoryBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	missing := digest.NewSetBuilder()
+	for _, blobDigest := range digests.Items() {
+		directories, name := ba.getLocation(blobDigest)
+		d, err := ba.enterDirectories(directories, false)
+		if err != nil {
+			if os.IsNotExist(err) {
+				missing.Add(blobDigest)
+				continue
+			}
+			return digest.EmptySet, util.StatusWrapfWithCode(err, codes.Internal, "Failed to open directory for blob %#v", blobDigest.String())
+		}
+		_, err = d.Lstat(name)
+		d.Close()
+		if err != nil {
+			if os.IsNotExist(err) {
+				missing.Add(blobDigest)
+				continue
+			}
+			return digest.EmptySet, util.StatusWrapfWithCode(err, codes.Internal, "Failed to check existence of blob %#v", blobDigest.String())
+		}
+	}
+	return missing.Build(), nil
+}
+
+// isTemporaryFile returns whether a file in a subdirectory corresponds
+// to a blob that is still in the process of being written by Put().
+func isTemporaryFile(name path.Component) bool {
+	return strings.Contains(name.String(), ".tmp.")
+}
+
+func (ba *directoryBlobAccess) EnumerateKeys(ctx context.Context, f func(key string) error) error {
+	outerEntries, err := ba.directory.ReadDir()
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to read directory")
+	}
+	for _, outerEntry := range outerEntries {
+		if outerEntry.Type() != filesystem.FileTypeDirectory {
+			continue
+		}
+		outerName := outerEntry.Name()
+		outerDirectory, err := ba.directory.EnterDirectory(outerName)
+		if err != nil {
+			return util.StatusWrapfWithCode(err, codes.Internal, "Failed to open directory %#v", outerName.String())
+		}
+		err = ba.enumerateKeysInOuterDirectory(ctx, outerDirectory, outerName, f)
+		outerDirectory.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ba *directoryBlobAccess) enumerateKeysInOuterDirectory(ctx context.Context, outerDirectory filesystem.Directory, outerName path.Component, f func(key string) error) error {
+	innerEntries, err := outerDirectory.ReadDir()
+	if err != nil {
+		return util.StatusWrapfWithCode(err, codes.Internal, "Failed to read directory %#v", outerName.String())
+	}
+	for _, innerEntry := range innerEntries {
+		if innerEntry.Type() != filesystem.FileTypeDirectory {
+			continue
+		}
+		if ctx.Err() != nil {
+			return util.StatusFromContext(ctx)
+		}
+		innerName := innerEntry.Name()
+		innerDirectory, err := outerDirectory.EnterDirectory(innerName)
+		if err != nil {
+			return util.StatusWrapfWithCode(err, codes.Internal, "Failed to open directory %#v", outerName.String()+"/"+innerName.String())
+		}
+		files, err := innerDirectory.ReadDir()
+		innerDirectory.Close()
+		if err != nil {
+			return util.StatusWrapfWithCode(err, codes.Internal, "Failed to read directory %#v", outerName.String()+"/"+innerName.String())
+		}
+		for _, file := range files {
+			name := file.Name()
+			if file.Type() == filesystem.FileTypeDirectory || isTemporaryFile(name) {
+				continue
+			}
+			key, err := url.PathUnescape(name.String())
+			if err != nil {
+				// Not a file created by this backend.
+				continue
+			}
+			if err := f(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package blobstore_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDirectoryBlobAccess(t *testing.T) {
+	ctx := context.Background()
+
+	directoryPath := t.TempDir()
+	directory, err := filesystem.NewLocalDirectory(directoryPath)
+	require.NoError(t, err)
+	defer directory.Close()
+	blobAccess := blobstore.NewDirectoryBlobAccess(directory, blobstore.CASReadBufferFactory, digest.KeyWithInstance, true)
+
+	blobDigest := digest.MustNewDigest("foo/bar", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("FindMissingBeforePut", func(t *testing.T) {
+		missing, err := blobAccess.FindMissing(ctx, blobDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, blobDigest.ToSingletonSet(), missing)
+	})
+
+	t.Run("PutCorrupted", func(t *testing.T) {
+		// Writes of corrupted data should not leave any files
+		// behind.
+		err := blobAccess.Put(ctx, blobDigest, buffer.NewCASBufferFromByteSlice(blobDigest, []byte("Hello World"), buffer.UserProvided))
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		files, err := ioutil.ReadDir(filepath.Join(directoryPath, "3e", "25"))
+		require.NoError(t, err)
+		require.Empty(t, files)
+	})
+
+	t.Run("PutSuccess", func(t *testing.T) {
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+
+		// The blob should be stored in a sharded directory,
+		// using a file name that contains the escaped key.
+		data, err := ioutil.ReadFile(filepath.Join(directoryPath, "3e", "25", "3e25960a79dbc69b674cd4ec67a72c62-11-foo%2Fbar"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("FindMissingAfterPut", func(t *testing.T) {
+		missing, err := blobAccess.FindMissing(ctx, blobDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("EnumerateKeys", func(t *testing.T) {
+		// Temporary files should not be reported.
+		require.NoError(t, ioutil.WriteFile(filepath.Join(directoryPath, "3e", "25", "8b1a9953c4611296a827abf8c47804d7-5-foo.tmp.0123456789abcdef"), []byte("Hello"), 0o666))
+
+		var keys []string
+		require.NoError(t, blobAccess.EnumerateKeys(ctx, func(key string) error {
+			keys = append(keys, key)
+			return nil
+		}))
+		require.Equal(t, []string{"3e25960a79dbc69b674cd4ec67a72c62-11-foo/bar"}, keys)
+	})
+
+	t.Run("GetCorrupted", func(t *testing.T) {
+		// Corrupted files should be removed.
+		require.NoError(t, ioutil.WriteFile(filepath.Join(directoryPath, "3e", "25", "3e25960a79dbc69b674cd4ec67a72c62-11-foo%2Fbar"), []byte("Hello World"), 0o666))
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, codes.Internal, status.Code(err))
+
+		missing, err := blobAccess.FindMissing(ctx, blobDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, blobDigest.ToSingletonSet(), missing)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+		require.NoError(t, blobAccess.(blobstore.BlobDeleter).Delete(ctx, blobDigest))
+
+		missing, err := blobAccess.FindMissing(ctx, blobDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, blobDigest.ToSingletonSet(), missing)
+
+		// Deleting blobs that are already absent should succeed.
+		require.NoError(t, blobAccess.(blobstore.BlobDeleter).Delete(ctx, blobDigest))
+	})
+}
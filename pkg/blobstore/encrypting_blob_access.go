@@ -0,0 +1,151 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	encryptionKeyIDSizeBytes = 4
+	encryptionNonceSizeBytes = 12
+	encryptionTagSizeBytes   = 16
+
+	// EncryptionOverheadSizeBytes is the number of bytes by which
+	// objects grow when encrypted by EncryptingBlobAccess.
+	EncryptionOverheadSizeBytes = encryptionKeyIDSizeBytes + encryptionNonceSizeBytes + encryptionTagSizeBytes
+)
+
+type encryptionKey struct {
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+type encryptingBlobAccess struct {
+	base                BlobAccess
+	readBufferFactory   ReadBufferFactory
+	digestKeyFormat     digest.KeyFormat
+	keys                map[uint32]encryptionKey
+	currentKeyID        uint32
+	deterministicNonces bool
+	maximumSizeBytes    int
+}
+
+// deriveKey derives a subkey from a key provided by the user, so that
+// separate keys are used for encryption and nonce generation.
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// NewEncryptingBlobAccess creates a decorator for BlobAccess that
+// encrypts objects using AES-256-GCM before they are written to the
+// backend, and decrypts them when read. Every stored object is
+// prefixed with the ID of the key used to encrypt it. This permits
+// rotating keys, as objects written using older keys remain readable
+// for as long as these keys are provided.
+//
+// The key of the object in the backend is used as additional
+// authenticated data, preventing objects from being swapped. If
+// deterministicNonces is set, nonces are derived from the same key.
+// This causes identical objects to yield identical ciphertexts, which
+// is only safe for the Content Addressable Storage, where the contents
+// of an object are fully determined by its digest. Random nonces must
+// be used in all other cases.
+//
+// As AES-GCM does not support streaming decryption, objects are loaded
+// into memory entirely. Objects larger than maximumSizeBytes are
+// rejected. The backend should be created with a ReadBufferFactory
+// obtained through NewUnvalidatedReadBufferFactory(), as ciphertexts
+// cannot be validated against the digest of the object.
+func NewEncryptingBlobAccess(base BlobAccess, readBufferFactory ReadBufferFactory, digestKeyFormat digest.KeyFormat, keys map[uint32][]byte, currentKeyID uint32, deterministicNonces bool, maximumSizeBytes int) (BlobAccess, error) {
+	derivedKeys := make(map[uint32]encryptionKey, len(keys))
+	for keyID, key := range keys {
+		if len(key) != 32 {
+			return nil, status.Errorf(codes.InvalidArgument, "Key with ID %d is %d bytes in size, while AES-256 requires keys that are 32 bytes in size", keyID, len(key))
+		}
+		block, err := aes.NewCipher(deriveKey(key, "encryption"))
+		if err != nil {
+			return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Failed to create cipher for key with ID %d", keyID)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Failed to create AEAD for key with ID %d", keyID)
+		}
+		derivedKeys[keyID] = encryptionKey{
+			aead:     aead,
+			nonceKey: deriveKey(key, "nonce"),
+		}
+	}
+	if _, ok := derivedKeys[currentKeyID]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "No key with ID %d is provided", currentKeyID)
+	}
+	return &encryptingBlobAccess{
+		base:                base,
+		readBufferFactory:   readBufferFactory,
+		digestKeyFormat:     digestKeyFormat,
+		keys:                derivedKeys,
+		currentKeyID:        currentKeyID,
+		deterministicNonces: deterministicNonces,
+		maximumSizeBytes:    maximumSizeBytes,
+	}, nil
+}
+
+func (ba *encryptingBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	ciphertext, err := ba.base.Get(ctx, blobDigest).ToByteSlice(ba.maximumSizeBytes + EncryptionOverheadSizeBytes)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	if len(ciphertext) < EncryptionOverheadSizeBytes {
+		return buffer.NewBufferFromError(status.Errorf(codes.Internal, "Encrypted object is %d bytes in size, while it must be at least %d bytes in size", len(ciphertext), EncryptionOverheadSizeBytes))
+	}
+	keyID := binary.BigEndian.Uint32(ciphertext)
+	key, ok := ba.keys[keyID]
+	if !ok {
+		return buffer.NewBufferFromError(status.Errorf(codes.Internal, "Object is encrypted using unknown key with ID %d", keyID))
+	}
+	nonce := ciphertext[encryptionKeyIDSizeBytes : encryptionKeyIDSizeBytes+encryptionNonceSizeBytes]
+	plaintext, err := key.aead.Open(nil, nonce, ciphertext[encryptionKeyIDSizeBytes+encryptionNonceSizeBytes:], []byte(blobDigest.GetKey(ba.digestKeyFormat)))
+	if err != nil {
+		return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.Internal, "Failed to decrypt object"))
+	}
+	return ba.readBufferFactory.NewBufferFromByteSlice(blobDigest, plaintext, func(dataIsValid bool) {})
+}
+
+func (ba *encryptingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	plaintext, err := b.ToByteSlice(ba.maximumSizeBytes)
+	if err != nil {
+		return err
+	}
+
+	key := ba.keys[ba.currentKeyID]
+	additionalData := []byte(blobDigest.GetKey(ba.digestKeyFormat))
+	ciphertext := make([]byte, encryptionKeyIDSizeBytes+encryptionNonceSizeBytes, EncryptionOverheadSizeBytes+len(plaintext))
+	binary.BigEndian.PutUint32(ciphertext, ba.currentKeyID)
+	nonce := ciphertext[encryptionKeyIDSizeBytes:]
+	if ba.deterministicNonces {
+		mac := hmac.New(sha256.New, key.nonceKey)
+		mac.Write(additionalData)
+		copy(nonce, mac.Sum(nil))
+	} else if _, err := rand.Read(nonce); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to generate nonce")
+	}
+	ciphertext = key.aead.Seal(ciphertext, nonce, plaintext, additionalData)
+	return ba.base.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(ciphertext))
+}
+
+func (ba *encryptingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	return ba.base.FindMissing(ctx, digests)
+}
@@ -0,0 +1,150 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEncryptingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 32)
+	blobDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("InvalidKeySize", func(t *testing.T) {
+		_, err := blobstore.NewEncryptingBlobAccess(nil, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, map[uint32][]byte{
+			1: []byte("Hello"),
+		}, 1, true, 100)
+		require.Equal(t, status.Error(codes.InvalidArgument, "Key with ID 1 is 5 bytes in size, while AES-256 requires keys that are 32 bytes in size"), err)
+	})
+
+	t.Run("MissingCurrentKey", func(t *testing.T) {
+		_, err := blobstore.NewEncryptingBlobAccess(nil, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, map[uint32][]byte{
+			1: key1,
+		}, 2, true, 100)
+		require.Equal(t, status.Error(codes.InvalidArgument, "No key with ID 2 is provided"), err)
+	})
+
+	// Store an object using the first key.
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess1, err := blobstore.NewEncryptingBlobAccess(baseBlobAccess, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, map[uint32][]byte{
+		1: key1,
+	}, 1, true, 100)
+	require.NoError(t, err)
+
+	var ciphertext []byte
+	baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+			var err error
+			ciphertext, err = b.ToByteSlice(100)
+			require.NoError(t, err)
+			return nil
+		})
+	require.NoError(t, blobAccess1.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	require.Len(t, ciphertext, 11+blobstore.EncryptionOverheadSizeBytes)
+	require.Equal(t, []byte{0, 0, 0, 1}, ciphertext[:4])
+	require.NotContains(t, string(ciphertext), "Hello world")
+
+	t.Run("DeterministicNonces", func(t *testing.T) {
+		// For the CAS, writing the same object twice should
+		// yield the same ciphertext.
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, ciphertext, data)
+				return nil
+			})
+		require.NoError(t, blobAccess1.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+
+	t.Run("RandomNonces", func(t *testing.T) {
+		blobAccess, err := blobstore.NewEncryptingBlobAccess(baseBlobAccess, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, map[uint32][]byte{
+			1: key1,
+		}, 1, false, 100)
+		require.NoError(t, err)
+
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.NotEqual(t, ciphertext, data)
+				return nil
+			})
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice(ciphertext))
+
+		data, err := blobAccess1.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("GetAfterRotation", func(t *testing.T) {
+		// After switching to a new key, objects written using
+		// the old key should remain readable.
+		blobAccess2, err := blobstore.NewEncryptingBlobAccess(baseBlobAccess, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, map[uint32][]byte{
+			1: key1,
+			2: key2,
+		}, 2, true, 100)
+		require.NoError(t, err)
+
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice(ciphertext))
+
+		data, err := blobAccess2.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("GetUnknownKey", func(t *testing.T) {
+		blobAccess2, err := blobstore.NewEncryptingBlobAccess(baseBlobAccess, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, map[uint32][]byte{
+			2: key2,
+		}, 2, true, 100)
+		require.NoError(t, err)
+
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice(ciphertext))
+
+		_, err = blobAccess2.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Internal, "Object is encrypted using unknown key with ID 1"), err)
+	})
+
+	t.Run("GetTampered", func(t *testing.T) {
+		tampered := append([]byte(nil), ciphertext...)
+		tampered[len(tampered)-1] ^= 1
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice(tampered))
+
+		_, err := blobAccess1.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Internal, "Failed to decrypt object: cipher: message authentication failed"), err)
+	})
+
+	t.Run("GetSwapped", func(t *testing.T) {
+		// Objects can't be moved to a different key, as the
+		// key is used as additional authenticated data.
+		otherDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+		baseBlobAccess.EXPECT().Get(ctx, otherDigest).Return(buffer.NewValidatedBufferFromByteSlice(ciphertext))
+
+		_, err := blobAccess1.Get(ctx, otherDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Internal, "Failed to decrypt object: cipher: message authentication failed"), err)
+	})
+
+	t.Run("GetTooShort", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		_, err := blobAccess1.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Internal, "Encrypted object is 5 bytes in size, while it must be at least 32 bytes in size"), err)
+	})
+}
@@ -2,13 +2,33 @@ package blobstore
 
 import (
 	"context"
+	"sync"
 
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	existenceCachingBlobAccessPrometheusMetrics sync.Once
+
+	existenceCachingBlobAccessFindMissingDigestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "existence_caching_blob_access_find_missing_digests_total",
+			Help:      "Number of digests provided to FindMissing(), and whether their existence could be determined using the cache.",
+		},
+		[]string{"result"})
+	existenceCachingBlobAccessFindMissingDigestsPresentHit = existenceCachingBlobAccessFindMissingDigestsTotal.WithLabelValues("PresentHit")
+	existenceCachingBlobAccessFindMissingDigestsMissingHit = existenceCachingBlobAccessFindMissingDigestsTotal.WithLabelValues("MissingHit")
+	existenceCachingBlobAccessFindMissingDigestsMiss       = existenceCachingBlobAccessFindMissingDigestsTotal.WithLabelValues("Miss")
 )
 
 type existenceCachingBlobAccess struct {
 	BlobAccess
 	existenceCache *digest.ExistenceCache
+	missingCache   *digest.ExistenceCache
 }
 
 // NewExistenceCachingBlobAccess creates a decorator for BlobAccess that
@@ -19,29 +39,67 @@ type existenceCachingBlobAccess struct {
 // digests. They don't seem to have a local cache of which digests they
 // queried recently. This decorator adds such a cache.
 //
+// If missingCache is provided, digests reported as missing by the
+// backend are cached as well. This is useful when many workers query
+// the same set of not yet uploaded objects. As objects may be written
+// through this decorator, entries in this cache are invalidated by
+// Put(). Because objects may also be written through other paths, the
+// duration of this cache should be kept short.
+//
 // This decorator may be useful to run on instances that act as
 // frontends for a mirrored/sharding storage pool, as it may reduce the
 // load observed on the storage pool.
-func NewExistenceCachingBlobAccess(base BlobAccess, existenceCache *digest.ExistenceCache) BlobAccess {
+func NewExistenceCachingBlobAccess(base BlobAccess, existenceCache, missingCache *digest.ExistenceCache) BlobAccess {
+	existenceCachingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(existenceCachingBlobAccessFindMissingDigestsTotal)
+	})
+
 	return &existenceCachingBlobAccess{
 		BlobAccess:     base,
 		existenceCache: existenceCache,
+		missingCache:   missingCache,
+	}
+}
+
+func (ba *existenceCachingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	if err := ba.BlobAccess.Put(ctx, blobDigest, b); err != nil {
+		return err
+	}
+	if ba.missingCache != nil {
+		ba.missingCache.Remove(blobDigest.ToSingletonSet())
 	}
+	return nil
 }
 
 func (ba *existenceCachingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
 	// Determine which digests don't need to be checked, because
 	// they have already been requested recently.
 	maybeMissing := ba.existenceCache.RemoveExisting(digests)
+	existenceCachingBlobAccessFindMissingDigestsPresentHit.Add(float64(digests.Length() - maybeMissing.Length()))
+
+	// Determine which digests were reported as being missing
+	// recently, if caching of missing digests is enabled.
+	toQuery := maybeMissing
+	knownMissing := digest.EmptySet
+	if ba.missingCache != nil {
+		toQuery = ba.missingCache.RemoveExisting(maybeMissing)
+		_, _, knownMissing = digest.GetDifferenceAndIntersection(toQuery, maybeMissing)
+		existenceCachingBlobAccessFindMissingDigestsMissingHit.Add(float64(knownMissing.Length()))
+	}
+	existenceCachingBlobAccessFindMissingDigestsMiss.Add(float64(toQuery.Length()))
 
 	// Check existence of the remaining digests.
-	missing, err := ba.BlobAccess.FindMissing(ctx, maybeMissing)
+	missing, err := ba.BlobAccess.FindMissing(ctx, toQuery)
 	if err != nil {
 		return digest.EmptySet, err
 	}
 
-	// Insert the digests that were present for future calls.
-	present, _, _ := digest.GetDifferenceAndIntersection(maybeMissing, missing)
+	// Insert the results for future calls.
+	present, _, _ := digest.GetDifferenceAndIntersection(toQuery, missing)
 	ba.existenceCache.Add(present)
+	if ba.missingCache != nil {
+		ba.missingCache.Add(missing)
+		missing = digest.GetUnion([]digest.Set{missing, knownMissing})
+	}
 	return missing, nil
 }
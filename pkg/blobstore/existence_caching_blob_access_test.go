@@ -7,6 +7,7 @@ import (
 
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/buildbarn/bb-storage/pkg/eviction"
 	"github.com/golang/mock/gomock"
@@ -20,7 +21,8 @@ func TestExistenceCachingBlobAccessFindMissing(t *testing.T) {
 	clock := mock.NewMockClock(ctrl)
 	blobAccess := blobstore.NewExistenceCachingBlobAccess(
 		baseBlobAccess,
-		digest.NewExistenceCache(clock, digest.KeyWithoutInstance, 10, time.Minute, eviction.NewLRUSet()))
+		digest.NewExistenceCache(clock, digest.KeyWithoutInstance, 10, time.Minute, eviction.NewLRUSet()),
+		nil)
 
 	bothDigests := digest.NewSetBuilder().
 		Add(digest.MustNewDigest("instance", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)).
@@ -53,3 +55,49 @@ func TestExistenceCachingBlobAccessFindMissing(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, nonExistingDigests, missing)
 }
+
+func TestExistenceCachingBlobAccessMissingCache(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewExistenceCachingBlobAccess(
+		baseBlobAccess,
+		digest.NewExistenceCache(clock, digest.KeyWithoutInstance, 10, time.Minute, eviction.NewLRUSet()),
+		digest.NewExistenceCache(clock, digest.KeyWithoutInstance, 10, 5*time.Second, eviction.NewLRUSet()))
+
+	existingDigest := digest.MustNewDigest("instance", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+	nonExistingDigest := digest.MustNewDigest("instance", "78ae647dc5544d227130a0682a51e30bc7777fbb6d8a8f17007463a3ecd1d524", 5)
+	bothDigests := digest.NewSetBuilder().Add(existingDigest).Add(nonExistingDigest).Build()
+
+	// The first request should cause both digests to be queried on
+	// the backend.
+	clock.EXPECT().Now().Return(time.Unix(1000, 0)).Times(4)
+	baseBlobAccess.EXPECT().FindMissing(ctx, bothDigests).Return(nonExistingDigest.ToSingletonSet(), nil)
+	missing, err := blobAccess.FindMissing(ctx, bothDigests)
+	require.NoError(t, err)
+	require.Equal(t, nonExistingDigest.ToSingletonSet(), missing)
+
+	// Within the duration of the missing cache, both results
+	// should be served from the cache.
+	clock.EXPECT().Now().Return(time.Unix(1005, 0)).Times(4)
+	baseBlobAccess.EXPECT().FindMissing(ctx, digest.EmptySet).Return(digest.EmptySet, nil)
+	missing, err = blobAccess.FindMissing(ctx, bothDigests)
+	require.NoError(t, err)
+	require.Equal(t, nonExistingDigest.ToSingletonSet(), missing)
+
+	// Writing the missing object should invalidate its entry,
+	// causing it to be queried once again.
+	baseBlobAccess.EXPECT().Put(ctx, nonExistingDigest, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			b.Discard()
+			return nil
+		})
+	require.NoError(t, blobAccess.Put(ctx, nonExistingDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+	clock.EXPECT().Now().Return(time.Unix(1005, 0)).Times(4)
+	baseBlobAccess.EXPECT().FindMissing(ctx, nonExistingDigest.ToSingletonSet()).Return(digest.EmptySet, nil)
+	missing, err = blobAccess.FindMissing(ctx, bothDigests)
+	require.NoError(t, err)
+	require.Equal(t, digest.EmptySet, missing)
+}
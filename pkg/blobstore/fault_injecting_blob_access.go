@@ -0,0 +1,163 @@
+package blobstore
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/random"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	faultInjectingBlobAccessPrometheusMetrics sync.Once
+
+	faultInjectingBlobAccessFaultsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "fault_injecting_blob_access_faults_total",
+			Help:      "Number of faults injected into operations.",
+		},
+		[]string{"name", "operation", "fault"})
+)
+
+// FaultInjectionPolicy describes which faults FaultInjectingBlobAccess
+// should inject into a single type of operation.
+type FaultInjectionPolicy struct {
+	// Operations are delayed by a random duration between
+	// MinimumLatency and MaximumLatency.
+	MinimumLatency time.Duration
+	MaximumLatency time.Duration
+	// The probability with which operations fail with Error.
+	ErrorProbability float64
+	Error            error
+	// The probability with which data returned by Get() is cut
+	// short. This field is ignored for other operations.
+	TruncationProbability float64
+}
+
+type faultInjectingBlobAccess struct {
+	base                  BlobAccess
+	clock                 clock.Clock
+	randomNumberGenerator random.ThreadSafeGenerator
+	readBufferFactory     ReadBufferFactory
+	getPolicy             FaultInjectionPolicy
+	putPolicy             FaultInjectionPolicy
+	findMissingPolicy     FaultInjectionPolicy
+
+	getErrorsTotal         prometheus.Counter
+	getTruncationsTotal    prometheus.Counter
+	putErrorsTotal         prometheus.Counter
+	findMissingErrorsTotal prometheus.Counter
+}
+
+// NewFaultInjectingBlobAccess creates a decorator for BlobAccess that
+// injects latency, errors and truncated reads into operations. It can
+// be used to validate that setups involving replication, retrying and
+// failover behave as expected when backends misbehave. It should not be
+// used in production.
+func NewFaultInjectingBlobAccess(base BlobAccess, clock clock.Clock, randomNumberGenerator random.ThreadSafeGenerator, readBufferFactory ReadBufferFactory, getPolicy, putPolicy, findMissingPolicy FaultInjectionPolicy, name string) BlobAccess {
+	faultInjectingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(faultInjectingBlobAccessFaultsTotal)
+	})
+
+	return &faultInjectingBlobAccess{
+		base:                  base,
+		clock:                 clock,
+		randomNumberGenerator: randomNumberGenerator,
+		readBufferFactory:     readBufferFactory,
+		getPolicy:             getPolicy,
+		putPolicy:             putPolicy,
+		findMissingPolicy:     findMissingPolicy,
+
+		getErrorsTotal:         faultInjectingBlobAccessFaultsTotal.WithLabelValues(name, "Get", "Error"),
+		getTruncationsTotal:    faultInjectingBlobAccessFaultsTotal.WithLabelValues(name, "Get", "Truncation"),
+		putErrorsTotal:         faultInjectingBlobAccessFaultsTotal.WithLabelValues(name, "Put", "Error"),
+		findMissingErrorsTotal: faultInjectingBlobAccessFaultsTotal.WithLabelValues(name, "FindMissing", "Error"),
+	}
+}
+
+// happens returns true with a given probability.
+func (ba *faultInjectingBlobAccess) happens(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	return float64(ba.randomNumberGenerator.Uint64()>>11)/(1<<53) < probability
+}
+
+// injectFaults delays the calling goroutine according to the policy,
+// and subsequently determines whether the operation should fail.
+func (ba *faultInjectingBlobAccess) injectFaults(ctx context.Context, policy *FaultInjectionPolicy, errorsTotal prometheus.Counter) error {
+	if policy.MaximumLatency > 0 {
+		delay := policy.MinimumLatency
+		if spread := policy.MaximumLatency - policy.MinimumLatency; spread > 0 {
+			delay += time.Duration(ba.randomNumberGenerator.Uint64() % uint64(spread+1))
+		}
+		timer, timerChannel := ba.clock.NewTimer(delay)
+		select {
+		case <-timerChannel:
+		case <-ctx.Done():
+			timer.Stop()
+			return util.StatusFromContext(ctx)
+		}
+	}
+	if ba.happens(policy.ErrorProbability) {
+		errorsTotal.Inc()
+		return policy.Error
+	}
+	return nil
+}
+
+func (ba *faultInjectingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	if err := ba.injectFaults(ctx, &ba.getPolicy, ba.getErrorsTotal); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	b := ba.base.Get(ctx, digest)
+	if !ba.happens(ba.getPolicy.TruncationProbability) {
+		return b
+	}
+
+	// Only return a random prefix of the data. Because the data is
+	// validated, the caller will observe this as data corruption.
+	sizeBytes, err := b.GetSizeBytes()
+	if err != nil {
+		b.Discard()
+		return buffer.NewBufferFromError(err)
+	}
+	if sizeBytes == 0 {
+		return b
+	}
+	ba.getTruncationsTotal.Inc()
+	r := b.ToReader()
+	return ba.readBufferFactory.NewBufferFromReader(
+		digest,
+		&struct {
+			io.Reader
+			io.Closer
+		}{
+			Reader: io.LimitReader(r, int64(ba.randomNumberGenerator.Uint64()%uint64(sizeBytes))),
+			Closer: r,
+		},
+		func(dataIsValid bool) {})
+}
+
+func (ba *faultInjectingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if err := ba.injectFaults(ctx, &ba.putPolicy, ba.putErrorsTotal); err != nil {
+		b.Discard()
+		return err
+	}
+	return ba.base.Put(ctx, digest, b)
+}
+
+func (ba *faultInjectingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	if err := ba.injectFaults(ctx, &ba.findMissingPolicy, ba.findMissingErrorsTotal); err != nil {
+		return digest.EmptySet, err
+	}
+	return ba.base.FindMissing(ctx, digests)
+}
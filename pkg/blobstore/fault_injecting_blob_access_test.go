@@ -0,0 +1,92 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/random"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFaultInjectingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewFaultInjectingBlobAccess(
+		baseBlobAccess,
+		clock,
+		random.FastThreadSafeGenerator,
+		blobstore.CASReadBufferFactory,
+		blobstore.FaultInjectionPolicy{
+			TruncationProbability: 1,
+		},
+		blobstore.FaultInjectionPolicy{
+			ErrorProbability: 1,
+			Error:            status.Error(codes.Unavailable, "Fault injected"),
+		},
+		blobstore.FaultInjectionPolicy{
+			MinimumLatency: 2 * time.Second,
+			MaximumLatency: 2 * time.Second,
+		},
+		"cas")
+
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("GetTruncated", func(t *testing.T) {
+		// Truncated data should fail validation.
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, codes.Internal, status.Code(err))
+	})
+
+	t.Run("GetBackendFailure", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("PutError", func(t *testing.T) {
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.Unavailable, "Fault injected"),
+			blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("FindMissingLatency", func(t *testing.T) {
+		timer := mock.NewMockTimer(ctrl)
+		timerChannel := make(chan time.Time, 1)
+		timerChannel <- time.Unix(1002, 0)
+		clock.EXPECT().NewTimer(2*time.Second).Return(timer, timerChannel)
+		baseBlobAccess.EXPECT().FindMissing(ctx, blobDigest.ToSingletonSet()).Return(digest.EmptySet, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, blobDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+
+	t.Run("FindMissingCanceled", func(t *testing.T) {
+		canceledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		timer := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(2*time.Second).Return(timer, nil)
+		timer.EXPECT().Stop().Return(true)
+
+		_, err := blobAccess.FindMissing(canceledCtx, blobDigest.ToSingletonSet())
+		testutil.RequireEqualStatus(t, status.Error(codes.Canceled, "context canceled"), err)
+	})
+}
@@ -0,0 +1,42 @@
+package garbagecollection
+
+import (
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+)
+
+// NewGarbageCollectorFromConfiguration creates a GarbageCollector
+// based on options specified in a configuration file.
+func NewGarbageCollectorFromConfiguration(configuration *pb.GarbageCollectionConfiguration, enumerateBlobs BlobEnumeratorFunc, expireBlobs BlobExpirerFunc, contentAddressableStorage blobstore.BlobAccess, maximumMessageSizeBytes int) (*GarbageCollector, error) {
+	if err := configuration.GracePeriod.CheckValid(); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to obtain grace period")
+	}
+	pins := make([]Pin, 0, len(configuration.Pins))
+	for i, pin := range configuration.Pins {
+		instanceName, err := digest.NewInstanceName(pin.InstanceName)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Invalid instance name for pin at index %d", i)
+		}
+		pinDigest, err := instanceName.NewDigestFromProto(pin.Digest)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Invalid digest for pin at index %d", i)
+		}
+		pins = append(pins, Pin{
+			Digest: pinDigest,
+			Type:   pin.Type,
+		})
+	}
+	return NewGarbageCollector(
+		enumerateBlobs,
+		expireBlobs,
+		contentAddressableStorage,
+		clock.SystemClock,
+		pins,
+		configuration.GracePeriod.AsDuration(),
+		maximumMessageSizeBytes), nil
+}
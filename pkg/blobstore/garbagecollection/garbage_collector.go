@@ -0,0 +1,287 @@
+package garbagecollection
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	garbageCollectorPrometheusMetrics sync.Once
+
+	garbageCollectorRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "garbage_collector_runs_total",
+			Help:      "Number of garbage collection runs performed.",
+		},
+		[]string{"result"})
+	garbageCollectorReachableBlobs = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "garbage_collector_reachable_blobs",
+			Help:      "Number of objects in the Content Addressable Storage that were reachable during the last garbage collection run.",
+		})
+	garbageCollectorExpiredBlobsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "garbage_collector_expired_blobs_total",
+			Help:      "Number of unreachable objects removed from the Content Addressable Storage.",
+		})
+)
+
+// BlobEnumeratorFunc is called by GarbageCollector to traverse all
+// objects stored in backends of a given storage type. It is
+// implemented by configuration.Registry.EnumerateBlobs().
+type BlobEnumeratorFunc func(ctx context.Context, storageType string, f func(blobAccess blobstore.BlobAccess, blobDigest digest.Digest) error) error
+
+// BlobExpirerFunc is called by GarbageCollector to remove unreachable
+// objects from backends of a given storage type. It is implemented by
+// configuration.Registry.ExpireBlobs().
+type BlobExpirerFunc func(ctx context.Context, storageType string, modifiedBefore time.Time, isRetained func(blobDigest digest.Digest) bool) (int64, error)
+
+// Pin of an object in the Content Addressable Storage that needs to
+// be retained, even if it is not referenced by any ActionResult.
+type Pin struct {
+	Digest digest.Digest
+	Type   pb.GarbageCollectionConfiguration_Pin_Type
+}
+
+// RunStatistics contains counters that are gathered while performing
+// a single garbage collection run.
+type RunStatistics struct {
+	ActionResults  int
+	ReachableBlobs int
+	ExpiredBlobs   int64
+}
+
+// GarbageCollector performs reachability-based garbage collection of
+// the Content Addressable Storage (CAS). During every run, it traverses
+// all ActionResult messages stored in the Action Cache (AC) and marks
+// all output files, standard output/error and output directories
+// referenced by them as reachable. Pinned objects are marked as well.
+// Backends of the CAS are then instructed to remove all objects that
+// are not reachable.
+//
+// To prevent the removal of objects that are uploaded by builds that
+// are in progress, objects are only removed if they were last modified
+// before the start of the run, minus a grace period.
+type GarbageCollector struct {
+	enumerateBlobs            BlobEnumeratorFunc
+	expireBlobs               BlobExpirerFunc
+	contentAddressableStorage blobstore.BlobAccess
+	clock                     clock.Clock
+	pins                      []Pin
+	gracePeriod               time.Duration
+	maximumMessageSizeBytes   int
+}
+
+// NewGarbageCollector creates a GarbageCollector. The Content
+// Addressable Storage is used to load the Tree and Directory messages
+// of output directories and pins.
+func NewGarbageCollector(enumerateBlobs BlobEnumeratorFunc, expireBlobs BlobExpirerFunc, contentAddressableStorage blobstore.BlobAccess, clock clock.Clock, pins []Pin, gracePeriod time.Duration, maximumMessageSizeBytes int) *GarbageCollector {
+	garbageCollectorPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(garbageCollectorRunsTotal)
+		prometheus.MustRegister(garbageCollectorReachableBlobs)
+		prometheus.MustRegister(garbageCollectorExpiredBlobsTotal)
+	})
+
+	return &GarbageCollector{
+		enumerateBlobs:            enumerateBlobs,
+		expireBlobs:               expireBlobs,
+		contentAddressableStorage: contentAddressableStorage,
+		clock:                     clock,
+		pins:                      pins,
+		gracePeriod:               gracePeriod,
+		maximumMessageSizeBytes:   maximumMessageSizeBytes,
+	}
+}
+
+// Run a single garbage collection cycle.
+func (gc *GarbageCollector) Run(ctx context.Context) (RunStatistics, error) {
+	stats, err := gc.run(ctx)
+	garbageCollectorExpiredBlobsTotal.Add(float64(stats.ExpiredBlobs))
+	if err != nil {
+		garbageCollectorRunsTotal.WithLabelValues("Failure").Inc()
+		return stats, err
+	}
+	garbageCollectorRunsTotal.WithLabelValues("Success").Inc()
+	garbageCollectorReachableBlobs.Set(float64(stats.ReachableBlobs))
+	return stats, nil
+}
+
+func (gc *GarbageCollector) run(ctx context.Context) (RunStatistics, error) {
+	// Determine the cutoff before traversing the Action Cache, so
+	// that objects uploaded during traversal are never removed.
+	modifiedBefore := gc.clock.Now().Add(-gc.gracePeriod)
+
+	m := reachabilityMarker{
+		contentAddressableStorage: gc.contentAddressableStorage,
+		maximumMessageSizeBytes:   gc.maximumMessageSizeBytes,
+		reachable:                 map[string]struct{}{},
+	}
+	for _, pin := range gc.pins {
+		if err := m.markPin(ctx, pin); err != nil {
+			return RunStatistics{}, util.StatusWrapf(err, "Failed to mark pin %#v", pin.Digest.String())
+		}
+	}
+
+	var stats RunStatistics
+	if err := gc.enumerateBlobs(ctx, "ac", func(blobAccess blobstore.BlobAccess, actionDigest digest.Digest) error {
+		actionResult, err := blobAccess.Get(ctx, actionDigest).ToProto(&remoteexecution.ActionResult{}, gc.maximumMessageSizeBytes)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				// Entry was removed during traversal.
+				return nil
+			}
+			return util.StatusWrapf(err, "Failed to obtain action result %#v", actionDigest.String())
+		}
+		stats.ActionResults++
+		return m.markActionResult(ctx, actionDigest.GetInstanceName(), actionResult.(*remoteexecution.ActionResult))
+	}); err != nil {
+		return stats, util.StatusWrap(err, "Failed to traverse the Action Cache")
+	}
+	stats.ReachableBlobs = len(m.reachable)
+
+	expiredBlobs, err := gc.expireBlobs(ctx, "cas", modifiedBefore, m.isReachable)
+	stats.ExpiredBlobs = expiredBlobs
+	if err != nil {
+		return stats, util.StatusWrap(err, "Failed to expire unreachable objects in the Content Addressable Storage")
+	}
+	return stats, nil
+}
+
+// reachabilityMarker keeps track of the set of objects in the Content
+// Addressable Storage that are reachable. Objects are identified by
+// their key without the instance name, so that objects shared between
+// instance names are retained if they are reachable through any of
+// them.
+type reachabilityMarker struct {
+	contentAddressableStorage blobstore.BlobAccess
+	maximumMessageSizeBytes   int
+	reachable                 map[string]struct{}
+}
+
+func (m *reachabilityMarker) isReachable(blobDigest digest.Digest) bool {
+	_, ok := m.reachable[blobDigest.GetKey(digest.KeyWithoutInstance)]
+	return ok
+}
+
+// markDigest marks an object as reachable. It returns true if the
+// object was not marked before, meaning that any objects referenced
+// by it still need to be marked.
+func (m *reachabilityMarker) markDigest(blobDigest digest.Digest) bool {
+	key := blobDigest.GetKey(digest.KeyWithoutInstance)
+	if _, ok := m.reachable[key]; ok {
+		return false
+	}
+	m.reachable[key] = struct{}{}
+	return true
+}
+
+// markProto marks an object referenced by a REv2 message. Malformed
+// digests are ignored, as they cannot refer to any object stored in
+// the Content Addressable Storage.
+func (m *reachabilityMarker) markProto(instanceName digest.InstanceName, blobDigest *remoteexecution.Digest) (digest.Digest, bool) {
+	if blobDigest == nil {
+		return digest.BadDigest, false
+	}
+	derivedDigest, err := instanceName.NewDigestFromProto(blobDigest)
+	if err != nil {
+		return digest.BadDigest, false
+	}
+	return derivedDigest, m.markDigest(derivedDigest)
+}
+
+func (m *reachabilityMarker) markPin(ctx context.Context, pin Pin) error {
+	if !m.markDigest(pin.Digest) {
+		return nil
+	}
+	switch pin.Type {
+	case pb.GarbageCollectionConfiguration_Pin_DIRECTORY:
+		return m.markDirectory(ctx, pin.Digest)
+	case pb.GarbageCollectionConfiguration_Pin_TREE:
+		return m.markTree(ctx, pin.Digest)
+	default:
+		return nil
+	}
+}
+
+func (m *reachabilityMarker) markActionResult(ctx context.Context, instanceName digest.InstanceName, actionResult *remoteexecution.ActionResult) error {
+	for _, outputFile := range actionResult.OutputFiles {
+		m.markProto(instanceName, outputFile.Digest)
+	}
+	m.markProto(instanceName, actionResult.StdoutDigest)
+	m.markProto(instanceName, actionResult.StderrDigest)
+	for _, outputDirectory := range actionResult.OutputDirectories {
+		if treeDigest, ok := m.markProto(instanceName, outputDirectory.TreeDigest); ok {
+			if err := m.markTree(ctx, treeDigest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *reachabilityMarker) markDirectoryFiles(instanceName digest.InstanceName, directory *remoteexecution.Directory) {
+	if directory == nil {
+		return
+	}
+	for _, file := range directory.Files {
+		m.markProto(instanceName, file.Digest)
+	}
+}
+
+func (m *reachabilityMarker) markTree(ctx context.Context, treeDigest digest.Digest) error {
+	treeMessage, err := m.contentAddressableStorage.Get(ctx, treeDigest).ToProto(&remoteexecution.Tree{}, m.maximumMessageSizeBytes)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			// The tree is already gone, meaning none of the
+			// files contained in it can be accessed through it.
+			return nil
+		}
+		return util.StatusWrapf(err, "Failed to obtain tree %#v", treeDigest.String())
+	}
+	tree := treeMessage.(*remoteexecution.Tree)
+	instanceName := treeDigest.GetInstanceName()
+	m.markDirectoryFiles(instanceName, tree.Root)
+	for _, child := range tree.Children {
+		m.markDirectoryFiles(instanceName, child)
+	}
+	return nil
+}
+
+func (m *reachabilityMarker) markDirectory(ctx context.Context, directoryDigest digest.Digest) error {
+	directoryMessage, err := m.contentAddressableStorage.Get(ctx, directoryDigest).ToProto(&remoteexecution.Directory{}, m.maximumMessageSizeBytes)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		return util.StatusWrapf(err, "Failed to obtain directory %#v", directoryDigest.String())
+	}
+	directory := directoryMessage.(*remoteexecution.Directory)
+	instanceName := directoryDigest.GetInstanceName()
+	m.markDirectoryFiles(instanceName, directory)
+	for _, child := range directory.Directories {
+		if childDigest, ok := m.markProto(instanceName, child.Digest); ok {
+			if err := m.markDirectory(ctx, childDigest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
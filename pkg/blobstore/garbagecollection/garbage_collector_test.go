@@ -0,0 +1,204 @@
+package garbagecollection_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/garbagecollection"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGarbageCollector(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	actionCache := mock.NewMockBlobAccess(ctrl)
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+
+	actionDigest1 := digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 100)
+	actionDigest2 := digest.MustNewDigest("hello", "7fc56270e7a70fa81a5935b72eacbe29", 100)
+	outputFileDigest := digest.MustNewDigest("hello", "0cc175b9c0f1b6a831c399e269772661", 1)
+	stdoutDigest := digest.MustNewDigest("hello", "92eb5ffee6ae2fec3ad71c777531578f", 1)
+	treeDigest := digest.MustNewDigest("hello", "4a8a08f09d37b73795649038408b5f33", 200)
+	treeFileDigest := digest.MustNewDigest("hello", "8277e0910d750195b448797616e091ad", 1)
+	pinnedFileDigest := digest.MustNewDigest("world", "e1671797c52e15f763380b45e841ec32", 1)
+	pinnedDirectoryDigest := digest.MustNewDigest("world", "8fa14cdd754f91cc6554c9e71929cce7", 50)
+	pinnedSubdirectoryDigest := digest.MustNewDigest("world", "b2f5ff47436671b6e533d8dc3614845d", 50)
+	pinnedDirectoryFileDigest := digest.MustNewDigest("world", "7b8b965ad4bca0e41ab51de7b31363a1", 1)
+	unreachableDigest := digest.MustNewDigest("hello", "2510c39011c5be704182423e3a695e91", 1)
+
+	enumerateBlobs := func(ctx context.Context, storageType string, f func(blobAccess blobstore.BlobAccess, blobDigest digest.Digest) error) error {
+		require.Equal(t, "ac", storageType)
+		if err := f(actionCache, actionDigest1); err != nil {
+			return err
+		}
+		return f(actionCache, actionDigest2)
+	}
+	var expireBlobsErr error
+	expireBlobs := func(ctx context.Context, storageType string, modifiedBefore time.Time, isRetained func(blobDigest digest.Digest) bool) (int64, error) {
+		require.Equal(t, "cas", storageType)
+		require.Equal(t, time.Unix(1000, 0), modifiedBefore)
+
+		// Reachability should not depend on the instance name.
+		for _, blobDigest := range []digest.Digest{
+			outputFileDigest,
+			stdoutDigest,
+			treeDigest,
+			treeFileDigest,
+			pinnedFileDigest,
+			pinnedDirectoryDigest,
+			pinnedSubdirectoryDigest,
+			pinnedDirectoryFileDigest,
+			digest.MustNewDigest("", "0cc175b9c0f1b6a831c399e269772661", 1),
+		} {
+			require.True(t, isRetained(blobDigest), blobDigest.String())
+		}
+		require.False(t, isRetained(unreachableDigest))
+		return 3, expireBlobsErr
+	}
+	garbageCollector := garbagecollection.NewGarbageCollector(
+		enumerateBlobs,
+		expireBlobs,
+		contentAddressableStorage,
+		clock,
+		[]garbagecollection.Pin{
+			{Digest: pinnedFileDigest, Type: pb.GarbageCollectionConfiguration_Pin_FILE},
+			{Digest: pinnedDirectoryDigest, Type: pb.GarbageCollectionConfiguration_Pin_DIRECTORY},
+		},
+		time.Hour,
+		10000)
+
+	expectPins := func() {
+		contentAddressableStorage.EXPECT().Get(ctx, pinnedDirectoryDigest).Return(buffer.NewProtoBufferFromProto(&remoteexecution.Directory{
+			Directories: []*remoteexecution.DirectoryNode{
+				{
+					Name: "subdirectory",
+					Digest: &remoteexecution.Digest{
+						Hash:      "b2f5ff47436671b6e533d8dc3614845d",
+						SizeBytes: 50,
+					},
+				},
+			},
+		}, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(ctx, pinnedSubdirectoryDigest).Return(buffer.NewProtoBufferFromProto(&remoteexecution.Directory{
+			Files: []*remoteexecution.FileNode{
+				{
+					Name: "file",
+					Digest: &remoteexecution.Digest{
+						Hash:      "7b8b965ad4bca0e41ab51de7b31363a1",
+						SizeBytes: 1,
+					},
+				},
+			},
+		}, buffer.UserProvided))
+	}
+	actionResult := &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{
+				Path: "file",
+				Digest: &remoteexecution.Digest{
+					Hash:      "0cc175b9c0f1b6a831c399e269772661",
+					SizeBytes: 1,
+				},
+			},
+		},
+		OutputDirectories: []*remoteexecution.OutputDirectory{
+			{
+				Path: "directory",
+				TreeDigest: &remoteexecution.Digest{
+					Hash:      "4a8a08f09d37b73795649038408b5f33",
+					SizeBytes: 200,
+				},
+			},
+		},
+		StdoutDigest: &remoteexecution.Digest{
+			Hash:      "92eb5ffee6ae2fec3ad71c777531578f",
+			SizeBytes: 1,
+		},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(4600, 0))
+		expectPins()
+
+		// The first action result references a tree. The second
+		// action result has been removed during traversal.
+		actionCache.EXPECT().Get(ctx, actionDigest1).Return(buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(ctx, treeDigest).Return(buffer.NewProtoBufferFromProto(&remoteexecution.Tree{
+			Root: &remoteexecution.Directory{
+				Files: []*remoteexecution.FileNode{
+					{
+						Name: "file",
+						Digest: &remoteexecution.Digest{
+							Hash:      "8277e0910d750195b448797616e091ad",
+							SizeBytes: 1,
+						},
+					},
+				},
+			},
+		}, buffer.UserProvided))
+		actionCache.EXPECT().Get(ctx, actionDigest2).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		stats, err := garbageCollector.Run(ctx)
+		require.NoError(t, err)
+		require.Equal(t, garbagecollection.RunStatistics{
+			ActionResults:  1,
+			ReachableBlobs: 8,
+			ExpiredBlobs:   3,
+		}, stats)
+	})
+
+	t.Run("TreeFailure", func(t *testing.T) {
+		// Failing to load a tree should cause the run to be
+		// aborted, as the files contained in the tree would
+		// otherwise be removed.
+		clock.EXPECT().Now().Return(time.Unix(4600, 0))
+		expectPins()
+		actionCache.EXPECT().Get(ctx, actionDigest1).Return(buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(ctx, treeDigest).Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server on fire")))
+
+		_, err := garbageCollector.Run(ctx)
+		testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Failed to traverse the Action Cache: Failed to obtain tree \"4a8a08f09d37b73795649038408b5f33-200-hello\": Server on fire"), err)
+	})
+
+	t.Run("ExpireFailure", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(4600, 0))
+		expectPins()
+		actionCache.EXPECT().Get(ctx, actionDigest1).Return(buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(ctx, treeDigest).Return(buffer.NewProtoBufferFromProto(&remoteexecution.Tree{
+			Root: &remoteexecution.Directory{
+				Files: []*remoteexecution.FileNode{
+					{
+						Name: "file",
+						Digest: &remoteexecution.Digest{
+							Hash:      "8277e0910d750195b448797616e091ad",
+							SizeBytes: 1,
+						},
+					},
+				},
+			},
+		}, buffer.UserProvided))
+		actionCache.EXPECT().Get(ctx, actionDigest2).Return(buffer.NewProtoBufferFromProto(&remoteexecution.ActionResult{}, buffer.UserProvided))
+		expireBlobsErr = status.Error(codes.Unavailable, "Bucket on fire")
+
+		stats, err := garbageCollector.Run(ctx)
+		testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Failed to expire unreachable objects in the Content Addressable Storage: Bucket on fire"), err)
+		require.Equal(t, garbagecollection.RunStatistics{
+			ActionResults:  2,
+			ReachableBlobs: 8,
+			ExpiredBlobs:   3,
+		}, stats)
+	})
+}
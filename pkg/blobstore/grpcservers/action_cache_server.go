@@ -8,19 +8,30 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 type actionCacheServer struct {
-	blobAccess              blobstore.BlobAccess
-	maximumMessageSizeBytes int
+	blobAccess                blobstore.BlobAccess
+	contentAddressableStorage blobstore.BlobAccess
+	maximumMessageSizeBytes   int
 }
 
 // NewActionCacheServer creates a GRPC service for serving the contents
 // of a Bazel Action Cache (AC) to Bazel.
-func NewActionCacheServer(blobAccess blobstore.BlobAccess, maximumMessageSizeBytes int) remoteexecution.ActionCacheServer {
+//
+// When requested by the client, the contents of small standard output,
+// standard error and output files are loaded from the Content
+// Addressable Storage (CAS) and embedded into the ActionResult. This
+// saves the client from having to download them separately.
+func NewActionCacheServer(blobAccess, contentAddressableStorage blobstore.BlobAccess, maximumMessageSizeBytes int) remoteexecution.ActionCacheServer {
 	return &actionCacheServer{
-		blobAccess:              blobAccess,
-		maximumMessageSizeBytes: maximumMessageSizeBytes,
+		blobAccess:                blobAccess,
+		contentAddressableStorage: contentAddressableStorage,
+		maximumMessageSizeBytes:   maximumMessageSizeBytes,
 	}
 }
 
@@ -39,7 +50,68 @@ func (s *actionCacheServer) GetActionResult(ctx context.Context, in *remoteexecu
 	if err != nil {
 		return nil, err
 	}
-	return actionResult.(*remoteexecution.ActionResult), nil
+	if !in.InlineStdout && !in.InlineStderr && len(in.InlineOutputFiles) == 0 {
+		return actionResult.(*remoteexecution.ActionResult), nil
+	}
+
+	// Inline the contents of blobs requested by the client. Blobs
+	// are only inlined as long as the response remains within the
+	// maximum message size. The message returned by the buffer may
+	// be shared, so modify a copy.
+	inlinedActionResult := proto.Clone(actionResult).(*remoteexecution.ActionResult)
+	bytesRemaining := int64(s.maximumMessageSizeBytes - proto.Size(inlinedActionResult))
+	if in.InlineStdout && len(inlinedActionResult.StdoutRaw) == 0 {
+		if inlinedActionResult.StdoutRaw, err = s.getInlinedBlob(ctx, instanceName, inlinedActionResult.StdoutDigest, &bytesRemaining); err != nil {
+			return nil, util.StatusWrap(err, "Failed to inline standard output")
+		}
+	}
+	if in.InlineStderr && len(inlinedActionResult.StderrRaw) == 0 {
+		if inlinedActionResult.StderrRaw, err = s.getInlinedBlob(ctx, instanceName, inlinedActionResult.StderrDigest, &bytesRemaining); err != nil {
+			return nil, util.StatusWrap(err, "Failed to inline standard error")
+		}
+	}
+	if len(in.InlineOutputFiles) > 0 {
+		inlineOutputFiles := make(map[string]struct{}, len(in.InlineOutputFiles))
+		for _, path := range in.InlineOutputFiles {
+			inlineOutputFiles[path] = struct{}{}
+		}
+		for _, outputFile := range inlinedActionResult.OutputFiles {
+			if _, ok := inlineOutputFiles[outputFile.Path]; ok && len(outputFile.Contents) == 0 {
+				if outputFile.Contents, err = s.getInlinedBlob(ctx, instanceName, outputFile.Digest, &bytesRemaining); err != nil {
+					return nil, util.StatusWrapf(err, "Failed to inline output file %#v", outputFile.Path)
+				}
+			}
+		}
+	}
+	return inlinedActionResult, nil
+}
+
+// getInlinedBlob loads the contents of a blob from the Content
+// Addressable Storage, so that it can be embedded into an ActionResult.
+// Nothing is returned if the blob does not fit in the remaining space
+// of the response message, or if it is not present.
+func (s *actionCacheServer) getInlinedBlob(ctx context.Context, instanceName digest.InstanceName, blobDigest *remoteexecution.Digest, bytesRemaining *int64) ([]byte, error) {
+	if blobDigest == nil || blobDigest.SizeBytes == 0 {
+		return nil, nil
+	}
+	// Account for the overhead of the field containing the data.
+	sizeBytes := blobDigest.SizeBytes + 16
+	if sizeBytes > *bytesRemaining {
+		return nil, nil
+	}
+	d, err := instanceName.NewDigestFromProto(blobDigest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.contentAddressableStorage.Get(ctx, d).ToByteSlice(int(d.GetSizeBytes()))
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	*bytesRemaining -= sizeBytes
+	return data, nil
 }
 
 func (s *actionCacheServer) UpdateActionResult(ctx context.Context, in *remoteexecution.UpdateActionResultRequest) (*remoteexecution.ActionResult, error) {
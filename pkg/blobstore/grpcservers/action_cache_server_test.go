@@ -0,0 +1,148 @@
+package grpcservers_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/grpcservers"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestActionCacheServerGetActionResult(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	actionCache := mock.NewMockBlobAccess(ctrl)
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	actionCacheServer := grpcservers.NewActionCacheServer(actionCache, contentAddressableStorage, 1000)
+
+	actionDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 123)
+	actionResult := &remoteexecution.ActionResult{
+		OutputFiles: []*remoteexecution.OutputFile{
+			{
+				Path: "small",
+				Digest: &remoteexecution.Digest{
+					Hash:      "3e25960a79dbc69b674cd4ec67a72c62",
+					SizeBytes: 11,
+				},
+			},
+			{
+				Path: "large",
+				Digest: &remoteexecution.Digest{
+					Hash:      "0a4d55a8d778e5022fab701977c5d840",
+					SizeBytes: 10000,
+				},
+			},
+			{
+				Path: "missing",
+				Digest: &remoteexecution.Digest{
+					Hash:      "8fc56270e7a70fa81a5935b72eacbe29",
+					SizeBytes: 7,
+				},
+			},
+		},
+		StdoutDigest: &remoteexecution.Digest{
+			Hash:      "5d41402abc4b2a76b9719d911017c592",
+			SizeBytes: 5,
+		},
+		StderrDigest: &remoteexecution.Digest{
+			Hash:      "7d793037a0760186574b0282f2f435e7",
+			SizeBytes: 5,
+		},
+	}
+
+	t.Run("NoInlining", func(t *testing.T) {
+		// If the client doesn't request inlining, the
+		// ActionResult should be returned as is.
+		actionCache.EXPECT().Get(ctx, actionDigest).Return(
+			buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided))
+
+		response, err := actionCacheServer.GetActionResult(ctx, &remoteexecution.GetActionResultRequest{
+			InstanceName: "hello",
+			ActionDigest: actionDigest.GetProto(),
+		})
+		require.NoError(t, err)
+		testutil.RequireEqualProto(t, actionResult, response)
+	})
+
+	t.Run("Inlining", func(t *testing.T) {
+		// Only blobs that are present and fit in the response
+		// should be inlined.
+		actionCache.EXPECT().Get(ctx, actionDigest).Return(
+			buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(ctx, digest.MustNewDigest("hello", "5d41402abc4b2a76b9719d911017c592", 5)).Return(
+			buffer.NewValidatedBufferFromByteSlice([]byte("hello")))
+		contentAddressableStorage.EXPECT().Get(ctx, digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)).Return(
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+		contentAddressableStorage.EXPECT().Get(ctx, digest.MustNewDigest("hello", "8fc56270e7a70fa81a5935b72eacbe29", 7)).Return(
+			buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		response, err := actionCacheServer.GetActionResult(ctx, &remoteexecution.GetActionResultRequest{
+			InstanceName:      "hello",
+			ActionDigest:      actionDigest.GetProto(),
+			InlineStdout:      true,
+			InlineOutputFiles: []string{"small", "large", "missing"},
+		})
+		require.NoError(t, err)
+		testutil.RequireEqualProto(t, &remoteexecution.ActionResult{
+			OutputFiles: []*remoteexecution.OutputFile{
+				{
+					Path: "small",
+					Digest: &remoteexecution.Digest{
+						Hash:      "3e25960a79dbc69b674cd4ec67a72c62",
+						SizeBytes: 11,
+					},
+					Contents: []byte("Hello world"),
+				},
+				{
+					Path: "large",
+					Digest: &remoteexecution.Digest{
+						Hash:      "0a4d55a8d778e5022fab701977c5d840",
+						SizeBytes: 10000,
+					},
+				},
+				{
+					Path: "missing",
+					Digest: &remoteexecution.Digest{
+						Hash:      "8fc56270e7a70fa81a5935b72eacbe29",
+						SizeBytes: 7,
+					},
+				},
+			},
+			StdoutDigest: &remoteexecution.Digest{
+				Hash:      "5d41402abc4b2a76b9719d911017c592",
+				SizeBytes: 5,
+			},
+			StdoutRaw: []byte("hello"),
+			StderrDigest: &remoteexecution.Digest{
+				Hash:      "7d793037a0760186574b0282f2f435e7",
+				SizeBytes: 5,
+			},
+		}, response)
+
+		// The cached ActionResult should not have been modified.
+		require.Empty(t, actionResult.StdoutRaw)
+	})
+
+	t.Run("StorageFailure", func(t *testing.T) {
+		actionCache.EXPECT().Get(ctx, actionDigest).Return(
+			buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(ctx, digest.MustNewDigest("hello", "7d793037a0760186574b0282f2f435e7", 5)).Return(
+			buffer.NewBufferFromError(status.Error(codes.Internal, "Server on fire")))
+
+		_, err := actionCacheServer.GetActionResult(ctx, &remoteexecution.GetActionResultRequest{
+			InstanceName: "hello",
+			ActionDigest: actionDigest.GetProto(),
+			InlineStderr: true,
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.Internal, "Failed to inline standard error: Server on fire"), err)
+	})
+}
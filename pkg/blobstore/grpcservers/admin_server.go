@@ -0,0 +1,52 @@
+package grpcservers
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/logging"
+	"github.com/buildbarn/bb-storage/pkg/proto/admin"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// BlobDeleterFunc is called by the administrative gRPC service to
+// remove a blob from all storage backends of a given storage type. It
+// returns the paths of the backends from which the blob was deleted.
+type BlobDeleterFunc func(ctx context.Context, storageType string, blobDigest digest.Digest) ([]string, error)
+
+type adminServer struct {
+	deleteBlob BlobDeleterFunc
+}
+
+// NewAdminServer creates a gRPC service for performing administrative
+// operations against storage, such as deleting individual blobs.
+func NewAdminServer(deleteBlob BlobDeleterFunc) admin.AdminServer {
+	return &adminServer{
+		deleteBlob: deleteBlob,
+	}
+}
+
+func (s *adminServer) DeleteBlob(ctx context.Context, in *admin.DeleteBlobRequest) (*admin.DeleteBlobResponse, error) {
+	instanceName, err := digest.NewInstanceName(in.InstanceName)
+	if err != nil {
+		return nil, util.StatusWrapf(err, "Invalid instance name %#v", in.InstanceName)
+	}
+	blobDigest, err := instanceName.NewDigestFromProto(in.Digest)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Invalid digest")
+	}
+
+	// Log deletions, so that there is a trail of which blobs have
+	// been purged. This also covers partial failures, as the blob
+	// may have been deleted from some of the backends.
+	deletedBackends, err := s.deleteBlob(ctx, in.StorageType, blobDigest)
+	for _, backend := range deletedBackends {
+		logging.Printf(ctx, "Deleted blob %#v from backend %#v", blobDigest.String(), backend)
+	}
+	if err != nil {
+		return nil, util.StatusWrapf(err, "Failed to delete blob %#v", blobDigest.String())
+	}
+	return &admin.DeleteBlobResponse{
+		DeletedBackends: deletedBackends,
+	}, nil
+}
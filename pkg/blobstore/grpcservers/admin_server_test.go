@@ -0,0 +1,93 @@
+package grpcservers_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/grpcservers"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/admin"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdminServerDeleteBlob(t *testing.T) {
+	ctx := context.Background()
+
+	var deletedDigests []digest.Digest
+	s := grpcservers.NewAdminServer(func(ctx context.Context, storageType string, blobDigest digest.Digest) ([]string, error) {
+		deletedDigests = append(deletedDigests, blobDigest)
+		switch storageType {
+		case "cas":
+			return []string{"cas_sharding/cas_redis[0]", "cas_sharding/cas_redis[1]"}, nil
+		case "ac":
+			return []string{"ac_mirrored/ac_s3[0]"}, status.Error(codes.Unavailable, "Backend \"ac_mirrored/ac_s3[1]\": Failed to delete blob: Access Denied")
+		default:
+			return nil, status.Errorf(codes.FailedPrecondition, "None of the storage backends of storage type %#v support deleting blobs", storageType)
+		}
+	})
+
+	t.Run("InvalidInstanceName", func(t *testing.T) {
+		_, err := s.DeleteBlob(ctx, &admin.DeleteBlobRequest{
+			InstanceName: "hello/blobs/world",
+			StorageType:  "cas",
+			Digest: &remoteexecution.Digest{
+				Hash:      "8b1a9953c4611296a827abf8c47804d7",
+				SizeBytes: 5,
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Invalid instance name \"hello/blobs/world\": Instance name contains reserved keyword \"blobs\""), err)
+	})
+
+	t.Run("InvalidDigest", func(t *testing.T) {
+		_, err := s.DeleteBlob(ctx, &admin.DeleteBlobRequest{
+			InstanceName: "hello",
+			StorageType:  "cas",
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Invalid digest: No digest provided"), err)
+	})
+
+	t.Run("UnsupportedStorageType", func(t *testing.T) {
+		_, err := s.DeleteBlob(ctx, &admin.DeleteBlobRequest{
+			InstanceName: "hello",
+			StorageType:  "icas",
+			Digest: &remoteexecution.Digest{
+				Hash:      "8b1a9953c4611296a827abf8c47804d7",
+				SizeBytes: 5,
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.FailedPrecondition, "Failed to delete blob \"8b1a9953c4611296a827abf8c47804d7-5-hello\": None of the storage backends of storage type \"icas\" support deleting blobs"), err)
+	})
+
+	t.Run("PartialFailure", func(t *testing.T) {
+		_, err := s.DeleteBlob(ctx, &admin.DeleteBlobRequest{
+			InstanceName: "hello",
+			StorageType:  "ac",
+			Digest: &remoteexecution.Digest{
+				Hash:      "8b1a9953c4611296a827abf8c47804d7",
+				SizeBytes: 5,
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Failed to delete blob \"8b1a9953c4611296a827abf8c47804d7-5-hello\": Backend \"ac_mirrored/ac_s3[1]\": Failed to delete blob: Access Denied"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		response, err := s.DeleteBlob(ctx, &admin.DeleteBlobRequest{
+			InstanceName: "hello",
+			StorageType:  "cas",
+			Digest: &remoteexecution.Digest{
+				Hash:      "8b1a9953c4611296a827abf8c47804d7",
+				SizeBytes: 5,
+			},
+		})
+		require.NoError(t, err)
+		testutil.RequireEqualProto(t, &admin.DeleteBlobResponse{
+			DeletedBackends: []string{"cas_sharding/cas_redis[0]", "cas_sharding/cas_redis[1]"},
+		}, response)
+		require.Equal(t, digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5), deletedDigests[len(deletedDigests)-1])
+	})
+}
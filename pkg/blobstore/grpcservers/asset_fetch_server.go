@@ -0,0 +1,125 @@
+package grpcservers
+
+import (
+	"context"
+	"time"
+
+	remoteasset "github.com/bazelbuild/remote-apis/build/bazel/remote/asset/v1"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/asset"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type assetFetchServer struct {
+	assetStore                blobstore.BlobAccess
+	assetKeyGenerator         *AssetKeyGenerator
+	contentAddressableStorage blobstore.BlobAccess
+	clock                     clock.Clock
+	maximumMessageSizeBytes   int
+}
+
+// NewAssetFetchServer creates a gRPC service for the Fetch service of
+// the Remote Asset API. Assets are only resolved using the mappings
+// stored in the Asset Store by the Push service. Assets are never
+// downloaded from their origin. NewHTTPFetchingAssetFetchServer can be
+// used to add support for that.
+//
+// Assets are only returned if their blob or root Directory message is
+// still present in the Content Addressable Storage. Children of
+// directories are not checked for existence.
+func NewAssetFetchServer(assetStore blobstore.BlobAccess, assetKeyGenerator *AssetKeyGenerator, contentAddressableStorage blobstore.BlobAccess, clock clock.Clock, maximumMessageSizeBytes int) remoteasset.FetchServer {
+	return &assetFetchServer{
+		assetStore:                assetStore,
+		assetKeyGenerator:         assetKeyGenerator,
+		contentAddressableStorage: contentAddressableStorage,
+		clock:                     clock,
+		maximumMessageSizeBytes:   maximumMessageSizeBytes,
+	}
+}
+
+// fetchAsset returns the first URI for which an asset of a given type
+// is present, together with the asset itself.
+func (s *assetFetchServer) fetchAsset(ctx context.Context, instanceNameStr string, uris []string, qualifiers []*remoteasset.Qualifier, oldestContentAccepted *timestamppb.Timestamp, assetType asset.Asset_AssetType) (string, *asset.Asset, error) {
+	instanceName, err := digest.NewInstanceName(instanceNameStr)
+	if err != nil {
+		return "", nil, util.StatusWrapf(err, "Invalid instance name %#v", instanceNameStr)
+	}
+	var oldestContentAcceptedTime time.Time
+	if oldestContentAccepted != nil {
+		if err := oldestContentAccepted.CheckValid(); err != nil {
+			return "", nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid oldest content accepted time")
+		}
+		oldestContentAcceptedTime = oldestContentAccepted.AsTime()
+	}
+
+	now := s.clock.Now()
+	for _, uri := range uris {
+		assetReferenceDigest, err := s.assetKeyGenerator.GetAssetKey(instanceName, uri, qualifiers)
+		if err != nil {
+			return "", nil, err
+		}
+		assetMessage, err := s.assetStore.Get(ctx, assetReferenceDigest).ToProto(&asset.Asset{}, s.maximumMessageSizeBytes)
+		if status.Code(err) == codes.NotFound {
+			continue
+		} else if err != nil {
+			return "", nil, util.StatusWrapf(err, "Failed to load asset for URI %#v", uri)
+		}
+
+		// Skip assets that are of the wrong type, too old or
+		// expired.
+		a := assetMessage.(*asset.Asset)
+		if a.Type != assetType ||
+			a.LastUpdated.AsTime().Before(oldestContentAcceptedTime) ||
+			(a.ExpireAt != nil && !now.Before(a.ExpireAt.AsTime())) {
+			continue
+		}
+
+		// Skip assets whose contents have disappeared from the
+		// Content Addressable Storage.
+		rootDigest, err := instanceName.NewDigestFromProto(a.Digest)
+		if err != nil {
+			continue
+		}
+		missing, err := s.contentAddressableStorage.FindMissing(ctx, rootDigest.ToSingletonSet())
+		if err != nil {
+			return "", nil, util.StatusWrapf(err, "Failed to determine existence of asset for URI %#v", uri)
+		}
+		if missing.Length() > 0 {
+			continue
+		}
+		return uri, a, nil
+	}
+	return "", nil, status.Error(codes.NotFound, "No asset is present for any of the provided URIs")
+}
+
+func (s *assetFetchServer) FetchBlob(ctx context.Context, in *remoteasset.FetchBlobRequest) (*remoteasset.FetchBlobResponse, error) {
+	uri, a, err := s.fetchAsset(ctx, in.InstanceName, in.Uris, in.Qualifiers, in.OldestContentAccepted, asset.Asset_BLOB)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteasset.FetchBlobResponse{
+		Uri:        uri,
+		Qualifiers: in.Qualifiers,
+		ExpiresAt:  a.ExpireAt,
+		BlobDigest: a.Digest,
+	}, nil
+}
+
+func (s *assetFetchServer) FetchDirectory(ctx context.Context, in *remoteasset.FetchDirectoryRequest) (*remoteasset.FetchDirectoryResponse, error) {
+	uri, a, err := s.fetchAsset(ctx, in.InstanceName, in.Uris, in.Qualifiers, in.OldestContentAccepted, asset.Asset_DIRECTORY)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteasset.FetchDirectoryResponse{
+		Uri:                 uri,
+		Qualifiers:          in.Qualifiers,
+		ExpiresAt:           a.ExpireAt,
+		RootDirectoryDigest: a.Digest,
+	}, nil
+}
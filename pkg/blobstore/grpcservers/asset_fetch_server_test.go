@@ -0,0 +1,162 @@
+package grpcservers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	remoteasset "github.com/bazelbuild/remote-apis/build/bazel/remote/asset/v1"
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/grpcservers"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/asset"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestAssetFetchServer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	assetStore := mock.NewMockBlobAccess(ctrl)
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	s := grpcservers.NewAssetFetchServer(assetStore, grpcservers.NewAssetKeyGenerator(nil), contentAddressableStorage, clock, 1000)
+
+	blobDigest := &remoteexecution.Digest{
+		Hash:      "8b1a9953c4611296a827abf8c47804d7",
+		SizeBytes: 5,
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+		assetStore.EXPECT().Get(ctx, gomock.Any()).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found"))).Times(2)
+
+		_, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris: []string{
+				"https://example.com/foo.tar.gz",
+				"https://mirror.example.com/foo.tar.gz",
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "No asset is present for any of the provided URIs"), err)
+	})
+
+	t.Run("StorageFailure", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+		assetStore.EXPECT().Get(ctx, gomock.Any()).Return(buffer.NewBufferFromError(status.Error(codes.Internal, "Disk on fire")))
+
+		_, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"https://example.com/foo.tar.gz"},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.Internal, "Failed to load asset for URI \"https://example.com/foo.tar.gz\": Disk on fire"), err)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+		assetStore.EXPECT().Get(ctx, gomock.Any()).Return(buffer.NewProtoBufferFromProto(&asset.Asset{
+			Digest:      blobDigest,
+			Type:        asset.Asset_BLOB,
+			LastUpdated: &timestamppb.Timestamp{Seconds: 1500000000},
+			ExpireAt:    &timestamppb.Timestamp{Seconds: 1600000000},
+		}, buffer.UserProvided))
+
+		_, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"https://example.com/foo.tar.gz"},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "No asset is present for any of the provided URIs"), err)
+	})
+
+	t.Run("TooOld", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+		assetStore.EXPECT().Get(ctx, gomock.Any()).Return(buffer.NewProtoBufferFromProto(&asset.Asset{
+			Digest:      blobDigest,
+			Type:        asset.Asset_BLOB,
+			LastUpdated: &timestamppb.Timestamp{Seconds: 1500000000},
+		}, buffer.UserProvided))
+
+		_, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName:          "example",
+			Uris:                  []string{"https://example.com/foo.tar.gz"},
+			OldestContentAccepted: &timestamppb.Timestamp{Seconds: 1550000000},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "No asset is present for any of the provided URIs"), err)
+	})
+
+	t.Run("WrongType", func(t *testing.T) {
+		// Directories should not be returned by FetchBlob().
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+		assetStore.EXPECT().Get(ctx, gomock.Any()).Return(buffer.NewProtoBufferFromProto(&asset.Asset{
+			Digest:      blobDigest,
+			Type:        asset.Asset_DIRECTORY,
+			LastUpdated: &timestamppb.Timestamp{Seconds: 1500000000},
+		}, buffer.UserProvided))
+
+		_, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"https://example.com/foo.tar.gz"},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "No asset is present for any of the provided URIs"), err)
+	})
+
+	t.Run("MissingFromCAS", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+		assetStore.EXPECT().Get(ctx, gomock.Any()).Return(buffer.NewProtoBufferFromProto(&asset.Asset{
+			Digest:      blobDigest,
+			Type:        asset.Asset_BLOB,
+			LastUpdated: &timestamppb.Timestamp{Seconds: 1500000000},
+		}, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().FindMissing(ctx, digest.MustNewDigest("example", "8b1a9953c4611296a827abf8c47804d7", 5).ToSingletonSet()).
+			Return(digest.MustNewDigest("example", "8b1a9953c4611296a827abf8c47804d7", 5).ToSingletonSet(), nil)
+
+		_, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"https://example.com/foo.tar.gz"},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "No asset is present for any of the provided URIs"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// The first URI is absent, meaning the second URI
+		// should be returned.
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+		gomock.InOrder(
+			assetStore.EXPECT().Get(ctx, gomock.Any()).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found"))),
+			assetStore.EXPECT().Get(ctx, gomock.Any()).Return(buffer.NewProtoBufferFromProto(&asset.Asset{
+				Digest:      blobDigest,
+				Type:        asset.Asset_DIRECTORY,
+				LastUpdated: &timestamppb.Timestamp{Seconds: 1500000000},
+				ExpireAt:    &timestamppb.Timestamp{Seconds: 1700000000},
+			}, buffer.UserProvided)))
+		contentAddressableStorage.EXPECT().FindMissing(ctx, digest.MustNewDigest("example", "8b1a9953c4611296a827abf8c47804d7", 5).ToSingletonSet()).
+			Return(digest.EmptySet, nil)
+
+		response, err := s.FetchDirectory(ctx, &remoteasset.FetchDirectoryRequest{
+			InstanceName: "example",
+			Uris: []string{
+				"https://example.com/foo.tar.gz",
+				"https://mirror.example.com/foo.tar.gz",
+			},
+			Qualifiers: []*remoteasset.Qualifier{
+				{Name: "resource_type", Value: "application/x-tar"},
+			},
+		})
+		require.NoError(t, err)
+		testutil.RequireEqualProto(t, &remoteasset.FetchDirectoryResponse{
+			Uri: "https://mirror.example.com/foo.tar.gz",
+			Qualifiers: []*remoteasset.Qualifier{
+				{Name: "resource_type", Value: "application/x-tar"},
+			},
+			ExpiresAt:           &timestamppb.Timestamp{Seconds: 1700000000},
+			RootDirectoryDigest: blobDigest,
+		}, response)
+	})
+}
@@ -0,0 +1,143 @@
+package grpcservers
+
+import (
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+
+	remoteasset "github.com/bazelbuild/remote-apis/build/bazel/remote/asset/v1"
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/asset"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// AssetKeyGenerator computes the keys under which assets are stored in
+// the Asset Store. A key is the SHA-256 digest of an AssetReference
+// message, containing the URI and the qualifiers of the asset in
+// canonical form. This ensures that requests that are semantically
+// identical, but differ in the order of qualifiers or in the spelling
+// of their values, map to the same key.
+type AssetKeyGenerator struct {
+	allowedNames    map[string]struct{}
+	allowedPrefixes []string
+}
+
+// NewAssetKeyGenerator creates an AssetKeyGenerator. If a non-empty
+// allowlist of qualifier names is provided, qualifiers whose names are
+// not part of it are not included in keys. Entries in the allowlist
+// that end with "*" match all qualifiers whose names start with the
+// preceding prefix. This can be used to ignore qualifiers that don't
+// affect the contents of an asset, such as "http_header:Authorization".
+func NewAssetKeyGenerator(qualifierAllowlist []string) *AssetKeyGenerator {
+	g := &AssetKeyGenerator{}
+	if len(qualifierAllowlist) > 0 {
+		g.allowedNames = map[string]struct{}{}
+		for _, name := range qualifierAllowlist {
+			if strings.HasSuffix(name, "*") {
+				g.allowedPrefixes = append(g.allowedPrefixes, name[:len(name)-1])
+			} else {
+				g.allowedNames[name] = struct{}{}
+			}
+		}
+	}
+	return g
+}
+
+func (g *AssetKeyGenerator) isAllowed(name string) bool {
+	if g.allowedNames == nil {
+		return true
+	}
+	if _, ok := g.allowedNames[name]; ok {
+		return true
+	}
+	for _, prefix := range g.allowedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalizeURI converts the case insensitive parts of a URI (i.e.,
+// the scheme and the host) to lower case.
+func canonicalizeURI(uri string) string {
+	parsedURI, err := url.Parse(uri)
+	if err != nil || parsedURI.Scheme == "" || parsedURI.Opaque != "" {
+		return uri
+	}
+	parsedURI.Scheme = strings.ToLower(parsedURI.Scheme)
+	parsedURI.Host = strings.ToLower(parsedURI.Host)
+	return parsedURI.String()
+}
+
+// canonicalizeQualifier converts the name and value of a qualifier to
+// canonical form, based on the semantics of qualifiers that are
+// described in the Remote Asset API's qualifier lexicon.
+func canonicalizeQualifier(name, value string) (string, string) {
+	switch {
+	case name == "checksum.sri":
+		// Hashes in Subresource Integrity strings are separated
+		// by whitespace, and are unordered.
+		hashes := strings.Fields(value)
+		sort.Strings(hashes)
+		return name, strings.Join(hashes, " ")
+	case name == "resource_type", name == "vcs.commit":
+		// MIME types and commit hashes are case insensitive.
+		return name, strings.ToLower(strings.TrimSpace(value))
+	case strings.HasPrefix(name, httpHeaderQualifierPrefix):
+		// HTTP header names are case insensitive.
+		return httpHeaderQualifierPrefix + textproto.CanonicalMIMEHeaderKey(name[len(httpHeaderQualifierPrefix):]), value
+	default:
+		return name, value
+	}
+}
+
+// GetAssetKey returns the key under which the asset with a given URI
+// and qualifiers is stored.
+func (g *AssetKeyGenerator) GetAssetKey(instanceName digest.InstanceName, uri string, qualifiers []*remoteasset.Qualifier) (digest.Digest, error) {
+	canonicalQualifiers := make([]*asset.Qualifier, 0, len(qualifiers))
+	for _, qualifier := range qualifiers {
+		name, value := canonicalizeQualifier(qualifier.Name, qualifier.Value)
+		canonicalQualifiers = append(canonicalQualifiers, &asset.Qualifier{
+			Name:  name,
+			Value: value,
+		})
+	}
+	sort.Slice(canonicalQualifiers, func(i, j int) bool {
+		return canonicalQualifiers[i].Name < canonicalQualifiers[j].Name
+	})
+	for i := 1; i < len(canonicalQualifiers); i++ {
+		if name := canonicalQualifiers[i].Name; name == canonicalQualifiers[i-1].Name {
+			return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Qualifier %#v is specified multiple times", name)
+		}
+	}
+
+	assetReference := asset.AssetReference{
+		Uri:        canonicalizeURI(uri),
+		Qualifiers: make([]*asset.Qualifier, 0, len(canonicalQualifiers)),
+	}
+	for _, qualifier := range canonicalQualifiers {
+		if g.isAllowed(qualifier.Name) {
+			assetReference.Qualifiers = append(assetReference.Qualifiers, qualifier)
+		}
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(&assetReference)
+	if err != nil {
+		return digest.BadDigest, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to marshal asset reference")
+	}
+	digestFunction, err := instanceName.GetDigestFunction(remoteexecution.DigestFunction_SHA256)
+	if err != nil {
+		return digest.BadDigest, err
+	}
+	generator := digestFunction.NewGenerator()
+	if _, err := generator.Write(data); err != nil {
+		return digest.BadDigest, err
+	}
+	return generator.Sum(), nil
+}
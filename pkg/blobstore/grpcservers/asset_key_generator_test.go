@@ -0,0 +1,87 @@
+package grpcservers_test
+
+import (
+	"testing"
+
+	remoteasset "github.com/bazelbuild/remote-apis/build/bazel/remote/asset/v1"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/grpcservers"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAssetKeyGenerator(t *testing.T) {
+	instanceName := digest.MustNewInstanceName("example")
+
+	t.Run("Canonicalization", func(t *testing.T) {
+		// Requests that only differ in the order of qualifiers,
+		// the order of hashes in Subresource Integrity strings,
+		// or the case of case insensitive parts should yield the
+		// same key.
+		g := grpcservers.NewAssetKeyGenerator(nil)
+		key1, err := g.GetAssetKey(instanceName, "https://example.com/Foo.tar.gz", []*remoteasset.Qualifier{
+			{Name: "checksum.sri", Value: "sha256-abc sha512-def"},
+			{Name: "http_header:authorization", Value: "Bearer token"},
+			{Name: "resource_type", Value: "application/x-tar"},
+			{Name: "vcs.commit", Value: "A5B8D4FC1FC6B4E0D37AC4BA2B7E8A45A3AF5B4E"},
+		})
+		require.NoError(t, err)
+		key2, err := g.GetAssetKey(instanceName, "HTTPS://EXAMPLE.COM/Foo.tar.gz", []*remoteasset.Qualifier{
+			{Name: "vcs.commit", Value: "a5b8d4fc1fc6b4e0d37ac4ba2b7e8a45a3af5b4e"},
+			{Name: "resource_type", Value: "Application/X-Tar"},
+			{Name: "http_header:Authorization", Value: "Bearer token"},
+			{Name: "checksum.sri", Value: " sha512-def  sha256-abc"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, key1, key2)
+
+		// The case of paths and header values is significant.
+		key3, err := g.GetAssetKey(instanceName, "https://example.com/foo.tar.gz", nil)
+		require.NoError(t, err)
+		key4, err := g.GetAssetKey(instanceName, "https://example.com/Foo.tar.gz", nil)
+		require.NoError(t, err)
+		require.NotEqual(t, key3, key4)
+	})
+
+	t.Run("DuplicateQualifier", func(t *testing.T) {
+		// Qualifiers that are identical after canonicalization
+		// should be rejected.
+		g := grpcservers.NewAssetKeyGenerator(nil)
+		_, err := g.GetAssetKey(instanceName, "https://example.com/foo.tar.gz", []*remoteasset.Qualifier{
+			{Name: "http_header:accept", Value: "*/*"},
+			{Name: "http_header:Accept", Value: "*/*"},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Qualifier \"http_header:Accept\" is specified multiple times"), err)
+	})
+
+	t.Run("Allowlist", func(t *testing.T) {
+		// Qualifiers that are not part of the allowlist should
+		// not affect the key.
+		g := grpcservers.NewAssetKeyGenerator([]string{"checksum.sri", "vcs.*"})
+		key1, err := g.GetAssetKey(instanceName, "https://example.com/foo.tar.gz", []*remoteasset.Qualifier{
+			{Name: "checksum.sri", Value: "sha256-abc"},
+			{Name: "http_header:Authorization", Value: "Bearer token1"},
+			{Name: "vcs.branch", Value: "main"},
+		})
+		require.NoError(t, err)
+		key2, err := g.GetAssetKey(instanceName, "https://example.com/foo.tar.gz", []*remoteasset.Qualifier{
+			{Name: "checksum.sri", Value: "sha256-abc"},
+			{Name: "http_header:Authorization", Value: "Bearer token2"},
+			{Name: "vcs.branch", Value: "main"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, key1, key2)
+
+		// Qualifiers matching a prefix in the allowlist should
+		// affect the key.
+		key3, err := g.GetAssetKey(instanceName, "https://example.com/foo.tar.gz", []*remoteasset.Qualifier{
+			{Name: "checksum.sri", Value: "sha256-abc"},
+			{Name: "vcs.branch", Value: "release"},
+		})
+		require.NoError(t, err)
+		require.NotEqual(t, key1, key3)
+	})
+}
@@ -0,0 +1,87 @@
+package grpcservers
+
+import (
+	"context"
+
+	remoteasset "github.com/bazelbuild/remote-apis/build/bazel/remote/asset/v1"
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/asset"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type assetPushServer struct {
+	assetStore        blobstore.BlobAccess
+	assetKeyGenerator *AssetKeyGenerator
+	clock             clock.Clock
+}
+
+// NewAssetPushServer creates a gRPC service for the Push service of
+// the Remote Asset API. Mappings from URIs and qualifiers to digests
+// are stored in an Asset Store, allowing them to be resolved through
+// the Fetch service later on.
+func NewAssetPushServer(assetStore blobstore.BlobAccess, assetKeyGenerator *AssetKeyGenerator, clock clock.Clock) remoteasset.PushServer {
+	return &assetPushServer{
+		assetStore:        assetStore,
+		assetKeyGenerator: assetKeyGenerator,
+		clock:             clock,
+	}
+}
+
+func (s *assetPushServer) pushAsset(ctx context.Context, instanceNameStr string, uris []string, qualifiers []*remoteasset.Qualifier, rootDigest *remoteexecution.Digest, assetType asset.Asset_AssetType, expireAt *timestamppb.Timestamp, referencesBlobs, referencesDirectories []*remoteexecution.Digest) error {
+	instanceName, err := digest.NewInstanceName(instanceNameStr)
+	if err != nil {
+		return util.StatusWrapf(err, "Invalid instance name %#v", instanceNameStr)
+	}
+	if len(uris) == 0 {
+		return status.Error(codes.InvalidArgument, "At least one URI must be provided")
+	}
+	if _, err := instanceName.NewDigestFromProto(rootDigest); err != nil {
+		return util.StatusWrap(err, "Invalid digest")
+	}
+	if expireAt != nil {
+		if err := expireAt.CheckValid(); err != nil {
+			return util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid expiration time")
+		}
+	}
+
+	assetMessage := &asset.Asset{
+		Digest:                rootDigest,
+		Type:                  assetType,
+		LastUpdated:           timestamppb.New(s.clock.Now()),
+		ExpireAt:              expireAt,
+		ReferencesBlobs:       referencesBlobs,
+		ReferencesDirectories: referencesDirectories,
+	}
+	for _, uri := range uris {
+		assetReferenceDigest, err := s.assetKeyGenerator.GetAssetKey(instanceName, uri, qualifiers)
+		if err != nil {
+			return err
+		}
+		if err := s.assetStore.Put(ctx, assetReferenceDigest, buffer.NewProtoBufferFromProto(assetMessage, buffer.UserProvided)); err != nil {
+			return util.StatusWrapf(err, "Failed to store asset for URI %#v", uri)
+		}
+	}
+	return nil
+}
+
+func (s *assetPushServer) PushBlob(ctx context.Context, in *remoteasset.PushBlobRequest) (*remoteasset.PushBlobResponse, error) {
+	if err := s.pushAsset(ctx, in.InstanceName, in.Uris, in.Qualifiers, in.BlobDigest, asset.Asset_BLOB, in.ExpireAt, in.ReferencesBlobs, in.ReferencesDirectories); err != nil {
+		return nil, err
+	}
+	return &remoteasset.PushBlobResponse{}, nil
+}
+
+func (s *assetPushServer) PushDirectory(ctx context.Context, in *remoteasset.PushDirectoryRequest) (*remoteasset.PushDirectoryResponse, error) {
+	if err := s.pushAsset(ctx, in.InstanceName, in.Uris, in.Qualifiers, in.RootDirectoryDigest, asset.Asset_DIRECTORY, in.ExpireAt, in.ReferencesBlobs, in.ReferencesDirectories); err != nil {
+		return nil, err
+	}
+	return &remoteasset.PushDirectoryResponse{}, nil
+}
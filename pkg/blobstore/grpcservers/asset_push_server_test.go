@@ -0,0 +1,150 @@
+package grpcservers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	remoteasset "github.com/bazelbuild/remote-apis/build/bazel/remote/asset/v1"
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/grpcservers"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/asset"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestAssetPushServer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	assetStore := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	s := grpcservers.NewAssetPushServer(assetStore, grpcservers.NewAssetKeyGenerator(nil), clock)
+
+	blobDigest := &remoteexecution.Digest{
+		Hash:      "8b1a9953c4611296a827abf8c47804d7",
+		SizeBytes: 5,
+	}
+
+	t.Run("NoURIs", func(t *testing.T) {
+		_, err := s.PushBlob(ctx, &remoteasset.PushBlobRequest{
+			InstanceName: "example",
+			BlobDigest:   blobDigest,
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "At least one URI must be provided"), err)
+	})
+
+	t.Run("BadDigest", func(t *testing.T) {
+		_, err := s.PushBlob(ctx, &remoteasset.PushBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"https://example.com/foo.tar.gz"},
+			BlobDigest: &remoteexecution.Digest{
+				Hash:      "This is not a valid hash",
+				SizeBytes: 123,
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Invalid digest: Unknown digest hash length: 24 characters"), err)
+	})
+
+	t.Run("DuplicateQualifier", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+
+		_, err := s.PushBlob(ctx, &remoteasset.PushBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"https://example.com/foo.tar.gz"},
+			Qualifiers: []*remoteasset.Qualifier{
+				{Name: "checksum.sri", Value: "sha256-abc"},
+				{Name: "checksum.sri", Value: "sha256-def"},
+			},
+			BlobDigest: blobDigest,
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Qualifier \"checksum.sri\" is specified multiple times"), err)
+	})
+
+	t.Run("StorageFailure", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+		assetStore.EXPECT().Put(ctx, gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Internal, "Disk on fire")
+			})
+
+		_, err := s.PushBlob(ctx, &remoteasset.PushBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"https://example.com/foo.tar.gz"},
+			BlobDigest:   blobDigest,
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.Internal, "Failed to store asset for URI \"https://example.com/foo.tar.gz\": Disk on fire"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Pushing a blob under two URIs should cause two
+		// entries to be written into the Asset Store.
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+		var keys []digest.Digest
+		assetStore.EXPECT().Put(ctx, gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				m, err := b.ToProto(&asset.Asset{}, 1000)
+				require.NoError(t, err)
+				testutil.RequireEqualProto(t, &asset.Asset{
+					Digest:      blobDigest,
+					Type:        asset.Asset_BLOB,
+					LastUpdated: &timestamppb.Timestamp{Seconds: 1600000000},
+					ExpireAt:    &timestamppb.Timestamp{Seconds: 1700000000},
+				}, m)
+				keys = append(keys, digest)
+				return nil
+			}).Times(2)
+
+		_, err := s.PushBlob(ctx, &remoteasset.PushBlobRequest{
+			InstanceName: "example",
+			Uris: []string{
+				"https://example.com/foo.tar.gz",
+				"https://mirror.example.com/foo.tar.gz",
+			},
+			Qualifiers: []*remoteasset.Qualifier{
+				{Name: "resource_type", Value: "application/x-tar"},
+				{Name: "checksum.sri", Value: "sha256-abc"},
+			},
+			ExpireAt:   &timestamppb.Timestamp{Seconds: 1700000000},
+			BlobDigest: blobDigest,
+		})
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		require.NotEqual(t, keys[0], keys[1])
+		require.Equal(t, digest.MustNewInstanceName("example"), keys[0].GetInstanceName())
+
+		// The order of qualifiers should not affect the key
+		// under which the asset is stored.
+		clock.EXPECT().Now().Return(time.Unix(1600000001, 0))
+		assetStore.EXPECT().Put(ctx, keys[0], gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				m, err := b.ToProto(&asset.Asset{}, 1000)
+				require.NoError(t, err)
+				testutil.RequireEqualProto(t, &asset.Asset{
+					Digest:      blobDigest,
+					Type:        asset.Asset_DIRECTORY,
+					LastUpdated: &timestamppb.Timestamp{Seconds: 1600000001},
+				}, m)
+				return nil
+			})
+
+		_, err = s.PushDirectory(ctx, &remoteasset.PushDirectoryRequest{
+			InstanceName: "example",
+			Uris:         []string{"https://example.com/foo.tar.gz"},
+			Qualifiers: []*remoteasset.Qualifier{
+				{Name: "checksum.sri", Value: "sha256-abc"},
+				{Name: "resource_type", Value: "application/x-tar"},
+			},
+			RootDirectoryDigest: blobDigest,
+		})
+		require.NoError(t, err)
+	})
+}
@@ -3,28 +3,50 @@ package grpcservers
 import (
 	"context"
 	"io"
+	"io/ioutil"
 
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/buildbarn/bb-storage/pkg/zstd"
 
 	"google.golang.org/genproto/googleapis/bytestream"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// byteStreamZstdMaximumWindowSizeBytes is the maximum window size of
+// Zstandard compressed data that is accepted by Write(). This bounds
+// the amount of memory needed to decompress a single upload.
+const byteStreamZstdMaximumWindowSizeBytes = 8 << 20
+
 type byteStreamServer struct {
 	blobAccess    blobstore.BlobAccess
 	readChunkSize int
+	uploadStore   UploadStore
 }
 
 // NewByteStreamServer creates a GRPC service for reading blobs from and
 // writing blobs to a BlobAccess. It is used by Bazel to access the
 // Content Addressable Storage (CAS).
-func NewByteStreamServer(blobAccess blobstore.BlobAccess, readChunkSize int) bytestream.ByteStreamServer {
+//
+// In addition to transferring data in literal form, this service
+// supports the "compressed-blobs" resource names of REv2, permitting
+// data to be transferred using Zstandard compression.
+//
+// If an UploadStore is provided, uncompressed uploads are persisted in
+// it until completion. This permits clients to resume interrupted
+// uploads, and to query their progress through QueryWriteStatus().
+// Compressed uploads cannot be resumed, as the REv2 specification
+// requires the write offset of the first request of an upload to refer
+// to the uncompressed data.
+func NewByteStreamServer(blobAccess blobstore.BlobAccess, readChunkSize int, uploadStore UploadStore) bytestream.ByteStreamServer {
 	return &byteStreamServer{
 		blobAccess:    blobAccess,
 		readChunkSize: readChunkSize,
+		uploadStore:   uploadStore,
 	}
 }
 
@@ -32,10 +54,23 @@ func (s *byteStreamServer) Read(in *bytestream.ReadRequest, out bytestream.ByteS
 	if in.ReadLimit != 0 {
 		return status.Error(codes.Unimplemented, "This service does not support downloading partial files")
 	}
-	digest, err := digest.NewDigestFromByteStreamReadPath(in.ResourceName)
+	digest, compressor, err := digest.NewDigestAndCompressorFromByteStreamReadPath(in.ResourceName)
 	if err != nil {
 		return err
 	}
+	var w io.WriteCloser
+	switch compressor {
+	case remoteexecution.Compressor_IDENTITY:
+	case remoteexecution.Compressor_ZSTD:
+		// The read offset refers to the uncompressed data,
+		// meaning that compression starts at the offset.
+		w = zstd.NewWriter(&byteStreamReadServerWriter{
+			out:       out,
+			chunkSize: s.readChunkSize,
+		})
+	default:
+		return status.Errorf(codes.Unimplemented, "This service does not support compressor %s", compressor)
+	}
 
 	r := s.blobAccess.Get(out.Context(), digest).ToChunkReader(in.ReadOffset, s.readChunkSize)
 	defer r.Close()
@@ -43,17 +78,49 @@ func (s *byteStreamServer) Read(in *bytestream.ReadRequest, out bytestream.ByteS
 	for {
 		readBuf, readErr := r.Read()
 		if readErr == io.EOF {
+			if w != nil {
+				return w.Close()
+			}
 			return nil
 		}
 		if readErr != nil {
 			return readErr
 		}
-		if writeErr := out.Send(&bytestream.ReadResponse{Data: readBuf}); writeErr != nil {
+		if w != nil {
+			if _, writeErr := w.Write(readBuf); writeErr != nil {
+				return writeErr
+			}
+		} else if writeErr := out.Send(&bytestream.ReadResponse{Data: readBuf}); writeErr != nil {
 			return writeErr
 		}
 	}
 }
 
+// byteStreamReadServerWriter is an io.Writer that sends all data
+// written to it as ReadResponse messages. It is used to send data that
+// is compressed, whose size is not related to the chunks returned by
+// the ChunkReader.
+type byteStreamReadServerWriter struct {
+	out       bytestream.ByteStream_ReadServer
+	chunkSize int
+}
+
+func (w *byteStreamReadServerWriter) Write(p []byte) (int, error) {
+	nTotal := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > w.chunkSize {
+			n = w.chunkSize
+		}
+		if err := w.out.Send(&bytestream.ReadResponse{Data: p[:n]}); err != nil {
+			return nTotal, err
+		}
+		nTotal += n
+		p = p[n:]
+	}
+	return nTotal, nil
+}
+
 type byteStreamWriteServerChunkReader struct {
 	stream        bytestream.ByteStream_WriteServer
 	writeOffset   int64
@@ -97,30 +164,170 @@ func (r *byteStreamWriteServerChunkReader) Read() ([]byte, error) {
 
 func (r *byteStreamWriteServerChunkReader) Close() {}
 
+// byteStreamWriteServerReader is an io.Reader that returns data
+// contained in WriteRequest messages. It is used to provide
+// compressed data to the decompressor.
+type byteStreamWriteServerReader struct {
+	r    *byteStreamWriteServerChunkReader
+	data []byte
+}
+
+func (r *byteStreamWriteServerReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		data, err := r.r.Read()
+		if err != nil {
+			return 0, err
+		}
+		r.data = data
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
 func (s *byteStreamServer) Write(stream bytestream.ByteStream_WriteServer) error {
 	request, err := stream.Recv()
 	if err != nil {
 		return err
 	}
-	digest, err := digest.NewDigestFromByteStreamWritePath(request.ResourceName)
+	digest, compressor, err := digest.NewDigestAndCompressorFromByteStreamWritePath(request.ResourceName)
 	if err != nil {
 		return err
 	}
+	if compressor == remoteexecution.Compressor_IDENTITY && s.uploadStore != nil {
+		return s.writeResumable(stream, request, digest)
+	}
 	r := &byteStreamWriteServerChunkReader{stream: stream}
 	if err := r.setRequest(request); err != nil {
 		return err
 	}
+
+	var b buffer.Buffer
+	switch compressor {
+	case remoteexecution.Compressor_IDENTITY:
+		b = buffer.NewCASBufferFromChunkReader(digest, r, buffer.UserProvided)
+	case remoteexecution.Compressor_ZSTD:
+		b = buffer.NewCASBufferFromReader(
+			digest,
+			ioutil.NopCloser(zstd.NewReader(
+				&byteStreamWriteServerReader{r: r},
+				byteStreamZstdMaximumWindowSizeBytes)),
+			buffer.UserProvided)
+	default:
+		return status.Errorf(codes.Unimplemented, "This service does not support compressor %s", compressor)
+	}
+	if err := s.blobAccess.Put(stream.Context(), digest, b); err != nil {
+		return err
+	}
+
+	// Write offsets of compressed uploads refer to the compressed
+	// data, meaning the committed size needs to do so as well.
+	committedSize := digest.GetSizeBytes()
+	if compressor != remoteexecution.Compressor_IDENTITY {
+		committedSize = r.writeOffset
+	}
+	return stream.SendAndClose(&bytestream.WriteResponse{
+		CommittedSize: committedSize,
+	})
+}
+
+// writeResumable processes an uncompressed upload by appending all
+// data to the UploadStore. Only once the client finishes the upload,
+// it is written into the BlobAccess. If the client disconnects, the
+// data received so far is retained, so that the client may resume the
+// upload by providing a nonzero write offset.
+func (s *byteStreamServer) writeResumable(stream bytestream.ByteStream_WriteServer, request *bytestream.WriteRequest, blobDigest digest.Digest) error {
+	upload, err := s.uploadStore.OpenUpload(request.ResourceName)
+	if err != nil {
+		return util.StatusWrap(err, "Failed to open upload")
+	}
+	for {
+		if committedSize := upload.GetCommittedSize(); request.WriteOffset != committedSize {
+			upload.Close()
+			return status.Errorf(codes.InvalidArgument, "Attempted to write at offset %d, while %d was expected", request.WriteOffset, committedSize)
+		} else if sizeBytes := blobDigest.GetSizeBytes(); int64(len(request.Data)) > sizeBytes-committedSize {
+			upload.Close()
+			return status.Errorf(codes.InvalidArgument, "Attempted to write %d bytes at offset %d, which exceeds the size of the blob of %d bytes", len(request.Data), committedSize, sizeBytes)
+		}
+		if err := upload.Append(request.Data); err != nil {
+			upload.Close()
+			return util.StatusWrap(err, "Failed to append to upload")
+		}
+		if request.FinishWrite {
+			break
+		}
+		request, err = stream.Recv()
+		if err != nil {
+			upload.Close()
+			if err == io.EOF {
+				return status.Error(codes.InvalidArgument, "Client closed stream without finishing write")
+			}
+			return err
+		}
+	}
+
 	if err := s.blobAccess.Put(
 		stream.Context(),
-		digest,
-		buffer.NewCASBufferFromChunkReader(digest, r, buffer.UserProvided)); err != nil {
+		blobDigest,
+		buffer.NewCASBufferFromReader(blobDigest, ioutil.NopCloser(upload.NewReader()), buffer.UserProvided),
+	); err != nil {
+		// Data that doesn't match the digest can never be
+		// completed successfully, so discard it. Other errors
+		// may be transient, so keep the data around to permit
+		// the client to finish the upload later on.
+		if status.Code(err) == codes.InvalidArgument {
+			upload.Remove()
+		} else {
+			upload.Close()
+		}
 		return err
 	}
+	if err := upload.Remove(); err != nil {
+		return util.StatusWrap(err, "Failed to remove completed upload")
+	}
 	return stream.SendAndClose(&bytestream.WriteResponse{
-		CommittedSize: digest.GetSizeBytes(),
+		CommittedSize: blobDigest.GetSizeBytes(),
 	})
 }
 
 func (s *byteStreamServer) QueryWriteStatus(ctx context.Context, in *bytestream.QueryWriteStatusRequest) (*bytestream.QueryWriteStatusResponse, error) {
-	return nil, status.Error(codes.Unimplemented, "This service does not support querying write status")
+	if s.uploadStore == nil {
+		return nil, status.Error(codes.Unimplemented, "This service does not support querying write status")
+	}
+	blobDigest, compressor, err := digest.NewDigestAndCompressorFromByteStreamWritePath(in.ResourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Uploads are removed from the UploadStore upon completion, so
+	// check whether the blob is present in storage first.
+	missing, err := s.blobAccess.FindMissing(ctx, blobDigest.ToSingletonSet())
+	if err != nil {
+		return nil, err
+	}
+	if missing.Empty() {
+		// The size of the compressed data is not known, as the
+		// blob may have been uploaded in a different form.
+		committedSize := int64(-1)
+		if compressor == remoteexecution.Compressor_IDENTITY {
+			committedSize = blobDigest.GetSizeBytes()
+		}
+		return &bytestream.QueryWriteStatusResponse{
+			CommittedSize: committedSize,
+			Complete:      true,
+		}, nil
+	}
+
+	// Compressed uploads are never persisted, meaning they always
+	// need to be restarted from the beginning.
+	var committedSize int64
+	if compressor == remoteexecution.Compressor_IDENTITY {
+		committedSize, err = s.uploadStore.GetCommittedSize(in.ResourceName)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to obtain committed size of upload")
+		}
+	}
+	return &bytestream.QueryWriteStatusResponse{
+		CommittedSize: committedSize,
+	}, nil
 }
@@ -1,8 +1,10 @@
 package grpcservers_test
 
 import (
+	"bytes"
 	"context"
 	"io"
+	"io/ioutil"
 	"net"
 	"testing"
 	"time"
@@ -10,8 +12,11 @@ import (
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/grpcservers"
+	"github.com/buildbarn/bb-storage/pkg/clock"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
 	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/buildbarn/bb-storage/pkg/zstd"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 
@@ -29,7 +34,7 @@ func TestByteStreamServer(t *testing.T) {
 	l := bufconn.Listen(1 << 20)
 	server := grpc.NewServer()
 	blobAccess := mock.NewMockBlobAccess(ctrl)
-	bytestream.RegisterByteStreamServer(server, grpcservers.NewByteStreamServer(blobAccess, 10))
+	bytestream.RegisterByteStreamServer(server, grpcservers.NewByteStreamServer(blobAccess, 10, nil))
 	go func() {
 		require.NoError(t, server.Serve(l))
 	}()
@@ -193,6 +198,43 @@ func TestByteStreamServer(t *testing.T) {
 		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "Blob not found"), err)
 	})
 
+	t.Run("ReadSuccessZstd", func(t *testing.T) {
+		// Attempt to fetch a blob in compressed form, starting
+		// at an offset in the uncompressed data.
+		blobAccess.EXPECT().Get(
+			gomock.Any(),
+			digest.MustNewDigest("ubuntu1804", "da39a3ee5e6b4b0d3255bfef95601890", 19),
+		).Return(buffer.NewValidatedBufferFromByteSlice([]byte("This offset message")))
+
+		req, err := client.Read(ctx, &bytestream.ReadRequest{
+			ResourceName: "ubuntu1804/compressed-blobs/zstd/da39a3ee5e6b4b0d3255bfef95601890/19",
+			ReadOffset:   4,
+		})
+		require.NoError(t, err)
+		var compressed []byte
+		for {
+			readResponse, err := req.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(readResponse.Data), 10)
+			compressed = append(compressed, readResponse.Data...)
+		}
+		data, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(compressed), 1<<20))
+		require.NoError(t, err)
+		require.Equal(t, []byte(" offset message"), data)
+	})
+
+	t.Run("ReadUnsupportedCompressor", func(t *testing.T) {
+		req, err := client.Read(ctx, &bytestream.ReadRequest{
+			ResourceName: "ubuntu1804/compressed-blobs/lz4/da39a3ee5e6b4b0d3255bfef95601890/19",
+		})
+		require.NoError(t, err)
+		_, err = req.Recv()
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Unsupported compressor \"lz4\""), err)
+	})
+
 	t.Run("WriteBadResourceName", func(t *testing.T) {
 		// Attempt to write to a bad resource name.
 		stream, err := client.Write(ctx)
@@ -311,6 +353,67 @@ func TestByteStreamServer(t *testing.T) {
 		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Attempted to write at offset 4, while 5 was expected"), err)
 	})
 
+	t.Run("WriteSuccessZstd", func(t *testing.T) {
+		// Attempt to write a blob in compressed form. Write
+		// offsets and the committed size refer to the
+		// compressed data.
+		var compressed bytes.Buffer
+		w := zstd.NewWriter(&compressed)
+		_, err := w.Write([]byte("LaputanMachine"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		blobAccess.EXPECT().Put(
+			gomock.Any(),
+			digest.MustNewDigest("", "581c1053f832a1c719fb6528a588ccfd", 14),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			data, err := b.ToByteSlice(100)
+			require.NoError(t, err)
+			require.Equal(t, []byte("LaputanMachine"), data)
+			return nil
+		})
+
+		stream, err := client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: "uploads/7de747e0-ab6b-4d83-90cb-11989f84c473/compressed-blobs/zstd/581c1053f832a1c719fb6528a588ccfd/14",
+			Data:         compressed.Bytes()[:10],
+		}))
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			Data:        compressed.Bytes()[10:],
+			WriteOffset: 10,
+			FinishWrite: true,
+		}))
+		response, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		require.Equal(t, int64(compressed.Len()), response.CommittedSize)
+	})
+
+	t.Run("WriteFailZstdTruncated", func(t *testing.T) {
+		// Compressed data that ends prematurely should be
+		// rejected.
+		blobAccess.EXPECT().Put(
+			gomock.Any(),
+			digest.MustNewDigest("", "581c1053f832a1c719fb6528a588ccfd", 14),
+			gomock.Any(),
+		).DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			_, err := b.ToByteSlice(100)
+			testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Zstandard stream is truncated"), err)
+			return err
+		})
+
+		stream, err := client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: "uploads/7de747e0-ab6b-4d83-90cb-11989f84c473/compressed-blobs/zstd/581c1053f832a1c719fb6528a588ccfd/14",
+			Data:         []byte{0x28, 0xb5, 0x2f, 0xfd, 0x04},
+			FinishWrite:  true,
+		}))
+		_, err = stream.CloseAndRecv()
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Zstandard stream is truncated"), err)
+	})
+
 	t.Run("QueryWriteStatus", func(t *testing.T) {
 		_, err := client.QueryWriteStatus(ctx, &bytestream.QueryWriteStatusRequest{
 			ResourceName: "windows10/uploads/d834d9c2-f3c9-4f30-a698-75fd4be9470d/blobs/68e109f0f40ca72a15e05cc22786f8e6/10",
@@ -318,3 +421,166 @@ func TestByteStreamServer(t *testing.T) {
 		testutil.RequireEqualStatus(t, status.Error(codes.Unimplemented, "This service does not support querying write status"), err)
 	})
 }
+
+func TestByteStreamServerResumableUploads(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	// Create an RPC server/client pair, where the server persists
+	// uploads in a temporary directory.
+	directory, err := filesystem.NewLocalDirectory(t.TempDir())
+	require.NoError(t, err)
+	defer directory.Close()
+
+	l := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	blobAccess := mock.NewMockBlobAccess(ctrl)
+	uploadStore := grpcservers.NewDirectoryUploadStore(directory, clock.SystemClock, time.Hour)
+	bytestream.RegisterByteStreamServer(server, grpcservers.NewByteStreamServer(blobAccess, 10, uploadStore))
+	go func() {
+		require.NoError(t, server.Serve(l))
+	}()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+		return l.Dial()
+	}), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer server.Stop()
+	defer conn.Close()
+	client := bytestream.NewByteStreamClient(conn)
+
+	t.Run("QueryWriteStatusUnknown", func(t *testing.T) {
+		// Uploads that have never been started should be
+		// reported as having no data.
+		blobDigest := digest.MustNewDigest("debian8", "bc6e6f16b8a077ef5fbc8d59d0b931b9", 12)
+		blobAccess.EXPECT().FindMissing(gomock.Any(), blobDigest.ToSingletonSet()).
+			Return(blobDigest.ToSingletonSet(), nil)
+
+		response, err := client.QueryWriteStatus(ctx, &bytestream.QueryWriteStatusRequest{
+			ResourceName: "debian8/uploads/4d5d3e4e-a09b-4e2c-b0d3-4e9ba6cc5e6f/blobs/bc6e6f16b8a077ef5fbc8d59d0b931b9/12",
+		})
+		require.NoError(t, err)
+		testutil.RequireEqualProto(t, &bytestream.QueryWriteStatusResponse{}, response)
+	})
+
+	t.Run("ResumeAfterDisconnect", func(t *testing.T) {
+		resourceName := "debian8/uploads/0b1e0a1a-7c7a-4a44-a4fa-fd4ca1e2b12c/blobs/bc6e6f16b8a077ef5fbc8d59d0b931b9/12"
+		blobDigest := digest.MustNewDigest("debian8", "bc6e6f16b8a077ef5fbc8d59d0b931b9", 12)
+
+		// Start an upload, but close the stream before
+		// finishing it.
+		stream, err := client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: resourceName,
+			Data:         []byte("Hello"),
+		}))
+		_, err = stream.CloseAndRecv()
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Client closed stream without finishing write"), err)
+
+		// The data written so far should have been retained.
+		blobAccess.EXPECT().FindMissing(gomock.Any(), blobDigest.ToSingletonSet()).
+			Return(blobDigest.ToSingletonSet(), nil)
+		response, err := client.QueryWriteStatus(ctx, &bytestream.QueryWriteStatusRequest{
+			ResourceName: resourceName,
+		})
+		require.NoError(t, err)
+		testutil.RequireEqualProto(t, &bytestream.QueryWriteStatusResponse{
+			CommittedSize: 5,
+		}, response)
+
+		// Writing at the wrong offset should not be permitted.
+		stream, err = client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: resourceName,
+			WriteOffset:  3,
+			Data:         []byte("lo, world"),
+			FinishWrite:  true,
+		}))
+		_, err = stream.CloseAndRecv()
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Attempted to write at offset 3, while 5 was expected"), err)
+
+		// Resuming the upload at the committed size should
+		// cause the full blob to be written into storage.
+		blobAccess.EXPECT().Put(gomock.Any(), blobDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello, world"), data)
+				return nil
+			})
+
+		stream, err = client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: resourceName,
+			WriteOffset:  5,
+			Data:         []byte(", world"),
+			FinishWrite:  true,
+		}))
+		writeResponse, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		require.Equal(t, int64(12), writeResponse.CommittedSize)
+
+		// Once completed, the upload should be reported as such.
+		blobAccess.EXPECT().FindMissing(gomock.Any(), blobDigest.ToSingletonSet()).
+			Return(digest.EmptySet, nil)
+		response, err = client.QueryWriteStatus(ctx, &bytestream.QueryWriteStatusRequest{
+			ResourceName: resourceName,
+		})
+		require.NoError(t, err)
+		testutil.RequireEqualProto(t, &bytestream.QueryWriteStatusResponse{
+			CommittedSize: 12,
+			Complete:      true,
+		}, response)
+		require.NoError(t, uploadStore.RemoveExpiredUploads())
+		committedSize, err := uploadStore.GetCommittedSize(resourceName)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), committedSize)
+	})
+
+	t.Run("WriteBeyondSize", func(t *testing.T) {
+		// Writes that exceed the size of the blob should be
+		// rejected before the data is stored.
+		resourceName := "debian8/uploads/3f6a1d0c-2b7e-4c5e-9d1a-6e8f0b2c4d7a/blobs/bc6e6f16b8a077ef5fbc8d59d0b931b9/12"
+
+		stream, err := client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: resourceName,
+			Data:         []byte("Hello, world!"),
+			FinishWrite:  true,
+		}))
+		_, err = stream.CloseAndRecv()
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Attempted to write 13 bytes at offset 0, which exceeds the size of the blob of 12 bytes"), err)
+
+		committedSize, err := uploadStore.GetCommittedSize(resourceName)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), committedSize)
+	})
+
+	t.Run("DigestMismatch", func(t *testing.T) {
+		// Uploads whose data does not match the digest can
+		// never be completed. Their data should be discarded.
+		resourceName := "debian8/uploads/8c3a1b5e-8f4c-4a8e-9b0f-2d2c4a0c9c6e/blobs/6cd3556deb0da54bca060b4c39479839/13"
+		blobDigest := digest.MustNewDigest("debian8", "6cd3556deb0da54bca060b4c39479839", 13)
+		blobAccess.EXPECT().Put(gomock.Any(), blobDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				_, err := b.ToByteSlice(100)
+				return err
+			})
+
+		stream, err := client.Write(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&bytestream.WriteRequest{
+			ResourceName: resourceName,
+			Data:         []byte("Hello, world?"),
+			FinishWrite:  true,
+		}))
+		_, err = stream.CloseAndRecv()
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Buffer has checksum b35b9b4b6114ee258f063e61a53d178b, while 6cd3556deb0da54bca060b4c39479839 was expected"), err)
+
+		committedSize, err := uploadStore.GetCommittedSize(resourceName)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), committedSize)
+	})
+}
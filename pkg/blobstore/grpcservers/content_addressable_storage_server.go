@@ -2,6 +2,8 @@ package grpcservers
 
 import (
 	"context"
+	"strconv"
+	"sync"
 
 	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
@@ -11,19 +13,33 @@ import (
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 type contentAddressableStorageServer struct {
 	contentAddressableStorage blobstore.BlobAccess
 	maximumMessageSizeBytes   int64
+	getTreeConcurrency        int
+	treeCache                 *TreeCache
 }
 
 // NewContentAddressableStorageServer creates a GRPC service for serving
 // the contents of a Bazel Content Addressable Storage (CAS) to Bazel.
-func NewContentAddressableStorageServer(contentAddressableStorage blobstore.BlobAccess, maximumMessageSizeBytes int64) remoteexecution.ContentAddressableStorageServer {
+//
+// GetTree() loads Directory objects using up to getTreeConcurrency
+// concurrent requests. Flattened directory hierarchies are stored in
+// the provided TreeCache, so that subsequent requests for the same
+// tree (e.g., to obtain additional pages) can be served without
+// traversing it once more. The TreeCache may be nil.
+func NewContentAddressableStorageServer(contentAddressableStorage blobstore.BlobAccess, maximumMessageSizeBytes int64, getTreeConcurrency int, treeCache *TreeCache) remoteexecution.ContentAddressableStorageServer {
+	if getTreeConcurrency < 1 {
+		getTreeConcurrency = 1
+	}
 	return &contentAddressableStorageServer{
 		contentAddressableStorage: contentAddressableStorage,
 		maximumMessageSizeBytes:   maximumMessageSizeBytes,
+		getTreeConcurrency:        getTreeConcurrency,
+		treeCache:                 treeCache,
 	}
 }
 
@@ -120,5 +136,155 @@ func (s *contentAddressableStorageServer) BatchUpdateBlobs(ctx context.Context,
 }
 
 func (s *contentAddressableStorageServer) GetTree(in *remoteexecution.GetTreeRequest, stream remoteexecution.ContentAddressableStorage_GetTreeServer) error {
-	return status.Error(codes.Unimplemented, "This service does not support downloading directory trees")
+	instanceName, err := digest.NewInstanceName(in.InstanceName)
+	if err != nil {
+		return util.StatusWrapf(err, "Invalid instance name %#v", in.InstanceName)
+	}
+	rootDigest, err := instanceName.NewDigestFromProto(in.RootDigest)
+	if err != nil {
+		return util.StatusWrap(err, "Invalid root digest")
+	}
+	if in.PageSize < 0 {
+		return status.Errorf(codes.InvalidArgument, "Invalid page size %d", in.PageSize)
+	}
+	offset := 0
+	if in.PageToken != "" {
+		offset, err = strconv.Atoi(in.PageToken)
+		if err != nil || offset < 0 {
+			return status.Errorf(codes.InvalidArgument, "Invalid page token %#v", in.PageToken)
+		}
+	}
+
+	// Obtain the flattened directory hierarchy, either from the
+	// cache or by traversing it.
+	var directories []*remoteexecution.Directory
+	ok := false
+	if s.treeCache != nil {
+		var directoryDigests digest.Set
+		directories, directoryDigests, ok = s.treeCache.get(rootDigest)
+		if ok {
+			// Directory objects may have been removed from
+			// the Content Addressable Storage since the
+			// hierarchy was cached. Only use the cached
+			// hierarchy if all of them are still present.
+			missing, err := s.contentAddressableStorage.FindMissing(stream.Context(), directoryDigests)
+			if err != nil {
+				return util.StatusWrap(err, "Failed to check for the existence of cached directories")
+			}
+			if !missing.Empty() {
+				s.treeCache.invalidate(rootDigest)
+				ok = false
+			}
+		}
+	}
+	if !ok {
+		var directoryDigests digest.Set
+		var complete bool
+		directories, directoryDigests, complete, err = s.getDirectories(stream.Context(), rootDigest)
+		if err != nil {
+			return err
+		}
+		if complete && s.treeCache != nil {
+			s.treeCache.put(rootDigest, directories, directoryDigests)
+		}
+	}
+	if offset > len(directories) {
+		return status.Errorf(codes.InvalidArgument, "Page token %#v exceeds the number of directories in the tree", in.PageToken)
+	}
+
+	// Return the remaining directories, splitting them up into pages
+	// that respect the requested page size and the maximum message
+	// size.
+	for {
+		var response remoteexecution.GetTreeResponse
+		responseSizeBytes := int64(0)
+		for offset < len(directories) && (in.PageSize == 0 || len(response.Directories) < int(in.PageSize)) {
+			// Account for the overhead of embedding the
+			// Directory in the response message.
+			directorySizeBytes := int64(proto.Size(directories[offset])) + 16
+			if len(response.Directories) > 0 && responseSizeBytes+directorySizeBytes > s.maximumMessageSizeBytes {
+				break
+			}
+			response.Directories = append(response.Directories, directories[offset])
+			responseSizeBytes += directorySizeBytes
+			offset++
+		}
+		if offset < len(directories) {
+			response.NextPageToken = strconv.Itoa(offset)
+		}
+		if err := stream.Send(&response); err != nil {
+			return err
+		}
+		if offset >= len(directories) {
+			return nil
+		}
+	}
+}
+
+// getDirectories traverses a directory hierarchy in breadth-first
+// order, returning every Directory contained in it once. Directories
+// at the same level of the hierarchy are loaded concurrently.
+//
+// The digests of the directories that are returned are provided as
+// well. As permitted by the REv2 specification, parts of the hierarchy
+// that are not present in the Content Addressable Storage are omitted.
+// In that case false is returned, indicating that the results should
+// not be cached.
+func (s *contentAddressableStorageServer) getDirectories(ctx context.Context, rootDigest digest.Digest) ([]*remoteexecution.Directory, digest.Set, bool, error) {
+	instanceName := rootDigest.GetInstanceName()
+	var directories []*remoteexecution.Directory
+	directoryDigests := digest.NewSetBuilder()
+	complete := true
+	seen := map[digest.Digest]struct{}{rootDigest: {}}
+	level := []digest.Digest{rootDigest}
+	for len(level) > 0 {
+		levelDirectories := make([]*remoteexecution.Directory, len(level))
+		levelErrors := make([]error, len(level))
+		semaphore := make(chan struct{}, s.getTreeConcurrency)
+		var wg sync.WaitGroup
+		for i, directoryDigest := range level {
+			semaphore <- struct{}{}
+			wg.Add(1)
+			go func(i int, directoryDigest digest.Digest) {
+				defer func() {
+					<-semaphore
+					wg.Done()
+				}()
+				directory, err := s.contentAddressableStorage.Get(ctx, directoryDigest).ToProto(
+					&remoteexecution.Directory{},
+					int(s.maximumMessageSizeBytes))
+				if err != nil {
+					levelErrors[i] = util.StatusWrapf(err, "Failed to obtain directory %#v", directoryDigest.String())
+					return
+				}
+				levelDirectories[i] = directory.(*remoteexecution.Directory)
+			}(i, directoryDigest)
+		}
+		wg.Wait()
+
+		var nextLevel []digest.Digest
+		for i, directory := range levelDirectories {
+			if err := levelErrors[i]; err != nil {
+				if status.Code(err) != codes.NotFound || level[i] == rootDigest {
+					return nil, digest.EmptySet, false, err
+				}
+				complete = false
+				continue
+			}
+			directories = append(directories, directory)
+			directoryDigests.Add(level[i])
+			for _, child := range directory.Directories {
+				childDigest, err := instanceName.NewDigestFromProto(child.Digest)
+				if err != nil {
+					return nil, digest.EmptySet, false, util.StatusWrapf(err, "Failed to extract digest for directory %#v", child.Name)
+				}
+				if _, ok := seen[childDigest]; !ok {
+					seen[childDigest] = struct{}{}
+					nextLevel = append(nextLevel, childDigest)
+				}
+			}
+		}
+		level = nextLevel
+	}
+	return directories, directoryDigests.Build(), complete, nil
 }
@@ -9,6 +9,8 @@ import (
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/grpcservers"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 
@@ -53,7 +55,7 @@ func TestContentAddressableStorageServerBatchReadBlobsSuccess(t *testing.T) {
 	buf3 := buffer.NewBufferFromError(status.Error(codes.NotFound, "The object you requested could not be found"))
 	contentAddressableStorage.EXPECT().Get(ctx, digest3).Return(buf3)
 
-	contentAddressableStorageServer := grpcservers.NewContentAddressableStorageServer(contentAddressableStorage, 1<<16)
+	contentAddressableStorageServer := grpcservers.NewContentAddressableStorageServer(contentAddressableStorage, 1<<16, 1, nil)
 
 	response, err := contentAddressableStorageServer.BatchReadBlobs(ctx, request)
 	require.NoError(t, err)
@@ -106,10 +108,233 @@ func TestContentAddressableStorageServerBatchReadBlobsFailure(t *testing.T) {
 
 	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
 
-	contentAddressableStorageServer := grpcservers.NewContentAddressableStorageServer(contentAddressableStorage, 200)
+	contentAddressableStorageServer := grpcservers.NewContentAddressableStorageServer(contentAddressableStorage, 200, 1, nil)
 
 	_, err := contentAddressableStorageServer.BatchReadBlobs(ctx, request)
 	require.Equal(t, status.Error(codes.InvalidArgument,
 		"Attempted to read a total of at least 357 bytes, while a maximum of 200 bytes is permitted"),
 		err)
 }
+
+// getTreeServer is a trivial implementation of
+// ContentAddressableStorage_GetTreeServer that captures all responses.
+type getTreeServer struct {
+	remoteexecution.ContentAddressableStorage_GetTreeServer
+	ctx       context.Context
+	responses []*remoteexecution.GetTreeResponse
+}
+
+func (s *getTreeServer) Context() context.Context {
+	return s.ctx
+}
+
+func (s *getTreeServer) Send(response *remoteexecution.GetTreeResponse) error {
+	s.responses = append(s.responses, response)
+	return nil
+}
+
+func TestContentAddressableStorageServerGetTree(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	contentAddressableStorageServer := grpcservers.NewContentAddressableStorageServer(
+		contentAddressableStorage,
+		1<<16,
+		2,
+		grpcservers.NewTreeCache(10, 1<<20, eviction.NewLRUSet()))
+
+	// A directory hierarchy where a single directory is referenced
+	// twice. It should only be returned once.
+	rootDigest := digest.MustNewDigest("hello", "d87bbaa4d7ee93ef5db3c3a6dda4dcf2", 100)
+	childDigest1 := digest.MustNewDigest("hello", "67e2ceb6a8a8b3fc0ebe12bb2eb93c21", 100)
+	childDigest2 := digest.MustNewDigest("hello", "0fa5cd9b7d7e6e4b3c2cbd3c50bd8b2c", 100)
+	grandchildDigest := digest.MustNewDigest("hello", "6c3b5a9cb3ef7d43f3e63c8a8c2ed4d5", 100)
+	rootDirectory := &remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "a", Digest: childDigest1.GetProto()},
+			{Name: "b", Digest: childDigest2.GetProto()},
+		},
+	}
+	childDirectory1 := &remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "c", Digest: grandchildDigest.GetProto()},
+		},
+	}
+	childDirectory2 := &remoteexecution.Directory{
+		Directories: []*remoteexecution.DirectoryNode{
+			{Name: "c", Digest: grandchildDigest.GetProto()},
+		},
+		Files: []*remoteexecution.FileNode{
+			{Name: "file", Digest: grandchildDigest.GetProto()},
+		},
+	}
+	grandchildDirectory := &remoteexecution.Directory{}
+
+	t.Run("InvalidPageToken", func(t *testing.T) {
+		err := contentAddressableStorageServer.GetTree(&remoteexecution.GetTreeRequest{
+			InstanceName: "hello",
+			RootDigest:   rootDigest.GetProto(),
+			PageToken:    "foo",
+		}, &getTreeServer{ctx: ctx})
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid page token \"foo\""), err)
+	})
+
+	t.Run("RootNotFound", func(t *testing.T) {
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), rootDigest).Return(
+			buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		err := contentAddressableStorageServer.GetTree(&remoteexecution.GetTreeRequest{
+			InstanceName: "hello",
+			RootDigest:   rootDigest.GetProto(),
+		}, &getTreeServer{ctx: ctx})
+		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "Failed to obtain directory \"d87bbaa4d7ee93ef5db3c3a6dda4dcf2-100-hello\": Object not found"), err)
+	})
+
+	t.Run("ChildNotFound", func(t *testing.T) {
+		// Missing parts of the hierarchy should be omitted. The
+		// results should not be cached.
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), rootDigest).Return(
+			buffer.NewProtoBufferFromProto(rootDirectory, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), childDigest1).Return(
+			buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), childDigest2).Return(
+			buffer.NewProtoBufferFromProto(childDirectory2, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), grandchildDigest).Return(
+			buffer.NewProtoBufferFromProto(grandchildDirectory, buffer.UserProvided))
+
+		stream := getTreeServer{ctx: ctx}
+		require.NoError(t, contentAddressableStorageServer.GetTree(&remoteexecution.GetTreeRequest{
+			InstanceName: "hello",
+			RootDigest:   rootDigest.GetProto(),
+		}, &stream))
+		require.Len(t, stream.responses, 1)
+		testutil.RequireEqualProto(t, &remoteexecution.GetTreeResponse{
+			Directories: []*remoteexecution.Directory{
+				rootDirectory,
+				childDirectory2,
+				grandchildDirectory,
+			},
+		}, stream.responses[0])
+	})
+
+	t.Run("Paginated", func(t *testing.T) {
+		// The tree should be traversed only once. Subsequent
+		// requests should be served from the cache.
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), rootDigest).Return(
+			buffer.NewProtoBufferFromProto(rootDirectory, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), childDigest1).Return(
+			buffer.NewProtoBufferFromProto(childDirectory1, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), childDigest2).Return(
+			buffer.NewProtoBufferFromProto(childDirectory2, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), grandchildDigest).Return(
+			buffer.NewProtoBufferFromProto(grandchildDirectory, buffer.UserProvided))
+
+		stream := getTreeServer{ctx: ctx}
+		require.NoError(t, contentAddressableStorageServer.GetTree(&remoteexecution.GetTreeRequest{
+			InstanceName: "hello",
+			RootDigest:   rootDigest.GetProto(),
+			PageSize:     3,
+		}, &stream))
+		require.Len(t, stream.responses, 2)
+		testutil.RequireEqualProto(t, &remoteexecution.GetTreeResponse{
+			Directories: []*remoteexecution.Directory{
+				rootDirectory,
+				childDirectory1,
+				childDirectory2,
+			},
+			NextPageToken: "3",
+		}, stream.responses[0])
+		testutil.RequireEqualProto(t, &remoteexecution.GetTreeResponse{
+			Directories: []*remoteexecution.Directory{
+				grandchildDirectory,
+			},
+		}, stream.responses[1])
+
+		// Before using the cached tree, the existence of all
+		// directories should be checked.
+		contentAddressableStorage.EXPECT().FindMissing(
+			gomock.Any(),
+			digest.NewSetBuilder().Add(rootDigest).Add(childDigest1).Add(childDigest2).Add(grandchildDigest).Build(),
+		).Return(digest.EmptySet, nil)
+
+		stream = getTreeServer{ctx: ctx}
+		require.NoError(t, contentAddressableStorageServer.GetTree(&remoteexecution.GetTreeRequest{
+			InstanceName: "hello",
+			RootDigest:   rootDigest.GetProto(),
+			PageSize:     2,
+			PageToken:    "1",
+		}, &stream))
+		require.Len(t, stream.responses, 2)
+		testutil.RequireEqualProto(t, &remoteexecution.GetTreeResponse{
+			Directories: []*remoteexecution.Directory{
+				childDirectory1,
+				childDirectory2,
+			},
+			NextPageToken: "3",
+		}, stream.responses[0])
+		testutil.RequireEqualProto(t, &remoteexecution.GetTreeResponse{
+			Directories: []*remoteexecution.Directory{
+				grandchildDirectory,
+			},
+		}, stream.responses[1])
+	})
+
+	t.Run("StaleCacheEntry", func(t *testing.T) {
+		// If directories have been removed from the Content
+		// Addressable Storage since the tree was cached, the
+		// tree should be traversed once more.
+		contentAddressableStorage.EXPECT().FindMissing(
+			gomock.Any(),
+			digest.NewSetBuilder().Add(rootDigest).Add(childDigest1).Add(childDigest2).Add(grandchildDigest).Build(),
+		).Return(childDigest2.ToSingletonSet(), nil)
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), rootDigest).Return(
+			buffer.NewProtoBufferFromProto(rootDirectory, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), childDigest1).Return(
+			buffer.NewProtoBufferFromProto(childDirectory1, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), childDigest2).Return(
+			buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), grandchildDigest).Return(
+			buffer.NewProtoBufferFromProto(grandchildDirectory, buffer.UserProvided))
+
+		stream := getTreeServer{ctx: ctx}
+		require.NoError(t, contentAddressableStorageServer.GetTree(&remoteexecution.GetTreeRequest{
+			InstanceName: "hello",
+			RootDigest:   rootDigest.GetProto(),
+		}, &stream))
+		require.Len(t, stream.responses, 1)
+		testutil.RequireEqualProto(t, &remoteexecution.GetTreeResponse{
+			Directories: []*remoteexecution.Directory{
+				rootDirectory,
+				childDirectory1,
+				grandchildDirectory,
+			},
+		}, stream.responses[0])
+	})
+
+	t.Run("TreeTooLarge", func(t *testing.T) {
+		// Trees that exceed the maximum size of the cache
+		// should not be cached.
+		contentAddressableStorageServer := grpcservers.NewContentAddressableStorageServer(
+			contentAddressableStorage,
+			1<<16,
+			2,
+			grpcservers.NewTreeCache(10, 100, eviction.NewLRUSet()))
+		for i := 0; i < 2; i++ {
+			contentAddressableStorage.EXPECT().Get(gomock.Any(), rootDigest).Return(
+				buffer.NewProtoBufferFromProto(rootDirectory, buffer.UserProvided))
+			contentAddressableStorage.EXPECT().Get(gomock.Any(), childDigest1).Return(
+				buffer.NewProtoBufferFromProto(childDirectory1, buffer.UserProvided))
+			contentAddressableStorage.EXPECT().Get(gomock.Any(), childDigest2).Return(
+				buffer.NewProtoBufferFromProto(childDirectory2, buffer.UserProvided))
+			contentAddressableStorage.EXPECT().Get(gomock.Any(), grandchildDigest).Return(
+				buffer.NewProtoBufferFromProto(grandchildDirectory, buffer.UserProvided))
+
+			stream := getTreeServer{ctx: ctx}
+			require.NoError(t, contentAddressableStorageServer.GetTree(&remoteexecution.GetTreeRequest{
+				InstanceName: "hello",
+				RootDigest:   rootDigest.GetProto(),
+			}, &stream))
+			require.Len(t, stream.responses, 1)
+		}
+	})
+}
@@ -0,0 +1,255 @@
+package grpcservers
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/filesystem/path"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Every file created by DirectoryUploadStore starts with a header that
+// contains the size of the data stored for the upload, followed by the
+// time at which the upload was last modified. Both are stored as
+// 64-bit little endian integers.
+const directoryUploadHeaderSizeBytes = 16
+
+// DirectoryUploadStore is an UploadStore that stores uploads as files
+// in a directory.
+type DirectoryUploadStore interface {
+	UploadStore
+
+	// RemoveExpiredUploads removes all uploads that are not in use
+	// and have not been modified for the configured expiration
+	// duration. This function should be called periodically, as
+	// clients may abandon uploads without completing them.
+	RemoveExpiredUploads() error
+}
+
+type directoryUploadStore struct {
+	directory  filesystem.Directory
+	clock      clock.Clock
+	expiration time.Duration
+
+	lock          sync.Mutex
+	activeUploads map[path.Component]struct{}
+}
+
+// NewDirectoryUploadStore creates an UploadStore that stores the data
+// of uploads as files in a directory. Files are named after the SHA-256
+// hash of the resource name of the upload, as resource names may
+// contain slashes and may exceed the maximum filename length.
+//
+// The size of uploads is stored in the file itself, and is only
+// updated when the upload is closed, after all data has been written.
+// This means that in case of crashes, the reported size never exceeds
+// the amount of data that was written, and that the header is not
+// rewritten for every chunk of data. Data corruption is detected when the completed upload is
+// validated against its digest.
+//
+// To permit uploads to be resumed on another replica, the directory
+// may be placed on storage that is shared between replicas. This
+// implementation only prevents concurrent access to the same upload
+// from within a single process.
+func NewDirectoryUploadStore(directory filesystem.Directory, clock clock.Clock, expiration time.Duration) DirectoryUploadStore {
+	return &directoryUploadStore{
+		directory:     directory,
+		clock:         clock,
+		expiration:    expiration,
+		activeUploads: map[path.Component]struct{}{},
+	}
+}
+
+func getUploadFilename(resourceName string) path.Component {
+	h := sha256.Sum256([]byte(resourceName))
+	return path.MustNewComponent(hex.EncodeToString(h[:]))
+}
+
+// readDirectoryUploadHeader reads the size and modification time of an
+// upload. Files whose header is incomplete were created by a process
+// that crashed, and are treated as if they are empty.
+func readDirectoryUploadHeader(f filesystem.FileReader) (int64, time.Time, error) {
+	var header [directoryUploadHeaderSizeBytes]byte
+	if n, err := f.ReadAt(header[:], 0); err != nil {
+		if err == io.EOF && n < len(header) {
+			return 0, time.Time{}, nil
+		}
+		return 0, time.Time{}, util.StatusWrapWithCode(err, codes.Internal, "Failed to read upload header")
+	}
+	committedSize := int64(binary.LittleEndian.Uint64(header[:]))
+	if committedSize < 0 {
+		return 0, time.Time{}, status.Errorf(codes.Internal, "Upload header contains invalid size %d", committedSize)
+	}
+	return committedSize, time.Unix(0, int64(binary.LittleEndian.Uint64(header[8:]))), nil
+}
+
+func (us *directoryUploadStore) writeHeader(f filesystem.FileWriter, committedSize int64) error {
+	var header [directoryUploadHeaderSizeBytes]byte
+	binary.LittleEndian.PutUint64(header[:], uint64(committedSize))
+	binary.LittleEndian.PutUint64(header[8:], uint64(us.clock.Now().UnixNano()))
+	if _, err := f.WriteAt(header[:], 0); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to write upload header")
+	}
+	return nil
+}
+
+// acquire marks an upload as being in use, so that it is not accessed
+// concurrently.
+func (us *directoryUploadStore) acquire(name path.Component) bool {
+	us.lock.Lock()
+	defer us.lock.Unlock()
+	if _, ok := us.activeUploads[name]; ok {
+		return false
+	}
+	us.activeUploads[name] = struct{}{}
+	return true
+}
+
+func (us *directoryUploadStore) release(name path.Component) {
+	us.lock.Lock()
+	delete(us.activeUploads, name)
+	us.lock.Unlock()
+}
+
+func (us *directoryUploadStore) OpenUpload(resourceName string) (Upload, error) {
+	name := getUploadFilename(resourceName)
+	if !us.acquire(name) {
+		return nil, status.Error(codes.Aborted, "Upload is already in progress")
+	}
+
+	f, err := us.directory.OpenReadWrite(name, filesystem.CreateReuse(0o666))
+	if err != nil {
+		us.release(name)
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to open upload file")
+	}
+	committedSize, _, err := readDirectoryUploadHeader(f)
+	if err == nil {
+		// Rewrite the header, so that the modification time
+		// is updated and newly created files get a header.
+		err = us.writeHeader(f, committedSize)
+	}
+	if err != nil {
+		f.Close()
+		us.release(name)
+		return nil, err
+	}
+	return &directoryUpload{
+		store:         us,
+		name:          name,
+		file:          f,
+		committedSize: committedSize,
+	}, nil
+}
+
+func (us *directoryUploadStore) GetCommittedSize(resourceName string) (int64, error) {
+	f, err := us.directory.OpenRead(getUploadFilename(resourceName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, util.StatusWrapWithCode(err, codes.Internal, "Failed to open upload file")
+	}
+	defer f.Close()
+	committedSize, _, err := readDirectoryUploadHeader(f)
+	return committedSize, err
+}
+
+func (us *directoryUploadStore) RemoveExpiredUploads() error {
+	entries, err := us.directory.ReadDir()
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to read directory")
+	}
+	now := us.clock.Now()
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type() != filesystem.FileTypeRegularFile || !us.acquire(name) {
+			continue
+		}
+		err := us.removeIfExpired(name, now)
+		us.release(name)
+		if err != nil {
+			return util.StatusWrapf(err, "Upload file %#v", name.String())
+		}
+	}
+	return nil
+}
+
+func (us *directoryUploadStore) removeIfExpired(name path.Component, now time.Time) error {
+	f, err := us.directory.OpenRead(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to open upload file")
+	}
+	_, lastModified, err := readDirectoryUploadHeader(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if now.Sub(lastModified) < us.expiration {
+		return nil
+	}
+	if err := us.directory.Remove(name); err != nil && !os.IsNotExist(err) {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to remove upload file")
+	}
+	return nil
+}
+
+type directoryUpload struct {
+	store         *directoryUploadStore
+	name          path.Component
+	file          filesystem.FileReadWriter
+	committedSize int64
+}
+
+func (u *directoryUpload) GetCommittedSize() int64 {
+	return u.committedSize
+}
+
+func (u *directoryUpload) Append(data []byte) error {
+	if _, err := u.file.WriteAt(data, directoryUploadHeaderSizeBytes+u.committedSize); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to write upload data")
+	}
+	u.committedSize += int64(len(data))
+	return nil
+}
+
+func (u *directoryUpload) NewReader() io.Reader {
+	return io.NewSectionReader(u.file, directoryUploadHeaderSizeBytes, u.committedSize)
+}
+
+func (u *directoryUpload) Close() error {
+	// Only persist the size of the upload now that no more data
+	// is appended to it.
+	headerErr := u.store.writeHeader(u.file, u.committedSize)
+	err := u.file.Close()
+	u.store.release(u.name)
+	if headerErr != nil {
+		return headerErr
+	}
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to close upload file")
+	}
+	return nil
+}
+
+func (u *directoryUpload) Remove() error {
+	u.file.Close()
+	err := u.store.directory.Remove(u.name)
+	u.store.release(u.name)
+	if err != nil && !os.IsNotExist(err) {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to remove upload file")
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package grpcservers_test
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/grpcservers"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDirectoryUploadStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	directoryPath := t.TempDir()
+	directory, err := filesystem.NewLocalDirectory(directoryPath)
+	require.NoError(t, err)
+	defer directory.Close()
+	clock := mock.NewMockClock(ctrl)
+	uploadStore := grpcservers.NewDirectoryUploadStore(directory, clock, time.Hour)
+
+	resourceName := "ubuntu1804/uploads/2b2f6a8e-5f3d-4d33-8b0e-bb1f8e0f2f55/blobs/3e25960a79dbc69b674cd4ec67a72c62/11"
+
+	t.Run("AppendAndReopen", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(1000, 0)).Times(2)
+		upload, err := uploadStore.OpenUpload(resourceName)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), upload.GetCommittedSize())
+		require.NoError(t, upload.Append([]byte("Hello ")))
+		require.NoError(t, upload.Append([]byte("world")))
+		require.Equal(t, int64(11), upload.GetCommittedSize())
+
+		// The size of the upload should only be persisted once
+		// the upload is closed.
+		committedSize, err := uploadStore.GetCommittedSize(resourceName)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), committedSize)
+
+		// Concurrent access to the same upload is not permitted.
+		_, err = uploadStore.OpenUpload(resourceName)
+		require.Equal(t, status.Error(codes.Aborted, "Upload is already in progress"), err)
+
+		require.NoError(t, upload.Close())
+
+		// The committed size should be retained after closing,
+		// and the data should be readable after reopening.
+		committedSize, err = uploadStore.GetCommittedSize(resourceName)
+		require.NoError(t, err)
+		require.Equal(t, int64(11), committedSize)
+
+		clock.EXPECT().Now().Return(time.Unix(1100, 0)).Times(2)
+		upload, err = uploadStore.OpenUpload(resourceName)
+		require.NoError(t, err)
+		require.Equal(t, int64(11), upload.GetCommittedSize())
+		data, err := ioutil.ReadAll(upload.NewReader())
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+		require.NoError(t, upload.Close())
+	})
+
+	t.Run("RemoveExpiredUploads", func(t *testing.T) {
+		// The upload was last modified at t=1100, meaning it
+		// should only be removed an hour after that.
+		clock.EXPECT().Now().Return(time.Unix(4699, 0))
+		require.NoError(t, uploadStore.RemoveExpiredUploads())
+		committedSize, err := uploadStore.GetCommittedSize(resourceName)
+		require.NoError(t, err)
+		require.Equal(t, int64(11), committedSize)
+
+		clock.EXPECT().Now().Return(time.Unix(4700, 0))
+		require.NoError(t, uploadStore.RemoveExpiredUploads())
+		committedSize, err = uploadStore.GetCommittedSize(resourceName)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), committedSize)
+
+		files, err := ioutil.ReadDir(directoryPath)
+		require.NoError(t, err)
+		require.Empty(t, files)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Unix(5000, 0))
+		upload, err := uploadStore.OpenUpload(resourceName)
+		require.NoError(t, err)
+		require.NoError(t, upload.Append([]byte("Hello")))
+		require.NoError(t, upload.Remove())
+
+		files, err := ioutil.ReadDir(directoryPath)
+		require.NoError(t, err)
+		require.Empty(t, files)
+	})
+}
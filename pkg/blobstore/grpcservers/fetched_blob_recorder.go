@@ -0,0 +1,55 @@
+package grpcservers
+
+import (
+	"context"
+
+	remoteasset "github.com/bazelbuild/remote-apis/build/bazel/remote/asset/v1"
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// fetchedBlobRecorder is used by implementations of the Fetch service
+// that download blobs from their origin. It stores downloaded blobs in
+// the Content Addressable Storage and records them through the Push
+// service, so that subsequent requests can be served without
+// downloading them again.
+type fetchedBlobRecorder struct {
+	pushServer                remoteasset.PushServer
+	contentAddressableStorage blobstore.BlobAccess
+	errorLogger               util.ErrorLogger
+}
+
+func (r *fetchedBlobRecorder) recordBlob(ctx context.Context, instanceName digest.InstanceName, in *remoteasset.FetchBlobRequest, uri string, data []byte) (*remoteasset.FetchBlobResponse, error) {
+	digestFunction, err := instanceName.GetDigestFunction(remoteexecution.DigestFunction_SHA256)
+	if err != nil {
+		return nil, err
+	}
+	generator := digestFunction.NewGenerator()
+	if _, err := generator.Write(data); err != nil {
+		return nil, err
+	}
+	blobDigest := generator.Sum()
+	if err := r.contentAddressableStorage.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice(data)); err != nil {
+		return nil, util.StatusWrapf(err, "Failed to store blob downloaded from URI %#v", uri)
+	}
+
+	// As the blob has already been stored, failures to record the
+	// asset are not fatal.
+	blobDigestMessage := blobDigest.GetProto()
+	if _, err := r.pushServer.PushBlob(ctx, &remoteasset.PushBlobRequest{
+		InstanceName: in.InstanceName,
+		Uris:         []string{uri},
+		Qualifiers:   in.Qualifiers,
+		BlobDigest:   blobDigestMessage,
+	}); err != nil {
+		r.errorLogger.Log(util.StatusWrapf(err, "Failed to record asset for URI %#v", uri))
+	}
+	return &remoteasset.FetchBlobResponse{
+		Uri:        uri,
+		Qualifiers: in.Qualifiers,
+		BlobDigest: blobDigestMessage,
+	}, nil
+}
@@ -0,0 +1,159 @@
+package grpcservers
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	remoteasset "github.com/bazelbuild/remote-apis/build/bazel/remote/asset/v1"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/git"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// vcsCommitQualifier is the name of the qualifier through which
+	// clients provide the commit of a repository that is requested.
+	vcsCommitQualifier = "vcs.commit"
+	// vcsBranchQualifier is the name of the qualifier through which
+	// clients provide the branch containing the requested commit.
+	// As archives are created based on the commit, it is ignored.
+	vcsBranchQualifier = "vcs.branch"
+)
+
+type gitFetchingAssetFetchServer struct {
+	remoteasset.FetchServer
+	fetchedBlobRecorder
+
+	archiver         git.Archiver
+	maximumSizeBytes int64
+}
+
+// NewGitFetchingAssetFetchServer creates a decorator for the Fetch
+// service of the Remote Asset API that resolves URIs of Git
+// repositories to tarballs containing the tree of a commit, if they
+// cannot be resolved by the backing Fetch service. This permits
+// clients to let repository rules that clone Git repositories be
+// satisfied remotely.
+//
+// This decorator only acts upon requests that provide the
+// "vcs.commit" qualifier, and only for URIs that use the "git" scheme,
+// or that use the "http", "https" or "ssh" scheme and have a path
+// ending with ".git". The resulting tarballs are written into the
+// Content Addressable Storage and recorded through the Push service,
+// so that subsequent requests for the same commit can be served
+// without contacting the origin. Their size is limited by
+// maximumSizeBytes.
+func NewGitFetchingAssetFetchServer(base remoteasset.FetchServer, pushServer remoteasset.PushServer, contentAddressableStorage blobstore.BlobAccess, archiver git.Archiver, maximumSizeBytes int64, errorLogger util.ErrorLogger) remoteasset.FetchServer {
+	return &gitFetchingAssetFetchServer{
+		FetchServer: base,
+		fetchedBlobRecorder: fetchedBlobRecorder{
+			pushServer:                pushServer,
+			contentAddressableStorage: contentAddressableStorage,
+			errorLogger:               errorLogger,
+		},
+		archiver:         archiver,
+		maximumSizeBytes: maximumSizeBytes,
+	}
+}
+
+func isGitRepositoryURI(uri string) bool {
+	parsedURI, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	switch parsedURI.Scheme {
+	case "git":
+		return true
+	case "http", "https", "ssh":
+		return strings.HasSuffix(parsedURI.Path, ".git")
+	default:
+		return false
+	}
+}
+
+// getArchive creates a tarball of a single commit in a Git repository,
+// returning its contents.
+func (s *gitFetchingAssetFetchServer) getArchive(ctx context.Context, uri, commit string) ([]byte, error) {
+	r, err := s.archiver.GetArchive(ctx, uri, commit)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, s.maximumSizeBytes+1))
+	if err != nil {
+		r.Close()
+		return nil, util.StatusWrapWithCode(err, codes.Unavailable, "Failed to read archive")
+	}
+	if int64(len(data)) > s.maximumSizeBytes {
+		r.Close()
+		return nil, status.Errorf(codes.InvalidArgument, "Archive exceeds the maximum size of %d bytes", s.maximumSizeBytes)
+	}
+	if err := r.Close(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *gitFetchingAssetFetchServer) FetchBlob(ctx context.Context, in *remoteasset.FetchBlobRequest) (*remoteasset.FetchBlobResponse, error) {
+	response, err := s.FetchServer.FetchBlob(ctx, in)
+	if status.Code(err) != codes.NotFound {
+		return response, err
+	}
+
+	// Only act upon requests for specific commits of Git
+	// repositories.
+	var commit string
+	for _, qualifier := range in.Qualifiers {
+		if qualifier.Name == vcsCommitQualifier {
+			commit = qualifier.Value
+		}
+	}
+	if commit == "" {
+		return nil, err
+	}
+	var uris []string
+	for _, uri := range in.Uris {
+		if isGitRepositoryURI(uri) {
+			uris = append(uris, uri)
+		}
+	}
+	if len(uris) == 0 {
+		return nil, err
+	}
+
+	instanceName, err := digest.NewInstanceName(in.InstanceName)
+	if err != nil {
+		return nil, util.StatusWrapf(err, "Invalid instance name %#v", in.InstanceName)
+	}
+	for _, qualifier := range in.Qualifiers {
+		if qualifier.Name != vcsCommitQualifier && qualifier.Name != vcsBranchQualifier {
+			return nil, status.Errorf(codes.InvalidArgument, "Qualifier %#v is not supported when fetching Git repositories", qualifier.Name)
+		}
+	}
+	if in.Timeout != nil {
+		if err := in.Timeout.CheckValid(); err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid timeout")
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, in.Timeout.AsDuration())
+		defer cancel()
+	}
+
+	// Attempt to fetch the commit from any of the repositories, in
+	// the order provided by the client.
+	for _, uri := range uris {
+		data, archiveErr := s.getArchive(ctx, uri, commit)
+		if archiveErr != nil {
+			err = util.StatusWrapf(archiveErr, "Failed to fetch commit %#v from repository %#v", commit, uri)
+			continue
+		}
+		return s.recordBlob(ctx, instanceName, in, uri, data)
+	}
+	return nil, err
+}
@@ -0,0 +1,156 @@
+package grpcservers_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	remoteasset "github.com/bazelbuild/remote-apis/build/bazel/remote/asset/v1"
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/grpcservers"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGitFetchingAssetFetchServer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	assetStore := mock.NewMockBlobAccess(ctrl)
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	archiver := mock.NewMockArchiver(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	s := grpcservers.NewGitFetchingAssetFetchServer(
+		grpcservers.NewAssetFetchServer(assetStore, grpcservers.NewAssetKeyGenerator(nil), contentAddressableStorage, clock, 1000),
+		grpcservers.NewAssetPushServer(assetStore, grpcservers.NewAssetKeyGenerator(nil), clock),
+		contentAddressableStorage,
+		archiver,
+		10,
+		errorLogger)
+
+	const commit = "a5b8d4fc1fc6b4e0d37ac4ba2b7e8a45a3af5b4e"
+	blobDigest := digest.MustNewDigest("example", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+
+	// expectAssetMissing expects the Asset Store to be consulted
+	// for a given number of URIs, without yielding any results.
+	expectAssetMissing := func(count int) {
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+		assetStore.EXPECT().Get(ctx, gomock.Any()).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found"))).Times(count)
+	}
+
+	t.Run("NoCommit", func(t *testing.T) {
+		// Requests that don't specify a commit should not cause
+		// repositories to be fetched.
+		expectAssetMissing(1)
+
+		_, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"https://github.com/example/repo.git"},
+			Qualifiers: []*remoteasset.Qualifier{
+				{Name: "vcs.branch", Value: "main"},
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "No asset is present for any of the provided URIs"), err)
+	})
+
+	t.Run("NoRepositoryURIs", func(t *testing.T) {
+		expectAssetMissing(1)
+
+		_, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"https://example.com/foo.tar.gz"},
+			Qualifiers: []*remoteasset.Qualifier{
+				{Name: "vcs.commit", Value: commit},
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "No asset is present for any of the provided URIs"), err)
+	})
+
+	t.Run("UnsupportedQualifier", func(t *testing.T) {
+		expectAssetMissing(1)
+
+		_, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"git://example.com/repo.git"},
+			Qualifiers: []*remoteasset.Qualifier{
+				{Name: "checksum.sri", Value: "sha256-GF+NsyJx/iX1Yab8k4suJkMG7DBO2lGAB9F2SCY4GWk="},
+				{Name: "vcs.commit", Value: commit},
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Qualifier \"checksum.sri\" is not supported when fetching Git repositories"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// The first repository fails, meaning the commit should
+		// be fetched from the second repository.
+		qualifiers := []*remoteasset.Qualifier{
+			{Name: "vcs.branch", Value: "main"},
+			{Name: "vcs.commit", Value: commit},
+		}
+		expectAssetMissing(2)
+		gomock.InOrder(
+			archiver.EXPECT().GetArchive(ctx, "https://github.com/example/repo.git", commit).
+				Return(nil, status.Error(codes.Unavailable, "Failed to run \"git fetch\": exit status 128: fatal: unable to access")),
+			archiver.EXPECT().GetArchive(ctx, "git://mirror.example.com/repo.git", commit).
+				Return(ioutil.NopCloser(bytes.NewBufferString("Hello")), nil))
+		contentAddressableStorage.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return nil
+			})
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+		assetStore.EXPECT().Put(ctx, gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+
+		response, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris: []string{
+				"https://github.com/example/repo.git",
+				"git://mirror.example.com/repo.git",
+			},
+			Qualifiers: qualifiers,
+		})
+		require.NoError(t, err)
+		testutil.RequireEqualProto(t, &remoteasset.FetchBlobResponse{
+			Uri:        "git://mirror.example.com/repo.git",
+			Qualifiers: qualifiers,
+			BlobDigest: &remoteexecution.Digest{
+				Hash:      "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969",
+				SizeBytes: 5,
+			},
+		}, response)
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		expectAssetMissing(1)
+		body := mock.NewMockReadCloser(ctrl)
+		archiver.EXPECT().GetArchive(ctx, "git://example.com/repo.git", commit).Return(body, nil)
+		body.EXPECT().Read(gomock.Any()).DoAndReturn(func(p []byte) (int, error) {
+			return copy(p, "Hello, world"), nil
+		})
+		body.EXPECT().Close()
+
+		_, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"git://example.com/repo.git"},
+			Qualifiers: []*remoteasset.Qualifier{
+				{Name: "vcs.commit", Value: commit},
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Failed to fetch commit \"a5b8d4fc1fc6b4e0d37ac4ba2b7e8a45a3af5b4e\" from repository \"git://example.com/repo.git\": Archive exceeds the maximum size of 10 bytes"), err)
+	})
+}
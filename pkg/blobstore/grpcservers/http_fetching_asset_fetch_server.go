@@ -0,0 +1,214 @@
+package grpcservers
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	remoteasset "github.com/bazelbuild/remote-apis/build/bazel/remote/asset/v1"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	// Register hash functions used by Subresource Integrity checksums.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// checksumSRIQualifier is the name of the qualifier through
+	// which clients provide the expected checksum of a blob, in
+	// the form of a Subresource Integrity (SRI) string.
+	checksumSRIQualifier = "checksum.sri"
+	// httpHeaderQualifierPrefix is the prefix of qualifiers through
+	// which clients provide headers that need to be set on HTTP
+	// requests.
+	httpHeaderQualifierPrefix = "http_header:"
+)
+
+var sriHashAlgorithms = map[string]crypto.Hash{
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+type httpFetchingAssetFetchServer struct {
+	remoteasset.FetchServer
+	fetchedBlobRecorder
+
+	httpClient       blobstore.HTTPClient
+	maximumSizeBytes int64
+}
+
+// NewHTTPFetchingAssetFetchServer creates a decorator for the Fetch
+// service of the Remote Asset API that downloads blobs over HTTP if
+// they cannot be resolved by the backing Fetch service. Downloaded
+// blobs are written into the Content Addressable Storage, and are
+// recorded through the Push service, so that subsequent requests for
+// the same URI and qualifiers can be served without contacting the
+// origin.
+//
+// Blobs are held in memory while being downloaded, as their digest
+// needs to be computed before they can be written into the Content
+// Addressable Storage. Their size is limited by maximumSizeBytes. If
+// the "checksum.sri" qualifier is provided, downloaded blobs are only
+// accepted if their checksum matches. Qualifiers whose name starts
+// with "http_header:" are converted to request headers. Directories
+// are never downloaded.
+func NewHTTPFetchingAssetFetchServer(base remoteasset.FetchServer, pushServer remoteasset.PushServer, contentAddressableStorage blobstore.BlobAccess, httpClient blobstore.HTTPClient, maximumSizeBytes int64, errorLogger util.ErrorLogger) remoteasset.FetchServer {
+	return &httpFetchingAssetFetchServer{
+		FetchServer: base,
+		fetchedBlobRecorder: fetchedBlobRecorder{
+			pushServer:                pushServer,
+			contentAddressableStorage: contentAddressableStorage,
+			errorLogger:               errorLogger,
+		},
+		httpClient:       httpClient,
+		maximumSizeBytes: maximumSizeBytes,
+	}
+}
+
+// checksumSRIMatches returns whether data matches a Subresource
+// Integrity string, as described in https://www.w3.org/TR/SRI/. Such
+// strings may contain multiple hashes, separated by whitespace. Data
+// matches if it matches any of the hashes whose algorithm is
+// supported.
+func checksumSRIMatches(sri string, data []byte) (bool, error) {
+	supported := false
+	for _, entry := range strings.Fields(sri) {
+		// Strip options that may follow the hash.
+		if i := strings.IndexByte(entry, '?'); i >= 0 {
+			entry = entry[:i]
+		}
+		dash := strings.IndexByte(entry, '-')
+		if dash < 0 {
+			return false, status.Errorf(codes.InvalidArgument, "Invalid Subresource Integrity entry %#v", entry)
+		}
+		hash, ok := sriHashAlgorithms[entry[:dash]]
+		if !ok {
+			continue
+		}
+		expectedChecksum, err := base64.StdEncoding.DecodeString(entry[dash+1:])
+		if err != nil {
+			return false, util.StatusWrapfWithCode(err, codes.InvalidArgument, "Invalid Subresource Integrity entry %#v", entry)
+		}
+		supported = true
+		hasher := hash.New()
+		hasher.Write(data)
+		if bytes.Equal(hasher.Sum(nil), expectedChecksum) {
+			return true, nil
+		}
+	}
+	if !supported {
+		return false, status.Errorf(codes.InvalidArgument, "Subresource Integrity string %#v does not contain any supported hash algorithms", sri)
+	}
+	return false, nil
+}
+
+func getHTTPStatusCode(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return codes.PermissionDenied
+	default:
+		return codes.Unavailable
+	}
+}
+
+// downloadBlob downloads a single blob over HTTP, returning its
+// contents.
+func (s *httpFetchingAssetFetchServer) downloadBlob(ctx context.Context, uri string, headers http.Header, checksumSRI string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to create request")
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Unavailable, "HTTP request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, status.Errorf(getHTTPStatusCode(resp.StatusCode), "HTTP request failed with status %#v", resp.Status)
+	}
+	if resp.ContentLength > s.maximumSizeBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "Blob is %d bytes in size, which exceeds the maximum of %d bytes", resp.ContentLength, s.maximumSizeBytes)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, s.maximumSizeBytes+1))
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Unavailable, "Failed to read response body")
+	}
+	if int64(len(data)) > s.maximumSizeBytes {
+		return nil, status.Errorf(codes.InvalidArgument, "Blob exceeds the maximum size of %d bytes", s.maximumSizeBytes)
+	}
+
+	if checksumSRI != "" {
+		matches, err := checksumSRIMatches(checksumSRI, data)
+		if err != nil {
+			return nil, err
+		}
+		if !matches {
+			return nil, status.Errorf(codes.InvalidArgument, "Blob does not match checksum %#v", checksumSRI)
+		}
+	}
+	return data, nil
+}
+
+func (s *httpFetchingAssetFetchServer) FetchBlob(ctx context.Context, in *remoteasset.FetchBlobRequest) (*remoteasset.FetchBlobResponse, error) {
+	response, err := s.FetchServer.FetchBlob(ctx, in)
+	if status.Code(err) != codes.NotFound {
+		return response, err
+	}
+
+	instanceName, err := digest.NewInstanceName(in.InstanceName)
+	if err != nil {
+		return nil, util.StatusWrapf(err, "Invalid instance name %#v", in.InstanceName)
+	}
+	var checksumSRI string
+	headers := http.Header{}
+	for _, qualifier := range in.Qualifiers {
+		if qualifier.Name == checksumSRIQualifier {
+			checksumSRI = qualifier.Value
+		} else if strings.HasPrefix(qualifier.Name, httpHeaderQualifierPrefix) {
+			headers.Set(qualifier.Name[len(httpHeaderQualifierPrefix):], qualifier.Value)
+		} else {
+			return nil, status.Errorf(codes.InvalidArgument, "Qualifier %#v is not supported when downloading blobs", qualifier.Name)
+		}
+	}
+	if in.Timeout != nil {
+		if err := in.Timeout.CheckValid(); err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid timeout")
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, in.Timeout.AsDuration())
+		defer cancel()
+	}
+
+	// Attempt to download the blob from any of the URIs that use a
+	// supported scheme, in the order provided by the client.
+	err = status.Error(codes.NotFound, "No asset is present for any of the provided URIs, and none of the URIs can be downloaded over HTTP")
+	for _, uri := range in.Uris {
+		if parsedURI, parseErr := url.Parse(uri); parseErr != nil || (parsedURI.Scheme != "http" && parsedURI.Scheme != "https") {
+			continue
+		}
+		data, downloadErr := s.downloadBlob(ctx, uri, headers, checksumSRI)
+		if downloadErr != nil {
+			err = util.StatusWrapf(downloadErr, "Failed to download blob from URI %#v", uri)
+			continue
+		}
+		return s.recordBlob(ctx, instanceName, in, uri, data)
+	}
+	return nil, err
+}
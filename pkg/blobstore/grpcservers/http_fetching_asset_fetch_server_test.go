@@ -0,0 +1,185 @@
+package grpcservers_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	remoteasset "github.com/bazelbuild/remote-apis/build/bazel/remote/asset/v1"
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/grpcservers"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHTTPFetchingAssetFetchServer(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	assetStore := mock.NewMockBlobAccess(ctrl)
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	httpClient := mock.NewMockHTTPClient(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	s := grpcservers.NewHTTPFetchingAssetFetchServer(
+		grpcservers.NewAssetFetchServer(assetStore, grpcservers.NewAssetKeyGenerator(nil), contentAddressableStorage, clock, 1000),
+		grpcservers.NewAssetPushServer(assetStore, grpcservers.NewAssetKeyGenerator(nil), clock),
+		contentAddressableStorage,
+		httpClient,
+		10,
+		errorLogger)
+
+	blobDigest := digest.MustNewDigest("example", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+
+	// expectAssetMissing expects the Asset Store to be consulted
+	// for a given number of URIs, without yielding any results.
+	expectAssetMissing := func(count int) {
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+		assetStore.EXPECT().Get(ctx, gomock.Any()).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found"))).Times(count)
+	}
+
+	newResponse := func(statusCode int, body string) *http.Response {
+		return &http.Response{
+			Status:        http.StatusText(statusCode),
+			StatusCode:    statusCode,
+			ContentLength: -1,
+			Body:          ioutil.NopCloser(bytes.NewBufferString(body)),
+		}
+	}
+
+	t.Run("NoDownloadableURIs", func(t *testing.T) {
+		expectAssetMissing(1)
+
+		_, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"git://example.com/foo.git"},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "No asset is present for any of the provided URIs, and none of the URIs can be downloaded over HTTP"), err)
+	})
+
+	t.Run("UnsupportedQualifier", func(t *testing.T) {
+		expectAssetMissing(1)
+
+		_, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"https://example.com/foo.txt"},
+			Qualifiers: []*remoteasset.Qualifier{
+				{Name: "vcs.branch", Value: "main"},
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Qualifier \"vcs.branch\" is not supported when downloading blobs"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// The first URI fails, meaning the blob should be
+		// downloaded from the second URI. Qualifiers for HTTP
+		// headers should be converted to request headers.
+		qualifiers := []*remoteasset.Qualifier{
+			{Name: "checksum.sri", Value: "sha256-GF+NsyJx/iX1Yab8k4suJkMG7DBO2lGAB9F2SCY4GWk="},
+			{Name: "http_header:Authorization", Value: "Bearer token"},
+		}
+		expectAssetMissing(2)
+		gomock.InOrder(
+			httpClient.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+				require.Equal(t, "https://example.com/foo.txt", req.URL.String())
+				return newResponse(http.StatusNotFound, "Not found"), nil
+			}),
+			httpClient.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+				require.Equal(t, "https://mirror.example.com/foo.txt", req.URL.String())
+				require.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+				return newResponse(http.StatusOK, "Hello"), nil
+			}))
+		contentAddressableStorage.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return nil
+			})
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+		assetStore.EXPECT().Put(ctx, gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+
+		response, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris: []string{
+				"https://example.com/foo.txt",
+				"https://mirror.example.com/foo.txt",
+			},
+			Qualifiers: qualifiers,
+		})
+		require.NoError(t, err)
+		testutil.RequireEqualProto(t, &remoteasset.FetchBlobResponse{
+			Uri:        "https://mirror.example.com/foo.txt",
+			Qualifiers: qualifiers,
+			BlobDigest: &remoteexecution.Digest{
+				Hash:      "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969",
+				SizeBytes: 5,
+			},
+		}, response)
+	})
+
+	t.Run("ChecksumMismatch", func(t *testing.T) {
+		expectAssetMissing(1)
+		httpClient.EXPECT().Do(gomock.Any()).Return(newResponse(http.StatusOK, "Goodbye"), nil)
+
+		_, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"https://example.com/foo.txt"},
+			Qualifiers: []*remoteasset.Qualifier{
+				{Name: "checksum.sri", Value: "sha256-GF+NsyJx/iX1Yab8k4suJkMG7DBO2lGAB9F2SCY4GWk="},
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Failed to download blob from URI \"https://example.com/foo.txt\": Blob does not match checksum \"sha256-GF+NsyJx/iX1Yab8k4suJkMG7DBO2lGAB9F2SCY4GWk=\""), err)
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		expectAssetMissing(1)
+		httpClient.EXPECT().Do(gomock.Any()).Return(newResponse(http.StatusOK, "Hello, world"), nil)
+
+		_, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"https://example.com/foo.txt"},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Failed to download blob from URI \"https://example.com/foo.txt\": Blob exceeds the maximum size of 10 bytes"), err)
+	})
+
+	t.Run("RecordFailure", func(t *testing.T) {
+		// Failing to record the asset should not cause the
+		// request to fail, as the blob is already present in the
+		// Content Addressable Storage.
+		expectAssetMissing(1)
+		httpClient.EXPECT().Do(gomock.Any()).Return(newResponse(http.StatusOK, "Hello"), nil)
+		contentAddressableStorage.EXPECT().Put(ctx, blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+		assetStore.EXPECT().Put(ctx, gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.PermissionDenied, "Permission denied")
+			})
+		errorLogger.EXPECT().Log(testutil.EqPrefixedStatus(status.Error(codes.PermissionDenied, "Failed to record asset for URI \"https://example.com/foo.txt\": Failed to store asset for URI \"https://example.com/foo.txt\": Permission denied")))
+
+		response, err := s.FetchBlob(ctx, &remoteasset.FetchBlobRequest{
+			InstanceName: "example",
+			Uris:         []string{"https://example.com/foo.txt"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "https://example.com/foo.txt", response.Uri)
+	})
+}
@@ -0,0 +1,127 @@
+package grpcservers
+
+import (
+	"sync"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// treeCacheEntry holds a flattened directory hierarchy stored in
+// TreeCache. Entries of hierarchies that have been invalidated have no
+// directories. They are retained until evicted or replaced, as
+// eviction.Set does not permit removing arbitrary elements.
+type treeCacheEntry struct {
+	directories      []*remoteexecution.Directory
+	directoryDigests digest.Set
+	sizeBytes        int64
+}
+
+// TreeCache is a cache of flattened directory hierarchies, keyed by the
+// digest of the root directory. It is used by the GetTree()
+// implementation of the Content Addressable Storage server to prevent
+// the need for traversing directory hierarchies repeatedly. This is
+// especially useful when clients request results in multiple pages.
+//
+// The cache is bounded both by the number of hierarchies and by the
+// total size of the Directory objects contained in them. Hierarchies
+// that exceed the total size on their own are not cached.
+//
+// Even though objects in the Content Addressable Storage are
+// immutable, they may be removed from it (e.g., due to eviction). The
+// Directory objects of cached hierarchies may therefore no longer be
+// present. The digests of these objects are returned alongside the
+// hierarchy, so that callers can check for their existence. Stale
+// entries can be invalidated by calling invalidate().
+//
+// It is safe to access TreeCache concurrently.
+type TreeCache struct {
+	cacheSize      int
+	cacheSizeBytes int64
+
+	lock           sync.Mutex
+	trees          map[string]treeCacheEntry
+	totalSizeBytes int64
+	evictionSet    eviction.Set
+}
+
+// NewTreeCache creates a new TreeCache that is empty.
+func NewTreeCache(cacheSize int, cacheSizeBytes int64, evictionSet eviction.Set) *TreeCache {
+	return &TreeCache{
+		cacheSize:      cacheSize,
+		cacheSizeBytes: cacheSizeBytes,
+
+		trees:       map[string]treeCacheEntry{},
+		evictionSet: evictionSet,
+	}
+}
+
+func (tc *TreeCache) get(rootDigest digest.Digest) ([]*remoteexecution.Directory, digest.Set, bool) {
+	key := rootDigest.GetKey(digest.KeyWithInstance)
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	entry, ok := tc.trees[key]
+	if !ok || entry.directories == nil {
+		return nil, digest.EmptySet, false
+	}
+	tc.evictionSet.Touch(key)
+	return entry.directories, entry.directoryDigests, true
+}
+
+func (tc *TreeCache) put(rootDigest digest.Digest, directories []*remoteexecution.Directory, directoryDigests digest.Set) {
+	if tc.cacheSize <= 0 {
+		return
+	}
+	sizeBytes := int64(0)
+	for _, directory := range directories {
+		sizeBytes += int64(proto.Size(directory))
+	}
+	if sizeBytes > tc.cacheSizeBytes {
+		return
+	}
+
+	key := rootDigest.GetKey(digest.KeyWithInstance)
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	if entry, ok := tc.trees[key]; ok && entry.directories != nil {
+		tc.evictionSet.Touch(key)
+		return
+	}
+
+	// Free up space to insert the tree. Entries that have been
+	// invalidated are replaced, as opposed to inserted.
+	for {
+		_, ok := tc.trees[key]
+		if (ok || len(tc.trees) < tc.cacheSize) && tc.totalSizeBytes+sizeBytes <= tc.cacheSizeBytes {
+			break
+		}
+		evictedKey := tc.evictionSet.Peek()
+		tc.totalSizeBytes -= tc.trees[evictedKey].sizeBytes
+		delete(tc.trees, evictedKey)
+		tc.evictionSet.Remove()
+	}
+	if _, ok := tc.trees[key]; ok {
+		tc.evictionSet.Touch(key)
+	} else {
+		tc.evictionSet.Insert(key)
+	}
+	tc.trees[key] = treeCacheEntry{
+		directories:      directories,
+		directoryDigests: directoryDigests,
+		sizeBytes:        sizeBytes,
+	}
+	tc.totalSizeBytes += sizeBytes
+}
+
+func (tc *TreeCache) invalidate(rootDigest digest.Digest) {
+	key := rootDigest.GetKey(digest.KeyWithInstance)
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	if entry, ok := tc.trees[key]; ok {
+		tc.totalSizeBytes -= entry.sizeBytes
+		tc.trees[key] = treeCacheEntry{}
+	}
+}
@@ -0,0 +1,51 @@
+package grpcservers
+
+import (
+	"io"
+)
+
+// UploadStore is used by the ByteStream service to persist the data of
+// uploads that are in progress. This permits clients to resume uploads
+// that got interrupted, even if the server got restarted in the
+// meantime, or if the client reconnects to another server that shares
+// the same UploadStore.
+//
+// Uploads are identified by the resource name provided by the client,
+// which contains a client generated UUID.
+type UploadStore interface {
+	// OpenUpload opens an upload, so that more data may be
+	// appended to it. If no upload with the provided resource name
+	// exists, a new one is created.
+	OpenUpload(resourceName string) (Upload, error)
+
+	// GetCommittedSize returns the amount of data that has been
+	// stored for an upload. Zero is returned if no upload with the
+	// provided resource name exists.
+	GetCommittedSize(resourceName string) (int64, error)
+}
+
+// Upload is a handle to an upload that is stored in an UploadStore.
+type Upload interface {
+	// GetCommittedSize returns the amount of data that has been
+	// stored for the upload through this handle.
+	GetCommittedSize() int64
+
+	// Append data to the end of the upload. The data is only
+	// guaranteed to be retained for resumption after Close() is
+	// called.
+	Append(data []byte) error
+
+	// NewReader returns a reader for all data that has been stored
+	// for the upload. The reader may only be used until Close() or
+	// Remove() is called.
+	NewReader() io.Reader
+
+	// Close the handle, committing the data of the upload and
+	// leaving it in place so that it may be resumed later on.
+	Close() error
+
+	// Remove the data of the upload and close the handle. This
+	// should be called once the upload is completed, or if its data
+	// turns out to be invalid.
+	Remove() error
+}
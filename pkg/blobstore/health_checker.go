@@ -0,0 +1,84 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// healthCheckProbeData is the contents of the object that is written
+// into storage to determine whether it is healthy.
+var healthCheckProbeData = []byte("Buildbarn storage health check probe")
+
+// HealthChecker performs probe requests against a storage backend to
+// determine whether it is healthy.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+type casHealthChecker struct {
+	blobAccess BlobAccess
+	digest     digest.Digest
+}
+
+// NewCASHealthChecker creates a HealthChecker for a Content Addressable
+// Storage backend. It writes a small object into storage and reads it
+// back, so that both the read and write paths of the backend are
+// exercised.
+func NewCASHealthChecker(blobAccess BlobAccess, digestFunction digest.Function) HealthChecker {
+	generator := digestFunction.NewGenerator()
+	generator.Write(healthCheckProbeData)
+	return &casHealthChecker{
+		blobAccess: blobAccess,
+		digest:     generator.Sum(),
+	}
+}
+
+func (hc *casHealthChecker) CheckHealth(ctx context.Context) error {
+	if err := hc.blobAccess.Put(ctx, hc.digest, buffer.NewValidatedBufferFromByteSlice(healthCheckProbeData)); err != nil {
+		return util.StatusWrap(err, "Failed to write probe object")
+	}
+	data, err := hc.blobAccess.Get(ctx, hc.digest).ToByteSlice(len(healthCheckProbeData))
+	if err != nil {
+		return util.StatusWrap(err, "Failed to read probe object")
+	}
+	if !bytes.Equal(data, healthCheckProbeData) {
+		return status.Error(codes.Internal, "Probe object has incorrect contents")
+	}
+	return nil
+}
+
+type acHealthChecker struct {
+	blobAccess       BlobAccess
+	digest           digest.Digest
+	maximumSizeBytes int
+}
+
+// NewACHealthChecker creates a HealthChecker for an Action Cache
+// backend. As writing bogus entries into the Action Cache is
+// undesirable, it only attempts to read an entry. A backend is
+// considered to be healthy if the entry is either returned or reported
+// as absent.
+func NewACHealthChecker(blobAccess BlobAccess, digestFunction digest.Function, maximumMessageSizeBytes int) HealthChecker {
+	generator := digestFunction.NewGenerator()
+	generator.Write(healthCheckProbeData)
+	return &acHealthChecker{
+		blobAccess:       blobAccess,
+		digest:           generator.Sum(),
+		maximumSizeBytes: maximumMessageSizeBytes,
+	}
+}
+
+func (hc *acHealthChecker) CheckHealth(ctx context.Context) error {
+	if _, err := hc.blobAccess.Get(ctx, hc.digest).ToProto(&remoteexecution.ActionResult{}, hc.maximumSizeBytes); err != nil && status.Code(err) != codes.NotFound {
+		return util.StatusWrap(err, "Failed to read probe entry")
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCASHealthChecker(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	healthChecker := blobstore.NewCASHealthChecker(
+		baseBlobAccess,
+		digest.MustNewFunction("health", remoteexecution.DigestFunction_MD5))
+	probeDigest := digest.MustNewDigest("health", "3e8e8ded0d4ec91302c9e94b309dafbc", 36)
+
+	t.Run("WriteFailure", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Put(ctx, probeDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Unavailable, "Server offline")
+			})
+
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.Unavailable, "Failed to write probe object: Server offline"),
+			healthChecker.CheckHealth(ctx))
+	})
+
+	t.Run("ReadFailure", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Put(ctx, probeDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		baseBlobAccess.EXPECT().Get(ctx, probeDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.NotFound, "Failed to read probe object: Object not found"),
+			healthChecker.CheckHealth(ctx))
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Put(ctx, probeDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Buildbarn storage health check probe"), data)
+				return nil
+			})
+		baseBlobAccess.EXPECT().Get(ctx, probeDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Buildbarn storage health check probe")))
+
+		require.NoError(t, healthChecker.CheckHealth(ctx))
+	})
+}
+
+func TestACHealthChecker(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	healthChecker := blobstore.NewACHealthChecker(
+		baseBlobAccess,
+		digest.MustNewFunction("health", remoteexecution.DigestFunction_MD5),
+		1000)
+	probeDigest := digest.MustNewDigest("health", "3e8e8ded0d4ec91302c9e94b309dafbc", 36)
+
+	t.Run("Failure", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, probeDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.Unavailable, "Failed to read probe entry: Server offline"),
+			healthChecker.CheckHealth(ctx))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		// Absence of the entry is expected, as it is never
+		// written.
+		baseBlobAccess.EXPECT().Get(ctx, probeDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		require.NoError(t, healthChecker.CheckHealth(ctx))
+	})
+}
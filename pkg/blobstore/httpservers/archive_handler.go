@@ -0,0 +1,420 @@
+package httpservers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	bb_path "github.com/buildbarn/bb-storage/pkg/filesystem/path"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// archiveWriter is a common interface for the archive formats that
+// are supported by archiveHandler.
+type archiveWriter interface {
+	addDirectory(name string) error
+	addFile(name string, isExecutable bool, sizeBytes int64) (io.Writer, error)
+	addSymlink(name, target string) error
+	Close() error
+}
+
+type tarArchiveWriter struct {
+	*tar.Writer
+}
+
+func (w tarArchiveWriter) addDirectory(name string) error {
+	return w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0o755,
+	})
+}
+
+func (w tarArchiveWriter) addFile(name string, isExecutable bool, sizeBytes int64) (io.Writer, error) {
+	mode := int64(0o644)
+	if isExecutable {
+		mode = 0o755
+	}
+	if err := w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     mode,
+		Size:     sizeBytes,
+	}); err != nil {
+		return nil, err
+	}
+	return w.Writer, nil
+}
+
+func (w tarArchiveWriter) addSymlink(name, target string) error {
+	return w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeSymlink,
+		Name:     name,
+		Linkname: target,
+		Mode:     0o777,
+	})
+}
+
+type zipArchiveWriter struct {
+	*zip.Writer
+}
+
+func (w zipArchiveWriter) addDirectory(name string) error {
+	header := &zip.FileHeader{Name: name + "/"}
+	header.SetMode(os.ModeDir | 0o755)
+	_, err := w.CreateHeader(header)
+	return err
+}
+
+func (w zipArchiveWriter) addFile(name string, isExecutable bool, sizeBytes int64) (io.Writer, error) {
+	header := &zip.FileHeader{
+		Name:   name,
+		Method: zip.Deflate,
+	}
+	if isExecutable {
+		header.SetMode(0o755)
+	} else {
+		header.SetMode(0o644)
+	}
+	return w.CreateHeader(header)
+}
+
+func (w zipArchiveWriter) addSymlink(name, target string) error {
+	header := &zip.FileHeader{Name: name}
+	header.SetMode(os.ModeSymlink | 0o777)
+	fw, err := w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(fw, target)
+	return err
+}
+
+// archiveEntry is a single file, directory or symbolic link that is
+// written into an archive. Entries are gathered and validated before
+// any data is written, so that invalid directory hierarchies can be
+// rejected with a proper status code.
+type archiveEntry struct {
+	path          string
+	isDirectory   bool
+	fileDigest    digest.Digest
+	isExecutable  bool
+	isSymlink     bool
+	symlinkTarget string
+}
+
+// symlinkTargetComponentWalker is used to validate the targets of
+// symbolic links stored in an archive. It only permits targets that
+// are relative and that remain within the archive. Targets may only
+// contain ".." components at the start, as ".." components that follow
+// a symbolic link would be resolved relative to the symbolic link's
+// target.
+type symlinkTargetComponentWalker struct {
+	remainingLevelsUp int
+	descended         bool
+}
+
+func (cw symlinkTargetComponentWalker) OnDirectory(name bb_path.Component) (bb_path.GotDirectoryOrSymlink, error) {
+	return bb_path.GotDirectory{
+		Child: symlinkTargetComponentWalker{
+			remainingLevelsUp: cw.remainingLevelsUp,
+			descended:         true,
+		},
+		IsReversible: false,
+	}, nil
+}
+
+func (cw symlinkTargetComponentWalker) OnTerminal(name bb_path.Component) (*bb_path.GotSymlink, error) {
+	return nil, nil
+}
+
+func (cw symlinkTargetComponentWalker) OnUp() (bb_path.ComponentWalker, error) {
+	if cw.descended {
+		return nil, status.Error(codes.InvalidArgument, "Path contains a \"..\" component after a regular component")
+	}
+	if cw.remainingLevelsUp == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Path resolves to a location outside the archive")
+	}
+	return symlinkTargetComponentWalker{
+		remainingLevelsUp: cw.remainingLevelsUp - 1,
+	}, nil
+}
+
+type archiveHandler struct {
+	contentAddressableStorage blobstore.BlobAccess
+	maximumMessageSizeBytes   int
+	maximumEntries            int
+	maximumDepth              int
+}
+
+// NewArchiveHandler creates a HTTP handler that converts directory
+// hierarchies stored in the Content Addressable Storage (CAS) to
+// archives. This allows users to download the entire contents of a
+// directory (e.g., the output directory of a build action) using a
+// single request. Archives are assembled on the fly.
+//
+// Archives are requested by issuing GET requests against paths of the
+// form "/${instance_name}/${type}/${hash}/${size}.${format}". Type may
+// either be "directory" for REv2 Directory messages or "tree" for REv2
+// Tree messages. Format may either be "tar" or "zip".
+//
+// As directory hierarchies are provided by clients, they are validated
+// before being converted. Requests are rejected if pathname components
+// are invalid, if symbolic links point to locations outside of the
+// archive, or if the archive would contain more than maximumEntries
+// entries or be nested more than maximumDepth levels deep. The latter
+// prevents small directory hierarchies that reference the same
+// directories many times from expanding into huge archives.
+func NewArchiveHandler(contentAddressableStorage blobstore.BlobAccess, maximumMessageSizeBytes, maximumEntries, maximumDepth int) http.Handler {
+	return &archiveHandler{
+		contentAddressableStorage: contentAddressableStorage,
+		maximumMessageSizeBytes:   maximumMessageSizeBytes,
+		maximumEntries:            maximumEntries,
+		maximumDepth:              maximumDepth,
+	}
+}
+
+func (h *archiveHandler) getDirectory(ctx context.Context, directoryDigest digest.Digest) (*remoteexecution.Directory, error) {
+	directory, err := h.contentAddressableStorage.Get(ctx, directoryDigest).ToProto(&remoteexecution.Directory{}, h.maximumMessageSizeBytes)
+	if err != nil {
+		return nil, util.StatusWrapf(err, "Failed to obtain directory %#v", directoryDigest.String())
+	}
+	return directory.(*remoteexecution.Directory), nil
+}
+
+func (h *archiveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the path. Strip the archive format, so that the
+	// remainder can be parsed like a ByteStream read path.
+	extension := path.Ext(r.URL.Path)
+	components := strings.FieldsFunc(strings.TrimSuffix(r.URL.Path, extension), func(r rune) bool { return r == '/' })
+	if len(components) < 3 {
+		http.Error(w, "Path must be of the form /${instance_name}/${type}/${hash}/${size}.${format}", http.StatusBadRequest)
+		return
+	}
+	objectType := components[len(components)-3]
+	components[len(components)-3] = "blobs"
+	rootDigest, err := digest.NewDigestFromByteStreamReadPath(strings.Join(components, "/"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var contentType string
+	var newArchiveWriter func(io.Writer) archiveWriter
+	switch extension {
+	case ".tar":
+		contentType = "application/x-tar"
+		newArchiveWriter = func(w io.Writer) archiveWriter { return tarArchiveWriter{Writer: tar.NewWriter(w)} }
+	case ".zip":
+		contentType = "application/zip"
+		newArchiveWriter = func(w io.Writer) archiveWriter { return zipArchiveWriter{Writer: zip.NewWriter(w)} }
+	default:
+		http.Error(w, "Unknown archive format", http.StatusBadRequest)
+		return
+	}
+
+	// Load the root directory, and the children in case of a Tree.
+	ctx := r.Context()
+	var rootDirectory *remoteexecution.Directory
+	var getDirectory func(directoryDigest digest.Digest) (*remoteexecution.Directory, error)
+	switch objectType {
+	case "directory":
+		if rootDirectory, err = h.getDirectory(ctx, rootDigest); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		// Directories may be referenced many times. Only load
+		// them from the Content Addressable Storage once.
+		directories := map[digest.Digest]*remoteexecution.Directory{}
+		getDirectory = func(directoryDigest digest.Digest) (*remoteexecution.Directory, error) {
+			if directory, ok := directories[directoryDigest]; ok {
+				return directory, nil
+			}
+			directory, err := h.getDirectory(ctx, directoryDigest)
+			if err != nil {
+				return nil, err
+			}
+			directories[directoryDigest] = directory
+			return directory, nil
+		}
+	case "tree":
+		treeMessage, err := h.contentAddressableStorage.Get(ctx, rootDigest).ToProto(&remoteexecution.Tree{}, h.maximumMessageSizeBytes)
+		if err != nil {
+			writeError(w, util.StatusWrap(err, "Failed to obtain tree"))
+			return
+		}
+		tree := treeMessage.(*remoteexecution.Tree)
+		rootDirectory = tree.Root
+
+		// Index the children of the tree by digest.
+		digestFunction := rootDigest.GetDigestFunction()
+		children := map[digest.Digest]*remoteexecution.Directory{}
+		for _, child := range tree.Children {
+			data, err := proto.Marshal(child)
+			if err != nil {
+				writeError(w, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to marshal child directory"))
+				return
+			}
+			generator := digestFunction.NewGenerator()
+			generator.Write(data)
+			children[generator.Sum()] = child
+		}
+		getDirectory = func(directoryDigest digest.Digest) (*remoteexecution.Directory, error) {
+			child, ok := children[directoryDigest]
+			if !ok {
+				return nil, status.Errorf(codes.InvalidArgument, "Tree does not contain directory %#v", directoryDigest.String())
+			}
+			return child, nil
+		}
+	default:
+		http.Error(w, "Unknown object type", http.StatusBadRequest)
+		return
+	}
+
+	// Gather and validate all entries of the archive, prior to
+	// starting the response.
+	var entries []archiveEntry
+	if err := h.gatherEntries(nil, 0, rootDigest.GetInstanceName(), rootDirectory, getDirectory, &entries); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	aw := newArchiveWriter(w)
+
+	// Errors can no longer be reported through the status code, as
+	// the response has already been started. Abort the response
+	// instead, so that clients observe a truncated archive.
+	if err := h.writeEntries(ctx, aw, entries); err != nil {
+		log.Printf("Failed to write archive for %#v: %s", r.URL.Path, err)
+		panic(http.ErrAbortHandler)
+	}
+	if err := aw.Close(); err != nil {
+		log.Printf("Failed to write archive for %#v: %s", r.URL.Path, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// addEntry appends an entry to the list of entries of the archive,
+// failing if the maximum number of entries is exceeded.
+func (h *archiveHandler) addEntry(entries *[]archiveEntry, entry archiveEntry) error {
+	if len(*entries) >= h.maximumEntries {
+		return status.Errorf(codes.InvalidArgument, "Archive contains more than %d entries", h.maximumEntries)
+	}
+	*entries = append(*entries, entry)
+	return nil
+}
+
+// gatherEntries appends the entries of a directory to the list of
+// entries of the archive, recursing into child directories. Names of
+// entries and targets of symbolic links are validated.
+func (h *archiveHandler) gatherEntries(directoryPath *bb_path.Trace, depth int, instanceName digest.InstanceName, directory *remoteexecution.Directory, getDirectory func(digest.Digest) (*remoteexecution.Directory, error), entries *[]archiveEntry) error {
+	for _, file := range directory.Files {
+		component, ok := bb_path.NewComponent(file.Name)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "Directory %#v contains file with invalid name %#v", directoryPath.String(), file.Name)
+		}
+		filePath := directoryPath.Append(component).String()
+		fileDigest, err := instanceName.NewDigestFromProto(file.Digest)
+		if err != nil {
+			return util.StatusWrapf(err, "Failed to extract digest for file %#v", filePath)
+		}
+		if err := h.addEntry(entries, archiveEntry{
+			path:         filePath,
+			fileDigest:   fileDigest,
+			isExecutable: file.IsExecutable,
+		}); err != nil {
+			return err
+		}
+	}
+	for _, symlink := range directory.Symlinks {
+		component, ok := bb_path.NewComponent(symlink.Name)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "Directory %#v contains symbolic link with invalid name %#v", directoryPath.String(), symlink.Name)
+		}
+		symlinkPath := directoryPath.Append(component).String()
+		if err := bb_path.Resolve(symlink.Target, bb_path.NewRelativeScopeWalker(symlinkTargetComponentWalker{remainingLevelsUp: depth})); err != nil {
+			return util.StatusWrapf(err, "Invalid target for symbolic link %#v", symlinkPath)
+		}
+		if err := h.addEntry(entries, archiveEntry{
+			path:          symlinkPath,
+			isSymlink:     true,
+			symlinkTarget: symlink.Target,
+		}); err != nil {
+			return err
+		}
+	}
+	for _, child := range directory.Directories {
+		component, ok := bb_path.NewComponent(child.Name)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "Directory %#v contains directory with invalid name %#v", directoryPath.String(), child.Name)
+		}
+		childPath := directoryPath.Append(component)
+		if depth >= h.maximumDepth {
+			return status.Errorf(codes.InvalidArgument, "Directory %#v is nested more than %d levels deep", childPath.String(), h.maximumDepth)
+		}
+		childDigest, err := instanceName.NewDigestFromProto(child.Digest)
+		if err != nil {
+			return util.StatusWrapf(err, "Failed to extract digest for directory %#v", childPath.String())
+		}
+		childDirectory, err := getDirectory(childDigest)
+		if err != nil {
+			return err
+		}
+		if err := h.addEntry(entries, archiveEntry{
+			path:        childPath.String(),
+			isDirectory: true,
+		}); err != nil {
+			return err
+		}
+		if err := h.gatherEntries(childPath, depth+1, instanceName, childDirectory, getDirectory, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEntries writes previously gathered entries into the archive,
+// loading the contents of files from the Content Addressable Storage.
+func (h *archiveHandler) writeEntries(ctx context.Context, aw archiveWriter, entries []archiveEntry) error {
+	for _, entry := range entries {
+		switch {
+		case entry.isDirectory:
+			if err := aw.addDirectory(entry.path); err != nil {
+				return err
+			}
+		case entry.isSymlink:
+			if err := aw.addSymlink(entry.path, entry.symlinkTarget); err != nil {
+				return err
+			}
+		default:
+			fw, err := aw.addFile(entry.path, entry.isExecutable, entry.fileDigest.GetSizeBytes())
+			if err != nil {
+				return err
+			}
+			if err := h.contentAddressableStorage.Get(ctx, entry.fileDigest).IntoWriter(fw); err != nil {
+				return util.StatusWrapf(err, "Failed to obtain file %#v", entry.path)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package httpservers_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/httpservers"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func computeDigest(data []byte) digest.Digest {
+	hash := sha256.Sum256(data)
+	return digest.MustNewDigest("hello", hex.EncodeToString(hash[:]), int64(len(data)))
+}
+
+func getArchivePath(objectType string, d digest.Digest, format string) string {
+	return fmt.Sprintf("/hello/%s/%s/%d.%s", objectType, d.GetHashString(), d.GetSizeBytes(), format)
+}
+
+func TestArchiveHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	handler := httpservers.NewArchiveHandler(contentAddressableStorage, 1<<16, 10, 2)
+
+	// A directory hierarchy containing a file, a symbolic link and
+	// a subdirectory containing an executable.
+	fileData := []byte("Hello world")
+	fileDigest := computeDigest(fileData)
+	executableData := []byte("#!/bin/sh\n")
+	executableDigest := computeDigest(executableData)
+	childDirectory := &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{
+				Name:         "run.sh",
+				Digest:       executableDigest.GetProto(),
+				IsExecutable: true,
+			},
+		},
+	}
+	childData, err := proto.Marshal(childDirectory)
+	require.NoError(t, err)
+	childDigest := computeDigest(childData)
+	rootDirectory := &remoteexecution.Directory{
+		Files: []*remoteexecution.FileNode{
+			{
+				Name:   "hello.txt",
+				Digest: fileDigest.GetProto(),
+			},
+		},
+		Directories: []*remoteexecution.DirectoryNode{
+			{
+				Name:   "bin",
+				Digest: childDigest.GetProto(),
+			},
+		},
+		Symlinks: []*remoteexecution.SymlinkNode{
+			{
+				Name:   "link",
+				Target: "hello.txt",
+			},
+		},
+	}
+	rootData, err := proto.Marshal(rootDirectory)
+	require.NoError(t, err)
+	rootDigest := computeDigest(rootData)
+
+	t.Run("InvalidPath", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello.tar", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("UnknownArchiveFormat", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getArchivePath("directory", rootDigest, "rar"), nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("RootNotFound", func(t *testing.T) {
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), rootDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getArchivePath("directory", rootDigest, "tar"), nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("DirectoryAsTar", func(t *testing.T) {
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), rootDigest).
+			Return(buffer.NewProtoBufferFromProto(rootDirectory, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), fileDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice(fileData))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), childDigest).
+			Return(buffer.NewProtoBufferFromProto(childDirectory, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), executableDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice(executableData))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getArchivePath("directory", rootDigest, "tar"), nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/x-tar", w.Header().Get("Content-Type"))
+
+		tr := tar.NewReader(w.Body)
+		header, err := tr.Next()
+		require.NoError(t, err)
+		require.Equal(t, "hello.txt", header.Name)
+		require.Equal(t, int64(0o644), header.Mode)
+		data, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		require.Equal(t, fileData, data)
+
+		header, err = tr.Next()
+		require.NoError(t, err)
+		require.Equal(t, "link", header.Name)
+		require.Equal(t, byte(tar.TypeSymlink), header.Typeflag)
+		require.Equal(t, "hello.txt", header.Linkname)
+
+		header, err = tr.Next()
+		require.NoError(t, err)
+		require.Equal(t, "bin/", header.Name)
+		require.Equal(t, byte(tar.TypeDir), header.Typeflag)
+
+		header, err = tr.Next()
+		require.NoError(t, err)
+		require.Equal(t, "bin/run.sh", header.Name)
+		require.Equal(t, int64(0o755), header.Mode)
+		data, err = ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		require.Equal(t, executableData, data)
+
+		_, err = tr.Next()
+		require.Equal(t, io.EOF, err)
+	})
+
+	t.Run("TreeAsZip", func(t *testing.T) {
+		// Directories contained in a Tree should not be loaded
+		// from the Content Addressable Storage separately.
+		tree := &remoteexecution.Tree{
+			Root:     rootDirectory,
+			Children: []*remoteexecution.Directory{childDirectory},
+		}
+		treeData, err := proto.Marshal(tree)
+		require.NoError(t, err)
+		treeDigest := computeDigest(treeData)
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), treeDigest).
+			Return(buffer.NewProtoBufferFromProto(tree, buffer.UserProvided))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), fileDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice(fileData))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), executableDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice(executableData))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getArchivePath("tree", treeDigest, "zip"), nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		require.NoError(t, err)
+		require.Len(t, zr.File, 4)
+		require.Equal(t, "hello.txt", zr.File[0].Name)
+		require.Equal(t, "link", zr.File[1].Name)
+		require.Equal(t, "bin/", zr.File[2].Name)
+		require.Equal(t, "bin/run.sh", zr.File[3].Name)
+		require.Equal(t, 0o755, int(zr.File[3].Mode().Perm()))
+
+		r, err := zr.File[3].Open()
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, executableData, data)
+		require.NoError(t, r.Close())
+	})
+
+	// Requests for directory hierarchies that would yield archives
+	// that are unsafe to extract should be rejected before any data
+	// is written.
+	requireRejected := func(t *testing.T, directory *remoteexecution.Directory, expectedMessage string) {
+		d := computeDigest(mustMarshal(t, directory))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), d).
+			Return(buffer.NewProtoBufferFromProto(directory, buffer.UserProvided))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getArchivePath("directory", d, "tar"), nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Equal(t, expectedMessage+"\n", w.Body.String())
+	}
+
+	t.Run("FileNameParent", func(t *testing.T) {
+		requireRejected(t, &remoteexecution.Directory{
+			Files: []*remoteexecution.FileNode{{Name: "..", Digest: fileDigest.GetProto()}},
+		}, "Directory \".\" contains file with invalid name \"..\"")
+	})
+
+	t.Run("FileNameSlash", func(t *testing.T) {
+		requireRejected(t, &remoteexecution.Directory{
+			Files: []*remoteexecution.FileNode{{Name: "../x", Digest: fileDigest.GetProto()}},
+		}, "Directory \".\" contains file with invalid name \"../x\"")
+	})
+
+	t.Run("DirectoryNameEmpty", func(t *testing.T) {
+		requireRejected(t, &remoteexecution.Directory{
+			Directories: []*remoteexecution.DirectoryNode{{Name: "", Digest: childDigest.GetProto()}},
+		}, "Directory \".\" contains directory with invalid name \"\"")
+	})
+
+	t.Run("SymlinkNameDot", func(t *testing.T) {
+		requireRejected(t, &remoteexecution.Directory{
+			Symlinks: []*remoteexecution.SymlinkNode{{Name: ".", Target: "hello.txt"}},
+		}, "Directory \".\" contains symbolic link with invalid name \".\"")
+	})
+
+	t.Run("SymlinkTargetAbsolute", func(t *testing.T) {
+		requireRejected(t, &remoteexecution.Directory{
+			Symlinks: []*remoteexecution.SymlinkNode{{Name: "link", Target: "/etc/passwd"}},
+		}, "Invalid target for symbolic link \"link\": Path is absolute, while a relative path was expected")
+	})
+
+	t.Run("SymlinkTargetEscapes", func(t *testing.T) {
+		requireRejected(t, &remoteexecution.Directory{
+			Symlinks: []*remoteexecution.SymlinkNode{{Name: "link", Target: "../etc/passwd"}},
+		}, "Invalid target for symbolic link \"link\": Path resolves to a location outside the archive")
+	})
+
+	t.Run("SymlinkTargetParentAfterComponent", func(t *testing.T) {
+		// If "a" were a symbolic link, the ".." components
+		// would be resolved relative to its target.
+		requireRejected(t, &remoteexecution.Directory{
+			Symlinks: []*remoteexecution.SymlinkNode{{Name: "link", Target: "a/../b"}},
+		}, "Invalid target for symbolic link \"link\": Path contains a \"..\" component after a regular component")
+	})
+
+	t.Run("SymlinkTargetParentOfDirectory", func(t *testing.T) {
+		// Symbolic links in subdirectories may point to
+		// locations in parent directories.
+		child := &remoteexecution.Directory{
+			Symlinks: []*remoteexecution.SymlinkNode{{Name: "link", Target: "../hello.txt"}},
+		}
+		childDigest := computeDigest(mustMarshal(t, child))
+		tree := &remoteexecution.Tree{
+			Root: &remoteexecution.Directory{
+				Directories: []*remoteexecution.DirectoryNode{{Name: "dir", Digest: childDigest.GetProto()}},
+			},
+			Children: []*remoteexecution.Directory{child},
+		}
+		treeDigest := computeDigest(mustMarshal(t, tree))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), treeDigest).
+			Return(buffer.NewProtoBufferFromProto(tree, buffer.UserProvided))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getArchivePath("tree", treeDigest, "tar"), nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		tr := tar.NewReader(w.Body)
+		header, err := tr.Next()
+		require.NoError(t, err)
+		require.Equal(t, "dir/", header.Name)
+		header, err = tr.Next()
+		require.NoError(t, err)
+		require.Equal(t, "dir/link", header.Name)
+		require.Equal(t, "../hello.txt", header.Linkname)
+	})
+
+	t.Run("TooManyEntries", func(t *testing.T) {
+		// A directory that references the same child directory
+		// many times should not expand into a large archive.
+		root := &remoteexecution.Directory{}
+		for i := 0; i < 10; i++ {
+			root.Directories = append(root.Directories, &remoteexecution.DirectoryNode{
+				Name:   fmt.Sprintf("dir%d", i),
+				Digest: childDigest.GetProto(),
+			})
+		}
+		tree := &remoteexecution.Tree{
+			Root:     root,
+			Children: []*remoteexecution.Directory{childDirectory},
+		}
+		treeDigest := computeDigest(mustMarshal(t, tree))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), treeDigest).
+			Return(buffer.NewProtoBufferFromProto(tree, buffer.UserProvided))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getArchivePath("tree", treeDigest, "zip"), nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Equal(t, "Archive contains more than 10 entries\n", w.Body.String())
+	})
+
+	t.Run("TooDeep", func(t *testing.T) {
+		level3 := &remoteexecution.Directory{}
+		level3Digest := computeDigest(mustMarshal(t, level3))
+		level2 := &remoteexecution.Directory{
+			Directories: []*remoteexecution.DirectoryNode{{Name: "d", Digest: level3Digest.GetProto()}},
+		}
+		level2Digest := computeDigest(mustMarshal(t, level2))
+		level1 := &remoteexecution.Directory{
+			Directories: []*remoteexecution.DirectoryNode{{Name: "c", Digest: level2Digest.GetProto()}},
+		}
+		level1Digest := computeDigest(mustMarshal(t, level1))
+		tree := &remoteexecution.Tree{
+			Root: &remoteexecution.Directory{
+				Directories: []*remoteexecution.DirectoryNode{{Name: "b", Digest: level1Digest.GetProto()}},
+			},
+			Children: []*remoteexecution.Directory{level1, level2, level3},
+		}
+		treeDigest := computeDigest(mustMarshal(t, tree))
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), treeDigest).
+			Return(buffer.NewProtoBufferFromProto(tree, buffer.UserProvided))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getArchivePath("tree", treeDigest, "tar"), nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Equal(t, "Directory \"b/c/d\" is nested more than 2 levels deep\n", w.Body.String())
+	})
+}
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	data, err := proto.Marshal(m)
+	require.NoError(t, err)
+	return data
+}
@@ -0,0 +1,94 @@
+package httpservers
+
+import (
+	"net/http"
+	"strings"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+type blobInspectionHandler struct {
+	contentAddressableStorage blobstore.BlobAccess
+	actionCache               blobstore.BlobAccess
+	maximumMessageSizeBytes   int
+}
+
+// NewBlobInspectionHandler creates a HTTP handler that loads REv2
+// messages from the Content Addressable Storage (CAS) or Action Cache
+// (AC) and renders them as JSON. This makes it possible to inspect
+// the contents of storage while debugging, without needing to
+// download and decode objects by hand.
+//
+// Messages are requested by issuing GET requests against paths of the
+// form "/${instance_name}/${type}/${hash}/${size}". Type may either be
+// "action", "command", "directory" or "tree" for messages stored in
+// the CAS, or "action_result" for messages stored in the AC. In the
+// latter case, the digest is that of the action.
+func NewBlobInspectionHandler(contentAddressableStorage, actionCache blobstore.BlobAccess, maximumMessageSizeBytes int) http.Handler {
+	return &blobInspectionHandler{
+		contentAddressableStorage: contentAddressableStorage,
+		actionCache:               actionCache,
+		maximumMessageSizeBytes:   maximumMessageSizeBytes,
+	}
+}
+
+func (h *blobInspectionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the path. Replace the type by "blobs", so that it can
+	// be parsed like a ByteStream read path.
+	components := strings.FieldsFunc(r.URL.Path, func(r rune) bool { return r == '/' })
+	if len(components) < 3 {
+		http.Error(w, "Path must be of the form /${instance_name}/${type}/${hash}/${size}", http.StatusBadRequest)
+		return
+	}
+	objectType := components[len(components)-3]
+	components[len(components)-3] = "blobs"
+	blobDigest, err := digest.NewDigestFromByteStreamReadPath(strings.Join(components, "/"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	blobAccess := h.contentAddressableStorage
+	var message proto.Message
+	switch objectType {
+	case "action":
+		message = &remoteexecution.Action{}
+	case "action_result":
+		blobAccess = h.actionCache
+		message = &remoteexecution.ActionResult{}
+	case "command":
+		message = &remoteexecution.Command{}
+	case "directory":
+		message = &remoteexecution.Directory{}
+	case "tree":
+		message = &remoteexecution.Tree{}
+	default:
+		http.Error(w, "Unknown object type", http.StatusBadRequest)
+		return
+	}
+
+	message, err = blobAccess.Get(r.Context(), blobDigest).ToProto(message, h.maximumMessageSizeBytes)
+	if err != nil {
+		writeError(w, util.StatusWrapf(err, "Failed to obtain %s", objectType))
+		return
+	}
+	data, err := protojson.MarshalOptions{Multiline: true}.Marshal(message)
+	if err != nil {
+		writeError(w, util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal message"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
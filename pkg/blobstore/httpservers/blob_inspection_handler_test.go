@@ -0,0 +1,111 @@
+package httpservers_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/httpservers"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func getInspectionPath(objectType string, d digest.Digest) string {
+	return fmt.Sprintf("/hello/%s/%s/%d", objectType, d.GetHashString(), d.GetSizeBytes())
+}
+
+func TestBlobInspectionHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	actionCache := mock.NewMockBlobAccess(ctrl)
+	handler := httpservers.NewBlobInspectionHandler(contentAddressableStorage, actionCache, 1<<16)
+
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("InvalidMethod", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, getInspectionPath("action", blobDigest), nil))
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+
+	t.Run("InvalidPath", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("UnknownObjectType", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getInspectionPath("file", blobDigest), nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), blobDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getInspectionPath("command", blobDigest), nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Equal(t, "Failed to obtain command: Object not found\n", w.Body.String())
+	})
+
+	t.Run("Command", func(t *testing.T) {
+		command := &remoteexecution.Command{
+			Arguments: []string{"cc", "-o", "hello", "hello.c"},
+			EnvironmentVariables: []*remoteexecution.Command_EnvironmentVariable{
+				{Name: "PATH", Value: "/bin:/usr/bin"},
+			},
+		}
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), blobDigest).
+			Return(buffer.NewProtoBufferFromProto(command, buffer.UserProvided))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getInspectionPath("command", blobDigest), nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var rendered remoteexecution.Command
+		require.NoError(t, protojson.Unmarshal(w.Body.Bytes(), &rendered))
+		testutil.RequireEqualProto(t, command, &rendered)
+	})
+
+	t.Run("ActionResult", func(t *testing.T) {
+		// Action results should be loaded from the Action
+		// Cache, using the digest of the action.
+		actionResult := &remoteexecution.ActionResult{
+			ExitCode:  1,
+			StderrRaw: []byte("hello.c: No such file or directory"),
+		}
+		actionCache.EXPECT().Get(gomock.Any(), blobDigest).
+			Return(buffer.NewProtoBufferFromProto(actionResult, buffer.UserProvided))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getInspectionPath("action_result", blobDigest), nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var rendered remoteexecution.ActionResult
+		require.NoError(t, protojson.Unmarshal(w.Body.Bytes(), &rendered))
+		testutil.RequireEqualProto(t, actionResult, &rendered)
+	})
+
+	t.Run("MalformedMessage", func(t *testing.T) {
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("\xff\xff\xff\xff\xff")))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, getInspectionPath("directory", blobDigest), nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,260 @@
+package httpservers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type remoteCacheHandler struct {
+	contentAddressableStorage blobstore.BlobAccess
+	actionCache               blobstore.BlobAccess
+	maximumMessageSizeBytes   int
+	readChunkSize             int
+
+	lock            sync.Mutex
+	sizesCacheSize  int
+	sizes           map[string]int64
+	sizeEvictionSet eviction.Set
+}
+
+// NewRemoteCacheHandler creates a HTTP handler that implements Bazel's
+// HTTP caching protocol. This allows clients that do not support the
+// Remote Execution API (e.g., tools that only support
+// --remote_cache=https://...) to make use of the same storage backends.
+//
+// Objects are accessed by issuing GET, HEAD and PUT requests against
+// paths of the form "/${instance_name}/ac/${hash}" and
+// "/${instance_name}/cas/${hash}". The instance name may be empty.
+//
+// Unlike the Remote Execution API, this protocol does not provide the
+// size of objects. Clients that know the size of objects may append it
+// to the path (i.e., "/${instance_name}/cas/${hash}/${size}"), in which
+// case objects are accessed using the same digests as the Remote
+// Execution API. For the Action Cache, the size is that of the Action
+// message. This permits sharing objects with clients that use the
+// Remote Execution API, regardless of how they were stored.
+//
+// If no size is provided, sizes of objects in the Content Addressable
+// Storage are looked up in an in-memory cache of sizes of objects
+// uploaded through this handler. Objects whose size is unknown are
+// reported as being absent. Action Cache entries are accessed using a
+// size of zero, meaning they can only be shared with clients that
+// don't provide sizes either.
+//
+// See: https://docs.bazel.build/versions/master/remote-caching.html#http-caching-protocol
+func NewRemoteCacheHandler(contentAddressableStorage, actionCache blobstore.BlobAccess, maximumMessageSizeBytes, readChunkSize, sizesCacheSize int, sizeEvictionSet eviction.Set) http.Handler {
+	return &remoteCacheHandler{
+		contentAddressableStorage: contentAddressableStorage,
+		actionCache:               actionCache,
+		maximumMessageSizeBytes:   maximumMessageSizeBytes,
+		readChunkSize:             readChunkSize,
+
+		sizesCacheSize:  sizesCacheSize,
+		sizes:           map[string]int64{},
+		sizeEvictionSet: sizeEvictionSet,
+	}
+}
+
+func (h *remoteCacheHandler) getSize(key string) (int64, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	sizeBytes, ok := h.sizes[key]
+	if ok {
+		h.sizeEvictionSet.Touch(key)
+	}
+	return sizeBytes, ok
+}
+
+func (h *remoteCacheHandler) putSize(key string, sizeBytes int64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, ok := h.sizes[key]; ok {
+		h.sizeEvictionSet.Touch(key)
+	} else {
+		// Free up space to insert the size.
+		if len(h.sizes) >= h.sizesCacheSize {
+			delete(h.sizes, h.sizeEvictionSet.Peek())
+			h.sizeEvictionSet.Remove()
+		}
+		h.sizeEvictionSet.Insert(key)
+	}
+	h.sizes[key] = sizeBytes
+}
+
+func isStorageType(component string) bool {
+	return component == "ac" || component == "cas"
+}
+
+func (h *remoteCacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Split the path into an instance name, storage type, hash and
+	// optional size.
+	components := strings.FieldsFunc(r.URL.Path, func(r rune) bool { return r == '/' })
+	sizeBytes := int64(-1)
+	if n := len(components); n >= 3 && !isStorageType(components[n-2]) && isStorageType(components[n-3]) {
+		size, err := strconv.ParseInt(components[n-1], 10, 64)
+		if err != nil || size < 0 {
+			http.Error(w, "Invalid object size", http.StatusBadRequest)
+			return
+		}
+		sizeBytes = size
+		components = components[:n-1]
+	}
+	if len(components) < 2 {
+		http.Error(w, "Path must be of the form /${instance_name}/{ac,cas}/${hash}[/${size}]", http.StatusBadRequest)
+		return
+	}
+	instanceName, err := digest.NewInstanceName(strings.Join(components[:len(components)-2], "/"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	storageType, hash := components[len(components)-2], components[len(components)-1]
+	sizeKey := instanceName.String() + "/" + hash
+
+	var blobAccess blobstore.BlobAccess
+	switch storageType {
+	case "ac":
+		blobAccess = h.actionCache
+	case "cas":
+		blobAccess = h.contentAddressableStorage
+	default:
+		http.Error(w, "Unknown storage type", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if sizeBytes < 0 {
+			if storageType == "cas" {
+				var ok bool
+				if sizeBytes, ok = h.getSize(sizeKey); !ok {
+					http.Error(w, "Object not found", http.StatusNotFound)
+					return
+				}
+			} else {
+				sizeBytes = 0
+			}
+		}
+		blobDigest, err := instanceName.NewDigest(hash, sizeBytes)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if r.Method == http.MethodHead {
+			missing, err := blobAccess.FindMissing(r.Context(), blobDigest.ToSingletonSet())
+			if err != nil {
+				writeError(w, err)
+			} else if missing.Empty() {
+				w.WriteHeader(http.StatusOK)
+			} else {
+				http.Error(w, "Object not found", http.StatusNotFound)
+			}
+			return
+		}
+
+		// Only write response headers after the first chunk of
+		// data has been read successfully, so that errors can
+		// still be reported properly.
+		b := blobAccess.Get(r.Context(), blobDigest)
+		if storageType == "cas" {
+			w.Header().Set("Content-Length", strconv.FormatInt(sizeBytes, 10))
+		} else if actionResultSizeBytes, err := b.GetSizeBytes(); err == nil {
+			w.Header().Set("Content-Length", strconv.FormatInt(actionResultSizeBytes, 10))
+		}
+		chunkReader := b.ToChunkReader(0, h.readChunkSize)
+		defer chunkReader.Close()
+		chunk, err := chunkReader.Read()
+		if err != nil && err != io.EOF {
+			w.Header().Del("Content-Length")
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		for err == nil {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			chunk, err = chunkReader.Read()
+		}
+		if err != io.EOF {
+			log.Printf("Failed to read %s: %s", blobDigest, err)
+		}
+	case http.MethodPut:
+		if r.ContentLength < 0 {
+			http.Error(w, "Content-Length must be provided", http.StatusLengthRequired)
+			return
+		}
+		var b buffer.Buffer
+		var blobDigest digest.Digest
+		if storageType == "cas" {
+			if sizeBytes >= 0 && sizeBytes != r.ContentLength {
+				http.Error(w, "Content-Length does not match the size in the path", http.StatusBadRequest)
+				return
+			}
+			blobDigest, err = instanceName.NewDigest(hash, r.ContentLength)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			b = buffer.NewCASBufferFromReader(blobDigest, r.Body, buffer.UserProvided)
+		} else {
+			if r.ContentLength > int64(h.maximumMessageSizeBytes) {
+				http.Error(w, "Action result is too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if sizeBytes < 0 {
+				sizeBytes = 0
+			}
+			blobDigest, err = instanceName.NewDigest(hash, sizeBytes)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			b = buffer.NewProtoBufferFromReader(&remoteexecution.ActionResult{}, r.Body, buffer.UserProvided)
+		}
+		if err := blobAccess.Put(r.Context(), blobDigest, b); err != nil {
+			writeError(w, err)
+			return
+		}
+		if storageType == "cas" {
+			h.putSize(sizeKey, r.ContentLength)
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeError converts a gRPC error to a HTTP response.
+func writeError(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+	code := http.StatusInternalServerError
+	switch s.Code() {
+	case codes.InvalidArgument:
+		code = http.StatusBadRequest
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+	case codes.Unavailable:
+		code = http.StatusServiceUnavailable
+	}
+	http.Error(w, s.Message(), code)
+}
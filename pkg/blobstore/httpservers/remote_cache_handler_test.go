@@ -0,0 +1,168 @@
+package httpservers_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/httpservers"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRemoteCacheHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	contentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	actionCache := mock.NewMockBlobAccess(ctrl)
+	handler := httpservers.NewRemoteCacheHandler(contentAddressableStorage, actionCache, 1<<16, 1<<16, 10, eviction.NewLRUSet())
+
+	blobDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("InvalidPath", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello/foo/3e25960a79dbc69b674cd4ec67a72c62", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("GetUnknownSize", func(t *testing.T) {
+		// Objects that have not been uploaded through this
+		// handler have an unknown size.
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello/cas/3e25960a79dbc69b674cd4ec67a72c62", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("PutFailure", func(t *testing.T) {
+		contentAddressableStorage.EXPECT().Put(gomock.Any(), blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Unavailable, "Server not reachable")
+			})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/hello/cas/3e25960a79dbc69b674cd4ec67a72c62", bytes.NewBufferString("Hello world")))
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("PutSuccess", func(t *testing.T) {
+		contentAddressableStorage.EXPECT().Put(gomock.Any(), blobDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello world"), data)
+				return nil
+			})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/hello/cas/3e25960a79dbc69b674cd4ec67a72c62", bytes.NewBufferString("Hello world")))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		// Now that the object has been uploaded, its size is
+		// known.
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), blobDigest).Return(
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello/cas/3e25960a79dbc69b674cd4ec67a72c62", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "11", w.Header().Get("Content-Length"))
+		require.Equal(t, "Hello world", w.Body.String())
+	})
+
+	t.Run("GetFailure", func(t *testing.T) {
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), blobDigest).Return(
+			buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello/cas/3e25960a79dbc69b674cd4ec67a72c62", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Head", func(t *testing.T) {
+		contentAddressableStorage.EXPECT().FindMissing(gomock.Any(), blobDigest.ToSingletonSet()).Return(digest.EmptySet, nil)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/hello/cas/3e25960a79dbc69b674cd4ec67a72c62", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("GetWithSize", func(t *testing.T) {
+		// If the client provides the size of the object, the
+		// object can be downloaded even if it was not uploaded
+		// through this handler.
+		otherDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+		contentAddressableStorage.EXPECT().Get(gomock.Any(), otherDigest).Return(
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello/cas/8b1a9953c4611296a827abf8c47804d7/5", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "5", w.Header().Get("Content-Length"))
+		require.Equal(t, "Hello", w.Body.String())
+	})
+
+	t.Run("InvalidSize", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello/cas/8b1a9953c4611296a827abf8c47804d7/-5", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("PutSizeMismatch", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/hello/cas/3e25960a79dbc69b674cd4ec67a72c62/12", bytes.NewBufferString("Hello world")))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("ActionCache", func(t *testing.T) {
+		// Action Cache entries should be stored with a size of
+		// zero. Action results should be validated.
+		actionDigest := digest.MustNewDigest("", "8b1a9953c4611296a827abf8c47804d7", 0)
+		actionCache.EXPECT().Get(gomock.Any(), actionDigest).Return(
+			buffer.NewValidatedBufferFromByteSlice([]byte{0x20, 0x01}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ac/8b1a9953c4611296a827abf8c47804d7", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, []byte{0x20, 0x01}, w.Body.Bytes())
+
+		actionCache.EXPECT().Put(gomock.Any(), actionDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				_, err := b.ToByteSlice(100)
+				return err
+			})
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/ac/8b1a9953c4611296a827abf8c47804d7", bytes.NewBufferString("Not a valid Protobuf")))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("ActionCacheWithSize", func(t *testing.T) {
+		// If the client provides the size of the Action message,
+		// entries are stored under the same digest as used by
+		// the Remote Execution API.
+		actionDigest := digest.MustNewDigest("", "8b1a9953c4611296a827abf8c47804d7", 123)
+		actionCache.EXPECT().Put(gomock.Any(), actionDigest, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte{0x20, 0x01}, data)
+				return nil
+			})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/ac/8b1a9953c4611296a827abf8c47804d7/123", bytes.NewBuffer([]byte{0x20, 0x01})))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+}
@@ -0,0 +1,82 @@
+package httpservers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	cloud_aws "github.com/buildbarn/bb-storage/pkg/cloud/aws"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/icas"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+)
+
+type signedURLHandler struct {
+	indirectContentAddressableStorage blobstore.BlobAccess
+	s3                                cloud_aws.S3
+	expiration                        time.Duration
+	maximumMessageSizeBytes           int
+}
+
+// NewSignedURLHandler creates a HTTP handler that redirects clients to
+// short-lived signed URLs, allowing objects stored in S3 to be
+// downloaded directly. This prevents large objects from needing to be
+// transferred through Buildbarn.
+//
+// Objects are requested by issuing GET requests against paths that use
+// the same format as ByteStream read requests (i.e.,
+// "/${instance_name}/blobs/${hash}/${size}"). The location of the
+// object is obtained by loading its Reference from the Indirect
+// Content Addressable Storage (ICAS). Only references to uncompressed
+// S3 objects that start at offset zero can be served, as these are
+// assumed to refer to entire S3 objects. For other objects, HTTP 404
+// is returned, meaning the client needs to fall back to downloading the
+// object through the Content Addressable Storage.
+func NewSignedURLHandler(indirectContentAddressableStorage blobstore.BlobAccess, s3 cloud_aws.S3, expiration time.Duration, maximumMessageSizeBytes int) http.Handler {
+	return &signedURLHandler{
+		indirectContentAddressableStorage: indirectContentAddressableStorage,
+		s3:                                s3,
+		expiration:                        expiration,
+		maximumMessageSizeBytes:           maximumMessageSizeBytes,
+	}
+}
+
+func (h *signedURLHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	blobDigest, err := digest.NewDigestFromByteStreamReadPath(strings.TrimPrefix(r.URL.Path, "/"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	referenceMessage, err := h.indirectContentAddressableStorage.Get(r.Context(), blobDigest).ToProto(&icas.Reference{}, h.maximumMessageSizeBytes)
+	if err != nil {
+		writeError(w, util.StatusWrap(err, "Failed to load reference"))
+		return
+	}
+	reference := referenceMessage.(*icas.Reference)
+	medium, ok := reference.Medium.(*icas.Reference_S3_)
+	if !ok || reference.OffsetBytes != 0 || reference.Decompressor != icas.Reference_NONE {
+		http.Error(w, "Object cannot be downloaded directly", http.StatusNotFound)
+		return
+	}
+
+	req, _ := h.s3.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(medium.S3.Bucket),
+		Key:    aws.String(medium.S3.Key),
+	})
+	signedURL, err := req.Presign(h.expiration)
+	if err != nil {
+		writeError(w, util.StatusWrapWithCode(err, codes.Internal, "Failed to sign URL"))
+		return
+	}
+	http.Redirect(w, r, signedURL, http.StatusTemporaryRedirect)
+}
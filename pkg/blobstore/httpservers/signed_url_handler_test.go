@@ -0,0 +1,87 @@
+package httpservers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/httpservers"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/proto/icas"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSignedURLHandler(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	indirectContentAddressableStorage := mock.NewMockBlobAccess(ctrl)
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("eu-west-1"),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	})
+	require.NoError(t, err)
+	handler := httpservers.NewSignedURLHandler(indirectContentAddressableStorage, s3.New(sess), time.Minute, 1000)
+
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("InvalidPath", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello/foo", nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("ReferenceNotFound", func(t *testing.T) {
+		indirectContentAddressableStorage.EXPECT().Get(gomock.Any(), blobDigest).Return(
+			buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello/blobs/8b1a9953c4611296a827abf8c47804d7/5", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("UnsupportedMedium", func(t *testing.T) {
+		// Objects that are not stored in S3 cannot be
+		// downloaded directly.
+		indirectContentAddressableStorage.EXPECT().Get(gomock.Any(), blobDigest).Return(
+			buffer.NewProtoBufferFromProto(&icas.Reference{
+				Medium:    &icas.Reference_HttpUrl{HttpUrl: "http://example.com/file"},
+				SizeBytes: 5,
+			}, buffer.UserProvided))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello/blobs/8b1a9953c4611296a827abf8c47804d7/5", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		indirectContentAddressableStorage.EXPECT().Get(gomock.Any(), blobDigest).Return(
+			buffer.NewProtoBufferFromProto(&icas.Reference{
+				Medium: &icas.Reference_S3_{
+					S3: &icas.Reference_S3{
+						Bucket: "mybucket",
+						Key:    "mykey",
+					},
+				},
+				SizeBytes: 5,
+			}, buffer.UserProvided))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello/blobs/8b1a9953c4611296a827abf8c47804d7/5", nil))
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		location := w.Header().Get("Location")
+		require.True(t, strings.HasPrefix(location, "https://mybucket.s3.eu-west-1.amazonaws.com/mykey?"), location)
+		require.Contains(t, location, "X-Amz-Expires=60")
+	})
+}
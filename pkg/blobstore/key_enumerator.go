@@ -0,0 +1,24 @@
+package blobstore
+
+import (
+	"context"
+)
+
+// KeyEnumerator is implemented by backends that are capable of listing
+// the keys of all objects stored within them. It is used by decorators
+// such as BloomFilterBlobAccess to construct an index of the contents
+// of the backend.
+type KeyEnumerator interface {
+	// EnumerateKeys calls the provided function for every object
+	// stored in the backend. Keys are formatted according to the
+	// digest.KeyFormat used by the backend. Objects that are
+	// written concurrently may or may not be reported.
+	EnumerateKeys(ctx context.Context, f func(key string) error) error
+}
+
+// EnumerableBlobAccess is a BlobAccess that is also capable of listing
+// the keys of all objects stored within it.
+type EnumerableBlobAccess interface {
+	BlobAccess
+	KeyEnumerator
+}
@@ -0,0 +1,142 @@
+package local
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	automaticallyResizingKeyLocationMapPrometheusMetrics sync.Once
+
+	automaticallyResizingKeyLocationMapRecordsCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "automatically_resizing_key_location_map_records_count",
+			Help:      "Number of slots in the hash table managed by AutomaticallyResizingKeyLocationMap",
+		},
+		[]string{"name"})
+)
+
+// AutomaticallyResizingKeyLocationMap is a KeyLocationMap that is
+// backed by a hash table stored in a GrowableLocationRecordArray. When
+// the estimated load factor of the hash table exceeds a threshold, the
+// array is grown, and records are migrated to their new slots using
+// ResizingKeyLocationMap.
+//
+// This makes it possible to start off with a small key-location map,
+// without the risk of losing entries due to excessive collisions once
+// the number of objects stored grows.
+type AutomaticallyResizingKeyLocationMap struct {
+	recordArray         GrowableLocationRecordArray
+	maximumRecordsCount int
+	maximumLoadFactor   float64
+	hashInitialization  uint64
+	maximumGetAttempts  uint32
+	maximumPutAttempts  int
+	name                string
+
+	recordsCount   int
+	keyLocationMap KeyLocationMap
+	hashTable      *hashingKeyLocationMap
+	resizing       *ResizingKeyLocationMap
+
+	recordsCountGauge prometheus.Gauge
+}
+
+// NewAutomaticallyResizingKeyLocationMap creates a new
+// AutomaticallyResizingKeyLocationMap. The hash table initially has a
+// size of recordsCount. Every time the load factor exceeds
+// maximumLoadFactor, its size is doubled, until maximumRecordsCount is
+// reached.
+func NewAutomaticallyResizingKeyLocationMap(recordArray GrowableLocationRecordArray, recordsCount, maximumRecordsCount int, maximumLoadFactor float64, hashInitialization uint64, maximumGetAttempts uint32, maximumPutAttempts int, name string) *AutomaticallyResizingKeyLocationMap {
+	automaticallyResizingKeyLocationMapPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(automaticallyResizingKeyLocationMapRecordsCount)
+	})
+
+	hashTable := NewHashingKeyLocationMap(recordArray, recordsCount, hashInitialization, maximumGetAttempts, maximumPutAttempts, name).(*hashingKeyLocationMap)
+	klm := &AutomaticallyResizingKeyLocationMap{
+		recordArray:         recordArray,
+		maximumRecordsCount: maximumRecordsCount,
+		maximumLoadFactor:   maximumLoadFactor,
+		hashInitialization:  hashInitialization,
+		maximumGetAttempts:  maximumGetAttempts,
+		maximumPutAttempts:  maximumPutAttempts,
+		name:                name,
+
+		recordsCount:   recordsCount,
+		keyLocationMap: hashTable,
+		hashTable:      hashTable,
+
+		recordsCountGauge: automaticallyResizingKeyLocationMapRecordsCount.WithLabelValues(name),
+	}
+	klm.recordsCountGauge.Set(float64(recordsCount))
+	return klm
+}
+
+// Get the location of an object.
+func (klm *AutomaticallyResizingKeyLocationMap) Get(key Key) (Location, error) {
+	return klm.keyLocationMap.Get(key)
+}
+
+// Put the location of an object.
+func (klm *AutomaticallyResizingKeyLocationMap) Put(key Key, location Location) error {
+	return klm.keyLocationMap.Put(key, location)
+}
+
+// Delete the entry for an object.
+func (klm *AutomaticallyResizingKeyLocationMap) Delete(key Key) error {
+	return klm.keyLocationMap.Delete(key)
+}
+
+// GetRecordsCount returns the current size of the hash table.
+func (klm *AutomaticallyResizingKeyLocationMap) GetRecordsCount() int {
+	return klm.recordsCount
+}
+
+// GrowAndMigrateRecords grows the hash table if its load factor
+// exceeds the configured threshold. If the hash table was grown
+// previously, up to a given number of records are migrated to their
+// new slots instead. It returns true if migration of records is still
+// in progress, meaning that this function should be called again
+// immediately.
+//
+// Calls to this function need to be synchronized with calls to Put()
+// and Get(). The caller must hold a lock that excludes both. As growing
+// the hash table requires copying all of its records, this function
+// may hold up other operations for some time.
+func (klm *AutomaticallyResizingKeyLocationMap) GrowAndMigrateRecords(count int) (bool, error) {
+	if klm.resizing != nil {
+		done, err := klm.resizing.MigrateRecords(count)
+		if err != nil {
+			return true, err
+		}
+		if done {
+			klm.resizing = nil
+		}
+		return !done, nil
+	}
+
+	if klm.hashTable.getLoadFactor() <= klm.maximumLoadFactor || klm.recordsCount >= klm.maximumRecordsCount {
+		return false, nil
+	}
+	newRecordsCount := klm.recordsCount * 2
+	if newRecordsCount > klm.maximumRecordsCount {
+		newRecordsCount = klm.maximumRecordsCount
+	}
+	klm.recordArray.Grow(newRecordsCount)
+	klm.resizing = NewResizingKeyLocationMap(
+		klm.recordArray,
+		klm.recordsCount,
+		newRecordsCount,
+		klm.hashInitialization,
+		klm.maximumGetAttempts,
+		klm.maximumPutAttempts,
+		klm.name)
+	klm.recordsCount = newRecordsCount
+	klm.keyLocationMap = klm.resizing
+	klm.hashTable = klm.resizing.newKeyLocationMap
+	klm.recordsCountGauge.Set(float64(newRecordsCount))
+	return true, nil
+}
@@ -0,0 +1,81 @@
+package local_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutomaticallyResizingKeyLocationMap(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Let BlockReferences simply be the block index, offset by
+	// one. This causes zero initialized records to be invalid.
+	resolver := mock.NewMockBlockReferenceResolver(ctrl)
+	resolver.EXPECT().BlockIndexToBlockReference(gomock.Any()).DoAndReturn(
+		func(blockIndex int) (local.BlockReference, uint64) {
+			return local.BlockReference{EpochID: uint32(blockIndex + 1)}, 0
+		}).AnyTimes()
+	resolver.EXPECT().BlockReferenceToBlockIndex(gomock.Any()).DoAndReturn(
+		func(blockReference local.BlockReference) (int, uint64, bool) {
+			if blockReference.EpochID == 0 {
+				return 0, 0, false
+			}
+			return int(blockReference.EpochID - 1), 0, true
+		}).AnyTimes()
+	array := local.NewInMemoryLocationRecordArray(16, resolver)
+	klm := local.NewAutomaticallyResizingKeyLocationMap(array, 16, 32, 0.001, 0x970aef1f90c7f916, 8, 32, "cas")
+
+	// As long as the hash table is empty, there is no need to grow
+	// it.
+	migrating, err := klm.GrowAndMigrateRecords(1)
+	require.NoError(t, err)
+	require.False(t, migrating)
+	require.Equal(t, 16, klm.GetRecordsCount())
+
+	// Fill the hash table, so that collisions start to occur. This
+	// should cause the estimated load factor to exceed the
+	// threshold.
+	locations := map[local.Key]local.Location{}
+	for i := 0; i < 12; i++ {
+		key := local.NewKeyFromString(fmt.Sprintf("Key %d", i))
+		location := local.Location{BlockIndex: 1, OffsetBytes: int64(i) * 100, SizeBytes: 100}
+		require.NoError(t, klm.Put(key, location))
+		locations[key] = location
+	}
+
+	// The hash table should be grown. All entries should remain
+	// accessible while records are migrated.
+	migrating, err = klm.GrowAndMigrateRecords(1)
+	require.NoError(t, err)
+	require.True(t, migrating)
+	require.Equal(t, 32, klm.GetRecordsCount())
+	for migrating {
+		for key, location := range locations {
+			foundLocation, err := klm.Get(key)
+			require.NoError(t, err)
+			require.Equal(t, location, foundLocation)
+		}
+		migrating, err = klm.GrowAndMigrateRecords(4)
+		require.NoError(t, err)
+	}
+	for key, location := range locations {
+		foundLocation, err := klm.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, location, foundLocation)
+	}
+
+	// The hash table should not grow beyond the maximum size.
+	for i := 12; i < 24; i++ {
+		key := local.NewKeyFromString(fmt.Sprintf("Key %d", i))
+		require.NoError(t, klm.Put(key, local.Location{BlockIndex: 1, OffsetBytes: int64(i) * 100, SizeBytes: 100}))
+	}
+	migrating, err = klm.GrowAndMigrateRecords(1)
+	require.NoError(t, err)
+	require.False(t, migrating)
+	require.Equal(t, 32, klm.GetRecordsCount())
+}
@@ -0,0 +1,104 @@
+package local
+
+import (
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	blobChecksumScrubberPrometheusMetrics sync.Once
+
+	blobChecksumScrubberBlobsScrubbed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "blob_checksum_scrubber_blobs_scrubbed_total",
+			Help:      "Number of blobs whose checksums were validated by BlobChecksumScrubber",
+		},
+		[]string{"name", "result"})
+)
+
+// BlobChecksumScrubber validates the checksums of blobs stored by a
+// BlockList created using NewChecksummingBlockList() in the
+// background. It iterates over all entries in a LocationRecordArray,
+// so that it only validates blobs that are still referenced.
+//
+// When a checksum mismatch is detected, the block containing the blob
+// is released by OldCurrentNewLocationBlobMap, in the same way as if
+// data corruption was detected during a read. This prevents corrupted
+// data from being served to clients.
+type BlobChecksumScrubber struct {
+	locationRecordArray LocationRecordArray
+	recordsCount        func() int
+	locationBlobMap     *OldCurrentNewLocationBlobMap
+	lock                *ShardedRWMutex
+
+	nextIndex int
+
+	blobsValid   prometheus.Counter
+	blobsCorrupt prometheus.Counter
+	blobsFailed  prometheus.Counter
+}
+
+// NewBlobChecksumScrubber creates a new BlobChecksumScrubber that
+// validates the blobs referenced by a LocationRecordArray. The size of
+// the LocationRecordArray is obtained by calling recordsCount while
+// holding a read lock, as it may grow over time.
+func NewBlobChecksumScrubber(locationRecordArray LocationRecordArray, recordsCount func() int, locationBlobMap *OldCurrentNewLocationBlobMap, lock *ShardedRWMutex, name string) *BlobChecksumScrubber {
+	blobChecksumScrubberPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(blobChecksumScrubberBlobsScrubbed)
+	})
+
+	return &BlobChecksumScrubber{
+		locationRecordArray: locationRecordArray,
+		recordsCount:        recordsCount,
+		locationBlobMap:     locationBlobMap,
+		lock:                lock,
+
+		blobsValid:   blobChecksumScrubberBlobsScrubbed.WithLabelValues(name, "Valid"),
+		blobsCorrupt: blobChecksumScrubberBlobsScrubbed.WithLabelValues(name, "Corrupt"),
+		blobsFailed:  blobChecksumScrubberBlobsScrubbed.WithLabelValues(name, "Failed"),
+	}
+}
+
+// ScrubRecords validates the blobs referenced by the next count entries
+// in the LocationRecordArray. Once the end of the LocationRecordArray
+// is reached, scrubbing continues at the start.
+//
+// This function is not thread-safe. It should be called repeatedly
+// from a single goroutine, without holding any locks.
+func (s *BlobChecksumScrubber) ScrubRecords(count int) error {
+	for i := 0; i < count; i++ {
+		// Only hold the lock while obtaining the blob. The
+		// buffer that is returned holds a reference to the
+		// block, meaning it remains valid after unlocking.
+		s.lock.RLock()
+		index := s.nextIndex % s.recordsCount()
+		s.nextIndex = index + 1
+		record, err := s.locationRecordArray.Get(index)
+		if err == ErrLocationRecordInvalid {
+			s.lock.RUnlock()
+			continue
+		} else if err != nil {
+			s.lock.RUnlock()
+			return util.StatusWrapf(err, "Failed to get record at index %d", index)
+		}
+		b, dataIntegrityCallback := s.locationBlobMap.GetRaw(record.Location, record.Location.SizeBytes+BlobChecksumSizeBytes)
+		s.lock.RUnlock()
+
+		valid, err := validateBlobChecksum(b, record.Location.SizeBytes)
+		if err != nil {
+			s.blobsFailed.Inc()
+			return util.StatusWrapf(err, "Failed to read blob referenced by record at index %d", index)
+		}
+		if valid {
+			s.blobsValid.Inc()
+		} else {
+			s.blobsCorrupt.Inc()
+			dataIntegrityCallback(false)
+		}
+	}
+	return nil
+}
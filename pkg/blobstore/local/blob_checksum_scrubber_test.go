@@ -0,0 +1,73 @@
+package local_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBlobChecksumScrubber(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	blockList := mock.NewMockBlockList(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	locationBlobMap := local.NewOldCurrentNewLocationBlobMap(
+		blockList,
+		errorLogger,
+		"cas",
+		/* blockSizeBytes = */ 100,
+		/* oldBlocksCount = */ 1,
+		/* currentBlocksCount = */ 1,
+		/* newBlocksCount = */ 1,
+		/* initialBlocksCount = */ 3,
+		local.FIFOBlobRefreshPolicy)
+	locationRecordArray := mock.NewMockLocationRecordArray(ctrl)
+	scrubber := local.NewBlobChecksumScrubber(
+		locationRecordArray,
+		func() int { return 3 },
+		locationBlobMap,
+		local.NewShardedRWMutex(4),
+		"cas")
+
+	// Invalid records should be skipped. Records for which the
+	// checksum matches should not cause any blocks to be released.
+	// Checksum mismatches should cause the block containing the
+	// blob to be released, together with all blocks preceding it.
+	locationRecordArray.EXPECT().Get(0).Return(local.LocationRecord{}, local.ErrLocationRecordInvalid)
+	locationRecordArray.EXPECT().Get(1).Return(local.LocationRecord{
+		Location: local.Location{BlockIndex: 2, OffsetBytes: 0, SizeBytes: 5},
+	}, nil)
+	blockList.EXPECT().GetRaw(2, int64(0), int64(9)).
+		Return(buffer.NewValidatedBufferFromByteSlice(appendBlobChecksum([]byte("Hello"))))
+	locationRecordArray.EXPECT().Get(2).Return(local.LocationRecord{
+		Location: local.Location{BlockIndex: 1, OffsetBytes: 20, SizeBytes: 5},
+	}, nil)
+	corrupted := appendBlobChecksum([]byte("Hello"))
+	corrupted[4] = 'p'
+	blockList.EXPECT().GetRaw(1, int64(20), int64(9)).
+		Return(buffer.NewValidatedBufferFromByteSlice(corrupted))
+	errorLogger.EXPECT().Log(status.Error(codes.Internal, "Releasing 2 blocks due to a data integrity error"))
+
+	require.NoError(t, scrubber.ScrubRecords(3))
+
+	blockList.EXPECT().BlockReferenceToBlockIndex(local.BlockReference{EpochID: 7}).Return(1, uint64(0), true)
+	_, _, found := locationBlobMap.BlockReferenceToBlockIndex(local.BlockReference{EpochID: 7})
+	require.False(t, found)
+
+	blockList.EXPECT().BlockReferenceToBlockIndex(local.BlockReference{EpochID: 8}).Return(2, uint64(0), true)
+	_, _, found = locationBlobMap.BlockReferenceToBlockIndex(local.BlockReference{EpochID: 8})
+	require.True(t, found)
+
+	// Once the end of the LocationRecordArray is reached, scrubbing
+	// should continue at the start.
+	locationRecordArray.EXPECT().Get(0).Return(local.LocationRecord{}, status.Error(codes.Internal, "Disk on fire"))
+
+	require.Equal(t, status.Error(codes.Internal, "Failed to get record at index 0: Disk on fire"), scrubber.ScrubRecords(1))
+}
@@ -0,0 +1,116 @@
+package local
+
+// BlockGroup indicates in which group of blocks managed by
+// OldCurrentNewLocationBlobMap a blob is stored.
+type BlockGroup int
+
+const (
+	// BlockGroupOld contains the blocks that are at risk of being
+	// released in the nearby future.
+	BlockGroupOld BlockGroup = iota
+	// BlockGroupCurrent contains the blocks that are no longer
+	// written to, but are not at risk of being released.
+	BlockGroupCurrent
+	// BlockGroupNew contains the blocks to which new blobs are
+	// written.
+	BlockGroupNew
+)
+
+// BlobRefreshPolicy is used by OldCurrentNewLocationBlobMap to decide
+// whether a blob that is accessed needs to be refreshed, meaning that
+// it is copied into a block in the "new" group. This decision
+// determines which blobs are retained by the cache.
+//
+// ShouldRefresh() is called for every access of a blob, potentially
+// in parallel. blobID uniquely identifies the copy of the blob that is
+// being accessed. It changes when the blob is refreshed.
+//
+// Callers may look up a blob multiple times as part of a single access
+// (e.g., once while holding a read lock, and once more while holding a
+// write lock). Implementations should therefore ensure that once true
+// is returned for a given blob, subsequent calls for the same blob in
+// the same group also return true.
+type BlobRefreshPolicy interface {
+	ShouldRefresh(blockGroup BlockGroup, blobID uint64) bool
+}
+
+type fifoBlobRefreshPolicy struct{}
+
+// FIFOBlobRefreshPolicy is a BlobRefreshPolicy that only refreshes
+// blobs stored in blocks in the "old" group. This causes
+// OldCurrentNewLocationBlobMap to behave like a FIFO, except that
+// blobs that are accessed shortly before being released are retained.
+// It requires little bookkeeping and keeps write amplification low.
+var FIFOBlobRefreshPolicy BlobRefreshPolicy = fifoBlobRefreshPolicy{}
+
+func (fifoBlobRefreshPolicy) ShouldRefresh(blockGroup BlockGroup, blobID uint64) bool {
+	return blockGroup == BlockGroupOld
+}
+
+type lruBlobRefreshPolicy struct{}
+
+// LRUBlobRefreshPolicy is a BlobRefreshPolicy that refreshes all
+// blobs that are not stored in blocks in the "new" group. This causes
+// OldCurrentNewLocationBlobMap to approximate a least recently used
+// (LRU) cache, at the cost of increasing write amplification.
+var LRUBlobRefreshPolicy BlobRefreshPolicy = lruBlobRefreshPolicy{}
+
+func (lruBlobRefreshPolicy) ShouldRefresh(blockGroup BlockGroup, blobID uint64) bool {
+	return blockGroup != BlockGroupNew
+}
+
+type segmentedLRUBlobRefreshPolicy struct {
+	sketch *frequencySketch
+}
+
+// NewSegmentedLRUBlobRefreshPolicy creates a BlobRefreshPolicy that
+// approximates a segmented LRU cache. Blobs that have only been
+// accessed once since being written are probationary. They are never
+// refreshed, causing them to be released in FIFO order. Blobs that
+// have been accessed multiple times are protected. They are refreshed
+// like they would be by LRUBlobRefreshPolicy.
+//
+// This prevents workloads that scan through large amounts of data that
+// is only accessed once from displacing the working set.
+//
+// Accesses are tracked using a count-min sketch that has sketchWidth
+// counters per row. Counters are halved every agingInterval accesses,
+// so that blobs eventually become probationary again.
+func NewSegmentedLRUBlobRefreshPolicy(sketchWidth int, agingInterval uint64) BlobRefreshPolicy {
+	return &segmentedLRUBlobRefreshPolicy{
+		sketch: newFrequencySketch(sketchWidth, agingInterval),
+	}
+}
+
+func (rp *segmentedLRUBlobRefreshPolicy) ShouldRefresh(blockGroup BlockGroup, blobID uint64) bool {
+	previousAccesses := rp.sketch.increment(blobID)
+	return blockGroup != BlockGroupNew && previousAccesses > 0
+}
+
+type tinyLFUBlobRefreshPolicy struct {
+	sketch                  *frequencySketch
+	minimumPreviousAccesses uint32
+}
+
+// NewTinyLFUBlobRefreshPolicy creates a BlobRefreshPolicy that is
+// inspired by the TinyLFU admission policy. The access frequency of
+// blobs is estimated using a count-min sketch that decays over time.
+// Blobs stored in blocks in the "old" group are only refreshed if
+// they have been accessed at least minimumPreviousAccesses times
+// before. The sketch is configured like the one used by
+// NewSegmentedLRUBlobRefreshPolicy().
+//
+// Compared to NewSegmentedLRUBlobRefreshPolicy(), this policy causes
+// less write amplification, while also preventing blobs that are only
+// accessed sporadically from being retained.
+func NewTinyLFUBlobRefreshPolicy(sketchWidth int, agingInterval uint64, minimumPreviousAccesses uint32) BlobRefreshPolicy {
+	return &tinyLFUBlobRefreshPolicy{
+		sketch:                  newFrequencySketch(sketchWidth, agingInterval),
+		minimumPreviousAccesses: minimumPreviousAccesses,
+	}
+}
+
+func (rp *tinyLFUBlobRefreshPolicy) ShouldRefresh(blockGroup BlockGroup, blobID uint64) bool {
+	previousAccesses := rp.sketch.increment(blobID)
+	return blockGroup == BlockGroupOld && previousAccesses >= rp.minimumPreviousAccesses
+}
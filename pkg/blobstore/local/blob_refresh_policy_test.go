@@ -0,0 +1,75 @@
+package local_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFIFOBlobRefreshPolicy(t *testing.T) {
+	require.True(t, local.FIFOBlobRefreshPolicy.ShouldRefresh(local.BlockGroupOld, 123))
+	require.False(t, local.FIFOBlobRefreshPolicy.ShouldRefresh(local.BlockGroupCurrent, 123))
+	require.False(t, local.FIFOBlobRefreshPolicy.ShouldRefresh(local.BlockGroupNew, 123))
+}
+
+func TestLRUBlobRefreshPolicy(t *testing.T) {
+	require.True(t, local.LRUBlobRefreshPolicy.ShouldRefresh(local.BlockGroupOld, 123))
+	require.True(t, local.LRUBlobRefreshPolicy.ShouldRefresh(local.BlockGroupCurrent, 123))
+	require.False(t, local.LRUBlobRefreshPolicy.ShouldRefresh(local.BlockGroupNew, 123))
+}
+
+func TestSegmentedLRUBlobRefreshPolicy(t *testing.T) {
+	refreshPolicy := local.NewSegmentedLRUBlobRefreshPolicy(1024, 1000000)
+
+	t.Run("Probationary", func(t *testing.T) {
+		// Blobs that are accessed for the first time should
+		// not be refreshed, even if they are about to be
+		// released.
+		require.False(t, refreshPolicy.ShouldRefresh(local.BlockGroupOld, 1))
+		require.False(t, refreshPolicy.ShouldRefresh(local.BlockGroupCurrent, 2))
+	})
+
+	t.Run("Protected", func(t *testing.T) {
+		// Subsequent accesses should cause blobs to be
+		// refreshed, as long as they are not stored in "new"
+		// blocks.
+		require.True(t, refreshPolicy.ShouldRefresh(local.BlockGroupOld, 1))
+		require.True(t, refreshPolicy.ShouldRefresh(local.BlockGroupOld, 1))
+		require.True(t, refreshPolicy.ShouldRefresh(local.BlockGroupCurrent, 2))
+
+		require.False(t, refreshPolicy.ShouldRefresh(local.BlockGroupNew, 3))
+		require.False(t, refreshPolicy.ShouldRefresh(local.BlockGroupNew, 3))
+	})
+}
+
+func TestTinyLFUBlobRefreshPolicy(t *testing.T) {
+	t.Run("MinimumPreviousAccesses", func(t *testing.T) {
+		refreshPolicy := local.NewTinyLFUBlobRefreshPolicy(1024, 1000000, 2)
+
+		// Blobs should only be refreshed after being accessed
+		// twice before, and only if they are stored in "old"
+		// blocks.
+		require.False(t, refreshPolicy.ShouldRefresh(local.BlockGroupOld, 1))
+		require.False(t, refreshPolicy.ShouldRefresh(local.BlockGroupOld, 1))
+		require.True(t, refreshPolicy.ShouldRefresh(local.BlockGroupOld, 1))
+		require.True(t, refreshPolicy.ShouldRefresh(local.BlockGroupOld, 1))
+
+		require.False(t, refreshPolicy.ShouldRefresh(local.BlockGroupCurrent, 2))
+		require.False(t, refreshPolicy.ShouldRefresh(local.BlockGroupCurrent, 2))
+		require.False(t, refreshPolicy.ShouldRefresh(local.BlockGroupCurrent, 2))
+	})
+
+	t.Run("Aging", func(t *testing.T) {
+		refreshPolicy := local.NewTinyLFUBlobRefreshPolicy(1024, 4, 2)
+
+		// After every four accesses, counters are halved. This
+		// causes blobs to be forgotten.
+		require.False(t, refreshPolicy.ShouldRefresh(local.BlockGroupOld, 1))
+		require.False(t, refreshPolicy.ShouldRefresh(local.BlockGroupOld, 1))
+		require.False(t, refreshPolicy.ShouldRefresh(local.BlockGroupOld, 2))
+		require.False(t, refreshPolicy.ShouldRefresh(local.BlockGroupOld, 3))
+		require.False(t, refreshPolicy.ShouldRefresh(local.BlockGroupOld, 1))
+		require.True(t, refreshPolicy.ShouldRefresh(local.BlockGroupOld, 1))
+	})
+}
@@ -7,9 +7,14 @@ import (
 )
 
 // Block of storage that contains a sequence of blobs. Buffers returned
-// by Get() must remain valid, even if Release() is called.
+// by Get() and GetRaw() must remain valid, even if Release() is called.
+//
+// Whereas Get() may return a buffer that validates the data against
+// the digest, GetRaw() returns the data as is. It may be used to access
+// regions of the block that do not correspond to a single blob.
 type Block interface {
 	Get(digest digest.Digest, offsetBytes, sizeBytes int64, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer
+	GetRaw(offsetBytes, sizeBytes int64) buffer.Buffer
 	Put(offsetBytes int64, b buffer.Buffer) error
 	Release()
 }
@@ -35,6 +35,13 @@ var (
 			Name:      "block_device_backed_block_allocator_releases_total",
 			Help:      "Number of times blocks managed by BlockDeviceBackedBlockAllocator were released",
 		})
+	blockDeviceBackedBlockAllocatorDiscardFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "block_device_backed_block_allocator_discard_failures_total",
+			Help:      "Number of times regions of released blocks managed by BlockDeviceBackedBlockAllocator could not be discarded",
+		})
 
 	blockDeviceBackedBlockAllocatorGetsStarted = prometheus.NewCounter(
 		prometheus.CounterOpts{
@@ -56,10 +63,18 @@ type blockDeviceBackedBlockAllocator struct {
 	blockDevice       blockdevice.BlockDevice
 	readBufferFactory blobstore.ReadBufferFactory
 	sectorSizeBytes   int
+	blockSectorCount  int64
 	blockSizeBytes    int64
+	blockCount        int
 
 	lock        sync.Mutex
 	freeOffsets []int64
+
+	// Blocks that were created by a previous run using a different
+	// block size may overlap with multiple blocks of the current
+	// size. For each block of the current size, track how many of
+	// such blocks overlap with it.
+	legacyBlocksPerOffset map[int64]int
 }
 
 // NewBlockDeviceBackedBlockAllocator implements a BlockAllocator that
@@ -75,10 +90,18 @@ type blockDeviceBackedBlockAllocator struct {
 // This implementation also ensures that writes against underlying
 // storage are all performed at sector boundaries and sizes. This
 // ensures that no unnecessary reads are performed.
+//
+// When blocks are released, their regions of the BlockDevice are
+// discarded, so that the storage medium may reclaim the space.
 func NewBlockDeviceBackedBlockAllocator(blockDevice blockdevice.BlockDevice, readBufferFactory blobstore.ReadBufferFactory, sectorSizeBytes int, blockSectorCount int64, blockCount int) BlockAllocator {
+	return newBlockDeviceBackedBlockAllocator(blockDevice, readBufferFactory, sectorSizeBytes, blockSectorCount, blockCount)
+}
+
+func newBlockDeviceBackedBlockAllocator(blockDevice blockdevice.BlockDevice, readBufferFactory blobstore.ReadBufferFactory, sectorSizeBytes int, blockSectorCount int64, blockCount int) *blockDeviceBackedBlockAllocator {
 	blockDeviceBackedBlockAllocatorPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(blockDeviceBackedBlockAllocatorAllocations)
 		prometheus.MustRegister(blockDeviceBackedBlockAllocatorReleases)
+		prometheus.MustRegister(blockDeviceBackedBlockAllocatorDiscardFailures)
 
 		prometheus.MustRegister(blockDeviceBackedBlockAllocatorGetsStarted)
 		prometheus.MustRegister(blockDeviceBackedBlockAllocatorGetsCompleted)
@@ -88,7 +111,11 @@ func NewBlockDeviceBackedBlockAllocator(blockDevice blockdevice.BlockDevice, rea
 		blockDevice:       blockDevice,
 		readBufferFactory: readBufferFactory,
 		sectorSizeBytes:   sectorSizeBytes,
+		blockSectorCount:  blockSectorCount,
 		blockSizeBytes:    blockSectorCount * int64(sectorSizeBytes),
+		blockCount:        blockCount,
+
+		legacyBlocksPerOffset: map[int64]int{},
 	}
 	for i := 0; i < blockCount; i++ {
 		pa.freeOffsets = append(pa.freeOffsets, int64(i)*blockSectorCount)
@@ -96,7 +123,7 @@ func NewBlockDeviceBackedBlockAllocator(blockDevice blockdevice.BlockDevice, rea
 	return pa
 }
 
-func (pa *blockDeviceBackedBlockAllocator) newBlockObject(offset int64) Block {
+func (pa *blockDeviceBackedBlockAllocator) newBlockObject(offset int64) *blockDeviceBackedBlock {
 	blockDeviceBackedBlockAllocatorAllocations.Inc()
 	pb := &blockDeviceBackedBlock{
 		blockAllocator: pa,
@@ -107,18 +134,30 @@ func (pa *blockDeviceBackedBlockAllocator) newBlockObject(offset int64) Block {
 }
 
 func (pa *blockDeviceBackedBlockAllocator) NewBlock() (Block, *pb.BlockLocation, error) {
+	block, err := pa.newBlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, &pb.BlockLocation{
+		OffsetBytes: block.offset * int64(pa.sectorSizeBytes),
+		SizeBytes:   pa.blockSizeBytes,
+	}, nil
+}
+
+// newBlock allocates a fresh block of data, returning it as its
+// concrete type. This is used by TieredBlockAllocator, which needs
+// direct access to the underlying storage to move blocks between
+// block devices.
+func (pa *blockDeviceBackedBlockAllocator) newBlock() (*blockDeviceBackedBlock, error) {
 	pa.lock.Lock()
 	defer pa.lock.Unlock()
 
 	if len(pa.freeOffsets) == 0 {
-		return nil, nil, status.Error(codes.ResourceExhausted, "No unused blocks available")
+		return nil, status.Error(codes.ResourceExhausted, "No unused blocks available")
 	}
 	offset := pa.freeOffsets[0]
 	pa.freeOffsets = pa.freeOffsets[1:]
-	return pa.newBlockObject(offset), &pb.BlockLocation{
-		OffsetBytes: offset * int64(pa.sectorSizeBytes),
-		SizeBytes:   pa.blockSizeBytes,
-	}, nil
+	return pa.newBlockObject(offset), nil
 }
 
 func (pa *blockDeviceBackedBlockAllocator) NewBlockAtLocation(location *pb.BlockLocation) (Block, bool) {
@@ -135,48 +174,141 @@ func (pa *blockDeviceBackedBlockAllocator) NewBlockAtLocation(location *pb.Block
 			return pa.newBlockObject(offset), true
 		}
 	}
-	return nil, false
+	return pa.newLegacyBlockAtLocation(location)
+}
+
+// newLegacyBlockAtLocation is called by NewBlockAtLocation() to
+// reattach a block that was created by a previous run that used a
+// different block size (e.g., due to the number of blocks or the size
+// of the block device being changed). This is only possible if all
+// blocks of the current size that overlap with the location are
+// unused, or only overlap with other legacy blocks.
+//
+// Because the size of such blocks differs from the current block size,
+// callers must not attempt to write additional data into them.
+func (pa *blockDeviceBackedBlockAllocator) newLegacyBlockAtLocation(location *pb.BlockLocation) (Block, bool) {
+	sectorSizeBytes := int64(pa.sectorSizeBytes)
+	if location == nil ||
+		location.OffsetBytes < 0 ||
+		location.SizeBytes <= 0 ||
+		location.OffsetBytes%sectorSizeBytes != 0 {
+		return nil, false
+	}
+	offsetSectors := location.OffsetBytes / sectorSizeBytes
+	sizeSectors := (location.SizeBytes + sectorSizeBytes - 1) / sectorSizeBytes
+	if offsetSectors+sizeSectors > int64(pa.blockCount)*pa.blockSectorCount {
+		// Block lies beyond the end of the block device.
+		return nil, false
+	}
+
+	// Determine which blocks of the current size overlap with the
+	// location, and whether they are available.
+	var overlappingOffsets []int64
+	freeIndices := map[int64]int{}
+	for i, offset := range pa.freeOffsets {
+		freeIndices[offset] = i
+	}
+	for offset := offsetSectors / pa.blockSectorCount * pa.blockSectorCount; offset < offsetSectors+sizeSectors; offset += pa.blockSectorCount {
+		if _, ok := freeIndices[offset]; !ok && pa.legacyBlocksPerOffset[offset] == 0 {
+			return nil, false
+		}
+		overlappingOffsets = append(overlappingOffsets, offset)
+	}
+
+	// Claim the overlapping blocks.
+	freeOffsets := pa.freeOffsets[:0]
+	for _, offset := range pa.freeOffsets {
+		if offset < overlappingOffsets[0] || offset > overlappingOffsets[len(overlappingOffsets)-1] {
+			freeOffsets = append(freeOffsets, offset)
+		}
+	}
+	pa.freeOffsets = freeOffsets
+	for _, offset := range overlappingOffsets {
+		pa.legacyBlocksPerOffset[offset]++
+	}
+
+	pb := pa.newBlockObject(offsetSectors)
+	pb.legacyOverlappingOffsets = overlappingOffsets
+	return pb, true
 }
 
 type blockDeviceBackedBlock struct {
 	usecount       atomic.Int64
 	blockAllocator *blockDeviceBackedBlockAllocator
 	offset         int64
+
+	// For blocks created by a previous run using a different block
+	// size, the offsets of the blocks of the current size with which
+	// this block overlaps.
+	legacyOverlappingOffsets []int64
 }
 
 func (pb *blockDeviceBackedBlock) Release() {
 	if c := pb.usecount.Add(-1); c < 0 {
 		panic(fmt.Sprintf("Release(): Block has invalid reference count %d", c))
 	} else if c == 0 {
-		// Block has no remaining consumers. Allow the region in
-		// storage to be reused for new data.
+		// Block has no remaining consumers. Determine which
+		// regions in storage are no longer in use.
 		pa := pb.blockAllocator
+		var releasedOffsets []int64
+		if pb.legacyOverlappingOffsets == nil {
+			releasedOffsets = []int64{pb.offset}
+		} else {
+			pa.lock.Lock()
+			for _, offset := range pb.legacyOverlappingOffsets {
+				if pa.legacyBlocksPerOffset[offset]--; pa.legacyBlocksPerOffset[offset] == 0 {
+					delete(pa.legacyBlocksPerOffset, offset)
+					releasedOffsets = append(releasedOffsets, offset)
+				}
+			}
+			pa.lock.Unlock()
+		}
+
+		// Discard the regions before allowing them to be reused
+		// for new data, as discarding them afterwards would
+		// cause the new data to be lost.
+		for _, offset := range releasedOffsets {
+			if err := pa.blockDevice.Discard(offset*int64(pa.sectorSizeBytes), pa.blockSizeBytes); err != nil {
+				blockDeviceBackedBlockAllocatorDiscardFailures.Inc()
+			}
+		}
+
 		pa.lock.Lock()
-		pa.freeOffsets = append(pa.freeOffsets, pb.offset)
+		pa.freeOffsets = append(pa.freeOffsets, releasedOffsets...)
 		pa.lock.Unlock()
 		blockDeviceBackedBlockAllocatorReleases.Inc()
 	}
 }
 
-func (pb *blockDeviceBackedBlock) Get(digest digest.Digest, offsetBytes, sizeBytes int64, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+// newReader creates a reader for a region of the block. The reader
+// holds a reference to the block, which is dropped when closed.
+func (pb *blockDeviceBackedBlock) newReader(offsetBytes, sizeBytes int64) *blockDeviceBackedBlockReader {
 	if c := pb.usecount.Add(1); c <= 1 {
 		panic(fmt.Sprintf("Get(): Block has invalid reference count %d", c))
 	}
 	blockDeviceBackedBlockAllocatorGetsStarted.Inc()
 
+	return &blockDeviceBackedBlockReader{
+		SectionReader: *io.NewSectionReader(
+			pb.blockAllocator.blockDevice,
+			pb.offset*int64(pb.blockAllocator.sectorSizeBytes)+offsetBytes,
+			sizeBytes),
+		block: pb,
+	}
+}
+
+func (pb *blockDeviceBackedBlock) Get(digest digest.Digest, offsetBytes, sizeBytes int64, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
 	return pb.blockAllocator.readBufferFactory.NewBufferFromReaderAt(
 		digest,
-		&blockDeviceBackedBlockReader{
-			SectionReader: *io.NewSectionReader(
-				pb.blockAllocator.blockDevice,
-				pb.offset*int64(pb.blockAllocator.sectorSizeBytes)+offsetBytes,
-				sizeBytes),
-			block: pb,
-		},
+		pb.newReader(offsetBytes, sizeBytes),
 		sizeBytes,
 		dataIntegrityCallback)
 }
 
+func (pb *blockDeviceBackedBlock) GetRaw(offsetBytes, sizeBytes int64) buffer.Buffer {
+	return buffer.NewValidatedBufferFromReaderAt(pb.newReader(offsetBytes, sizeBytes), sizeBytes)
+}
+
 func (pb *blockDeviceBackedBlock) Put(offsetBytes int64, b buffer.Buffer) error {
 	if pb.usecount.Load() <= 0 {
 		panic("Attempted to store buffer in unused block")
@@ -61,12 +61,14 @@ func TestBlockDeviceBackedBlockAllocator(t *testing.T) {
 	require.Equal(t, err, status.Error(codes.ResourceExhausted, "No unused blocks available"))
 
 	// The blob may still be consumed with the block being released.
-	// It should have started at offset 700.
+	// It should have started at offset 700. Once consumed, the
+	// region of the block should be discarded.
 	blockDevice.EXPECT().ReadAt(gomock.Any(), int64(725)).DoAndReturn(
 		func(p []byte, off int64) (int, error) {
 			copy(p, "Hello")
 			return 5, nil
 		})
+	blockDevice.EXPECT().Discard(int64(700), int64(100))
 	data, err := b.ToByteSlice(100)
 	require.NoError(t, err)
 	require.Equal(t, []byte("Hello"), data)
@@ -89,6 +91,7 @@ func TestBlockDeviceBackedBlockAllocator(t *testing.T) {
 	// leveling of the storage backend.
 	order := []int{2, 8, 4, 9, 3}
 	for _, i := range order {
+		blockDevice.EXPECT().Discard(int64(i)*100, int64(100))
 		blocks[i].Release()
 	}
 	for _, i := range order {
@@ -116,7 +119,9 @@ func TestBlockDeviceBackedBlockAllocator(t *testing.T) {
 	require.False(t, found)
 
 	// Releasing a block should make it possible to extract it using
-	// NewBlockAtLocation() again.
+	// NewBlockAtLocation() again. Failures to discard the region of
+	// the block should not prevent it from being reused.
+	blockDevice.EXPECT().Discard(int64(700), int64(100)).Return(status.Error(codes.Unimplemented, "Discarding is not supported"))
 	blocks[7].Release()
 	blocks[7], found = pa.NewBlockAtLocation(&pb.BlockLocation{
 		OffsetBytes: 700,
@@ -126,3 +131,89 @@ func TestBlockDeviceBackedBlockAllocator(t *testing.T) {
 	blockDevice.EXPECT().WriteAt([]byte("Hello"), int64(741)).Return(5, nil)
 	require.NoError(t, blocks[7].Put(41, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
 }
+
+func TestBlockDeviceBackedBlockAllocatorLegacyBlockSize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	blockDevice := mock.NewMockBlockDevice(ctrl)
+	pa := local.NewBlockDeviceBackedBlockAllocator(blockDevice, blobstore.CASReadBufferFactory, 1, 100, 4)
+
+	// Blocks created by a previous run with a block size of 150
+	// bytes should be reattachable, even though they overlap with
+	// multiple blocks of the current size.
+	legacyBlock1, found := pa.NewBlockAtLocation(&pb.BlockLocation{
+		OffsetBytes: 0,
+		SizeBytes:   150,
+	})
+	require.True(t, found)
+	legacyBlock2, found := pa.NewBlockAtLocation(&pb.BlockLocation{
+		OffsetBytes: 150,
+		SizeBytes:   150,
+	})
+	require.True(t, found)
+
+	// Blocks that extend beyond the end of the block device cannot
+	// be reattached.
+	_, found = pa.NewBlockAtLocation(&pb.BlockLocation{
+		OffsetBytes: 300,
+		SizeBytes:   150,
+	})
+	require.False(t, found)
+
+	// Data should be read at the original location.
+	blockDevice.EXPECT().ReadAt(gomock.Any(), int64(175)).DoAndReturn(
+		func(p []byte, off int64) (int, error) {
+			copy(p, "Hello")
+			return 5, nil
+		})
+	data, err := legacyBlock2.Get(
+		digest.MustNewDigest("some-instance", "8b1a9953c4611296a827abf8c47804d7", 5),
+		25,
+		5,
+		func(dataIsValid bool) {}).ToByteSlice(100)
+	require.NoError(t, err)
+	require.Equal(t, []byte("Hello"), data)
+
+	// Only the last block of the current size is unused.
+	block, location, err := pa.NewBlock()
+	require.NoError(t, err)
+	testutil.RequireEqualProto(t, &pb.BlockLocation{
+		OffsetBytes: 300,
+		SizeBytes:   100,
+	}, location)
+	_, _, err = pa.NewBlock()
+	require.Equal(t, err, status.Error(codes.ResourceExhausted, "No unused blocks available"))
+
+	// Releasing the first legacy block should only make the first
+	// block of the current size available, as the second one still
+	// overlaps with the second legacy block.
+	blockDevice.EXPECT().Discard(int64(0), int64(100))
+	legacyBlock1.Release()
+	_, location, err = pa.NewBlock()
+	require.NoError(t, err)
+	testutil.RequireEqualProto(t, &pb.BlockLocation{
+		OffsetBytes: 0,
+		SizeBytes:   100,
+	}, location)
+	_, _, err = pa.NewBlock()
+	require.Equal(t, err, status.Error(codes.ResourceExhausted, "No unused blocks available"))
+
+	blockDevice.EXPECT().Discard(int64(100), int64(100))
+	blockDevice.EXPECT().Discard(int64(200), int64(100))
+	legacyBlock2.Release()
+	_, location, err = pa.NewBlock()
+	require.NoError(t, err)
+	testutil.RequireEqualProto(t, &pb.BlockLocation{
+		OffsetBytes: 100,
+		SizeBytes:   100,
+	}, location)
+	_, location, err = pa.NewBlock()
+	require.NoError(t, err)
+	testutil.RequireEqualProto(t, &pb.BlockLocation{
+		OffsetBytes: 200,
+		SizeBytes:   100,
+	}, location)
+
+	blockDevice.EXPECT().Discard(int64(300), int64(100))
+	block.Release()
+}
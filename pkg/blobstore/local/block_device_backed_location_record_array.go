@@ -55,10 +55,16 @@ func (lra *blockDeviceBackedLocationRecordArray) Get(index int) (LocationRecord,
 	if _, err := lra.device.ReadAt(record[:], int64(index)*BlockDeviceBackedLocationRecordSize); err != nil {
 		return LocationRecord{}, err
 	}
+	return deserializeLocationRecord(&record, lra.resolver)
+}
 
+// deserializeLocationRecord converts a serialized LocationRecord back
+// to its native form, validating that it refers to a block that still
+// exists and that its checksum is valid.
+func deserializeLocationRecord(record *[BlockDeviceBackedLocationRecordSize]byte, resolver BlockReferenceResolver) (LocationRecord, error) {
 	// Reobtain the index of the block in the BlockList. This may
 	// fail if the entry refers to a block that is no longer there.
-	blockIndex, hashSeed, found := lra.resolver.BlockReferenceToBlockIndex(BlockReference{
+	blockIndex, hashSeed, found := resolver.BlockReferenceToBlockIndex(BlockReference{
 		EpochID:        binary.LittleEndian.Uint32(record[:]),
 		BlocksFromLast: binary.LittleEndian.Uint16(record[4:]),
 	})
@@ -70,7 +76,7 @@ func (lra *blockDeviceBackedLocationRecordArray) Get(index int) (LocationRecord,
 	// match up with what's expected. Such records may have either
 	// been corrupted or correspond to blobs that weren't flushed
 	// before shutdown.
-	if computeChecksumForRecord(&record, hashSeed) != binary.LittleEndian.Uint64(record[4+2+sha256.Size+4+8+8:]) {
+	if computeChecksumForRecord(record, hashSeed) != binary.LittleEndian.Uint64(record[4+2+sha256.Size+4+8+8:]) {
 		return LocationRecord{}, ErrLocationRecordInvalid
 	}
 
@@ -89,10 +95,11 @@ func (lra *blockDeviceBackedLocationRecordArray) Get(index int) (LocationRecord,
 	return l, nil
 }
 
-func (lra *blockDeviceBackedLocationRecordArray) Put(index int, locationRecord LocationRecord) error {
-	blockReference, hashSeed := lra.resolver.BlockIndexToBlockReference(locationRecord.Location.BlockIndex)
+// serializeLocationRecord converts a LocationRecord to the form in
+// which it is stored on the block device.
+func serializeLocationRecord(locationRecord LocationRecord, resolver BlockReferenceResolver) [BlockDeviceBackedLocationRecordSize]byte {
+	blockReference, hashSeed := resolver.BlockIndexToBlockReference(locationRecord.Location.BlockIndex)
 
-	// Serialize the LocationRecord ready to be written to disk.
 	var record [BlockDeviceBackedLocationRecordSize]byte
 	binary.LittleEndian.PutUint32(record[:], blockReference.EpochID)
 	binary.LittleEndian.PutUint16(record[4:], blockReference.BlocksFromLast)
@@ -101,7 +108,12 @@ func (lra *blockDeviceBackedLocationRecordArray) Put(index int, locationRecord L
 	binary.LittleEndian.PutUint64(record[4+2+sha256.Size+4:], uint64(locationRecord.Location.OffsetBytes))
 	binary.LittleEndian.PutUint64(record[4+2+sha256.Size+4+8:], uint64(locationRecord.Location.SizeBytes))
 	binary.LittleEndian.PutUint64(record[4+2+sha256.Size+4+8+8:], computeChecksumForRecord(&record, hashSeed))
+	return record
+}
 
+func (lra *blockDeviceBackedLocationRecordArray) Put(index int, locationRecord LocationRecord) error {
+	// Serialize the LocationRecord ready to be written to disk.
+	record := serializeLocationRecord(locationRecord, lra.resolver)
 	_, err := lra.device.WriteAt(record[:], int64(index)*BlockDeviceBackedLocationRecordSize)
 	return err
 }
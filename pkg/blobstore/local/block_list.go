@@ -27,11 +27,11 @@ type BlockListPutFinalizer func() (int64, error)
 // space in the block is consumed.
 //
 // BlockList is only partially thread-safe. The BlockReferenceResolver
-// methods and BlockList.Get() can be invoked in parallel (e.g., under a
-// read lock), while BlockList.PopFront(), BlockList.PushBack(),
-// BlockList.HasSpace(), BlockList.Put() and BlockListPutFinalizer must
-// run exclusively (e.g., under a write lock). BlockListPutWriter is
-// safe to call without holding any locks.
+// methods, BlockList.Get() and BlockList.GetRaw() can be invoked in
+// parallel (e.g., under a read lock), while BlockList.PopFront(),
+// BlockList.PushBack(), BlockList.HasSpace(), BlockList.Put() and
+// BlockListPutFinalizer must run exclusively (e.g., under a write
+// lock). BlockListPutWriter is safe to call without holding any locks.
 type BlockList interface {
 	BlockReferenceResolver
 
@@ -45,10 +45,18 @@ type BlockList interface {
 	// Get a blob from a given block in the BlockList.
 	Get(blockIndex int, digest digest.Digest, offsetBytes, sizeBytes int64, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer
 
+	// GetRaw returns the contents of a region of a given block in
+	// the BlockList, without performing any validation.
+	GetRaw(blockIndex int, offsetBytes, sizeBytes int64) buffer.Buffer
+
 	// HasSpace returns whether a given block in the BlockList is
 	// capable of storing an additional blob of a given size.
 	HasSpace(blockIndex int, sizeBytes int64) bool
 
 	// Put a new blob in a given block in the BlockList.
 	Put(blockIndex int, sizeBytes int64) BlockListPutWriter
+
+	// GetAllocatedBytes returns the amount of space in a given
+	// block in the BlockList that has been allocated by Put().
+	GetAllocatedBytes(blockIndex int) int64
 }
@@ -0,0 +1,130 @@
+package local
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BlobChecksumSizeBytes is the amount of space that the BlockList
+// returned by NewChecksummingBlockList() uses to store the checksum
+// of a blob. The maximum size of blobs that can be stored is reduced
+// accordingly.
+const BlobChecksumSizeBytes = 4
+
+var blobChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+type checksummingBlockList struct {
+	BlockList
+}
+
+// NewChecksummingBlockList creates a decorator for BlockList that
+// stores a CRC32C checksum directly after the contents of every blob.
+//
+// These checksums are not validated by Get(), as the buffers returned
+// by it are already validated against the blob's digest. Because the
+// key-location map only contains hashed keys, digests are not known
+// when iterating over all of the blobs in storage. Checksums permit
+// BlobChecksumScrubber to detect data corruption in the background
+// regardless.
+func NewChecksummingBlockList(base BlockList) BlockList {
+	return &checksummingBlockList{
+		BlockList: base,
+	}
+}
+
+func (bl *checksummingBlockList) HasSpace(index int, sizeBytes int64) bool {
+	return bl.BlockList.HasSpace(index, sizeBytes+BlobChecksumSizeBytes)
+}
+
+func (bl *checksummingBlockList) Put(index int, sizeBytes int64) BlockListPutWriter {
+	putWriter := bl.BlockList.Put(index, sizeBytes+BlobChecksumSizeBytes)
+	return func(b buffer.Buffer) BlockListPutFinalizer {
+		return putWriter(buffer.NewValidatedBufferFromReaderAt(
+			&checksumAppendingReader{
+				r:         b.ToReader(),
+				hash:      crc32.New(blobChecksumTable),
+				sizeBytes: sizeBytes,
+			},
+			sizeBytes+BlobChecksumSizeBytes))
+	}
+}
+
+// checksumAppendingReader is a buffer.ReadAtCloser that returns the
+// contents of a blob, followed by its checksum. As the checksum can
+// only be computed after reading the full contents of the blob, data
+// may only be read sequentially. This is sufficient for Block.Put(),
+// which calls Buffer.IntoWriter().
+type checksumAppendingReader struct {
+	r         io.ReadCloser
+	hash      hash.Hash32
+	sizeBytes int64
+	offset    int64
+}
+
+func (r *checksumAppendingReader) ReadAt(p []byte, off int64) (int, error) {
+	if off != r.offset {
+		return 0, status.Errorf(codes.Internal, "Attempted to read at offset %d, while the next offset to be read is %d", off, r.offset)
+	}
+
+	// Read the contents of the blob.
+	n := 0
+	if remaining := r.sizeBytes - r.offset; remaining > 0 {
+		dataSizeBytes := len(p)
+		if int64(dataSizeBytes) > remaining {
+			dataSizeBytes = int(remaining)
+		}
+		nData, err := io.ReadFull(r.r, p[:dataSizeBytes])
+		r.hash.Write(p[:nData])
+		r.offset += int64(nData)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nData, status.Errorf(codes.Internal, "Buffer is %d bytes in size, while %d bytes were expected", r.offset, r.sizeBytes)
+		} else if err != nil {
+			return nData, err
+		}
+		n = nData
+	}
+
+	// Append the checksum.
+	if n < len(p) {
+		var checksum [BlobChecksumSizeBytes]byte
+		binary.LittleEndian.PutUint32(checksum[:], r.hash.Sum32())
+		if checksumOffset := r.offset - r.sizeBytes; checksumOffset < BlobChecksumSizeBytes {
+			nChecksum := copy(p[n:], checksum[checksumOffset:])
+			r.offset += int64(nChecksum)
+			n += nChecksum
+		}
+		if n < len(p) {
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+func (r *checksumAppendingReader) Close() error {
+	return r.r.Close()
+}
+
+// validateBlobChecksum reads the contents of a blob followed by its
+// checksum, as written by the BlockList returned by
+// NewChecksummingBlockList(). It returns whether the checksum matches.
+func validateBlobChecksum(b buffer.Buffer, sizeBytes int64) (bool, error) {
+	r := b.ToReader()
+	defer r.Close()
+
+	hash := crc32.New(blobChecksumTable)
+	if _, err := io.CopyN(hash, r, sizeBytes); err != nil {
+		return false, err
+	}
+	var checksum [BlobChecksumSizeBytes]byte
+	if _, err := io.ReadFull(r, checksum[:]); err != nil {
+		return false, err
+	}
+	return binary.LittleEndian.Uint32(checksum[:]) == hash.Sum32(), nil
+}
@@ -0,0 +1,84 @@
+package local_test
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// appendBlobChecksum returns the data as it is stored by the BlockList
+// returned by NewChecksummingBlockList().
+func appendBlobChecksum(data []byte) []byte {
+	var checksum [local.BlobChecksumSizeBytes]byte
+	binary.LittleEndian.PutUint32(checksum[:], crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+	return append(append([]byte(nil), data...), checksum[:]...)
+}
+
+func TestChecksummingBlockList(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	baseBlockList := mock.NewMockBlockList(ctrl)
+	blockList := local.NewChecksummingBlockList(baseBlockList)
+
+	t.Run("HasSpace", func(t *testing.T) {
+		// Space for the checksum should be taken into account.
+		baseBlockList.EXPECT().HasSpace(3, int64(9)).Return(false)
+
+		require.False(t, blockList.HasSpace(3, 5))
+	})
+
+	t.Run("GetRaw", func(t *testing.T) {
+		// Raw reads should be forwarded as is.
+		baseBlockList.EXPECT().GetRaw(3, int64(10), int64(9)).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello1234")))
+
+		data, err := blockList.GetRaw(3, 10, 9).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello1234"), data)
+	})
+
+	t.Run("PutSuccess", func(t *testing.T) {
+		// The checksum should be stored after the blob.
+		blockListPutWriter := mock.NewMockBlockListPutWriter(ctrl)
+		baseBlockList.EXPECT().Put(3, int64(9)).Return(blockListPutWriter.Call)
+		blockListPutFinalizer := mock.NewMockBlockListPutFinalizer(ctrl)
+		blockListPutWriter.EXPECT().Call(gomock.Any()).DoAndReturn(
+			func(b buffer.Buffer) local.BlockListPutFinalizer {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, appendBlobChecksum([]byte("Hello")), data)
+				return blockListPutFinalizer.Call
+			})
+		blockListPutFinalizer.EXPECT().Call().Return(int64(10), nil)
+
+		offsetBytes, err := blockList.Put(3, 5)(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))()
+		require.NoError(t, err)
+		require.Equal(t, int64(10), offsetBytes)
+	})
+
+	t.Run("PutReadFailure", func(t *testing.T) {
+		// Errors reading the blob should be propagated.
+		blockListPutWriter := mock.NewMockBlockListPutWriter(ctrl)
+		baseBlockList.EXPECT().Put(3, int64(9)).Return(blockListPutWriter.Call)
+		blockListPutFinalizer := mock.NewMockBlockListPutFinalizer(ctrl)
+		blockListPutWriter.EXPECT().Call(gomock.Any()).DoAndReturn(
+			func(b buffer.Buffer) local.BlockListPutFinalizer {
+				_, err := b.ToByteSlice(100)
+				require.Equal(t, status.Error(codes.Internal, "Disk on fire"), err)
+				return blockListPutFinalizer.Call
+			})
+		blockListPutFinalizer.EXPECT().Call().Return(int64(0), status.Error(codes.Internal, "Disk on fire"))
+
+		_, err := blockList.Put(3, 5)(buffer.NewBufferFromError(status.Error(codes.Internal, "Disk on fire")))()
+		require.Equal(t, status.Error(codes.Internal, "Disk on fire"), err)
+	})
+}
@@ -0,0 +1,232 @@
+package local
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	cuckooKeyLocationMapPrometheusMetrics sync.Once
+
+	cuckooKeyLocationMapGetProbes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "cuckoo_key_location_map_get_probes",
+			Help:      "Number of slots that were inspected by Get()",
+			Buckets:   []float64{1, 2},
+		},
+		[]string{"name", "outcome"})
+
+	cuckooKeyLocationMapPutIterations = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "cuckoo_key_location_map_put_iterations",
+			Help:      "Number of iterations it took for Put()",
+			Buckets:   prometheus.ExponentialBuckets(1.0, 2.0, 8),
+		},
+		[]string{"name", "outcome"})
+	cuckooKeyLocationMapPutTooManyIterations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "cuckoo_key_location_map_put_too_many_iterations_total",
+			Help:      "Number of times Put() discarded an entry, because it took the maximum number of iterations, which may indicate the hash table is too small",
+		},
+		[]string{"name"})
+)
+
+type cuckooKeyLocationMap struct {
+	recordArray          LocationRecordArray
+	tableSize            int
+	hashInitialization   uint64
+	maximumPutIterations int
+
+	getNotFound prometheus.Observer
+	getFound    prometheus.Observer
+
+	putInserted          prometheus.Observer
+	putUpdated           prometheus.Observer
+	putIgnoredOlder      prometheus.Observer
+	putTooManyIterations prometheus.Counter
+}
+
+// NewCuckooKeyLocationMap creates a KeyLocationMap backed by a hash
+// table that uses cuckoo hashing to handle collisions. The
+// LocationRecordArray is split into two tables of equal size. Every
+// key has exactly one slot in each of the tables, meaning that Get()
+// needs to inspect at most two slots. The Attempt field of
+// LocationRecordKey is used to store the table in which a record is
+// stored.
+//
+// Put() first attempts to store a record in either of its slots. If
+// both are occupied, the record displaces the entry pointing to the
+// oldest location, which is then moved to its slot in the other
+// table, potentially displacing another entry. Compared to
+// NewHashingKeyLocationMap(), this allows the hash table to be used
+// at higher load factors, while keeping lookups bounded.
+//
+// Like NewHashingKeyLocationMap(), there is an upper bound on the
+// number of displacements Put() is willing to perform. Once reached,
+// the record pointing to the oldest location out of the one being
+// displaced and the one occupying its slot is discarded.
+func NewCuckooKeyLocationMap(recordArray LocationRecordArray, recordsCount int, hashInitialization uint64, maximumPutIterations int, name string) KeyLocationMap {
+	cuckooKeyLocationMapPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(cuckooKeyLocationMapGetProbes)
+
+		prometheus.MustRegister(cuckooKeyLocationMapPutIterations)
+		prometheus.MustRegister(cuckooKeyLocationMapPutTooManyIterations)
+	})
+
+	return &cuckooKeyLocationMap{
+		recordArray:          recordArray,
+		tableSize:            recordsCount / 2,
+		hashInitialization:   hashInitialization,
+		maximumPutIterations: maximumPutIterations,
+
+		getNotFound: cuckooKeyLocationMapGetProbes.WithLabelValues(name, "NotFound"),
+		getFound:    cuckooKeyLocationMapGetProbes.WithLabelValues(name, "Found"),
+
+		putInserted:          cuckooKeyLocationMapPutIterations.WithLabelValues(name, "Inserted"),
+		putUpdated:           cuckooKeyLocationMapPutIterations.WithLabelValues(name, "Updated"),
+		putIgnoredOlder:      cuckooKeyLocationMapPutIterations.WithLabelValues(name, "IgnoredOlder"),
+		putTooManyIterations: cuckooKeyLocationMapPutTooManyIterations.WithLabelValues(name),
+	}
+}
+
+// getSlot returns the index in the LocationRecordArray at which a
+// record is stored. The Attempt field of the LocationRecordKey
+// determines the table.
+func (klm *cuckooKeyLocationMap) getSlot(k *LocationRecordKey) int {
+	return int(k.Attempt)*klm.tableSize + int(k.Hash(klm.hashInitialization)%uint64(klm.tableSize))
+}
+
+func (klm *cuckooKeyLocationMap) Get(key Key) (Location, error) {
+	for table := uint32(0); table < 2; table++ {
+		recordKey := LocationRecordKey{Key: key, Attempt: table}
+		record, err := klm.recordArray.Get(klm.getSlot(&recordKey))
+		if err == nil {
+			if record.RecordKey == recordKey {
+				klm.getFound.Observe(float64(table + 1))
+				return record.Location, nil
+			}
+		} else if err != ErrLocationRecordInvalid {
+			return Location{}, err
+		}
+	}
+	klm.getNotFound.Observe(2)
+	return Location{}, status.Error(codes.NotFound, "Object not found")
+}
+
+func (klm *cuckooKeyLocationMap) Put(key Key, location Location) error {
+	// Check whether the key is already present in either of the
+	// tables. If so, only overwrite the entry if it points to a
+	// newer version of the same blob.
+	var oldRecords [2]LocationRecord
+	var oldRecordsValid [2]bool
+	for table := uint32(0); table < 2; table++ {
+		recordKey := LocationRecordKey{Key: key, Attempt: table}
+		slot := klm.getSlot(&recordKey)
+		oldRecord, err := klm.recordArray.Get(slot)
+		if err == nil {
+			if oldRecord.RecordKey == recordKey {
+				if oldRecord.Location.IsOlder(location) {
+					if err := klm.recordArray.Put(slot, LocationRecord{
+						RecordKey: recordKey,
+						Location:  location,
+					}); err != nil {
+						return err
+					}
+					klm.putUpdated.Observe(1)
+					return nil
+				}
+				klm.putIgnoredOlder.Observe(1)
+				return nil
+			}
+			oldRecords[table] = oldRecord
+			oldRecordsValid[table] = oldRecord.RecordKey.Key != deletedKey
+		} else if err != ErrLocationRecordInvalid {
+			return err
+		}
+	}
+
+	// Store the record in one of its slots if either is unused.
+	for table := uint32(0); table < 2; table++ {
+		if !oldRecordsValid[table] {
+			recordKey := LocationRecordKey{Key: key, Attempt: table}
+			if err := klm.recordArray.Put(klm.getSlot(&recordKey), LocationRecord{
+				RecordKey: recordKey,
+				Location:  location,
+			}); err != nil {
+				return err
+			}
+			klm.putInserted.Observe(1)
+			return nil
+		}
+	}
+
+	// Both slots are occupied. Displace the entry pointing to the
+	// oldest location, and move it to its slot in the other table.
+	record := LocationRecord{
+		RecordKey: LocationRecordKey{Key: key},
+		Location:  location,
+	}
+	if oldRecords[1].Location.IsOlder(oldRecords[0].Location) {
+		record.RecordKey.Attempt = 1
+	}
+	oldRecord := oldRecords[record.RecordKey.Attempt]
+	for iteration := 1; ; iteration++ {
+		slot := klm.getSlot(&record.RecordKey)
+		if iteration > klm.maximumPutIterations {
+			// Too many displacements. Discard whichever
+			// of the two records points to the oldest
+			// location.
+			klm.putTooManyIterations.Inc()
+			if record.Location.IsOlder(oldRecord.Location) {
+				return nil
+			}
+			return klm.recordArray.Put(slot, record)
+		}
+		if err := klm.recordArray.Put(slot, record); err != nil {
+			return err
+		}
+
+		// Move the displaced record to the other table.
+		record = oldRecord
+		record.RecordKey.Attempt ^= 1
+		var err error
+		oldRecord, err = klm.recordArray.Get(klm.getSlot(&record.RecordKey))
+		if err == ErrLocationRecordInvalid || (err == nil && oldRecord.RecordKey.Key == deletedKey) {
+			if err := klm.recordArray.Put(klm.getSlot(&record.RecordKey), record); err != nil {
+				return err
+			}
+			klm.putInserted.Observe(float64(iteration + 1))
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (klm *cuckooKeyLocationMap) Delete(key Key) error {
+	for table := uint32(0); table < 2; table++ {
+		recordKey := LocationRecordKey{Key: key, Attempt: table}
+		slot := klm.getSlot(&recordKey)
+		record, err := klm.recordArray.Get(slot)
+		if err == nil {
+			if record.RecordKey == recordKey {
+				if err := klm.recordArray.Put(slot, newDeletedLocationRecord(record)); err != nil {
+					return err
+				}
+			}
+		} else if err != ErrLocationRecordInvalid {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package local_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// getCuckooSlot computes the index at which NewCuckooKeyLocationMap()
+// stores a record in a given table, assuming tables of size 5.
+func getCuckooSlot(key local.Key, table uint32) int {
+	recordKey := local.LocationRecordKey{Key: key, Attempt: table}
+	return int(table)*5 + int(recordKey.Hash(0x970aef1f90c7f916)%5)
+}
+
+func TestCuckooKeyLocationMapGet(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	array := mock.NewMockLocationRecordArray(ctrl)
+	klm := local.NewCuckooKeyLocationMap(array, 11, 0x970aef1f90c7f916, 2, "cas")
+
+	key1 := local.Key{1}
+	key2 := local.Key{2}
+	location := local.Location{
+		BlockIndex:  17,
+		OffsetBytes: 864,
+		SizeBytes:   12,
+	}
+
+	t.Run("FoundInFirstTable", func(t *testing.T) {
+		array.EXPECT().Get(getCuckooSlot(key1, 0)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key1},
+			Location:  location,
+		}, nil)
+
+		foundLocation, err := klm.Get(key1)
+		require.NoError(t, err)
+		require.Equal(t, location, foundLocation)
+	})
+
+	t.Run("FoundInSecondTable", func(t *testing.T) {
+		// Unlike NewHashingKeyLocationMap(), an invalid entry
+		// in the first table should not terminate the search.
+		array.EXPECT().Get(getCuckooSlot(key1, 0)).Return(local.LocationRecord{}, local.ErrLocationRecordInvalid)
+		array.EXPECT().Get(getCuckooSlot(key1, 1)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key1, Attempt: 1},
+			Location:  location,
+		}, nil)
+
+		foundLocation, err := klm.Get(key1)
+		require.NoError(t, err)
+		require.Equal(t, location, foundLocation)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		// At most two slots should be inspected.
+		array.EXPECT().Get(getCuckooSlot(key1, 0)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key2},
+			Location:  location,
+		}, nil)
+		array.EXPECT().Get(getCuckooSlot(key1, 1)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key1},
+			Location:  location,
+		}, nil)
+
+		_, err := klm.Get(key1)
+		require.Equal(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("IOFailure", func(t *testing.T) {
+		array.EXPECT().Get(getCuckooSlot(key1, 0)).Return(local.LocationRecord{}, status.Error(codes.Internal, "Disk on fire"))
+
+		_, err := klm.Get(key1)
+		require.Equal(t, status.Error(codes.Internal, "Disk on fire"), err)
+	})
+}
+
+func TestCuckooKeyLocationMapPut(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	array := mock.NewMockLocationRecordArray(ctrl)
+	klm := local.NewCuckooKeyLocationMap(array, 11, 0x970aef1f90c7f916, 2, "cas")
+
+	key1 := local.Key{1}
+	key2 := local.Key{2}
+	key3 := local.Key{3}
+	key4 := local.Key{4}
+	oldLocation := local.Location{
+		BlockIndex:  14,
+		OffsetBytes: 859,
+		SizeBytes:   12930,
+	}
+	newLocation := local.Location{
+		BlockIndex:  17,
+		OffsetBytes: 864,
+		SizeBytes:   12,
+	}
+	newestLocation := local.Location{
+		BlockIndex:  18,
+		OffsetBytes: 0,
+		SizeBytes:   10,
+	}
+
+	t.Run("SimpleInsertion", func(t *testing.T) {
+		// An unused slot should be overwritten directly.
+		array.EXPECT().Get(getCuckooSlot(key1, 0)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key2},
+			Location:  oldLocation,
+		}, nil)
+		array.EXPECT().Get(getCuckooSlot(key1, 1)).Return(local.LocationRecord{}, local.ErrLocationRecordInvalid)
+		array.EXPECT().Put(getCuckooSlot(key1, 1), local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key1, Attempt: 1},
+			Location:  newLocation,
+		})
+
+		require.NoError(t, klm.Put(key1, newLocation))
+	})
+
+	t.Run("OverwriteWithNewer", func(t *testing.T) {
+		array.EXPECT().Get(getCuckooSlot(key1, 0)).Return(local.LocationRecord{}, local.ErrLocationRecordInvalid)
+		array.EXPECT().Get(getCuckooSlot(key1, 1)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key1, Attempt: 1},
+			Location:  oldLocation,
+		}, nil)
+		array.EXPECT().Put(getCuckooSlot(key1, 1), local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key1, Attempt: 1},
+			Location:  newLocation,
+		})
+
+		require.NoError(t, klm.Put(key1, newLocation))
+	})
+
+	t.Run("OverwriteWithOlder", func(t *testing.T) {
+		// Overwriting the same key with an older location
+		// should be ignored.
+		array.EXPECT().Get(getCuckooSlot(key1, 0)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key1},
+			Location:  newLocation,
+		}, nil)
+
+		require.NoError(t, klm.Put(key1, oldLocation))
+	})
+
+	t.Run("Displacement", func(t *testing.T) {
+		// If both slots are occupied, the record pointing to
+		// the oldest location should be moved to its slot in
+		// the other table.
+		array.EXPECT().Get(getCuckooSlot(key1, 0)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key2},
+			Location:  newLocation,
+		}, nil)
+		array.EXPECT().Get(getCuckooSlot(key1, 1)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key3, Attempt: 1},
+			Location:  oldLocation,
+		}, nil)
+		array.EXPECT().Put(getCuckooSlot(key1, 1), local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key1, Attempt: 1},
+			Location:  newestLocation,
+		})
+		array.EXPECT().Get(getCuckooSlot(key3, 0)).Return(local.LocationRecord{}, local.ErrLocationRecordInvalid)
+		array.EXPECT().Put(getCuckooSlot(key3, 0), local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key3},
+			Location:  oldLocation,
+		})
+
+		require.NoError(t, klm.Put(key1, newestLocation))
+	})
+
+	t.Run("TooManyIterations", func(t *testing.T) {
+		// Once the maximum number of displacements is reached,
+		// the record pointing to the oldest location should be
+		// discarded.
+		array.EXPECT().Get(getCuckooSlot(key1, 0)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key2},
+			Location:  oldLocation,
+		}, nil)
+		array.EXPECT().Get(getCuckooSlot(key1, 1)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key3, Attempt: 1},
+			Location:  newLocation,
+		}, nil)
+		array.EXPECT().Put(getCuckooSlot(key1, 0), local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key1},
+			Location:  newestLocation,
+		})
+		array.EXPECT().Get(getCuckooSlot(key2, 1)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key4, Attempt: 1},
+			Location:  newLocation,
+		}, nil)
+		array.EXPECT().Put(getCuckooSlot(key2, 1), local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key2, Attempt: 1},
+			Location:  oldLocation,
+		})
+		array.EXPECT().Get(getCuckooSlot(key4, 0)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key3},
+			Location:  newestLocation,
+		}, nil)
+
+		require.NoError(t, klm.Put(key1, newestLocation))
+	})
+}
+
+func TestCuckooKeyLocationMapDelete(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	array := mock.NewMockLocationRecordArray(ctrl)
+	klm := local.NewCuckooKeyLocationMap(array, 11, 0x970aef1f90c7f916, 2, "cas")
+
+	key1 := local.Key{1}
+	key2 := local.Key{2}
+	location := local.Location{
+		BlockIndex:  17,
+		OffsetBytes: 864,
+		SizeBytes:   12,
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		// Deleting absent entries should be a no-op.
+		array.EXPECT().Get(getCuckooSlot(key1, 0)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key2},
+			Location:  location,
+		}, nil)
+		array.EXPECT().Get(getCuckooSlot(key1, 1)).Return(local.LocationRecord{}, local.ErrLocationRecordInvalid)
+
+		require.NoError(t, klm.Delete(key1))
+	})
+
+	t.Run("FoundInSecondTable", func(t *testing.T) {
+		// The record should retain its location, so that it is
+		// only reused once no other records can be stored.
+		array.EXPECT().Get(getCuckooSlot(key1, 0)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key2},
+			Location:  location,
+		}, nil)
+		array.EXPECT().Get(getCuckooSlot(key1, 1)).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key1, Attempt: 1},
+			Location:  location,
+		}, nil)
+		array.EXPECT().Put(getCuckooSlot(key1, 1), local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Attempt: 1},
+			Location:  location,
+		})
+
+		require.NoError(t, klm.Delete(key1))
+	})
+
+	t.Run("IOFailure", func(t *testing.T) {
+		array.EXPECT().Get(getCuckooSlot(key1, 0)).Return(local.LocationRecord{}, status.Error(codes.Internal, "Disk on fire"))
+
+		require.Equal(t, status.Error(codes.Internal, "Disk on fire"), klm.Delete(key1))
+	})
+}
@@ -106,3 +106,16 @@ func (pss directoryBackedPersistentStateStore) WritePersistentState(persistentSt
 	}
 	return nil
 }
+
+// DirectoryContainsPersistentState returns whether a directory contains
+// a persistent state file, as written by the PersistentStateStore
+// returned by NewDirectoryBackedPersistentStateStore().
+func DirectoryContainsPersistentState(directory filesystem.Directory) (bool, error) {
+	if _, err := directory.Lstat(componentState); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, util.StatusWrapWithCode(err, codes.Internal, "Failed to obtain file status")
+	}
+	return true, nil
+}
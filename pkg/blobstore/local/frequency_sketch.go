@@ -0,0 +1,71 @@
+package local
+
+import (
+	"github.com/buildbarn/bb-storage/pkg/atomic"
+)
+
+// frequencySketchDepth is the number of rows of counters in a
+// frequencySketch. Every row is indexed using a different hash.
+const frequencySketchDepth = 4
+
+// frequencySketch is a count-min sketch that can be used to estimate
+// how often items have been accessed, using a fixed amount of memory.
+// To ensure that the estimates reflect recent behavior, all counters
+// are halved periodically.
+//
+// All operations are performed atomically, meaning that it is safe to
+// call increment() in parallel. Halving of counters is not atomic with
+// respect to increments. This may cause estimates to be slightly off,
+// which is acceptable for the purpose of BlobRefreshPolicy.
+type frequencySketch struct {
+	counters      []atomic.Uint32
+	width         uint64
+	agingInterval uint64
+	accesses      atomic.Uint64
+}
+
+func newFrequencySketch(width int, agingInterval uint64) *frequencySketch {
+	return &frequencySketch{
+		counters:      make([]atomic.Uint32, frequencySketchDepth*width),
+		width:         uint64(width),
+		agingInterval: agingInterval,
+	}
+}
+
+// increment the counters associated with an item, returning the
+// estimated number of times the item was accessed before.
+func (s *frequencySketch) increment(id uint64) uint32 {
+	estimate := ^uint32(0)
+	h := id
+	for row := uint64(0); row < frequencySketchDepth; row++ {
+		// Derive a different hash for every row using the
+		// finalizer of SplitMix64.
+		h += 0x9e3779b97f4a7c15
+		z := h
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		z ^= z >> 31
+
+		if c := s.counters[row*s.width+z%s.width].Add(1) - 1; estimate > c {
+			estimate = c
+		}
+	}
+
+	if s.accesses.Add(1)%s.agingInterval == 0 {
+		s.age()
+	}
+	return estimate
+}
+
+// age halves all counters, so that accesses that happened a long time
+// ago have less influence on estimates.
+func (s *frequencySketch) age() {
+	for i := range s.counters {
+		for {
+			c := s.counters[i].Load()
+			if s.counters[i].CompareAndSwap(c, c/2) {
+				break
+			}
+		}
+	}
+}
@@ -47,8 +47,22 @@ var (
 			Help:      "Number of times Put() discarded an entry, because it took the maximum number of iterations, which may indicate the hash table is too small",
 		},
 		[]string{"name"})
+
+	hashingKeyLocationMapLoadFactor = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "hashing_key_location_map_load_factor",
+			Help:      "Estimated fraction of slots in the hash table that contain valid entries",
+		},
+		[]string{"name"})
 )
 
+// hashingKeyLocationMapLoadFactorSmoothing is the weight of a single
+// sample in the exponential moving average that is used to estimate
+// the load factor of the hash table.
+const hashingKeyLocationMapLoadFactorSmoothing = 1.0 / 1024
+
 type hashingKeyLocationMap struct {
 	recordArray        LocationRecordArray
 	recordsCount       int
@@ -56,6 +70,13 @@ type hashingKeyLocationMap struct {
 	maximumGetAttempts uint32
 	maximumPutAttempts int
 
+	// Set by ResizingKeyLocationMap to handle records that are
+	// stored according to the old size of the hash table.
+	reinsertMisplacedRecords bool
+
+	// Estimate of the load factor of the hash table.
+	loadFactor float64
+
 	getNotFound        prometheus.Observer
 	getFound           prometheus.Observer
 	getTooManyAttempts prometheus.Counter
@@ -65,6 +86,7 @@ type hashingKeyLocationMap struct {
 	putIgnoredOlder      prometheus.Observer
 	putTooManyAttempts   prometheus.Observer
 	putTooManyIterations prometheus.Counter
+	loadFactorGauge      prometheus.Gauge
 }
 
 // NewHashingKeyLocationMap creates a KeyLocationMap backed by a hash
@@ -86,6 +108,12 @@ type hashingKeyLocationMap struct {
 // discarded once the upper bound is reached. Though this may sound
 // harmful, there is a very high probability that the entry being
 // discarded is one of the older ones.
+//
+// The load factor of the hash table is estimated by observing whether
+// the first slot inspected by Put() for a new key contains a valid
+// entry. As keys are uniformly distributed, this happens with a
+// probability equal to the load factor. This prevents the need for
+// tracking when entries become invalid due to blocks being released.
 func NewHashingKeyLocationMap(recordArray LocationRecordArray, recordsCount int, hashInitialization uint64, maximumGetAttempts uint32, maximumPutAttempts int, name string) KeyLocationMap {
 	hashingKeyLocationMapPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(hashingKeyLocationMapGetAttempts)
@@ -93,6 +121,8 @@ func NewHashingKeyLocationMap(recordArray LocationRecordArray, recordsCount int,
 
 		prometheus.MustRegister(hashingKeyLocationMapPutIterations)
 		prometheus.MustRegister(hashingKeyLocationMapPutTooManyIterations)
+
+		prometheus.MustRegister(hashingKeyLocationMapLoadFactor)
 	})
 
 	return &hashingKeyLocationMap{
@@ -111,7 +141,25 @@ func NewHashingKeyLocationMap(recordArray LocationRecordArray, recordsCount int,
 		putIgnoredOlder:      hashingKeyLocationMapPutIterations.WithLabelValues(name, "IgnoredOlder"),
 		putTooManyAttempts:   hashingKeyLocationMapPutIterations.WithLabelValues(name, "TooManyAttempts"),
 		putTooManyIterations: hashingKeyLocationMapPutTooManyIterations.WithLabelValues(name),
+		loadFactorGauge:      hashingKeyLocationMapLoadFactor.WithLabelValues(name),
+	}
+}
+
+// observeInitialSlot updates the estimate of the load factor, based on
+// whether the first slot inspected by Put() contains a valid entry.
+func (klm *hashingKeyLocationMap) observeInitialSlot(occupied bool) {
+	sample := 0.0
+	if occupied {
+		sample = 1.0
 	}
+	klm.loadFactor += (sample - klm.loadFactor) * hashingKeyLocationMapLoadFactorSmoothing
+	klm.loadFactorGauge.Set(klm.loadFactor)
+}
+
+// getLoadFactor returns the estimated fraction of slots in the hash
+// table that contain valid entries.
+func (klm *hashingKeyLocationMap) getLoadFactor() float64 {
+	return klm.loadFactor
 }
 
 func (klm *hashingKeyLocationMap) getSlot(k *LocationRecordKey) int {
@@ -153,6 +201,13 @@ func (klm *hashingKeyLocationMap) Put(key Key, location Location) error {
 	for iteration := 1; iteration <= klm.maximumPutAttempts; iteration++ {
 		slot := klm.getSlot(&record.RecordKey)
 		oldRecord, err := klm.recordArray.Get(slot)
+		if err == nil && oldRecord.RecordKey.Key == deletedKey {
+			// Records of deleted entries may be reused.
+			err = ErrLocationRecordInvalid
+		}
+		if iteration == 1 && (err == nil || err == ErrLocationRecordInvalid) && oldRecord.RecordKey != record.RecordKey {
+			klm.observeInitialSlot(err == nil)
+		}
 		if err == ErrLocationRecordInvalid {
 			// The existing record may be overwritten directly.
 			if err := klm.recordArray.Put(slot, record); err != nil {
@@ -185,6 +240,15 @@ func (klm *hashingKeyLocationMap) Put(key Key, location Location) error {
 				return err
 			}
 			record = oldRecord
+			if klm.reinsertMisplacedRecords && klm.getSlot(&record.RecordKey) != slot {
+				// The displaced record was not stored
+				// in the slot corresponding to its key,
+				// meaning it was inserted before the
+				// hash table was resized. Reinsert it
+				// from the start.
+				record.RecordKey.Attempt = 0
+				continue
+			}
 		}
 		record.RecordKey.Attempt++
 		if record.RecordKey.Attempt >= klm.maximumGetAttempts {
@@ -196,3 +260,21 @@ func (klm *hashingKeyLocationMap) Put(key Key, location Location) error {
 	klm.putTooManyIterations.Inc()
 	return nil
 }
+
+func (klm *hashingKeyLocationMap) Delete(key Key) error {
+	recordKey := LocationRecordKey{Key: key}
+	for recordKey.Attempt < klm.maximumGetAttempts {
+		slot := klm.getSlot(&recordKey)
+		record, err := klm.recordArray.Get(slot)
+		if err == ErrLocationRecordInvalid {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if record.RecordKey == recordKey {
+			return klm.recordArray.Put(slot, newDeletedLocationRecord(record))
+		}
+		recordKey.Attempt++
+	}
+	return nil
+}
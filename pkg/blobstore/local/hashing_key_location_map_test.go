@@ -146,6 +146,69 @@ func TestHashingKeyLocationMapPut(t *testing.T) {
 		array.EXPECT().Put(6, locationRecord)
 		require.NoError(t, klm.Put(key1, newLocation))
 	})
+	t.Run("OverwriteDeleted", func(t *testing.T) {
+		// Records of deleted entries may be overwritten
+		// directly, even if they point to a newer location.
+		array.EXPECT().Get(5).Return(local.LocationRecord{
+			Location: newLocation,
+		}, nil)
+		array.EXPECT().Put(5, local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key1},
+			Location:  oldLocation,
+		})
+		require.NoError(t, klm.Put(key1, oldLocation))
+	})
+}
+
+func TestHashingKeyLocationMapDelete(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	array := mock.NewMockLocationRecordArray(ctrl)
+	klm := local.NewHashingKeyLocationMap(array, 10, 0x970aef1f90c7f916, 2, 2, "cas")
+
+	key1 := local.Key{
+		0xca, 0x2b, 0xd6, 0xc9, 0xc9, 0x9e, 0x7b, 0xc0,
+		0x0a, 0x44, 0x09, 0x73, 0xd6, 0xe1, 0xa3, 0x69,
+	}
+	key2 := local.Key{
+		0x49, 0x42, 0x69, 0x1f, 0x59, 0x07, 0xd5, 0xed,
+		0xdb, 0x71, 0x81, 0x8f, 0x65, 0x8f, 0x20, 0x71,
+	}
+	location := local.Location{
+		BlockIndex:  17,
+		OffsetBytes: 864,
+		SizeBytes:   12,
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		// Deleting absent entries should be a no-op.
+		array.EXPECT().Get(5).Return(local.LocationRecord{}, local.ErrLocationRecordInvalid)
+		require.NoError(t, klm.Delete(key1))
+	})
+
+	t.Run("IOFailure", func(t *testing.T) {
+		array.EXPECT().Get(5).Return(local.LocationRecord{}, status.Error(codes.Internal, "Disk on fire"))
+		require.Equal(t, status.Error(codes.Internal, "Disk on fire"), klm.Delete(key1))
+	})
+
+	t.Run("SecondAttempt", func(t *testing.T) {
+		// The record should retain its location and attempt,
+		// so that lookups of other keys continue to traverse
+		// it. Only its key should be cleared.
+		array.EXPECT().Get(5).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key2},
+			Location:  location,
+		}, nil)
+		array.EXPECT().Get(2).Return(local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Key: key1, Attempt: 1},
+			Location:  location,
+		}, nil)
+		array.EXPECT().Put(2, local.LocationRecord{
+			RecordKey: local.LocationRecordKey{Attempt: 1},
+			Location:  location,
+		})
+		require.NoError(t, klm.Delete(key1))
+	})
 }
 
 // TODO: Make unit testing coverage more complete.
@@ -2,7 +2,9 @@ package local
 
 import (
 	"bytes"
+	"sync"
 
+	"github.com/buildbarn/bb-storage/pkg/atomic"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
@@ -10,12 +12,23 @@ import (
 
 type inMemoryBlockAllocator struct {
 	blockSize int
+
+	lock       sync.Mutex
+	freeBlocks [][]byte
 }
 
 // NewInMemoryBlockAllocator creates a block allocator that stores its
 // blocks directly in memory, being backed by a simple byte slice. The
 // byte slice is already fully allocated. It does not grow to the
 // desired size lazily.
+//
+// Buffers returned by Get() refer to the memory of the block directly,
+// meaning that no copying takes place. The lifetime of these buffers is
+// tracked, so that the memory of a block may be reused by a subsequent
+// call to NewBlock() once the block is released and all buffers
+// referring to it have been consumed. Memory of blocks from which
+// references have escaped (e.g., by calling Buffer.ToByteSlice()) is
+// left to be reclaimed by the garbage collector.
 func NewInMemoryBlockAllocator(blockSize int) BlockAllocator {
 	return &inMemoryBlockAllocator{
 		blockSize: blockSize,
@@ -23,9 +36,24 @@ func NewInMemoryBlockAllocator(blockSize int) BlockAllocator {
 }
 
 func (ia *inMemoryBlockAllocator) NewBlock() (Block, *pb.BlockLocation, error) {
-	return inMemoryBlock{
-		data: make([]byte, ia.blockSize),
-	}, nil, nil
+	ia.lock.Lock()
+	var data []byte
+	if n := len(ia.freeBlocks); n > 0 {
+		data = ia.freeBlocks[n-1]
+		ia.freeBlocks[n-1] = nil
+		ia.freeBlocks = ia.freeBlocks[:n-1]
+	}
+	ia.lock.Unlock()
+
+	if data == nil {
+		data = make([]byte, ia.blockSize)
+	}
+	ib := &inMemoryBlock{
+		allocator: ia,
+		data:      data,
+	}
+	ib.usageCount.Initialize(1)
+	return ib, nil, nil
 }
 
 func (ia *inMemoryBlockAllocator) NewBlockAtLocation(location *pb.BlockLocation) (Block, bool) {
@@ -34,15 +62,48 @@ func (ia *inMemoryBlockAllocator) NewBlockAtLocation(location *pb.BlockLocation)
 }
 
 type inMemoryBlock struct {
-	data []byte
+	allocator *inMemoryBlockAllocator
+	data      []byte
+
+	// The number of buffers returned by Get() that have not been
+	// consumed yet, plus one if the block has not been released.
+	usageCount atomic.Int32
+	escaped    atomic.Uint32
 }
 
-func (ib inMemoryBlock) Get(digest digest.Digest, offsetBytes, sizeBytes int64, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
-	return buffer.NewValidatedBufferFromByteSlice(ib.data[offsetBytes : offsetBytes+sizeBytes])
+func (ib *inMemoryBlock) decreaseUsageCount() {
+	if ib.usageCount.Add(-1) == 0 && ib.escaped.Load() == 0 {
+		// Nothing refers to the block's memory anymore. Make
+		// it available for reuse.
+		ia := ib.allocator
+		ia.lock.Lock()
+		ia.freeBlocks = append(ia.freeBlocks, ib.data)
+		ia.lock.Unlock()
+		ib.data = nil
+	}
+}
+
+func (ib *inMemoryBlock) Get(digest digest.Digest, offsetBytes, sizeBytes int64, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	// Data stored in memory is assumed to be free of corruption.
+	return ib.GetRaw(offsetBytes, sizeBytes)
 }
 
-func (ib inMemoryBlock) Put(offsetBytes int64, b buffer.Buffer) error {
+func (ib *inMemoryBlock) GetRaw(offsetBytes, sizeBytes int64) buffer.Buffer {
+	ib.usageCount.Add(1)
+	return buffer.NewValidatedBufferFromReleasableByteSlice(
+		ib.data[offsetBytes:offsetBytes+sizeBytes],
+		func(escaped bool) {
+			if escaped {
+				ib.escaped.Store(1)
+			}
+			ib.decreaseUsageCount()
+		})
+}
+
+func (ib *inMemoryBlock) Put(offsetBytes int64, b buffer.Buffer) error {
 	return b.IntoWriter(bytes.NewBuffer(ib.data[offsetBytes:offsetBytes]))
 }
 
-func (ib inMemoryBlock) Release() {}
+func (ib *inMemoryBlock) Release() {
+	ib.decreaseUsageCount()
+}
@@ -1,6 +1,7 @@
 package local_test
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/buildbarn/bb-storage/internal/mock"
@@ -54,3 +55,67 @@ func TestInMemoryBlockAllocatorNewBlockAtLocation(t *testing.T) {
 	})
 	require.False(t, found)
 }
+
+func TestInMemoryBlockAllocatorRecycling(t *testing.T) {
+	blockAllocator := local.NewInMemoryBlockAllocator(1024)
+	blobDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("NoEscape", func(t *testing.T) {
+		block1, _, err := blockAllocator.NewBlock()
+		require.NoError(t, err)
+		require.NoError(t, block1.Put(
+			0,
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+
+		// Reading data through IntoWriter() does not cause
+		// references to the block's memory to escape. Even
+		// though the read completes after the block is
+		// released, the block's memory should be reused.
+		b := block1.Get(blobDigest, 0, 11, buffer.Irreparable(blobDigest))
+		block1.Release()
+
+		block2, _, err := blockAllocator.NewBlock()
+		require.NoError(t, err)
+		require.NoError(t, block2.Put(
+			0,
+			buffer.NewValidatedBufferFromByteSlice([]byte("Goodbye"))))
+
+		// As the buffer was still outstanding, block2 must
+		// have been allocated freshly.
+		var output bytes.Buffer
+		require.NoError(t, b.IntoWriter(&output))
+		require.Equal(t, []byte("Hello world"), output.Bytes())
+		block2.Release()
+
+		// Both blocks are now free, meaning that the next
+		// block should reuse the memory of one of them.
+		block3, _, err := blockAllocator.NewBlock()
+		require.NoError(t, err)
+		data, err := block3.Get(blobDigest, 0, 7, buffer.Irreparable(blobDigest)).ToByteSlice(1024)
+		require.NoError(t, err)
+		require.Contains(t, [][]byte{[]byte("Hello w"), []byte("Goodbye")}, data)
+		block3.Release()
+	})
+
+	t.Run("Escape", func(t *testing.T) {
+		block1, _, err := blockAllocator.NewBlock()
+		require.NoError(t, err)
+		require.NoError(t, block1.Put(
+			0,
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+
+		// Calling ToByteSlice() hands out a reference to the
+		// block's memory. The block may thus never be reused.
+		data, err := block1.Get(blobDigest, 0, 11, buffer.Irreparable(blobDigest)).ToByteSlice(1024)
+		require.NoError(t, err)
+		block1.Release()
+
+		block2, _, err := blockAllocator.NewBlock()
+		require.NoError(t, err)
+		require.NoError(t, block2.Put(
+			0,
+			buffer.NewValidatedBufferFromByteSlice([]byte("Goodbye world"))))
+		require.Equal(t, []byte("Hello world"), data)
+		block2.Release()
+	})
+}
@@ -16,7 +16,10 @@ type inMemoryLocationRecordArray struct {
 // stores its data in memory. HashingKeyLocationMap relies on being able
 // to store a mapping from Keys to a Location in memory or on disk. This
 // type implements a non-persistent storage of such a map in memory.
-func NewInMemoryLocationRecordArray(size int, resolver BlockReferenceResolver) LocationRecordArray {
+//
+// The array may be grown while in use, which permits the use of
+// AutomaticallyResizingKeyLocationMap.
+func NewInMemoryLocationRecordArray(size int, resolver BlockReferenceResolver) GrowableLocationRecordArray {
 	return &inMemoryLocationRecordArray{
 		records:  make([]inMemoryLocationRecord, size),
 		resolver: resolver,
@@ -49,3 +52,9 @@ func (lra *inMemoryLocationRecordArray) Put(index int, locationRecord LocationRe
 	}
 	return nil
 }
+
+func (lra *inMemoryLocationRecordArray) Grow(size int) {
+	records := make([]inMemoryLocationRecord, size)
+	copy(records, lra.records)
+	lra.records = records
+}
@@ -33,8 +33,8 @@ type KeyBlobPutFinalizer func(key Key) error
 //
 // KeyBlobMap is only partially thread-safe. KeyBlobMap.Get() and
 // KeyBlobGetter can be invoked in parallel (e.g., under a read lock),
-// while KeyBlobMap.Put() and KeyBlobPutFinalizer must run exclusively
-// (e.g., under a write lock). KeyBlobPutWriter is safe to call without
+// while KeyBlobMap.Put(), KeyBlobMap.Delete() and KeyBlobPutFinalizer
+// must run exclusively (e.g., under a write lock). KeyBlobPutWriter is safe to call without
 // holding any locks.
 type KeyBlobMap interface {
 	// Get information about a blob stored in the map.
@@ -59,4 +59,10 @@ type KeyBlobMap interface {
 	// KeyBlobPutFinalizer must be invoked to associate the blob
 	// with a Key.
 	Put(sizeBytes int64) (KeyBlobPutWriter, error)
+
+	// Delete a blob from the map, so that it can no longer be
+	// obtained through Get(). The space occupied by the blob is
+	// only released once the block containing it is recycled.
+	// Deleting blobs that are absent is not an error.
+	Delete(key Key) error
 }
@@ -32,7 +32,7 @@ type keyBlobMapBackedBlobAccess struct {
 	keyBlobMap      KeyBlobMap
 	digestKeyFormat digest.KeyFormat
 
-	lock        *sync.RWMutex
+	lock        *ShardedRWMutex
 	refreshLock sync.Mutex
 
 	refreshesGet         prometheus.Observer
@@ -41,7 +41,7 @@ type keyBlobMapBackedBlobAccess struct {
 
 // NewKeyBlobMapBackedBlobAccess creates a BlobAccess that forwards all
 // calls to a KeyBlobMap backend.
-func NewKeyBlobMapBackedBlobAccess(keyBlobMap KeyBlobMap, digestKeyFormat digest.KeyFormat, lock *sync.RWMutex, name string) blobstore.BlobAccess {
+func NewKeyBlobMapBackedBlobAccess(keyBlobMap KeyBlobMap, digestKeyFormat digest.KeyFormat, lock *ShardedRWMutex, name string) blobstore.BlobAccess {
 	keyBlobMapBackedBlobAccessPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(keyBlobMapBackedBlobAccessRefreshes)
 	})
@@ -64,20 +64,21 @@ func (ba *keyBlobMapBackedBlobAccess) Get(ctx context.Context, blobDigest digest
 	key := ba.getKey(blobDigest)
 
 	// Look up the blob in storage while holding a read lock.
-	ba.lock.RLock()
+	readLock := ba.lock.RLocker(key)
+	readLock.Lock()
 	getter, _, needsRefresh, err := ba.keyBlobMap.Get(key)
 	if err != nil {
-		ba.lock.RUnlock()
+		readLock.Unlock()
 		return buffer.NewBufferFromError(err)
 	}
 	if !needsRefresh {
 		// The blob doesn't need to be refreshed, so we can
 		// return its data directly.
 		b := getter(blobDigest)
-		ba.lock.RUnlock()
+		readLock.Unlock()
 		return b
 	}
-	ba.lock.RUnlock()
+	readLock.Unlock()
 
 	// Blob was found, but it needs to be refreshed to ensure it
 	// doesn't disappear. Retry loading the blob a second time, this
@@ -171,7 +172,11 @@ func (ba *keyBlobMapBackedBlobAccess) FindMissing(ctx context.Context, digests d
 	}
 	var blobsToRefresh []blobToRefresh
 	missing := digest.NewSetBuilder()
-	ba.lock.RLock()
+	if len(keys) == 0 {
+		return missing.Build(), nil
+	}
+	readLock := ba.lock.RLocker(keys[0])
+	readLock.Lock()
 	for i, blobDigest := range digests.Items() {
 		key := keys[i]
 		if _, _, needsRefresh, err := ba.keyBlobMap.Get(key); err == nil {
@@ -188,11 +193,11 @@ func (ba *keyBlobMapBackedBlobAccess) FindMissing(ctx context.Context, digests d
 			// Blob is absent.
 			missing.Add(blobDigest)
 		} else {
-			ba.lock.RUnlock()
+			readLock.Unlock()
 			return digest.EmptySet, util.StatusWrapf(err, "Failed to get blob %#v", blobDigest.String())
 		}
 	}
-	ba.lock.RUnlock()
+	readLock.Unlock()
 	if len(blobsToRefresh) == 0 {
 		return missing.Build(), nil
 	}
@@ -247,3 +252,11 @@ func (ba *keyBlobMapBackedBlobAccess) FindMissing(ctx context.Context, digests d
 	ba.refreshesFindMissing.Observe(float64(blobsRefreshedSuccessfully))
 	return missing.Build(), nil
 }
+
+func (ba *keyBlobMapBackedBlobAccess) Delete(ctx context.Context, blobDigest digest.Digest) error {
+	key := ba.getKey(blobDigest)
+	ba.lock.Lock()
+	err := ba.keyBlobMap.Delete(key)
+	ba.lock.Unlock()
+	return err
+}
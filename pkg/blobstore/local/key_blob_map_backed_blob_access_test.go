@@ -2,10 +2,10 @@ package local_test
 
 import (
 	"context"
-	"sync"
 	"testing"
 
 	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
 	"github.com/buildbarn/bb-storage/pkg/digest"
@@ -20,7 +20,7 @@ func TestKeyBlobMapBackedBlobAccessGet(t *testing.T) {
 	ctrl, ctx := gomock.WithContext(context.Background(), t)
 
 	keyBlobMap := mock.NewMockKeyBlobMap(ctrl)
-	blobAccess := local.NewKeyBlobMapBackedBlobAccess(keyBlobMap, digest.KeyWithoutInstance, &sync.RWMutex{}, "cas")
+	blobAccess := local.NewKeyBlobMapBackedBlobAccess(keyBlobMap, digest.KeyWithoutInstance, local.NewShardedRWMutex(4), "cas")
 	helloDigest := digest.MustNewDigest("example", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
 	helloKey := local.NewKeyFromString("185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969-5")
 
@@ -157,7 +157,7 @@ func TestKeyBlobMapBackedBlobAccessPut(t *testing.T) {
 	ctrl, ctx := gomock.WithContext(context.Background(), t)
 
 	keyBlobMap := mock.NewMockKeyBlobMap(ctrl)
-	blobAccess := local.NewKeyBlobMapBackedBlobAccess(keyBlobMap, digest.KeyWithoutInstance, &sync.RWMutex{}, "cas")
+	blobAccess := local.NewKeyBlobMapBackedBlobAccess(keyBlobMap, digest.KeyWithoutInstance, local.NewShardedRWMutex(4), "cas")
 	helloDigest := digest.MustNewDigest("example", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
 	helloKey := local.NewKeyFromString("185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969-5")
 
@@ -224,7 +224,7 @@ func TestKeyBlobMapBackedBlobAccessFindMissing(t *testing.T) {
 	ctrl, ctx := gomock.WithContext(context.Background(), t)
 
 	keyBlobMap := mock.NewMockKeyBlobMap(ctrl)
-	blobAccess := local.NewKeyBlobMapBackedBlobAccess(keyBlobMap, digest.KeyWithoutInstance, &sync.RWMutex{}, "cas")
+	blobAccess := local.NewKeyBlobMapBackedBlobAccess(keyBlobMap, digest.KeyWithoutInstance, local.NewShardedRWMutex(4), "cas")
 	helloDigest := digest.MustNewDigest("example", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
 	helloKey := local.NewKeyFromString("185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969-5")
 
@@ -359,3 +359,26 @@ func TestKeyBlobMapBackedBlobAccessFindMissing(t *testing.T) {
 		require.Equal(t, digest.EmptySet, missing)
 	})
 }
+
+func TestKeyBlobMapBackedBlobAccessDelete(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	keyBlobMap := mock.NewMockKeyBlobMap(ctrl)
+	blobAccess := local.NewKeyBlobMapBackedBlobAccess(keyBlobMap, digest.KeyWithoutInstance, local.NewShardedRWMutex(4), "cas")
+	blobDeleter, ok := blobAccess.(blobstore.BlobDeleter)
+	require.True(t, ok)
+	helloDigest := digest.MustNewDigest("example", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+	helloKey := local.NewKeyFromString("185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969-5")
+
+	t.Run("Failure", func(t *testing.T) {
+		keyBlobMap.EXPECT().Delete(helloKey).Return(status.Error(codes.Internal, "Disk on fire"))
+
+		require.Equal(t, status.Error(codes.Internal, "Disk on fire"), blobDeleter.Delete(ctx, helloDigest))
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		keyBlobMap.EXPECT().Delete(helloKey)
+
+		require.NoError(t, blobDeleter.Delete(ctx, helloDigest))
+	})
+}
@@ -8,4 +8,23 @@ package local
 type KeyLocationMap interface {
 	Get(key Key) (Location, error)
 	Put(key Key, location Location) error
+	// Delete the entry for a key, so that subsequent calls to
+	// Get() no longer return its location. Deleting keys that are
+	// absent is not an error.
+	Delete(key Key) error
+}
+
+// deletedKey is stored in records of entries that have been deleted
+// from a KeyLocationMap. Instead of marking such records as invalid,
+// which would cause lookups of other keys to terminate early, they
+// retain their location, but are given a key that doesn't match any
+// blob. Insertions may overwrite these records. As keys are SHA-256
+// hashes, no blob has this key in practice.
+var deletedKey Key
+
+// newDeletedLocationRecord returns a copy of a LocationRecord that no
+// longer matches any key.
+func newDeletedLocationRecord(record LocationRecord) LocationRecord {
+	record.RecordKey.Key = deletedKey
+	return record
 }
@@ -56,3 +56,7 @@ func (kbm *locationBasedKeyBlobMap) Put(sizeBytes int64) (KeyBlobPutWriter, erro
 		}
 	}, nil
 }
+
+func (kbm *locationBasedKeyBlobMap) Delete(key Key) error {
+	return kbm.keyLocationMap.Delete(key)
+}
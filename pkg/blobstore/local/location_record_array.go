@@ -31,3 +31,12 @@ type LocationRecordArray interface {
 	Get(index int) (LocationRecord, error)
 	Put(index int, locationRecord LocationRecord) error
 }
+
+// GrowableLocationRecordArray is a LocationRecordArray whose size can
+// be increased. Records stored in the array prior to growing it remain
+// present at the same indices.
+type GrowableLocationRecordArray interface {
+	LocationRecordArray
+
+	Grow(size int)
+}
@@ -0,0 +1,64 @@
+package local
+
+import (
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// locationRecordArrayStatisticsBatchSize is the number of records
+// that GetLocationRecordArrayStatistics() inspects while holding a
+// read lock. Releasing the lock periodically ensures that scanning
+// large key-location maps does not stall writes.
+const locationRecordArrayStatisticsBatchSize = 1024
+
+// LocationRecordArrayStatistics contains information on the usage of
+// a LocationRecordArray that is used as the backing store of a
+// key-location map.
+type LocationRecordArrayStatistics struct {
+	RecordsCount      int     `json:"records_count"`
+	ValidRecordsCount int     `json:"valid_records_count"`
+	Utilization       float64 `json:"utilization"`
+	// The total size of the blobs referenced by valid records.
+	// As a blob may be referenced by multiple records (e.g., while
+	// the key-location map is being resized), this is an estimate.
+	ValidRecordsSizeBytes int64 `json:"valid_records_size_bytes"`
+}
+
+// GetLocationRecordArrayStatistics computes statistics on the usage of
+// a LocationRecordArray by iterating over all of its entries. The size
+// of the LocationRecordArray is obtained by calling recordsCount while
+// holding a read lock, as it may grow over time.
+//
+// As this function needs to read all entries, it may take a long time
+// to complete for key-location maps that are stored on disk. It should
+// therefore only be called on demand.
+func GetLocationRecordArrayStatistics(locationRecordArray LocationRecordArray, recordsCount func() int, lock *ShardedRWMutex) (LocationRecordArrayStatistics, error) {
+	lock.RLock()
+	statistics := LocationRecordArrayStatistics{
+		RecordsCount: recordsCount(),
+	}
+	lock.RUnlock()
+
+	for start := 0; start < statistics.RecordsCount; start += locationRecordArrayStatisticsBatchSize {
+		end := start + locationRecordArrayStatisticsBatchSize
+		if end > statistics.RecordsCount {
+			end = statistics.RecordsCount
+		}
+		lock.RLock()
+		for index := start; index < end; index++ {
+			record, err := locationRecordArray.Get(index)
+			if err == ErrLocationRecordInvalid {
+				continue
+			} else if err != nil {
+				lock.RUnlock()
+				return LocationRecordArrayStatistics{}, util.StatusWrapf(err, "Failed to get record at index %d", index)
+			}
+			statistics.ValidRecordsCount++
+			statistics.ValidRecordsSizeBytes += record.Location.SizeBytes
+		}
+		lock.RUnlock()
+	}
+	if statistics.RecordsCount > 0 {
+		statistics.Utilization = float64(statistics.ValidRecordsCount) / float64(statistics.RecordsCount)
+	}
+	return statistics, nil
+}
@@ -0,0 +1,63 @@
+package local_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetLocationRecordArrayStatistics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	locationRecordArray := mock.NewMockLocationRecordArray(ctrl)
+	lock := local.NewShardedRWMutex(4)
+
+	t.Run("Empty", func(t *testing.T) {
+		statistics, err := local.GetLocationRecordArrayStatistics(locationRecordArray, func() int { return 0 }, lock)
+		require.NoError(t, err)
+		require.Equal(t, local.LocationRecordArrayStatistics{}, statistics)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Invalid records should not be counted. The size of
+		// the blobs referenced by valid records should be
+		// summed up.
+		for i := 0; i < 2000; i++ {
+			if i%4 == 0 {
+				locationRecordArray.EXPECT().Get(i).Return(local.LocationRecord{
+					Location: local.Location{
+						BlockIndex:  1,
+						OffsetBytes: int64(i) * 100,
+						SizeBytes:   100,
+					},
+				}, nil)
+			} else {
+				locationRecordArray.EXPECT().Get(i).Return(local.LocationRecord{}, local.ErrLocationRecordInvalid)
+			}
+		}
+
+		statistics, err := local.GetLocationRecordArrayStatistics(locationRecordArray, func() int { return 2000 }, lock)
+		require.NoError(t, err)
+		require.Equal(t, local.LocationRecordArrayStatistics{
+			RecordsCount:          2000,
+			ValidRecordsCount:     500,
+			Utilization:           0.25,
+			ValidRecordsSizeBytes: 50000,
+		}, statistics)
+	})
+
+	t.Run("IOFailure", func(t *testing.T) {
+		locationRecordArray.EXPECT().Get(0).Return(local.LocationRecord{}, local.ErrLocationRecordInvalid)
+		locationRecordArray.EXPECT().Get(1).Return(local.LocationRecord{}, status.Error(codes.Internal, "Disk on fire"))
+
+		_, err := local.GetLocationRecordArrayStatistics(locationRecordArray, func() int { return 10 }, lock)
+		testutil.RequireEqualStatus(t, status.Error(codes.Internal, "Failed to get record at index 1: Disk on fire"), err)
+	})
+}
@@ -0,0 +1,108 @@
+package local
+
+import (
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// LocationRecordStatus is the outcome of validating a single entry in a
+// LocationRecordArray using LocationRecordChecker.
+type LocationRecordStatus int
+
+const (
+	// LocationRecordStatusUnused indicates that the entry is not in
+	// use, either because it was never set or because it refers to
+	// a block that has been released.
+	LocationRecordStatusUnused LocationRecordStatus = iota
+	// LocationRecordStatusValid indicates that the entry refers to
+	// a blob that passed all checks.
+	LocationRecordStatusValid
+	// LocationRecordStatusMisplaced indicates that the entry is
+	// stored at an index that doesn't correspond to its key and
+	// probing attempt. It can never be found by
+	// HashingKeyLocationMap.
+	LocationRecordStatusMisplaced
+	// LocationRecordStatusOutOfBounds indicates that the entry
+	// refers to data that lies outside the part of the block that
+	// has been written.
+	LocationRecordStatusOutOfBounds
+	// LocationRecordStatusCorrupt indicates that the checksum of
+	// the blob referenced by the entry does not match its contents.
+	LocationRecordStatusCorrupt
+)
+
+// LocationRecordChecker validates the entries in a LocationRecordArray
+// against the blocks they reference. It can be used to check the
+// consistency of persistent local storage while it is offline.
+//
+// Because the key-location map only contains hashed keys, the digests
+// of blobs are not known. The contents of blobs can therefore only be
+// validated if they were written by the BlockList returned by
+// NewChecksummingBlockList().
+type LocationRecordChecker struct {
+	locationRecordArray    LocationRecordArray
+	recordsCount           int
+	hashInitialization     uint64
+	blockList              BlockList
+	blockWriteOffsetsBytes []int64
+	blobChecksums          bool
+}
+
+// NewLocationRecordChecker creates a new LocationRecordChecker.
+// blockWriteOffsetsBytes contains the number of bytes written to each
+// of the blocks in the BlockList, as stored in the persistent state.
+//
+// The index at which an entry is stored is only validated if
+// recordsCount is positive. It should be set to zero if records may be
+// stored at indices corresponding to different sizes of the hash
+// table, as is the case while ResizingKeyLocationMap is migrating
+// records.
+func NewLocationRecordChecker(locationRecordArray LocationRecordArray, recordsCount int, hashInitialization uint64, blockList BlockList, blockWriteOffsetsBytes []int64, blobChecksums bool) *LocationRecordChecker {
+	return &LocationRecordChecker{
+		locationRecordArray:    locationRecordArray,
+		recordsCount:           recordsCount,
+		hashInitialization:     hashInitialization,
+		blockList:              blockList,
+		blockWriteOffsetsBytes: blockWriteOffsetsBytes,
+		blobChecksums:          blobChecksums,
+	}
+}
+
+// CheckRecord validates the entry stored at a given index.
+func (c *LocationRecordChecker) CheckRecord(index int) (LocationRecordStatus, error) {
+	record, err := c.locationRecordArray.Get(index)
+	if err == ErrLocationRecordInvalid {
+		return LocationRecordStatusUnused, nil
+	} else if err != nil {
+		return 0, util.StatusWrapf(err, "Failed to get record at index %d", index)
+	}
+
+	if c.recordsCount > 0 && int(record.RecordKey.Hash(c.hashInitialization)%uint64(c.recordsCount)) != index {
+		return LocationRecordStatusMisplaced, nil
+	}
+
+	location := record.Location
+	sizeBytes := location.SizeBytes
+	if c.blobChecksums {
+		sizeBytes += BlobChecksumSizeBytes
+	}
+	if location.BlockIndex < 0 ||
+		location.BlockIndex >= len(c.blockWriteOffsetsBytes) ||
+		location.OffsetBytes < 0 ||
+		location.SizeBytes < 0 ||
+		location.OffsetBytes > c.blockWriteOffsetsBytes[location.BlockIndex]-sizeBytes {
+		return LocationRecordStatusOutOfBounds, nil
+	}
+
+	if c.blobChecksums {
+		valid, err := validateBlobChecksum(
+			c.blockList.GetRaw(location.BlockIndex, location.OffsetBytes, sizeBytes),
+			location.SizeBytes)
+		if err != nil {
+			return 0, util.StatusWrapf(err, "Failed to read blob referenced by record at index %d", index)
+		}
+		if !valid {
+			return LocationRecordStatusCorrupt, nil
+		}
+	}
+	return LocationRecordStatusValid, nil
+}
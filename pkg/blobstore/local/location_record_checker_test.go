@@ -0,0 +1,113 @@
+package local_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLocationRecordChecker(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	locationRecordArray := mock.NewMockLocationRecordArray(ctrl)
+	blockList := mock.NewMockBlockList(ctrl)
+	checker := local.NewLocationRecordChecker(
+		locationRecordArray,
+		/* recordsCount = */ 0,
+		/* hashInitialization = */ 14695981039346656037,
+		blockList,
+		/* blockWriteOffsetsBytes = */ []int64{100, 50},
+		/* blobChecksums = */ true)
+
+	t.Run("Unused", func(t *testing.T) {
+		locationRecordArray.EXPECT().Get(0).Return(local.LocationRecord{}, local.ErrLocationRecordInvalid)
+
+		recordStatus, err := checker.CheckRecord(0)
+		require.NoError(t, err)
+		require.Equal(t, local.LocationRecordStatusUnused, recordStatus)
+	})
+
+	t.Run("GetFailure", func(t *testing.T) {
+		locationRecordArray.EXPECT().Get(1).Return(local.LocationRecord{}, status.Error(codes.Internal, "Disk on fire"))
+
+		_, err := checker.CheckRecord(1)
+		require.Equal(t, status.Error(codes.Internal, "Failed to get record at index 1: Disk on fire"), err)
+	})
+
+	t.Run("OutOfBounds", func(t *testing.T) {
+		// The blob and its checksum must lie entirely within
+		// the part of the block that has been written.
+		locationRecordArray.EXPECT().Get(2).Return(local.LocationRecord{
+			Location: local.Location{BlockIndex: 1, OffsetBytes: 40, SizeBytes: 7},
+		}, nil)
+
+		recordStatus, err := checker.CheckRecord(2)
+		require.NoError(t, err)
+		require.Equal(t, local.LocationRecordStatusOutOfBounds, recordStatus)
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		locationRecordArray.EXPECT().Get(3).Return(local.LocationRecord{
+			Location: local.Location{BlockIndex: 1, OffsetBytes: 41, SizeBytes: 5},
+		}, nil)
+		blockList.EXPECT().GetRaw(1, int64(41), int64(9)).
+			Return(buffer.NewValidatedBufferFromByteSlice(appendBlobChecksum([]byte("Hello"))))
+
+		recordStatus, err := checker.CheckRecord(3)
+		require.NoError(t, err)
+		require.Equal(t, local.LocationRecordStatusValid, recordStatus)
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		corrupted := appendBlobChecksum([]byte("Hello"))
+		corrupted[4] = 'p'
+		locationRecordArray.EXPECT().Get(4).Return(local.LocationRecord{
+			Location: local.Location{BlockIndex: 0, OffsetBytes: 20, SizeBytes: 5},
+		}, nil)
+		blockList.EXPECT().GetRaw(0, int64(20), int64(9)).
+			Return(buffer.NewValidatedBufferFromByteSlice(corrupted))
+
+		recordStatus, err := checker.CheckRecord(4)
+		require.NoError(t, err)
+		require.Equal(t, local.LocationRecordStatusCorrupt, recordStatus)
+	})
+
+	t.Run("Misplaced", func(t *testing.T) {
+		// Records should be stored at the slot that
+		// corresponds to their key and probing attempt.
+		checker := local.NewLocationRecordChecker(
+			locationRecordArray,
+			/* recordsCount = */ 10,
+			/* hashInitialization = */ 14695981039346656037,
+			blockList,
+			/* blockWriteOffsetsBytes = */ []int64{100, 50},
+			/* blobChecksums = */ false)
+		recordKey := local.LocationRecordKey{Key: local.Key{1, 2, 3}, Attempt: 4}
+		slot := int(recordKey.Hash(14695981039346656037) % 10)
+		record := local.LocationRecord{
+			RecordKey: recordKey,
+			Location:  local.Location{BlockIndex: 0, OffsetBytes: 20, SizeBytes: 5},
+		}
+
+		locationRecordArray.EXPECT().Get((slot+1)%10).Return(record, nil)
+
+		recordStatus, err := checker.CheckRecord((slot + 1) % 10)
+		require.NoError(t, err)
+		require.Equal(t, local.LocationRecordStatusMisplaced, recordStatus)
+
+		// Without blob checksums, only the location of the
+		// blob can be validated.
+		locationRecordArray.EXPECT().Get(slot).Return(record, nil)
+
+		recordStatus, err = checker.CheckRecord(slot)
+		require.NoError(t, err)
+		require.Equal(t, local.LocationRecordStatusValid, recordStatus)
+	})
+}
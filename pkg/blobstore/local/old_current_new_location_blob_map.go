@@ -51,7 +51,9 @@ type oldBlockState struct {
 // future, which is why it needs to be copied into the "new" group when
 // requested to be retained. Data in the "current" group is assumed to
 // remain present for the time being, which is why it is left in place.
-// This copying is performed by KeyBlobMapBackedBlobAccess.
+// This copying is performed by KeyBlobMapBackedBlobAccess. The exact
+// decision whether to copy data is made by a BlobRefreshPolicy, which
+// may deviate from this scheme.
 //
 // Below is an illustration of how the blocks of data may be laid out at
 // a given point in time. Every column of █ characters corresponds to a
@@ -103,6 +105,7 @@ type OldCurrentNewLocationBlobMap struct {
 	desiredOldBlocksCount           int
 	desiredCurrentAndNewBlocksCount int
 	desiredNewBlocksCount           int
+	refreshPolicy                   BlobRefreshPolicy
 
 	// The number of blocks present in the underlying BlockList,
 	// partitioned into "old", "current" and "new".
@@ -120,16 +123,22 @@ type OldCurrentNewLocationBlobMap struct {
 	allocationAttemptsRemaining int
 	allocationBlockIndex        int
 
-	lastRemovedOldBlockInsertionTime prometheus.Gauge
+	lastRemovedOldBlockInsertionTime        prometheus.Gauge
+	lastRemovedOldBlockInsertionTimeSeconds float64
 }
 
 func unixTime() float64 {
 	return time.Now().Sub(time.Unix(0, 0)).Seconds()
 }
 
+func fromUnixTime(t float64) time.Time {
+	return time.Unix(0, 0).Add(time.Duration(t * float64(time.Second))).UTC()
+}
+
 // NewOldCurrentNewLocationBlobMap creates a new instance of
-// OldCurrentNewLocationBlobMap.
-func NewOldCurrentNewLocationBlobMap(blockList BlockList, errorLogger util.ErrorLogger, name string, blockSizeBytes int64, oldBlocksCount, currentBlocksCount, newBlocksCount, initialBlocksCount int) *OldCurrentNewLocationBlobMap {
+// OldCurrentNewLocationBlobMap. The provided BlobRefreshPolicy decides
+// which blobs that are accessed need to be refreshed.
+func NewOldCurrentNewLocationBlobMap(blockList BlockList, errorLogger util.ErrorLogger, name string, blockSizeBytes int64, oldBlocksCount, currentBlocksCount, newBlocksCount, initialBlocksCount int, refreshPolicy BlobRefreshPolicy) *OldCurrentNewLocationBlobMap {
 	oldCurrentNewLocationBlobMapPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(oldCurrentNewLocationBlobMapLastRemovedOldBlockInsertionTime)
 	})
@@ -141,6 +150,7 @@ func NewOldCurrentNewLocationBlobMap(blockList BlockList, errorLogger util.Error
 		desiredOldBlocksCount:           oldBlocksCount,
 		desiredCurrentAndNewBlocksCount: currentBlocksCount + newBlocksCount,
 		desiredNewBlocksCount:           newBlocksCount,
+		refreshPolicy:                   refreshPolicy,
 
 		allocationBlockIndex: -1,
 
@@ -148,6 +158,7 @@ func NewOldCurrentNewLocationBlobMap(blockList BlockList, errorLogger util.Error
 	}
 	now := unixTime()
 	lbm.lastRemovedOldBlockInsertionTime.Set(now)
+	lbm.lastRemovedOldBlockInsertionTimeSeconds = now
 
 	// Configure the layout based on the number of blocks that were
 	// persisted and restored.
@@ -235,15 +246,53 @@ func (lbm *OldCurrentNewLocationBlobMap) increaseTotalBlocksToBeReleased(newValu
 // contents.
 func (lbm *OldCurrentNewLocationBlobMap) Get(location Location) (LocationBlobGetter, bool) {
 	return func(digest digest.Digest) buffer.Buffer {
-		totalBlocksToBeReleased := lbm.totalBlocksReleased + uint64(location.BlockIndex) + 1
-		return lbm.blockList.Get(location.BlockIndex, digest, location.OffsetBytes, location.SizeBytes, func(dataIsValid bool) {
-			if !dataIsValid {
-				if blocksReleased := lbm.increaseTotalBlocksToBeReleased(totalBlocksToBeReleased); blocksReleased > 0 {
-					lbm.errorLogger.Log(status.Errorf(codes.Internal, "Releasing %d blocks due to a data integrity error", blocksReleased))
-				}
+		return lbm.blockList.Get(location.BlockIndex, digest, location.OffsetBytes, location.SizeBytes, lbm.newDataIntegrityCallback(location.BlockIndex))
+	}, lbm.refreshPolicy.ShouldRefresh(lbm.getBlockGroup(location.BlockIndex), lbm.getBlobID(location))
+}
+
+// getBlockGroup returns the group in which the block with a given
+// index is placed.
+func (lbm *OldCurrentNewLocationBlobMap) getBlockGroup(blockIndex int) BlockGroup {
+	if blockIndex < len(lbm.oldBlocks) {
+		return BlockGroupOld
+	}
+	if blockIndex < len(lbm.oldBlocks)+lbm.currentBlocks {
+		return BlockGroupCurrent
+	}
+	return BlockGroupNew
+}
+
+// getBlobID returns a value that uniquely identifies the copy of a
+// blob stored at a given location, for use by BlobRefreshPolicy. As
+// blocks are never reused, the position at which the blob would have
+// been stored if all blocks were laid out consecutively is used.
+func (lbm *OldCurrentNewLocationBlobMap) getBlobID(location Location) uint64 {
+	absoluteBlockIndex := lbm.totalBlocksReleased + uint64(location.BlockIndex)
+	return absoluteBlockIndex*uint64(lbm.blockSizeBytes) + uint64(location.OffsetBytes)
+}
+
+// GetRaw obtains the raw contents of a region of storage, starting at
+// the offset of a given Location. This may be used to access data that
+// is stored alongside the blob (e.g., checksums). In addition to the
+// contents, a DataIntegrityCallback is returned that can be invoked to
+// report data corruption. Like Get(), this function must be called
+// while holding a read lock.
+func (lbm *OldCurrentNewLocationBlobMap) GetRaw(location Location, sizeBytes int64) (buffer.Buffer, buffer.DataIntegrityCallback) {
+	return lbm.blockList.GetRaw(location.BlockIndex, location.OffsetBytes, sizeBytes), lbm.newDataIntegrityCallback(location.BlockIndex)
+}
+
+// newDataIntegrityCallback creates a DataIntegrityCallback that
+// releases the block with a given index, and all blocks preceding it,
+// when data corruption is reported.
+func (lbm *OldCurrentNewLocationBlobMap) newDataIntegrityCallback(blockIndex int) buffer.DataIntegrityCallback {
+	totalBlocksToBeReleased := lbm.totalBlocksReleased + uint64(blockIndex) + 1
+	return func(dataIsValid bool) {
+		if !dataIsValid {
+			if blocksReleased := lbm.increaseTotalBlocksToBeReleased(totalBlocksToBeReleased); blocksReleased > 0 {
+				lbm.errorLogger.Log(status.Errorf(codes.Internal, "Releasing %d blocks due to a data integrity error", blocksReleased))
 			}
-		})
-	}, location.BlockIndex < len(lbm.oldBlocks)
+		}
+	}
 }
 
 // startAllocatingFromBlock resets the counters used to determine from
@@ -268,6 +317,7 @@ func (lbm *OldCurrentNewLocationBlobMap) popFront() {
 
 func (lbm *OldCurrentNewLocationBlobMap) removeOldestOldBlock() {
 	lbm.lastRemovedOldBlockInsertionTime.Set(lbm.oldBlocks[0].insertionTime)
+	lbm.lastRemovedOldBlockInsertionTimeSeconds = lbm.oldBlocks[0].insertionTime
 	lbm.oldBlocks = lbm.oldBlocks[1:]
 }
 
@@ -391,3 +441,52 @@ func (lbm *OldCurrentNewLocationBlobMap) Put(sizeBytes int64) (LocationBlobPutWr
 		}
 	}, nil
 }
+
+// BlockStatistics contains information on the usage of a single block
+// managed by OldCurrentNewLocationBlobMap.
+type BlockStatistics struct {
+	Group          string `json:"group"`
+	AllocatedBytes int64  `json:"allocated_bytes"`
+	// The time at which the block was moved into the "old" group,
+	// if applicable.
+	OldInsertionTime *time.Time `json:"old_insertion_time,omitempty"`
+}
+
+// LocationBlobMapStatistics contains information on the usage of all
+// blocks managed by OldCurrentNewLocationBlobMap, ordered from oldest
+// to newest.
+type LocationBlobMapStatistics struct {
+	Blocks []BlockStatistics `json:"blocks"`
+	// The time at which the last block that was discarded was moved
+	// into the "old" group. The time between this moment and now is
+	// an indicator for the worst-case blob retention time.
+	LastRemovedOldBlockInsertionTime time.Time `json:"last_removed_old_block_insertion_time"`
+}
+
+// GetStatistics returns information on the usage of all blocks,
+// for display on the diagnostics HTTP server. This function must be
+// called while holding a read lock.
+func (lbm *OldCurrentNewLocationBlobMap) GetStatistics() LocationBlobMapStatistics {
+	blocksCount := len(lbm.oldBlocks) + lbm.currentBlocks + lbm.newBlocks
+	statistics := LocationBlobMapStatistics{
+		Blocks:                           make([]BlockStatistics, 0, blocksCount),
+		LastRemovedOldBlockInsertionTime: fromUnixTime(lbm.lastRemovedOldBlockInsertionTimeSeconds),
+	}
+	for i := 0; i < blocksCount; i++ {
+		blockStatistics := BlockStatistics{
+			AllocatedBytes: lbm.blockList.GetAllocatedBytes(i),
+		}
+		switch lbm.getBlockGroup(i) {
+		case BlockGroupOld:
+			blockStatistics.Group = "old"
+			insertionTime := fromUnixTime(lbm.oldBlocks[i].insertionTime)
+			blockStatistics.OldInsertionTime = &insertionTime
+		case BlockGroupCurrent:
+			blockStatistics.Group = "current"
+		default:
+			blockStatistics.Group = "new"
+		}
+		statistics.Blocks = append(statistics.Blocks, blockStatistics)
+	}
+	return statistics
+}
@@ -27,7 +27,8 @@ func TestOldCurrentNewLocationBlobMapAllocationPattern(t *testing.T) {
 		/* oldBlocksCount = */ 2,
 		/* currentBlocksCount = */ 4,
 		/* newBlocksCount = */ 4,
-		/* initialBlocksCount = */ 10)
+		/* initialBlocksCount = */ 10,
+		local.FIFOBlobRefreshPolicy)
 
 	// After starting up, there should be a uniform distribution on
 	// the "current" blocks and an inverse exponential distribution
@@ -86,7 +87,8 @@ func TestOldCurrentNewLocationBlobMapDataCorruption(t *testing.T) {
 		/* oldBlocksCount = */ 2,
 		/* currentBlocksCount = */ 4,
 		/* newBlocksCount = */ 4,
-		/* initialBlocksCount = */ 10)
+		/* initialBlocksCount = */ 10,
+		local.FIFOBlobRefreshPolicy)
 
 	// Perform a Get() call against block 1. Return a buffer that
 	// will trigger a data integrity error, as the digest
@@ -163,3 +165,48 @@ func TestOldCurrentNewLocationBlobMapDataCorruption(t *testing.T) {
 	_, err = locationBlobPutWriter(buffer.NewBufferFromError(status.Error(codes.Unknown, "Client hung up")))()
 	require.Equal(t, status.Error(codes.Unknown, "Client hung up"), err)
 }
+
+func TestOldCurrentNewLocationBlobMapGetStatistics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	blockList := mock.NewMockBlockList(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	locationBlobMap := local.NewOldCurrentNewLocationBlobMap(
+		blockList,
+		errorLogger,
+		"cas",
+		/* blockSizeBytes = */ 16,
+		/* oldBlocksCount = */ 1,
+		/* currentBlocksCount = */ 1,
+		/* newBlocksCount = */ 2,
+		/* initialBlocksCount = */ 4,
+		local.FIFOBlobRefreshPolicy)
+
+	// Force the first "new" block to be moved to "current" by
+	// letting it run out of space.
+	blockList.EXPECT().HasSpace(1, int64(5)).Return(false)
+	blockList.EXPECT().HasSpace(2, int64(5)).Return(true).Times(2)
+	blockListPutWriter := mock.NewMockBlockListPutWriter(ctrl)
+	blockList.EXPECT().Put(2, int64(5)).Return(blockListPutWriter.Call)
+	_, err := locationBlobMap.Put(5)
+	require.NoError(t, err)
+
+	blockList.EXPECT().GetAllocatedBytes(0).Return(int64(16))
+	blockList.EXPECT().GetAllocatedBytes(1).Return(int64(14))
+	blockList.EXPECT().GetAllocatedBytes(2).Return(int64(5))
+	blockList.EXPECT().GetAllocatedBytes(3).Return(int64(0))
+
+	statistics := locationBlobMap.GetStatistics()
+	require.Len(t, statistics.Blocks, 4)
+	require.NotNil(t, statistics.Blocks[0].OldInsertionTime)
+	require.Equal(t, local.BlockStatistics{
+		Group:            "old",
+		AllocatedBytes:   16,
+		OldInsertionTime: statistics.Blocks[0].OldInsertionTime,
+	}, statistics.Blocks[0])
+	require.Equal(t, []local.BlockStatistics{
+		{Group: "current", AllocatedBytes: 14},
+		{Group: "new", AllocatedBytes: 5},
+		{Group: "new", AllocatedBytes: 0},
+	}, statistics.Blocks[1:])
+}
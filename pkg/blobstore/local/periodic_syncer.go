@@ -4,6 +4,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/clock"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
 	"github.com/buildbarn/bb-storage/pkg/util"
@@ -19,11 +20,19 @@ type PeriodicSyncer struct {
 	errorRetryInterval               time.Duration
 	minimumEpochInterval             time.Duration
 	keyLocationMapHashInitialization uint64
+	keyLocationMapRecordsCount       func() int64
+	blobChecksums                    bool
 	dataSyncer                       DataSyncer
 
-	sourceLock *sync.RWMutex
+	sourceLock *ShardedRWMutex
 	source     PersistentStateSource
 
+	// Serializes synchronization of data between ProcessBlockPut()
+	// and CreateSnapshot(). Interleaving calls to
+	// NotifySyncStarting() and NotifySyncCompleted() would cause
+	// data to be reported as synchronized prematurely.
+	syncLock sync.Mutex
+
 	storeLock sync.Mutex
 	store     PersistentStateStore
 
@@ -40,14 +49,20 @@ type PeriodicSyncer struct {
 type DataSyncer func() error
 
 // NewPeriodicSyncer creates a new PeriodicSyncer according to the
-// arguments provided.
-func NewPeriodicSyncer(source PersistentStateSource, sourceLock *sync.RWMutex, store PersistentStateStore, clock clock.Clock, errorLogger util.ErrorLogger, errorRetryInterval, minimumEpochInterval time.Duration, keyLocationMapHashInitialization uint64, dataSyncer DataSyncer) *PeriodicSyncer {
+// arguments provided. The size of the key-location map that is written
+// into the persistent state is obtained by calling
+// keyLocationMapRecordsCount while holding sourceLock. blobChecksums
+// indicates whether blobs are stored with checksums, which is recorded
+// so that blocks written in another format are not reused.
+func NewPeriodicSyncer(source PersistentStateSource, sourceLock *ShardedRWMutex, store PersistentStateStore, clock clock.Clock, errorLogger util.ErrorLogger, errorRetryInterval, minimumEpochInterval time.Duration, keyLocationMapHashInitialization uint64, keyLocationMapRecordsCount func() int64, blobChecksums bool, dataSyncer DataSyncer) *PeriodicSyncer {
 	return &PeriodicSyncer{
 		clock:                            clock,
 		errorLogger:                      errorLogger,
 		errorRetryInterval:               errorRetryInterval,
 		minimumEpochInterval:             minimumEpochInterval,
 		keyLocationMapHashInitialization: keyLocationMapHashInitialization,
+		keyLocationMapRecordsCount:       keyLocationMapRecordsCount,
+		blobChecksums:                    blobChecksums,
 		dataSyncer:                       dataSyncer,
 
 		source:                  source,
@@ -73,12 +88,15 @@ func (ps *PeriodicSyncer) writePersistentState() error {
 
 	ps.sourceLock.RLock()
 	oldestEpochID, blocks := ps.source.GetPersistentState()
+	keyLocationMapRecordsCount := ps.keyLocationMapRecordsCount()
 	ps.sourceLock.RUnlock()
 
 	if err := ps.store.WritePersistentState(&pb.PersistentState{
 		OldestEpochId:                    oldestEpochID,
 		Blocks:                           blocks,
 		KeyLocationMapHashInitialization: ps.keyLocationMapHashInitialization,
+		KeyLocationMapRecordsCount:       keyLocationMapRecordsCount,
+		BlobChecksums:                    ps.blobChecksums,
 	}); err != nil {
 		return err
 	}
@@ -149,6 +167,7 @@ func (ps *PeriodicSyncer) ProcessBlockPut() {
 	}
 	ps.lastSynchronizationTime = <-t
 
+	ps.syncLock.Lock()
 	ps.sourceLock.Lock()
 	ps.source.NotifySyncStarting()
 	ps.sourceLock.Unlock()
@@ -167,6 +186,72 @@ func (ps *PeriodicSyncer) ProcessBlockPut() {
 	ps.sourceLock.Lock()
 	ps.source.NotifySyncCompleted()
 	ps.sourceLock.Unlock()
+	ps.syncLock.Unlock()
 
 	ps.writePersistentStateRetrying()
 }
+
+// Synchronize all data that has been written up to this point, and
+// update the persistent state stored on disk accordingly. Unlike
+// ProcessBlockPut(), this function does not wait for writes to occur,
+// nor does it retry in case of errors. It can be called prior to
+// shutting down, so that no data is lost upon restart.
+func (ps *PeriodicSyncer) Synchronize() error {
+	ps.syncLock.Lock()
+	ps.sourceLock.Lock()
+	ps.source.NotifySyncStarting()
+	ps.sourceLock.Unlock()
+
+	if err := ps.dataSyncer(); err != nil {
+		ps.syncLock.Unlock()
+		return util.StatusWrap(err, "Failed to synchronize data")
+	}
+
+	ps.sourceLock.Lock()
+	ps.source.NotifySyncCompleted()
+	ps.sourceLock.Unlock()
+	ps.syncLock.Unlock()
+
+	if err := ps.writePersistentState(); err != nil {
+		return util.StatusWrap(err, "Failed to write persistent state")
+	}
+	return nil
+}
+
+// CreateSnapshot synchronizes all data that has been written up to
+// this point, and returns a persistent state that describes it. Buffers
+// containing the contents of the blocks referenced by the persistent
+// state are returned as well. These buffers must always be discarded,
+// as blocks cannot be reused while they are open.
+//
+// Writes performed after synchronization has started are not part of
+// the snapshot. These use newer epochs, meaning that entries in the
+// key-location map that reference them will be ignored when the
+// snapshot is restored. It is therefore safe to copy the key-location
+// map after this function returns, without blocking writes.
+func (ps *PeriodicSyncer) CreateSnapshot() (*pb.PersistentState, []buffer.Buffer, error) {
+	ps.syncLock.Lock()
+	defer ps.syncLock.Unlock()
+
+	ps.sourceLock.Lock()
+	ps.source.NotifySyncStarting()
+	ps.sourceLock.Unlock()
+
+	if err := ps.dataSyncer(); err != nil {
+		return nil, nil, util.StatusWrap(err, "Failed to synchronize data")
+	}
+
+	ps.sourceLock.Lock()
+	ps.source.NotifySyncCompleted()
+	oldestEpochID, blocks, contents := ps.source.GetSnapshot()
+	keyLocationMapRecordsCount := ps.keyLocationMapRecordsCount()
+	ps.sourceLock.Unlock()
+
+	return &pb.PersistentState{
+		OldestEpochId:                    oldestEpochID,
+		Blocks:                           blocks,
+		KeyLocationMapHashInitialization: ps.keyLocationMapHashInitialization,
+		KeyLocationMapRecordsCount:       keyLocationMapRecordsCount,
+		BlobChecksums:                    ps.blobChecksums,
+	}, contents, nil
+}
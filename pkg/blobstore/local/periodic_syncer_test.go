@@ -1,14 +1,16 @@
 package local_test
 
 import (
-	"sync"
 	"testing"
 	"time"
 
 	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
 	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -18,7 +20,7 @@ func TestPeriodicSyncerProcessBlockRelease(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
 	source := mock.NewMockPersistentStateSource(ctrl)
-	var sourceLock sync.RWMutex
+	sourceLock := local.NewShardedRWMutex(4)
 	store := mock.NewMockPersistentStateStore(ctrl)
 	clock := mock.NewMockClock(ctrl)
 	clock.EXPECT().Now().Return(time.Unix(1000, 0))
@@ -26,13 +28,15 @@ func TestPeriodicSyncerProcessBlockRelease(t *testing.T) {
 	dataSyncer := mock.NewMockDataSyncer(ctrl)
 	periodicSyncer := local.NewPeriodicSyncer(
 		source,
-		&sourceLock,
+		sourceLock,
 		store,
 		clock,
 		errorLogger,
 		30*time.Second,
 		time.Minute,
 		0xdf280dd45b2c39e,
+		func() int64 { return 1000 },
+		false,
 		dataSyncer.Call)
 
 	blockReleaseWakeup := make(chan struct{}, 1)
@@ -72,6 +76,7 @@ func TestPeriodicSyncerProcessBlockRelease(t *testing.T) {
 				},
 			},
 			KeyLocationMapHashInitialization: 0xdf280dd45b2c39e,
+			KeyLocationMapRecordsCount:       1000,
 		}).Return(status.Error(codes.Internal, "Permission denied")),
 
 		// When the above fails, we should wait a bit before
@@ -105,6 +110,7 @@ func TestPeriodicSyncerProcessBlockRelease(t *testing.T) {
 				},
 			},
 			KeyLocationMapHashInitialization: 0xdf280dd45b2c39e,
+			KeyLocationMapRecordsCount:       1000,
 		}),
 
 		// Upon success, PersistentBlockList should be notified,
@@ -118,7 +124,7 @@ func TestPeriodicSyncerProcessBlockPut(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
 	source := mock.NewMockPersistentStateSource(ctrl)
-	var sourceLock sync.RWMutex
+	sourceLock := local.NewShardedRWMutex(4)
 	store := mock.NewMockPersistentStateStore(ctrl)
 	clock := mock.NewMockClock(ctrl)
 	clock.EXPECT().Now().Return(time.Unix(1000, 0))
@@ -126,13 +132,15 @@ func TestPeriodicSyncerProcessBlockPut(t *testing.T) {
 	dataSyncer := mock.NewMockDataSyncer(ctrl)
 	periodicSyncer := local.NewPeriodicSyncer(
 		source,
-		&sourceLock,
+		sourceLock,
 		store,
 		clock,
 		errorLogger,
 		30*time.Second,
 		time.Minute,
 		0xdf280dd45b2c39e,
+		func() int64 { return 1000 },
+		false,
 		dataSyncer.Call)
 
 	blockPutWakeup := make(chan struct{}, 1)
@@ -201,8 +209,162 @@ func TestPeriodicSyncerProcessBlockPut(t *testing.T) {
 				},
 			},
 			KeyLocationMapHashInitialization: 0xdf280dd45b2c39e,
+			KeyLocationMapRecordsCount:       1000,
 		}),
 		source.EXPECT().NotifyPersistentStateWritten())
 
 	periodicSyncer.ProcessBlockPut()
 }
+
+func TestPeriodicSyncerCreateSnapshot(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	source := mock.NewMockPersistentStateSource(ctrl)
+	sourceLock := local.NewShardedRWMutex(4)
+	store := mock.NewMockPersistentStateStore(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Unix(1000, 0))
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	dataSyncer := mock.NewMockDataSyncer(ctrl)
+	periodicSyncer := local.NewPeriodicSyncer(
+		source,
+		sourceLock,
+		store,
+		clock,
+		errorLogger,
+		30*time.Second,
+		time.Minute,
+		0xdf280dd45b2c39e,
+		func() int64 { return 1000 },
+		true,
+		dataSyncer.Call)
+
+	t.Run("SyncFailure", func(t *testing.T) {
+		// Unlike ProcessBlockPut(), synchronization failures
+		// should be returned immediately.
+		gomock.InOrder(
+			source.EXPECT().NotifySyncStarting(),
+			dataSyncer.EXPECT().Call().Return(status.Error(codes.Internal, "Disk on fire")))
+
+		_, _, err := periodicSyncer.CreateSnapshot()
+		require.Equal(t, status.Error(codes.Internal, "Failed to synchronize data: Disk on fire"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		blockContents := buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))
+		gomock.InOrder(
+			source.EXPECT().NotifySyncStarting(),
+			dataSyncer.EXPECT().Call(),
+			source.EXPECT().NotifySyncCompleted(),
+			source.EXPECT().GetSnapshot().Return(uint32(7), []*pb.BlockState{
+				{
+					BlockLocation: &pb.BlockLocation{
+						OffsetBytes: 1024,
+						SizeBytes:   1024,
+					},
+					WriteOffsetBytes: 5,
+					EpochHashSeeds:   []uint64{1, 2, 3},
+				},
+			}, []buffer.Buffer{blockContents}))
+
+		persistentState, contents, err := periodicSyncer.CreateSnapshot()
+		require.NoError(t, err)
+		testutil.RequireEqualProto(t, &pb.PersistentState{
+			OldestEpochId: 7,
+			Blocks: []*pb.BlockState{
+				{
+					BlockLocation: &pb.BlockLocation{
+						OffsetBytes: 1024,
+						SizeBytes:   1024,
+					},
+					WriteOffsetBytes: 5,
+					EpochHashSeeds:   []uint64{1, 2, 3},
+				},
+			},
+			KeyLocationMapHashInitialization: 0xdf280dd45b2c39e,
+			KeyLocationMapRecordsCount:       1000,
+			BlobChecksums:                    true,
+		}, persistentState)
+		require.Equal(t, []buffer.Buffer{blockContents}, contents)
+	})
+}
+
+func TestPeriodicSyncerSynchronize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	source := mock.NewMockPersistentStateSource(ctrl)
+	sourceLock := local.NewShardedRWMutex(4)
+	store := mock.NewMockPersistentStateStore(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Unix(1000, 0))
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	dataSyncer := mock.NewMockDataSyncer(ctrl)
+	periodicSyncer := local.NewPeriodicSyncer(
+		source,
+		sourceLock,
+		store,
+		clock,
+		errorLogger,
+		30*time.Second,
+		time.Minute,
+		0xdf280dd45b2c39e,
+		func() int64 { return 1000 },
+		false,
+		dataSyncer.Call)
+
+	t.Run("SyncFailure", func(t *testing.T) {
+		// Unlike ProcessBlockPut(), synchronization failures
+		// should be returned immediately.
+		gomock.InOrder(
+			source.EXPECT().NotifySyncStarting(),
+			dataSyncer.EXPECT().Call().Return(status.Error(codes.Internal, "Disk on fire")))
+
+		require.Equal(t, status.Error(codes.Internal, "Failed to synchronize data: Disk on fire"), periodicSyncer.Synchronize())
+	})
+
+	t.Run("WriteFailure", func(t *testing.T) {
+		gomock.InOrder(
+			source.EXPECT().NotifySyncStarting(),
+			dataSyncer.EXPECT().Call(),
+			source.EXPECT().NotifySyncCompleted(),
+			source.EXPECT().GetPersistentState().Return(uint32(7), nil),
+			store.EXPECT().WritePersistentState(gomock.Any()).Return(status.Error(codes.Internal, "Permission denied")))
+
+		require.Equal(t, status.Error(codes.Internal, "Failed to write persistent state: Permission denied"), periodicSyncer.Synchronize())
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		gomock.InOrder(
+			source.EXPECT().NotifySyncStarting(),
+			dataSyncer.EXPECT().Call(),
+			source.EXPECT().NotifySyncCompleted(),
+			source.EXPECT().GetPersistentState().Return(uint32(7), []*pb.BlockState{
+				{
+					BlockLocation: &pb.BlockLocation{
+						OffsetBytes: 1024,
+						SizeBytes:   1024,
+					},
+					WriteOffsetBytes: 456,
+					EpochHashSeeds:   []uint64{1, 2, 3, 4},
+				},
+			}),
+			store.EXPECT().WritePersistentState(&pb.PersistentState{
+				OldestEpochId: 7,
+				Blocks: []*pb.BlockState{
+					{
+						BlockLocation: &pb.BlockLocation{
+							OffsetBytes: 1024,
+							SizeBytes:   1024,
+						},
+						WriteOffsetBytes: 456,
+						EpochHashSeeds:   []uint64{1, 2, 3, 4},
+					},
+				},
+				KeyLocationMapHashInitialization: 0xdf280dd45b2c39e,
+				KeyLocationMapRecordsCount:       1000,
+			}),
+			source.EXPECT().NotifyPersistentStateWritten())
+
+		require.NoError(t, periodicSyncer.Synchronize())
+	})
+}
@@ -151,10 +151,18 @@ func NewPersistentBlockList(blockAllocator BlockAllocator, sectorSizeBytes int,
 			bl.epochLastAbsoluteBlockIndex = append(bl.epochLastAbsoluteBlockIndex, len(bl.blocks))
 		}
 
+		allocationOffsetSectors := (blockState.WriteOffsetBytes + int64(sectorSizeBytes) - 1) / int64(sectorSizeBytes)
+		if blockState.BlockLocation.GetSizeBytes() != blockSectorCount*int64(sectorSizeBytes) {
+			// The block was created by a previous run that
+			// used a different block size. Its contents may
+			// still be read, but no new data may be written
+			// into it, as it may overlap with other blocks.
+			allocationOffsetSectors = blockSectorCount
+		}
 		bl.blocks = append(bl.blocks, persistentBlockInfo{
 			block:                    newSharedBlock(block),
 			blockLocation:            blockState.BlockLocation,
-			allocationOffsetSectors:  (blockState.WriteOffsetBytes + int64(sectorSizeBytes) - 1) / int64(sectorSizeBytes),
+			allocationOffsetSectors:  allocationOffsetSectors,
 			writtenOffsetBytes:       blockState.WriteOffsetBytes,
 			synchronizingOffsetBytes: blockState.WriteOffsetBytes,
 			synchronizedOffsetBytes:  blockState.WriteOffsetBytes,
@@ -262,6 +270,12 @@ func (bl *PersistentBlockList) Get(index int, digest digest.Digest, offsetBytes,
 	return bl.blocks[index].block.block.Get(digest, offsetBytes, sizeBytes, dataIntegrityCallback)
 }
 
+// GetRaw obtains the contents of a region of one of the blocks managed
+// by this BlockList, without performing any validation.
+func (bl *PersistentBlockList) GetRaw(index int, offsetBytes, sizeBytes int64) buffer.Buffer {
+	return bl.blocks[index].block.block.GetRaw(offsetBytes, sizeBytes)
+}
+
 func (bl *PersistentBlockList) toSectors(sizeBytes int64) int64 {
 	// Determine the number of sectors needed to store the object.
 	//
@@ -278,6 +292,12 @@ func (bl *PersistentBlockList) HasSpace(index int, sizeBytes int64) bool {
 	return bl.blockSectorCount-blockInfo.allocationOffsetSectors >= bl.toSectors(sizeBytes)
 }
 
+// GetAllocatedBytes returns the amount of space in a block with a
+// given index that has been allocated by Put().
+func (bl *PersistentBlockList) GetAllocatedBytes(index int) int64 {
+	return bl.blocks[index].allocationOffsetSectors * int64(bl.sectorSizeBytes)
+}
+
 // Put data into a block managed by the BlockList.
 func (bl *PersistentBlockList) Put(index int, sizeBytes int64) BlockListPutWriter {
 	// Allocate space from the requested block.
@@ -382,10 +402,9 @@ func (bl *PersistentBlockList) NotifySyncCompleted() {
 	}
 }
 
-// GetPersistentState returns information that needs to be persisted to
-// disk to be able to restore the layout of the BlockList after a
-// restart.
-func (bl *PersistentBlockList) GetPersistentState() (uint32, []*pb.BlockState) {
+// getSynchronizedBlockStates returns information on all blocks that
+// contain epochs whose data has been synchronized to storage.
+func (bl *PersistentBlockList) getSynchronizedBlockStates() []*pb.BlockState {
 	// Create a list of all of the epochs that we've synchronized
 	// properly. Partition the epochs by the block that was the last
 	// block at the time the epoch was created. This gives a compact
@@ -411,6 +430,14 @@ func (bl *PersistentBlockList) GetPersistentState() (uint32, []*pb.BlockState) {
 			EpochHashSeeds:   bl.epochHashSeeds[firstEpochIndex:lastEpochIndex],
 		})
 	}
+	return blocks
+}
+
+// GetPersistentState returns information that needs to be persisted to
+// disk to be able to restore the layout of the BlockList after a
+// restart.
+func (bl *PersistentBlockList) GetPersistentState() (uint32, []*pb.BlockState) {
+	blocks := bl.getSynchronizedBlockStates()
 
 	// Store which blocks we're removing from the persistent state.
 	// This allows NotifyPersistentStateWritten() to remove them
@@ -420,6 +447,22 @@ func (bl *PersistentBlockList) GetPersistentState() (uint32, []*pb.BlockState) {
 	return bl.oldestEpochID, blocks
 }
 
+// GetSnapshot returns the same information as GetPersistentState(),
+// together with buffers containing the synchronized contents of each of
+// the blocks. The buffers hold references to the blocks, meaning that
+// the blocks are not reused until the buffers are discarded.
+//
+// Unlike GetPersistentState(), this function has no influence on when
+// blocks are released.
+func (bl *PersistentBlockList) GetSnapshot() (uint32, []*pb.BlockState, []buffer.Buffer) {
+	blocks := bl.getSynchronizedBlockStates()
+	contents := make([]buffer.Buffer, 0, len(blocks))
+	for blockIndex, blockState := range blocks {
+		contents = append(contents, bl.GetRaw(blockIndex, 0, blockState.WriteOffsetBytes))
+	}
+	return bl.oldestEpochID, blocks, contents
+}
+
 // NotifyPersistentStateWritten needs to be called after the data
 // returned by GetPersistentState() is written to disk. This allows
 // PersistentBlockList to recycle blocks that were used previously.
@@ -380,3 +380,46 @@ func TestPersistentBlockListRestorePersistentState(t *testing.T) {
 	require.True(t, blockList.HasSpace(1, 48))
 	require.False(t, blockList.HasSpace(1, 49))
 }
+
+func TestPersistentBlockListRestoreDifferentBlockSize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Blocks created by a previous run that used a different block
+	// size may still be restored.
+	blockAllocator := mock.NewMockBlockAllocator(ctrl)
+	block1 := mock.NewMockBlock(ctrl)
+	blockAllocator.EXPECT().NewBlockAtLocation(&pb.BlockLocation{
+		OffsetBytes: 0,
+		SizeBytes:   240,
+	}).Return(block1, true)
+
+	blockList, blocksRestored := local.NewPersistentBlockList(blockAllocator, 16, 10, 5, []*pb.BlockState{
+		{
+			BlockLocation: &pb.BlockLocation{
+				OffsetBytes: 0,
+				SizeBytes:   240,
+			},
+			WriteOffsetBytes: 42,
+			EpochHashSeeds:   []uint64{0x7c9dbac171efb2ee},
+		},
+	})
+	require.Equal(t, 1, blocksRestored)
+
+	// No new data may be written into the block, as it may overlap
+	// with blocks of the current size.
+	require.False(t, blockList.HasSpace(0, 1))
+
+	// The block should be preserved as part of the persistent state.
+	oldestEpochID, blockStateList := blockList.GetPersistentState()
+	require.Equal(t, uint32(5), oldestEpochID)
+	require.Equal(t, []*pb.BlockState{
+		{
+			BlockLocation: &pb.BlockLocation{
+				OffsetBytes: 0,
+				SizeBytes:   240,
+			},
+			WriteOffsetBytes: 42,
+			EpochHashSeeds:   []uint64{0x7c9dbac171efb2ee},
+		},
+	}, blockStateList)
+}
@@ -1,6 +1,7 @@
 package local
 
 import (
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
 )
 
@@ -59,6 +60,14 @@ type PersistentStateSource interface {
 	// This function must be called while holding a read lock on the
 	// BlockList.
 	GetPersistentState() (uint32, []*pb.BlockState)
+	// GetSnapshot returns the same information as
+	// GetPersistentState(), together with buffers containing the
+	// synchronized contents of every block. Blocks are not reused
+	// for as long as these buffers have not been discarded.
+	//
+	// This function must be called while holding a read lock on the
+	// BlockList.
+	GetSnapshot() (uint32, []*pb.BlockState, []buffer.Buffer)
 
 	// NotifyPersistentStateWritten instructs the BlockList that the
 	// data returned by the last call to GetPersistentState was
@@ -0,0 +1,125 @@
+package local
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ResizingKeyLocationMap is a KeyLocationMap that can be used while the
+// size of a hash table managed by HashingKeyLocationMap is increased.
+// It prevents the need for discarding all existing entries, by
+// migrating them to their new slots incrementally.
+//
+// Growing a hash table is performed in place. The first slots of the
+// resized LocationRecordArray are expected to contain the entries of
+// the hash table prior to resizing. Because LocationRecordKeys contain
+// the probing attempt, it is possible to determine for every record
+// whether it is placed according to the old or new size of the hash
+// table. Records placed according to the old size are reinserted.
+//
+// While migration is in progress, insertions are performed against the
+// resized hash table. Lookups are first performed against the resized
+// hash table, falling back to the hash table of the old size. As
+// insertions may overwrite records that have not been migrated yet,
+// some entries may get lost in the process. As only a fraction of the
+// entries is affected, this is far less disruptive than discarding the
+// contents of the hash table entirely.
+type ResizingKeyLocationMap struct {
+	recordArray          LocationRecordArray
+	hashInitialization   uint64
+	oldRecordsCount      int
+	newRecordsCount      int
+	oldKeyLocationMap    KeyLocationMap
+	newKeyLocationMap    *hashingKeyLocationMap
+	migratedRecordsCount int
+}
+
+// NewResizingKeyLocationMap creates a ResizingKeyLocationMap that
+// migrates records from a hash table of size oldRecordsCount to one of
+// size newRecordsCount. Migration is performed by calling
+// MigrateRecords() repeatedly.
+func NewResizingKeyLocationMap(recordArray LocationRecordArray, oldRecordsCount, newRecordsCount int, hashInitialization uint64, maximumGetAttempts uint32, maximumPutAttempts int, name string) *ResizingKeyLocationMap {
+	// Records stored according to the old size of the hash table
+	// may get displaced by insertions. Instead of moving them to
+	// the next slot, they need to be reinserted.
+	newKeyLocationMap := NewHashingKeyLocationMap(recordArray, newRecordsCount, hashInitialization, maximumGetAttempts, maximumPutAttempts, name).(*hashingKeyLocationMap)
+	newKeyLocationMap.reinsertMisplacedRecords = true
+
+	return &ResizingKeyLocationMap{
+		recordArray:        recordArray,
+		hashInitialization: hashInitialization,
+		oldRecordsCount:    oldRecordsCount,
+		newRecordsCount:    newRecordsCount,
+		oldKeyLocationMap:  NewHashingKeyLocationMap(recordArray, oldRecordsCount, hashInitialization, maximumGetAttempts, maximumPutAttempts, name),
+		newKeyLocationMap:  newKeyLocationMap,
+	}
+}
+
+func (klm *ResizingKeyLocationMap) isMigrating() bool {
+	return klm.migratedRecordsCount < klm.oldRecordsCount
+}
+
+// Get the location of an object, either by looking it up in the
+// resized hash table or the hash table of the old size.
+func (klm *ResizingKeyLocationMap) Get(key Key) (Location, error) {
+	location, err := klm.newKeyLocationMap.Get(key)
+	if status.Code(err) == codes.NotFound && klm.isMigrating() {
+		return klm.oldKeyLocationMap.Get(key)
+	}
+	return location, err
+}
+
+// Put the location of an object in the resized hash table.
+func (klm *ResizingKeyLocationMap) Put(key Key, location Location) error {
+	return klm.newKeyLocationMap.Put(key, location)
+}
+
+// Delete the entry for an object from both the resized hash table and
+// the hash table of the old size, so that it is not migrated later on.
+func (klm *ResizingKeyLocationMap) Delete(key Key) error {
+	if err := klm.newKeyLocationMap.Delete(key); err != nil {
+		return err
+	}
+	if klm.isMigrating() {
+		return klm.oldKeyLocationMap.Delete(key)
+	}
+	return nil
+}
+
+// MigrateRecords migrates up to a given number of records from their
+// slots in the hash table of the old size to the resized hash table.
+// It returns true once all records have been migrated.
+//
+// Calls to this function need to be synchronized with calls to Put()
+// and Get(). The caller must hold a lock that excludes both.
+func (klm *ResizingKeyLocationMap) MigrateRecords(count int) (bool, error) {
+	for i := 0; i < count && klm.isMigrating(); i++ {
+		slot := klm.migratedRecordsCount
+		record, err := klm.recordArray.Get(slot)
+		if err == nil && record.RecordKey.Key != deletedKey {
+			hash := record.RecordKey.Hash(klm.hashInitialization)
+			if int(hash%uint64(klm.oldRecordsCount)) == slot && int(hash%uint64(klm.newRecordsCount)) != slot {
+				// Record is placed according to the
+				// old size of the hash table.
+				if err := klm.newKeyLocationMap.Put(record.RecordKey.Key, record.Location); err != nil {
+					return false, err
+				}
+			}
+		} else if err != ErrLocationRecordInvalid {
+			return false, err
+		}
+		klm.migratedRecordsCount++
+	}
+	return !klm.isMigrating(), nil
+}
+
+// GetPersistentRecordsCount returns the size of the hash table that
+// needs to be stored in persistent state. As long as migration has not
+// completed, the old size is returned. This ensures that migration is
+// resumed if the process is restarted.
+func (klm *ResizingKeyLocationMap) GetPersistentRecordsCount() int64 {
+	if klm.isMigrating() {
+		return int64(klm.oldRecordsCount)
+	}
+	return int64(klm.newRecordsCount)
+}
@@ -0,0 +1,83 @@
+package local_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizingKeyLocationMap(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// Let BlockReferences simply be the block index, offset by
+	// one. This causes zero initialized records to be invalid.
+	resolver := mock.NewMockBlockReferenceResolver(ctrl)
+	resolver.EXPECT().BlockIndexToBlockReference(gomock.Any()).DoAndReturn(
+		func(blockIndex int) (local.BlockReference, uint64) {
+			return local.BlockReference{EpochID: uint32(blockIndex + 1)}, 0
+		}).AnyTimes()
+	resolver.EXPECT().BlockReferenceToBlockIndex(gomock.Any()).DoAndReturn(
+		func(blockReference local.BlockReference) (int, uint64, bool) {
+			if blockReference.EpochID == 0 {
+				return 0, 0, false
+			}
+			return int(blockReference.EpochID - 1), 0, true
+		}).AnyTimes()
+	array := local.NewInMemoryLocationRecordArray(64, resolver)
+
+	// Fill a hash table that only uses the first 16 slots of the
+	// array.
+	oldKLM := local.NewHashingKeyLocationMap(array, 16, 0x970aef1f90c7f916, 8, 32, "cas")
+	locations := map[local.Key]local.Location{}
+	for i := 0; i < 8; i++ {
+		key := local.NewKeyFromString(fmt.Sprintf("Old key %d", i))
+		location := local.Location{BlockIndex: 1, OffsetBytes: int64(i) * 100, SizeBytes: 100}
+		require.NoError(t, oldKLM.Put(key, location))
+		locations[key] = location
+	}
+
+	// Grow the hash table to use all 64 slots. All entries should
+	// remain accessible, both prior to and during migration.
+	klm := local.NewResizingKeyLocationMap(array, 16, 64, 0x970aef1f90c7f916, 8, 32, "cas")
+	require.Equal(t, int64(16), klm.GetPersistentRecordsCount())
+	for key, location := range locations {
+		foundLocation, err := klm.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, location, foundLocation)
+	}
+
+	for i := 0; i < 8; i++ {
+		key := local.NewKeyFromString(fmt.Sprintf("New key %d", i))
+		location := local.Location{BlockIndex: 2, OffsetBytes: int64(i) * 100, SizeBytes: 100}
+		require.NoError(t, klm.Put(key, location))
+		locations[key] = location
+	}
+
+	done, err := klm.MigrateRecords(10)
+	require.NoError(t, err)
+	require.False(t, done)
+	require.Equal(t, int64(16), klm.GetPersistentRecordsCount())
+	for key, location := range locations {
+		foundLocation, err := klm.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, location, foundLocation)
+	}
+
+	done, err = klm.MigrateRecords(10)
+	require.NoError(t, err)
+	require.True(t, done)
+	require.Equal(t, int64(64), klm.GetPersistentRecordsCount())
+
+	// After migration has completed, all entries should be
+	// accessible through a hash table of the new size.
+	newKLM := local.NewHashingKeyLocationMap(array, 64, 0x970aef1f90c7f916, 8, 32, "cas")
+	for key, location := range locations {
+		foundLocation, err := newKLM.Get(key)
+		require.NoError(t, err)
+		require.Equal(t, location, foundLocation)
+	}
+}
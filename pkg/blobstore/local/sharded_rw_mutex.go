@@ -0,0 +1,76 @@
+package local
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// shardedRWMutexShard is a single shard of ShardedRWMutex. Every
+// shard is padded by the size of a cache line, so that read locks
+// acquired on different shards don't cause false sharing.
+type shardedRWMutexShard struct {
+	sync.RWMutex
+	_ [64]byte
+}
+
+// ShardedRWMutex is a reader/writer lock that is optimized for
+// workloads where read locks are acquired far more frequently than
+// write locks, such as LocalBlobAccess receiving bursts of
+// FindMissingBlobs() calls.
+//
+// Even though sync.RWMutex permits readers to run concurrently, all
+// readers still need to update the same counter. On systems with many
+// CPU cores, the cache line containing this counter becomes heavily
+// contended. ShardedRWMutex consists of multiple sync.RWMutexes,
+// where readers only lock a single shard that is picked based on the
+// key of the object being accessed. Writers need to lock all shards.
+type ShardedRWMutex struct {
+	shards []shardedRWMutexShard
+}
+
+// NewShardedRWMutex creates a ShardedRWMutex that consists of a given
+// number of shards. Setting the number of shards to the number of CPU
+// cores in the system is generally a good choice.
+func NewShardedRWMutex(shardCount int) *ShardedRWMutex {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+	return &ShardedRWMutex{
+		shards: make([]shardedRWMutexShard, shardCount),
+	}
+}
+
+// Lock the ShardedRWMutex for writing. This causes all shards to be
+// locked in order.
+func (m *ShardedRWMutex) Lock() {
+	for i := range m.shards {
+		m.shards[i].Lock()
+	}
+}
+
+// Unlock the ShardedRWMutex for writing.
+func (m *ShardedRWMutex) Unlock() {
+	for i := len(m.shards) - 1; i >= 0; i-- {
+		m.shards[i].Unlock()
+	}
+}
+
+// RLocker returns a sync.Locker that can be used to lock the
+// ShardedRWMutex for reading. The shard that is used is picked based
+// on the key that is provided. As keys are SHA-256 sums, their leading
+// bytes are uniformly distributed.
+func (m *ShardedRWMutex) RLocker(key Key) sync.Locker {
+	return m.shards[binary.LittleEndian.Uint64(key[:])%uint64(len(m.shards))].RLocker()
+}
+
+// RLock locks the ShardedRWMutex for reading, using the first shard.
+// This function may be used by callers that don't access objects
+// associated with a specific key.
+func (m *ShardedRWMutex) RLock() {
+	m.shards[0].RLock()
+}
+
+// RUnlock undoes a single RLock() call.
+func (m *ShardedRWMutex) RUnlock() {
+	m.shards[0].RUnlock()
+}
@@ -0,0 +1,74 @@
+package local_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+)
+
+func TestShardedRWMutex(t *testing.T) {
+	m := local.NewShardedRWMutex(4)
+	key1 := local.Key{0}
+	key2 := local.Key{1}
+
+	t.Run("ConcurrentReaders", func(t *testing.T) {
+		// Readers on the same and on different shards should
+		// not block each other.
+		l1, l2, l3 := m.RLocker(key1), m.RLocker(key1), m.RLocker(key2)
+		l1.Lock()
+		l2.Lock()
+		l3.Lock()
+		m.RLock()
+		m.RUnlock()
+		l3.Unlock()
+		l2.Unlock()
+		l1.Unlock()
+	})
+
+	t.Run("WriterExcludesReaders", func(t *testing.T) {
+		// A writer should block until readers on any of the
+		// shards have released their locks.
+		readLock := m.RLocker(key2)
+		readLock.Lock()
+
+		locked := make(chan struct{})
+		go func() {
+			m.Lock()
+			close(locked)
+		}()
+
+		select {
+		case <-locked:
+			t.Fatal("Writer acquired lock while a reader was present")
+		case <-time.After(10 * time.Millisecond):
+		}
+		readLock.Unlock()
+		<-locked
+
+		// Readers should be blocked while the writer is
+		// present.
+		acquired := make(chan struct{})
+		go func() {
+			readLock.Lock()
+			close(acquired)
+		}()
+		select {
+		case <-acquired:
+			t.Fatal("Reader acquired lock while a writer was present")
+		case <-time.After(10 * time.Millisecond):
+		}
+		m.Unlock()
+		<-acquired
+		readLock.Unlock()
+	})
+
+	t.Run("SingleShard", func(t *testing.T) {
+		// Shard counts below one should be rounded up.
+		m := local.NewShardedRWMutex(0)
+		m.RLocker(key1).Lock()
+		m.RLocker(key2).Unlock()
+		m.Lock()
+		m.Unlock()
+	})
+}
@@ -0,0 +1,232 @@
+package local
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blockdevice"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/filesystem/path"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	componentKeyLocationMap = path.MustNewComponent("key_location_map")
+	componentManifest       = path.MustNewComponent("manifest")
+	componentManifestNew    = path.MustNewComponent("manifest.new")
+)
+
+// snapshotCopyBufferSizeBytes is the size of the buffer that is used to
+// copy the contents of snapshot files back to block devices.
+const snapshotCopyBufferSizeBytes = 1 << 20
+
+func getSnapshotBlockFileComponent(blockIndex int) path.Component {
+	return path.MustNewComponent(fmt.Sprintf("block.%d", blockIndex))
+}
+
+// writeSnapshotFile writes the contents of a reader into a file stored
+// in the snapshot directory, returning its size and checksum.
+func writeSnapshotFile(directory filesystem.Directory, name path.Component, r io.Reader) (*pb.SnapshotFile, error) {
+	if err := directory.Remove(name); err != nil && !os.IsNotExist(err) {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to remove previous file")
+	}
+	f, err := directory.OpenAppend(name, filesystem.CreateExcl(0o666))
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to create file")
+	}
+	hasher := sha256.New()
+	sizeBytes, err := io.Copy(io.MultiWriter(f, hasher), r)
+	if err != nil {
+		f.Close()
+		return nil, util.StatusWrap(err, "Failed to write to file")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to synchronize file")
+	}
+	if err := f.Close(); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to close file")
+	}
+	return &pb.SnapshotFile{
+		Name:      name.String(),
+		SizeBytes: sizeBytes,
+		Sha256:    hasher.Sum(nil),
+	}, nil
+}
+
+// WriteSnapshot writes a snapshot of a persistent LocalBlobAccess into
+// a directory. The persistent state and the contents of the blocks it
+// references are typically obtained by calling
+// PeriodicSyncer.CreateSnapshot(). The contents of the key-location map
+// are copied from its block device while writing the snapshot.
+//
+// The manifest of any snapshot previously stored in the directory is
+// removed before any other files are written, and the manifest of the
+// new snapshot is written last. This ensures that an interrupted
+// snapshot cannot be mistaken for a complete one.
+func WriteSnapshot(directory filesystem.Directory, persistentState *pb.PersistentState, blockContents []buffer.Buffer, keyLocationMapBlockDevice blockdevice.BlockDevice, keyLocationMapSizeBytes int64) error {
+	// Buffers hold references to blocks, so they must be discarded
+	// regardless of whether writing the snapshot succeeds.
+	discardRemaining := func(i int) {
+		for _, b := range blockContents[i:] {
+			b.Discard()
+		}
+	}
+	if err := directory.Remove(componentManifest); err != nil && !os.IsNotExist(err) {
+		discardRemaining(0)
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to remove previous manifest")
+	}
+
+	manifest := pb.SnapshotManifest{
+		PersistentState: persistentState,
+	}
+	for blockIndex, b := range blockContents {
+		r := b.ToReader()
+		blockFile, err := writeSnapshotFile(directory, getSnapshotBlockFileComponent(blockIndex), r)
+		r.Close()
+		if err != nil {
+			discardRemaining(blockIndex + 1)
+			return util.StatusWrapf(err, "Failed to write contents of block %d", blockIndex)
+		}
+		manifest.BlockFiles = append(manifest.BlockFiles, blockFile)
+	}
+
+	keyLocationMapFile, err := writeSnapshotFile(directory, componentKeyLocationMap, io.NewSectionReader(keyLocationMapBlockDevice, 0, keyLocationMapSizeBytes))
+	if err != nil {
+		return util.StatusWrap(err, "Failed to write contents of key-location map")
+	}
+	manifest.KeyLocationMapFile = keyLocationMapFile
+
+	data, err := proto.Marshal(&manifest)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal manifest")
+	}
+	if _, err := writeSnapshotFile(directory, componentManifestNew, bytes.NewReader(data)); err != nil {
+		return util.StatusWrap(err, "Failed to write manifest")
+	}
+	if err := directory.Rename(componentManifestNew, directory, componentManifest); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to rename manifest")
+	}
+	if err := directory.Sync(); err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to synchronize directory")
+	}
+	return nil
+}
+
+// restoreSnapshotFile copies the contents of a file stored in the
+// snapshot directory to a block device, validating its size and
+// checksum.
+func restoreSnapshotFile(directory filesystem.Directory, snapshotFile *pb.SnapshotFile, blockDevice blockdevice.BlockDevice, offsetBytes int64) error {
+	name, ok := path.NewComponent(snapshotFile.GetName())
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "Invalid filename %#v", snapshotFile.GetName())
+	}
+	f, err := directory.OpenRead(name)
+	if err != nil {
+		return util.StatusWrapfWithCode(err, codes.Internal, "Failed to open file %#v", name.String())
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	data := make([]byte, snapshotCopyBufferSizeBytes)
+	for position := int64(0); position < snapshotFile.SizeBytes; {
+		chunk := data
+		if remaining := snapshotFile.SizeBytes - position; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		if n, err := f.ReadAt(chunk, position); n != len(chunk) {
+			if err == io.EOF {
+				return status.Errorf(codes.DataLoss, "File %#v is %d bytes in size, while %d bytes were expected", name.String(), position+int64(n), snapshotFile.SizeBytes)
+			}
+			return util.StatusWrapfWithCode(err, codes.Internal, "Failed to read from file %#v", name.String())
+		}
+		hasher.Write(chunk)
+		if _, err := blockDevice.WriteAt(chunk, offsetBytes+position); err != nil {
+			return util.StatusWrapf(err, "Failed to write contents of file %#v", name.String())
+		}
+		position += int64(len(chunk))
+	}
+	if checksum := hasher.Sum(nil); !bytes.Equal(checksum, snapshotFile.Sha256) {
+		return status.Errorf(codes.DataLoss, "File %#v has SHA-256 hash %x, while %x was expected", name.String(), checksum, snapshotFile.Sha256)
+	}
+	return nil
+}
+
+// RestoreSnapshot restores a snapshot created by WriteSnapshot(). The
+// contents of blocks are written back to the locations on the block
+// device at which they were originally stored, and the key-location map
+// is written to the start of its block device. The persistent state is
+// written last, so that an interrupted restore does not cause
+// partially restored data to be used.
+//
+// The block devices must be at least as large as the ones from which
+// the snapshot was created. If no snapshot is present in the
+// directory, an error with code NOT_FOUND is returned.
+func RestoreSnapshot(directory filesystem.Directory, blockDevice, keyLocationMapBlockDevice blockdevice.BlockDevice, keyLocationMapSizeBytes int64, persistentStateStore PersistentStateStore) error {
+	f, err := directory.OpenRead(componentManifest)
+	if os.IsNotExist(err) {
+		return status.Error(codes.NotFound, "Snapshot manifest not found")
+	} else if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to open snapshot manifest")
+	}
+	data, err := ioutil.ReadAll(io.NewSectionReader(f, 0, math.MaxInt64))
+	f.Close()
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to read snapshot manifest")
+	}
+	var manifest pb.SnapshotManifest
+	if err := proto.Unmarshal(data, &manifest); err != nil {
+		return util.StatusWrapWithCode(err, codes.DataLoss, "Failed to unmarshal snapshot manifest")
+	}
+
+	persistentState := manifest.PersistentState
+	if persistentState == nil {
+		return status.Error(codes.DataLoss, "Snapshot manifest does not contain a persistent state")
+	}
+	if len(manifest.BlockFiles) != len(persistentState.Blocks) {
+		return status.Errorf(codes.DataLoss, "Snapshot manifest contains %d block files, while the persistent state contains %d blocks", len(manifest.BlockFiles), len(persistentState.Blocks))
+	}
+	for blockIndex, blockState := range persistentState.Blocks {
+		blockFile := manifest.BlockFiles[blockIndex]
+		blockLocation := blockState.BlockLocation
+		if blockLocation == nil || blockFile.SizeBytes != blockState.WriteOffsetBytes || blockFile.SizeBytes > blockLocation.SizeBytes {
+			return status.Errorf(codes.DataLoss, "Size of block file %d does not match the persistent state", blockIndex)
+		}
+		if err := restoreSnapshotFile(directory, blockFile, blockDevice, blockLocation.OffsetBytes); err != nil {
+			return util.StatusWrapf(err, "Failed to restore block %d", blockIndex)
+		}
+	}
+
+	keyLocationMapFile := manifest.KeyLocationMapFile
+	if keyLocationMapFile == nil {
+		return status.Error(codes.DataLoss, "Snapshot manifest does not contain a key-location map file")
+	}
+	if keyLocationMapFile.SizeBytes > keyLocationMapSizeBytes {
+		return status.Errorf(codes.InvalidArgument, "Key-location map in snapshot is %d bytes in size, which exceeds the size of the key-location map block device of %d bytes", keyLocationMapFile.SizeBytes, keyLocationMapSizeBytes)
+	}
+	if err := restoreSnapshotFile(directory, keyLocationMapFile, keyLocationMapBlockDevice, 0); err != nil {
+		return util.StatusWrap(err, "Failed to restore key-location map")
+	}
+
+	if err := blockDevice.Sync(); err != nil {
+		return util.StatusWrap(err, "Failed to synchronize blocks block device")
+	}
+	if err := keyLocationMapBlockDevice.Sync(); err != nil {
+		return util.StatusWrap(err, "Failed to synchronize key-location map block device")
+	}
+	if err := persistentStateStore.WritePersistentState(persistentState); err != nil {
+		return util.StatusWrap(err, "Failed to write persistent state")
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package local_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+	"github.com/buildbarn/bb-storage/pkg/blockdevice"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestBlockDevice(t *testing.T, name string) blockdevice.BlockDevice {
+	blockDevice, _, _, err := blockdevice.NewBlockDeviceFromFile(filepath.Join(t.TempDir(), name), 4096, true)
+	require.NoError(t, err)
+	return blockDevice
+}
+
+func TestSnapshot(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	snapshotDirectoryPath := t.TempDir()
+	snapshotDirectory, err := filesystem.NewLocalDirectory(snapshotDirectoryPath)
+	require.NoError(t, err)
+	defer snapshotDirectory.Close()
+
+	persistentState := &pb.PersistentState{
+		OldestEpochId: 7,
+		Blocks: []*pb.BlockState{
+			{
+				BlockLocation: &pb.BlockLocation{
+					OffsetBytes: 1000,
+					SizeBytes:   1000,
+				},
+				WriteOffsetBytes: 5,
+				EpochHashSeeds:   []uint64{1, 2},
+			},
+			{
+				BlockLocation: &pb.BlockLocation{
+					OffsetBytes: 0,
+					SizeBytes:   1000,
+				},
+				WriteOffsetBytes: 6,
+				EpochHashSeeds:   []uint64{3},
+			},
+		},
+		KeyLocationMapHashInitialization: 0xdf280dd45b2c39e,
+		KeyLocationMapRecordsCount:       1,
+	}
+
+	t.Run("RestoreNotFound", func(t *testing.T) {
+		// Restoring should fail with NOT_FOUND if the directory
+		// does not contain a snapshot, so that callers can
+		// start with an empty data store.
+		persistentStateStore := mock.NewMockPersistentStateStore(ctrl)
+
+		require.Equal(
+			t,
+			status.Error(codes.NotFound, "Snapshot manifest not found"),
+			local.RestoreSnapshot(snapshotDirectory, newTestBlockDevice(t, "blocks"), newTestBlockDevice(t, "key_location_map"), 4096, persistentStateStore))
+	})
+
+	// Create a snapshot of two blocks and a key-location map.
+	keyLocationMapBlockDevice := newTestBlockDevice(t, "key_location_map")
+	_, err = keyLocationMapBlockDevice.WriteAt([]byte("KeyLocationMap"), 0)
+	require.NoError(t, err)
+	require.NoError(t, local.WriteSnapshot(
+		snapshotDirectory,
+		persistentState,
+		[]buffer.Buffer{
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello")),
+			buffer.NewValidatedBufferFromByteSlice([]byte("World!")),
+		},
+		keyLocationMapBlockDevice,
+		local.BlockDeviceBackedLocationRecordSize))
+
+	t.Run("RestoreSuccess", func(t *testing.T) {
+		// Restoring the snapshot should write the contents of
+		// the blocks back to their original locations, followed
+		// by writing the persistent state.
+		blockDevice := newTestBlockDevice(t, "blocks")
+		keyLocationMapBlockDevice := newTestBlockDevice(t, "key_location_map")
+		persistentStateStore := mock.NewMockPersistentStateStore(ctrl)
+		persistentStateStore.EXPECT().WritePersistentState(testutil.EqProto(t, persistentState))
+
+		require.NoError(t, local.RestoreSnapshot(snapshotDirectory, blockDevice, keyLocationMapBlockDevice, 4096, persistentStateStore))
+
+		var block0 [5]byte
+		_, err := blockDevice.ReadAt(block0[:], 1000)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), block0[:])
+
+		var block1 [6]byte
+		_, err = blockDevice.ReadAt(block1[:], 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte("World!"), block1[:])
+
+		var keyLocationMap [local.BlockDeviceBackedLocationRecordSize]byte
+		_, err = keyLocationMapBlockDevice.ReadAt(keyLocationMap[:], 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte("KeyLocationMap"), keyLocationMap[:14])
+	})
+
+	t.Run("RestoreKeyLocationMapTooSmall", func(t *testing.T) {
+		persistentStateStore := mock.NewMockPersistentStateStore(ctrl)
+
+		require.Equal(
+			t,
+			status.Error(codes.InvalidArgument, "Key-location map in snapshot is 66 bytes in size, which exceeds the size of the key-location map block device of 10 bytes"),
+			local.RestoreSnapshot(snapshotDirectory, newTestBlockDevice(t, "blocks"), newTestBlockDevice(t, "key_location_map"), 10, persistentStateStore))
+	})
+
+	t.Run("RestoreCorrupted", func(t *testing.T) {
+		// Corruption of any of the files should cause restoring
+		// to fail. The persistent state should not be written.
+		require.NoError(t, ioutil.WriteFile(filepath.Join(snapshotDirectoryPath, "block.1"), []byte("World?"), 0o666))
+		persistentStateStore := mock.NewMockPersistentStateStore(ctrl)
+
+		err := local.RestoreSnapshot(snapshotDirectory, newTestBlockDevice(t, "blocks"), newTestBlockDevice(t, "key_location_map"), 4096, persistentStateStore)
+		require.Equal(t, codes.DataLoss, status.Code(err))
+	})
+}
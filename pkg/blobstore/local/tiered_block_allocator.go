@@ -0,0 +1,263 @@
+package local
+
+import (
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blockdevice"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/blobstore/local"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	tieredBlockAllocatorPrometheusMetrics sync.Once
+
+	tieredBlockAllocatorAllocations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "tiered_block_allocator_allocations_total",
+			Help:      "Number of times blocks managed by TieredBlockAllocator were allocated",
+		},
+		[]string{"tier"})
+	tieredBlockAllocatorDemotions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "tiered_block_allocator_demotions_total",
+			Help:      "Number of times blocks managed by TieredBlockAllocator were moved from the fast tier to the slow tier",
+		},
+		[]string{"result"})
+)
+
+// tieredBlockAllocatorCopyBufferSizeBytes is the maximum amount of
+// data that is copied at once when demoting a block.
+const tieredBlockAllocatorCopyBufferSizeBytes = 1 << 20
+
+type tieredBlockAllocator struct {
+	fast              *blockDeviceBackedBlockAllocator
+	slow              *blockDeviceBackedBlockAllocator
+	maximumFastBlocks int
+	errorLogger       util.ErrorLogger
+
+	lock       sync.Mutex
+	fastBlocks []*tieredBlock
+
+	allocationsFast    prometheus.Counter
+	allocationsSlow    prometheus.Counter
+	demotionsSucceeded prometheus.Counter
+	demotionsAborted   prometheus.Counter
+	demotionsFailed    prometheus.Counter
+}
+
+// NewTieredBlockAllocator creates a BlockAllocator that stores blocks
+// on two block devices with different performance characteristics
+// (e.g., an SSD and an HDD). New blocks are always allocated on the
+// fast block device. Once more than maximumFastBlocks blocks are
+// stored on the fast block device, the oldest blocks are copied to the
+// slow block device in the background, freeing up space on the fast
+// block device.
+//
+// Because BlockList hands out blocks in chronological order, this
+// causes the oldest blocks in the BlockList to reside on the slow
+// block device. When combined with OldCurrentNewLocationBlobMap, it is
+// recommended that the number of "old" blocks is at least as large as
+// the number of blocks stored on the slow block device. Blobs stored
+// in "old" blocks are copied into "new" blocks when accessed, meaning
+// that frequently used blobs are promoted to the fast block device.
+//
+// Blocks change location when demoted. This BlockAllocator can
+// therefore not be used in combination with persistent storage.
+func NewTieredBlockAllocator(fastBlockDevice blockdevice.BlockDevice, fastBlockCount int, slowBlockDevice blockdevice.BlockDevice, slowBlockCount int, readBufferFactory blobstore.ReadBufferFactory, sectorSizeBytes int, blockSectorCount int64, maximumFastBlocks int, errorLogger util.ErrorLogger) BlockAllocator {
+	tieredBlockAllocatorPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(tieredBlockAllocatorAllocations)
+		prometheus.MustRegister(tieredBlockAllocatorDemotions)
+	})
+
+	return &tieredBlockAllocator{
+		fast:              newBlockDeviceBackedBlockAllocator(fastBlockDevice, readBufferFactory, sectorSizeBytes, blockSectorCount, fastBlockCount),
+		slow:              newBlockDeviceBackedBlockAllocator(slowBlockDevice, readBufferFactory, sectorSizeBytes, blockSectorCount, slowBlockCount),
+		maximumFastBlocks: maximumFastBlocks,
+		errorLogger:       errorLogger,
+
+		allocationsFast:    tieredBlockAllocatorAllocations.WithLabelValues("Fast"),
+		allocationsSlow:    tieredBlockAllocatorAllocations.WithLabelValues("Slow"),
+		demotionsSucceeded: tieredBlockAllocatorDemotions.WithLabelValues("Succeeded"),
+		demotionsAborted:   tieredBlockAllocatorDemotions.WithLabelValues("Aborted"),
+		demotionsFailed:    tieredBlockAllocatorDemotions.WithLabelValues("Failed"),
+	}
+}
+
+func (ta *tieredBlockAllocator) NewBlock() (Block, *pb.BlockLocation, error) {
+	ta.lock.Lock()
+	defer ta.lock.Unlock()
+
+	// Prefer storing new blocks on the fast block device. If it is
+	// exhausted (e.g., because demotions are not able to keep up),
+	// fall back to the slow block device.
+	current, err := ta.fast.newBlock()
+	if err != nil {
+		current, err = ta.slow.newBlock()
+		if err != nil {
+			return nil, nil, err
+		}
+		ta.allocationsSlow.Inc()
+		return &tieredBlock{current: current}, nil, nil
+	}
+	ta.allocationsFast.Inc()
+	tb := &tieredBlock{current: current}
+
+	// Forget about blocks that have been released, and demote the
+	// oldest blocks if the fast block device contains too many.
+	fastBlocks := make([]*tieredBlock, 0, len(ta.fastBlocks)+1)
+	for _, fb := range ta.fastBlocks {
+		if !fb.isReleased() {
+			fastBlocks = append(fastBlocks, fb)
+		}
+	}
+	fastBlocks = append(fastBlocks, tb)
+	for len(fastBlocks) > ta.maximumFastBlocks {
+		go ta.demote(fastBlocks[0])
+		fastBlocks = fastBlocks[1:]
+	}
+	ta.fastBlocks = fastBlocks
+	return tb, nil, nil
+}
+
+func (ta *tieredBlockAllocator) NewBlockAtLocation(location *pb.BlockLocation) (Block, bool) {
+	// Blocks may be moved between block devices, meaning their
+	// locations are not stable.
+	return nil, false
+}
+
+// demote a block from the fast block device to the slow block device.
+// Data is copied without holding any locks. If the block is written to
+// while being copied, demotion is aborted, leaving the block on the
+// fast block device.
+func (ta *tieredBlockAllocator) demote(tb *tieredBlock) {
+	tb.lock.Lock()
+	if tb.released || tb.writesInProgress > 0 {
+		tb.lock.Unlock()
+		ta.demotionsAborted.Inc()
+		return
+	}
+	source := tb.current
+	source.usecount.Add(1)
+	writesCompleted := tb.writesCompleted
+	tb.lock.Unlock()
+
+	target, err := ta.slow.newBlock()
+	if err != nil {
+		source.Release()
+		ta.errorLogger.Log(util.StatusWrap(err, "Failed to allocate block on slow block device"))
+		ta.demotionsFailed.Inc()
+		return
+	}
+	err = ta.copyBlock(target, source)
+	source.Release()
+	if err != nil {
+		target.Release()
+		ta.errorLogger.Log(util.StatusWrap(err, "Failed to copy block to slow block device"))
+		ta.demotionsFailed.Inc()
+		return
+	}
+
+	tb.lock.Lock()
+	if tb.released || tb.writesInProgress > 0 || tb.writesCompleted != writesCompleted {
+		tb.lock.Unlock()
+		target.Release()
+		ta.demotionsAborted.Inc()
+		return
+	}
+	tb.current = target
+	// Drop the reference to the block on the fast block device that
+	// was previously held by the tiered block. Buffers returned by
+	// Get() hold their own references, meaning they remain valid.
+	source.Release()
+	tb.lock.Unlock()
+	ta.demotionsSucceeded.Inc()
+}
+
+// copyBlock copies the full contents of a block on the fast block
+// device to a block on the slow block device.
+func (ta *tieredBlockAllocator) copyBlock(target, source *blockDeviceBackedBlock) error {
+	sectorSizeBytes := int64(ta.fast.sectorSizeBytes)
+	chunkSizeBytes := int64(tieredBlockAllocatorCopyBufferSizeBytes) / sectorSizeBytes * sectorSizeBytes
+	if chunkSizeBytes == 0 {
+		chunkSizeBytes = sectorSizeBytes
+	}
+	if chunkSizeBytes > ta.fast.blockSizeBytes {
+		chunkSizeBytes = ta.fast.blockSizeBytes
+	}
+	chunk := make([]byte, chunkSizeBytes)
+
+	sourceOffsetBytes := source.offset * sectorSizeBytes
+	targetOffsetBytes := target.offset * sectorSizeBytes
+	for offsetBytes := int64(0); offsetBytes < ta.fast.blockSizeBytes; offsetBytes += int64(len(chunk)) {
+		if remaining := ta.fast.blockSizeBytes - offsetBytes; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		if n, err := ta.fast.blockDevice.ReadAt(chunk, sourceOffsetBytes+offsetBytes); n != len(chunk) {
+			return util.StatusWrapf(err, "Failed to read data at offset %d", sourceOffsetBytes+offsetBytes)
+		}
+		if _, err := ta.slow.blockDevice.WriteAt(chunk, targetOffsetBytes+offsetBytes); err != nil {
+			return util.StatusWrapf(err, "Failed to write data at offset %d", targetOffsetBytes+offsetBytes)
+		}
+	}
+	return nil
+}
+
+// tieredBlock is a Block that is managed by TieredBlockAllocator. It
+// forwards all calls to a block that is either stored on the fast or
+// the slow block device.
+type tieredBlock struct {
+	lock             sync.Mutex
+	current          *blockDeviceBackedBlock
+	writesInProgress int
+	writesCompleted  uint64
+	released         bool
+}
+
+func (tb *tieredBlock) isReleased() bool {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	return tb.released
+}
+
+func (tb *tieredBlock) Get(digest digest.Digest, offsetBytes, sizeBytes int64, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	return tb.current.Get(digest, offsetBytes, sizeBytes, dataIntegrityCallback)
+}
+
+func (tb *tieredBlock) GetRaw(offsetBytes, sizeBytes int64) buffer.Buffer {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	return tb.current.GetRaw(offsetBytes, sizeBytes)
+}
+
+func (tb *tieredBlock) Put(offsetBytes int64, b buffer.Buffer) error {
+	tb.lock.Lock()
+	current := tb.current
+	tb.writesInProgress++
+	tb.lock.Unlock()
+
+	err := current.Put(offsetBytes, b)
+
+	tb.lock.Lock()
+	tb.writesInProgress--
+	tb.writesCompleted++
+	tb.lock.Unlock()
+	return err
+}
+
+func (tb *tieredBlock) Release() {
+	tb.lock.Lock()
+	current := tb.current
+	tb.released = true
+	tb.lock.Unlock()
+	current.Release()
+}
@@ -0,0 +1,102 @@
+package local_test
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeBlockDevice creates a MockBlockDevice that is backed by a
+// byte slice, so that data can be copied between block devices.
+func newFakeBlockDevice(ctrl *gomock.Controller, data []byte) *mock.MockBlockDevice {
+	blockDevice := mock.NewMockBlockDevice(ctrl)
+	blockDevice.EXPECT().ReadAt(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(p []byte, off int64) (int, error) {
+			return copy(p, data[off:]), nil
+		}).AnyTimes()
+	blockDevice.EXPECT().WriteAt(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(p []byte, off int64) (int, error) {
+			return copy(data[off:], p), nil
+		}).AnyTimes()
+	blockDevice.EXPECT().Discard(gomock.Any(), gomock.Any()).AnyTimes()
+	return blockDevice
+}
+
+func TestTieredBlockAllocator(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	fastData := make([]byte, 30)
+	fastBlockDevice := newFakeBlockDevice(ctrl, fastData)
+	slowData := make([]byte, 20)
+	slowBlockDevice := mock.NewMockBlockDevice(ctrl)
+	errorLogger := mock.NewMockErrorLogger(ctrl)
+	ta := local.NewTieredBlockAllocator(fastBlockDevice, 3, slowBlockDevice, 2, blobstore.CASReadBufferFactory, 1, 10, 2, errorLogger)
+
+	helloDigest := digest.MustNewDigest("some-instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	// The first two blocks should be placed on the fast block
+	// device, without causing any demotions.
+	block0, location, err := ta.NewBlock()
+	require.NoError(t, err)
+	require.Nil(t, location)
+	require.NoError(t, block0.Put(0, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	require.Equal(t, []byte("Hello"), fastData[:5])
+
+	block1, _, err := ta.NewBlock()
+	require.NoError(t, err)
+
+	// Allocating a third block should cause the oldest block to be
+	// copied to the slow block device. Overwrite the original copy
+	// on the fast block device afterwards, so that we can detect
+	// when reads are redirected.
+	copied := make(chan struct{})
+	slowBlockDevice.EXPECT().WriteAt(gomock.Any(), int64(0)).DoAndReturn(
+		func(p []byte, off int64) (int, error) {
+			copy(slowData, p)
+			copy(fastData, "XXXXX")
+			close(copied)
+			return len(p), nil
+		})
+	slowBlockDevice.EXPECT().ReadAt(gomock.Any(), int64(0)).DoAndReturn(
+		func(p []byte, off int64) (int, error) {
+			return copy(p, slowData[off:]), nil
+		}).MinTimes(1)
+
+	block2, _, err := ta.NewBlock()
+	require.NoError(t, err)
+	<-copied
+
+	// Reads against the demoted block should eventually be served
+	// from the slow block device.
+	for {
+		data, err := block0.Get(helloDigest, 0, 5, func(dataIsValid bool) {}).ToByteSlice(10)
+		if err == nil {
+			require.Equal(t, []byte("Hello"), data)
+			break
+		}
+		runtime.Gosched()
+	}
+
+	// Demotion should have freed up space on the fast block device,
+	// meaning that the next block can be placed there once more.
+	// Releasing the second block prevents further demotions.
+	block1.Release()
+	block3, _, err := ta.NewBlock()
+	require.NoError(t, err)
+	require.NoError(t, block3.Put(0, buffer.NewValidatedBufferFromByteSlice([]byte("World"))))
+	require.Equal(t, []byte("World"), fastData[:5])
+
+	// Releasing the demoted block should discard its region on
+	// the slow block device.
+	slowBlockDevice.EXPECT().Discard(int64(0), int64(10))
+	block0.Release()
+	block2.Release()
+	block3.Release()
+}
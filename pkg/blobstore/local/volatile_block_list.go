@@ -120,6 +120,10 @@ func (bl *volatileBlockList) Get(index int, digest digest.Digest, offsetBytes, s
 	return bl.blocks[index].block.block.Get(digest, offsetBytes, sizeBytes, dataIntegrityCallback)
 }
 
+func (bl *volatileBlockList) GetRaw(index int, offsetBytes, sizeBytes int64) buffer.Buffer {
+	return bl.blocks[index].block.block.GetRaw(offsetBytes, sizeBytes)
+}
+
 func (bl *volatileBlockList) toSectors(sizeBytes int64) int64 {
 	// Determine the number of sectors needed to store the object.
 	//
@@ -149,3 +153,7 @@ func (bl *volatileBlockList) Put(index int, sizeBytes int64) BlockListPutWriter
 		}
 	}
 }
+
+func (bl *volatileBlockList) GetAllocatedBytes(index int) int64 {
+	return bl.blocks[index].allocationOffsetSectors * int64(bl.sectorSizeBytes)
+}
@@ -0,0 +1,232 @@
+package local
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blockdevice"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	writeBatchingLocationRecordArrayPrometheusMetrics sync.Once
+
+	writeBatchingLocationRecordArrayFlushedRecords = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "write_batching_location_record_array_flushed_records_total",
+			Help:      "Number of records written to the block device by flushing",
+		},
+		[]string{"name"})
+	writeBatchingLocationRecordArrayFlushedRegions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "write_batching_location_record_array_flushed_regions_total",
+			Help:      "Number of contiguous regions written to the block device by flushing",
+		},
+		[]string{"name"})
+	writeBatchingLocationRecordArrayUnbatchedRecords = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "write_batching_location_record_array_unbatched_records_total",
+			Help:      "Number of records written to the block device directly, because the maximum number of dirty records was reached",
+		},
+		[]string{"name"})
+)
+
+// writeBatchingLocationRecordArrayMaximumRegionSizeBytes is the
+// maximum size of a single write issued by Flush().
+const writeBatchingLocationRecordArrayMaximumRegionSizeBytes = 1 << 20
+
+type serializedLocationRecord = [BlockDeviceBackedLocationRecordSize]byte
+
+// WriteBatchingLocationRecordArray is a LocationRecordArray that
+// stores serialized LocationRecords on a block device, similar to the
+// one returned by NewBlockDeviceBackedLocationRecordArray(). Instead
+// of writing every record to the block device individually, records
+// are kept in memory until Flush() is called. Flush() sorts the
+// records by index, and writes them in sector aligned regions. This
+// reduces write amplification on the block device significantly, as
+// the same sector is written at most once per flush.
+//
+// Records that have not been flushed yet are lost when the process
+// terminates. This is equivalent to what happens when records are
+// written to the block device, but not synchronized to storage. Blobs
+// referenced by those records simply become unreachable.
+type WriteBatchingLocationRecordArray struct {
+	device              blockdevice.BlockDevice
+	resolver            BlockReferenceResolver
+	sectorSizeBytes     int64
+	maximumDirtyRecords int
+	lock                *ShardedRWMutex
+
+	// Records that have been written since the last call to
+	// Flush(), and records that are currently being written to
+	// the block device by Flush(). The latter is not modified
+	// until Flush() completes, meaning that it may be read without
+	// holding a write lock.
+	dirtyRecords    map[int]serializedLocationRecord
+	flushingRecords map[int]serializedLocationRecord
+	flushLock       sync.Mutex
+
+	flushedRecords   prometheus.Counter
+	flushedRegions   prometheus.Counter
+	unbatchedRecords prometheus.Counter
+}
+
+// NewWriteBatchingLocationRecordArray creates a LocationRecordArray
+// that batches writes of LocationRecords to a block device. The lock
+// must be the same lock that is held by callers of Get() and Put(),
+// as Flush() needs to acquire it to obtain the records to write.
+//
+// If the number of records that have not been flushed reaches
+// maximumDirtyRecords, further records are written to the block
+// device directly.
+func NewWriteBatchingLocationRecordArray(device blockdevice.BlockDevice, resolver BlockReferenceResolver, sectorSizeBytes, maximumDirtyRecords int, lock *ShardedRWMutex, name string) *WriteBatchingLocationRecordArray {
+	writeBatchingLocationRecordArrayPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(writeBatchingLocationRecordArrayFlushedRecords)
+		prometheus.MustRegister(writeBatchingLocationRecordArrayFlushedRegions)
+		prometheus.MustRegister(writeBatchingLocationRecordArrayUnbatchedRecords)
+	})
+
+	return &WriteBatchingLocationRecordArray{
+		device:              device,
+		resolver:            resolver,
+		sectorSizeBytes:     int64(sectorSizeBytes),
+		maximumDirtyRecords: maximumDirtyRecords,
+		lock:                lock,
+
+		dirtyRecords: map[int]serializedLocationRecord{},
+
+		flushedRecords:   writeBatchingLocationRecordArrayFlushedRecords.WithLabelValues(name),
+		flushedRegions:   writeBatchingLocationRecordArrayFlushedRegions.WithLabelValues(name),
+		unbatchedRecords: writeBatchingLocationRecordArrayUnbatchedRecords.WithLabelValues(name),
+	}
+}
+
+// Get a LocationRecord from the array. Records that have not been
+// flushed yet take precedence over the ones stored on the block
+// device.
+func (lra *WriteBatchingLocationRecordArray) Get(index int) (LocationRecord, error) {
+	record, ok := lra.dirtyRecords[index]
+	if !ok {
+		record, ok = lra.flushingRecords[index]
+		if !ok {
+			if _, err := lra.device.ReadAt(record[:], int64(index)*BlockDeviceBackedLocationRecordSize); err != nil {
+				return LocationRecord{}, err
+			}
+		}
+	}
+	return deserializeLocationRecord(&record, lra.resolver)
+}
+
+// Put a LocationRecord into the array. The record is serialized
+// immediately, as the BlockReference to which a block index
+// corresponds changes over time.
+func (lra *WriteBatchingLocationRecordArray) Put(index int, locationRecord LocationRecord) error {
+	record := serializeLocationRecord(locationRecord, lra.resolver)
+	if _, ok := lra.dirtyRecords[index]; ok || len(lra.dirtyRecords) < lra.maximumDirtyRecords {
+		lra.dirtyRecords[index] = record
+		return nil
+	}
+
+	// Too many records are waiting to be flushed. Write this
+	// record directly. This may not be done while a flush is in
+	// progress, as writing a region of the block device may
+	// overwrite this record with its previous contents.
+	if lra.flushingRecords != nil {
+		lra.dirtyRecords[index] = record
+		return nil
+	}
+	lra.unbatchedRecords.Inc()
+	_, err := lra.device.WriteAt(record[:], int64(index)*BlockDeviceBackedLocationRecordSize)
+	return err
+}
+
+// Flush all records that have been written since the last call to
+// Flush() to the block device. A write lock is only held while
+// obtaining the records to write, meaning that Get() and Put() may be
+// called while the records are being written.
+func (lra *WriteBatchingLocationRecordArray) Flush() error {
+	lra.flushLock.Lock()
+	defer lra.flushLock.Unlock()
+
+	lra.lock.Lock()
+	lra.flushingRecords = lra.dirtyRecords
+	lra.dirtyRecords = map[int]serializedLocationRecord{}
+	lra.lock.Unlock()
+
+	err := lra.writeRecords(lra.flushingRecords)
+
+	lra.lock.Lock()
+	if err != nil {
+		// Retain records that could not be written, unless
+		// they have been overwritten in the meantime.
+		for index, record := range lra.flushingRecords {
+			if _, ok := lra.dirtyRecords[index]; !ok {
+				lra.dirtyRecords[index] = record
+			}
+		}
+	}
+	lra.flushingRecords = nil
+	lra.lock.Unlock()
+	return err
+}
+
+// writeRecords writes a set of records to the block device. Records
+// are sorted by index, so that records stored in adjacent sectors can
+// be written using a single write. Sectors that are only partially
+// covered by records are read first, so that the remaining records in
+// those sectors are preserved.
+func (lra *WriteBatchingLocationRecordArray) writeRecords(records map[int]serializedLocationRecord) error {
+	indices := make([]int, 0, len(records))
+	for index := range records {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+
+	var region []byte
+	for len(indices) > 0 {
+		// Determine the sector aligned region of the block
+		// device that contains the first record, and extend it
+		// for as long as subsequent records are stored in the
+		// same or the next sector.
+		regionStart := int64(indices[0]) * BlockDeviceBackedLocationRecordSize / lra.sectorSizeBytes * lra.sectorSizeBytes
+		regionEnd := regionStart
+		count := 0
+		for ; count < len(indices); count++ {
+			recordStart := int64(indices[count]) * BlockDeviceBackedLocationRecordSize
+			if recordStart/lra.sectorSizeBytes*lra.sectorSizeBytes > regionEnd || recordStart-regionStart >= writeBatchingLocationRecordArrayMaximumRegionSizeBytes {
+				break
+			}
+			if recordEnd := (recordStart + BlockDeviceBackedLocationRecordSize + lra.sectorSizeBytes - 1) / lra.sectorSizeBytes * lra.sectorSizeBytes; regionEnd < recordEnd {
+				regionEnd = recordEnd
+			}
+		}
+
+		// Read the existing contents of the region, overlay
+		// the records and write it back.
+		if int64(cap(region)) < regionEnd-regionStart {
+			region = make([]byte, regionEnd-regionStart)
+		}
+		region = region[:regionEnd-regionStart]
+		if _, err := lra.device.ReadAt(region, regionStart); err != nil {
+			return util.StatusWrapf(err, "Failed to read region at offset %d", regionStart)
+		}
+		for _, index := range indices[:count] {
+			record := records[index]
+			copy(region[int64(index)*BlockDeviceBackedLocationRecordSize-regionStart:], record[:])
+		}
+		if _, err := lra.device.WriteAt(region, regionStart); err != nil {
+			return util.StatusWrapf(err, "Failed to write region at offset %d", regionStart)
+		}
+		lra.flushedRecords.Add(float64(count))
+		lra.flushedRegions.Inc()
+		indices = indices[count:]
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package local_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/local"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWriteBatchingLocationRecordArray(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	blockDevice := mock.NewMockBlockDevice(ctrl)
+	blockIndexResolver := mock.NewMockBlockReferenceResolver(ctrl)
+	lra := local.NewWriteBatchingLocationRecordArray(blockDevice, blockIndexResolver, 512, 2, local.NewShardedRWMutex(4), "cas")
+
+	blockIndexResolver.EXPECT().BlockIndexToBlockReference(12).Return(local.BlockReference{
+		EpochID:        851212842,
+		BlocksFromLast: 9271,
+	}, uint64(90384039284213)).AnyTimes()
+	blockIndexResolver.EXPECT().BlockReferenceToBlockIndex(local.BlockReference{
+		EpochID:        851212842,
+		BlocksFromLast: 9271,
+	}).Return(12, uint64(90384039284213), true).AnyTimes()
+
+	t.Run("GetBeforeFlush", func(t *testing.T) {
+		// Records should be returned without accessing the
+		// block device until they are flushed.
+		require.NoError(t, lra.Put(100, exampleBlockDeviceBackedLocationRecord))
+		require.NoError(t, lra.Put(101, exampleBlockDeviceBackedLocationRecord))
+
+		record, err := lra.Get(100)
+		require.NoError(t, err)
+		require.Equal(t, exampleBlockDeviceBackedLocationRecord, record)
+	})
+
+	t.Run("TooManyDirtyRecords", func(t *testing.T) {
+		// Once the maximum number of dirty records is reached,
+		// records should be written directly. Overwriting
+		// dirty records should still be permitted.
+		require.NoError(t, lra.Put(101, exampleBlockDeviceBackedLocationRecord))
+		blockDevice.EXPECT().WriteAt(exampleBlockDeviceBackedLocationRecordBytes, int64(13200)).
+			Return(len(exampleBlockDeviceBackedLocationRecordBytes), nil)
+
+		require.NoError(t, lra.Put(200, exampleBlockDeviceBackedLocationRecord))
+	})
+
+	t.Run("FlushFailure", func(t *testing.T) {
+		// Records that could not be flushed should be retained.
+		blockDevice.EXPECT().ReadAt(gomock.Len(1024), int64(6144)).
+			Return(0, status.Error(codes.Internal, "Disk failure"))
+
+		require.Equal(
+			t,
+			status.Error(codes.Internal, "Failed to read region at offset 6144: Disk failure"),
+			lra.Flush())
+
+		record, err := lra.Get(101)
+		require.NoError(t, err)
+		require.Equal(t, exampleBlockDeviceBackedLocationRecord, record)
+	})
+
+	t.Run("FlushSuccess", func(t *testing.T) {
+		// Records 100 and 101 are stored in sectors 12 and 13.
+		// They should be written using a single write, while
+		// preserving the existing contents of these sectors.
+		blockDevice.EXPECT().ReadAt(gomock.Len(1024), int64(6144)).
+			DoAndReturn(func(p []byte, off int64) (int, error) {
+				for i := range p {
+					p[i] = 0xff
+				}
+				return len(p), nil
+			})
+		blockDevice.EXPECT().WriteAt(gomock.Len(1024), int64(6144)).
+			DoAndReturn(func(p []byte, off int64) (int, error) {
+				require.Equal(t, []byte{0xff, 0xff}, p[454:456])
+				require.Equal(t, exampleBlockDeviceBackedLocationRecordBytes, p[456:522])
+				require.Equal(t, exampleBlockDeviceBackedLocationRecordBytes, p[522:588])
+				require.Equal(t, []byte{0xff, 0xff}, p[588:590])
+				return len(p), nil
+			})
+
+		require.NoError(t, lra.Flush())
+
+		// Subsequent reads should be served from the block
+		// device.
+		blockDevice.EXPECT().ReadAt(gomock.Len(len(exampleBlockDeviceBackedLocationRecordBytes)), int64(6600)).
+			DoAndReturn(func(p []byte, off int64) (int, error) {
+				return copy(p, exampleBlockDeviceBackedLocationRecordBytes), nil
+			})
+
+		record, err := lra.Get(100)
+		require.NoError(t, err)
+		require.Equal(t, exampleBlockDeviceBackedLocationRecord, record)
+	})
+}
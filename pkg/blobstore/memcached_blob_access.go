@@ -0,0 +1,141 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/logging"
+	"github.com/buildbarn/bb-storage/pkg/memcached"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type memcachedBlobAccess struct {
+	client                memcached.Client
+	readBufferFactory     ReadBufferFactory
+	digestKeyFormat       digest.KeyFormat
+	maximumValueSizeBytes int
+	expiration            uint32
+}
+
+// NewMemcachedBlobAccess creates a BlobAccess that uses memcached as
+// its backing store. As memcached limits the size of values that may be
+// stored, attempts to store blobs larger than maximumValueSizeBytes are
+// rejected. This backend is therefore best used as the 'small' backend
+// of SizeDistinguishingBlobAccess.
+func NewMemcachedBlobAccess(client memcached.Client, readBufferFactory ReadBufferFactory, digestKeyFormat digest.KeyFormat, maximumValueSizeBytes int, expiration uint32) BlobAccess {
+	return &memcachedBlobAccess{
+		client:                client,
+		readBufferFactory:     readBufferFactory,
+		digestKeyFormat:       digestKeyFormat,
+		maximumValueSizeBytes: maximumValueSizeBytes,
+		expiration:            expiration,
+	}
+}
+
+// getKey returns the memcached key of a blob. Keys that exceed the
+// maximum key length of memcached (e.g., due to long instance names)
+// are hashed.
+func (ba *memcachedBlobAccess) getKey(blobDigest digest.Digest) string {
+	key := blobDigest.GetKey(ba.digestKeyFormat)
+	if len(key) > memcached.MaximumKeyLength {
+		h := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(h[:])
+	}
+	return key
+}
+
+func (ba *memcachedBlobAccess) Get(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	if err := util.StatusFromContext(ctx); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	key := ba.getKey(blobDigest)
+	value, err := ba.client.Get(ctx, key)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.NotFound, "Blob not found"))
+		}
+		return buffer.NewBufferFromError(util.StatusWrap(err, "Failed to get blob"))
+	}
+	return ba.readBufferFactory.NewBufferFromByteSlice(
+		blobDigest,
+		value,
+		func(dataIsValid bool) {
+			if !dataIsValid {
+				if err := ba.client.Delete(ctx, key); err == nil {
+					logging.Printf(ctx, "Blob %#v was malformed and has been deleted from memcached successfully", blobDigest.String())
+				} else {
+					logging.Printf(ctx, "Blob %#v was malformed and could not be deleted from memcached: %s", blobDigest.String(), err)
+				}
+			}
+		})
+}
+
+func (ba *memcachedBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	if err := util.StatusFromContext(ctx); err != nil {
+		b.Discard()
+		return err
+	}
+	sizeBytes, err := b.GetSizeBytes()
+	if err != nil {
+		b.Discard()
+		return err
+	}
+	if sizeBytes > int64(ba.maximumValueSizeBytes) {
+		b.Discard()
+		return status.Errorf(codes.InvalidArgument, "Blob is %d bytes in size, while this backend is only capable of storing blobs of up to %d bytes in size", sizeBytes, ba.maximumValueSizeBytes)
+	}
+	value, err := b.ToByteSlice(ba.maximumValueSizeBytes)
+	if err != nil {
+		return err
+	}
+	if err := ba.client.Set(ctx, ba.getKey(blobDigest), value, ba.expiration); err != nil {
+		return util.StatusWrap(err, "Failed to put blob")
+	}
+	return nil
+}
+
+func (ba *memcachedBlobAccess) Delete(ctx context.Context, blobDigest digest.Digest) error {
+	if err := util.StatusFromContext(ctx); err != nil {
+		return err
+	}
+	if err := ba.client.Delete(ctx, ba.getKey(blobDigest)); err != nil {
+		return util.StatusWrap(err, "Failed to delete blob")
+	}
+	return nil
+}
+
+func (ba *memcachedBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	if err := util.StatusFromContext(ctx); err != nil {
+		return digest.EmptySet, err
+	}
+	if digests.Empty() {
+		return digest.EmptySet, nil
+	}
+
+	keys := make([]string, 0, digests.Length())
+	for _, blobDigest := range digests.Items() {
+		keys = append(keys, ba.getKey(blobDigest))
+	}
+	missingKeys, err := ba.client.FindMissing(ctx, keys)
+	if err != nil {
+		return digest.EmptySet, util.StatusWrap(err, "Failed to find missing blobs")
+	}
+
+	missingKeysSet := make(map[string]struct{}, len(missingKeys))
+	for _, key := range missingKeys {
+		missingKeysSet[key] = struct{}{}
+	}
+	missing := digest.NewSetBuilder()
+	for i, blobDigest := range digests.Items() {
+		if _, ok := missingKeysSet[keys[i]]; ok {
+			missing.Add(blobDigest)
+		}
+	}
+	return missing.Build(), nil
+}
@@ -0,0 +1,115 @@
+package blobstore_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMemcachedBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	client := mock.NewMockClient(ctrl)
+	blobAccess := blobstore.NewMemcachedBlobAccess(client, blobstore.CASReadBufferFactory, digest.KeyWithInstance, 20, 3600)
+	blobDigest := digest.MustNewDigest("foo", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		client.EXPECT().Get(ctx, "3e25960a79dbc69b674cd4ec67a72c62-11-foo").
+			Return(nil, status.Error(codes.NotFound, "Key not found"))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.NotFound, "Blob not found: Key not found"), err)
+	})
+
+	t.Run("GetCorrupted", func(t *testing.T) {
+		// Corrupted entries should be removed from memcached.
+		client.EXPECT().Get(ctx, "3e25960a79dbc69b674cd4ec67a72c62-11-foo").
+			Return([]byte("Hello World"), nil)
+		client.EXPECT().Delete(ctx, "3e25960a79dbc69b674cd4ec67a72c62-11-foo")
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, codes.Internal, status.Code(err))
+	})
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		client.EXPECT().Get(ctx, "3e25960a79dbc69b674cd4ec67a72c62-11-foo").
+			Return([]byte("Hello world"), nil)
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("GetLongKey", func(t *testing.T) {
+		// Keys exceeding memcached's maximum key length should
+		// be hashed.
+		longDigest := digest.MustNewDigest(
+			strings.Repeat("0123456789", 25),
+			"3e25960a79dbc69b674cd4ec67a72c62",
+			11)
+		client.EXPECT().Get(ctx, gomock.Len(64)).Return([]byte("Hello world"), nil)
+
+		data, err := blobAccess.Get(ctx, longDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("PutTooLarge", func(t *testing.T) {
+		tooLargeDigest := digest.MustNewDigest("foo", "7c0cba5fc3e9a8bb4ea1a4a8ba8d8d4d", 24)
+		err := blobAccess.Put(ctx, tooLargeDigest, buffer.NewValidatedBufferFromByteSlice([]byte("This is a very long blob")))
+		require.Equal(t, status.Error(codes.InvalidArgument, "Blob is 24 bytes in size, while this backend is only capable of storing blobs of up to 20 bytes in size"), err)
+	})
+
+	t.Run("PutFailure", func(t *testing.T) {
+		client.EXPECT().Set(ctx, "3e25960a79dbc69b674cd4ec67a72c62-11-foo", []byte("Hello world"), uint32(3600)).
+			Return(status.Error(codes.Unavailable, "Server offline"))
+
+		err := blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+		require.Equal(t, status.Error(codes.Unavailable, "Failed to put blob: Server offline"), err)
+	})
+
+	t.Run("PutSuccess", func(t *testing.T) {
+		client.EXPECT().Set(ctx, "3e25960a79dbc69b674cd4ec67a72c62-11-foo", []byte("Hello world"), uint32(3600))
+
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		otherDigest := digest.MustNewDigest("foo", "8b1a9953c4611296a827abf8c47804d7", 5)
+		client.EXPECT().FindMissing(ctx, gomock.Any()).DoAndReturn(func(ctx context.Context, keys []string) ([]string, error) {
+			require.ElementsMatch(t, []string{
+				"3e25960a79dbc69b674cd4ec67a72c62-11-foo",
+				"8b1a9953c4611296a827abf8c47804d7-5-foo",
+			}, keys)
+			return []string{"8b1a9953c4611296a827abf8c47804d7-5-foo"}, nil
+		})
+
+		missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(blobDigest).Add(otherDigest).Build())
+		require.NoError(t, err)
+		require.Equal(t, otherDigest.ToSingletonSet(), missing)
+	})
+
+	t.Run("DeleteSuccess", func(t *testing.T) {
+		client.EXPECT().Delete(ctx, "3e25960a79dbc69b674cd4ec67a72c62-11-foo")
+
+		require.NoError(t, blobAccess.(blobstore.BlobDeleter).Delete(ctx, blobDigest))
+	})
+
+	t.Run("DeleteFailure", func(t *testing.T) {
+		client.EXPECT().Delete(ctx, "3e25960a79dbc69b674cd4ec67a72c62-11-foo").
+			Return(status.Error(codes.Unavailable, "Server offline"))
+
+		err := blobAccess.(blobstore.BlobDeleter).Delete(ctx, blobDigest)
+		require.Equal(t, status.Error(codes.Unavailable, "Failed to delete blob: Server offline"), err)
+	})
+}
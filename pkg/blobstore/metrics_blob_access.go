@@ -26,7 +26,7 @@ var (
 			Help:      "Size of blobs being inserted/retrieved, in bytes.",
 			Buckets:   prometheus.ExponentialBuckets(1.0, 2.0, 33),
 		},
-		[]string{"name", "operation"})
+		[]string{"name", "operation", "instance_name_prefix"})
 	blobAccessOperationsFindMissingBatchSize = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "buildbarn",
@@ -35,7 +35,7 @@ var (
 			Help:      "Number of digests provided to FindMissing().",
 			Buckets:   prometheus.ExponentialBuckets(1.0, 2.0, 17),
 		},
-		[]string{"name"})
+		[]string{"name", "instance_name_prefix"})
 	blobAccessOperationsDurationSeconds = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: "buildbarn",
@@ -44,57 +44,86 @@ var (
 			Help:      "Amount of time spent per operation on blob access objects, in seconds.",
 			Buckets:   util.DecimalExponentialBuckets(-3, 6, 2),
 		},
-		[]string{"name", "operation", "grpc_code"})
+		[]string{"name", "operation", "instance_name_prefix", "grpc_code"})
 )
 
 type metricsBlobAccess struct {
 	blobAccess BlobAccess
 	clock      clock.Clock
 
-	getBlobSizeBytes           prometheus.Observer
+	instanceNamePrefixesTrie *digest.InstanceNameTrie
+	instanceNamePrefixes     []string
+
+	getBlobSizeBytes           prometheus.ObserverVec
 	getDurationSeconds         prometheus.ObserverVec
-	putBlobSizeBytes           prometheus.Observer
+	putBlobSizeBytes           prometheus.ObserverVec
 	putDurationSeconds         prometheus.ObserverVec
-	findMissingBatchSize       prometheus.Observer
+	findMissingBatchSize       prometheus.ObserverVec
 	findMissingDurationSeconds prometheus.ObserverVec
 }
 
 // NewMetricsBlobAccess creates an adapter for BlobAccess that adds
 // basic instrumentation in the form of Prometheus metrics.
-func NewMetricsBlobAccess(blobAccess BlobAccess, clock clock.Clock, name string) BlobAccess {
+//
+// Metrics are labeled by the longest prefix in instanceNamePrefixes
+// that matches the instance name of the blobs being accessed. This
+// allows operators of multi-tenant setups to attribute load and
+// latency to individual tenants, while keeping the cardinality of
+// the metrics bounded. If none of the prefixes match, the label is
+// left empty.
+func NewMetricsBlobAccess(blobAccess BlobAccess, clock clock.Clock, name string, instanceNamePrefixes []digest.InstanceName) BlobAccess {
 	blobAccessOperationsPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(blobAccessOperationsBlobSizeBytes)
 		prometheus.MustRegister(blobAccessOperationsFindMissingBatchSize)
 		prometheus.MustRegister(blobAccessOperationsDurationSeconds)
 	})
 
-	return &metricsBlobAccess{
+	ba := &metricsBlobAccess{
 		blobAccess: blobAccess,
 		clock:      clock,
 
-		getBlobSizeBytes:           blobAccessOperationsBlobSizeBytes.WithLabelValues(name, "Get"),
+		instanceNamePrefixesTrie: digest.NewInstanceNameTrie(),
+		instanceNamePrefixes:     make([]string, 0, len(instanceNamePrefixes)),
+
+		getBlobSizeBytes:           blobAccessOperationsBlobSizeBytes.MustCurryWith(map[string]string{"name": name, "operation": "Get"}),
 		getDurationSeconds:         blobAccessOperationsDurationSeconds.MustCurryWith(map[string]string{"name": name, "operation": "Get"}),
-		putBlobSizeBytes:           blobAccessOperationsBlobSizeBytes.WithLabelValues(name, "Put"),
+		putBlobSizeBytes:           blobAccessOperationsBlobSizeBytes.MustCurryWith(map[string]string{"name": name, "operation": "Put"}),
 		putDurationSeconds:         blobAccessOperationsDurationSeconds.MustCurryWith(map[string]string{"name": name, "operation": "Put"}),
-		findMissingBatchSize:       blobAccessOperationsFindMissingBatchSize.WithLabelValues(name),
+		findMissingBatchSize:       blobAccessOperationsFindMissingBatchSize.MustCurryWith(map[string]string{"name": name}),
 		findMissingDurationSeconds: blobAccessOperationsDurationSeconds.MustCurryWith(map[string]string{"name": name, "operation": "FindMissing"}),
 	}
+	for i, instanceNamePrefix := range instanceNamePrefixes {
+		ba.instanceNamePrefixesTrie.Set(instanceNamePrefix, i)
+		ba.instanceNamePrefixes = append(ba.instanceNamePrefixes, instanceNamePrefix.String())
+	}
+	return ba
+}
+
+// getInstanceNamePrefix returns the value of the
+// "instance_name_prefix" label for a given instance name.
+func (ba *metricsBlobAccess) getInstanceNamePrefix(instanceName digest.InstanceName) string {
+	if i := ba.instanceNamePrefixesTrie.Get(instanceName); i >= 0 {
+		return ba.instanceNamePrefixes[i]
+	}
+	return ""
 }
 
-func (ba *metricsBlobAccess) updateDurationSeconds(vec prometheus.ObserverVec, code codes.Code, timeStart time.Time) {
-	vec.WithLabelValues(code.String()).Observe(ba.clock.Now().Sub(timeStart).Seconds())
+func (ba *metricsBlobAccess) updateDurationSeconds(vec prometheus.ObserverVec, instanceNamePrefix string, code codes.Code, timeStart time.Time) {
+	vec.WithLabelValues(instanceNamePrefix, code.String()).Observe(ba.clock.Now().Sub(timeStart).Seconds())
 }
 
 func (ba *metricsBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	instanceNamePrefix := ba.getInstanceNamePrefix(digest.GetInstanceName())
 	b := buffer.WithErrorHandler(
 		ba.blobAccess.Get(ctx, digest),
 		&metricsErrorHandler{
-			blobAccess: ba,
-			timeStart:  ba.clock.Now(),
-			errorCode:  codes.OK,
+			blobAccess:         ba,
+			instanceNamePrefix: instanceNamePrefix,
+			timeStart:          ba.clock.Now(),
+			errorCode:          codes.OK,
 		})
 	if sizeBytes, err := b.GetSizeBytes(); err == nil {
-		ba.getBlobSizeBytes.Observe(float64(sizeBytes))
+		ba.getBlobSizeBytes.WithLabelValues(instanceNamePrefix).Observe(float64(sizeBytes))
 	}
 	return b
 }
@@ -107,11 +136,12 @@ func (ba *metricsBlobAccess) Put(ctx context.Context, digest digest.Digest, b bu
 	if err != nil {
 		return err
 	}
-	ba.putBlobSizeBytes.Observe(float64(sizeBytes))
+	instanceNamePrefix := ba.getInstanceNamePrefix(digest.GetInstanceName())
+	ba.putBlobSizeBytes.WithLabelValues(instanceNamePrefix).Observe(float64(sizeBytes))
 
 	timeStart := ba.clock.Now()
 	err = ba.blobAccess.Put(ctx, digest, b)
-	ba.updateDurationSeconds(ba.putDurationSeconds, status.Code(err), timeStart)
+	ba.updateDurationSeconds(ba.putDurationSeconds, instanceNamePrefix, status.Code(err), timeStart)
 	return err
 }
 
@@ -123,17 +153,24 @@ func (ba *metricsBlobAccess) FindMissing(ctx context.Context, digests digest.Set
 		return digest.EmptySet, nil
 	}
 
-	ba.findMissingBatchSize.Observe(float64(digests.Length()))
+	// Requests for digests that use different instance names are
+	// uncommon, as the REv2 FindMissingBlobs() call only accepts a
+	// single instance name. Label the request based on the first
+	// digest.
+	firstDigest, _ := digests.First()
+	instanceNamePrefix := ba.getInstanceNamePrefix(firstDigest.GetInstanceName())
+	ba.findMissingBatchSize.WithLabelValues(instanceNamePrefix).Observe(float64(digests.Length()))
 	timeStart := ba.clock.Now()
 	digests, err := ba.blobAccess.FindMissing(ctx, digests)
-	ba.updateDurationSeconds(ba.findMissingDurationSeconds, status.Code(err), timeStart)
+	ba.updateDurationSeconds(ba.findMissingDurationSeconds, instanceNamePrefix, status.Code(err), timeStart)
 	return digests, err
 }
 
 type metricsErrorHandler struct {
-	blobAccess *metricsBlobAccess
-	timeStart  time.Time
-	errorCode  codes.Code
+	blobAccess         *metricsBlobAccess
+	instanceNamePrefix string
+	timeStart          time.Time
+	errorCode          codes.Code
 }
 
 func (eh *metricsErrorHandler) OnError(err error) (buffer.Buffer, error) {
@@ -142,5 +179,5 @@ func (eh *metricsErrorHandler) OnError(err error) (buffer.Buffer, error) {
 }
 
 func (eh *metricsErrorHandler) Done() {
-	eh.blobAccess.updateDurationSeconds(eh.blobAccess.getDurationSeconds, eh.errorCode, eh.timeStart)
+	eh.blobAccess.updateDurationSeconds(eh.blobAccess.getDurationSeconds, eh.instanceNamePrefix, eh.errorCode, eh.timeStart)
 }
@@ -0,0 +1,78 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+// getPutDurationSampleCounts returns the number of Put() operations
+// recorded for a given backend name, grouped by instance name prefix.
+func getPutDurationSampleCounts(t *testing.T, name string) map[string]uint64 {
+	metricFamilies, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	counts := map[string]uint64{}
+	for _, metricFamily := range metricFamilies {
+		if metricFamily.GetName() != "buildbarn_blobstore_blob_access_operations_duration_seconds" {
+			continue
+		}
+		for _, metric := range metricFamily.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["name"] == name && labels["operation"] == "Put" {
+				counts[labels["instance_name_prefix"]] += metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return counts
+}
+
+func TestMetricsBlobAccessInstanceNamePrefixes(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Unix(1000, 0)).AnyTimes()
+	blobAccess := blobstore.NewMetricsBlobAccess(
+		baseBlobAccess,
+		clock,
+		"metrics_blob_access_test",
+		[]digest.InstanceName{
+			digest.MustNewInstanceName("tenant1"),
+			digest.MustNewInstanceName("tenant2"),
+			digest.MustNewInstanceName("tenant2/ci"),
+		})
+
+	baseBlobAccess.EXPECT().Put(ctx, gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+			b.Discard()
+			return nil
+		}).
+		Times(5)
+	for _, instanceName := range []string{"tenant1", "tenant1/a", "tenant2/b", "tenant2/ci/c", "tenant3"} {
+		require.NoError(t, blobAccess.Put(
+			ctx,
+			digest.MustNewDigest(instanceName, "8b1a9953c4611296a827abf8c47804d7", 5),
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	}
+
+	// Operations should be labeled by the longest matching prefix.
+	// Operations for instance names that don't match any of the
+	// prefixes should have an empty label.
+	require.Equal(t, map[string]uint64{
+		"":           1,
+		"tenant1":    2,
+		"tenant2":    1,
+		"tenant2/ci": 1,
+	}, getPutDurationSampleCounts(t, "metrics_blob_access_test"))
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"sync"
 
-	"github.com/buildbarn/bb-storage/pkg/atomic"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
@@ -37,7 +36,7 @@ type mirroredBlobAccess struct {
 	backendB       blobstore.BlobAccess
 	replicatorAToB replication.BlobReplicator
 	replicatorBToA replication.BlobReplicator
-	round          atomic.Uint32
+	readPreference ReadPreference
 }
 
 // NewMirroredBlobAccess creates a BlobAccess that applies operations to
@@ -45,7 +44,11 @@ type mirroredBlobAccess struct {
 // inconsistencies between the two storage backends are detected (i.e.,
 // a blob is only present in one of the backends), the blob is
 // replicated.
-func NewMirroredBlobAccess(backendA, backendB blobstore.BlobAccess, replicatorAToB, replicatorBToA replication.BlobReplicator) blobstore.BlobAccess {
+//
+// The read preference determines which of the backends is consulted
+// first when reading objects. Backend A has index 0, while backend B
+// has index 1.
+func NewMirroredBlobAccess(backendA, backendB blobstore.BlobAccess, replicatorAToB, replicatorBToA replication.BlobReplicator, readPreference ReadPreference) blobstore.BlobAccess {
 	mirroredBlobAccessPrometheusMetrics.Do(func() {
 		prometheus.MustRegister(mirroredBlobAccessFindMissingSynchronizations)
 	})
@@ -55,15 +58,15 @@ func NewMirroredBlobAccess(backendA, backendB blobstore.BlobAccess, replicatorAT
 		backendB:       backendB,
 		replicatorAToB: replicatorAToB,
 		replicatorBToA: replicatorBToA,
+		readPreference: readPreference,
 	}
 }
 
 func (ba *mirroredBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
-	// Alternate requests between storage backends.
 	var firstBackend blobstore.BlobAccess
 	var firstBackendName, secondBackendName string
 	var replicator replication.BlobReplicator
-	if ba.round.Add(1)%2 == 1 {
+	if ba.readPreference.GetFirstBackend() == 0 {
 		firstBackend = ba.backendA
 		firstBackendName, secondBackendName = "Backend A", "Backend B"
 		replicator = ba.replicatorBToA
@@ -85,7 +88,10 @@ func (ba *mirroredBlobAccess) Get(ctx context.Context, digest digest.Digest) buf
 }
 
 func (ba *mirroredBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
-	// Store object in both storage backends.
+	// Store object in both storage backends. The buffer is cloned
+	// in such a way that the data is only read and validated once,
+	// regardless of the number of backends. If both backends
+	// request the data as a byte slice, they share the same copy.
 	b1, b2 := b.CloneStream()
 	errAChan := make(chan error, 1)
 	go func() {
@@ -115,9 +121,14 @@ func (ba *mirroredBlobAccess) FindMissing(ctx context.Context, digests digest.Se
 	// Call FindMissing() on both backends.
 	resultsAChan := make(chan findMissingResults, 1)
 	go func() {
-		resultsAChan <- callFindMissing(ctx, ba.backendA, digests)
+		done := ba.readPreference.ObserveOperation(0)
+		resultsA := callFindMissing(ctx, ba.backendA, digests)
+		done()
+		resultsAChan <- resultsA
 	}()
+	done := ba.readPreference.ObserveOperation(1)
 	resultsB := callFindMissing(ctx, ba.backendB, digests)
+	done()
 	resultsA := <-resultsAChan
 	if resultsA.err != nil {
 		return digest.EmptySet, util.StatusWrap(resultsA.err, "Backend A")
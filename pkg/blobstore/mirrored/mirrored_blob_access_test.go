@@ -34,7 +34,7 @@ func TestMirroredBlobAccessGet(t *testing.T) {
 			backendA.EXPECT().Get(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))),
 		)
 
-		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, mirrored.NewRoundRobinReadPreference([]int{0, 1}))
 		for i := 0; i < 3; i++ {
 			data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
 			require.NoError(t, err)
@@ -49,7 +49,7 @@ func TestMirroredBlobAccessGet(t *testing.T) {
 		backendA.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
 		replicatorBToA.EXPECT().ReplicateSingle(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
 
-		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, mirrored.NewRoundRobinReadPreference([]int{0, 1}))
 		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
 		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "Blob not found"), err)
 	})
@@ -60,7 +60,7 @@ func TestMirroredBlobAccessGet(t *testing.T) {
 		backendA.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
 		replicatorBToA.EXPECT().ReplicateSingle(ctx, blobDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
 
-		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, mirrored.NewRoundRobinReadPreference([]int{0, 1}))
 		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
 		require.NoError(t, err)
 		require.Equal(t, []byte("Hello world"), data)
@@ -71,7 +71,7 @@ func TestMirroredBlobAccessGet(t *testing.T) {
 
 		// In case of fatal errors, the name of the backend
 		// should be prepended.
-		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, mirrored.NewRoundRobinReadPreference([]int{0, 1}))
 		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
 		testutil.RequireEqualStatus(t, status.Error(codes.Internal, "Backend A: Server on fire"), err)
 	})
@@ -80,7 +80,7 @@ func TestMirroredBlobAccessGet(t *testing.T) {
 		backendA.EXPECT().Get(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
 		replicatorBToA.EXPECT().ReplicateSingle(ctx, blobDigest).Return(buffer.NewBufferFromError(status.Error(codes.Internal, "Server on fire")))
 
-		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+		blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, mirrored.NewRoundRobinReadPreference([]int{0, 1}))
 		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
 		testutil.RequireEqualStatus(t, status.Error(codes.Internal, "Backend B: Server on fire"), err)
 	})
@@ -94,7 +94,7 @@ func TestMirroredBlobAccessPut(t *testing.T) {
 	replicatorAToB := mock.NewMockBlobReplicator(ctrl)
 	replicatorBToA := mock.NewMockBlobReplicator(ctrl)
 	blobDigest := digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
-	blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+	blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, mirrored.NewRoundRobinReadPreference([]int{0, 1}))
 
 	t.Run("Success", func(t *testing.T) {
 		backendA.EXPECT().Put(gomock.Any(), blobDigest, gomock.Any()).DoAndReturn(
@@ -168,7 +168,7 @@ func TestMirroredBlobAccessFindMissing(t *testing.T) {
 	onlyOnB := digestB.ToSingletonSet()
 	missingFromA := digest.NewSetBuilder().Add(digestNone).Add(digestB).Build()
 	missingFromB := digest.NewSetBuilder().Add(digestNone).Add(digestA).Build()
-	blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA)
+	blobAccess := mirrored.NewMirroredBlobAccess(backendA, backendB, replicatorAToB, replicatorBToA, mirrored.NewRoundRobinReadPreference([]int{0, 1}))
 
 	t.Run("Success", func(t *testing.T) {
 		// Listings of both backends should be requested.
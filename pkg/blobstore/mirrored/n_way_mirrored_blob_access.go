@@ -0,0 +1,319 @@
+package mirrored
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MaximumBackends is the maximum number of backends that may be
+// provided to NewNWayMirroredBlobAccess. Backends are named "Backend
+// A", "Backend B", etc., which is why this is limited to the number of
+// letters in the alphabet.
+const MaximumBackends = 26
+
+func getBackendLetter(index int) string {
+	return string(rune('A' + index))
+}
+
+func getBackendName(index int) string {
+	return "Backend " + getBackendLetter(index)
+}
+
+type nWayMirroredBlobAccess struct {
+	backends                   []blobstore.BlobAccess
+	replicators                [][]replication.BlobReplicator
+	writeQuorum                int
+	readFanOut                 int
+	readQuorum                 int
+	maximumQuorumReadSizeBytes int
+	readPreference             ReadPreference
+	synchronized               [][]prometheus.Observer
+}
+
+// NewNWayMirroredBlobAccess creates a BlobAccess that applies
+// operations to an arbitrary number of storage backends in such a way
+// that they are mirrored. It is a generalization of
+// NewMirroredBlobAccess.
+//
+// Put() operations are sent to all backends, succeeding if at least
+// writeQuorum backends acknowledge the write. FindMissing() operations
+// are sent to all backends as well, tolerating the same number of
+// failing backends. Objects that are only present in some of the
+// backends are replicated to the others, using replicators[i][j] to
+// copy objects from backend i to backend j.
+//
+// Get() operations are sent to a single backend, chosen by the read
+// preference. If the object cannot be obtained, up to readFanOut
+// backends are consulted in total. If the first backend reported the
+// object as being absent, it is repaired by replicating the object
+// from the backend from which it is eventually obtained.
+//
+// If readQuorum is greater than one, Get() operations are instead sent
+// to readQuorum backends in parallel. The copies of the object are
+// validated and compared against each other, and backends that don't
+// have a copy of the object are repaired. As this requires objects to
+// be loaded into memory, this is only done for objects up to
+// maximumQuorumReadSizeBytes in size. Combined with a write quorum
+// such that readQuorum + writeQuorum exceeds the number of backends,
+// reads are guaranteed to observe the latest successful write.
+func NewNWayMirroredBlobAccess(backends []blobstore.BlobAccess, replicators [][]replication.BlobReplicator, writeQuorum, readFanOut, readQuorum, maximumQuorumReadSizeBytes int, readPreference ReadPreference) blobstore.BlobAccess {
+	mirroredBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(mirroredBlobAccessFindMissingSynchronizations)
+	})
+
+	synchronized := make([][]prometheus.Observer, len(backends))
+	for i := range backends {
+		synchronized[i] = make([]prometheus.Observer, len(backends))
+		for j := range backends {
+			if i != j {
+				synchronized[i][j] = mirroredBlobAccessFindMissingSynchronizations.WithLabelValues(
+					fmt.Sprintf("From%sTo%s", getBackendLetter(i), getBackendLetter(j)))
+			}
+		}
+	}
+	return &nWayMirroredBlobAccess{
+		backends:                   backends,
+		replicators:                replicators,
+		writeQuorum:                writeQuorum,
+		readFanOut:                 readFanOut,
+		readQuorum:                 readQuorum,
+		maximumQuorumReadSizeBytes: maximumQuorumReadSizeBytes,
+		readPreference:             readPreference,
+		synchronized:               synchronized,
+	}
+}
+
+func (ba *nWayMirroredBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	first := ba.readPreference.GetFirstBackend()
+	if ba.readQuorum > 1 && digest.GetSizeBytes() <= int64(ba.maximumQuorumReadSizeBytes) {
+		return ba.getQuorum(ctx, digest, first)
+	}
+	return buffer.WithErrorHandler(
+		ba.backends[first].Get(ctx, digest),
+		&nWayMirroredErrorHandler{
+			blobAccess: ba,
+			context:    ctx,
+			digest:     digest,
+			first:      first,
+			attempts:   1,
+		})
+}
+
+type getQuorumResult struct {
+	data []byte
+	err  error
+}
+
+// getQuorum reads an object from multiple backends in parallel,
+// verifying that all copies are identical.
+func (ba *nWayMirroredBlobAccess) getQuorum(ctx context.Context, blobDigest digest.Digest, first int) buffer.Buffer {
+	resultsChans := make([]chan getQuorumResult, ba.readQuorum)
+	for i := range resultsChans {
+		resultsChans[i] = make(chan getQuorumResult, 1)
+		go func(backend blobstore.BlobAccess, resultsChan chan<- getQuorumResult) {
+			data, err := backend.Get(ctx, blobDigest).ToByteSlice(ba.maximumQuorumReadSizeBytes)
+			resultsChan <- getQuorumResult{data: data, err: err}
+		}(ba.backends[(first+i)%len(ba.backends)], resultsChans[i])
+	}
+
+	var data []byte
+	source := -1
+	var absent []int
+	var firstErr error
+	for i, resultsChan := range resultsChans {
+		index := (first + i) % len(ba.backends)
+		results := <-resultsChan
+		if results.err == nil {
+			if source < 0 {
+				data, source = results.data, index
+			} else if !bytes.Equal(data, results.data) && firstErr == nil {
+				firstErr = status.Errorf(codes.Internal, "%s and %s returned different contents", getBackendName(source), getBackendName(index))
+			}
+		} else if status.Code(results.err) == codes.NotFound {
+			absent = append(absent, index)
+		} else if firstErr == nil {
+			firstErr = util.StatusWrap(results.err, getBackendName(index))
+		}
+	}
+	if firstErr != nil {
+		return buffer.NewBufferFromError(firstErr)
+	}
+	if source < 0 {
+		return buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found in any of the backends"))
+	}
+
+	// Repair backends that don't have a copy of the object.
+	for _, sink := range absent {
+		if err := ba.replicators[source][sink].ReplicateMultiple(ctx, blobDigest.ToSingletonSet()); err != nil {
+			return buffer.NewBufferFromError(util.StatusWrapf(err, "Failed to synchronize from backend %s to backend %s", getBackendLetter(source), getBackendLetter(sink)))
+		}
+	}
+	return buffer.NewValidatedBufferFromByteSlice(data)
+}
+
+func (ba *nWayMirroredBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	// Store the object in all storage backends. The buffer is
+	// cloned in such a way that the data is only read and validated
+	// once, regardless of the number of backends.
+	errs := make([]chan error, len(ba.backends))
+	for i, backend := range ba.backends {
+		var bBackend buffer.Buffer
+		if i == len(ba.backends)-1 {
+			bBackend = b
+		} else {
+			bBackend, b = b.CloneStream()
+		}
+		errs[i] = make(chan error, 1)
+		go func(backend blobstore.BlobAccess, b buffer.Buffer, errChan chan<- error) {
+			errChan <- backend.Put(ctx, digest, b)
+		}(backend, bBackend, errs[i])
+	}
+
+	successes := 0
+	var firstErr error
+	for i, errChan := range errs {
+		if err := <-errChan; err == nil {
+			successes++
+		} else if firstErr == nil {
+			firstErr = util.StatusWrap(err, getBackendName(i))
+		}
+	}
+	if successes < ba.writeQuorum {
+		return firstErr
+	}
+	return nil
+}
+
+func (ba *nWayMirroredBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// Call FindMissing() on all backends.
+	resultsChans := make([]chan findMissingResults, len(ba.backends))
+	for i, backend := range ba.backends {
+		resultsChans[i] = make(chan findMissingResults, 1)
+		go func(i int, backend blobstore.BlobAccess, resultsChan chan<- findMissingResults) {
+			done := ba.readPreference.ObserveOperation(i)
+			results := callFindMissing(ctx, backend, digests)
+			done()
+			resultsChan <- results
+		}(i, backend, resultsChans[i])
+	}
+
+	// Permit as many backends to fail as Put() does.
+	var available []int
+	var missing []digest.Set
+	var firstErr error
+	for i, resultsChan := range resultsChans {
+		if results := <-resultsChan; results.err == nil {
+			available = append(available, i)
+			missing = append(missing, results.missing)
+		} else if firstErr == nil {
+			firstErr = util.StatusWrap(results.err, getBackendName(i))
+		}
+	}
+	if len(available) < ba.writeQuorum || len(available) == 0 {
+		return digest.EmptySet, firstErr
+	}
+
+	// Determine which objects are absent in all backends.
+	missingFromAll := missing[0]
+	for _, m := range missing[1:] {
+		_, missingFromAll, _ = digest.GetDifferenceAndIntersection(missingFromAll, m)
+	}
+
+	// For every object that is absent in a backend, determine the
+	// first backend that has a copy of it.
+	type pendingReplication struct {
+		source, sink int
+		digests      digest.Set
+	}
+	var replications []pendingReplication
+	for sinkIndex, sink := range available {
+		remaining, _, _ := digest.GetDifferenceAndIntersection(missing[sinkIndex], missingFromAll)
+		for sourceIndex, source := range available {
+			if remaining.Empty() {
+				break
+			}
+			if source == sink {
+				continue
+			}
+			present, stillMissing, _ := digest.GetDifferenceAndIntersection(remaining, missing[sourceIndex])
+			if !present.Empty() {
+				replications = append(replications, pendingReplication{
+					source:  source,
+					sink:    sink,
+					digests: present,
+				})
+			}
+			remaining = stillMissing
+		}
+	}
+
+	// Exchange objects between backends.
+	errChans := make([]chan error, 0, len(replications))
+	for _, r := range replications {
+		ba.synchronized[r.source][r.sink].Observe(float64(r.digests.Length()))
+		errChan := make(chan error, 1)
+		errChans = append(errChans, errChan)
+		go func(replicator replication.BlobReplicator, digests digest.Set) {
+			errChan <- replicator.ReplicateMultiple(ctx, digests)
+		}(ba.replicators[r.source][r.sink], r.digests)
+	}
+	var replicationErr error
+	for i, errChan := range errChans {
+		if err := <-errChan; err != nil && replicationErr == nil {
+			r := replications[i]
+			replicationErr = util.StatusWrapf(err, "Failed to synchronize from backend %s to backend %s", getBackendLetter(r.source), getBackendLetter(r.sink))
+		}
+	}
+	if replicationErr != nil {
+		return digest.EmptySet, replicationErr
+	}
+	return missingFromAll, nil
+}
+
+type nWayMirroredErrorHandler struct {
+	blobAccess *nWayMirroredBlobAccess
+	context    context.Context
+	digest     digest.Digest
+	first      int
+	attempts   int
+	fatalErr   error
+}
+
+func (eh *nWayMirroredErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	ba := eh.blobAccess
+	current := (eh.first + eh.attempts - 1) % len(ba.backends)
+	if status.Code(err) != codes.NotFound && eh.fatalErr == nil {
+		// Retain the first fatal error, so that it can be
+		// returned if no other backend is able to serve the
+		// object.
+		eh.fatalErr = util.StatusWrap(err, getBackendName(current))
+	}
+	if eh.attempts >= ba.readFanOut || eh.attempts >= len(ba.backends) {
+		if eh.fatalErr != nil {
+			return nil, eh.fatalErr
+		}
+		return nil, err
+	}
+
+	next := (eh.first + eh.attempts) % len(ba.backends)
+	eh.attempts++
+	if eh.fatalErr == nil {
+		// All backends consulted so far reported the object as
+		// being absent. Attempt to repair the first backend.
+		return ba.replicators[next][eh.first].ReplicateSingle(eh.context, eh.digest), nil
+	}
+	return ba.backends[next].Get(eh.context, eh.digest), nil
+}
+
+func (eh *nWayMirroredErrorHandler) Done() {}
@@ -0,0 +1,200 @@
+package mirrored_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNWayMirroredBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	backends := []*mock.MockBlobAccess{
+		mock.NewMockBlobAccess(ctrl),
+		mock.NewMockBlobAccess(ctrl),
+		mock.NewMockBlobAccess(ctrl),
+	}
+	replicators := make([][]*mock.MockBlobReplicator, 3)
+	replicatorInterfaces := make([][]replication.BlobReplicator, 3)
+	for i := range replicators {
+		replicators[i] = make([]*mock.MockBlobReplicator, 3)
+		replicatorInterfaces[i] = make([]replication.BlobReplicator, 3)
+		for j := range replicators[i] {
+			if i != j {
+				replicators[i][j] = mock.NewMockBlobReplicator(ctrl)
+				replicatorInterfaces[i][j] = replicators[i][j]
+			}
+		}
+	}
+	blobAccess := mirrored.NewNWayMirroredBlobAccess(
+		[]blobstore.BlobAccess{backends[0], backends[1], backends[2]},
+		replicatorInterfaces,
+		/* writeQuorum = */ 2,
+		/* readFanOut = */ 2,
+		/* readQuorum = */ 1,
+		/* maximumQuorumReadSizeBytes = */ 0,
+		mirrored.NewRoundRobinReadPreference([]int{0, 1, 2}))
+
+	blobDigest := digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+	expectPut := func(backend *mock.MockBlobAccess, err error) {
+		backend.EXPECT().Put(ctx, blobDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, dataErr := b.ToByteSlice(100)
+				require.NoError(t, dataErr)
+				require.Equal(t, []byte("Hello world"), data)
+				return err
+			})
+	}
+
+	t.Run("GetRepair", func(t *testing.T) {
+		// The first backend doesn't have the object, while the
+		// second one does. The first backend should be repaired.
+		backends[0].EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
+		replicators[1][0].EXPECT().ReplicateSingle(ctx, blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("GetFanOutExhausted", func(t *testing.T) {
+		// Requests should rotate between backends. If the object
+		// cannot be obtained from two backends, the error of the
+		// failing backend should be returned.
+		backends[1].EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+		backends[2].EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Backend B: Server offline"), err)
+	})
+
+	t.Run("PutQuorumReached", func(t *testing.T) {
+		expectPut(backends[0], nil)
+		expectPut(backends[1], status.Error(codes.Unavailable, "Server offline"))
+		expectPut(backends[2], nil)
+
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+
+	t.Run("PutQuorumNotReached", func(t *testing.T) {
+		expectPut(backends[0], nil)
+		expectPut(backends[1], status.Error(codes.Unavailable, "Server offline"))
+		expectPut(backends[2], status.Error(codes.Internal, "Disk on fire"))
+
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.Unavailable, "Backend B: Server offline"),
+			blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+
+	t.Run("FindMissingReplication", func(t *testing.T) {
+		digest1 := digest.MustNewDigest("default", "00000000000000000000000000000001", 1)
+		digest2 := digest.MustNewDigest("default", "00000000000000000000000000000002", 2)
+		digest3 := digest.MustNewDigest("default", "00000000000000000000000000000003", 3)
+		allDigests := digest.NewSetBuilder().Add(digest1).Add(digest2).Add(digest3).Build()
+
+		// Digest 1 is only present in backend A, digest 2 is
+		// present in backends B and C, and digest 3 is absent.
+		backends[0].EXPECT().FindMissing(ctx, allDigests).
+			Return(digest.NewSetBuilder().Add(digest2).Add(digest3).Build(), nil)
+		backends[1].EXPECT().FindMissing(ctx, allDigests).
+			Return(digest.NewSetBuilder().Add(digest1).Add(digest3).Build(), nil)
+		backends[2].EXPECT().FindMissing(ctx, allDigests).
+			Return(digest.NewSetBuilder().Add(digest1).Add(digest3).Build(), nil)
+		replicators[1][0].EXPECT().ReplicateMultiple(ctx, digest2.ToSingletonSet())
+		replicators[0][1].EXPECT().ReplicateMultiple(ctx, digest1.ToSingletonSet())
+		replicators[0][2].EXPECT().ReplicateMultiple(ctx, digest1.ToSingletonSet())
+
+		missing, err := blobAccess.FindMissing(ctx, allDigests)
+		require.NoError(t, err)
+		require.Equal(t, digest3.ToSingletonSet(), missing)
+	})
+
+	t.Run("FindMissingBackendFailure", func(t *testing.T) {
+		// A single failing backend should be tolerated, as it
+		// still permits writes to succeed.
+		backends[0].EXPECT().FindMissing(ctx, blobDigest.ToSingletonSet()).
+			Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+		backends[1].EXPECT().FindMissing(ctx, blobDigest.ToSingletonSet()).
+			Return(blobDigest.ToSingletonSet(), nil)
+		backends[2].EXPECT().FindMissing(ctx, blobDigest.ToSingletonSet()).
+			Return(blobDigest.ToSingletonSet(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, blobDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, blobDigest.ToSingletonSet(), missing)
+	})
+}
+
+func TestNWayMirroredBlobAccessReadQuorum(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	backendA := mock.NewMockBlobAccess(ctrl)
+	backendB := mock.NewMockBlobAccess(ctrl)
+	backendC := mock.NewMockBlobAccess(ctrl)
+	replicatorAToC := mock.NewMockBlobReplicator(ctrl)
+	blobAccess := mirrored.NewNWayMirroredBlobAccess(
+		[]blobstore.BlobAccess{backendA, backendB, backendC},
+		[][]replication.BlobReplicator{
+			{nil, mock.NewMockBlobReplicator(ctrl), replicatorAToC},
+			{mock.NewMockBlobReplicator(ctrl), nil, mock.NewMockBlobReplicator(ctrl)},
+			{mock.NewMockBlobReplicator(ctrl), mock.NewMockBlobReplicator(ctrl), nil},
+		},
+		/* writeQuorum = */ 2,
+		/* readFanOut = */ 3,
+		/* readQuorum = */ 2,
+		/* maximumQuorumReadSizeBytes = */ 100,
+		mirrored.NewRoundRobinReadPreference([]int{0, 1, 2}))
+
+	blobDigest := digest.MustNewDigest("default", "64ec88ca00b268e5ba1a35678a1b5316d212f4f366b2477232534a8aeca37f3c", 11)
+
+	t.Run("Success", func(t *testing.T) {
+		backendA.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+		backendB.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		backendB.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+		backendC.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Goodbye world")))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		testutil.RequireEqualStatus(t, status.Error(codes.Internal, "Backend B and Backend C returned different contents"), err)
+	})
+
+	t.Run("Repair", func(t *testing.T) {
+		// Backends that don't have a copy should be repaired.
+		backendC.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Blob not found")))
+		backendA.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+		replicatorAToC.EXPECT().ReplicateMultiple(ctx, blobDigest.ToSingletonSet())
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+}
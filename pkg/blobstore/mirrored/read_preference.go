@@ -0,0 +1,109 @@
+package mirrored
+
+import (
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/atomic"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+)
+
+// ReadPreference is used by MirroredBlobAccess to determine which
+// backend should be consulted first when reading an object. Other
+// backends are only consulted if the object cannot be obtained from
+// this backend.
+type ReadPreference interface {
+	// GetFirstBackend returns the index of the backend that should
+	// be consulted first.
+	GetFirstBackend() int
+
+	// ObserveOperation is called when an operation against a
+	// backend is started. The function that is returned is called
+	// when the operation completes. This allows the read preference
+	// to take the responsiveness of backends into account.
+	ObserveOperation(backend int) func()
+}
+
+func noopObservation() {}
+
+type roundRobinReadPreference struct {
+	candidates []int
+	round      atomic.Uint32
+}
+
+// NewRoundRobinReadPreference creates a ReadPreference that alternates
+// between a set of candidate backends. When the set of candidates
+// contains all backends, load is spread evenly. Providing a single
+// candidate causes all reads to be sent to a primary backend, while
+// providing the backends located in the same zone as the current
+// process prevents reads from crossing zones.
+func NewRoundRobinReadPreference(candidates []int) ReadPreference {
+	return &roundRobinReadPreference{
+		candidates: candidates,
+	}
+}
+
+func (rp *roundRobinReadPreference) GetFirstBackend() int {
+	return rp.candidates[(rp.round.Add(1)-1)%uint32(len(rp.candidates))]
+}
+
+func (rp *roundRobinReadPreference) ObserveOperation(backend int) func() {
+	return noopObservation
+}
+
+type lowestLatencyReadPreference struct {
+	clock           clock.Clock
+	smoothingFactor float64
+
+	lock      sync.Mutex
+	latencies []float64
+	observed  []bool
+}
+
+// NewLowestLatencyReadPreference creates a ReadPreference that sends
+// reads to the backend that has the lowest latency. The latency of
+// each backend is tracked by computing an exponentially weighted moving
+// average of the duration of operations. The smoothing factor controls
+// how much weight is given to the latest observation.
+//
+// As Get() operations return data in a streaming fashion, their
+// duration depends on the pace at which the caller consumes data. The
+// duration of FindMissing() operations, which are sent to all backends,
+// is therefore used instead.
+func NewLowestLatencyReadPreference(clock clock.Clock, backendsCount int, smoothingFactor float64) ReadPreference {
+	return &lowestLatencyReadPreference{
+		clock:           clock,
+		smoothingFactor: smoothingFactor,
+		latencies:       make([]float64, backendsCount),
+		observed:        make([]bool, backendsCount),
+	}
+}
+
+func (rp *lowestLatencyReadPreference) GetFirstBackend() int {
+	// Backends for which no latency has been observed yet have a
+	// latency of zero, causing them to be preferred. This ensures
+	// that operations are sent to them.
+	rp.lock.Lock()
+	defer rp.lock.Unlock()
+	best := 0
+	for i, latency := range rp.latencies[1:] {
+		if latency < rp.latencies[best] {
+			best = i + 1
+		}
+	}
+	return best
+}
+
+func (rp *lowestLatencyReadPreference) ObserveOperation(backend int) func() {
+	start := rp.clock.Now()
+	return func() {
+		latency := rp.clock.Now().Sub(start).Seconds()
+		rp.lock.Lock()
+		if rp.observed[backend] {
+			rp.latencies[backend] += rp.smoothingFactor * (latency - rp.latencies[backend])
+		} else {
+			rp.latencies[backend] = latency
+			rp.observed[backend] = true
+		}
+		rp.lock.Unlock()
+	}
+}
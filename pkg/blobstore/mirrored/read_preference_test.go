@@ -0,0 +1,71 @@
+package mirrored_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/mirrored"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundRobinReadPreference(t *testing.T) {
+	t.Run("AllBackends", func(t *testing.T) {
+		readPreference := mirrored.NewRoundRobinReadPreference([]int{0, 1, 2})
+		for i := 0; i < 10; i++ {
+			require.Equal(t, i%3, readPreference.GetFirstBackend())
+		}
+	})
+
+	t.Run("Primary", func(t *testing.T) {
+		readPreference := mirrored.NewRoundRobinReadPreference([]int{0})
+		for i := 0; i < 10; i++ {
+			require.Equal(t, 0, readPreference.GetFirstBackend())
+		}
+	})
+
+	t.Run("Subset", func(t *testing.T) {
+		// Only backends located in the local zone should be
+		// consulted first.
+		readPreference := mirrored.NewRoundRobinReadPreference([]int{1, 3})
+		for i := 0; i < 10; i++ {
+			require.Equal(t, 1+i%2*2, readPreference.GetFirstBackend())
+		}
+	})
+}
+
+func TestLowestLatencyReadPreference(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	clock := mock.NewMockClock(ctrl)
+	readPreference := mirrored.NewLowestLatencyReadPreference(clock, 2, 0.5)
+
+	observe := func(backend int, latency time.Duration) {
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		done := readPreference.ObserveOperation(backend)
+		clock.EXPECT().Now().Return(time.Unix(1000, 0).Add(latency))
+		done()
+	}
+
+	// Without any observations, the first backend should be used.
+	require.Equal(t, 0, readPreference.GetFirstBackend())
+
+	// Backends for which no latency has been observed should be
+	// preferred, so that their latency is measured.
+	observe(0, 100*time.Millisecond)
+	require.Equal(t, 1, readPreference.GetFirstBackend())
+
+	// The first observation of a backend should be used as is.
+	observe(1, 300*time.Millisecond)
+	require.Equal(t, 0, readPreference.GetFirstBackend())
+
+	// Subsequent observations should be averaged. Backend A now
+	// has a latency of 0.5*100ms + 0.5*700ms = 400ms.
+	observe(0, 700*time.Millisecond)
+	require.Equal(t, 1, readPreference.GetFirstBackend())
+
+	// Backend B now has a latency of 0.5*300ms + 0.5*600ms = 450ms.
+	observe(1, 600*time.Millisecond)
+	require.Equal(t, 0, readPreference.GetFirstBackend())
+}
@@ -0,0 +1,183 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	prioritizingBlobAccessPrometheusMetrics sync.Once
+
+	prioritizingBlobAccessOperationsWaiting = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "prioritizing_blob_access_operations_waiting",
+			Help:      "Number of operations that are waiting for capacity to become available, per priority class.",
+		},
+		[]string{"name", "class"})
+	prioritizingBlobAccessOperationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "prioritizing_blob_access_operations_total",
+			Help:      "Number of operations that were forwarded to the backend, per priority class.",
+		},
+		[]string{"name", "class"})
+)
+
+// PriorityClassifier assigns operations to a priority class, based on
+// information contained in the context of the request.
+type PriorityClassifier interface {
+	// ClassifyRequest returns the index of the priority class.
+	ClassifyRequest(ctx context.Context) int
+}
+
+type metadataPriorityClassifier struct {
+	header       string
+	headerValues map[string]int
+	toolNames    map[string]int
+	defaultClass int
+}
+
+// NewMetadataPriorityClassifier creates a PriorityClassifier that
+// assigns requests to a priority class based on gRPC metadata. The
+// value of the provided metadata header is considered first. If it
+// does not match, the name of the tool stored in the REv2
+// RequestMetadata is used (e.g., "bazel"). Requests that match neither
+// are placed in the default class.
+func NewMetadataPriorityClassifier(header string, headerValues, toolNames map[string]int, defaultClass int) PriorityClassifier {
+	return &metadataPriorityClassifier{
+		header:       header,
+		headerValues: headerValues,
+		toolNames:    toolNames,
+		defaultClass: defaultClass,
+	}
+}
+
+func (pc *metadataPriorityClassifier) ClassifyRequest(ctx context.Context) int {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return pc.defaultClass
+	}
+	if pc.header != "" {
+		for _, value := range md.Get(pc.header) {
+			if class, ok := pc.headerValues[value]; ok {
+				return class
+			}
+		}
+	}
+	if len(pc.toolNames) > 0 {
+		for _, value := range md.Get("build.bazel.remote.execution.v2.requestmetadata-bin") {
+			var requestMetadata remoteexecution.RequestMetadata
+			if err := proto.Unmarshal([]byte(value), &requestMetadata); err == nil {
+				if class, ok := pc.toolNames[requestMetadata.ToolDetails.GetToolName()]; ok {
+					return class
+				}
+			}
+		}
+	}
+	return pc.defaultClass
+}
+
+type prioritizingClassMetrics struct {
+	operationsWaiting prometheus.Gauge
+	operationsTotal   prometheus.Counter
+}
+
+type prioritizingBlobAccess struct {
+	base       BlobAccess
+	classifier PriorityClassifier
+	scheduler  *PriorityScheduler
+	metrics    []prioritizingClassMetrics
+}
+
+// NewPrioritizingBlobAccess creates a decorator for BlobAccess that
+// limits the number of operations that are forwarded to the backend
+// concurrently. Operations that exceed this limit are queued per
+// priority class, and are forwarded in proportion to the weights of the
+// classes. This prevents interactive builds from being starved by batch
+// jobs that issue large numbers of requests.
+//
+// Operations occupy capacity until they complete. For Get(), this is
+// when the returned buffer has been consumed. For Put(), this includes
+// the time needed to read the buffer provided by the caller.
+func NewPrioritizingBlobAccess(base BlobAccess, classifier PriorityClassifier, classNames []string, weights []int, maximumConcurrency int, name string) BlobAccess {
+	prioritizingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(prioritizingBlobAccessOperationsWaiting)
+		prometheus.MustRegister(prioritizingBlobAccessOperationsTotal)
+	})
+
+	metrics := make([]prioritizingClassMetrics, 0, len(classNames))
+	for _, className := range classNames {
+		metrics = append(metrics, prioritizingClassMetrics{
+			operationsWaiting: prioritizingBlobAccessOperationsWaiting.WithLabelValues(name, className),
+			operationsTotal:   prioritizingBlobAccessOperationsTotal.WithLabelValues(name, className),
+		})
+	}
+	return &prioritizingBlobAccess{
+		base:       base,
+		classifier: classifier,
+		scheduler:  NewPriorityScheduler(weights, maximumConcurrency),
+		metrics:    metrics,
+	}
+}
+
+func (ba *prioritizingBlobAccess) acquire(ctx context.Context) error {
+	class := ba.classifier.ClassifyRequest(ctx)
+	metrics := &ba.metrics[class]
+	metrics.operationsWaiting.Inc()
+	err := ba.scheduler.Enqueue(class).Wait(ctx)
+	metrics.operationsWaiting.Dec()
+	if err != nil {
+		return err
+	}
+	metrics.operationsTotal.Inc()
+	return nil
+}
+
+func (ba *prioritizingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	if err := ba.acquire(ctx); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.WithErrorHandler(
+		ba.base.Get(ctx, digest),
+		prioritizingErrorHandler{scheduler: ba.scheduler})
+}
+
+func (ba *prioritizingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	if err := ba.acquire(ctx); err != nil {
+		b.Discard()
+		return err
+	}
+	defer ba.scheduler.Release()
+	return ba.base.Put(ctx, digest, b)
+}
+
+func (ba *prioritizingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	if err := ba.acquire(ctx); err != nil {
+		return digest.EmptySet, err
+	}
+	defer ba.scheduler.Release()
+	return ba.base.FindMissing(ctx, digests)
+}
+
+type prioritizingErrorHandler struct {
+	scheduler *PriorityScheduler
+}
+
+func (eh prioritizingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	return nil, err
+}
+
+func (eh prioritizingErrorHandler) Done() {
+	eh.scheduler.Release()
+}
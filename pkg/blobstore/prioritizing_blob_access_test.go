@@ -0,0 +1,117 @@
+package blobstore_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMetadataPriorityClassifier(t *testing.T) {
+	classifier := blobstore.NewMetadataPriorityClassifier(
+		"x-priority-class",
+		map[string]int{"interactive": 0, "batch": 1},
+		map[string]int{"bazel": 0, "ci-runner": 1},
+		2)
+
+	requestMetadata, err := proto.Marshal(&remoteexecution.RequestMetadata{
+		ToolDetails: &remoteexecution.ToolDetails{
+			ToolName: "ci-runner",
+		},
+	})
+	require.NoError(t, err)
+
+	t.Run("NoMetadata", func(t *testing.T) {
+		require.Equal(t, 2, classifier.ClassifyRequest(context.Background()))
+	})
+
+	t.Run("Header", func(t *testing.T) {
+		// The header takes precedence over the tool name.
+		ctx := metadata.NewIncomingContext(
+			context.Background(),
+			metadata.Pairs(
+				"x-priority-class", "interactive",
+				"build.bazel.remote.execution.v2.requestmetadata-bin", string(requestMetadata)))
+		require.Equal(t, 0, classifier.ClassifyRequest(ctx))
+	})
+
+	t.Run("ToolName", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(
+			context.Background(),
+			metadata.Pairs(
+				"x-priority-class", "unknown",
+				"build.bazel.remote.execution.v2.requestmetadata-bin", string(requestMetadata)))
+		require.Equal(t, 1, classifier.ClassifyRequest(ctx))
+	})
+}
+
+func TestPrioritizingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess := blobstore.NewPrioritizingBlobAccess(
+		baseBlobAccess,
+		blobstore.NewMetadataPriorityClassifier("x-priority-class", map[string]int{}, map[string]int{}, 0),
+		[]string{"default"},
+		[]int{1},
+		1,
+		"cas")
+
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digests := blobDigest.ToSingletonSet()
+
+	t.Run("Success", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+		missing, err := blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+
+	t.Run("Cancellation", func(t *testing.T) {
+		// A buffer returned by Get() occupies capacity until it
+		// has been consumed. Other operations should block
+		// until their context is canceled.
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewCASBufferFromReader(blobDigest, ioutil.NopCloser(bytes.NewBufferString("Hello")), buffer.UserProvided))
+		b := blobAccess.Get(ctx, blobDigest)
+
+		canceledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := blobAccess.FindMissing(canceledCtx, digests)
+		testutil.RequireEqualStatus(t, status.Error(codes.Canceled, "context canceled"), err)
+
+		// Once the buffer is consumed, capacity becomes
+		// available once again.
+		b.Discard()
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).Return(digest.EmptySet, nil)
+		_, err = blobAccess.FindMissing(ctx, digests)
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,146 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// PriorityScheduler limits the number of operations that may run
+// concurrently. Operations that cannot run immediately are queued per
+// priority class. When capacity becomes available, it is handed out in
+// proportion to the weights of the classes that have operations
+// queued, using stride scheduling. This approximates weighted fair
+// queuing, where every operation has the same cost.
+//
+// Queuing and waiting are separate steps, so that callers can observe
+// the order in which operations are queued.
+type PriorityScheduler struct {
+	lock        sync.Mutex
+	available   int
+	virtualTime float64
+	classes     []priorityClassState
+}
+
+type priorityClassState struct {
+	stride  float64
+	pass    float64
+	waiters []*PrioritySchedulerTicket
+}
+
+// NewPriorityScheduler creates a PriorityScheduler for a set of
+// priority classes with given weights. Weights must be positive.
+func NewPriorityScheduler(weights []int, maximumConcurrency int) *PriorityScheduler {
+	s := &PriorityScheduler{
+		available: maximumConcurrency,
+		classes:   make([]priorityClassState, len(weights)),
+	}
+	for i, weight := range weights {
+		s.classes[i].stride = 1 / float64(weight)
+	}
+	return s
+}
+
+// charge the capacity that is handed out to a class. Classes that have
+// been idle may not use capacity they did not use in the past, which
+// is why their pass is never permitted to lag behind virtual time.
+func (s *PriorityScheduler) charge(c *priorityClassState) {
+	if c.pass < s.virtualTime {
+		c.pass = s.virtualTime
+	}
+	s.virtualTime = c.pass
+	c.pass += c.stride
+}
+
+func (s *PriorityScheduler) hasWaiters() bool {
+	for i := range s.classes {
+		if len(s.classes[i].waiters) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Enqueue a request for running an operation that belongs to a given
+// priority class. The operation may only run after Wait() on the
+// returned ticket succeeds.
+func (s *PriorityScheduler) Enqueue(class int) *PrioritySchedulerTicket {
+	t := &PrioritySchedulerTicket{
+		scheduler: s,
+		class:     class,
+		granted:   make(chan struct{}),
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := &s.classes[class]
+	if s.available > 0 && !s.hasWaiters() {
+		s.available--
+		s.charge(c)
+		close(t.granted)
+	} else {
+		c.waiters = append(c.waiters, t)
+	}
+	return t
+}
+
+// Release capacity obtained through Wait(), handing it to the next
+// queued operation.
+func (s *PriorityScheduler) Release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var next *priorityClassState
+	for i := range s.classes {
+		c := &s.classes[i]
+		if len(c.waiters) > 0 && (next == nil || c.pass < next.pass) {
+			next = c
+		}
+	}
+	if next == nil {
+		s.available++
+		return
+	}
+	t := next.waiters[0]
+	next.waiters = next.waiters[1:]
+	s.charge(next)
+	close(t.granted)
+}
+
+// PrioritySchedulerTicket is returned by PriorityScheduler.Enqueue(). It
+// can be used to wait for the operation to be permitted to run.
+type PrioritySchedulerTicket struct {
+	scheduler *PriorityScheduler
+	class     int
+	granted   chan struct{}
+}
+
+// Wait for the operation to be permitted to run. If the context is
+// canceled while waiting, the operation is removed from the queue and
+// an error is returned. Release() must be called if and only if Wait()
+// succeeds.
+func (t *PrioritySchedulerTicket) Wait(ctx context.Context) error {
+	select {
+	case <-t.granted:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s := t.scheduler
+	s.lock.Lock()
+	c := &s.classes[t.class]
+	for i, waiter := range c.waiters {
+		if waiter == t {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			s.lock.Unlock()
+			return util.StatusFromContext(ctx)
+		}
+	}
+	s.lock.Unlock()
+
+	// Capacity was granted concurrently with the context being
+	// canceled. Let the caller proceed, so that the capacity is
+	// released through the regular code path.
+	return nil
+}
@@ -0,0 +1,71 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPriorityScheduler(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("WeightedOrdering", func(t *testing.T) {
+		scheduler := blobstore.NewPriorityScheduler([]int{3, 1}, 1)
+
+		// The first operation may run immediately.
+		require.NoError(t, scheduler.Enqueue(0).Wait(ctx))
+
+		// Queue four operations in both classes. Operations
+		// report their name once they are permitted to run.
+		granted := make(chan string)
+		for _, name := range []string{"a1", "a2", "a3", "a4"} {
+			ticket := scheduler.Enqueue(0)
+			go func(name string) {
+				require.NoError(t, ticket.Wait(ctx))
+				granted <- name
+			}(name)
+		}
+		for _, name := range []string{"b1", "b2", "b3", "b4"} {
+			ticket := scheduler.Enqueue(1)
+			go func(name string) {
+				require.NoError(t, ticket.Wait(ctx))
+				granted <- name
+			}(name)
+		}
+
+		// Capacity should be handed out in a 3:1 ratio, for as
+		// long as both classes have operations queued. As the
+		// first class already consumed capacity, the second
+		// class goes first.
+		for _, name := range []string{"b1", "a1", "a2", "a3", "b2", "a4", "b3", "b4"} {
+			scheduler.Release()
+			require.Equal(t, name, <-granted)
+		}
+		scheduler.Release()
+	})
+
+	t.Run("Cancellation", func(t *testing.T) {
+		scheduler := blobstore.NewPriorityScheduler([]int{1}, 1)
+		require.NoError(t, scheduler.Enqueue(0).Wait(ctx))
+
+		// Waiting on an operation whose context is canceled
+		// should cause it to be removed from the queue.
+		canceledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.Canceled, "context canceled"),
+			scheduler.Enqueue(0).Wait(canceledCtx))
+
+		// As the queue is empty, releasing should allow the next
+		// operation to run immediately.
+		scheduler.Release()
+		require.NoError(t, scheduler.Enqueue(0).Wait(canceledCtx))
+	})
+}
@@ -0,0 +1,204 @@
+package blobstore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	quotaEnforcingBlobAccessPrometheusMetrics sync.Once
+
+	quotaEnforcingBlobAccessBytesWritten = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "quota_enforcing_blob_access_bytes_written",
+			Help:      "Number of bytes written per instance name prefix since the last reset.",
+		},
+		[]string{"name", "instance_name_prefix"})
+	quotaEnforcingBlobAccessQuotaBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "quota_enforcing_blob_access_quota_bytes",
+			Help:      "Maximum number of bytes that may be written per instance name prefix.",
+		},
+		[]string{"name", "instance_name_prefix"})
+	quotaEnforcingBlobAccessPutsRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "quota_enforcing_blob_access_puts_rejected_total",
+			Help:      "Number of Put() operations that were rejected due to the quota being exceeded.",
+		},
+		[]string{"name", "instance_name_prefix"})
+)
+
+// Quota of the number of bytes that may be written for a given
+// instance name prefix.
+type Quota struct {
+	InstanceNamePrefix digest.InstanceName
+	MaximumSizeBytes   int64
+}
+
+// QuotaUsage contains the number of bytes written for a given instance
+// name prefix, as returned by QuotaEnforcingBlobAccess.GetUsage().
+type QuotaUsage struct {
+	InstanceNamePrefix string `json:"instance_name_prefix"`
+	BytesWritten       int64  `json:"bytes_written"`
+	MaximumSizeBytes   int64  `json:"maximum_size_bytes"`
+}
+
+// QuotaEnforcingBlobAccess is a decorator for BlobAccess that limits
+// the number of bytes that may be written per instance name prefix.
+// In addition to providing the BlobAccess interface, it offers
+// functions for inspecting and resetting usage.
+type QuotaEnforcingBlobAccess interface {
+	BlobAccess
+
+	// GetUsage returns the number of bytes written for each of the
+	// instance name prefixes for which a quota is configured.
+	GetUsage() []QuotaUsage
+	// ResetUsage resets the number of bytes written for an
+	// instance name prefix to zero, causing writes to be
+	// permitted once again.
+	ResetUsage(instanceNamePrefix digest.InstanceName) error
+}
+
+type quotaState struct {
+	instanceNamePrefix string
+	maximumSizeBytes   int64
+	bytesWritten       int64
+
+	bytesWrittenGauge prometheus.Gauge
+	putsRejected      prometheus.Counter
+}
+
+type quotaEnforcingBlobAccess struct {
+	BlobAccess
+
+	trie   *digest.InstanceNameTrie
+	lock   sync.Mutex
+	quotas []quotaState
+}
+
+// NewQuotaEnforcingBlobAccess creates a decorator for BlobAccess that
+// tracks the number of bytes written per instance name prefix. Put()
+// operations are rejected with RESOURCE_EXHAUSTED if they would cause
+// the quota of the longest matching instance name prefix to be
+// exceeded. Writes for instance names for which no quota is configured
+// are not limited.
+//
+// Usage is tracked in memory. It is not persisted across restarts, nor
+// is it shared between replicas. Objects that already exist in storage
+// are counted again when rewritten, as determining whether a write is
+// redundant would require calling FindMissing().
+func NewQuotaEnforcingBlobAccess(base BlobAccess, quotas []Quota, name string) (QuotaEnforcingBlobAccess, error) {
+	quotaEnforcingBlobAccessPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(quotaEnforcingBlobAccessBytesWritten)
+		prometheus.MustRegister(quotaEnforcingBlobAccessQuotaBytes)
+		prometheus.MustRegister(quotaEnforcingBlobAccessPutsRejected)
+	})
+
+	ba := &quotaEnforcingBlobAccess{
+		BlobAccess: base,
+		trie:       digest.NewInstanceNameTrie(),
+		quotas:     make([]quotaState, 0, len(quotas)),
+	}
+	for i, quota := range quotas {
+		instanceNamePrefix := quota.InstanceNamePrefix.String()
+		for _, existing := range ba.quotas {
+			if existing.instanceNamePrefix == instanceNamePrefix {
+				return nil, status.Errorf(codes.InvalidArgument, "Instance name prefix %#v is specified multiple times", instanceNamePrefix)
+			}
+		}
+		ba.trie.Set(quota.InstanceNamePrefix, i)
+		quotaEnforcingBlobAccessQuotaBytes.WithLabelValues(name, instanceNamePrefix).Set(float64(quota.MaximumSizeBytes))
+		bytesWrittenGauge := quotaEnforcingBlobAccessBytesWritten.WithLabelValues(name, instanceNamePrefix)
+		bytesWrittenGauge.Set(0)
+		ba.quotas = append(ba.quotas, quotaState{
+			instanceNamePrefix: instanceNamePrefix,
+			maximumSizeBytes:   quota.MaximumSizeBytes,
+			bytesWrittenGauge:  bytesWrittenGauge,
+			putsRejected:       quotaEnforcingBlobAccessPutsRejected.WithLabelValues(name, instanceNamePrefix),
+		})
+	}
+	return ba, nil
+}
+
+func (ba *quotaEnforcingBlobAccess) Put(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+	i := ba.trie.Get(blobDigest.GetInstanceName())
+	if i < 0 {
+		return ba.BlobAccess.Put(ctx, blobDigest, b)
+	}
+
+	sizeBytes, err := b.GetSizeBytes()
+	if err != nil {
+		b.Discard()
+		return err
+	}
+
+	// Reserve space prior to writing, so that concurrent writes
+	// cannot cause the quota to be exceeded.
+	q := &ba.quotas[i]
+	ba.lock.Lock()
+	if q.bytesWritten+sizeBytes > q.maximumSizeBytes {
+		bytesWritten := q.bytesWritten
+		ba.lock.Unlock()
+		b.Discard()
+		q.putsRejected.Inc()
+		return status.Errorf(codes.ResourceExhausted, "Writing %d bytes would exceed the quota of instance name prefix %#v, which has %d of %d bytes in use", sizeBytes, q.instanceNamePrefix, bytesWritten, q.maximumSizeBytes)
+	}
+	q.bytesWritten += sizeBytes
+	q.bytesWrittenGauge.Set(float64(q.bytesWritten))
+	ba.lock.Unlock()
+
+	if err := ba.BlobAccess.Put(ctx, blobDigest, b); err != nil {
+		// Release the reserved space.
+		ba.lock.Lock()
+		q.bytesWritten -= sizeBytes
+		if q.bytesWritten < 0 {
+			q.bytesWritten = 0
+		}
+		q.bytesWrittenGauge.Set(float64(q.bytesWritten))
+		ba.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (ba *quotaEnforcingBlobAccess) GetUsage() []QuotaUsage {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	usage := make([]QuotaUsage, 0, len(ba.quotas))
+	for _, q := range ba.quotas {
+		usage = append(usage, QuotaUsage{
+			InstanceNamePrefix: q.instanceNamePrefix,
+			BytesWritten:       q.bytesWritten,
+			MaximumSizeBytes:   q.maximumSizeBytes,
+		})
+	}
+	return usage
+}
+
+func (ba *quotaEnforcingBlobAccess) ResetUsage(instanceNamePrefix digest.InstanceName) error {
+	ba.lock.Lock()
+	defer ba.lock.Unlock()
+
+	for i := range ba.quotas {
+		if q := &ba.quotas[i]; q.instanceNamePrefix == instanceNamePrefix.String() {
+			q.bytesWritten = 0
+			q.bytesWrittenGauge.Set(0)
+			return nil
+		}
+	}
+	return status.Errorf(codes.NotFound, "No quota is configured for instance name prefix %#v", instanceNamePrefix.String())
+}
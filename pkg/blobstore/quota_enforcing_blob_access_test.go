@@ -0,0 +1,116 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestQuotaEnforcingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	blobAccess, err := blobstore.NewQuotaEnforcingBlobAccess(baseBlobAccess, []blobstore.Quota{
+		{InstanceNamePrefix: digest.MustNewInstanceName("team1"), MaximumSizeBytes: 20},
+		{InstanceNamePrefix: digest.MustNewInstanceName("team1/ci"), MaximumSizeBytes: 5},
+	}, "cas")
+	require.NoError(t, err)
+
+	team1Digest := digest.MustNewDigest("team1/dev", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	ciDigest := digest.MustNewDigest("team1/ci", "8b1a9953c4611296a827abf8c47804d7", 5)
+	otherDigest := digest.MustNewDigest("team2", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("DuplicatePrefix", func(t *testing.T) {
+		_, err := blobstore.NewQuotaEnforcingBlobAccess(baseBlobAccess, []blobstore.Quota{
+			{InstanceNamePrefix: digest.MustNewInstanceName("team1"), MaximumSizeBytes: 20},
+			{InstanceNamePrefix: digest.MustNewInstanceName("team1"), MaximumSizeBytes: 5},
+		}, "cas")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Instance name prefix \"team1\" is specified multiple times"), err)
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		// Instance names without a quota should not be limited.
+		for i := 0; i < 3; i++ {
+			baseBlobAccess.EXPECT().Put(ctx, otherDigest, gomock.Any()).
+				DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+					b.Discard()
+					return nil
+				})
+			require.NoError(t, blobAccess.Put(ctx, otherDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+		}
+	})
+
+	t.Run("BackendFailure", func(t *testing.T) {
+		// Space reserved by failed writes should be released.
+		baseBlobAccess.EXPECT().Put(ctx, team1Digest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Unavailable, "Server offline")
+			})
+		require.Equal(
+			t,
+			status.Error(codes.Unavailable, "Server offline"),
+			blobAccess.Put(ctx, team1Digest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+		require.Equal(t, []blobstore.QuotaUsage{
+			{InstanceNamePrefix: "team1", BytesWritten: 0, MaximumSizeBytes: 20},
+			{InstanceNamePrefix: "team1/ci", BytesWritten: 0, MaximumSizeBytes: 5},
+		}, blobAccess.GetUsage())
+	})
+
+	t.Run("QuotaExceeded", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Put(ctx, team1Digest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		require.NoError(t, blobAccess.Put(ctx, team1Digest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+
+		require.Equal(
+			t,
+			status.Error(codes.ResourceExhausted, "Writing 11 bytes would exceed the quota of instance name prefix \"team1\", which has 11 of 20 bytes in use"),
+			blobAccess.Put(ctx, team1Digest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+
+		// The longest matching prefix should be used.
+		baseBlobAccess.EXPECT().Put(ctx, ciDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		require.NoError(t, blobAccess.Put(ctx, ciDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+
+		require.Equal(t, []blobstore.QuotaUsage{
+			{InstanceNamePrefix: "team1", BytesWritten: 11, MaximumSizeBytes: 20},
+			{InstanceNamePrefix: "team1/ci", BytesWritten: 5, MaximumSizeBytes: 5},
+		}, blobAccess.GetUsage())
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		require.Equal(
+			t,
+			status.Error(codes.NotFound, "No quota is configured for instance name prefix \"team2\""),
+			blobAccess.ResetUsage(digest.MustNewInstanceName("team2")))
+
+		require.NoError(t, blobAccess.ResetUsage(digest.MustNewInstanceName("team1")))
+		require.Equal(t, []blobstore.QuotaUsage{
+			{InstanceNamePrefix: "team1", BytesWritten: 0, MaximumSizeBytes: 20},
+			{InstanceNamePrefix: "team1/ci", BytesWritten: 5, MaximumSizeBytes: 5},
+		}, blobAccess.GetUsage())
+
+		// Writes should be permitted once again.
+		baseBlobAccess.EXPECT().Put(ctx, team1Digest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+		require.NoError(t, blobAccess.Put(ctx, team1Digest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+}
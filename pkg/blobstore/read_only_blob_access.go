@@ -0,0 +1,29 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type readOnlyBlobAccess struct {
+	BlobAccess
+}
+
+// NewReadOnlyBlobAccess creates a decorator for BlobAccess that rejects
+// all Put() operations. This can be used to expose a mirror of a
+// storage backend that clients cannot modify.
+func NewReadOnlyBlobAccess(base BlobAccess) BlobAccess {
+	return &readOnlyBlobAccess{
+		BlobAccess: base,
+	}
+}
+
+func (ba *readOnlyBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	b.Discard()
+	return status.Error(codes.PermissionDenied, "This storage backend is read-only")
+}
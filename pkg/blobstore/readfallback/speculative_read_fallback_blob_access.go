@@ -0,0 +1,211 @@
+package readfallback
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type speculativeReadFallbackBlobAccess struct {
+	readFallbackBlobAccess
+
+	readBufferFactory blobstore.ReadBufferFactory
+	clock             clock.Clock
+	delay             time.Duration
+}
+
+// NewSpeculativeReadFallbackBlobAccess creates a variant of
+// ReadFallbackBlobAccess that does not wait for the primary backend to
+// report that an object is absent before reading it from the secondary
+// backend. If the primary backend has not responded within the
+// provided delay, a read against the secondary backend is started as
+// well. Data is returned from whichever backend responds successfully
+// first. A delay of zero causes both backends to be queried
+// concurrently.
+//
+// This prevents the latency of reads from doubling when the primary
+// backend is slow or failing, at the cost of placing additional load
+// on the secondary backend. Unlike NewReadFallbackBlobAccess(), hard
+// failures of the primary backend are masked if the secondary backend
+// is capable of serving the object.
+//
+// Objects are only replicated from the secondary backend to the
+// primary backend if the primary backend reported that the object is
+// absent. The replicator is invoked in that case, meaning that any
+// data obtained through speculation is discarded.
+func NewSpeculativeReadFallbackBlobAccess(primary, secondary blobstore.BlobAccess, replicator replication.BlobReplicator, readBufferFactory blobstore.ReadBufferFactory, clock clock.Clock, delay time.Duration) blobstore.BlobAccess {
+	return &speculativeReadFallbackBlobAccess{
+		readFallbackBlobAccess: readFallbackBlobAccess{
+			primary:    primary,
+			secondary:  secondary,
+			replicator: replicator,
+		},
+		readBufferFactory: readBufferFactory,
+		clock:             clock,
+		delay:             delay,
+	}
+}
+
+func (ba *speculativeReadFallbackBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	primary := startSpeculativeRead(ctx, ba.primary, digest)
+	var secondary *speculativeRead
+	timer, timerChannel := ba.clock.NewTimer(ba.delay)
+	defer timer.Stop()
+
+	var primaryErr, secondaryErr error
+	for {
+		select {
+		case <-timerChannel:
+			// The primary backend is taking too long to
+			// respond. Start reading from the secondary
+			// backend as well.
+			timerChannel = nil
+			if secondary == nil {
+				secondary = startSpeculativeRead(ctx, ba.secondary, digest)
+			}
+		case result := <-primary.results:
+			if result.err == nil {
+				if secondary != nil && secondaryErr == nil {
+					secondary.abandon()
+				}
+				return ba.readBufferFactory.NewBufferFromReader(digest, result.reader, buffer.Irreparable(digest))
+			}
+			primaryErr = result.err
+			primary.results = nil
+
+			if status.Code(primaryErr) == codes.NotFound && ba.replicator != nil {
+				// The object is absent in the primary
+				// backend. Let the replicator copy it,
+				// so that subsequent reads don't need
+				// to go to the secondary backend.
+				if secondary != nil && secondaryErr == nil {
+					secondary.abandon()
+				}
+				return buffer.WithErrorHandler(
+					ba.replicator.ReplicateSingle(ctx, digest),
+					&readFallbackErrorHandler{
+						context: ctx,
+						digest:  digest,
+					})
+			}
+			if secondary == nil {
+				secondary = startSpeculativeRead(ctx, ba.secondary, digest)
+			} else if secondaryErr != nil {
+				return buffer.NewBufferFromError(getSpeculativeReadError(primaryErr, secondaryErr))
+			}
+		case result := <-secondary.getResults():
+			if result.err == nil {
+				if primaryErr == nil {
+					primary.abandon()
+				}
+				return ba.readBufferFactory.NewBufferFromReader(digest, result.reader, buffer.Irreparable(digest))
+			}
+			secondaryErr = result.err
+			secondary.results = nil
+			if primaryErr != nil {
+				return buffer.NewBufferFromError(getSpeculativeReadError(primaryErr, secondaryErr))
+			}
+		}
+	}
+}
+
+// getSpeculativeReadError computes the error that should be returned
+// if both backends failed to serve an object. Errors are reported
+// similarly to how ReadFallbackBlobAccess does it.
+func getSpeculativeReadError(primaryErr, secondaryErr error) error {
+	if status.Code(primaryErr) != codes.NotFound {
+		return util.StatusWrap(primaryErr, "Primary")
+	}
+	if status.Code(secondaryErr) != codes.NotFound {
+		return util.StatusWrap(secondaryErr, "Secondary")
+	}
+	return secondaryErr
+}
+
+type speculativeReadResult struct {
+	reader io.ReadCloser
+	err    error
+}
+
+// speculativeRead keeps track of a read against one of the backends
+// that is performed asynchronously.
+type speculativeRead struct {
+	cancel  context.CancelFunc
+	results <-chan speculativeReadResult
+}
+
+// startSpeculativeRead starts reading an object from a backend. Only
+// once the first bytes of the object have been received, the read is
+// reported as being successful.
+func startSpeculativeRead(ctx context.Context, backend blobstore.BlobAccess, digest digest.Digest) *speculativeRead {
+	ctxWithCancel, cancel := context.WithCancel(ctx)
+	results := make(chan speculativeReadResult, 1)
+	go func() {
+		r := backend.Get(ctxWithCancel, digest).ToReader()
+		br := bufio.NewReader(r)
+		if _, err := br.Peek(1); err != nil && err != io.EOF {
+			r.Close()
+			cancel()
+			results <- speculativeReadResult{err: err}
+			return
+		}
+		results <- speculativeReadResult{
+			reader: &speculativeReader{
+				Reader: br,
+				closer: r,
+				cancel: cancel,
+			},
+		}
+	}()
+	return &speculativeRead{
+		cancel:  cancel,
+		results: results,
+	}
+}
+
+// getResults returns the channel on which the results of the read are
+// published. It returns a nil channel if no read has been started,
+// so that it can safely be used as part of a select statement.
+func (sr *speculativeRead) getResults() <-chan speculativeReadResult {
+	if sr == nil {
+		return nil
+	}
+	return sr.results
+}
+
+// abandon a read whose results are no longer needed, because the
+// object has already been obtained from the other backend.
+func (sr *speculativeRead) abandon() {
+	sr.cancel()
+	go func() {
+		if result := <-sr.results; result.err == nil {
+			result.reader.Close()
+		}
+	}()
+}
+
+// speculativeReader is returned by successful speculative reads. It
+// contains the data that was read to determine whether the read was
+// successful, followed by the remainder of the object.
+type speculativeReader struct {
+	*bufio.Reader
+	closer io.Closer
+	cancel context.CancelFunc
+}
+
+func (r *speculativeReader) Close() error {
+	err := r.closer.Close()
+	r.cancel()
+	return err
+}
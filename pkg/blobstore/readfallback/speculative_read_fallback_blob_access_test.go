@@ -0,0 +1,136 @@
+package readfallback_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/readfallback"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSpeculativeReadFallbackBlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	primary := mock.NewMockBlobAccess(ctrl)
+	secondary := mock.NewMockBlobAccess(ctrl)
+	replicator := mock.NewMockBlobReplicator(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blobAccess := readfallback.NewSpeculativeReadFallbackBlobAccess(primary, secondary, replicator, blobstore.CASReadBufferFactory, clock, time.Second)
+	helloDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("PrimarySuccess", func(t *testing.T) {
+		// The primary backend responds before the delay
+		// expires. There is no need to contact the secondary
+		// backend.
+		timer := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(time.Second).Return(timer, nil)
+		primary.EXPECT().Get(gomock.Any(), helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		timer.EXPECT().Stop()
+
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("PrimarySlow", func(t *testing.T) {
+		// The primary backend does not respond before the
+		// delay expires, causing the secondary backend to be
+		// contacted. The read against the primary backend
+		// should be canceled once the secondary backend
+		// responds.
+		timer := mock.NewMockTimer(ctrl)
+		timerChan := make(chan time.Time, 1)
+		timerChan <- time.Unix(1000, 0)
+		clock.EXPECT().NewTimer(time.Second).Return(timer, timerChan)
+		primaryCanceled := make(chan struct{})
+		primary.EXPECT().Get(gomock.Any(), helloDigest).DoAndReturn(
+			func(ctx context.Context, digest digest.Digest) buffer.Buffer {
+				<-ctx.Done()
+				close(primaryCanceled)
+				return buffer.NewBufferFromError(status.Error(codes.Canceled, "Request canceled"))
+			})
+		secondary.EXPECT().Get(gomock.Any(), helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		timer.EXPECT().Stop()
+
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+		<-primaryCanceled
+	})
+
+	t.Run("PrimaryNotFound", func(t *testing.T) {
+		// The primary backend reports that the object is
+		// absent. The replicator should be used to copy it
+		// into the primary backend.
+		timer := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(time.Second).Return(timer, nil)
+		primary.EXPECT().Get(gomock.Any(), helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		replicator.EXPECT().ReplicateSingle(ctx, helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		timer.EXPECT().Stop()
+
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("PrimaryFailureSecondarySuccess", func(t *testing.T) {
+		// Hard failures of the primary backend should cause
+		// the secondary backend to be contacted immediately.
+		timer := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(time.Second).Return(timer, nil)
+		primary.EXPECT().Get(gomock.Any(), helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.Internal, "I/O error")))
+		secondary.EXPECT().Get(gomock.Any(), helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+		timer.EXPECT().Stop()
+
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("BothFailure", func(t *testing.T) {
+		// If both backends fail, the error of the primary
+		// backend is returned, as the object may not be
+		// absent.
+		timer := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(time.Second).Return(timer, nil)
+		primary.EXPECT().Get(gomock.Any(), helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.Internal, "I/O error")))
+		secondary.EXPECT().Get(gomock.Any(), helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+		timer.EXPECT().Stop()
+
+		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Internal, "Primary: I/O error"), err)
+	})
+
+	t.Run("SecondaryCorrupted", func(t *testing.T) {
+		// Data returned by either backend is validated once
+		// more, as it is converted to a buffer through the
+		// ReadBufferFactory.
+		timer := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(time.Second).Return(timer, nil)
+		primary.EXPECT().Get(gomock.Any(), helloDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline")))
+		secondary.EXPECT().Get(gomock.Any(), helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hallo")))
+		timer.EXPECT().Stop()
+
+		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Internal, "Buffer has checksum d1bf93299de1b68e6d382c893bf1215f, while 8b1a9953c4611296a827abf8c47804d7 was expected"), err)
+	})
+}
@@ -2,11 +2,11 @@ package blobstore
 
 import (
 	"context"
-	"log"
 	"time"
 
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/logging"
 	"github.com/buildbarn/bb-storage/pkg/util"
 	"github.com/go-redis/redis/v8"
 
@@ -59,9 +59,9 @@ func (ba *redisBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer
 		func(dataIsValid bool) {
 			if !dataIsValid {
 				if err := ba.redisClient.Del(ctx, key).Err(); err == nil {
-					log.Printf("Blob %#v was malformed and has been deleted from Redis successfully", digest.String())
+					logging.Printf(ctx, "Blob %#v was malformed and has been deleted from Redis successfully", digest.String())
 				} else {
-					log.Printf("Blob %#v was malformed and could not be deleted from Redis: %s", digest.String(), err)
+					logging.Printf(ctx, "Blob %#v was malformed and could not be deleted from Redis: %s", digest.String(), err)
 				}
 			}
 		})
@@ -83,6 +83,28 @@ func (ba *redisBlobAccess) Put(ctx context.Context, digest digest.Digest, b buff
 	return ba.waitIfReplicationEnabled(ctx)
 }
 
+func (ba *redisBlobAccess) Delete(ctx context.Context, digest digest.Digest) error {
+	if err := util.StatusFromContext(ctx); err != nil {
+		return err
+	}
+	if err := ba.redisClient.Del(ctx, digest.GetKey(ba.digestKeyFormat)).Err(); err != nil {
+		return util.StatusWrapWithCode(err, codes.Unavailable, "Failed to delete blob")
+	}
+	return ba.waitIfReplicationEnabled(ctx)
+}
+
+func (ba *redisBlobAccess) GetStatistics(ctx context.Context) (StorageStatistics, error) {
+	// The size of the keyspace also includes keys that are not
+	// managed by this backend, if the database is shared.
+	keysCount, err := ba.redisClient.DBSize(ctx).Result()
+	if err != nil {
+		return StorageStatistics{}, util.StatusWrapWithCode(err, codes.Unavailable, "Failed to obtain keyspace size")
+	}
+	return StorageStatistics{
+		ObjectsCount: keysCount,
+	}, nil
+}
+
 func (ba *redisBlobAccess) waitIfReplicationEnabled(ctx context.Context) error {
 	if ba.replicationCount == 0 {
 		return nil
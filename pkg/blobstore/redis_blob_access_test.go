@@ -2,12 +2,14 @@ package blobstore_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/buildbarn/bb-storage/internal/mock"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/go-redis/redis/v8"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 
@@ -42,3 +44,25 @@ func TestRedisBlobAccessContextCanceled(t *testing.T) {
 	_, err = blobAccess.FindMissing(canceledCtx, digest.EmptySet)
 	require.Equal(t, err, status.Error(codes.Canceled, "context canceled"))
 }
+
+func TestRedisBlobAccessGetStatistics(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	redisClient := mock.NewMockRedisClient(ctrl)
+	blobAccess := blobstore.NewRedisBlobAccess(redisClient, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, 0, 0)
+
+	t.Run("Success", func(t *testing.T) {
+		redisClient.EXPECT().DBSize(ctx).Return(redis.NewIntResult(42, nil))
+
+		statistics, err := blobAccess.(blobstore.StatisticsReporter).GetStatistics(ctx)
+		require.NoError(t, err)
+		require.Equal(t, blobstore.StorageStatistics{ObjectsCount: 42}, statistics)
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		redisClient.EXPECT().DBSize(ctx).Return(redis.NewIntResult(0, errors.New("connection refused")))
+
+		_, err := blobAccess.(blobstore.StatisticsReporter).GetStatistics(ctx)
+		require.Equal(t, status.Error(codes.Unavailable, "Failed to obtain keyspace size: connection refused"), err)
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -31,6 +32,7 @@ type referenceExpandingBlobAccess struct {
 	blobAccess              BlobAccess
 	httpClient              HTTPClient
 	s3                      cloud_aws.S3
+	gcsHTTPClient           HTTPClient
 	maximumMessageSizeBytes int
 }
 
@@ -45,15 +47,39 @@ func getHTTPRangeHeader(reference *icas.Reference) string {
 // Storage (CAS) backend. Any object requested through this BlobAccess
 // will cause its reference to be loaded from the ICAS, followed by
 // fetching its data from the referenced location.
-func NewReferenceExpandingBlobAccess(blobAccess BlobAccess, httpClient HTTPClient, s3 cloud_aws.S3, maximumMessageSizeBytes int) BlobAccess {
+//
+// Objects stored in Google Cloud Storage are loaded through its XML
+// API, using a separate HTTP client that is expected to attach
+// credentials to requests.
+func NewReferenceExpandingBlobAccess(blobAccess BlobAccess, httpClient HTTPClient, s3 cloud_aws.S3, gcsHTTPClient HTTPClient, maximumMessageSizeBytes int) BlobAccess {
 	return &referenceExpandingBlobAccess{
 		blobAccess:              blobAccess,
 		httpClient:              httpClient,
 		s3:                      s3,
+		gcsHTTPClient:           gcsHTTPClient,
 		maximumMessageSizeBytes: maximumMessageSizeBytes,
 	}
 }
 
+// getHTTPRange downloads the range of an object referenced by an ICAS
+// Reference through HTTP.
+func getHTTPRange(ctx context.Context, httpClient HTTPClient, url string, reference *icas.Reference) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to create HTTP request")
+	}
+	req.Header.Add("Range", getHTTPRangeHeader(reference))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "HTTP request failed")
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, status.Errorf(codes.Internal, "HTTP request failed with status %#v", resp.Status)
+	}
+	return resp.Body, nil
+}
+
 func (ba *referenceExpandingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
 	// Load reference from the ICAS.
 	referenceMessage, err := ba.blobAccess.Get(ctx, digest).ToProto(&icas.Reference{}, ba.maximumMessageSizeBytes)
@@ -67,20 +93,10 @@ func (ba *referenceExpandingBlobAccess) Get(ctx context.Context, digest digest.D
 	switch medium := reference.Medium.(type) {
 	case *icas.Reference_HttpUrl:
 		// Download the object through HTTP.
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, medium.HttpUrl, nil)
+		r, err = getHTTPRange(ctx, ba.httpClient, medium.HttpUrl, reference)
 		if err != nil {
-			return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.Internal, "Failed to create HTTP request"))
+			return buffer.NewBufferFromError(err)
 		}
-		req.Header.Add("Range", getHTTPRangeHeader(reference))
-		resp, err := ba.httpClient.Do(req)
-		if err != nil {
-			return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.Internal, "HTTP request failed"))
-		}
-		if resp.StatusCode != http.StatusPartialContent {
-			resp.Body.Close()
-			return buffer.NewBufferFromError(status.Errorf(codes.Internal, "HTTP request failed with status %#v", resp.Status))
-		}
-		r = resp.Body
 	case *icas.Reference_S3_:
 		// Download the object from S3.
 		getObjectOutput, err := ba.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
@@ -92,6 +108,17 @@ func (ba *referenceExpandingBlobAccess) Get(ctx context.Context, digest digest.D
 			return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.Internal, "S3 request failed"))
 		}
 		r = getObjectOutput.Body
+	case *icas.Reference_Gcs:
+		// Download the object from Google Cloud Storage.
+		gcsURL := url.URL{
+			Scheme: "https",
+			Host:   "storage.googleapis.com",
+			Path:   "/" + medium.Gcs.Bucket + "/" + medium.Gcs.Object,
+		}
+		r, err = getHTTPRange(ctx, ba.gcsHTTPClient, gcsURL.String(), reference)
+		if err != nil {
+			return buffer.NewBufferFromError(util.StatusWrap(err, "Google Cloud Storage request failed"))
+		}
 	default:
 		return buffer.NewBufferFromError(status.Error(codes.Unimplemented, "Reference uses an unsupported medium"))
 	}
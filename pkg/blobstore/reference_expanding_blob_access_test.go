@@ -29,7 +29,8 @@ func TestReferenceExpandingBlobAccessGet(t *testing.T) {
 	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
 	httpClient := mock.NewMockHTTPClient(ctrl)
 	s3Client := mock.NewMockS3(ctrl)
-	blobAccess := blobstore.NewReferenceExpandingBlobAccess(baseBlobAccess, httpClient, s3Client, 100)
+	gcsHTTPClient := mock.NewMockHTTPClient(ctrl)
+	blobAccess := blobstore.NewReferenceExpandingBlobAccess(baseBlobAccess, httpClient, s3Client, gcsHTTPClient, 100)
 	helloDigest := digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)
 
 	t.Run("BackendError", func(t *testing.T) {
@@ -277,6 +278,73 @@ func TestReferenceExpandingBlobAccessGet(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, []byte("Hello"), data)
 	})
+
+	t.Run("GCSBadStatusCode", func(t *testing.T) {
+		// Google Cloud Storage returns a response other than
+		// 206 Partial Content.
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).Return(
+			buffer.NewProtoBufferFromProto(
+				&icas.Reference{
+					Medium: &icas.Reference_Gcs{
+						Gcs: &icas.Reference_GCS{
+							Bucket: "mybucket",
+							Object: "myobject",
+						},
+					},
+					OffsetBytes: 100,
+					SizeBytes:   5,
+				},
+				buffer.BackendProvided(buffer.Irreparable(helloDigest))))
+		body := mock.NewMockReadCloser(ctrl)
+		gcsHTTPClient.EXPECT().Do(gomock.Any()).Return(&http.Response{
+			Status:     "403 Forbidden",
+			StatusCode: 403,
+			Body:       body,
+		}, nil)
+		body.EXPECT().Close()
+
+		_, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.Equal(t, status.Error(codes.Internal, "Google Cloud Storage request failed: HTTP request failed with status \"403 Forbidden\""), err)
+	})
+
+	t.Run("GCSSuccess", func(t *testing.T) {
+		// Google Cloud Storage returns valid data. The object
+		// name should be escaped properly.
+		baseBlobAccess.EXPECT().Get(ctx, helloDigest).Return(
+			buffer.NewProtoBufferFromProto(
+				&icas.Reference{
+					Medium: &icas.Reference_Gcs{
+						Gcs: &icas.Reference_GCS{
+							Bucket: "mybucket",
+							Object: "path/to/my object",
+						},
+					},
+					OffsetBytes: 100,
+					SizeBytes:   5,
+				},
+				buffer.BackendProvided(buffer.Irreparable(helloDigest))))
+		body := mock.NewMockReadCloser(ctrl)
+		gcsHTTPClient.EXPECT().Do(gomock.Any()).DoAndReturn(
+			func(req *http.Request) (*http.Response, error) {
+				require.Equal(t, "GET", req.Method)
+				require.Equal(t, "https://storage.googleapis.com/mybucket/path/to/my%20object", req.URL.String())
+				require.Equal(t, "bytes=100-104", req.Header.Get("Range"))
+				return &http.Response{
+					Status:     "206 Partial Content",
+					StatusCode: 206,
+					Body:       body,
+				}, nil
+			})
+		body.EXPECT().Read(gomock.Any()).DoAndReturn(func(p []byte) (int, error) {
+			copy(p, "Hello")
+			return 5, io.EOF
+		})
+		body.EXPECT().Close()
+
+		data, err := blobAccess.Get(ctx, helloDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
 }
 
 func TestReferenceExpandingBlobAccessPut(t *testing.T) {
@@ -285,7 +353,8 @@ func TestReferenceExpandingBlobAccessPut(t *testing.T) {
 	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
 	httpClient := mock.NewMockHTTPClient(ctrl)
 	s3Client := mock.NewMockS3(ctrl)
-	blobAccess := blobstore.NewReferenceExpandingBlobAccess(baseBlobAccess, httpClient, s3Client, 100)
+	gcsHTTPClient := mock.NewMockHTTPClient(ctrl)
+	blobAccess := blobstore.NewReferenceExpandingBlobAccess(baseBlobAccess, httpClient, s3Client, gcsHTTPClient, 100)
 
 	t.Run("Failure", func(t *testing.T) {
 		// It is not possible to write objects using
@@ -310,7 +379,8 @@ func TestReferenceExpandingBlobAccessFindMissing(t *testing.T) {
 	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
 	httpClient := mock.NewMockHTTPClient(ctrl)
 	s3Client := mock.NewMockS3(ctrl)
-	blobAccess := blobstore.NewReferenceExpandingBlobAccess(baseBlobAccess, httpClient, s3Client, 100)
+	gcsHTTPClient := mock.NewMockHTTPClient(ctrl)
+	blobAccess := blobstore.NewReferenceExpandingBlobAccess(baseBlobAccess, httpClient, s3Client, gcsHTTPClient, 100)
 
 	digests := digest.NewSetBuilder().
 		Add(digest.MustNewDigest("instance", "8b1a9953c4611296a827abf8c47804d7", 5)).
@@ -0,0 +1,299 @@
+package replication
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/filesystem/path"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	asynchronousBlobReplicatorPrometheusMetrics sync.Once
+
+	asynchronousBlobReplicatorEnqueuedDigestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "asynchronous_blob_replicator_enqueued_digests_total",
+			Help:      "Number of digests for which replication was requested, and whether they were added to the queue.",
+		},
+		[]string{"result"})
+	asynchronousBlobReplicatorReplicatedDigestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "asynchronous_blob_replicator_replicated_digests_total",
+			Help:      "Number of digests taken from the queue, and whether replicating them succeeded.",
+		},
+		[]string{"result"})
+	asynchronousBlobReplicatorQueueLength = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "asynchronous_blob_replicator_queue_length",
+			Help:      "Number of digests that are queued for replication, or are in the process of being replicated.",
+		})
+	asynchronousBlobReplicatorQueueAgeSeconds = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blobstore",
+			Name:      "asynchronous_blob_replicator_queue_age_seconds",
+			Help:      "Amount of time digests spent in the queue prior to being replicated, in seconds.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4.0, 14),
+		})
+
+	asynchronousBlobReplicatorEnqueuedDigestsQueued        = asynchronousBlobReplicatorEnqueuedDigestsTotal.WithLabelValues("Queued")
+	asynchronousBlobReplicatorEnqueuedDigestsAlreadyQueued = asynchronousBlobReplicatorEnqueuedDigestsTotal.WithLabelValues("AlreadyQueued")
+	asynchronousBlobReplicatorEnqueuedDigestsDropped       = asynchronousBlobReplicatorEnqueuedDigestsTotal.WithLabelValues("Dropped")
+
+	asynchronousBlobReplicatorReplicatedDigestsSucceeded = asynchronousBlobReplicatorReplicatedDigestsTotal.WithLabelValues("Succeeded")
+	asynchronousBlobReplicatorReplicatedDigestsFailed    = asynchronousBlobReplicatorReplicatedDigestsTotal.WithLabelValues("Failed")
+)
+
+// AsynchronousBlobReplicator is a BlobReplicator that does not
+// replicate objects immediately. Instead, objects are placed in a
+// queue. The queue needs to be processed by calling ProcessSingle()
+// repeatedly.
+type AsynchronousBlobReplicator interface {
+	BlobReplicator
+
+	// ProcessSingle takes a single object from the queue and
+	// replicates it. It blocks until the queue is non-empty.
+	ProcessSingle(ctx context.Context) error
+}
+
+// queuedDigest is an entry in the queue of AsynchronousBlobReplicator.
+type queuedDigest struct {
+	digest        digest.Digest
+	insertionTime time.Time
+}
+
+type asynchronousBlobReplicator struct {
+	source         blobstore.BlobAccess
+	base           BlobReplicator
+	clock          clock.Clock
+	stateDirectory filesystem.Directory
+	queueSize      int
+
+	lock    sync.Mutex
+	pending map[digest.Digest]struct{}
+	queue   chan queuedDigest
+}
+
+// NewAsynchronousBlobReplicator creates a decorator for BlobReplicator
+// that performs replication in the background. Calls to
+// ReplicateSingle() are served from the source directly, while calls
+// to ReplicateMultiple() return immediately. This prevents replication
+// from adding latency to the read path of MirroredBlobAccess.
+//
+// The queue is bounded in size. Requests that are made while the queue
+// is full are discarded, as they will be made again the next time the
+// object is accessed. Every queued object is also journaled as a file
+// in a state directory, containing the time at which it was queued.
+// This causes the queue to be reloaded when the process restarts.
+func NewAsynchronousBlobReplicator(source blobstore.BlobAccess, base BlobReplicator, clock clock.Clock, stateDirectory filesystem.Directory, queueSize int) (AsynchronousBlobReplicator, error) {
+	asynchronousBlobReplicatorPrometheusMetrics.Do(func() {
+		prometheus.MustRegister(asynchronousBlobReplicatorEnqueuedDigestsTotal)
+		prometheus.MustRegister(asynchronousBlobReplicatorReplicatedDigestsTotal)
+		prometheus.MustRegister(asynchronousBlobReplicatorQueueLength)
+		prometheus.MustRegister(asynchronousBlobReplicatorQueueAgeSeconds)
+	})
+
+	br := &asynchronousBlobReplicator{
+		source:         source,
+		base:           base,
+		clock:          clock,
+		stateDirectory: stateDirectory,
+		queueSize:      queueSize,
+		pending:        map[digest.Digest]struct{}{},
+		queue:          make(chan queuedDigest, queueSize),
+	}
+
+	// Reload objects that were queued prior to restarting.
+	entries, err := stateDirectory.ReadDir()
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to read state directory")
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		blobDigest, err := newDigestFromStateFileName(name.String())
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Invalid state file %#v", name.String())
+		}
+		if len(br.pending) >= queueSize {
+			if err := stateDirectory.Remove(name); err != nil {
+				return nil, util.StatusWrapfWithCode(err, codes.Internal, "Failed to remove state file %#v", name.String())
+			}
+			asynchronousBlobReplicatorEnqueuedDigestsDropped.Inc()
+			continue
+		}
+		insertionTime, err := br.readInsertionTime(name)
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Failed to read state file %#v", name.String())
+		}
+		br.pending[blobDigest] = struct{}{}
+		br.queue <- queuedDigest{
+			digest:        blobDigest,
+			insertionTime: insertionTime,
+		}
+		asynchronousBlobReplicatorQueueLength.Inc()
+	}
+	return br, nil
+}
+
+// readInsertionTime reads the time at which an object was queued from
+// its state file. If the state file is incomplete (e.g., due to a crash
+// while writing it), the current time is used.
+func (br *asynchronousBlobReplicator) readInsertionTime(name path.Component) (time.Time, error) {
+	f, err := br.stateDirectory.OpenRead(name)
+	if err != nil {
+		return time.Time{}, util.StatusWrapWithCode(err, codes.Internal, "Failed to open file")
+	}
+	defer f.Close()
+
+	var timestamp [8]byte
+	if n, err := f.ReadAt(timestamp[:], 0); n != len(timestamp) {
+		if err != io.EOF {
+			return time.Time{}, util.StatusWrapWithCode(err, codes.Internal, "Failed to read timestamp")
+		}
+		return br.clock.Now(), nil
+	}
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(timestamp[:]))), nil
+}
+
+// getStateFileName returns the name of the file in the state directory
+// that corresponds to a queued object. The instance name is hex
+// encoded, as it may contain slashes.
+func getStateFileName(blobDigest digest.Digest) path.Component {
+	return path.MustNewComponent(fmt.Sprintf(
+		"%s-%d-%s",
+		blobDigest.GetHashString(),
+		blobDigest.GetSizeBytes(),
+		hex.EncodeToString([]byte(blobDigest.GetInstanceName().String()))))
+}
+
+func newDigestFromStateFileName(name string) (digest.Digest, error) {
+	fields := strings.Split(name, "-")
+	if len(fields) != 3 {
+		return digest.BadDigest, status.Error(codes.InvalidArgument, "File name does not consist of a hash, size and instance name")
+	}
+	sizeBytes, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Invalid blob size %#v", fields[1])
+	}
+	instanceNameBytes, err := hex.DecodeString(fields[2])
+	if err != nil {
+		return digest.BadDigest, status.Errorf(codes.InvalidArgument, "Invalid instance name %#v", fields[2])
+	}
+	instanceName, err := digest.NewInstanceName(string(instanceNameBytes))
+	if err != nil {
+		return digest.BadDigest, err
+	}
+	return instanceName.NewDigest(fields[0], sizeBytes)
+}
+
+func (br *asynchronousBlobReplicator) enqueue(digests digest.Set) error {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+
+	for _, blobDigest := range digests.Items() {
+		if _, ok := br.pending[blobDigest]; ok {
+			asynchronousBlobReplicatorEnqueuedDigestsAlreadyQueued.Inc()
+			continue
+		}
+		if len(br.pending) >= br.queueSize {
+			asynchronousBlobReplicatorEnqueuedDigestsDropped.Inc()
+			continue
+		}
+
+		insertionTime := br.clock.Now()
+		f, err := br.stateDirectory.OpenWrite(getStateFileName(blobDigest), filesystem.CreateReuse(0o666))
+		if err != nil {
+			return util.StatusWrapfWithCode(err, codes.Internal, "Failed to create state file for blob %#v", blobDigest.String())
+		}
+		var timestamp [8]byte
+		binary.LittleEndian.PutUint64(timestamp[:], uint64(insertionTime.UnixNano()))
+		if _, err := f.WriteAt(timestamp[:], 0); err != nil {
+			f.Close()
+			return util.StatusWrapfWithCode(err, codes.Internal, "Failed to write state file for blob %#v", blobDigest.String())
+		}
+		if err := f.Close(); err != nil {
+			return util.StatusWrapfWithCode(err, codes.Internal, "Failed to close state file for blob %#v", blobDigest.String())
+		}
+
+		// The number of pending objects is bounded by the size
+		// of the channel, meaning this cannot block.
+		br.pending[blobDigest] = struct{}{}
+		br.queue <- queuedDigest{
+			digest:        blobDigest,
+			insertionTime: insertionTime,
+		}
+		asynchronousBlobReplicatorEnqueuedDigestsQueued.Inc()
+		asynchronousBlobReplicatorQueueLength.Inc()
+	}
+	return nil
+}
+
+func (br *asynchronousBlobReplicator) ReplicateSingle(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	if err := br.enqueue(blobDigest.ToSingletonSet()); err != nil {
+		// Failing to queue the replication should not cause
+		// the read to fail.
+		util.DefaultErrorLogger.Log(util.StatusWrap(err, "Failed to queue replication"))
+	}
+	return br.source.Get(ctx, blobDigest)
+}
+
+func (br *asynchronousBlobReplicator) ReplicateMultiple(ctx context.Context, digests digest.Set) error {
+	return br.enqueue(digests)
+}
+
+func (br *asynchronousBlobReplicator) ProcessSingle(ctx context.Context) error {
+	var entry queuedDigest
+	select {
+	case entry = <-br.queue:
+	case <-ctx.Done():
+		return util.StatusFromContext(ctx)
+	}
+	blobDigest := entry.digest
+	asynchronousBlobReplicatorQueueAgeSeconds.Observe(br.clock.Now().Sub(entry.insertionTime).Seconds())
+
+	replicationErr := br.base.ReplicateMultiple(ctx, blobDigest.ToSingletonSet())
+	if replicationErr == nil {
+		asynchronousBlobReplicatorReplicatedDigestsSucceeded.Inc()
+	} else {
+		// Don't requeue the object. Replication is requested
+		// once more when the object is accessed again.
+		asynchronousBlobReplicatorReplicatedDigestsFailed.Inc()
+		replicationErr = util.StatusWrapf(replicationErr, "Failed to replicate blob %#v", blobDigest.String())
+	}
+
+	br.lock.Lock()
+	delete(br.pending, blobDigest)
+	removalErr := br.stateDirectory.Remove(getStateFileName(blobDigest))
+	br.lock.Unlock()
+	asynchronousBlobReplicatorQueueLength.Dec()
+	if replicationErr != nil {
+		return replicationErr
+	}
+	if removalErr != nil {
+		return util.StatusWrapfWithCode(removalErr, codes.Internal, "Failed to remove state file for blob %#v", blobDigest.String())
+	}
+	return nil
+}
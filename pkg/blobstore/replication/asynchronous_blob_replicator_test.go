@@ -0,0 +1,198 @@
+package replication_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/filesystem"
+	"github.com/buildbarn/bb-storage/pkg/filesystem/path"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAsynchronousBlobReplicatorReload(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	source := mock.NewMockBlobAccess(ctrl)
+	baseReplicator := mock.NewMockBlobReplicator(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	stateDirectory := mock.NewMockDirectory(ctrl)
+
+	t.Run("ReadDirFailure", func(t *testing.T) {
+		stateDirectory.EXPECT().ReadDir().Return(nil, status.Error(codes.Internal, "Disk on fire"))
+
+		_, err := replication.NewAsynchronousBlobReplicator(source, baseReplicator, clock, stateDirectory, 2)
+		testutil.RequireEqualStatus(t, status.Error(codes.Internal, "Failed to read state directory: Disk on fire"), err)
+	})
+
+	t.Run("InvalidFileName", func(t *testing.T) {
+		stateDirectory.EXPECT().ReadDir().Return([]filesystem.FileInfo{
+			filesystem.NewFileInfo(path.MustNewComponent("hello.txt"), filesystem.FileTypeRegularFile),
+		}, nil)
+
+		_, err := replication.NewAsynchronousBlobReplicator(source, baseReplicator, clock, stateDirectory, 2)
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Invalid state file \"hello.txt\": File name does not consist of a hash, size and instance name"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Entries exceeding the maximum queue size should be
+		// discarded.
+		stateDirectory.EXPECT().ReadDir().Return([]filesystem.FileInfo{
+			filesystem.NewFileInfo(path.MustNewComponent("8b1a9953c4611296a827abf8c47804d7-5-68656c6c6f"), filesystem.FileTypeRegularFile),
+			filesystem.NewFileInfo(path.MustNewComponent("6fc422233a40a75a1f028e11c3cd1140-7-"), filesystem.FileTypeRegularFile),
+			filesystem.NewFileInfo(path.MustNewComponent("3e25960a79dbc69b674cd4ec67a72c62-11-"), filesystem.FileTypeRegularFile),
+		}, nil)
+
+		// The first entry contains the time at which it was
+		// queued.
+		file1 := mock.NewMockFileReader(ctrl)
+		stateDirectory.EXPECT().OpenRead(path.MustNewComponent("8b1a9953c4611296a827abf8c47804d7-5-68656c6c6f")).Return(file1, nil)
+		file1.EXPECT().ReadAt(gomock.Len(8), int64(0)).DoAndReturn(func(p []byte, off int64) (int, error) {
+			return copy(p, []byte{0x00, 0x10, 0xa5, 0xd4, 0xe8, 0x00, 0x00, 0x00}), nil
+		})
+		file1.EXPECT().Close()
+
+		// The second entry is empty, meaning the current time
+		// is used.
+		file2 := mock.NewMockFileReader(ctrl)
+		stateDirectory.EXPECT().OpenRead(path.MustNewComponent("6fc422233a40a75a1f028e11c3cd1140-7-")).Return(file2, nil)
+		file2.EXPECT().ReadAt(gomock.Len(8), int64(0)).Return(0, io.EOF)
+		file2.EXPECT().Close()
+		clock.EXPECT().Now().Return(time.Unix(1100, 0))
+
+		stateDirectory.EXPECT().Remove(path.MustNewComponent("3e25960a79dbc69b674cd4ec67a72c62-11-"))
+
+		replicator, err := replication.NewAsynchronousBlobReplicator(source, baseReplicator, clock, stateDirectory, 2)
+		require.NoError(t, err)
+
+		// Reloaded entries should be processed in order.
+		clock.EXPECT().Now().Return(time.Unix(1200, 0))
+		baseReplicator.EXPECT().ReplicateMultiple(ctx, digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5).ToSingletonSet())
+		stateDirectory.EXPECT().Remove(path.MustNewComponent("8b1a9953c4611296a827abf8c47804d7-5-68656c6c6f"))
+		require.NoError(t, replicator.ProcessSingle(ctx))
+
+		clock.EXPECT().Now().Return(time.Unix(1200, 0))
+		baseReplicator.EXPECT().ReplicateMultiple(ctx, digest.MustNewDigest("", "6fc422233a40a75a1f028e11c3cd1140", 7).ToSingletonSet())
+		stateDirectory.EXPECT().Remove(path.MustNewComponent("6fc422233a40a75a1f028e11c3cd1140-7-"))
+		require.NoError(t, replicator.ProcessSingle(ctx))
+	})
+}
+
+func TestAsynchronousBlobReplicatorReplicateSingle(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	source := mock.NewMockBlobAccess(ctrl)
+	baseReplicator := mock.NewMockBlobReplicator(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	stateDirectory := mock.NewMockDirectory(ctrl)
+	stateDirectory.EXPECT().ReadDir()
+	replicator, err := replication.NewAsynchronousBlobReplicator(source, baseReplicator, clock, stateDirectory, 1)
+	require.NoError(t, err)
+
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("StateFileCreationFailure", func(t *testing.T) {
+		// Failing to queue the object should not prevent it
+		// from being read from the source.
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		stateDirectory.EXPECT().OpenWrite(path.MustNewComponent("8b1a9953c4611296a827abf8c47804d7-5-68656c6c6f"), filesystem.CreateReuse(0o666)).
+			Return(nil, status.Error(codes.Internal, "Disk on fire"))
+		source.EXPECT().Get(ctx, helloDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := replicator.ReplicateSingle(ctx, helloDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// The object should be read from the source, while
+		// only queueing the replication. The time at which the
+		// object was queued should be journaled.
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		file := mock.NewMockFileReadWriter(ctrl)
+		stateDirectory.EXPECT().OpenWrite(path.MustNewComponent("8b1a9953c4611296a827abf8c47804d7-5-68656c6c6f"), filesystem.CreateReuse(0o666)).
+			Return(file, nil)
+		file.EXPECT().WriteAt([]byte{0x00, 0x10, 0xa5, 0xd4, 0xe8, 0x00, 0x00, 0x00}, int64(0)).Return(8, nil)
+		file.EXPECT().Close()
+		source.EXPECT().Get(ctx, helloDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := replicator.ReplicateSingle(ctx, helloDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+
+		// Requesting the same object again should not cause it
+		// to be queued twice.
+		source.EXPECT().Get(ctx, helloDigest).Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err = replicator.ReplicateSingle(ctx, helloDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+
+		// Failures to replicate should be propagated by the
+		// worker, while still removing the object from the
+		// queue.
+		clock.EXPECT().Now().Return(time.Unix(1005, 0))
+		baseReplicator.EXPECT().ReplicateMultiple(ctx, helloDigest.ToSingletonSet()).
+			Return(status.Error(codes.Unavailable, "Server offline"))
+		stateDirectory.EXPECT().Remove(path.MustNewComponent("8b1a9953c4611296a827abf8c47804d7-5-68656c6c6f"))
+
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.Unavailable, "Failed to replicate blob \"8b1a9953c4611296a827abf8c47804d7-5-hello\": Server offline"),
+			replicator.ProcessSingle(ctx))
+	})
+}
+
+func TestAsynchronousBlobReplicatorReplicateMultiple(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	source := mock.NewMockBlobAccess(ctrl)
+	baseReplicator := mock.NewMockBlobReplicator(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	stateDirectory := mock.NewMockDirectory(ctrl)
+	stateDirectory.EXPECT().ReadDir()
+	replicator, err := replication.NewAsynchronousBlobReplicator(source, baseReplicator, clock, stateDirectory, 1)
+	require.NoError(t, err)
+
+	digest1 := digest.MustNewDigest("", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digest2 := digest.MustNewDigest("", "6fc422233a40a75a1f028e11c3cd1140", 7)
+
+	t.Run("QueueFull", func(t *testing.T) {
+		// Only a single object fits in the queue. The other
+		// object should be discarded.
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		file := mock.NewMockFileReadWriter(ctrl)
+		stateDirectory.EXPECT().OpenWrite(gomock.Any(), filesystem.CreateReuse(0o666)).Return(file, nil)
+		file.EXPECT().WriteAt(gomock.Len(8), int64(0)).Return(8, nil)
+		file.EXPECT().Close()
+
+		require.NoError(t, replicator.ReplicateMultiple(ctx, digest.NewSetBuilder().Add(digest1).Add(digest2).Build()))
+
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		baseReplicator.EXPECT().ReplicateMultiple(ctx, gomock.Any())
+		stateDirectory.EXPECT().Remove(gomock.Any())
+		require.NoError(t, replicator.ProcessSingle(ctx))
+	})
+
+	t.Run("ContextCanceled", func(t *testing.T) {
+		// Processing the queue should block until it becomes
+		// non-empty.
+		ctxCanceled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.Canceled, "context canceled"),
+			replicator.ProcessSingle(ctxCanceled))
+	})
+}
@@ -0,0 +1,157 @@
+package replication
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// ReplicatorStatus contains the state of a replicator, as reported by
+// IntrospectableBlobReplicator.
+type ReplicatorStatus struct {
+	// The number of objects whose replication has been requested,
+	// but has not completed yet. This includes objects for which
+	// replication is blocked due to the replicator being paused.
+	BacklogDigests int `json:"backlog_digests"`
+	// The total size of the objects in the backlog that are being
+	// replicated, in bytes.
+	BytesInFlight int64 `json:"bytes_in_flight"`
+	// The number of replication requests that failed.
+	ErrorsTotal uint64 `json:"errors_total"`
+	// Whether the replicator has been paused.
+	Paused bool `json:"paused"`
+}
+
+// IntrospectableBlobReplicator is a BlobReplicator whose progress can be
+// inspected, and which can be paused and resumed. This can be used to
+// temporarily suspend replication while performing maintenance on
+// storage backends.
+type IntrospectableBlobReplicator interface {
+	BlobReplicator
+
+	GetStatus() ReplicatorStatus
+	SetPaused(paused bool)
+}
+
+type introspectableBlobReplicator struct {
+	base BlobReplicator
+
+	lock           sync.Mutex
+	backlogDigests int
+	bytesInFlight  int64
+	errorsTotal    uint64
+	resumed        chan struct{}
+}
+
+// NewIntrospectableBlobReplicator creates a decorator for
+// BlobReplicator that keeps track of the number of objects that are
+// being replicated. It also permits replication to be paused. While
+// paused, requests are not forwarded to the base replicator, but block
+// until replication is resumed.
+func NewIntrospectableBlobReplicator(base BlobReplicator) IntrospectableBlobReplicator {
+	return &introspectableBlobReplicator{
+		base: base,
+	}
+}
+
+func (br *introspectableBlobReplicator) GetStatus() ReplicatorStatus {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	return ReplicatorStatus{
+		BacklogDigests: br.backlogDigests,
+		BytesInFlight:  br.bytesInFlight,
+		ErrorsTotal:    br.errorsTotal,
+		Paused:         br.resumed != nil,
+	}
+}
+
+func (br *introspectableBlobReplicator) SetPaused(paused bool) {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	if paused {
+		if br.resumed == nil {
+			br.resumed = make(chan struct{})
+		}
+	} else if br.resumed != nil {
+		close(br.resumed)
+		br.resumed = nil
+	}
+}
+
+// start registers objects as being part of the backlog, and waits for
+// replication to be resumed if paused.
+func (br *introspectableBlobReplicator) start(ctx context.Context, digests digest.Set) (int64, error) {
+	sizeBytes := int64(0)
+	for _, blobDigest := range digests.Items() {
+		sizeBytes += blobDigest.GetSizeBytes()
+	}
+
+	br.lock.Lock()
+	br.backlogDigests += digests.Length()
+	for br.resumed != nil {
+		resumed := br.resumed
+		br.lock.Unlock()
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			br.finish(digests.Length(), 0, util.StatusFromContext(ctx))
+			return 0, util.StatusFromContext(ctx)
+		}
+		br.lock.Lock()
+	}
+	br.bytesInFlight += sizeBytes
+	br.lock.Unlock()
+	return sizeBytes, nil
+}
+
+// finish removes objects from the backlog.
+func (br *introspectableBlobReplicator) finish(digestsCount int, sizeBytes int64, err error) {
+	br.lock.Lock()
+	br.backlogDigests -= digestsCount
+	br.bytesInFlight -= sizeBytes
+	if err != nil {
+		br.errorsTotal++
+	}
+	br.lock.Unlock()
+}
+
+func (br *introspectableBlobReplicator) ReplicateSingle(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	sizeBytes, err := br.start(ctx, blobDigest.ToSingletonSet())
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.WithErrorHandler(
+		br.base.ReplicateSingle(ctx, blobDigest),
+		&introspectableErrorHandler{
+			blobReplicator: br,
+			sizeBytes:      sizeBytes,
+		})
+}
+
+func (br *introspectableBlobReplicator) ReplicateMultiple(ctx context.Context, digests digest.Set) error {
+	sizeBytes, err := br.start(ctx, digests)
+	if err != nil {
+		return err
+	}
+	err = br.base.ReplicateMultiple(ctx, digests)
+	br.finish(digests.Length(), sizeBytes, err)
+	return err
+}
+
+type introspectableErrorHandler struct {
+	blobReplicator *introspectableBlobReplicator
+	sizeBytes      int64
+	err            error
+}
+
+func (eh *introspectableErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	eh.err = err
+	return nil, err
+}
+
+func (eh *introspectableErrorHandler) Done() {
+	eh.blobReplicator.finish(1, eh.sizeBytes, eh.err)
+}
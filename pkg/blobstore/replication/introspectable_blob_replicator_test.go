@@ -0,0 +1,125 @@
+package replication_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"runtime"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIntrospectableBlobReplicator(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseReplicator := mock.NewMockBlobReplicator(ctrl)
+	replicator := replication.NewIntrospectableBlobReplicator(baseReplicator)
+
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digests := digest.NewSetBuilder().
+		Add(helloDigest).
+		Add(digest.MustNewDigest("hello", "6fc422233a40a75a1f028e11c3cd1140", 7)).
+		Build()
+
+	t.Run("ReplicateMultipleSuccess", func(t *testing.T) {
+		// While replication is in progress, the objects should
+		// be reported as being part of the backlog.
+		baseReplicator.EXPECT().ReplicateMultiple(ctx, digests).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) error {
+				require.Equal(t, replication.ReplicatorStatus{
+					BacklogDigests: 2,
+					BytesInFlight:  12,
+				}, replicator.GetStatus())
+				return nil
+			})
+
+		require.NoError(t, replicator.ReplicateMultiple(ctx, digests))
+		require.Equal(t, replication.ReplicatorStatus{}, replicator.GetStatus())
+	})
+
+	t.Run("ReplicateMultipleFailure", func(t *testing.T) {
+		baseReplicator.EXPECT().ReplicateMultiple(ctx, digests).
+			Return(status.Error(codes.Internal, "Server on fire"))
+
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.Internal, "Server on fire"),
+			replicator.ReplicateMultiple(ctx, digests))
+		require.Equal(t, replication.ReplicatorStatus{
+			ErrorsTotal: 1,
+		}, replicator.GetStatus())
+	})
+
+	t.Run("ReplicateSingle", func(t *testing.T) {
+		// Objects should remain in flight until the buffer
+		// returned by ReplicateSingle() has been consumed.
+		baseReplicator.EXPECT().ReplicateSingle(ctx, helloDigest).
+			Return(buffer.NewCASBufferFromReader(
+				helloDigest,
+				ioutil.NopCloser(bytes.NewBufferString("Hello")),
+				buffer.BackendProvided(buffer.Irreparable(helloDigest))))
+
+		b := replicator.ReplicateSingle(ctx, helloDigest)
+		require.Equal(t, replication.ReplicatorStatus{
+			BacklogDigests: 1,
+			BytesInFlight:  5,
+			ErrorsTotal:    1,
+		}, replicator.GetStatus())
+
+		data, err := b.ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+		require.Equal(t, replication.ReplicatorStatus{
+			ErrorsTotal: 1,
+		}, replicator.GetStatus())
+	})
+
+	t.Run("Paused", func(t *testing.T) {
+		// While paused, requests should not be forwarded, but
+		// still be reported as part of the backlog.
+		replicator.SetPaused(true)
+
+		ctxCanceled, cancel := context.WithCancel(ctx)
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- replicator.ReplicateMultiple(ctxCanceled, digests)
+		}()
+		for replicator.GetStatus().BacklogDigests != 2 {
+			runtime.Gosched()
+		}
+		require.Equal(t, replication.ReplicatorStatus{
+			BacklogDigests: 2,
+			ErrorsTotal:    1,
+			Paused:         true,
+		}, replicator.GetStatus())
+
+		// Canceling the request should cause it to fail.
+		cancel()
+		testutil.RequireEqualStatus(t, status.Error(codes.Canceled, "context canceled"), <-errChan)
+
+		// Resuming replication should cause blocked requests to
+		// be forwarded.
+		go func() {
+			errChan <- replicator.ReplicateMultiple(ctx, digests)
+		}()
+		for replicator.GetStatus().BacklogDigests != 2 {
+			runtime.Gosched()
+		}
+		baseReplicator.EXPECT().ReplicateMultiple(ctx, digests)
+		replicator.SetPaused(false)
+		require.NoError(t, <-errChan)
+		require.Equal(t, replication.ReplicatorStatus{
+			ErrorsTotal: 2,
+		}, replicator.GetStatus())
+	})
+}
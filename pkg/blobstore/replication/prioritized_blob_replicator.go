@@ -0,0 +1,185 @@
+package replication
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// prioritizedWaiter is a request that is waiting for
+// PrioritizedBlobReplicator to let it be forwarded.
+type prioritizedWaiter struct {
+	sizeBytes int64
+	sequence  uint64
+	index     int
+	wakeup    chan struct{}
+}
+
+// prioritizedWaiterHeap is a binary heap of requests, ordered by size.
+// Requests of the same size are processed in FIFO order.
+type prioritizedWaiterHeap []*prioritizedWaiter
+
+func (h prioritizedWaiterHeap) Len() int {
+	return len(h)
+}
+
+func (h prioritizedWaiterHeap) Less(i, j int) bool {
+	if h[i].sizeBytes != h[j].sizeBytes {
+		return h[i].sizeBytes < h[j].sizeBytes
+	}
+	return h[i].sequence < h[j].sequence
+}
+
+func (h prioritizedWaiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *prioritizedWaiterHeap) Push(x interface{}) {
+	w := x.(*prioritizedWaiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *prioritizedWaiterHeap) Pop() interface{} {
+	old := *h
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	w.index = -1
+	return w
+}
+
+// PrioritizedBlobReplicator is a BlobReplicator that limits the
+// number of requests that are forwarded concurrently. The limit may be
+// changed at runtime.
+type PrioritizedBlobReplicator interface {
+	BlobReplicator
+
+	// SetMaximumConcurrency changes the maximum number of requests
+	// that are forwarded concurrently. When the limit is lowered,
+	// requests that are in flight are permitted to complete.
+	SetMaximumConcurrency(maximumConcurrency int)
+}
+
+type prioritizedBlobReplicator struct {
+	base BlobReplicator
+
+	lock               sync.Mutex
+	maximumConcurrency int
+	available          int
+	nextSequence       uint64
+	waiters            prioritizedWaiterHeap
+}
+
+// NewPrioritizedBlobReplicator creates a decorator for BlobReplicator
+// that limits the number of requests that are forwarded concurrently.
+// When the limit is reached, the request with the smallest total size
+// of objects is forwarded first. This prevents the replication of
+// small objects (e.g., Action Cache entries and Action messages) from
+// being held up by the replication of large objects.
+func NewPrioritizedBlobReplicator(base BlobReplicator, maximumConcurrency int) PrioritizedBlobReplicator {
+	return &prioritizedBlobReplicator{
+		base:               base,
+		maximumConcurrency: maximumConcurrency,
+		available:          maximumConcurrency,
+	}
+}
+
+func (br *prioritizedBlobReplicator) SetMaximumConcurrency(maximumConcurrency int) {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+
+	// The number of available slots may become negative if the
+	// limit is lowered while requests are in flight.
+	br.available += maximumConcurrency - br.maximumConcurrency
+	br.maximumConcurrency = maximumConcurrency
+	for br.available > 0 && len(br.waiters) > 0 {
+		close(heap.Pop(&br.waiters).(*prioritizedWaiter).wakeup)
+		br.available--
+	}
+}
+
+func (br *prioritizedBlobReplicator) acquire(ctx context.Context, sizeBytes int64) error {
+	br.lock.Lock()
+	if br.available > 0 && len(br.waiters) == 0 {
+		br.available--
+		br.lock.Unlock()
+		return nil
+	}
+	w := &prioritizedWaiter{
+		sizeBytes: sizeBytes,
+		sequence:  br.nextSequence,
+		wakeup:    make(chan struct{}),
+	}
+	br.nextSequence++
+	heap.Push(&br.waiters, w)
+	br.lock.Unlock()
+
+	select {
+	case <-w.wakeup:
+		return nil
+	case <-ctx.Done():
+		br.lock.Lock()
+		if w.index >= 0 {
+			heap.Remove(&br.waiters, w.index)
+			br.lock.Unlock()
+		} else {
+			// The request was woken up concurrently. Hand
+			// the slot to the next request.
+			br.lock.Unlock()
+			br.release()
+		}
+		return util.StatusFromContext(ctx)
+	}
+}
+
+func (br *prioritizedBlobReplicator) release() {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	if br.available >= 0 && len(br.waiters) > 0 {
+		close(heap.Pop(&br.waiters).(*prioritizedWaiter).wakeup)
+	} else {
+		br.available++
+	}
+}
+
+func (br *prioritizedBlobReplicator) ReplicateSingle(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	if err := br.acquire(ctx, blobDigest.GetSizeBytes()); err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.WithErrorHandler(
+		br.base.ReplicateSingle(ctx, blobDigest),
+		prioritizedErrorHandler{blobReplicator: br})
+}
+
+func (br *prioritizedBlobReplicator) ReplicateMultiple(ctx context.Context, digests digest.Set) error {
+	sizeBytes := int64(0)
+	for _, blobDigest := range digests.Items() {
+		sizeBytes += blobDigest.GetSizeBytes()
+	}
+	if err := br.acquire(ctx, sizeBytes); err != nil {
+		return err
+	}
+	defer br.release()
+	return br.base.ReplicateMultiple(ctx, digests)
+}
+
+// prioritizedErrorHandler releases the slot that was acquired by
+// ReplicateSingle() once the caller is done reading the buffer.
+type prioritizedErrorHandler struct {
+	blobReplicator *prioritizedBlobReplicator
+}
+
+func (eh prioritizedErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	return nil, err
+}
+
+func (eh prioritizedErrorHandler) Done() {
+	eh.blobReplicator.release()
+}
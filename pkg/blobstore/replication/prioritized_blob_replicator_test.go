@@ -0,0 +1,160 @@
+package replication_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// waitingContext is a Context that reports when Done() is called for
+// the first time. PrioritizedBlobReplicator only does this when a
+// request needs to wait, which allows the test to determine when
+// requests have been queued.
+type waitingContext struct {
+	context.Context
+	waiting chan struct{}
+	once    sync.Once
+}
+
+func newWaitingContext(ctx context.Context) *waitingContext {
+	return &waitingContext{
+		Context: ctx,
+		waiting: make(chan struct{}),
+	}
+}
+
+func (ctx *waitingContext) Done() <-chan struct{} {
+	ctx.once.Do(func() { close(ctx.waiting) })
+	return ctx.Context.Done()
+}
+
+func TestPrioritizedBlobReplicator(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseReplicator := mock.NewMockBlobReplicator(ctrl)
+	replicator := replication.NewPrioritizedBlobReplicator(baseReplicator, 1)
+
+	smallDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	largeDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	// The first request should be forwarded immediately. Keep the
+	// slot occupied by not consuming the buffer that is returned.
+	baseReplicator.EXPECT().ReplicateSingle(ctx, largeDigest).
+		Return(buffer.NewCASBufferFromReader(
+			largeDigest,
+			ioutil.NopCloser(bytes.NewBufferString("Hello world")),
+			buffer.BackendProvided(buffer.Irreparable(largeDigest))))
+	b := replicator.ReplicateSingle(ctx, largeDigest)
+
+	// Queue a large request, followed by a small request.
+	ctxLarge := newWaitingContext(ctx)
+	errLarge := make(chan error, 1)
+	go func() {
+		errLarge <- replicator.ReplicateMultiple(ctxLarge, largeDigest.ToSingletonSet())
+	}()
+	<-ctxLarge.waiting
+
+	ctxSmall := newWaitingContext(ctx)
+	errSmall := make(chan error, 1)
+	go func() {
+		errSmall <- replicator.ReplicateMultiple(ctxSmall, smallDigest.ToSingletonSet())
+	}()
+	<-ctxSmall.waiting
+
+	// A request whose context is canceled while waiting should not
+	// be forwarded.
+	ctxCanceled, cancel := context.WithCancel(ctx)
+	ctxCanceledWaiting := newWaitingContext(ctxCanceled)
+	errCanceled := make(chan error, 1)
+	go func() {
+		errCanceled <- replicator.ReplicateMultiple(ctxCanceledWaiting, smallDigest.ToSingletonSet())
+	}()
+	<-ctxCanceledWaiting.waiting
+	cancel()
+	testutil.RequireEqualStatus(t, status.Error(codes.Canceled, "context canceled"), <-errCanceled)
+
+	// Releasing the slot should cause the small request to be
+	// forwarded first, even though it was queued last.
+	smallDone := make(chan struct{})
+	gomock.InOrder(
+		baseReplicator.EXPECT().ReplicateMultiple(ctxSmall, smallDigest.ToSingletonSet()).DoAndReturn(
+			func(ctx context.Context, digests digest.Set) error {
+				close(smallDone)
+				return nil
+			}),
+		baseReplicator.EXPECT().ReplicateMultiple(ctxLarge, largeDigest.ToSingletonSet()),
+	)
+	b.Discard()
+	<-smallDone
+	require.NoError(t, <-errSmall)
+	require.NoError(t, <-errLarge)
+}
+
+func TestPrioritizedBlobReplicatorSetMaximumConcurrency(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseReplicator := mock.NewMockBlobReplicator(ctrl)
+	replicator := replication.NewPrioritizedBlobReplicator(baseReplicator, 2)
+
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	newBuffer := func() buffer.Buffer {
+		return buffer.NewCASBufferFromReader(
+			helloDigest,
+			ioutil.NopCloser(bytes.NewBufferString("Hello")),
+			buffer.BackendProvided(buffer.Irreparable(helloDigest)))
+	}
+
+	// Occupy both slots by not consuming the buffers that are
+	// returned.
+	baseReplicator.EXPECT().ReplicateSingle(ctx, helloDigest).
+		DoAndReturn(func(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+			return newBuffer()
+		}).
+		Times(2)
+	b1 := replicator.ReplicateSingle(ctx, helloDigest)
+	b2 := replicator.ReplicateSingle(ctx, helloDigest)
+
+	// Lower the limit. Requests that are in flight may complete,
+	// but new requests need to wait until the number of requests
+	// in flight is below the new limit.
+	replicator.SetMaximumConcurrency(1)
+	ctxWaiting := newWaitingContext(ctx)
+	errWaiting := make(chan error, 1)
+	go func() {
+		errWaiting <- replicator.ReplicateMultiple(ctxWaiting, helloDigest.ToSingletonSet())
+	}()
+	<-ctxWaiting.waiting
+	b1.Discard()
+
+	baseReplicator.EXPECT().ReplicateMultiple(ctxWaiting, helloDigest.ToSingletonSet())
+	b2.Discard()
+	require.NoError(t, <-errWaiting)
+
+	// Raising the limit should cause requests that are waiting to
+	// be forwarded immediately.
+	baseReplicator.EXPECT().ReplicateSingle(ctx, helloDigest).Return(newBuffer())
+	b3 := replicator.ReplicateSingle(ctx, helloDigest)
+	ctxWaiting = newWaitingContext(ctx)
+	go func() {
+		errWaiting <- replicator.ReplicateMultiple(ctxWaiting, helloDigest.ToSingletonSet())
+	}()
+	<-ctxWaiting.waiting
+
+	baseReplicator.EXPECT().ReplicateMultiple(ctxWaiting, helloDigest.ToSingletonSet())
+	replicator.SetMaximumConcurrency(2)
+	require.NoError(t, <-errWaiting)
+	b3.Discard()
+}
@@ -0,0 +1,235 @@
+package replication
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+)
+
+// ReplicationWindow is a recurring period of time during which
+// ScheduledBlobReplicator lets replication run at full speed.
+type ReplicationWindow struct {
+	// The days of the week on which the window starts. If empty,
+	// the window starts every day.
+	DaysOfWeek []time.Weekday
+	// The time of day at which the window starts, expressed as the
+	// offset relative to midnight.
+	Start time.Duration
+	// The duration of the window. Windows may span past midnight.
+	Duration time.Duration
+}
+
+func (w *ReplicationWindow) startsOnDay(day time.Weekday) bool {
+	if len(w.DaysOfWeek) == 0 {
+		return true
+	}
+	for _, d := range w.DaysOfWeek {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// getMidnight returns the start of the day that is a given number of
+// days away from the day containing t. time.Date() is used, as opposed
+// to adding multiples of 24 hours, to deal with daylight saving time.
+func getMidnight(t time.Time, days int) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day+days, 0, 0, 0, 0, t.Location())
+}
+
+// isInReplicationWindow returns whether a point in time lies within
+// any of the provided windows.
+func isInReplicationWindow(windows []ReplicationWindow, t time.Time) bool {
+	for i := range windows {
+		w := &windows[i]
+		// Consider windows that started on earlier days, but
+		// may still be running.
+		for days := 0; time.Duration(days)*24*time.Hour <= w.Start+w.Duration; days++ {
+			midnight := getMidnight(t, -days)
+			start := midnight.Add(w.Start)
+			if w.startsOnDay(midnight.Weekday()) && !t.Before(start) && t.Before(start.Add(w.Duration)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getNextReplicationWindowStart returns the first point in time after
+// t at which one of the provided windows starts.
+func getNextReplicationWindowStart(windows []ReplicationWindow, t time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	for i := range windows {
+		w := &windows[i]
+		for days := 0; days <= 7; days++ {
+			midnight := getMidnight(t, days)
+			start := midnight.Add(w.Start)
+			if w.startsOnDay(midnight.Weekday()) && start.After(t) {
+				if !found || start.Before(next) {
+					next = start
+					found = true
+				}
+				break
+			}
+		}
+	}
+	return next, found
+}
+
+// ScheduledBlobReplicator is a BlobReplicator that only lets
+// replication run at full speed during a set of recurring windows of
+// time. The schedule may be changed at runtime.
+type ScheduledBlobReplicator interface {
+	BlobReplicator
+
+	// SetSchedule changes the windows during which replication
+	// runs at full speed and the off-peak concurrency. Requests
+	// that are in flight are not counted against the new off-peak
+	// concurrency.
+	SetSchedule(windows []ReplicationWindow, location *time.Location, offPeakConcurrency int)
+}
+
+// replicationSchedule contains the parameters of
+// ScheduledBlobReplicator that may be changed at runtime.
+type replicationSchedule struct {
+	windows  []ReplicationWindow
+	location *time.Location
+	slots    chan struct{}
+
+	// Closed when the schedule is replaced, so that requests that
+	// are waiting reevaluate the new schedule.
+	replaced chan struct{}
+}
+
+func newReplicationSchedule(windows []ReplicationWindow, location *time.Location, offPeakConcurrency int) *replicationSchedule {
+	s := &replicationSchedule{
+		windows:  windows,
+		location: location,
+		replaced: make(chan struct{}),
+	}
+	if offPeakConcurrency > 0 {
+		s.slots = make(chan struct{}, offPeakConcurrency)
+	}
+	return s
+}
+
+type scheduledBlobReplicator struct {
+	base  BlobReplicator
+	clock clock.Clock
+
+	lock     sync.Mutex
+	schedule *replicationSchedule
+}
+
+// NewScheduledBlobReplicator creates a decorator for BlobReplicator
+// that only lets replication run at full speed during a set of
+// recurring windows of time, such as at night when network traffic
+// between regions is cheaper.
+//
+// Outside of these windows, at most offPeakConcurrency requests are
+// forwarded to the base replicator at the same time. If
+// offPeakConcurrency is zero, replication is paused until the next
+// window starts.
+func NewScheduledBlobReplicator(base BlobReplicator, clock clock.Clock, windows []ReplicationWindow, location *time.Location, offPeakConcurrency int) ScheduledBlobReplicator {
+	return &scheduledBlobReplicator{
+		base:     base,
+		clock:    clock,
+		schedule: newReplicationSchedule(windows, location, offPeakConcurrency),
+	}
+}
+
+func (br *scheduledBlobReplicator) SetSchedule(windows []ReplicationWindow, location *time.Location, offPeakConcurrency int) {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	close(br.schedule.replaced)
+	br.schedule = newReplicationSchedule(windows, location, offPeakConcurrency)
+}
+
+func (br *scheduledBlobReplicator) getSchedule() *replicationSchedule {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	return br.schedule
+}
+
+func noopRelease() {}
+
+// acquire blocks until a request may be forwarded to the base
+// replicator. The function that is returned must be called when the
+// request completes.
+func (br *scheduledBlobReplicator) acquire(ctx context.Context) (func(), error) {
+	for {
+		schedule := br.getSchedule()
+		now := br.clock.Now().In(schedule.location)
+		if isInReplicationWindow(schedule.windows, now) {
+			return noopRelease, nil
+		}
+
+		// Outside of a window. Wait for an off-peak slot to
+		// become available, or for the next window to start.
+		// If replication is paused, schedule.slots is nil,
+		// meaning that sending to it blocks indefinitely.
+		var timer clock.Timer
+		var timerChannel <-chan time.Time
+		if next, ok := getNextReplicationWindowStart(schedule.windows, now); ok {
+			timer, timerChannel = br.clock.NewTimer(next.Sub(now))
+		}
+		select {
+		case schedule.slots <- struct{}{}:
+			if timer != nil {
+				timer.Stop()
+			}
+			return func() { <-schedule.slots }, nil
+		case <-timerChannel:
+		case <-schedule.replaced:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return nil, util.StatusFromContext(ctx)
+		}
+	}
+}
+
+func (br *scheduledBlobReplicator) ReplicateSingle(ctx context.Context, blobDigest digest.Digest) buffer.Buffer {
+	release, err := br.acquire(ctx)
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	return buffer.WithErrorHandler(
+		br.base.ReplicateSingle(ctx, blobDigest),
+		scheduledErrorHandler{release: release})
+}
+
+func (br *scheduledBlobReplicator) ReplicateMultiple(ctx context.Context, digests digest.Set) error {
+	release, err := br.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return br.base.ReplicateMultiple(ctx, digests)
+}
+
+// scheduledErrorHandler releases the off-peak slot that was acquired
+// by ReplicateSingle() once the caller is done reading the buffer.
+type scheduledErrorHandler struct {
+	release func()
+}
+
+func (eh scheduledErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	return nil, err
+}
+
+func (eh scheduledErrorHandler) Done() {
+	eh.release()
+}
@@ -0,0 +1,175 @@
+package replication_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/replication"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Replication runs at full speed on weeknights, from 22:00 to 06:00.
+var weeknightReplicationWindows = []replication.ReplicationWindow{
+	{
+		DaysOfWeek: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		Start:      22 * time.Hour,
+		Duration:   8 * time.Hour,
+	},
+}
+
+func TestScheduledBlobReplicatorOffPeakConcurrency(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseReplicator := mock.NewMockBlobReplicator(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	replicator := replication.NewScheduledBlobReplicator(baseReplicator, clock, weeknightReplicationWindows, time.UTC, 1)
+
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	helloDigests := helloDigest.ToSingletonSet()
+
+	t.Run("InWindow", func(t *testing.T) {
+		// Tuesday 23:00.
+		clock.EXPECT().Now().Return(time.Date(2026, 1, 6, 23, 0, 0, 0, time.UTC))
+		baseReplicator.EXPECT().ReplicateMultiple(ctx, helloDigests)
+
+		require.NoError(t, replicator.ReplicateMultiple(ctx, helloDigests))
+	})
+
+	t.Run("InWindowPastMidnight", func(t *testing.T) {
+		// Wednesday 03:00, which is still part of the window
+		// that started on Tuesday.
+		clock.EXPECT().Now().Return(time.Date(2026, 1, 7, 3, 0, 0, 0, time.UTC))
+		baseReplicator.EXPECT().ReplicateMultiple(ctx, helloDigests)
+
+		require.NoError(t, replicator.ReplicateMultiple(ctx, helloDigests))
+	})
+
+	t.Run("OffPeak", func(t *testing.T) {
+		// Wednesday 12:00. The first request may run, as there
+		// is a free off-peak slot.
+		clock.EXPECT().Now().Return(time.Date(2026, 1, 7, 12, 0, 0, 0, time.UTC))
+		timer1 := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(10*time.Hour).Return(timer1, nil)
+		timer1.EXPECT().Stop()
+		baseReplicator.EXPECT().ReplicateSingle(ctx, helloDigest).Return(
+			buffer.NewCASBufferFromReader(
+				helloDigest,
+				ioutil.NopCloser(bytes.NewBufferString("Hello")),
+				buffer.BackendProvided(buffer.Irreparable(helloDigest))))
+
+		b := replicator.ReplicateSingle(ctx, helloDigest)
+
+		// As long as the first request is in progress, the
+		// second request needs to wait. It may only continue
+		// once the window starts.
+		clock.EXPECT().Now().Return(time.Date(2026, 1, 7, 13, 0, 0, 0, time.UTC))
+		timer2 := mock.NewMockTimer(ctrl)
+		timerChannel2 := make(chan time.Time, 1)
+		clock.EXPECT().NewTimer(9*time.Hour).Return(timer2, timerChannel2)
+		clock.EXPECT().Now().Return(time.Date(2026, 1, 7, 22, 0, 0, 0, time.UTC))
+		baseReplicator.EXPECT().ReplicateMultiple(ctx, helloDigests)
+
+		timerChannel2 <- time.Date(2026, 1, 7, 22, 0, 0, 0, time.UTC)
+		require.NoError(t, replicator.ReplicateMultiple(ctx, helloDigests))
+
+		data, err := b.ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+
+		// Now that the first request has completed, the slot
+		// should be available again.
+		clock.EXPECT().Now().Return(time.Date(2026, 1, 8, 12, 0, 0, 0, time.UTC))
+		timer3 := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(10*time.Hour).Return(timer3, nil)
+		timer3.EXPECT().Stop()
+		baseReplicator.EXPECT().ReplicateMultiple(ctx, helloDigests)
+
+		require.NoError(t, replicator.ReplicateMultiple(ctx, helloDigests))
+	})
+}
+
+func TestScheduledBlobReplicatorPaused(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseReplicator := mock.NewMockBlobReplicator(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	replicator := replication.NewScheduledBlobReplicator(baseReplicator, clock, weeknightReplicationWindows, time.UTC, 0)
+
+	helloDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("ContextCanceled", func(t *testing.T) {
+		// Saturday 12:00. Replication is paused until Monday
+		// 22:00.
+		ctxCanceled, cancel := context.WithCancel(ctx)
+		cancel()
+		clock.EXPECT().Now().Return(time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC))
+		timer := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(58*time.Hour).Return(timer, nil)
+		timer.EXPECT().Stop()
+
+		_, err := replicator.ReplicateSingle(ctxCanceled, helloDigest).ToByteSlice(10)
+		testutil.RequireEqualStatus(t, status.Error(codes.Canceled, "context canceled"), err)
+	})
+
+	t.Run("WindowStarted", func(t *testing.T) {
+		clock.EXPECT().Now().Return(time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC))
+		timer := mock.NewMockTimer(ctrl)
+		timerChannel := make(chan time.Time, 1)
+		timerChannel <- time.Date(2026, 1, 12, 22, 0, 0, 0, time.UTC)
+		clock.EXPECT().NewTimer(58*time.Hour).Return(timer, timerChannel)
+		clock.EXPECT().Now().Return(time.Date(2026, 1, 12, 22, 0, 0, 0, time.UTC))
+		baseReplicator.EXPECT().ReplicateSingle(ctx, helloDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := replicator.ReplicateSingle(ctx, helloDigest).ToByteSlice(10)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+}
+
+func TestScheduledBlobReplicatorSetSchedule(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseReplicator := mock.NewMockBlobReplicator(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	replicator := replication.NewScheduledBlobReplicator(baseReplicator, clock, weeknightReplicationWindows, time.UTC, 0)
+
+	helloDigests := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5).ToSingletonSet()
+
+	// Saturday 12:00. Replication is paused until Monday 22:00.
+	clock.EXPECT().Now().Return(time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC))
+	timer := mock.NewMockTimer(ctrl)
+	waiting := make(chan struct{})
+	clock.EXPECT().NewTimer(58 * time.Hour).DoAndReturn(func(d time.Duration) (*mock.MockTimer, <-chan time.Time) {
+		close(waiting)
+		return timer, nil
+	})
+	errWaiting := make(chan error, 1)
+	go func() {
+		errWaiting <- replicator.ReplicateMultiple(ctx, helloDigests)
+	}()
+	<-waiting
+
+	// Permitting off-peak replication should cause the request to
+	// be forwarded.
+	timer.EXPECT().Stop()
+	clock.EXPECT().Now().Return(time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC))
+	timer2 := mock.NewMockTimer(ctrl)
+	clock.EXPECT().NewTimer(58*time.Hour).Return(timer2, nil)
+	timer2.EXPECT().Stop()
+	baseReplicator.EXPECT().ReplicateMultiple(ctx, helloDigests)
+
+	replicator.SetSchedule(weeknightReplicationWindows, time.UTC, 1)
+	require.NoError(t, <-errWaiting)
+}
@@ -0,0 +1,150 @@
+package blobstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/random"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy describes under which circumstances and how often
+// operations performed by RetryingBlobAccess are retried.
+type RetryPolicy struct {
+	// The maximum number of attempts, including the initial one.
+	MaximumAttempts int
+	// The delay before the first retry. Subsequent delays are
+	// multiplied by BackoffMultiplier, up to MaximumBackoff.
+	InitialBackoff    time.Duration
+	MaximumBackoff    time.Duration
+	BackoffMultiplier float64
+	// Status codes for which operations should be retried.
+	RetryableCodes map[codes.Code]struct{}
+}
+
+type retryingBlobAccess struct {
+	base                  BlobAccess
+	clock                 clock.Clock
+	randomNumberGenerator random.ThreadSafeGenerator
+	policy                RetryPolicy
+	maximumPutSizeBytes   int
+}
+
+// NewRetryingBlobAccess creates a decorator for BlobAccess that retries
+// operations that fail with one of the status codes provided in the
+// retry policy. Between attempts, it waits according to an exponential
+// backoff. Delays are randomized ("full jitter"), so that clients that
+// failed simultaneously don't retry in lockstep.
+//
+// Get() operations are only retried if the error occurs before any
+// data has been returned to the caller. Put() operations are only
+// retried for objects up to maximumPutSizeBytes in size, as these need
+// to be held in memory to be able to transmit them multiple times.
+func NewRetryingBlobAccess(base BlobAccess, clock clock.Clock, randomNumberGenerator random.ThreadSafeGenerator, policy RetryPolicy, maximumPutSizeBytes int) BlobAccess {
+	return &retryingBlobAccess{
+		base:                  base,
+		clock:                 clock,
+		randomNumberGenerator: randomNumberGenerator,
+		policy:                policy,
+		maximumPutSizeBytes:   maximumPutSizeBytes,
+	}
+}
+
+// shouldRetry returns whether an operation should be retried after a
+// given number of attempts have failed. If so, it waits for the
+// backoff delay to pass.
+func (ba *retryingBlobAccess) shouldRetry(ctx context.Context, attempts int, err error) bool {
+	if attempts >= ba.policy.MaximumAttempts {
+		return false
+	}
+	if _, ok := ba.policy.RetryableCodes[status.Code(err)]; !ok {
+		return false
+	}
+
+	backoff := float64(ba.policy.InitialBackoff)
+	for i := 1; i < attempts; i++ {
+		backoff *= ba.policy.BackoffMultiplier
+	}
+	if maximumBackoff := float64(ba.policy.MaximumBackoff); backoff > maximumBackoff {
+		backoff = maximumBackoff
+	}
+	var delay time.Duration
+	if backoff >= 1 {
+		delay = time.Duration(ba.randomNumberGenerator.Uint64() % uint64(backoff))
+	}
+
+	timer, timerChannel := ba.clock.NewTimer(delay)
+	select {
+	case <-timerChannel:
+		return true
+	case <-ctx.Done():
+		timer.Stop()
+		return false
+	}
+}
+
+func (ba *retryingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	return buffer.WithErrorHandler(
+		ba.base.Get(ctx, digest),
+		&retryingErrorHandler{
+			blobAccess: ba,
+			context:    ctx,
+			digest:     digest,
+			attempts:   1,
+		})
+}
+
+func (ba *retryingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	sizeBytes, err := b.GetSizeBytes()
+	if err != nil {
+		b.Discard()
+		return err
+	}
+	if sizeBytes > int64(ba.maximumPutSizeBytes) {
+		return ba.base.Put(ctx, digest, b)
+	}
+
+	// Load the object into memory, so that it can be written
+	// multiple times.
+	data, err := b.ToByteSlice(ba.maximumPutSizeBytes)
+	if err != nil {
+		return err
+	}
+	for attempts := 1; ; attempts++ {
+		err := ba.base.Put(ctx, digest, buffer.NewValidatedBufferFromByteSlice(data))
+		if err == nil || !ba.shouldRetry(ctx, attempts, err) {
+			return err
+		}
+	}
+}
+
+func (ba *retryingBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	for attempts := 1; ; attempts++ {
+		missing, err := ba.base.FindMissing(ctx, digests)
+		if err == nil || !ba.shouldRetry(ctx, attempts, err) {
+			return missing, err
+		}
+	}
+}
+
+type retryingErrorHandler struct {
+	blobAccess *retryingBlobAccess
+	context    context.Context
+	digest     digest.Digest
+	attempts   int
+}
+
+func (eh *retryingErrorHandler) OnError(err error) (buffer.Buffer, error) {
+	if !eh.blobAccess.shouldRetry(eh.context, eh.attempts, err) {
+		return nil, err
+	}
+	eh.attempts++
+	return eh.blobAccess.base.Get(eh.context, eh.digest), nil
+}
+
+func (eh *retryingErrorHandler) Done() {}
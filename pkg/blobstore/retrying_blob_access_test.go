@@ -0,0 +1,140 @@
+package blobstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/random"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryingBlobAccess(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	baseBlobAccess := mock.NewMockBlobAccess(ctrl)
+	mockClock := mock.NewMockClock(ctrl)
+	blobAccess := blobstore.NewRetryingBlobAccess(
+		baseBlobAccess,
+		mockClock,
+		random.FastThreadSafeGenerator,
+		blobstore.RetryPolicy{
+			MaximumAttempts:   3,
+			InitialBackoff:    time.Second,
+			MaximumBackoff:    3 * time.Second,
+			BackoffMultiplier: 4,
+			RetryableCodes: map[codes.Code]struct{}{
+				codes.Unavailable: {},
+			},
+		},
+		100)
+
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digests := blobDigest.ToSingletonSet()
+
+	// expectBackoff expects a single backoff delay to be applied,
+	// which should be bounded by the provided duration.
+	expectBackoff := func(maximumDelay time.Duration) {
+		timer := mock.NewMockTimer(ctrl)
+		mockClock.EXPECT().NewTimer(gomock.Any()).DoAndReturn(func(d time.Duration) (clock.Timer, <-chan time.Time) {
+			require.True(t, d >= 0 && d < maximumDelay)
+			ch := make(chan time.Time, 1)
+			ch <- time.Unix(1000, 0)
+			return timer, ch
+		})
+	}
+
+	t.Run("GetSuccessAfterRetry", func(t *testing.T) {
+		gomock.InOrder(
+			baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+				Return(buffer.NewBufferFromError(status.Error(codes.Unavailable, "Server offline"))),
+			baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+				Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+		expectBackoff(time.Second)
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("GetNonRetryable", func(t *testing.T) {
+		baseBlobAccess.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewBufferFromError(status.Error(codes.NotFound, "Object not found")))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "Object not found"), err)
+	})
+
+	t.Run("FindMissingAttemptsExhausted", func(t *testing.T) {
+		// The backoff should grow, but remain bounded by the
+		// maximum backoff.
+		baseBlobAccess.EXPECT().FindMissing(ctx, digests).
+			Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline")).
+			Times(3)
+		expectBackoff(time.Second)
+		expectBackoff(3 * time.Second)
+
+		_, err := blobAccess.FindMissing(ctx, digests)
+		testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Server offline"), err)
+	})
+
+	t.Run("PutSuccessAfterRetry", func(t *testing.T) {
+		// Objects should be retransmitted in their entirety.
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return status.Error(codes.Unavailable, "Server offline")
+			})
+		expectBackoff(time.Second)
+		baseBlobAccess.EXPECT().Put(ctx, blobDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				data, err := b.ToByteSlice(100)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("PutTooLarge", func(t *testing.T) {
+		// Objects that are too large to be held in memory should
+		// only be written once.
+		largeDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 200)
+		baseBlobAccess.EXPECT().Put(ctx, largeDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return status.Error(codes.Unavailable, "Server offline")
+			})
+
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.Unavailable, "Server offline"),
+			blobAccess.Put(ctx, largeDigest, buffer.NewValidatedBufferFromByteSlice(make([]byte, 200))))
+	})
+
+	t.Run("CanceledDuringBackoff", func(t *testing.T) {
+		canceledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		baseBlobAccess.EXPECT().FindMissing(canceledCtx, digests).
+			Return(digest.EmptySet, status.Error(codes.Unavailable, "Server offline"))
+		timer := mock.NewMockTimer(ctrl)
+		mockClock.EXPECT().NewTimer(gomock.Any()).Return(timer, nil)
+		timer.EXPECT().Stop().Return(true)
+
+		_, err := blobAccess.FindMissing(canceledCtx, digests)
+		testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Server offline"), err)
+	})
+}
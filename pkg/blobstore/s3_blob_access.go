@@ -0,0 +1,438 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	cloud_aws "github.com/buildbarn/bb-storage/pkg/cloud/aws"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/logging"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type s3BlobAccess struct {
+	s3                 cloud_aws.S3
+	readBufferFactory  ReadBufferFactory
+	digestKeyFormat    digest.KeyFormat
+	bucket             string
+	keyPrefix          string
+	partSizeBytes      int64
+	maximumConcurrency int
+	clock              clock.Clock
+	refreshInterval    time.Duration
+}
+
+// s3MaximumCopySizeBytes is the maximum size of objects that can be
+// copied through a single CopyObject() call.
+const s3MaximumCopySizeBytes = 5 * 1024 * 1024 * 1024
+
+// NewS3BlobAccess creates a BlobAccess that stores objects in an S3
+// bucket, or any other object store that provides an S3 compatible
+// API. Objects are stored under keys that consist of a configurable
+// prefix, followed by the key of the digest.
+//
+// Objects that are larger than the part size are uploaded using
+// multipart uploads, where up to maximumConcurrency parts are uploaded
+// in parallel. The same degree of concurrency is used to check for the
+// existence of objects, as S3 does not provide bulk operations for
+// doing so.
+//
+// The contents of the bucket can be enumerated, and objects may be
+// expired based on their modification time. This permits the use of
+// reachability-based garbage collection, instead of relying on
+// lifecycle rules of the bucket. As S3 does not track access times,
+// objects whose modification time is older than refreshInterval are
+// copied onto themselves when reported as present by FindMissing().
+// Expiring objects is only permitted if refreshing is enabled.
+func NewS3BlobAccess(s3 cloud_aws.S3, readBufferFactory ReadBufferFactory, digestKeyFormat digest.KeyFormat, bucket, keyPrefix string, partSizeBytes int64, maximumConcurrency int, clock clock.Clock, refreshInterval time.Duration) EnumerableBlobAccess {
+	return &s3BlobAccess{
+		s3:                 s3,
+		readBufferFactory:  readBufferFactory,
+		digestKeyFormat:    digestKeyFormat,
+		bucket:             bucket,
+		keyPrefix:          keyPrefix,
+		partSizeBytes:      partSizeBytes,
+		maximumConcurrency: maximumConcurrency,
+		clock:              clock,
+		refreshInterval:    refreshInterval,
+	}
+}
+
+func (ba *s3BlobAccess) getKey(digest digest.Digest) *string {
+	return aws.String(ba.keyPrefix + digest.GetKey(ba.digestKeyFormat))
+}
+
+// isNotFound returns whether an error returned by the S3 client
+// indicates that an object does not exist. GetObject() reports this
+// through an explicit error code, while HeadObject() only returns the
+// HTTP status code.
+func isNotFound(err error) bool {
+	if awsErr, ok := err.(awserr.RequestFailure); ok && awsErr.StatusCode() == 404 {
+		return true
+	}
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return true
+	}
+	return false
+}
+
+func (ba *s3BlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	key := ba.getKey(digest)
+	getObjectOutput, err := ba.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(ba.bucket),
+		Key:    key,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.NotFound, "Blob not found"))
+		}
+		return buffer.NewBufferFromError(util.StatusWrapWithCode(err, codes.Unavailable, "Failed to get blob"))
+	}
+	return ba.readBufferFactory.NewBufferFromReader(
+		digest,
+		getObjectOutput.Body,
+		func(dataIsValid bool) {
+			if !dataIsValid {
+				if _, err := ba.s3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+					Bucket: aws.String(ba.bucket),
+					Key:    key,
+				}); err == nil {
+					logging.Printf(ctx, "Blob %#v was malformed and has been deleted from S3 successfully", digest.String())
+				} else {
+					logging.Printf(ctx, "Blob %#v was malformed and could not be deleted from S3: %s", digest.String(), err)
+				}
+			}
+		})
+}
+
+func (ba *s3BlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	sizeBytes, err := b.GetSizeBytes()
+	if err != nil {
+		b.Discard()
+		return err
+	}
+	if sizeBytes <= ba.partSizeBytes {
+		// Small objects can be uploaded using a single request.
+		data, err := b.ToByteSlice(int(ba.partSizeBytes))
+		if err != nil {
+			return err
+		}
+		if _, err := ba.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(ba.bucket),
+			Key:    ba.getKey(digest),
+			Body:   bytes.NewReader(data),
+		}); err != nil {
+			return util.StatusWrapWithCode(err, codes.Unavailable, "Failed to put blob")
+		}
+		return nil
+	}
+	return ba.putMultipart(ctx, digest, b)
+}
+
+// putMultipart uploads a large object by splitting it up into parts,
+// which are uploaded concurrently. At most maximumConcurrency parts
+// are held in memory at any given time.
+func (ba *s3BlobAccess) putMultipart(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	key := ba.getKey(digest)
+	createOutput, err := ba.s3.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(ba.bucket),
+		Key:    key,
+	})
+	if err != nil {
+		b.Discard()
+		return util.StatusWrapWithCode(err, codes.Unavailable, "Failed to create multipart upload")
+	}
+	uploadID := createOutput.UploadId
+
+	// Upload parts concurrently. Stop reading data as soon as any
+	// of the uploads fails.
+	uploadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var uploadErr error
+	var completedParts []*s3.CompletedPart
+	semaphore := make(chan struct{}, ba.maximumConcurrency)
+
+	r := b.ToReader()
+	defer r.Close()
+	for partNumber := int64(1); ; partNumber++ {
+		select {
+		case semaphore <- struct{}{}:
+		case <-uploadCtx.Done():
+		}
+		if uploadCtx.Err() != nil {
+			break
+		}
+		part := make([]byte, ba.partSizeBytes)
+		n, err := io.ReadFull(r, part)
+		if err == io.EOF {
+			<-semaphore
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			<-semaphore
+			lock.Lock()
+			if uploadErr == nil {
+				uploadErr = err
+			}
+			lock.Unlock()
+			break
+		}
+
+		wg.Add(1)
+		go func(partNumber int64, part []byte) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			uploadPartOutput, err := ba.s3.UploadPartWithContext(uploadCtx, &s3.UploadPartInput{
+				Bucket:     aws.String(ba.bucket),
+				Key:        key,
+				UploadId:   uploadID,
+				PartNumber: aws.Int64(partNumber),
+				Body:       bytes.NewReader(part),
+			})
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if uploadErr == nil {
+					uploadErr = util.StatusWrapfWithCode(err, codes.Unavailable, "Failed to upload part %d", partNumber)
+				}
+				cancel()
+				return
+			}
+			completedParts = append(completedParts, &s3.CompletedPart{
+				ETag:       uploadPartOutput.ETag,
+				PartNumber: aws.Int64(partNumber),
+			})
+		}(partNumber, part[:n])
+
+		if n < len(part) {
+			break
+		}
+	}
+	wg.Wait()
+	if uploadErr == nil {
+		uploadErr = util.StatusFromContext(ctx)
+	}
+
+	if uploadErr == nil {
+		sort.Slice(completedParts, func(i, j int) bool {
+			return *completedParts[i].PartNumber < *completedParts[j].PartNumber
+		})
+		if _, err := ba.s3.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:   aws.String(ba.bucket),
+			Key:      key,
+			UploadId: uploadID,
+			MultipartUpload: &s3.CompletedMultipartUpload{
+				Parts: completedParts,
+			},
+		}); err != nil {
+			uploadErr = util.StatusWrapWithCode(err, codes.Unavailable, "Failed to complete multipart upload")
+		} else {
+			return nil
+		}
+	}
+
+	// Release storage consumed by parts that were uploaded
+	// successfully. Don't use the caller's context, as this also
+	// needs to be done when the caller's context was canceled.
+	if _, err := ba.s3.AbortMultipartUploadWithContext(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(ba.bucket),
+		Key:      key,
+		UploadId: uploadID,
+	}); err != nil {
+		logging.Printf(ctx, "Failed to abort multipart upload for blob %#v: %s", digest.String(), err)
+	}
+	return uploadErr
+}
+
+func (ba *s3BlobAccess) Delete(ctx context.Context, digest digest.Digest) error {
+	if _, err := ba.s3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(ba.bucket),
+		Key:    ba.getKey(digest),
+	}); err != nil && !isNotFound(err) {
+		return util.StatusWrapWithCode(err, codes.Unavailable, "Failed to delete blob")
+	}
+	return nil
+}
+
+func (ba *s3BlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	// S3 does not provide a bulk operation for checking the
+	// existence of objects. Issue HeadObject() calls concurrently.
+	findCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var findErr error
+	missing := digest.NewSetBuilder()
+	semaphore := make(chan struct{}, ba.maximumConcurrency)
+	for _, blobDigest := range digests.Items() {
+		select {
+		case semaphore <- struct{}{}:
+		case <-findCtx.Done():
+		}
+		if findCtx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(blobDigest digest.Digest) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			isMissing, err := ba.findMissingAndRefresh(findCtx, blobDigest)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if findErr == nil {
+					findErr = util.StatusWrapfWithCode(err, codes.Unavailable, "Failed to check existence of blob %#v", blobDigest.String())
+					cancel()
+				}
+			} else if isMissing {
+				missing.Add(blobDigest)
+			}
+		}(blobDigest)
+	}
+	wg.Wait()
+
+	if findErr != nil {
+		return digest.EmptySet, findErr
+	}
+	if err := util.StatusFromContext(ctx); err != nil {
+		return digest.EmptySet, err
+	}
+	return missing.Build(), nil
+}
+
+// findMissingAndRefresh checks for the existence of a single object.
+// If the object exists, but its modification time is older than the
+// refresh interval, it is copied onto itself to update its
+// modification time.
+func (ba *s3BlobAccess) findMissingAndRefresh(ctx context.Context, blobDigest digest.Digest) (bool, error) {
+	key := ba.getKey(blobDigest)
+	headObjectOutput, err := ba.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(ba.bucket),
+		Key:    key,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if ba.refreshInterval <= 0 || !aws.TimeValue(headObjectOutput.LastModified).Before(ba.clock.Now().Add(-ba.refreshInterval)) {
+		return false, nil
+	}
+	if aws.Int64Value(headObjectOutput.ContentLength) > s3MaximumCopySizeBytes {
+		// Report the object as missing, so that the client
+		// uploads it again.
+		return true, nil
+	}
+	if _, err := ba.s3.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(ba.bucket),
+		Key:               key,
+		CopySource:        aws.String((&url.URL{Path: ba.bucket + "/" + *key}).EscapedPath()),
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+	}); err != nil {
+		if isNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+func (ba *s3BlobAccess) EnumerateKeys(ctx context.Context, f func(key string) error) error {
+	var enumerateErr error
+	if err := ba.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(ba.bucket),
+		Prefix: aws.String(ba.keyPrefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			if err := f(strings.TrimPrefix(*object.Key, ba.keyPrefix)); err != nil {
+				enumerateErr = err
+				return false
+			}
+		}
+		return true
+	}); err != nil {
+		return util.StatusWrapWithCode(err, codes.Unavailable, "Failed to list objects")
+	}
+	return enumerateErr
+}
+
+func (ba *s3BlobAccess) ExpireBlobs(ctx context.Context, modifiedBefore time.Time, isRetained func(blobDigest digest.Digest) bool) (int64, error) {
+	// Objects reported as present by FindMissing() may have a
+	// modification time that is up to the refresh interval in the
+	// past. Expiring objects that were modified more recently could
+	// remove objects that are referenced by builds in progress.
+	if ba.refreshInterval <= 0 {
+		return 0, status.Error(codes.FailedPrecondition, "Objects can only be expired if refreshing of objects is enabled")
+	}
+	if gracePeriod := ba.clock.Now().Sub(modifiedBefore); gracePeriod <= ba.refreshInterval {
+		return 0, status.Errorf(codes.FailedPrecondition, "Grace period of %s does not exceed the refresh interval of %s", gracePeriod, ba.refreshInterval)
+	}
+
+	// Objects are listed one page at a time, with every page
+	// containing at most 1000 objects. This is also the maximum
+	// number of objects that may be removed through a single call
+	// to DeleteObjects().
+	expired := int64(0)
+	var expireErr error
+	if err := ba.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(ba.bucket),
+		Prefix: aws.String(ba.keyPrefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		var objects []*s3.ObjectIdentifier
+		for _, object := range page.Contents {
+			if !aws.TimeValue(object.LastModified).Before(modifiedBefore) {
+				continue
+			}
+			blobDigest, err := digest.NewDigestFromKey(strings.TrimPrefix(*object.Key, ba.keyPrefix))
+			if err != nil || isRetained(blobDigest) {
+				continue
+			}
+			objects = append(objects, &s3.ObjectIdentifier{Key: object.Key})
+		}
+		if len(objects) == 0 {
+			return true
+		}
+
+		deleteObjectsOutput, err := ba.s3.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(ba.bucket),
+			Delete: &s3.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			expireErr = util.StatusWrapWithCode(err, codes.Unavailable, "Failed to delete objects")
+			return false
+		}
+		expired += int64(len(objects) - len(deleteObjectsOutput.Errors))
+		if len(deleteObjectsOutput.Errors) > 0 {
+			deleteErr := deleteObjectsOutput.Errors[0]
+			expireErr = status.Errorf(codes.Unavailable, "Failed to delete object %#v: %s", aws.StringValue(deleteErr.Key), aws.StringValue(deleteErr.Message))
+			return false
+		}
+		return true
+	}); err != nil {
+		return expired, util.StatusWrapWithCode(err, codes.Unavailable, "Failed to list objects")
+	}
+	return expired, expireErr
+}
@@ -0,0 +1,465 @@
+package blobstore_test
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestS3BlobAccessGet(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	s3Client := mock.NewMockS3(ctrl)
+	blobAccess := blobstore.NewS3BlobAccess(s3Client, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, "bucket", "cas/", 5, 2, clock.SystemClock, 0)
+	blobDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("NotFound", func(t *testing.T) {
+		s3Client.EXPECT().GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("cas/3e25960a79dbc69b674cd4ec67a72c62-11"),
+		}).Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil))
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		s3Client.EXPECT().GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("cas/3e25960a79dbc69b674cd4ec67a72c62-11"),
+		}).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(strings.NewReader("Hello world")),
+		}, nil)
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello world"), data)
+	})
+
+	t.Run("DataCorruption", func(t *testing.T) {
+		// Corrupted objects should be deleted from the bucket.
+		s3Client.EXPECT().GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("cas/3e25960a79dbc69b674cd4ec67a72c62-11"),
+		}).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(strings.NewReader("Hello World")),
+		}, nil)
+		s3Client.EXPECT().DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("cas/3e25960a79dbc69b674cd4ec67a72c62-11"),
+		}).Return(&s3.DeleteObjectOutput{}, nil)
+
+		_, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		testutil.RequireEqualStatus(t, status.Error(codes.Internal, "Buffer has checksum b10a8db164e0754105b7a99be72e3fe5, while 3e25960a79dbc69b674cd4ec67a72c62 was expected"), err)
+	})
+}
+
+func TestS3BlobAccessPut(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	s3Client := mock.NewMockS3(ctrl)
+	blobAccess := blobstore.NewS3BlobAccess(s3Client, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, "bucket", "cas/", 5, 2, clock.SystemClock, 0)
+
+	t.Run("SinglePart", func(t *testing.T) {
+		s3Client.EXPECT().PutObjectWithContext(ctx, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+				require.Equal(t, "bucket", *input.Bucket)
+				require.Equal(t, "cas/8b1a9953c4611296a827abf8c47804d7-5", *input.Key)
+				data, err := ioutil.ReadAll(input.Body)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Hello"), data)
+				return &s3.PutObjectOutput{}, nil
+			})
+
+		require.NoError(t, blobAccess.Put(
+			ctx,
+			digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5),
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("MultiplePartsSuccess", func(t *testing.T) {
+		// An object of 11 bytes should be uploaded in three
+		// parts. Parts should be listed in order when completing
+		// the upload, regardless of the order in which they
+		// finished uploading.
+		s3Client.EXPECT().CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("cas/3e25960a79dbc69b674cd4ec67a72c62-11"),
+		}).Return(&s3.CreateMultipartUploadOutput{
+			UploadId: aws.String("upload-id"),
+		}, nil)
+		expectedParts := map[int64]string{1: "Hello", 2: " worl", 3: "d"}
+		s3Client.EXPECT().UploadPartWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, input *s3.UploadPartInput, opts ...request.Option) (*s3.UploadPartOutput, error) {
+				require.Equal(t, "upload-id", *input.UploadId)
+				data, err := ioutil.ReadAll(input.Body)
+				require.NoError(t, err)
+				require.Equal(t, expectedParts[*input.PartNumber], string(data))
+				return &s3.UploadPartOutput{
+					ETag: aws.String(string(data)),
+				}, nil
+			}).Times(3)
+		s3Client.EXPECT().CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:   aws.String("bucket"),
+			Key:      aws.String("cas/3e25960a79dbc69b674cd4ec67a72c62-11"),
+			UploadId: aws.String("upload-id"),
+			MultipartUpload: &s3.CompletedMultipartUpload{
+				Parts: []*s3.CompletedPart{
+					{ETag: aws.String("Hello"), PartNumber: aws.Int64(1)},
+					{ETag: aws.String(" worl"), PartNumber: aws.Int64(2)},
+					{ETag: aws.String("d"), PartNumber: aws.Int64(3)},
+				},
+			},
+		}).Return(&s3.CompleteMultipartUploadOutput{}, nil)
+
+		require.NoError(t, blobAccess.Put(
+			ctx,
+			digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11),
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello world"))))
+	})
+
+	t.Run("MultiplePartsFailure", func(t *testing.T) {
+		// Failing to upload a part should cause the multipart
+		// upload to be aborted.
+		s3Client.EXPECT().CreateMultipartUploadWithContext(ctx, gomock.Any()).Return(&s3.CreateMultipartUploadOutput{
+			UploadId: aws.String("upload-id"),
+		}, nil)
+		s3Client.EXPECT().UploadPartWithContext(gomock.Any(), gomock.Any()).
+			Return(nil, awserr.New("InternalError", "We encountered an internal error. Please try again.", nil)).
+			MinTimes(1)
+		s3Client.EXPECT().AbortMultipartUploadWithContext(gomock.Any(), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String("bucket"),
+			Key:      aws.String("cas/3e25960a79dbc69b674cd4ec67a72c62-11"),
+			UploadId: aws.String("upload-id"),
+		}).Return(&s3.AbortMultipartUploadOutput{}, nil)
+
+		err := blobAccess.Put(
+			ctx,
+			digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11),
+			buffer.NewValidatedBufferFromByteSlice([]byte("Hello world")))
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
+
+func TestS3BlobAccessFindMissing(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	s3Client := mock.NewMockS3(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Unix(5000, 0)).AnyTimes()
+	blobAccess := blobstore.NewS3BlobAccess(s3Client, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, "bucket", "cas/", 5, 2, clock, time.Hour)
+	digestPresent := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+	digestMissing := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+
+	t.Run("Success", func(t *testing.T) {
+		s3Client.EXPECT().HeadObjectWithContext(gomock.Any(), &s3.HeadObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("cas/8b1a9953c4611296a827abf8c47804d7-5"),
+		}).Return(&s3.HeadObjectOutput{
+			ContentLength: aws.Int64(5),
+			LastModified:  aws.Time(time.Unix(4000, 0)),
+		}, nil)
+		s3Client.EXPECT().HeadObjectWithContext(gomock.Any(), &s3.HeadObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("cas/3e25960a79dbc69b674cd4ec67a72c62-11"),
+		}).Return(nil, awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), 404, "request-id"))
+
+		missing, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(digestPresent).Add(digestMissing).Build())
+		require.NoError(t, err)
+		require.Equal(t, digestMissing.ToSingletonSet(), missing)
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		// Objects that were last modified more than an hour ago
+		// should be copied onto themselves, so that they are not
+		// removed by garbage collection.
+		s3Client.EXPECT().HeadObjectWithContext(gomock.Any(), &s3.HeadObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("cas/8b1a9953c4611296a827abf8c47804d7-5"),
+		}).Return(&s3.HeadObjectOutput{
+			ContentLength: aws.Int64(5),
+			LastModified:  aws.Time(time.Unix(1000, 0)),
+		}, nil)
+		s3Client.EXPECT().CopyObjectWithContext(gomock.Any(), &s3.CopyObjectInput{
+			Bucket:            aws.String("bucket"),
+			Key:               aws.String("cas/8b1a9953c4611296a827abf8c47804d7-5"),
+			CopySource:        aws.String("bucket/cas/8b1a9953c4611296a827abf8c47804d7-5"),
+			MetadataDirective: aws.String("REPLACE"),
+		}).Return(&s3.CopyObjectOutput{}, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digestPresent.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+
+	t.Run("RefreshTooLarge", func(t *testing.T) {
+		// Objects that are too large to be copied should be
+		// reported as missing, so that they are uploaded again.
+		s3Client.EXPECT().HeadObjectWithContext(gomock.Any(), &s3.HeadObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("cas/8b1a9953c4611296a827abf8c47804d7-5"),
+		}).Return(&s3.HeadObjectOutput{
+			ContentLength: aws.Int64(6 * 1024 * 1024 * 1024),
+			LastModified:  aws.Time(time.Unix(1000, 0)),
+		}, nil)
+
+		missing, err := blobAccess.FindMissing(ctx, digestPresent.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, digestPresent.ToSingletonSet(), missing)
+	})
+
+	t.Run("RefreshFailure", func(t *testing.T) {
+		s3Client.EXPECT().HeadObjectWithContext(gomock.Any(), &s3.HeadObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("cas/8b1a9953c4611296a827abf8c47804d7-5"),
+		}).Return(&s3.HeadObjectOutput{
+			ContentLength: aws.Int64(5),
+			LastModified:  aws.Time(time.Unix(1000, 0)),
+		}, nil)
+		s3Client.EXPECT().CopyObjectWithContext(gomock.Any(), gomock.Any()).
+			Return(nil, awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "request-id"))
+
+		_, err := blobAccess.FindMissing(ctx, digestPresent.ToSingletonSet())
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		s3Client.EXPECT().HeadObjectWithContext(gomock.Any(), gomock.Any()).
+			Return(nil, awserr.NewRequestFailure(awserr.New("Forbidden", "Forbidden", nil), 403, "request-id")).
+			MinTimes(1)
+
+		_, err := blobAccess.FindMissing(ctx, digest.NewSetBuilder().Add(digestPresent).Add(digestMissing).Build())
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
+
+func TestS3BlobAccessDelete(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	s3Client := mock.NewMockS3(ctrl)
+	blobAccess := blobstore.NewS3BlobAccess(s3Client, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, "bucket", "cas/", 5, 2, clock.SystemClock, 0)
+	blobDigest := digest.MustNewDigest("hello", "3e25960a79dbc69b674cd4ec67a72c62", 11)
+	deleteInput := &s3.DeleteObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("cas/3e25960a79dbc69b674cd4ec67a72c62-11"),
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		s3Client.EXPECT().DeleteObjectWithContext(ctx, deleteInput).Return(&s3.DeleteObjectOutput{}, nil)
+
+		require.NoError(t, blobAccess.(blobstore.BlobDeleter).Delete(ctx, blobDigest))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		// Deleting objects that are already absent should succeed.
+		s3Client.EXPECT().DeleteObjectWithContext(ctx, deleteInput).
+			Return(nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil))
+
+		require.NoError(t, blobAccess.(blobstore.BlobDeleter).Delete(ctx, blobDigest))
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		s3Client.EXPECT().DeleteObjectWithContext(ctx, deleteInput).
+			Return(nil, awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "request-id"))
+
+		err := blobAccess.(blobstore.BlobDeleter).Delete(ctx, blobDigest)
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
+
+func TestS3BlobAccessEnumerateKeys(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	s3Client := mock.NewMockS3(ctrl)
+	blobAccess := blobstore.NewS3BlobAccess(s3Client, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, "bucket", "cas/", 5, 2, clock.SystemClock, 0)
+	listInput := &s3.ListObjectsV2Input{
+		Bucket: aws.String("bucket"),
+		Prefix: aws.String("cas/"),
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		// Keys should be reported without the prefix, across
+		// all pages.
+		s3Client.EXPECT().ListObjectsV2PagesWithContext(ctx, listInput, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+				if fn(&s3.ListObjectsV2Output{
+					Contents: []*s3.Object{
+						{Key: aws.String("cas/3e25960a79dbc69b674cd4ec67a72c62-11")},
+					},
+				}, false) {
+					fn(&s3.ListObjectsV2Output{
+						Contents: []*s3.Object{
+							{Key: aws.String("cas/8b1a9953c4611296a827abf8c47804d7-5")},
+						},
+					}, true)
+				}
+				return nil
+			})
+
+		var keys []string
+		require.NoError(t, blobAccess.EnumerateKeys(ctx, func(key string) error {
+			keys = append(keys, key)
+			return nil
+		}))
+		require.Equal(t, []string{
+			"3e25960a79dbc69b674cd4ec67a72c62-11",
+			"8b1a9953c4611296a827abf8c47804d7-5",
+		}, keys)
+	})
+
+	t.Run("CallbackFailure", func(t *testing.T) {
+		// Errors returned by the callback should stop the
+		// enumeration.
+		s3Client.EXPECT().ListObjectsV2PagesWithContext(ctx, listInput, gomock.Any()).DoAndReturn(
+			func(ctx context.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+				require.False(t, fn(&s3.ListObjectsV2Output{
+					Contents: []*s3.Object{
+						{Key: aws.String("cas/3e25960a79dbc69b674cd4ec67a72c62-11")},
+						{Key: aws.String("cas/8b1a9953c4611296a827abf8c47804d7-5")},
+					},
+				}, true))
+				return nil
+			})
+
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.Internal, "Disk on fire"),
+			blobAccess.EnumerateKeys(ctx, func(key string) error {
+				return status.Error(codes.Internal, "Disk on fire")
+			}))
+	})
+
+	t.Run("ListFailure", func(t *testing.T) {
+		s3Client.EXPECT().ListObjectsV2PagesWithContext(ctx, listInput, gomock.Any()).
+			Return(awserr.New("AccessDenied", "Access Denied", nil))
+
+		err := blobAccess.EnumerateKeys(ctx, func(key string) error {
+			return nil
+		})
+		require.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
+
+func TestS3BlobAccessExpireBlobs(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	s3Client := mock.NewMockS3(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Unix(5000, 0)).AnyTimes()
+	blobAccess := blobstore.NewS3BlobAccess(s3Client, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, "bucket", "cas/", 5, 2, clock, time.Hour)
+	listInput := &s3.ListObjectsV2Input{
+		Bucket: aws.String("bucket"),
+		Prefix: aws.String("cas/"),
+	}
+	modifiedBefore := time.Unix(1000, 0)
+	page := &s3.ListObjectsV2Output{
+		Contents: []*s3.Object{
+			// Unreachable, and old enough to be removed.
+			{
+				Key:          aws.String("cas/3e25960a79dbc69b674cd4ec67a72c62-11"),
+				LastModified: aws.Time(time.Unix(900, 0)),
+			},
+			// Reachable.
+			{
+				Key:          aws.String("cas/8b1a9953c4611296a827abf8c47804d7-5"),
+				LastModified: aws.Time(time.Unix(900, 0)),
+			},
+			// Unreachable, but too recent to be removed.
+			{
+				Key:          aws.String("cas/6f5902ac237024bdd0c176cb93063dc4-12"),
+				LastModified: aws.Time(time.Unix(1000, 0)),
+			},
+			// Not created by Buildbarn.
+			{
+				Key:          aws.String("cas/README"),
+				LastModified: aws.Time(time.Unix(900, 0)),
+			},
+		},
+	}
+	isRetained := func(blobDigest digest.Digest) bool {
+		return blobDigest == digest.MustNewDigest("", "8b1a9953c4611296a827abf8c47804d7", 5)
+	}
+	deleteInput := &s3.DeleteObjectsInput{
+		Bucket: aws.String("bucket"),
+		Delete: &s3.Delete{
+			Objects: []*s3.ObjectIdentifier{
+				{Key: aws.String("cas/3e25960a79dbc69b674cd4ec67a72c62-11")},
+			},
+			Quiet: aws.Bool(true),
+		},
+	}
+	listOnePage := func(ctx context.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+		fn(page, true)
+		return nil
+	}
+
+	t.Run("RefreshingDisabled", func(t *testing.T) {
+		// Objects reported as present by FindMissing() are not
+		// refreshed, meaning that they may be removed while
+		// builds depend on them.
+		blobAccess := blobstore.NewS3BlobAccess(s3Client, blobstore.CASReadBufferFactory, digest.KeyWithoutInstance, "bucket", "cas/", 5, 2, clock, 0)
+
+		_, err := blobAccess.(blobstore.BlobExpirer).ExpireBlobs(ctx, modifiedBefore, isRetained)
+		testutil.RequireEqualStatus(t, status.Error(codes.FailedPrecondition, "Objects can only be expired if refreshing of objects is enabled"), err)
+	})
+
+	t.Run("GracePeriodTooShort", func(t *testing.T) {
+		_, err := blobAccess.(blobstore.BlobExpirer).ExpireBlobs(ctx, time.Unix(2000, 0), isRetained)
+		testutil.RequireEqualStatus(t, status.Error(codes.FailedPrecondition, "Grace period of 50m0s does not exceed the refresh interval of 1h0m0s"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		s3Client.EXPECT().ListObjectsV2PagesWithContext(ctx, listInput, gomock.Any()).DoAndReturn(listOnePage)
+		s3Client.EXPECT().DeleteObjectsWithContext(ctx, deleteInput).Return(&s3.DeleteObjectsOutput{}, nil)
+
+		expired, err := blobAccess.(blobstore.BlobExpirer).ExpireBlobs(ctx, modifiedBefore, isRetained)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), expired)
+	})
+
+	t.Run("NothingToExpire", func(t *testing.T) {
+		// No calls to DeleteObjects() should be made if all
+		// objects are retained.
+		s3Client.EXPECT().ListObjectsV2PagesWithContext(ctx, listInput, gomock.Any()).DoAndReturn(listOnePage)
+
+		expired, err := blobAccess.(blobstore.BlobExpirer).ExpireBlobs(ctx, modifiedBefore, func(blobDigest digest.Digest) bool {
+			return true
+		})
+		require.NoError(t, err)
+		require.Equal(t, int64(0), expired)
+	})
+
+	t.Run("DeleteFailure", func(t *testing.T) {
+		s3Client.EXPECT().ListObjectsV2PagesWithContext(ctx, listInput, gomock.Any()).DoAndReturn(listOnePage)
+		s3Client.EXPECT().DeleteObjectsWithContext(ctx, deleteInput).Return(&s3.DeleteObjectsOutput{
+			Errors: []*s3.Error{
+				{
+					Key:     aws.String("cas/3e25960a79dbc69b674cd4ec67a72c62-11"),
+					Message: aws.String("Access Denied"),
+				},
+			},
+		}, nil)
+
+		expired, err := blobAccess.(blobstore.BlobExpirer).ExpireBlobs(ctx, modifiedBefore, isRetained)
+		testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Failed to delete object \"cas/3e25960a79dbc69b674cd4ec67a72c62-11\": Access Denied"), err)
+		require.Equal(t, int64(0), expired)
+	})
+}
@@ -0,0 +1,62 @@
+package sharding
+
+import (
+	"math"
+	"sort"
+)
+
+type rendezvousShardPermuter struct {
+	weights []float64
+}
+
+// NewRendezvousShardPermuter is a shard selection algorithm based on
+// weighted rendezvous hashing (also known as highest random weight
+// hashing). For every hash, a score is computed for each shard. Shards
+// are returned in order of decreasing score.
+//
+// Unlike NewWeightedShardPermuter, adding a shard to the end of the
+// list only causes keys to be moved to the new shard. The amount of
+// data that is moved is proportional to the weight of the new shard.
+// Similarly, the weight of a shard may be adjusted without causing keys
+// to be moved between the other shards.
+func NewRendezvousShardPermuter(weights []uint32) ShardPermuter {
+	floatWeights := make([]float64, 0, len(weights))
+	for _, weight := range weights {
+		floatWeights = append(floatWeights, float64(weight))
+	}
+	return &rendezvousShardPermuter{
+		weights: floatWeights,
+	}
+}
+
+// mixHash computes a hash for the combination of a key's hash and a
+// shard index, using the finalizer of SplitMix64.
+func mixHash(hash uint64, index int) uint64 {
+	h := hash ^ (uint64(index)+1)*0x9e3779b97f4a7c15
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	return h ^ (h >> 31)
+}
+
+func (s *rendezvousShardPermuter) GetShard(hash uint64, selector ShardSelector) {
+	// Compute scores for every shard, using the method described
+	// in "Weighted Distributed Hash Tables" by Schindelhauer and
+	// Schomaker. The score of a shard is -weight / ln(x), where x
+	// is a uniformly distributed number in the range (0, 1).
+	scores := make([]float64, len(s.weights))
+	indices := make([]int, len(s.weights))
+	for i, weight := range s.weights {
+		x := (float64(mixHash(hash, i)>>11) + 0.5) / (1 << 53)
+		scores[i] = -weight / math.Log(x)
+		indices[i] = i
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		return scores[indices[i]] > scores[indices[j]]
+	})
+
+	for _, index := range indices {
+		if !selector(index) {
+			return
+		}
+	}
+}
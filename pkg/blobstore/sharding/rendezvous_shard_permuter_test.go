@@ -0,0 +1,62 @@
+package sharding_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/sharding"
+	"github.com/stretchr/testify/require"
+)
+
+func getFirstShard(s sharding.ShardPermuter, hash uint64) int {
+	shard := -1
+	s.GetShard(hash, func(i int) bool {
+		shard = i
+		return false
+	})
+	return shard
+}
+
+func TestRendezvousShardPermuterDistribution(t *testing.T) {
+	// Distribution across five backends with a total weight of 15.
+	weights := []uint32{1, 4, 2, 5, 3}
+	s := sharding.NewRendezvousShardPermuter(weights)
+
+	occurrences := map[int]uint32{}
+	for hash := uint64(0); hash < 1000000; hash++ {
+		occurrences[getFirstShard(s, hash)]++
+	}
+
+	// Requests should be fanned out with a small error margin.
+	for shard, weight := range weights {
+		require.InEpsilon(t, weight*1000000/15, occurrences[shard], 0.02)
+	}
+}
+
+func TestRendezvousShardPermuterPermutation(t *testing.T) {
+	// Every shard should be returned exactly once, so that drained
+	// shards can be skipped.
+	s := sharding.NewRendezvousShardPermuter([]uint32{1, 4, 2, 5, 3})
+	seen := map[int]bool{}
+	s.GetShard(9127725482751685232, func(i int) bool {
+		require.False(t, seen[i])
+		seen[i] = true
+		return true
+	})
+	require.Len(t, seen, 5)
+}
+
+func TestRendezvousShardPermuterAddShard(t *testing.T) {
+	// Adding a shard should only cause keys to be moved to the new
+	// shard, and only a fraction proportional to its weight.
+	before := sharding.NewRendezvousShardPermuter([]uint32{1, 1, 1, 1})
+	after := sharding.NewRendezvousShardPermuter([]uint32{1, 1, 1, 1, 1})
+
+	moved := 0
+	for hash := uint64(0); hash < 100000; hash++ {
+		if shardAfter := getFirstShard(after, hash); shardAfter != getFirstShard(before, hash) {
+			require.Equal(t, 4, shardAfter)
+			moved++
+		}
+	}
+	require.InEpsilon(t, 100000/5, moved, 0.05)
+}
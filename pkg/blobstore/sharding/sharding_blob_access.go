@@ -2,30 +2,103 @@ package sharding
 
 import (
 	"context"
+	"sync"
 
+	"github.com/buildbarn/bb-storage/pkg/atomic"
 	"github.com/buildbarn/bb-storage/pkg/blobstore"
 	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
 	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// ShardingBlobAccess is a BlobAccess that partitions requests across
+// a set of shards. The set of shards that is drained may be changed
+// at runtime.
+type ShardingBlobAccess interface {
+	blobstore.BlobAccess
+
+	// SetDrainedShards changes which shards are drained. Requests
+	// for blobs that map to drained shards are spread out across
+	// the other shards. Shards that have no backend cannot be
+	// undrained, and at least one shard needs to remain undrained.
+	SetDrainedShards(drained []bool) error
+}
+
 type shardingBlobAccess struct {
 	backends           []blobstore.BlobAccess
+	readBackends       []blobstore.BlobAccess
 	shardPermuter      ShardPermuter
 	hashInitialization uint64
+
+	drainedLock sync.Mutex
+	drained     []atomic.Uint32
 }
 
 // NewShardingBlobAccess is an adapter for BlobAccess that partitions
 // requests across backends by hashing the digest. A ShardPermuter is
 // used to map hashes to backends.
-func NewShardingBlobAccess(backends []blobstore.BlobAccess, shardPermuter ShardPermuter, hashInitialization uint64) blobstore.BlobAccess {
-	return &shardingBlobAccess{
+//
+// Get() and FindMissing() calls are sent to readBackends, while Put()
+// calls are sent to backends. This makes it possible to read data from
+// a replica of a shard that is located closer to the client (e.g., in
+// the same availability zone), while still writing data to the shard's
+// canonical backend. For shards that have no such replica, both slices
+// should contain the same backend.
+//
+// Shards whose backend is nil are drained.
+func NewShardingBlobAccess(backends, readBackends []blobstore.BlobAccess, shardPermuter ShardPermuter, hashInitialization uint64) ShardingBlobAccess {
+	ba := &shardingBlobAccess{
 		backends:           backends,
+		readBackends:       readBackends,
 		shardPermuter:      shardPermuter,
 		hashInitialization: hashInitialization,
+		drained:            make([]atomic.Uint32, len(backends)),
+	}
+	for i, backend := range backends {
+		if backend == nil {
+			ba.drained[i].Initialize(1)
+		}
+	}
+	return ba
+}
+
+func (ba *shardingBlobAccess) SetDrainedShards(drained []bool) error {
+	if len(drained) != len(ba.backends) {
+		return status.Errorf(codes.InvalidArgument, "Expected %d shards, while %d shards were provided", len(ba.backends), len(drained))
+	}
+	hasUndrainedShards := false
+	for i, d := range drained {
+		if !d {
+			if ba.backends[i] == nil {
+				return status.Errorf(codes.InvalidArgument, "Shard %d cannot be undrained, as it has no backend", i)
+			}
+			hasUndrainedShards = true
+		}
+	}
+	if !hasUndrainedShards {
+		return status.Error(codes.InvalidArgument, "At least one shard must remain undrained")
+	}
+
+	// Undrain shards before draining others, so that concurrent
+	// requests never observe a state where all shards are drained.
+	ba.drainedLock.Lock()
+	defer ba.drainedLock.Unlock()
+	for i, d := range drained {
+		if !d {
+			ba.drained[i].Store(0)
+		}
+	}
+	for i, d := range drained {
+		if d {
+			ba.drained[i].Store(1)
+		}
 	}
+	return nil
 }
 
-func (ba *shardingBlobAccess) getBackend(blobDigest digest.Digest) blobstore.BlobAccess {
+func (ba *shardingBlobAccess) getBackendIndex(blobDigest digest.Digest) int {
 	// Hash the key using FNV-1a.
 	h := ba.hashInitialization
 	for _, c := range blobDigest.GetKey(digest.KeyWithoutInstance) {
@@ -34,20 +107,20 @@ func (ba *shardingBlobAccess) getBackend(blobDigest digest.Digest) blobstore.Blo
 	}
 
 	// Keep requesting shards until matching one that is undrained.
-	var backend blobstore.BlobAccess
+	var backendIndex int
 	ba.shardPermuter.GetShard(h, func(index int) bool {
-		backend = ba.backends[index]
-		return backend == nil
+		backendIndex = index
+		return ba.drained[index].Load() != 0
 	})
-	return backend
+	return backendIndex
 }
 
 func (ba *shardingBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
-	return ba.getBackend(digest).Get(ctx, digest)
+	return ba.readBackends[ba.getBackendIndex(digest)].Get(ctx, digest)
 }
 
 func (ba *shardingBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
-	return ba.getBackend(digest).Put(ctx, digest, b)
+	return ba.backends[ba.getBackendIndex(digest)].Put(ctx, digest, b)
 }
 
 type findMissingResults struct {
@@ -64,7 +137,7 @@ func (ba *shardingBlobAccess) FindMissing(ctx context.Context, digests digest.Se
 	// Determine which backends to contact.
 	digestsPerBackend := map[blobstore.BlobAccess]digest.SetBuilder{}
 	for _, blobDigest := range digests.Items() {
-		backend := ba.getBackend(blobDigest)
+		backend := ba.readBackends[ba.getBackendIndex(blobDigest)]
 		if _, ok := digestsPerBackend[backend]; !ok {
 			digestsPerBackend[backend] = digest.NewSetBuilder()
 		}
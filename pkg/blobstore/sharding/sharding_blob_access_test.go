@@ -0,0 +1,116 @@
+package sharding_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/sharding"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestShardingBlobAccessZonalReplicas(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	// The first shard is drained. The second shard has a replica
+	// from which data should be read.
+	canonicalBackend := mock.NewMockBlobAccess(ctrl)
+	replicaBackend := mock.NewMockBlobAccess(ctrl)
+	blobAccess := sharding.NewShardingBlobAccess(
+		[]blobstore.BlobAccess{nil, canonicalBackend},
+		[]blobstore.BlobAccess{nil, replicaBackend},
+		sharding.NewWeightedShardPermuter([]uint32{1, 1}),
+		0)
+
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("Get", func(t *testing.T) {
+		replicaBackend.EXPECT().Get(ctx, blobDigest).
+			Return(buffer.NewValidatedBufferFromByteSlice([]byte("Hello")))
+
+		data, err := blobAccess.Get(ctx, blobDigest).ToByteSlice(100)
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), data)
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		canonicalBackend.EXPECT().Put(ctx, blobDigest, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, blobDigest digest.Digest, b buffer.Buffer) error {
+				b.Discard()
+				return nil
+			})
+
+		require.NoError(t, blobAccess.Put(ctx, blobDigest, buffer.NewValidatedBufferFromByteSlice([]byte("Hello"))))
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		replicaBackend.EXPECT().FindMissing(ctx, blobDigest.ToSingletonSet()).
+			Return(blobDigest.ToSingletonSet(), nil)
+
+		missing, err := blobAccess.FindMissing(ctx, blobDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, blobDigest.ToSingletonSet(), missing)
+	})
+}
+
+func TestShardingBlobAccessSetDrainedShards(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	backend0 := mock.NewMockBlobAccess(ctrl)
+	backend1 := mock.NewMockBlobAccess(ctrl)
+	backends := []blobstore.BlobAccess{backend0, backend1, nil}
+	blobAccess := sharding.NewShardingBlobAccess(
+		backends,
+		backends,
+		sharding.NewWeightedShardPermuter([]uint32{1, 1, 1}),
+		0)
+
+	blobDigest := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5)
+
+	t.Run("InvalidShardCount", func(t *testing.T) {
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.InvalidArgument, "Expected 3 shards, while 2 shards were provided"),
+			blobAccess.SetDrainedShards([]bool{false, false}))
+	})
+
+	t.Run("UndrainShardWithoutBackend", func(t *testing.T) {
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.InvalidArgument, "Shard 2 cannot be undrained, as it has no backend"),
+			blobAccess.SetDrainedShards([]bool{false, false, false}))
+	})
+
+	t.Run("AllShardsDrained", func(t *testing.T) {
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.InvalidArgument, "At least one shard must remain undrained"),
+			blobAccess.SetDrainedShards([]bool{true, true, true}))
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Draining either of the shards should cause all
+		// requests to be sent to the other shard.
+		require.NoError(t, blobAccess.SetDrainedShards([]bool{true, false, true}))
+		backend1.EXPECT().FindMissing(ctx, blobDigest.ToSingletonSet()).
+			Return(digest.EmptySet, nil)
+		missing, err := blobAccess.FindMissing(ctx, blobDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+
+		require.NoError(t, blobAccess.SetDrainedShards([]bool{false, true, true}))
+		backend0.EXPECT().FindMissing(ctx, blobDigest.ToSingletonSet()).
+			Return(digest.EmptySet, nil)
+		missing, err = blobAccess.FindMissing(ctx, blobDigest.ToSingletonSet())
+		require.NoError(t, err)
+		require.Equal(t, digest.EmptySet, missing)
+	})
+}
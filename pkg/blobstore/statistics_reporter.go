@@ -0,0 +1,25 @@
+package blobstore
+
+import (
+	"context"
+)
+
+// StorageStatistics contains information on the data held by a
+// storage backend.
+type StorageStatistics struct {
+	// The estimated number of objects stored in the backend.
+	ObjectsCount int64 `json:"objects_count"`
+	// The estimated total size of the objects stored in the
+	// backend, if known.
+	SizeBytes *int64 `json:"size_bytes,omitempty"`
+	// Backend specific information, such as fill levels of blocks.
+	Details interface{} `json:"details,omitempty"`
+}
+
+// StatisticsReporter is implemented by backends that are capable of
+// reporting statistics on the data held within them. These statistics
+// are displayed by the diagnostics HTTP server, as they are not always
+// inferable from Prometheus metrics.
+type StatisticsReporter interface {
+	GetStatistics(ctx context.Context) (StorageStatistics, error)
+}
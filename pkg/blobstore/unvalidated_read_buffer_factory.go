@@ -0,0 +1,51 @@
+package blobstore
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type unvalidatedReadBufferFactory struct {
+	maximumSizeBytes int64
+}
+
+// NewUnvalidatedReadBufferFactory creates a ReadBufferFactory that
+// returns data as is, without performing any integrity checking. This
+// is needed by decorators that transform data before it is handed to
+// the backend (e.g., EncryptingBlobAccess), as the data stored in the
+// backend no longer corresponds to the digest.
+//
+// Buffers created from readers are loaded into memory entirely. To
+// prevent excessive memory usage, this is only permitted for objects
+// up to maximumSizeBytes in size.
+func NewUnvalidatedReadBufferFactory(maximumSizeBytes int64) ReadBufferFactory {
+	return &unvalidatedReadBufferFactory{
+		maximumSizeBytes: maximumSizeBytes,
+	}
+}
+
+func (f *unvalidatedReadBufferFactory) NewBufferFromByteSlice(digest digest.Digest, data []byte, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	return buffer.NewValidatedBufferFromByteSlice(data)
+}
+
+func (f *unvalidatedReadBufferFactory) NewBufferFromReader(digest digest.Digest, r io.ReadCloser, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	defer r.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(r, f.maximumSizeBytes+1))
+	if err != nil {
+		return buffer.NewBufferFromError(err)
+	}
+	if int64(len(data)) > f.maximumSizeBytes {
+		return buffer.NewBufferFromError(status.Errorf(codes.InvalidArgument, "Buffer is at least %d bytes in size, while a maximum of %d bytes is permitted", len(data), f.maximumSizeBytes))
+	}
+	return buffer.NewValidatedBufferFromByteSlice(data)
+}
+
+func (f *unvalidatedReadBufferFactory) NewBufferFromReaderAt(digest digest.Digest, r buffer.ReadAtCloser, sizeBytes int64, dataIntegrityCallback buffer.DataIntegrityCallback) buffer.Buffer {
+	return buffer.NewValidatedBufferFromReaderAt(r, sizeBytes)
+}
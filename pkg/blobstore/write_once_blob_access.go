@@ -0,0 +1,53 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/auth"
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type writeOnceBlobAccess struct {
+	BlobAccess
+	overwriteAuthorizer auth.Authorizer
+}
+
+// NewWriteOnceBlobAccess creates a decorator for an Action Cache (AC)
+// that refuses to overwrite existing entries. This prevents clients
+// (e.g., compromised workers) from replacing legitimate ActionResult
+// messages with poisoned ones. Clients for which the overwrite
+// authorizer grants access are still permitted to overwrite entries.
+//
+// Existence of entries is checked by calling Get() prior to forwarding
+// Put() operations. Concurrent writes for the same key may therefore
+// still both succeed.
+func NewWriteOnceBlobAccess(base BlobAccess, overwriteAuthorizer auth.Authorizer) BlobAccess {
+	return &writeOnceBlobAccess{
+		BlobAccess:          base,
+		overwriteAuthorizer: overwriteAuthorizer,
+	}
+}
+
+func (ba *writeOnceBlobAccess) Put(ctx context.Context, digest digest.Digest, b buffer.Buffer) error {
+	overwriteErr := ba.overwriteAuthorizer.Authorize(ctx, digest.GetInstanceName())
+	if overwriteErr == nil {
+		return ba.BlobAccess.Put(ctx, digest, b)
+	}
+
+	existing := ba.BlobAccess.Get(ctx, digest)
+	_, err := existing.GetSizeBytes()
+	existing.Discard()
+	if err == nil {
+		b.Discard()
+		return util.StatusWrap(overwriteErr, "Action result already exists")
+	} else if status.Code(err) != codes.NotFound {
+		b.Discard()
+		return util.StatusWrap(err, "Failed to determine whether the action result already exists")
+	}
+	return ba.BlobAccess.Put(ctx, digest, b)
+}
@@ -0,0 +1,37 @@
+package blobstore
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/blobstore/buffer"
+	"github.com/buildbarn/bb-storage/pkg/digest"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type writeOnlyBlobAccess struct {
+	BlobAccess
+}
+
+// NewWriteOnlyBlobAccess creates a decorator for BlobAccess that
+// rejects all Get() and FindMissing() operations. This can be used to
+// expose an ingestion endpoint, through which clients can upload
+// objects without being able to observe the contents of storage.
+func NewWriteOnlyBlobAccess(base BlobAccess) BlobAccess {
+	return &writeOnlyBlobAccess{
+		BlobAccess: base,
+	}
+}
+
+func newWriteOnlyError() error {
+	return status.Error(codes.PermissionDenied, "This storage backend is write-only")
+}
+
+func (ba *writeOnlyBlobAccess) Get(ctx context.Context, digest digest.Digest) buffer.Buffer {
+	return buffer.NewBufferFromError(newWriteOnlyError())
+}
+
+func (ba *writeOnlyBlobAccess) FindMissing(ctx context.Context, digests digest.Set) (digest.Set, error) {
+	return digest.EmptySet, newWriteOnlyError()
+}
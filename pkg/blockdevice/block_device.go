@@ -2,7 +2,6 @@ package blockdevice
 
 import (
 	"io"
-	"os"
 )
 
 // BlockDevice is an interface for interacting with a block device like
@@ -23,11 +22,16 @@ import (
 // storage medium immediately. This can be problematic in case the order
 // of writes matters. The Sync() function can be used to block execution
 // until all previous writes are persisted.
+//
+// Discard() can be used to inform the storage medium that a region is
+// no longer in use, allowing it to reclaim the space (e.g., through
+// TRIM on SSDs, or by punching holes into regular files). Subsequent
+// reads of the region may either return zeros or its original
+// contents.
 type BlockDevice interface {
 	io.ReaderAt
 	io.WriterAt
 
 	Sync() error
+	Discard(offsetBytes, sizeBytes int64) error
 }
-
-var _ BlockDevice = (*os.File)(nil)
@@ -1,7 +1,9 @@
 package blockdevice
 
 import (
+	"github.com/buildbarn/bb-storage/pkg/clock"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blockdevice"
+	"github.com/buildbarn/bb-storage/pkg/util"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -14,12 +16,44 @@ func NewBlockDeviceFromConfiguration(configuration *pb.Configuration, mayZeroIni
 		return nil, 0, 0, status.Error(codes.InvalidArgument, "Block device configuration not specified")
 	}
 
+	var blockDevice BlockDevice
+	var sectorSizeBytes int
+	var sectorCount int64
+	var err error
+	directIO := configuration.DirectIo
 	switch source := configuration.Source.(type) {
 	case *pb.Configuration_DevicePath:
-		return NewBlockDeviceFromDevice(source.DevicePath)
+		if directIO == nil {
+			blockDevice, sectorSizeBytes, sectorCount, err = NewBlockDeviceFromDevice(source.DevicePath)
+		} else {
+			blockDevice, sectorSizeBytes, sectorCount, err = NewDirectIOBlockDeviceFromDevice(source.DevicePath, int(directIO.IoUringEntries), int(directIO.BufferSizeBytes))
+		}
 	case *pb.Configuration_File:
-		return NewBlockDeviceFromFile(source.File.Path, int(source.File.SizeBytes), mayZeroInitialize)
+		if directIO == nil {
+			blockDevice, sectorSizeBytes, sectorCount, err = NewBlockDeviceFromFile(source.File.Path, int(source.File.SizeBytes), mayZeroInitialize)
+		} else {
+			blockDevice, sectorSizeBytes, sectorCount, err = NewDirectIOBlockDeviceFromFile(source.File.Path, int(source.File.SizeBytes), mayZeroInitialize, int(directIO.IoUringEntries), int(directIO.BufferSizeBytes))
+		}
 	default:
 		return nil, 0, 0, status.Error(codes.InvalidArgument, "Configuration did not contain a supported block device source")
 	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	if !configuration.DiscardUnusedRegions {
+		blockDevice = newDiscardIgnoringBlockDevice(blockDevice)
+	}
+
+	if writeAggregation := configuration.WriteAggregation; writeAggregation != nil {
+		if err := writeAggregation.MaximumDelay.CheckValid(); err != nil {
+			return nil, 0, 0, util.StatusWrap(err, "Failed to obtain maximum write aggregation delay")
+		}
+		blockDevice = NewWriteAggregatingBlockDevice(
+			blockDevice,
+			clock.SystemClock,
+			writeAggregation.MaximumDelay.AsDuration(),
+			int(writeAggregation.MaximumSizeBytes))
+	}
+	return blockDevice, sectorSizeBytes, sectorCount, nil
 }
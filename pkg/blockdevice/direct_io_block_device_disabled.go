@@ -0,0 +1,23 @@
+// +build !linux
+
+package blockdevice
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewDirectIOBlockDeviceFromDevice creates a BlockDevice that is backed
+// by a device node, bypassing the page cache. This implementation is a
+// stub for operating systems that don't support O_DIRECT.
+func NewDirectIOBlockDeviceFromDevice(path string, ioURingEntries, bufferSizeBytes int) (BlockDevice, int, int64, error) {
+	return nil, 0, 0, status.Error(codes.Unimplemented, "Direct I/O is not supported on this platform")
+}
+
+// NewDirectIOBlockDeviceFromFile creates a BlockDevice that is backed
+// by a regular file stored on a file system, bypassing the page cache.
+// This implementation is a stub for operating systems that don't
+// support O_DIRECT.
+func NewDirectIOBlockDeviceFromFile(path string, minimumSizeBytes int, zeroInitialize bool, ioURingEntries, bufferSizeBytes int) (BlockDevice, int, int64, error) {
+	return nil, 0, 0, status.Error(codes.Unimplemented, "Direct I/O is not supported on this platform")
+}
@@ -0,0 +1,278 @@
+// +build linux
+
+package blockdevice
+
+import (
+	"io"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// directIOEngine is used by directIOBlockDevice to perform reads and
+// writes of sector aligned buffers against a file descriptor.
+type directIOEngine interface {
+	pread(fd int, p []byte, off int64) (int, error)
+	pwrite(fd int, p []byte, off int64) (int, error)
+}
+
+// systemCallDirectIOEngine performs reads and writes by calling
+// pread() and pwrite().
+type systemCallDirectIOEngine struct{}
+
+func (systemCallDirectIOEngine) pread(fd int, p []byte, off int64) (int, error) {
+	return unix.Pread(fd, p, off)
+}
+
+func (systemCallDirectIOEngine) pwrite(fd int, p []byte, off int64) (int, error) {
+	return unix.Pwrite(fd, p, off)
+}
+
+type directIOBlockDevice struct {
+	fd              int
+	isDevice        bool
+	engine          directIOEngine
+	sectorSizeBytes int64
+	sizeBytes       int64
+	bufferSizeBytes int64
+	buffers         sync.Pool
+
+	// Writes that don't start or end at a sector boundary need to
+	// read the sectors at their edges first. Serialize these, so
+	// that concurrent writes to the same sector don't get lost.
+	unalignedWriteLock sync.Mutex
+}
+
+// newDirectIOBlockDevice creates a BlockDevice from a file descriptor
+// that was opened with O_DIRECT. All data is transferred through
+// sector aligned buffers that are allocated from a pool, as O_DIRECT
+// requires I/O to be aligned both in memory and on disk.
+func newDirectIOBlockDevice(fd int, isDevice bool, sectorSizeBytes int, sizeBytes int64, ioURingEntries, bufferSizeBytes int) (BlockDevice, error) {
+	var engine directIOEngine = systemCallDirectIOEngine{}
+	if ioURingEntries > 0 {
+		var err error
+		engine, err = newIOURingDirectIOEngine(ioURingEntries)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	alignedBufferSizeBytes := (int64(bufferSizeBytes) + int64(sectorSizeBytes) - 1) / int64(sectorSizeBytes) * int64(sectorSizeBytes)
+	if alignedBufferSizeBytes <= 0 {
+		alignedBufferSizeBytes = int64(sectorSizeBytes)
+	}
+	return &directIOBlockDevice{
+		fd:              fd,
+		isDevice:        isDevice,
+		engine:          engine,
+		sectorSizeBytes: int64(sectorSizeBytes),
+		sizeBytes:       sizeBytes,
+		bufferSizeBytes: alignedBufferSizeBytes,
+		buffers: sync.Pool{
+			New: func() interface{} {
+				// Overallocate, so that the buffer can be
+				// aligned to the sector size in memory.
+				b := make([]byte, alignedBufferSizeBytes+int64(sectorSizeBytes))
+				offset := 0
+				if misalignment := int(uintptr(unsafe.Pointer(&b[0])) % uintptr(sectorSizeBytes)); misalignment != 0 {
+					offset = sectorSizeBytes - misalignment
+				}
+				b = b[offset : offset+int(alignedBufferSizeBytes)]
+				return &b
+			},
+		},
+	}, nil
+}
+
+// NewDirectIOBlockDeviceFromDevice creates a BlockDevice that is backed
+// by a device node, bypassing the page cache. Unlike
+// NewBlockDeviceFromDevice(), data is not memory mapped. Every read is
+// performed against the device, meaning that data is not cached by
+// the kernel in addition to caches maintained by the process.
+//
+// If ioURingEntries is nonzero, reads and writes are submitted through
+// an io_uring. Otherwise, pread() and pwrite() are used.
+func NewDirectIOBlockDeviceFromDevice(path string, ioURingEntries, bufferSizeBytes int) (BlockDevice, int, int64, error) {
+	fd, err := unix.Open(path, unix.O_RDWR|unix.O_DIRECT, 0)
+	if err != nil {
+		return nil, 0, 0, util.StatusWrapf(err, "Failed to open device node %#v", path)
+	}
+
+	sectorSizeBytes, deviceSizeBytes, err := getDeviceSize(fd, path)
+	if err != nil {
+		unix.Close(fd)
+		return nil, 0, 0, err
+	}
+	sectorCount := deviceSizeBytes / int64(sectorSizeBytes)
+
+	bd, err := newDirectIOBlockDevice(fd, true, sectorSizeBytes, int64(sectorSizeBytes)*sectorCount, ioURingEntries, bufferSizeBytes)
+	if err != nil {
+		unix.Close(fd)
+		return nil, 0, 0, err
+	}
+	return bd, sectorSizeBytes, sectorCount, nil
+}
+
+// NewDirectIOBlockDeviceFromFile creates a BlockDevice that is backed
+// by a regular file stored on a file system, bypassing the page cache.
+// The file system must support O_DIRECT.
+func NewDirectIOBlockDeviceFromFile(path string, minimumSizeBytes int, zeroInitialize bool, ioURingEntries, bufferSizeBytes int) (BlockDevice, int, int64, error) {
+	flags := unix.O_CREAT | unix.O_RDWR | unix.O_DIRECT
+	if zeroInitialize {
+		flags |= unix.O_TRUNC
+	}
+	fd, err := unix.Open(path, flags, 0o666)
+	if err != nil {
+		return nil, 0, 0, util.StatusWrapf(err, "Failed to open file %#v", path)
+	}
+
+	sectorSizeBytes, sectorCount, err := truncateFileToSectors(fd, path, minimumSizeBytes)
+	if err != nil {
+		unix.Close(fd)
+		return nil, 0, 0, err
+	}
+
+	bd, err := newDirectIOBlockDevice(fd, false, sectorSizeBytes, int64(sectorSizeBytes)*sectorCount, ioURingEntries, bufferSizeBytes)
+	if err != nil {
+		unix.Close(fd)
+		return nil, 0, 0, err
+	}
+	return bd, sectorSizeBytes, sectorCount, nil
+}
+
+// readFull reads an aligned region of the block device in its
+// entirety, retrying in case of short reads.
+func (bd *directIOBlockDevice) readFull(p []byte, off int64) error {
+	for len(p) > 0 {
+		n, err := bd.engine.pread(bd.fd, p, off)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		p = p[n:]
+		off += int64(n)
+	}
+	return nil
+}
+
+// writeFull writes an aligned region of the block device in its
+// entirety, retrying in case of short writes.
+func (bd *directIOBlockDevice) writeFull(p []byte, off int64) error {
+	for len(p) > 0 {
+		n, err := bd.engine.pwrite(bd.fd, p, off)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		p = p[n:]
+		off += int64(n)
+	}
+	return nil
+}
+
+// getAlignedRegion returns the sector aligned region of the block
+// device that needs to be transferred through a single buffer to
+// access data starting at a given offset, up to a given end offset.
+func (bd *directIOBlockDevice) getAlignedRegion(off, end int64) (int64, int64) {
+	alignedStart := off - off%bd.sectorSizeBytes
+	alignedEnd := (end + bd.sectorSizeBytes - 1) / bd.sectorSizeBytes * bd.sectorSizeBytes
+	if alignedEnd > alignedStart+bd.bufferSizeBytes {
+		alignedEnd = alignedStart + bd.bufferSizeBytes
+	}
+	return alignedStart, alignedEnd
+}
+
+func (bd *directIOBlockDevice) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, syscall.EINVAL
+	}
+	end := off + int64(len(p))
+	if end > bd.sizeBytes {
+		end = bd.sizeBytes
+	}
+
+	buffer := bd.buffers.Get().(*[]byte)
+	defer bd.buffers.Put(buffer)
+
+	n := 0
+	for position := off; position < end; {
+		alignedStart, alignedEnd := bd.getAlignedRegion(position, end)
+		b := (*buffer)[:alignedEnd-alignedStart]
+		if err := bd.readFull(b, alignedStart); err != nil {
+			return n, err
+		}
+		chunkEnd := end
+		if chunkEnd > alignedEnd {
+			chunkEnd = alignedEnd
+		}
+		n += copy(p[position-off:], b[position-alignedStart:chunkEnd-alignedStart])
+		position = chunkEnd
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (bd *directIOBlockDevice) WriteAt(p []byte, off int64) (int, error) {
+	end := off + int64(len(p))
+	if off < 0 || end > bd.sizeBytes {
+		return 0, status.Errorf(codes.InvalidArgument, "Cannot write %d bytes at offset %d, as the block device is %d bytes in size", len(p), off, bd.sizeBytes)
+	}
+	if off%bd.sectorSizeBytes != 0 || end%bd.sectorSizeBytes != 0 {
+		bd.unalignedWriteLock.Lock()
+		defer bd.unalignedWriteLock.Unlock()
+	}
+
+	buffer := bd.buffers.Get().(*[]byte)
+	defer bd.buffers.Put(buffer)
+
+	n := 0
+	for position := off; position < end; {
+		alignedStart, alignedEnd := bd.getAlignedRegion(position, end)
+		b := (*buffer)[:alignedEnd-alignedStart]
+		chunkEnd := end
+		if chunkEnd > alignedEnd {
+			chunkEnd = alignedEnd
+		}
+
+		// Preserve the existing contents of sectors that are
+		// only partially overwritten.
+		if position != alignedStart {
+			if err := bd.readFull(b[:bd.sectorSizeBytes], alignedStart); err != nil {
+				return n, err
+			}
+		}
+		if lastSectorStart := alignedEnd - bd.sectorSizeBytes; chunkEnd != alignedEnd && (lastSectorStart != alignedStart || position == alignedStart) {
+			if err := bd.readFull(b[lastSectorStart-alignedStart:], lastSectorStart); err != nil {
+				return n, err
+			}
+		}
+
+		copy(b[position-alignedStart:], p[position-off:chunkEnd-off])
+		if err := bd.writeFull(b, alignedStart); err != nil {
+			return n, err
+		}
+		n += int(chunkEnd - position)
+		position = chunkEnd
+	}
+	return n, nil
+}
+
+func (bd *directIOBlockDevice) Sync() error {
+	return unix.Fsync(bd.fd)
+}
+
+func (bd *directIOBlockDevice) Discard(offsetBytes, sizeBytes int64) error {
+	return discardRegion(bd.fd, bd.isDevice, offsetBytes, sizeBytes)
+}
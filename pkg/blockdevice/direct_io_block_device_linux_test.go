@@ -0,0 +1,84 @@
+// +build linux
+
+package blockdevice_test
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/blockdevice"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testDirectIOBlockDevice(t *testing.T, ioURingEntries int) {
+	blockDevicePath := filepath.Join(t.TempDir(), "blockdevice")
+	blockDevice, sectorSizeBytes, sectorCount, err := blockdevice.NewDirectIOBlockDeviceFromFile(blockDevicePath, 123456, true, ioURingEntries, 1)
+	require.NoError(t, err)
+	require.Equal(t, int64((123456+sectorSizeBytes-1)/sectorSizeBytes), sectorCount)
+	sizeBytes := int64(sectorSizeBytes) * sectorCount
+
+	t.Run("UnalignedWrite", func(t *testing.T) {
+		// Writes that only partially cover sectors should
+		// preserve the existing contents of those sectors.
+		// Writes spanning multiple sectors should be split up,
+		// as the buffer size is rounded up to a single sector.
+		data := bytes.Repeat([]byte("Hello"), sectorSizeBytes)
+		n, err := blockDevice.WriteAt(data, 12345)
+		require.Equal(t, len(data), n)
+		require.NoError(t, err)
+
+		n, err = blockDevice.WriteAt([]byte("World"), 12340)
+		require.Equal(t, 5, n)
+		require.NoError(t, err)
+
+		b := make([]byte, len(data)+10)
+		n, err = blockDevice.ReadAt(b, 12335)
+		require.Equal(t, len(b), n)
+		require.NoError(t, err)
+		require.Equal(t, append([]byte("\x00\x00\x00\x00\x00World"), data...), b)
+
+		require.NoError(t, blockDevice.Sync())
+	})
+
+	t.Run("Discard", func(t *testing.T) {
+		// Discarding a region of a regular file should punch a
+		// hole into it, causing zeros to be returned.
+		n, err := blockDevice.WriteAt([]byte("Hello"), 0)
+		require.Equal(t, 5, n)
+		require.NoError(t, err)
+		require.NoError(t, blockDevice.Discard(0, int64(sectorSizeBytes)))
+
+		var b [5]byte
+		n, err = blockDevice.ReadAt(b[:], 0)
+		require.Equal(t, 5, n)
+		require.NoError(t, err)
+		require.Equal(t, []byte("\x00\x00\x00\x00\x00"), b[:])
+	})
+
+	t.Run("ReadPastEnd", func(t *testing.T) {
+		var b [16]byte
+		n, err := blockDevice.ReadAt(b[:], sizeBytes-5)
+		require.Equal(t, 5, n)
+		require.Equal(t, io.EOF, err)
+	})
+
+	t.Run("WritePastEnd", func(t *testing.T) {
+		_, err := blockDevice.WriteAt([]byte("Hello"), sizeBytes-4)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestNewDirectIOBlockDeviceFromFile(t *testing.T) {
+	t.Run("SystemCalls", func(t *testing.T) {
+		testDirectIOBlockDevice(t, 0)
+	})
+
+	t.Run("IOURing", func(t *testing.T) {
+		testDirectIOBlockDevice(t, 8)
+	})
+}
@@ -0,0 +1,15 @@
+// +build darwin freebsd
+
+package blockdevice
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// discardRegion informs the storage medium that a region of a device
+// node or regular file is no longer in use. This implementation is a
+// stub for operating systems on which this is not supported.
+func discardRegion(fd int, isDevice bool, offsetBytes, sizeBytes int64) error {
+	return status.Error(codes.Unimplemented, "Discarding regions of block devices is not supported on this platform")
+}
@@ -0,0 +1,19 @@
+package blockdevice
+
+type discardIgnoringBlockDevice struct {
+	BlockDevice
+}
+
+// newDiscardIgnoringBlockDevice creates a decorator for BlockDevice
+// that turns calls to Discard() into no-ops. This is used to disable
+// discarding for block devices for which it has not been enabled
+// explicitly.
+func newDiscardIgnoringBlockDevice(base BlockDevice) BlockDevice {
+	return discardIgnoringBlockDevice{
+		BlockDevice: base,
+	}
+}
+
+func (bd discardIgnoringBlockDevice) Discard(offsetBytes, sizeBytes int64) error {
+	return nil
+}
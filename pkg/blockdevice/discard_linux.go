@@ -0,0 +1,29 @@
+// +build linux
+
+package blockdevice
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// blkDiscard is the BLKDISCARD ioctl, declared in <linux/fs.h>. It is
+// not provided by golang.org/x/sys/unix.
+const blkDiscard = 0x1277
+
+// discardRegion informs the storage medium that a region of a device
+// node or regular file is no longer in use. For device nodes,
+// BLKDISCARD is used, causing SSDs and thinly provisioned volumes to
+// reclaim the space. For regular files, a hole is punched into the
+// file, causing the file system to reclaim the space.
+func discardRegion(fd int, isDevice bool, offsetBytes, sizeBytes int64) error {
+	if isDevice {
+		region := [2]uint64{uint64(offsetBytes), uint64(sizeBytes)}
+		if _, _, err := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), blkDiscard, uintptr(unsafe.Pointer(&region))); err != 0 {
+			return err
+		}
+		return nil
+	}
+	return unix.Fallocate(fd, unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, offsetBytes, sizeBytes)
+}
@@ -0,0 +1,293 @@
+// +build linux
+
+package blockdevice
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"golang.org/x/sys/unix"
+)
+
+// Constants and data structures declared in <linux/io_uring.h>.
+const (
+	ioURingOffSQRing = 0
+	ioURingOffCQRing = 0x8000000
+	ioURingOffSQEs   = 0x10000000
+
+	ioURingEnterGetEvents = 1
+
+	ioURingOpRead  = 22
+	ioURingOpWrite = 23
+)
+
+type ioURingSQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	flags       uint32
+	dropped     uint32
+	array       uint32
+	resv1       uint32
+	resv2       uint64
+}
+
+type ioURingCQRingOffsets struct {
+	head        uint32
+	tail        uint32
+	ringMask    uint32
+	ringEntries uint32
+	overflow    uint32
+	cqes        uint32
+	flags       uint32
+	resv1       uint32
+	resv2       uint64
+}
+
+type ioURingParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	resv         [3]uint32
+	sqOff        ioURingSQRingOffsets
+	cqOff        ioURingCQRingOffsets
+}
+
+type ioURingSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	rwFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFDIn  int32
+	pad2        [2]uint64
+}
+
+type ioURingCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// ioURingDirectIOEngine is a directIOEngine that submits reads and
+// writes through an io_uring. Compared to calling pread() and pwrite(),
+// this reduces the number of threads that are blocked in system calls
+// when many operations are performed concurrently.
+//
+// Every operation in flight is assigned a slot, whose index is stored
+// in the submission queue entry. A single goroutine waits for
+// completions and forwards results to the goroutines that submitted
+// the operations.
+//
+// If a system call against the io_uring fails, it is no longer known
+// which operations have been submitted. The engine is then marked as
+// failed, causing all pending and future operations to fail.
+type ioURingDirectIOEngine struct {
+	fd int
+
+	submissionLock sync.Mutex
+	sqTail         *uint32
+	sqMask         uint32
+	sqArray        []uint32
+	sqes           []ioURingSQE
+
+	cqHead *uint32
+	cqTail *uint32
+	cqMask uint32
+	cqes   []ioURingCQE
+
+	freeSlots chan int
+	results   []chan int32
+
+	failOnce   sync.Once
+	failed     chan struct{}
+	failureErr error
+
+	// Buffers of operations that were still in flight when the
+	// engine failed. The kernel may still access these, meaning
+	// they must not be garbage collected.
+	abandonedBuffersLock sync.Mutex
+	abandonedBuffers     [][]byte
+}
+
+func newIOURingDirectIOEngine(entries int) (directIOEngine, error) {
+	var params ioURingParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, util.StatusWrap(errno, "Failed to set up io_uring")
+	}
+
+	sqRing, err := unix.Mmap(int(fd), ioURingOffSQRing, int(params.sqOff.array+params.sqEntries*4), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		unix.Close(int(fd))
+		return nil, util.StatusWrap(err, "Failed to memory map io_uring submission queue")
+	}
+	cqRing, err := unix.Mmap(int(fd), ioURingOffCQRing, int(params.cqOff.cqes+params.cqEntries*uint32(unsafe.Sizeof(ioURingCQE{}))), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		unix.Munmap(sqRing)
+		unix.Close(int(fd))
+		return nil, util.StatusWrap(err, "Failed to memory map io_uring completion queue")
+	}
+	sqesData, err := unix.Mmap(int(fd), ioURingOffSQEs, int(params.sqEntries*uint32(unsafe.Sizeof(ioURingSQE{}))), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		unix.Munmap(cqRing)
+		unix.Munmap(sqRing)
+		unix.Close(int(fd))
+		return nil, util.StatusWrap(err, "Failed to memory map io_uring submission queue entries")
+	}
+
+	// Limit the number of operations in flight to the size of the
+	// submission queue. The completion queue is at least as large,
+	// meaning that it can never overflow.
+	slotsCount := int(params.sqEntries)
+	e := &ioURingDirectIOEngine{
+		fd: int(fd),
+
+		sqTail:  (*uint32)(unsafe.Pointer(&sqRing[params.sqOff.tail])),
+		sqMask:  *(*uint32)(unsafe.Pointer(&sqRing[params.sqOff.ringMask])),
+		sqArray: (*[1 << 28]uint32)(unsafe.Pointer(&sqRing[params.sqOff.array]))[:params.sqEntries:params.sqEntries],
+		sqes:    (*[1 << 24]ioURingSQE)(unsafe.Pointer(&sqesData[0]))[:params.sqEntries:params.sqEntries],
+
+		cqHead: (*uint32)(unsafe.Pointer(&cqRing[params.cqOff.head])),
+		cqTail: (*uint32)(unsafe.Pointer(&cqRing[params.cqOff.tail])),
+		cqMask: *(*uint32)(unsafe.Pointer(&cqRing[params.cqOff.ringMask])),
+		cqes:   (*[1 << 24]ioURingCQE)(unsafe.Pointer(&cqRing[params.cqOff.cqes]))[:params.cqEntries:params.cqEntries],
+
+		freeSlots: make(chan int, slotsCount),
+		results:   make([]chan int32, slotsCount),
+		failed:    make(chan struct{}),
+	}
+	for i := 0; i < slotsCount; i++ {
+		e.freeSlots <- i
+		e.results[i] = make(chan int32, 1)
+	}
+	go e.processCompletions()
+	return e, nil
+}
+
+// fail marks the engine as failed. Only the first error is retained.
+func (e *ioURingDirectIOEngine) fail(err error) {
+	e.failOnce.Do(func() {
+		e.failureErr = err
+		close(e.failed)
+	})
+}
+
+// abandonBuffer retains the buffer of an operation that may still be
+// in flight after the engine failed.
+func (e *ioURingDirectIOEngine) abandonBuffer(p []byte) {
+	e.abandonedBuffersLock.Lock()
+	e.abandonedBuffers = append(e.abandonedBuffers, p)
+	e.abandonedBuffersLock.Unlock()
+}
+
+// processCompletions waits for operations to complete, and forwards
+// their results to the goroutines that submitted them.
+func (e *ioURingDirectIOEngine) processCompletions() {
+	for {
+		if _, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(e.fd), 0, 1, ioURingEnterGetEvents, 0, 0); errno != 0 && errno != syscall.EINTR {
+			e.fail(util.StatusWrap(errno, "Failed to wait for io_uring completions"))
+			return
+		}
+		head := atomic.LoadUint32(e.cqHead)
+		tail := atomic.LoadUint32(e.cqTail)
+		for ; head != tail; head++ {
+			cqe := &e.cqes[head&e.cqMask]
+			e.results[cqe.userData] <- cqe.res
+		}
+		atomic.StoreUint32(e.cqHead, head)
+	}
+}
+
+func (e *ioURingDirectIOEngine) submit(opcode uint8, fd int, p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var slot int
+	select {
+	case slot = <-e.freeSlots:
+	case <-e.failed:
+		return 0, e.failureErr
+	}
+
+	e.submissionLock.Lock()
+	// Don't add entries to the submission queue after a previous
+	// submission failed, as it is unknown whether the kernel has
+	// consumed the entries preceding it.
+	select {
+	case <-e.failed:
+		e.submissionLock.Unlock()
+		e.freeSlots <- slot
+		return 0, e.failureErr
+	default:
+	}
+	tail := *e.sqTail
+	index := tail & e.sqMask
+	e.sqes[index] = ioURingSQE{
+		opcode:   opcode,
+		fd:       int32(fd),
+		off:      uint64(off),
+		addr:     uint64(uintptr(unsafe.Pointer(&p[0]))),
+		len:      uint32(len(p)),
+		userData: uint64(slot),
+	}
+	e.sqArray[index] = index
+	atomic.StoreUint32(e.sqTail, tail+1)
+	for {
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(e.fd), 1, 0, 0, 0, 0)
+		if errno == 0 {
+			break
+		} else if errno != syscall.EINTR && errno != syscall.EAGAIN && errno != syscall.EBUSY {
+			// The entry has already been added to the
+			// submission queue, meaning it cannot be
+			// retracted. Continuing would cause results
+			// to be associated with the wrong operations.
+			e.fail(util.StatusWrap(errno, "Failed to submit to io_uring"))
+			e.submissionLock.Unlock()
+			e.abandonBuffer(p)
+			return 0, e.failureErr
+		}
+		runtime.Gosched()
+	}
+	e.submissionLock.Unlock()
+
+	select {
+	case res := <-e.results[slot]:
+		// Keep the buffer alive until the kernel is done with it.
+		runtime.KeepAlive(p)
+		e.freeSlots <- slot
+		if res < 0 {
+			return 0, syscall.Errno(-res)
+		}
+		return int(res), nil
+	case <-e.failed:
+		// Completions are no longer processed, meaning it is
+		// unknown whether the kernel is done with the buffer.
+		e.abandonBuffer(p)
+		return 0, e.failureErr
+	}
+}
+
+func (e *ioURingDirectIOEngine) pread(fd int, p []byte, off int64) (int, error) {
+	return e.submit(ioURingOpRead, fd, p, off)
+}
+
+func (e *ioURingDirectIOEngine) pwrite(fd int, p []byte, off int64) (int, error) {
+	return e.submit(ioURingOpWrite, fd, p, off)
+}
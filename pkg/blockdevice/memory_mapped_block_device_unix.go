@@ -15,21 +15,23 @@ import (
 )
 
 type memoryMappedBlockDevice struct {
-	fd   int
-	data []byte
+	fd       int
+	isDevice bool
+	data     []byte
 }
 
 // newMemoryMappedBlockDevice creates a BlockDevice from a file
 // descriptor referring either to a regular file or UNIX device node. To
 // speed up reads, a memory map is used.
-func newMemoryMappedBlockDevice(fd, sizeBytes int) (BlockDevice, error) {
+func newMemoryMappedBlockDevice(fd, sizeBytes int, isDevice bool) (BlockDevice, error) {
 	data, err := unix.Mmap(fd, 0, sizeBytes, syscall.PROT_READ, syscall.MAP_SHARED)
 	if err != nil {
 		return nil, util.StatusWrap(err, "Failed to memory map block device")
 	}
 	return &memoryMappedBlockDevice{
-		fd:   fd,
-		data: data,
+		fd:       fd,
+		isDevice: isDevice,
+		data:     data,
 	}, nil
 }
 
@@ -74,3 +76,7 @@ func (bd *memoryMappedBlockDevice) WriteAt(p []byte, off int64) (int, error) {
 func (bd *memoryMappedBlockDevice) Sync() error {
 	return unix.Fsync(bd.fd)
 }
+
+func (bd *memoryMappedBlockDevice) Discard(offsetBytes, sizeBytes int64) error {
+	return discardRegion(bd.fd, bd.isDevice, offsetBytes, sizeBytes)
+}
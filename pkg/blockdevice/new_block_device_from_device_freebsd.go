@@ -38,7 +38,7 @@ func NewBlockDeviceFromDevice(path string) (BlockDevice, int, int64, error) {
 		return nil, 0, 0, util.StatusWrapf(err, "Failed to obtain media size of device node %#v", path)
 	}
 
-	bd, err := newMemoryMappedBlockDevice(fd, int(deviceSizeBytes))
+	bd, err := newMemoryMappedBlockDevice(fd, int(deviceSizeBytes), true)
 	if err != nil {
 		unix.Close(fd)
 		return nil, 0, 0, err
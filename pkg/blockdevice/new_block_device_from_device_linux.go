@@ -26,22 +26,30 @@ func NewBlockDeviceFromDevice(path string) (BlockDevice, int, int64, error) {
 		return nil, 0, 0, util.StatusWrapf(err, "Failed to open device node %#v", path)
 	}
 
-	// Obtain the size of the device and its individual sectors.
-	var sectorSizeBytes int32
-	if _, _, err := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.BLKBSZGET, uintptr(unsafe.Pointer(&sectorSizeBytes))); err != 0 {
-		unix.Close(fd)
-		return nil, 0, 0, util.StatusWrapf(err, "Failed to obtain block size of device node %#v", path)
-	}
-	var deviceSizeBytes int64
-	if _, _, err := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.BLKGETSIZE64, uintptr(unsafe.Pointer(&deviceSizeBytes))); err != 0 {
+	sectorSizeBytes, deviceSizeBytes, err := getDeviceSize(fd, path)
+	if err != nil {
 		unix.Close(fd)
-		return nil, 0, 0, util.StatusWrapf(err, "Failed to obtain size of device node %#v", path)
+		return nil, 0, 0, err
 	}
 
-	bd, err := newMemoryMappedBlockDevice(fd, int(deviceSizeBytes))
+	bd, err := newMemoryMappedBlockDevice(fd, int(deviceSizeBytes), true)
 	if err != nil {
 		unix.Close(fd)
 		return nil, 0, 0, err
 	}
-	return bd, int(sectorSizeBytes), deviceSizeBytes / int64(sectorSizeBytes), nil
+	return bd, sectorSizeBytes, deviceSizeBytes / int64(sectorSizeBytes), nil
+}
+
+// getDeviceSize obtains the size of a device node and its individual
+// sectors.
+func getDeviceSize(fd int, path string) (int, int64, error) {
+	var sectorSizeBytes int32
+	if _, _, err := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.BLKBSZGET, uintptr(unsafe.Pointer(&sectorSizeBytes))); err != 0 {
+		return 0, 0, util.StatusWrapf(err, "Failed to obtain block size of device node %#v", path)
+	}
+	var deviceSizeBytes int64
+	if _, _, err := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.BLKGETSIZE64, uintptr(unsafe.Pointer(&deviceSizeBytes))); err != 0 {
+		return 0, 0, util.StatusWrapf(err, "Failed to obtain size of device node %#v", path)
+	}
+	return int(sectorSizeBytes), deviceSizeBytes, nil
 }
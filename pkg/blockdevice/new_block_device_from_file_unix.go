@@ -25,25 +25,34 @@ func NewBlockDeviceFromFile(path string, minimumSizeBytes int, zeroInitialize bo
 		return nil, 0, 0, util.StatusWrapf(err, "Failed to open file %#v", path)
 	}
 
-	// Use the block size returned by fstat() to determine the
-	// sector size and the number of sectors needed to store the
-	// desired amount of space.
+	sectorSizeBytes, sectorCount, err := truncateFileToSectors(fd, path, minimumSizeBytes)
+	if err != nil {
+		unix.Close(fd)
+		return nil, 0, 0, err
+	}
+
+	bd, err := newMemoryMappedBlockDevice(fd, int(int64(sectorSizeBytes)*sectorCount), false)
+	if err != nil {
+		unix.Close(fd)
+		return nil, 0, 0, err
+	}
+	return bd, sectorSizeBytes, sectorCount, nil
+}
+
+// truncateFileToSectors uses the block size returned by fstat() to
+// determine the sector size and the number of sectors needed to store
+// the desired amount of space. The file is resized accordingly.
+func truncateFileToSectors(fd int, path string, minimumSizeBytes int) (int, int64, error) {
 	var stat unix.Stat_t
 	if err := unix.Fstat(fd, &stat); err != nil {
-		return nil, 0, 0, util.StatusWrapf(err, "Failed to obtain size of file %#v", path)
+		return 0, 0, util.StatusWrapf(err, "Failed to obtain size of file %#v", path)
 	}
 	sectorSizeBytes := int(stat.Blksize)
 	sectorCount := int64((uint64(minimumSizeBytes) + uint64(stat.Blksize) - 1) / uint64(stat.Blksize))
 	sizeBytes := int64(sectorSizeBytes) * sectorCount
 
 	if err := unix.Ftruncate(fd, sizeBytes); err != nil {
-		return nil, 0, 0, util.StatusWrapf(err, "Failed to truncate file %#v to %d bytes", path, sizeBytes)
+		return 0, 0, util.StatusWrapf(err, "Failed to truncate file %#v to %d bytes", path, sizeBytes)
 	}
-
-	bd, err := newMemoryMappedBlockDevice(fd, int(sizeBytes))
-	if err != nil {
-		unix.Close(fd)
-		return nil, 0, 0, err
-	}
-	return bd, sectorSizeBytes, sectorCount, nil
+	return sectorSizeBytes, sectorCount, nil
 }
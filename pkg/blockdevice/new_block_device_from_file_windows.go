@@ -0,0 +1,83 @@
+// +build windows
+
+package blockdevice
+
+import (
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"golang.org/x/sys/windows"
+)
+
+var procGetDiskFreeSpaceW = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetDiskFreeSpaceW")
+
+// getSectorSizeBytes returns the size of the sectors of the volume on
+// which a file is stored.
+func getSectorSizeBytes(path string) (int, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	absPathPtr, err := windows.UTF16PtrFromString(absPath)
+	if err != nil {
+		return 0, err
+	}
+	var volumePath [windows.MAX_PATH + 1]uint16
+	if err := windows.GetVolumePathName(absPathPtr, &volumePath[0], uint32(len(volumePath))); err != nil {
+		return 0, err
+	}
+
+	var sectorsPerCluster, bytesPerSector, numberOfFreeClusters, totalNumberOfClusters uint32
+	if r, _, err := procGetDiskFreeSpaceW.Call(
+		uintptr(unsafe.Pointer(&volumePath[0])),
+		uintptr(unsafe.Pointer(&sectorsPerCluster)),
+		uintptr(unsafe.Pointer(&bytesPerSector)),
+		uintptr(unsafe.Pointer(&numberOfFreeClusters)),
+		uintptr(unsafe.Pointer(&totalNumberOfClusters))); r == 0 {
+		return 0, err
+	}
+	return int(bytesPerSector), nil
+}
+
+// NewBlockDeviceFromFile creates a BlockDevice that is backed by a
+// regular file stored in a file system.
+//
+// This approach tends to have more overhead than BlockDevices created
+// using NewBlockDeviceFromDevice, but is often easier to set up in
+// environments where spare disks (or the privileges needed to access
+// those) aren't readily available.
+func NewBlockDeviceFromFile(path string, minimumSizeBytes int, zeroInitialize bool) (BlockDevice, int, int64, error) {
+	flags := os.O_CREATE | os.O_RDWR
+	if zeroInitialize {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0o666)
+	if err != nil {
+		return nil, 0, 0, util.StatusWrapf(err, "Failed to open file %#v", path)
+	}
+
+	// Windows does not report a preferred block size for files.
+	// Use the sector size of the underlying volume instead.
+	sectorSizeBytes, err := getSectorSizeBytes(path)
+	if err != nil {
+		f.Close()
+		return nil, 0, 0, util.StatusWrapf(err, "Failed to obtain sector size of volume containing file %#v", path)
+	}
+	sectorCount := int64((uint64(minimumSizeBytes) + uint64(sectorSizeBytes) - 1) / uint64(sectorSizeBytes))
+	sizeBytes := int64(sectorSizeBytes) * sectorCount
+
+	if err := f.Truncate(sizeBytes); err != nil {
+		f.Close()
+		return nil, 0, 0, util.StatusWrapf(err, "Failed to truncate file %#v to %d bytes", path, sizeBytes)
+	}
+
+	bd, err := newMemoryMappedBlockDevice(f, int(sizeBytes))
+	if err != nil {
+		f.Close()
+		return nil, 0, 0, err
+	}
+	return bd, sectorSizeBytes, sectorCount, nil
+}
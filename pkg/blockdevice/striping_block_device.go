@@ -0,0 +1,108 @@
+package blockdevice
+
+import (
+	"io"
+)
+
+type stripingBlockDevice struct {
+	blockDevices    []BlockDevice
+	stripeSizeBytes int64
+}
+
+// NewStripingBlockDevice creates a BlockDevice that combines the
+// capacity of multiple underlying block devices. The address space is
+// partitioned into stripes of a fixed size, which are assigned to the
+// underlying block devices in round-robin order. Stripe i is thus
+// stored on block device i % n, at offset i / n * stripeSizeBytes.
+//
+// By setting the stripe size to a multiple of the block size used by
+// LocalBlobAccess, every block is stored on a single block device,
+// while consecutively allocated blocks are spread out across all block
+// devices. This causes I/O to be distributed evenly.
+func NewStripingBlockDevice(blockDevices []BlockDevice, stripeSizeBytes int64) BlockDevice {
+	return &stripingBlockDevice{
+		blockDevices:    blockDevices,
+		stripeSizeBytes: stripeSizeBytes,
+	}
+}
+
+// forEachStripe splits up an operation against the address space into
+// operations against the underlying block devices, each of which is
+// contained within a single stripe. errShort is returned if an
+// underlying block device processes less data than requested without
+// returning an error.
+func (bd *stripingBlockDevice) forEachStripe(p []byte, off int64, errShort error, f func(blockDevice BlockDevice, p []byte, off int64) (int, error)) (int, error) {
+	nTotal := 0
+	for len(p) > 0 {
+		stripe := off / bd.stripeSizeBytes
+		offsetInStripe := off % bd.stripeSizeBytes
+		chunkSize := bd.stripeSizeBytes - offsetInStripe
+		if chunkSize > int64(len(p)) {
+			chunkSize = int64(len(p))
+		}
+		blockDeviceCount := int64(len(bd.blockDevices))
+		n, err := f(
+			bd.blockDevices[stripe%blockDeviceCount],
+			p[:chunkSize],
+			stripe/blockDeviceCount*bd.stripeSizeBytes+offsetInStripe)
+		nTotal += n
+		if err != nil {
+			return nTotal, err
+		}
+		if int64(n) < chunkSize {
+			return nTotal, errShort
+		}
+		p = p[chunkSize:]
+		off += chunkSize
+	}
+	return nTotal, nil
+}
+
+func (bd *stripingBlockDevice) ReadAt(p []byte, off int64) (int, error) {
+	return bd.forEachStripe(p, off, io.ErrUnexpectedEOF, func(blockDevice BlockDevice, p []byte, off int64) (int, error) {
+		n, err := blockDevice.ReadAt(p, off)
+		if err == io.EOF && n == len(p) {
+			// Reaching the end of one of the underlying block
+			// devices does not imply that the end of the
+			// address space has been reached.
+			err = nil
+		}
+		return n, err
+	})
+}
+
+func (bd *stripingBlockDevice) WriteAt(p []byte, off int64) (int, error) {
+	return bd.forEachStripe(p, off, io.ErrShortWrite, func(blockDevice BlockDevice, p []byte, off int64) (int, error) {
+		return blockDevice.WriteAt(p, off)
+	})
+}
+
+func (bd *stripingBlockDevice) Sync() error {
+	for _, blockDevice := range bd.blockDevices {
+		if err := blockDevice.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bd *stripingBlockDevice) Discard(offsetBytes, sizeBytes int64) error {
+	// Split up the region in the same way as forEachStripe().
+	blockDeviceCount := int64(len(bd.blockDevices))
+	for sizeBytes > 0 {
+		stripe := offsetBytes / bd.stripeSizeBytes
+		offsetInStripe := offsetBytes % bd.stripeSizeBytes
+		chunkSize := bd.stripeSizeBytes - offsetInStripe
+		if chunkSize > sizeBytes {
+			chunkSize = sizeBytes
+		}
+		if err := bd.blockDevices[stripe%blockDeviceCount].Discard(
+			stripe/blockDeviceCount*bd.stripeSizeBytes+offsetInStripe,
+			chunkSize); err != nil {
+			return err
+		}
+		offsetBytes += chunkSize
+		sizeBytes -= chunkSize
+	}
+	return nil
+}
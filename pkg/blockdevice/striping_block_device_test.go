@@ -0,0 +1,80 @@
+package blockdevice_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blockdevice"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStripingBlockDevice(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	baseBlockDevice0 := mock.NewMockBlockDevice(ctrl)
+	baseBlockDevice1 := mock.NewMockBlockDevice(ctrl)
+	blockDevice := blockdevice.NewStripingBlockDevice(
+		[]blockdevice.BlockDevice{baseBlockDevice0, baseBlockDevice1},
+		10)
+
+	t.Run("WriteWithinStripe", func(t *testing.T) {
+		// Stripe 3 is the second stripe of the second block
+		// device.
+		baseBlockDevice1.EXPECT().WriteAt([]byte("Hello"), int64(12)).Return(5, nil)
+
+		n, err := blockDevice.WriteAt([]byte("Hello"), 32)
+		require.NoError(t, err)
+		require.Equal(t, 5, n)
+	})
+
+	t.Run("ReadAcrossStripes", func(t *testing.T) {
+		// Reads that cross stripe boundaries should be split up.
+		baseBlockDevice0.EXPECT().ReadAt(gomock.Len(3), int64(17)).DoAndReturn(
+			func(p []byte, off int64) (int, error) {
+				return copy(p, "Hel"), nil
+			})
+		baseBlockDevice1.EXPECT().ReadAt(gomock.Len(8), int64(10)).DoAndReturn(
+			func(p []byte, off int64) (int, error) {
+				return copy(p, "lo world"), nil
+			})
+
+		p := make([]byte, 11)
+		n, err := blockDevice.ReadAt(p, 27)
+		require.NoError(t, err)
+		require.Equal(t, 11, n)
+		require.Equal(t, []byte("Hello world"), p)
+	})
+
+	t.Run("WriteFailure", func(t *testing.T) {
+		// Errors should be propagated, together with the amount
+		// of data that was written successfully.
+		baseBlockDevice0.EXPECT().WriteAt([]byte("Hel"), int64(17)).Return(3, nil)
+		baseBlockDevice1.EXPECT().WriteAt([]byte("lo world"), int64(10)).Return(2, status.Error(codes.Internal, "Disk on fire"))
+
+		n, err := blockDevice.WriteAt([]byte("Hello world"), 27)
+		require.Equal(t, status.Error(codes.Internal, "Disk on fire"), err)
+		require.Equal(t, 5, n)
+	})
+
+	t.Run("Sync", func(t *testing.T) {
+		baseBlockDevice0.EXPECT().Sync()
+		baseBlockDevice1.EXPECT().Sync()
+
+		require.NoError(t, blockDevice.Sync())
+	})
+
+	t.Run("Discard", func(t *testing.T) {
+		// Discarded regions should be split up along stripe
+		// boundaries.
+		baseBlockDevice0.EXPECT().Discard(int64(17), int64(3))
+		baseBlockDevice1.EXPECT().Discard(int64(10), int64(10))
+		baseBlockDevice0.EXPECT().Discard(int64(20), int64(10))
+		baseBlockDevice1.EXPECT().Discard(int64(20), int64(2))
+
+		require.NoError(t, blockDevice.Discard(27, 25))
+	})
+}
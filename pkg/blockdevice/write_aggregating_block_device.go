@@ -0,0 +1,167 @@
+package blockdevice
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	writeAggregatingBlockDevicePrometheusMetrics sync.Once
+
+	writeAggregatingBlockDeviceWritesPerDeviceWrite = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "buildbarn",
+			Subsystem: "blockdevice",
+			Name:      "write_aggregating_block_device_writes_per_device_write",
+			Help:      "Number of calls to WriteAt() that were combined into a single write against the underlying block device",
+			Buckets:   prometheus.ExponentialBuckets(1.0, 2.0, 10),
+		})
+)
+
+type pendingWrite struct {
+	p   []byte
+	off int64
+	n   int
+	err error
+}
+
+type writeAggregatingBatch struct {
+	writes    []*pendingWrite
+	sizeBytes int
+	done      chan struct{}
+}
+
+type writeAggregatingBlockDevice struct {
+	BlockDevice
+
+	clock            clock.Clock
+	maximumDelay     time.Duration
+	maximumSizeBytes int
+
+	lock         sync.Mutex
+	currentBatch *writeAggregatingBatch
+}
+
+// NewWriteAggregatingBlockDevice creates a decorator for BlockDevice
+// that combines small writes that are performed concurrently into
+// larger ones. Writes against adjacent regions of the block device are
+// submitted to the underlying block device as a single write. This
+// reduces the number of I/O operations performed when many small
+// objects are written, which may improve the throughput and lifetime
+// of storage media that perform poorly under small random writes.
+//
+// Calls to WriteAt() block until data is written to the underlying
+// block device, meaning that errors are still propagated properly.
+// Writes are delayed by at most the provided duration. Writes of at
+// least the provided maximum size are never delayed, and also cause
+// pending writes to be submitted.
+func NewWriteAggregatingBlockDevice(base BlockDevice, clock clock.Clock, maximumDelay time.Duration, maximumSizeBytes int) BlockDevice {
+	writeAggregatingBlockDevicePrometheusMetrics.Do(func() {
+		prometheus.MustRegister(writeAggregatingBlockDeviceWritesPerDeviceWrite)
+	})
+
+	return &writeAggregatingBlockDevice{
+		BlockDevice:      base,
+		clock:            clock,
+		maximumDelay:     maximumDelay,
+		maximumSizeBytes: maximumSizeBytes,
+	}
+}
+
+func (bd *writeAggregatingBlockDevice) WriteAt(p []byte, off int64) (int, error) {
+	if len(p) >= bd.maximumSizeBytes {
+		writeAggregatingBlockDeviceWritesPerDeviceWrite.Observe(1)
+		return bd.BlockDevice.WriteAt(p, off)
+	}
+
+	// Add the write to the batch that is currently being
+	// constructed. The first write in a batch is responsible for
+	// submitting it once the delay expires.
+	write := &pendingWrite{p: p, off: off}
+	bd.lock.Lock()
+	batch := bd.currentBatch
+	isLeader := batch == nil
+	if isLeader {
+		batch = &writeAggregatingBatch{
+			done: make(chan struct{}),
+		}
+		bd.currentBatch = batch
+	}
+	batch.writes = append(batch.writes, write)
+	batch.sizeBytes += len(p)
+
+	if batch.sizeBytes >= bd.maximumSizeBytes {
+		// Batch has become large enough. Submit it immediately.
+		bd.currentBatch = nil
+		bd.lock.Unlock()
+		bd.submitBatch(batch)
+	} else {
+		bd.lock.Unlock()
+		if isLeader {
+			timer, t := bd.clock.NewTimer(bd.maximumDelay)
+			select {
+			case <-t:
+				bd.lock.Lock()
+				if bd.currentBatch == batch {
+					bd.currentBatch = nil
+					bd.lock.Unlock()
+					bd.submitBatch(batch)
+				} else {
+					bd.lock.Unlock()
+				}
+			case <-batch.done:
+				timer.Stop()
+			}
+		}
+	}
+
+	<-batch.done
+	return write.n, write.err
+}
+
+// submitBatch writes all of the data contained in a batch to the
+// underlying block device. Adjacent writes are combined.
+func (bd *writeAggregatingBlockDevice) submitBatch(batch *writeAggregatingBatch) {
+	writes := batch.writes
+	sort.SliceStable(writes, func(i, j int) bool {
+		return writes[i].off < writes[j].off
+	})
+	for len(writes) > 0 {
+		// Determine the number of writes that are adjacent.
+		count := 1
+		end := writes[0].off + int64(len(writes[0].p))
+		for count < len(writes) && writes[count].off == end {
+			end += int64(len(writes[count].p))
+			count++
+		}
+		writeAggregatingBlockDeviceWritesPerDeviceWrite.Observe(float64(count))
+
+		if count == 1 {
+			writes[0].n, writes[0].err = bd.BlockDevice.WriteAt(writes[0].p, writes[0].off)
+		} else {
+			data := make([]byte, 0, end-writes[0].off)
+			for _, write := range writes[:count] {
+				data = append(data, write.p...)
+			}
+			n, err := bd.BlockDevice.WriteAt(data, writes[0].off)
+			for _, write := range writes[:count] {
+				// Attribute the number of bytes written
+				// to each of the individual writes.
+				if writeEnd := write.off - writes[0].off + int64(len(write.p)); int64(n) >= writeEnd {
+					write.n = len(write.p)
+				} else if written := int64(n) - (write.off - writes[0].off); written > 0 {
+					write.n = int(written)
+					write.err = err
+				} else {
+					write.err = err
+				}
+			}
+		}
+		writes = writes[count:]
+	}
+	close(batch.done)
+}
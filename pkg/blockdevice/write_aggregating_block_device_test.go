@@ -0,0 +1,75 @@
+package blockdevice_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/blockdevice"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWriteAggregatingBlockDevice(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	baseBlockDevice := mock.NewMockBlockDevice(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	blockDevice := blockdevice.NewWriteAggregatingBlockDevice(baseBlockDevice, clock, time.Millisecond, 10)
+
+	t.Run("LargeWrite", func(t *testing.T) {
+		// Writes that exceed the maximum size should be
+		// forwarded without any delay.
+		baseBlockDevice.EXPECT().WriteAt([]byte("Hello world"), int64(100)).Return(11, nil)
+
+		n, err := blockDevice.WriteAt([]byte("Hello world"), 100)
+		require.NoError(t, err)
+		require.Equal(t, 11, n)
+	})
+
+	t.Run("DelayExpired", func(t *testing.T) {
+		// A single small write should be forwarded once the
+		// delay expires.
+		timer := mock.NewMockTimer(ctrl)
+		timerChan := make(chan time.Time, 1)
+		timerChan <- time.Unix(1000, 0)
+		clock.EXPECT().NewTimer(time.Millisecond).Return(timer, timerChan)
+		baseBlockDevice.EXPECT().WriteAt([]byte("Hello"), int64(200)).Return(5, nil)
+
+		n, err := blockDevice.WriteAt([]byte("Hello"), 200)
+		require.NoError(t, err)
+		require.Equal(t, 5, n)
+	})
+
+	t.Run("Aggregation", func(t *testing.T) {
+		// Concurrent writes against adjacent regions should be
+		// combined. Because the combined size reaches the
+		// maximum, they should be written without waiting for
+		// the delay to expire.
+		timer := mock.NewMockTimer(ctrl)
+		timerCreated := make(chan struct{})
+		clock.EXPECT().NewTimer(time.Millisecond).DoAndReturn(
+			func(d time.Duration) (*mock.MockTimer, <-chan time.Time) {
+				close(timerCreated)
+				return timer, nil
+			})
+		timer.EXPECT().Stop()
+		baseBlockDevice.EXPECT().WriteAt([]byte("Hello world"), int64(300)).Return(0, status.Error(codes.Internal, "Disk on fire"))
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := blockDevice.WriteAt([]byte("world"), 306)
+			errs <- err
+		}()
+		<-timerCreated
+
+		// Errors of the combined write should be propagated to
+		// all callers.
+		_, err := blockDevice.WriteAt([]byte("Hello "), 300)
+		require.Equal(t, status.Error(codes.Internal, "Disk on fire"), err)
+		require.Equal(t, status.Error(codes.Internal, "Disk on fire"), <-errs)
+	})
+}
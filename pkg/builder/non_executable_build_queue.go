@@ -29,6 +29,11 @@ func (bq nonExecutableBuildQueue) GetCapabilities(ctx context.Context, in *remot
 			// CachePriorityCapabilities: Priorities not supported.
 			// MaxBatchTotalSize: Not used by Bazel yet.
 			SymlinkAbsolutePathStrategy: remoteexecution.SymlinkAbsolutePathStrategy_ALLOWED,
+			// Compressors supported by the "compressed-blobs"
+			// resource names of the ByteStream service.
+			SupportedCompressor: []remoteexecution.Compressor_Value{
+				remoteexecution.Compressor_ZSTD,
+			},
 		},
 		// TODO(edsch): DeprecatedApiVersion.
 		LowApiVersion:  &semver.SemVer{Major: 2},
@@ -0,0 +1,50 @@
+package gcp
+
+import (
+	"context"
+	"net/http"
+
+	gcp_pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/cloud/gcp"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewHTTPClientFromConfiguration creates a decorator for an HTTP client
+// that attaches OAuth2 access tokens to all requests, allowing it to
+// access Google Cloud Platform APIs such as Google Cloud Storage.
+// Access tokens are obtained using the credentials specified in a
+// client options configuration message, and are refreshed
+// automatically before they expire.
+func NewHTTPClientFromConfiguration(configuration *gcp_pb.ClientOptionsConfiguration, base *http.Client, scopes ...string) (*http.Client, error) {
+	var credentials *google.Credentials
+	var err error
+	switch c := configuration.GetCredentials().(type) {
+	case *gcp_pb.ClientOptionsConfiguration_GoogleDefaultCredentials:
+		credentials, err = google.FindDefaultCredentials(context.Background(), scopes...)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.Unauthenticated, "Failed to obtain default Google credentials")
+		}
+	case *gcp_pb.ClientOptionsConfiguration_ServiceAccountKey:
+		credentials, err = google.CredentialsFromJSON(context.Background(), []byte(c.ServiceAccountKey), scopes...)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to parse service account key")
+		}
+	default:
+		return nil, status.Error(codes.InvalidArgument, "No Google Cloud Platform credentials provided")
+	}
+
+	baseTransport := base.Transport
+	if baseTransport == nil {
+		baseTransport = http.DefaultTransport
+	}
+	newClient := *base
+	newClient.Transport = &oauth2.Transport{
+		Source: credentials.TokenSource,
+		Base:   baseTransport,
+	}
+	return &newClient, nil
+}
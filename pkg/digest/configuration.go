@@ -26,3 +26,17 @@ func NewExistenceCacheFromConfiguration(configuration *pb.ExistenceCacheConfigur
 		cacheDuration.AsDuration(),
 		eviction.NewMetricsSet(evictionSet, name)), nil
 }
+
+// NewTranslationIndexFromConfiguration is identical to
+// NewTranslationIndex(), except that it takes a specification for the
+// object to be created from a configuration file message.
+func NewTranslationIndexFromConfiguration(configuration *pb.TranslationIndexConfiguration, keyFormat KeyFormat, name string) (*TranslationIndex, error) {
+	evictionSet, err := eviction.NewSetFromConfiguration(configuration.CacheReplacementPolicy)
+	if err != nil {
+		return nil, util.StatusWrap(err, "Cache replacement policy")
+	}
+	return NewTranslationIndex(
+		keyFormat,
+		int(configuration.CacheSize),
+		eviction.NewMetricsSet(evictionSet, name)), nil
+}
@@ -97,11 +97,34 @@ func MustNewDigest(instanceName, hash string, sizeBytes int64) Digest {
 // the following format: ${instanceName}/blobs/${hash}/${size}. This
 // notation is used to read files through the ByteStream service.
 func NewDigestFromByteStreamReadPath(path string) (Digest, error) {
+	d, compressor, err := NewDigestAndCompressorFromByteStreamReadPath(path)
+	if err != nil {
+		return BadDigest, err
+	}
+	if compressor != remoteexecution.Compressor_IDENTITY {
+		return BadDigest, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
+	}
+	return d, nil
+}
+
+// NewDigestAndCompressorFromByteStreamReadPath creates a Digest from a
+// string having either one of the following formats:
+//
+// - ${instanceName}/blobs/${hash}/${size}
+// - ${instanceName}/compressed-blobs/${compressor}/${hash}/${size}
+//
+// This notation is used to read files through the ByteStream service.
+// In addition to the Digest, it returns the compressor with which the
+// data is to be transferred.
+func NewDigestAndCompressorFromByteStreamReadPath(path string) (Digest, remoteexecution.Compressor_Value, error) {
 	fields := strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
 	if len(fields) < 3 {
-		return BadDigest, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
+		return BadDigest, remoteexecution.Compressor_IDENTITY, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
 	}
 	split := len(fields) - 3
+	if split > 0 && fields[split-1] == "compressed-blobs" {
+		split--
+	}
 	return newDigestFromByteStreamPathCommon(fields[:split], fields[split:])
 }
 
@@ -110,9 +133,29 @@ func NewDigestFromByteStreamReadPath(path string) (Digest, error) {
 // ${instanceName}/uploads/${uuid}/blobs/${hash}/${size}/${path}. This
 // notation is used to write files through the ByteStream service.
 func NewDigestFromByteStreamWritePath(path string) (Digest, error) {
+	d, compressor, err := NewDigestAndCompressorFromByteStreamWritePath(path)
+	if err != nil {
+		return BadDigest, err
+	}
+	if compressor != remoteexecution.Compressor_IDENTITY {
+		return BadDigest, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
+	}
+	return d, nil
+}
+
+// NewDigestAndCompressorFromByteStreamWritePath creates a Digest from
+// a string having either one of the following formats:
+//
+// - ${instanceName}/uploads/${uuid}/blobs/${hash}/${size}/${path}
+// - ${instanceName}/uploads/${uuid}/compressed-blobs/${compressor}/${hash}/${size}/${path}
+//
+// This notation is used to write files through the ByteStream service.
+// In addition to the Digest, it returns the compressor with which the
+// data is transferred.
+func NewDigestAndCompressorFromByteStreamWritePath(path string) (Digest, remoteexecution.Compressor_Value, error) {
 	fields := strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
 	if len(fields) < 5 {
-		return BadDigest, status.Errorf(codes.InvalidArgument, "Invalid resource naming scheme")
+		return BadDigest, remoteexecution.Compressor_IDENTITY, status.Errorf(codes.InvalidArgument, "Invalid resource naming scheme")
 	}
 	// Determine the end of the instance name. Because both the
 	// leading instance name and the trailing path have a variable
@@ -122,25 +165,69 @@ func NewDigestFromByteStreamWritePath(path string) (Digest, error) {
 	for fields[split] != "uploads" {
 		split++
 		if split > len(fields)-5 {
-			return BadDigest, status.Errorf(codes.InvalidArgument, "Invalid resource naming scheme")
+			return BadDigest, remoteexecution.Compressor_IDENTITY, status.Errorf(codes.InvalidArgument, "Invalid resource naming scheme")
 		}
 	}
 	return newDigestFromByteStreamPathCommon(fields[:split], fields[split+2:])
 }
 
-func newDigestFromByteStreamPathCommon(header, trailer []string) (Digest, error) {
-	if trailer[0] != "blobs" {
-		return BadDigest, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
+func newDigestFromByteStreamPathCommon(header, trailer []string) (Digest, remoteexecution.Compressor_Value, error) {
+	compressor := remoteexecution.Compressor_IDENTITY
+	switch trailer[0] {
+	case "blobs":
+		trailer = trailer[1:]
+	case "compressed-blobs":
+		if len(trailer) < 4 {
+			return BadDigest, remoteexecution.Compressor_IDENTITY, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
+		}
+		// Compressors are provided in lowercase form. Identity
+		// may not be used, as it is implied by "blobs".
+		compressorValue, ok := remoteexecution.Compressor_Value_value[strings.ToUpper(trailer[1])]
+		if !ok || trailer[1] != strings.ToLower(trailer[1]) || compressorValue == int32(remoteexecution.Compressor_IDENTITY) {
+			return BadDigest, remoteexecution.Compressor_IDENTITY, status.Errorf(codes.InvalidArgument, "Unsupported compressor %#v", trailer[1])
+		}
+		compressor = remoteexecution.Compressor_Value(compressorValue)
+		trailer = trailer[2:]
+	default:
+		return BadDigest, remoteexecution.Compressor_IDENTITY, status.Error(codes.InvalidArgument, "Invalid resource naming scheme")
 	}
-	sizeBytes, err := strconv.ParseInt(trailer[2], 10, 64)
+	sizeBytes, err := strconv.ParseInt(trailer[1], 10, 64)
 	if err != nil {
-		return BadDigest, status.Errorf(codes.InvalidArgument, "Invalid blob size %#v", trailer[2])
+		return BadDigest, remoteexecution.Compressor_IDENTITY, status.Errorf(codes.InvalidArgument, "Invalid blob size %#v", trailer[1])
 	}
 	instanceName, err := NewInstanceNameFromComponents(header)
 	if err != nil {
-		return BadDigest, util.StatusWrapf(err, "Invalid instance name %#v", strings.Join(header, "/"))
+		return BadDigest, remoteexecution.Compressor_IDENTITY, util.StatusWrapf(err, "Invalid instance name %#v", strings.Join(header, "/"))
+	}
+	d, err := instanceName.NewDigest(trailer[0], sizeBytes)
+	if err != nil {
+		return BadDigest, remoteexecution.Compressor_IDENTITY, err
 	}
-	return instanceName.NewDigest(trailer[1], sizeBytes)
+	return d, compressor, nil
+}
+
+// NewDigestFromKey creates a Digest from a key that was obtained by
+// calling Digest.GetKey(). Keys that were created using
+// KeyWithoutInstance yield a Digest with an empty instance name. This
+// is used to convert keys reported by backends that are capable of
+// enumerating their contents back to digests.
+func NewDigestFromKey(key string) (Digest, error) {
+	fields := strings.SplitN(key, "-", 3)
+	if len(fields) < 2 {
+		return BadDigest, status.Errorf(codes.InvalidArgument, "Invalid key %#v", key)
+	}
+	sizeBytes, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return BadDigest, status.Errorf(codes.InvalidArgument, "Invalid blob size %#v", fields[1])
+	}
+	instanceName := EmptyInstanceName
+	if len(fields) == 3 {
+		instanceName, err = NewInstanceName(fields[2])
+		if err != nil {
+			return BadDigest, util.StatusWrapf(err, "Invalid instance name %#v", fields[2])
+		}
+	}
+	return instanceName.NewDigest(fields[0], sizeBytes)
 }
 
 // GetByteStreamReadPath converts the Digest to a string having
@@ -263,6 +350,13 @@ func (d Digest) ToSingletonSet() Set {
 	}
 }
 
+// getHasherFactory returns the hash function corresponding to a digest
+// hash of a given length.
+//
+// The implementations provided by the Go standard library are used.
+// Which instruction set extensions these make use of depends on the
+// version of Go. Notably, the version of Go used by this project does
+// not make use of the SHA extensions (SHA-NI) on x86-64.
 func getHasherFactory(hashLength int) func() hash.Hash {
 	switch hashLength {
 	case md5.Size * 2:
@@ -113,6 +113,97 @@ func TestNewDigestFromByteStreamWritePath(t *testing.T) {
 	})
 }
 
+func TestNewDigestAndCompressorFromByteStreamReadPath(t *testing.T) {
+	t.Run("Uncompressed", func(t *testing.T) {
+		d, compressor, err := digest.NewDigestAndCompressorFromByteStreamReadPath("hello/blobs/8b1a9953c4611296a827abf8c47804d7/123")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 123), d)
+		require.Equal(t, remoteexecution.Compressor_IDENTITY, compressor)
+	})
+
+	t.Run("Zstandard", func(t *testing.T) {
+		d, compressor, err := digest.NewDigestAndCompressorFromByteStreamReadPath("hello/compressed-blobs/zstd/8b1a9953c4611296a827abf8c47804d7/123")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 123), d)
+		require.Equal(t, remoteexecution.Compressor_ZSTD, compressor)
+	})
+
+	t.Run("IdentityCompressor", func(t *testing.T) {
+		// Uncompressed data must be requested through "blobs".
+		_, _, err := digest.NewDigestAndCompressorFromByteStreamReadPath("compressed-blobs/identity/8b1a9953c4611296a827abf8c47804d7/123")
+		require.Equal(t, err, status.Error(codes.InvalidArgument, "Unsupported compressor \"identity\""))
+	})
+
+	t.Run("UppercaseCompressor", func(t *testing.T) {
+		_, _, err := digest.NewDigestAndCompressorFromByteStreamReadPath("compressed-blobs/ZSTD/8b1a9953c4611296a827abf8c47804d7/123")
+		require.Equal(t, err, status.Error(codes.InvalidArgument, "Unsupported compressor \"ZSTD\""))
+	})
+
+	t.Run("CompressedWithoutAwareness", func(t *testing.T) {
+		// Callers that don't support compression should not
+		// accept compressed resource names.
+		_, err := digest.NewDigestFromByteStreamReadPath("compressed-blobs/zstd/8b1a9953c4611296a827abf8c47804d7/123")
+		require.Equal(t, err, status.Error(codes.InvalidArgument, "Invalid resource naming scheme"))
+	})
+}
+
+func TestNewDigestAndCompressorFromByteStreamWritePath(t *testing.T) {
+	t.Run("Uncompressed", func(t *testing.T) {
+		d, compressor, err := digest.NewDigestAndCompressorFromByteStreamWritePath("hello/uploads/da2f1135-326b-4956-b920-1646cdd6cb53/blobs/8b1a9953c4611296a827abf8c47804d7/123")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 123), d)
+		require.Equal(t, remoteexecution.Compressor_IDENTITY, compressor)
+	})
+
+	t.Run("Zstandard", func(t *testing.T) {
+		d, compressor, err := digest.NewDigestAndCompressorFromByteStreamWritePath("hello/uploads/da2f1135-326b-4956-b920-1646cdd6cb53/compressed-blobs/zstd/8b1a9953c4611296a827abf8c47804d7/123/foo.txt")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 123), d)
+		require.Equal(t, remoteexecution.Compressor_ZSTD, compressor)
+	})
+
+	t.Run("MissingSize", func(t *testing.T) {
+		_, _, err := digest.NewDigestAndCompressorFromByteStreamWritePath("uploads/da2f1135-326b-4956-b920-1646cdd6cb53/compressed-blobs/zstd/8b1a9953c4611296a827abf8c47804d7")
+		require.Equal(t, err, status.Error(codes.InvalidArgument, "Invalid resource naming scheme"))
+	})
+}
+
+func TestNewDigestFromKey(t *testing.T) {
+	t.Run("WithoutInstance", func(t *testing.T) {
+		d, err := digest.NewDigestFromKey("8b1a9953c4611296a827abf8c47804d7-123")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("", "8b1a9953c4611296a827abf8c47804d7", 123), d)
+	})
+
+	t.Run("WithInstance", func(t *testing.T) {
+		d, err := digest.NewDigestFromKey("8b1a9953c4611296a827abf8c47804d7-123-hello/world")
+		require.NoError(t, err)
+		require.Equal(t, digest.MustNewDigest("hello/world", "8b1a9953c4611296a827abf8c47804d7", 123), d)
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		original := digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 123)
+		d, err := digest.NewDigestFromKey(original.GetKey(digest.KeyWithInstance))
+		require.NoError(t, err)
+		require.Equal(t, original, d)
+	})
+
+	t.Run("MissingSize", func(t *testing.T) {
+		_, err := digest.NewDigestFromKey("8b1a9953c4611296a827abf8c47804d7")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid key \"8b1a9953c4611296a827abf8c47804d7\""), err)
+	})
+
+	t.Run("InvalidSize", func(t *testing.T) {
+		_, err := digest.NewDigestFromKey("8b1a9953c4611296a827abf8c47804d7-hello")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Invalid blob size \"hello\""), err)
+	})
+
+	t.Run("InvalidHash", func(t *testing.T) {
+		_, err := digest.NewDigestFromKey("foo-123")
+		require.Equal(t, status.Error(codes.InvalidArgument, "Unknown digest hash length: 3 characters"), err)
+	})
+}
+
 func TestDigestGetByteStreamReadPath(t *testing.T) {
 	t.Run("NoInstanceName", func(t *testing.T) {
 		require.Equal(
@@ -82,3 +82,18 @@ func (ec *ExistenceCache) Add(digests Set) {
 	}
 	ec.lock.Unlock()
 }
+
+// Remove digests from the cache, causing them to be returned by
+// subsequent calls to RemoveExisting(). Entries are marked as expired,
+// as opposed to being removed from the eviction set, as eviction sets
+// only permit removal of the entry that is up for eviction.
+func (ec *ExistenceCache) Remove(digests Set) {
+	ec.lock.Lock()
+	for _, d := range digests.Items() {
+		key := d.GetKey(ec.keyFormat)
+		if _, ok := ec.insertionTimes[key]; ok {
+			ec.insertionTimes[key] = time.Time{}
+		}
+	}
+	ec.lock.Unlock()
+}
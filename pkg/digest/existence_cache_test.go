@@ -110,3 +110,29 @@ func TestExistenceCache(t *testing.T) {
 		allDigests,
 		existenceCache.RemoveExisting(allDigests))
 }
+
+func TestExistenceCacheRemove(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	clock := mock.NewMockClock(ctrl)
+	existenceCache := digest.NewExistenceCache(clock, digest.KeyWithoutInstance, 2, time.Minute, eviction.NewLRUSet())
+
+	digest1 := digest.MustNewDigest("hello", "d41d8cd98f00b204e9800998ecf8427e", 5)
+	digest2 := digest.MustNewDigest("hello", "6fc422233a40a75a1f028e11c3cd1140", 7)
+	bothDigests := digest.NewSetBuilder().Add(digest1).Add(digest2).Build()
+
+	clock.EXPECT().Now().Return(time.Unix(1000, 0))
+	existenceCache.Add(bothDigests)
+
+	// Removed digests should be returned by RemoveExisting(), even
+	// though they have not expired yet.
+	existenceCache.Remove(digest1.ToSingletonSet())
+	clock.EXPECT().Now().Return(time.Unix(1001, 0))
+	require.Equal(t, digest1.ToSingletonSet(), existenceCache.RemoveExisting(bothDigests))
+
+	// Adding them once again should make them present.
+	clock.EXPECT().Now().Return(time.Unix(1002, 0))
+	existenceCache.Add(digest1.ToSingletonSet())
+	clock.EXPECT().Now().Return(time.Unix(1003, 0))
+	require.Equal(t, digest.EmptySet, existenceCache.RemoveExisting(bothDigests))
+}
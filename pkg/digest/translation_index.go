@@ -0,0 +1,70 @@
+package digest
+
+import (
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+)
+
+// TranslationIndex keeps track of pairs of digests that correspond to
+// the same content, but are computed using different digest functions.
+// It is used by DigestTranslatingBlobAccess to allow clients that use
+// different digest functions to share objects stored in a single
+// Content Addressable Storage (CAS).
+//
+// Entries are stored in memory. Once the maximum number of entries is
+// reached, existing entries are discarded according to a cache
+// replacement policy.
+//
+// It is safe to access TranslationIndex concurrently.
+type TranslationIndex struct {
+	keyFormat KeyFormat
+	cacheSize int
+
+	lock         sync.Mutex
+	translations map[string]Digest
+	evictionSet  eviction.Set
+}
+
+// NewTranslationIndex creates a new TranslationIndex that is empty.
+func NewTranslationIndex(keyFormat KeyFormat, cacheSize int, evictionSet eviction.Set) *TranslationIndex {
+	return &TranslationIndex{
+		keyFormat: keyFormat,
+		cacheSize: cacheSize,
+
+		translations: map[string]Digest{},
+		evictionSet:  evictionSet,
+	}
+}
+
+// Add a translation to the index, stating that the object with digest
+// 'from' has the same contents as the object with digest 'to'.
+func (ti *TranslationIndex) Add(from, to Digest) {
+	key := from.GetKey(ti.keyFormat)
+	ti.lock.Lock()
+	if _, ok := ti.translations[key]; ok {
+		ti.evictionSet.Touch(key)
+	} else {
+		// Free up space to insert the translation.
+		if len(ti.translations) >= ti.cacheSize {
+			delete(ti.translations, ti.evictionSet.Peek())
+			ti.evictionSet.Remove()
+		}
+		ti.evictionSet.Insert(key)
+	}
+	ti.translations[key] = to
+	ti.lock.Unlock()
+}
+
+// Lookup the digest of an object that has the same contents as the
+// object with the provided digest.
+func (ti *TranslationIndex) Lookup(from Digest) (Digest, bool) {
+	key := from.GetKey(ti.keyFormat)
+	ti.lock.Lock()
+	to, ok := ti.translations[key]
+	if ok {
+		ti.evictionSet.Touch(key)
+	}
+	ti.lock.Unlock()
+	return to, ok
+}
@@ -0,0 +1,45 @@
+package digest_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/digest"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslationIndex(t *testing.T) {
+	translationIndex := digest.NewTranslationIndex(digest.KeyWithoutInstance, 2, eviction.NewLRUSet())
+
+	sha256Digest := digest.MustNewDigest("hello", "185f8db32271fe25f561a6fc938b2e264306ec304eda518007d1764826381969", 5)
+	digests := []digest.Digest{
+		digest.MustNewDigest("hello", "8b1a9953c4611296a827abf8c47804d7", 5),
+		digest.MustNewDigest("hello", "f7ff9e8b7bb2e09b70935a5d785e0cc5d9d0abf0", 5),
+		sha256Digest,
+	}
+
+	// The index should be empty initially.
+	_, ok := translationIndex.Lookup(digests[0])
+	require.False(t, ok)
+
+	translationIndex.Add(digests[0], sha256Digest)
+	translationIndex.Add(digests[1], sha256Digest)
+	to, ok := translationIndex.Lookup(digests[0])
+	require.True(t, ok)
+	require.Equal(t, sha256Digest, to)
+
+	// Instance names should be ignored when using
+	// KeyWithoutInstance.
+	to, ok = translationIndex.Lookup(digest.MustNewDigest("other", "f7ff9e8b7bb2e09b70935a5d785e0cc5d9d0abf0", 5))
+	require.True(t, ok)
+	require.Equal(t, sha256Digest, to)
+
+	// Inserting a third entry should cause the least recently used
+	// entry to be evicted.
+	translationIndex.Add(digests[2], sha256Digest)
+	_, ok = translationIndex.Lookup(digests[0])
+	require.False(t, ok)
+	to, ok = translationIndex.Lookup(digests[1])
+	require.True(t, ok)
+	require.Equal(t, sha256Digest, to)
+}
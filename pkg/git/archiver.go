@@ -0,0 +1,15 @@
+package git
+
+import (
+	"context"
+	"io"
+)
+
+// Archiver is capable of creating archives of the tree of a commit
+// stored in a remote Git repository.
+type Archiver interface {
+	// GetArchive returns a tarball containing the tree of a commit
+	// in a Git repository. Errors that occur while creating the
+	// archive are reported when the archive is closed.
+	GetArchive(ctx context.Context, repositoryURL, commit string) (io.ReadCloser, error)
+}
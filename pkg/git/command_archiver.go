@@ -0,0 +1,137 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// commitPattern matches full SHA-1 and SHA-256 commit hashes.
+// Abbreviated hashes and symbolic names are not accepted, as the
+// contents of the archive would then not be reproducible.
+var commitPattern = regexp.MustCompile("^([0-9a-f]{40}|[0-9a-f]{64})$")
+
+type commandArchiver struct {
+	gitPath            string
+	temporaryDirectory string
+}
+
+// NewCommandArchiver creates an Archiver that invokes the Git command
+// line tool. For every archive, a bare repository is created in a
+// temporary directory, into which only the requested commit is
+// fetched. Credentials for repositories may be provided through Git's
+// own configuration mechanisms (e.g., credential helpers configured
+// in $HOME/.gitconfig).
+func NewCommandArchiver(gitPath, temporaryDirectory string) Archiver {
+	return &commandArchiver{
+		gitPath:            gitPath,
+		temporaryDirectory: temporaryDirectory,
+	}
+}
+
+func (a *commandArchiver) newCommand(ctx context.Context, directory string, args ...string) (*exec.Cmd, *bytes.Buffer) {
+	cmd := exec.CommandContext(ctx, a.gitPath, args...)
+	cmd.Dir = directory
+	// Prevent Git from prompting for credentials.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	return cmd, &stderr
+}
+
+func (a *commandArchiver) run(ctx context.Context, directory string, args ...string) error {
+	cmd, stderr := a.newCommand(ctx, directory, args...)
+	if err := cmd.Run(); err != nil {
+		return status.Errorf(codes.Unavailable, "Failed to run \"git %s\": %s: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (a *commandArchiver) GetArchive(ctx context.Context, repositoryURL, commit string) (io.ReadCloser, error) {
+	if !commitPattern.MatchString(commit) {
+		return nil, status.Errorf(codes.InvalidArgument, "Commit %#v is not a full commit hash", commit)
+	}
+	if strings.HasPrefix(repositoryURL, "-") {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid repository URL %#v", repositoryURL)
+	}
+
+	directory, err := ioutil.TempDir(a.temporaryDirectory, "git")
+	if err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to create temporary directory")
+	}
+	if err := a.run(ctx, directory, "init", "--quiet", "--bare"); err != nil {
+		os.RemoveAll(directory)
+		return nil, err
+	}
+	if err := a.run(ctx, directory, "fetch", "--quiet", "--depth=1", "--", repositoryURL, commit); err != nil {
+		os.RemoveAll(directory)
+		return nil, err
+	}
+
+	archiveCtx, cancel := context.WithCancel(ctx)
+	cmd, stderr := a.newCommand(archiveCtx, directory, "archive", "--format=tar", commit)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		os.RemoveAll(directory)
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to create pipe")
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		os.RemoveAll(directory)
+		return nil, util.StatusWrapWithCode(err, codes.Internal, "Failed to run \"git archive\"")
+	}
+	return &commandArchive{
+		stdout:    stdout,
+		cmd:       cmd,
+		stderr:    stderr,
+		cancel:    cancel,
+		directory: directory,
+	}, nil
+}
+
+// commandArchive is the archive that is returned by GetArchive(). It
+// terminates "git archive" and removes the temporary repository upon
+// closure.
+type commandArchive struct {
+	stdout    io.Reader
+	cmd       *exec.Cmd
+	stderr    *bytes.Buffer
+	cancel    context.CancelFunc
+	directory string
+	completed bool
+}
+
+func (a *commandArchive) Read(p []byte) (int, error) {
+	n, err := a.stdout.Read(p)
+	if err == io.EOF {
+		a.completed = true
+	}
+	return n, err
+}
+
+func (a *commandArchive) Close() error {
+	// If the archive has not been read entirely, terminate "git
+	// archive", so that it does not block on writes. Its exit
+	// status is irrelevant in that case.
+	if !a.completed {
+		a.cancel()
+	}
+	err := a.cmd.Wait()
+	a.cancel()
+	os.RemoveAll(a.directory)
+	if err != nil && a.completed {
+		return status.Errorf(codes.Unavailable, "Failed to run \"git archive\": %s: %s", err, strings.TrimSpace(a.stderr.String()))
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package global
 
 import (
+	"context"
 	"io"
 	"log"
 	"net/http"
@@ -13,6 +14,10 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	bb_http "github.com/buildbarn/bb-storage/pkg/http"
+	"github.com/buildbarn/bb-storage/pkg/logging"
+	"github.com/buildbarn/bb-storage/pkg/otlp"
 	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/global"
 	"github.com/buildbarn/bb-storage/pkg/util"
 	"github.com/gorilla/mux"
@@ -47,17 +52,90 @@ func (ls *LifecycleState) MarkReadyAndWait() {
 	if ls.config == nil {
 		select {}
 	} else {
-		router := mux.NewRouter()
-		router.HandleFunc("/-/healthy", func(http.ResponseWriter, *http.Request) {})
-		if ls.config.EnablePrometheus {
-			router.Handle("/metrics", promhttp.Handler())
-		}
-		if ls.config.EnablePprof {
-			router.PathPrefix("/debug/pprof/").Handler(http.DefaultServeMux)
-		}
+		log.Fatal(http.ListenAndServe(ls.config.ListenAddress, NewDiagnosticsHandler(ls.config)))
+	}
+}
 
-		log.Fatal(http.ListenAndServe(ls.config.ListenAddress, router))
+// NewDiagnosticsHandler creates the HTTP handler of the diagnostics web
+// server. Endpoints that are registered against the default mux, either
+// by libraries or by the application, are only exposed if enabled in
+// the configuration.
+func NewDiagnosticsHandler(configuration *pb.DiagnosticsHTTPServerConfiguration) http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/-/healthy", func(http.ResponseWriter, *http.Request) {})
+	if configuration.EnablePrometheus {
+		router.Handle("/metrics", promhttp.Handler())
+	}
+	if configuration.EnablePprof {
+		router.PathPrefix("/debug/pprof/").Handler(http.DefaultServeMux)
+	}
+	if configuration.EnableBlobstoreTopology {
+		// Registered against the default mux by
+		// applications that construct storage backends.
+		router.Handle("/debug/blobstore/topology", http.DefaultServeMux)
+	}
+	if configuration.EnableBlobstoreQuota {
+		// Registered against the default mux by
+		// applications that construct storage backends.
+		router.Handle("/debug/blobstore/quota", http.DefaultServeMux)
+	}
+	if configuration.EnableBlobstoreActiveOperations {
+		// Registered against the default mux by
+		// applications that construct storage backends.
+		router.Handle("/debug/blobstore/active_operations", http.DefaultServeMux)
+	}
+	if configuration.EnableBlobstoreStatistics {
+		// Registered against the default mux by
+		// applications that construct storage backends.
+		router.Handle("/debug/blobstore/statistics", http.DefaultServeMux)
+	}
+	if configuration.EnableBlobstoreReplication {
+		// Registered against the default mux by
+		// applications that construct storage backends.
+		router.Handle("/debug/blobstore/replication", http.DefaultServeMux)
+	}
+	if configuration.EnableBlobstoreLocalSnapshot {
+		// Registered against the default mux by
+		// applications that construct storage backends.
+		router.Handle("/debug/blobstore/local/snapshot", http.DefaultServeMux)
 	}
+	if configuration.EnableDrain {
+		// Registered against the default mux by
+		// applications that support draining.
+		router.Handle("/-/drain", http.DefaultServeMux)
+	}
+	if configuration.EnableReload {
+		// Registered against the default mux by
+		// applications that support reloading their
+		// configuration.
+		router.Handle("/-/reload", http.DefaultServeMux)
+	}
+	return router
+}
+
+// otlpTraceExporterMaximumBufferSize is the maximum number of spans
+// that are buffered in memory by the OTLP trace exporter in between
+// flushes. Spans are discarded when this limit is reached.
+const otlpTraceExporterMaximumBufferSize = 10000
+
+// newOTLPHTTPClient creates the HTTP client used by an OTLP exporter,
+// and obtains the interval at which the exporter sends data.
+func newOTLPHTTPClient(configuration *pb.OTLPExporterConfiguration) (*http.Client, time.Duration, error) {
+	if configuration.EndpointUrl == "" {
+		return nil, 0, status.Error(codes.InvalidArgument, "No endpoint URL provided")
+	}
+	httpClient, err := bb_http.NewClientFromConfiguration(configuration.HttpClient)
+	if err != nil {
+		return nil, 0, util.StatusWrap(err, "Failed to create HTTP client")
+	}
+	exportInterval := configuration.ExportInterval
+	if err := exportInterval.CheckValid(); err != nil {
+		return nil, 0, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to parse export interval")
+	}
+	if exportInterval.AsDuration() <= 0 {
+		return nil, 0, status.Error(codes.InvalidArgument, "Export interval must be positive")
+	}
+	return httpClient, exportInterval.AsDuration(), nil
 }
 
 // ApplyConfiguration applies configuration options to the running
@@ -81,7 +159,11 @@ func ApplyConfiguration(configuration *pb.Configuration) (*LifecycleState, error
 		}
 		logWriters = append(logWriters, w)
 	}
-	log.SetOutput(io.MultiWriter(logWriters...))
+	if configuration.GetEnableJsonLogging() {
+		logging.SetJSONOutput(io.MultiWriter(logWriters...))
+	} else {
+		log.SetOutput(io.MultiWriter(logWriters...))
+	}
 
 	// Push traces to Jaeger.
 	if tracingConfiguration := configuration.GetTracing(); tracingConfiguration != nil {
@@ -119,6 +201,24 @@ func ApplyConfiguration(configuration *pb.Configuration) (*LifecycleState, error
 			trace.RegisterExporter(se)
 		}
 
+		if otlpConfiguration := tracingConfiguration.Otlp; otlpConfiguration != nil {
+			httpClient, exportInterval, err := newOTLPHTTPClient(otlpConfiguration)
+			if err != nil {
+				return nil, util.StatusWrap(err, "Failed to create the OTLP trace exporter")
+			}
+			te := otlp.NewTraceExporter(httpClient, otlpConfiguration.EndpointUrl, otlpConfiguration.ResourceAttributes, otlpTraceExporterMaximumBufferSize)
+			trace.RegisterExporter(te)
+
+			go func() {
+				for {
+					time.Sleep(exportInterval)
+					if err := te.Flush(context.Background()); err != nil {
+						log.Print("Failed to send traces to OTLP endpoint: ", err)
+					}
+				}
+			}()
+		}
+
 		if tracingConfiguration.EnablePrometheus {
 			pe, err := prometheus_exporter.NewExporter(prometheus_exporter.Options{
 				Registry:  prometheus.DefaultRegisterer.(*prometheus.Registry),
@@ -147,6 +247,13 @@ func ApplyConfiguration(configuration *pb.Configuration) (*LifecycleState, error
 		}
 	}
 
+	// Tune memory usage and garbage collection.
+	if memoryConfiguration := configuration.GetMemory(); memoryConfiguration != nil {
+		if err := applyMemoryConfiguration(memoryConfiguration); err != nil {
+			return nil, util.StatusWrap(err, "Failed to apply memory configuration")
+		}
+	}
+
 	// Enable mutex profiling.
 	runtime.SetMutexProfileFraction(int(configuration.GetMutexProfileFraction()))
 
@@ -177,6 +284,25 @@ func ApplyConfiguration(configuration *pb.Configuration) (*LifecycleState, error
 		}()
 	}
 
+	// Periodically push metrics to a service accepting the
+	// OpenTelemetry Protocol (OTLP).
+	if otlpConfiguration := configuration.GetOtlpMetrics(); otlpConfiguration != nil {
+		httpClient, exportInterval, err := newOTLPHTTPClient(otlpConfiguration)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to create the OTLP metrics exporter")
+		}
+		me := otlp.NewMetricsExporter(httpClient, otlpConfiguration.EndpointUrl, otlpConfiguration.ResourceAttributes, prometheus.DefaultGatherer, clock.SystemClock)
+
+		go func() {
+			for {
+				if err := me.Push(context.Background()); err != nil {
+					log.Print("Failed to push metrics to OTLP endpoint: ", err)
+				}
+				time.Sleep(exportInterval)
+			}
+		}()
+	}
+
 	return &LifecycleState{
 		config: configuration.GetDiagnosticsHttpServer(),
 	}, nil
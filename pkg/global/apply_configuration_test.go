@@ -0,0 +1,82 @@
+package global_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	blobstore_configuration "github.com/buildbarn/bb-storage/pkg/blobstore/configuration"
+	"github.com/buildbarn/bb-storage/pkg/global"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/global"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDiagnosticsHandler(t *testing.T) {
+	// Register the storage endpoints against the default mux, as
+	// is done by applications that construct storage backends.
+	blobstore_configuration.NewRegistry(nil, nil).RegisterHTTPHandlers(http.DefaultServeMux)
+
+	t.Run("Healthy", func(t *testing.T) {
+		handler := global.NewDiagnosticsHandler(&pb.DiagnosticsHTTPServerConfiguration{})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/-/healthy", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("ReplicationDisabled", func(t *testing.T) {
+		// Endpoints registered against the default mux should
+		// not be exposed unless enabled.
+		handler := global.NewDiagnosticsHandler(&pb.DiagnosticsHTTPServerConfiguration{})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/blobstore/replication", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("ReplicationEnabled", func(t *testing.T) {
+		handler := global.NewDiagnosticsHandler(&pb.DiagnosticsHTTPServerConfiguration{
+			EnableBlobstoreReplication: true,
+		})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/blobstore/replication", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, "[]", w.Body.String())
+
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/debug/blobstore/replication", strings.NewReader(url.Values{
+			"action": {"pause"},
+			"name":   {"asynchronous-0"},
+		}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Contains(t, w.Body.String(), "No replicator with name \"asynchronous-0\" exists")
+	})
+
+	t.Run("LocalSnapshotDisabled", func(t *testing.T) {
+		handler := global.NewDiagnosticsHandler(&pb.DiagnosticsHTTPServerConfiguration{})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/debug/blobstore/local/snapshot?storage_type=cas", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.NotContains(t, w.Body.String(), "No local storage")
+	})
+
+	t.Run("LocalSnapshotEnabled", func(t *testing.T) {
+		handler := global.NewDiagnosticsHandler(&pb.DiagnosticsHTTPServerConfiguration{
+			EnableBlobstoreLocalSnapshot: true,
+		})
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/blobstore/local/snapshot?storage_type=cas", nil))
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+		// The request should be forwarded to the handler, which
+		// reports that no snapshots can be created.
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/debug/blobstore/local/snapshot?storage_type=cas", nil))
+		require.Equal(t, http.StatusNotFound, w.Code)
+		require.Contains(t, w.Body.String(), "No local storage with snapshots enabled is configured for storage type \"cas\"")
+	})
+}
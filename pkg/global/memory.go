@@ -0,0 +1,174 @@
+package global
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/global"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CgroupFilesystemPath is the path at which the cgroup filesystem is
+// mounted on Linux.
+const CgroupFilesystemPath = "/sys/fs/cgroup"
+
+const (
+	// defaultGCPercent is the garbage collection target percentage
+	// used by the Go runtime if GOGC is not set.
+	defaultGCPercent = 100
+	// minimumGCPercent is the lowest garbage collection target
+	// percentage used to enforce the soft memory limit. It prevents
+	// the process from spending all of its time performing garbage
+	// collection when the heap is close to the limit.
+	minimumGCPercent = 10
+)
+
+// heapBallast is a large allocation that is never accessed. It is
+// retained to increase the size of the heap, thereby reducing the
+// frequency at which garbage collection is performed.
+var heapBallast []byte
+
+// GetContainerMemoryLimit returns the memory limit of the control
+// group in which the current process runs, using the cgroup filesystem
+// mounted at the provided path. Both cgroup v1 and v2 are supported.
+func GetContainerMemoryLimit(cgroupFilesystemPath string) (int64, error) {
+	// cgroup v2.
+	if data, err := ioutil.ReadFile(filepath.Join(cgroupFilesystemPath, "memory.max")); err == nil {
+		value := strings.TrimSpace(string(data))
+		if value == "max" {
+			return 0, status.Error(codes.FailedPrecondition, "Control group does not have a memory limit")
+		}
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, util.StatusWrapWithCode(err, codes.Internal, "Failed to parse cgroup v2 memory limit")
+		}
+		return limit, nil
+	}
+
+	// cgroup v1. The absence of a limit is indicated by a very
+	// large value that is rounded down to the page size.
+	data, err := ioutil.ReadFile(filepath.Join(cgroupFilesystemPath, "memory/memory.limit_in_bytes"))
+	if err != nil {
+		return 0, util.StatusWrapWithCode(err, codes.FailedPrecondition, "Failed to read cgroup memory limit")
+	}
+	limit, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, util.StatusWrapWithCode(err, codes.Internal, "Failed to parse cgroup v1 memory limit")
+	}
+	if limit >= 1<<62 {
+		return 0, status.Error(codes.FailedPrecondition, "Control group does not have a memory limit")
+	}
+	return limit, nil
+}
+
+// memoryLimiter enforces a soft memory limit on the heap. The version
+// of Go used by this project does not provide debug.SetMemoryLimit().
+// Instead, the garbage collection target percentage is recomputed
+// after every garbage collection cycle, so that the heap does not
+// grow beyond the limit before the next cycle starts.
+type memoryLimiter struct {
+	memoryLimitBytes     uint64
+	heapBallastSizeBytes uint64
+	maximumGCPercent     int
+	currentGCPercent     int
+}
+
+// memoryLimiterSentinel is an object that is allocated for the sole
+// purpose of getting garbage collected. Its finalizer is invoked after
+// every garbage collection cycle. It contains a pointer, so that it is
+// not merged with other objects by the tiny allocator.
+type memoryLimiterSentinel struct {
+	limiter *memoryLimiter
+}
+
+func (ml *memoryLimiter) arm() {
+	runtime.SetFinalizer(&memoryLimiterSentinel{limiter: ml}, func(s *memoryLimiterSentinel) {
+		s.limiter.adjustGCPercent()
+		s.limiter.arm()
+	})
+}
+
+func (ml *memoryLimiter) adjustGCPercent() {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	// The next garbage collection cycle starts when the heap has
+	// grown by gcPercent. The heap ballast is never accessed, so
+	// it does not count towards the limit.
+	gcPercent := ml.maximumGCPercent
+	if heapBytes := memStats.HeapAlloc; heapBytes > 0 {
+		if availableBytes := ml.memoryLimitBytes + ml.heapBallastSizeBytes; availableBytes > heapBytes {
+			if p := (availableBytes - heapBytes) * 100 / heapBytes; p < uint64(gcPercent) {
+				gcPercent = int(p)
+			}
+		} else {
+			gcPercent = 0
+		}
+	}
+	if gcPercent < minimumGCPercent {
+		gcPercent = minimumGCPercent
+	}
+	if gcPercent != ml.currentGCPercent {
+		debug.SetGCPercent(gcPercent)
+		ml.currentGCPercent = gcPercent
+	}
+}
+
+// applyMemoryConfiguration tunes memory usage and garbage collection.
+func applyMemoryConfiguration(configuration *pb.MemoryConfiguration) error {
+	var memoryLimitBytes int64
+	switch memoryLimit := configuration.MemoryLimit.(type) {
+	case nil:
+	case *pb.MemoryConfiguration_MemoryLimitBytes:
+		memoryLimitBytes = memoryLimit.MemoryLimitBytes
+		if memoryLimitBytes <= 0 {
+			return status.Error(codes.InvalidArgument, "Memory limit must be positive")
+		}
+	case *pb.MemoryConfiguration_ContainerMemoryLimitFraction:
+		fraction := memoryLimit.ContainerMemoryLimitFraction
+		if fraction <= 0 || fraction > 1 {
+			return status.Errorf(codes.InvalidArgument, "Container memory limit fraction must be in range (0, 1], not %f", fraction)
+		}
+		containerMemoryLimit, err := GetContainerMemoryLimit(CgroupFilesystemPath)
+		if err != nil {
+			return util.StatusWrap(err, "Failed to obtain container memory limit")
+		}
+		memoryLimitBytes = int64(float64(containerMemoryLimit) * fraction)
+	default:
+		return status.Error(codes.InvalidArgument, "Unknown memory limit type")
+	}
+
+	gcPercent := int(configuration.GcPercent)
+	if gcPercent < 0 {
+		return status.Error(codes.InvalidArgument, "Garbage collection target percentage cannot be negative")
+	} else if gcPercent == 0 {
+		gcPercent = defaultGCPercent
+	} else {
+		debug.SetGCPercent(gcPercent)
+	}
+
+	heapBallastSizeBytes := configuration.HeapBallastSizeBytes
+	if heapBallastSizeBytes < 0 {
+		return status.Error(codes.InvalidArgument, "Heap ballast size cannot be negative")
+	} else if heapBallastSizeBytes > 0 {
+		heapBallast = make([]byte, heapBallastSizeBytes)
+	}
+
+	if memoryLimitBytes > 0 {
+		ml := &memoryLimiter{
+			memoryLimitBytes:     uint64(memoryLimitBytes),
+			heapBallastSizeBytes: uint64(heapBallastSizeBytes),
+			maximumGCPercent:     gcPercent,
+			currentGCPercent:     gcPercent,
+		}
+		ml.arm()
+	}
+	return nil
+}
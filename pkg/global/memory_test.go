@@ -0,0 +1,142 @@
+package global_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/global"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/global"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writeCgroupFile creates a file in a fake cgroup filesystem.
+func writeCgroupFile(t *testing.T, cgroupFilesystemPath, name, contents string) {
+	path := filepath.Join(cgroupFilesystemPath, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o777))
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0o666))
+}
+
+func TestGetContainerMemoryLimit(t *testing.T) {
+	t.Run("CgroupV2", func(t *testing.T) {
+		cgroupFilesystemPath := t.TempDir()
+		writeCgroupFile(t, cgroupFilesystemPath, "memory.max", "1073741824\n")
+
+		limit, err := global.GetContainerMemoryLimit(cgroupFilesystemPath)
+		require.NoError(t, err)
+		require.Equal(t, int64(1073741824), limit)
+	})
+
+	t.Run("CgroupV2Unlimited", func(t *testing.T) {
+		cgroupFilesystemPath := t.TempDir()
+		writeCgroupFile(t, cgroupFilesystemPath, "memory.max", "max\n")
+
+		_, err := global.GetContainerMemoryLimit(cgroupFilesystemPath)
+		testutil.RequireEqualStatus(t, status.Error(codes.FailedPrecondition, "Control group does not have a memory limit"), err)
+	})
+
+	t.Run("CgroupV2Malformed", func(t *testing.T) {
+		cgroupFilesystemPath := t.TempDir()
+		writeCgroupFile(t, cgroupFilesystemPath, "memory.max", "hello\n")
+
+		_, err := global.GetContainerMemoryLimit(cgroupFilesystemPath)
+		testutil.RequirePrefixedStatus(t, status.Error(codes.Internal, "Failed to parse cgroup v2 memory limit: "), err)
+	})
+
+	t.Run("CgroupV1", func(t *testing.T) {
+		cgroupFilesystemPath := t.TempDir()
+		writeCgroupFile(t, cgroupFilesystemPath, "memory/memory.limit_in_bytes", "2147483648\n")
+
+		limit, err := global.GetContainerMemoryLimit(cgroupFilesystemPath)
+		require.NoError(t, err)
+		require.Equal(t, int64(2147483648), limit)
+	})
+
+	t.Run("CgroupV1Unlimited", func(t *testing.T) {
+		// cgroup v1 indicates the absence of a limit by
+		// reporting the largest page aligned value.
+		cgroupFilesystemPath := t.TempDir()
+		writeCgroupFile(t, cgroupFilesystemPath, "memory/memory.limit_in_bytes", "9223372036854771712\n")
+
+		_, err := global.GetContainerMemoryLimit(cgroupFilesystemPath)
+		testutil.RequireEqualStatus(t, status.Error(codes.FailedPrecondition, "Control group does not have a memory limit"), err)
+	})
+
+	t.Run("CgroupV1Malformed", func(t *testing.T) {
+		cgroupFilesystemPath := t.TempDir()
+		writeCgroupFile(t, cgroupFilesystemPath, "memory/memory.limit_in_bytes", "-\n")
+
+		_, err := global.GetContainerMemoryLimit(cgroupFilesystemPath)
+		testutil.RequirePrefixedStatus(t, status.Error(codes.Internal, "Failed to parse cgroup v1 memory limit: "), err)
+	})
+
+	t.Run("NoCgroup", func(t *testing.T) {
+		_, err := global.GetContainerMemoryLimit(t.TempDir())
+		testutil.RequirePrefixedStatus(t, status.Error(codes.FailedPrecondition, "Failed to read cgroup memory limit: "), err)
+	})
+}
+
+func TestApplyConfigurationMemory(t *testing.T) {
+	t.Run("NonPositiveMemoryLimit", func(t *testing.T) {
+		_, err := global.ApplyConfiguration(&pb.Configuration{
+			Memory: &pb.MemoryConfiguration{
+				MemoryLimit: &pb.MemoryConfiguration_MemoryLimitBytes{MemoryLimitBytes: 0},
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Failed to apply memory configuration: Memory limit must be positive"), err)
+	})
+
+	t.Run("FractionZero", func(t *testing.T) {
+		_, err := global.ApplyConfiguration(&pb.Configuration{
+			Memory: &pb.MemoryConfiguration{
+				MemoryLimit: &pb.MemoryConfiguration_ContainerMemoryLimitFraction{ContainerMemoryLimitFraction: 0},
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Failed to apply memory configuration: Container memory limit fraction must be in range (0, 1], not 0.000000"), err)
+	})
+
+	t.Run("FractionTooLarge", func(t *testing.T) {
+		_, err := global.ApplyConfiguration(&pb.Configuration{
+			Memory: &pb.MemoryConfiguration{
+				MemoryLimit: &pb.MemoryConfiguration_ContainerMemoryLimitFraction{ContainerMemoryLimitFraction: 1.5},
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Failed to apply memory configuration: Container memory limit fraction must be in range (0, 1], not 1.500000"), err)
+	})
+
+	t.Run("NegativeGCPercent", func(t *testing.T) {
+		_, err := global.ApplyConfiguration(&pb.Configuration{
+			Memory: &pb.MemoryConfiguration{
+				GcPercent: -1,
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Failed to apply memory configuration: Garbage collection target percentage cannot be negative"), err)
+	})
+
+	t.Run("NegativeHeapBallast", func(t *testing.T) {
+		_, err := global.ApplyConfiguration(&pb.Configuration{
+			Memory: &pb.MemoryConfiguration{
+				HeapBallastSizeBytes: -1,
+			},
+		})
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Failed to apply memory configuration: Heap ballast size cannot be negative"), err)
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// Use a memory limit that is large enough not to
+		// affect the garbage collection behavior of the test.
+		_, err := global.ApplyConfiguration(&pb.Configuration{
+			Memory: &pb.MemoryConfiguration{
+				MemoryLimit:          &pb.MemoryConfiguration_MemoryLimitBytes{MemoryLimitBytes: 1 << 40},
+				GcPercent:            100,
+				HeapBallastSizeBytes: 1024,
+			},
+		})
+		require.NoError(t, err)
+	})
+}
@@ -5,7 +5,9 @@ import (
 	"crypto/x509"
 
 	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/jwt"
 	configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
+	"github.com/buildbarn/bb-storage/pkg/util"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -48,6 +50,14 @@ func NewAuthenticatorFromConfiguration(policy *configuration.AuthenticationPolic
 		return NewTLSClientCertificateAuthenticator(
 			clientCAs,
 			clock.SystemClock), nil
+	case *configuration.AuthenticationPolicy_Jwt:
+		authorizationHeaderParser, err := jwt.NewAuthorizationHeaderParserFromConfiguration(policyKind.Jwt.AuthorizationHeaderParser)
+		if err != nil {
+			return nil, util.StatusWrap(err, "Failed to create authorization header parser")
+		}
+		return NewJWTAuthenticator(
+			authorizationHeaderParser,
+			jwt.NewClaimsMatcherFromConfiguration(policyKind.Jwt.RequiredClaims)), nil
 	default:
 		return nil, status.Error(codes.InvalidArgument, "Configuration did not contain an authentication policy type")
 	}
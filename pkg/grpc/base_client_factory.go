@@ -2,15 +2,24 @@ package grpc
 
 import (
 	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
 	"github.com/buildbarn/bb-storage/pkg/util"
 	"github.com/grpc-ecosystem/go-grpc-prometheus"
+	"golang.org/x/oauth2/clientcredentials"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/oauth"
+	"google.golang.org/grpc/credentials/sts"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/status"
 
@@ -46,9 +55,11 @@ func (cf baseClientFactory) NewClientFromConfiguration(config *configuration.Cli
 	}
 	unaryInterceptors := []grpc.UnaryClientInterceptor{
 		grpc_prometheus.UnaryClientInterceptor,
+		NewCorrelationIDUnaryClientInterceptor(),
 	}
 	streamInterceptors := []grpc.StreamClientInterceptor{
 		grpc_prometheus.StreamClientInterceptor,
+		NewCorrelationIDStreamClientInterceptor(),
 	}
 
 	// Optional: TLS.
@@ -71,8 +82,35 @@ func (cf baseClientFactory) NewClientFromConfiguration(config *configuration.Cli
 			perRPC, err = oauth.NewApplicationDefault(context.Background(), oauthConfig.Scopes...)
 		case *configuration.ClientOAuthConfiguration_ServiceAccountKey:
 			perRPC, err = oauth.NewServiceAccountFromKey([]byte(credentials.ServiceAccountKey), oauthConfig.Scopes...)
+		case *configuration.ClientOAuthConfiguration_ClientCredentials:
+			endpointParams := url.Values{}
+			for key, value := range credentials.ClientCredentials.EndpointParameters {
+				endpointParams.Set(key, value)
+			}
+			clientCredentialsConfig := clientcredentials.Config{
+				ClientID:       credentials.ClientCredentials.ClientId,
+				ClientSecret:   credentials.ClientCredentials.ClientSecret,
+				TokenURL:       credentials.ClientCredentials.TokenEndpointUrl,
+				Scopes:         oauthConfig.Scopes,
+				EndpointParams: endpointParams,
+			}
+			perRPC = oauth.TokenSource{
+				TokenSource: clientCredentialsConfig.TokenSource(context.Background()),
+			}
+		case *configuration.ClientOAuthConfiguration_TokenExchange:
+			perRPC, err = sts.NewCredentials(sts.Options{
+				TokenExchangeServiceURI: credentials.TokenExchange.TokenExchangeServiceUri,
+				Resource:                credentials.TokenExchange.Resource,
+				Audience:                credentials.TokenExchange.Audience,
+				Scope:                   strings.Join(oauthConfig.Scopes, " "),
+				RequestedTokenType:      credentials.TokenExchange.RequestedTokenType,
+				SubjectTokenPath:        credentials.TokenExchange.SubjectTokenPath,
+				SubjectTokenType:        credentials.TokenExchange.SubjectTokenType,
+				ActorTokenPath:          credentials.TokenExchange.ActorTokenPath,
+				ActorTokenType:          credentials.TokenExchange.ActorTokenType,
+			})
 		default:
-			return nil, status.Error(codes.InvalidArgument, "gRPC client credentials are wrong: one of googleDefaultCredentials or serviceAccountKey should be provided")
+			return nil, status.Error(codes.InvalidArgument, "gRPC client credentials are wrong: one of googleDefaultCredentials, serviceAccountKey, clientCredentials or tokenExchange should be provided")
 		}
 		if err != nil {
 			return nil, util.StatusWrap(err, "Failed to create gRPC credentials")
@@ -128,6 +166,84 @@ func (cf baseClientFactory) NewClientFromConfiguration(config *configuration.Cli
 		streamInterceptors = append(streamInterceptors, interceptor.InterceptStreamClient)
 	}
 
+	// Optional: load balancing and per-method options. These are
+	// provided to gRPC in the form of a service config.
+	serviceConfig := map[string]interface{}{}
+	if loadBalancingConfig := config.LoadBalancing; loadBalancingConfig != nil {
+		if balancer.Get(loadBalancingConfig.Policy) == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Unknown load balancing policy %#v", loadBalancingConfig.Policy)
+		}
+		serviceConfig["loadBalancingConfig"] = []interface{}{
+			map[string]interface{}{
+				loadBalancingConfig.Policy: map[string]interface{}{},
+			},
+		}
+		if healthCheckConfig := loadBalancingConfig.HealthCheck; healthCheckConfig != nil {
+			serviceConfig["healthCheckConfig"] = map[string]interface{}{
+				"serviceName": healthCheckConfig.ServiceName,
+			}
+		}
+	}
+	if len(config.Methods) > 0 {
+		methodConfigs := make([]interface{}, 0, len(config.Methods))
+		for _, methodConfig := range config.Methods {
+			if methodConfig.Service == "" && methodConfig.Method != "" {
+				return nil, status.Errorf(codes.InvalidArgument, "Method %#v has no service name", methodConfig.Method)
+			}
+			jsonMethodConfig := map[string]interface{}{
+				"name": []interface{}{
+					map[string]interface{}{
+						"service": methodConfig.Service,
+						"method":  methodConfig.Method,
+					},
+				},
+				"waitForReady": methodConfig.WaitForReady,
+			}
+			if timeout := methodConfig.Timeout; timeout != nil {
+				if err := timeout.CheckValid(); err != nil {
+					return nil, util.StatusWrapf(err, "Failed to parse timeout for method %#v of service %#v", methodConfig.Method, methodConfig.Service)
+				}
+				jsonMethodConfig["timeout"] = strconv.FormatFloat(timeout.AsDuration().Seconds(), 'f', -1, 64) + "s"
+			}
+			methodConfigs = append(methodConfigs, jsonMethodConfig)
+		}
+		serviceConfig["methodConfig"] = methodConfigs
+	}
+	if len(serviceConfig) > 0 {
+		serviceConfigJSON, err := json.Marshal(serviceConfig)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to create service config")
+		}
+		dialOptions = append(dialOptions, grpc.WithDefaultServiceConfig(string(serviceConfigJSON)))
+	}
+
+	// Optional: reconnect backoff.
+	if reconnectBackoffConfig := config.ReconnectBackoff; reconnectBackoffConfig != nil {
+		connectParams := grpc.ConnectParams{
+			Backoff:           backoff.DefaultConfig,
+			MinConnectTimeout: 20 * time.Second,
+		}
+		if baseDelay := reconnectBackoffConfig.BaseDelay; baseDelay != nil {
+			if err := baseDelay.CheckValid(); err != nil {
+				return nil, util.StatusWrap(err, "Failed to parse reconnect backoff base delay")
+			}
+			connectParams.Backoff.BaseDelay = baseDelay.AsDuration()
+		}
+		if maximumDelay := reconnectBackoffConfig.MaximumDelay; maximumDelay != nil {
+			if err := maximumDelay.CheckValid(); err != nil {
+				return nil, util.StatusWrap(err, "Failed to parse reconnect backoff maximum delay")
+			}
+			connectParams.Backoff.MaxDelay = maximumDelay.AsDuration()
+		}
+		if minimumConnectTimeout := reconnectBackoffConfig.MinimumConnectTimeout; minimumConnectTimeout != nil {
+			if err := minimumConnectTimeout.CheckValid(); err != nil {
+				return nil, util.StatusWrap(err, "Failed to parse reconnect backoff minimum connect timeout")
+			}
+			connectParams.MinConnectTimeout = minimumConnectTimeout.AsDuration()
+		}
+		dialOptions = append(dialOptions, grpc.WithConnectParams(connectParams))
+	}
+
 	dialOptions = append(
 		dialOptions,
 		grpc.WithChainUnaryInterceptor(unaryInterceptors...),
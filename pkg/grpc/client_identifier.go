@@ -0,0 +1,192 @@
+package grpc
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ClientIdentifier is used to determine the identity of the client
+// that issued a request. Unlike Authenticator, it does not grant or
+// deny access. Its results are used for purposes such as rate
+// limiting.
+type ClientIdentifier interface {
+	// IdentifyClient returns the identity of the client, or the
+	// empty string if it cannot be determined.
+	IdentifyClient(ctx context.Context) string
+}
+
+// NewClientIdentifierFromConfiguration creates a ClientIdentifier
+// based on a configuration file.
+func NewClientIdentifierFromConfiguration(policy *configuration.ClientIdentificationPolicy) (ClientIdentifier, error) {
+	if policy == nil {
+		return nil, status.Error(codes.InvalidArgument, "Client identification policy not specified")
+	}
+	switch policyKind := policy.Policy.(type) {
+	case *configuration.ClientIdentificationPolicy_MetadataHeader:
+		return NewMetadataClientIdentifier(policyKind.MetadataHeader), nil
+	case *configuration.ClientIdentificationPolicy_JwtSubjectHeader:
+		return NewJWTSubjectClientIdentifier(policyKind.JwtSubjectHeader), nil
+	case *configuration.ClientIdentificationPolicy_TlsClientCertificateSubject:
+		return TLSClientCertificateSubjectClientIdentifier, nil
+	case *configuration.ClientIdentificationPolicy_TlsClientCertificateSpiffeId:
+		return TLSClientCertificateSPIFFEIDClientIdentifier, nil
+	case *configuration.ClientIdentificationPolicy_TlsClientCertificateDnsName:
+		return TLSClientCertificateDNSNameClientIdentifier, nil
+	default:
+		return nil, status.Error(codes.InvalidArgument, "Configuration did not contain a client identification policy type")
+	}
+}
+
+func getFirstMetadataValue(ctx context.Context, header string) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	values := md.Get(header)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+type metadataClientIdentifier struct {
+	header string
+}
+
+// NewMetadataClientIdentifier creates a ClientIdentifier that uses the
+// value of a gRPC metadata header as the identity of the client.
+func NewMetadataClientIdentifier(header string) ClientIdentifier {
+	return metadataClientIdentifier{
+		header: header,
+	}
+}
+
+func (ci metadataClientIdentifier) IdentifyClient(ctx context.Context) string {
+	value, _ := getFirstMetadataValue(ctx, ci.header)
+	return value
+}
+
+type jwtSubjectClientIdentifier struct {
+	header string
+}
+
+// NewJWTSubjectClientIdentifier creates a ClientIdentifier that uses
+// the "sub" claim of a JSON Web Token as the identity of the client.
+// The token is extracted from a gRPC metadata header, having the form
+// "Bearer ${token}".
+//
+// This implementation does not validate the signature of the token. It
+// should only be used in environments where tokens are validated by
+// other means.
+func NewJWTSubjectClientIdentifier(header string) ClientIdentifier {
+	return jwtSubjectClientIdentifier{
+		header: header,
+	}
+}
+
+func (ci jwtSubjectClientIdentifier) IdentifyClient(ctx context.Context) string {
+	value, ok := getFirstMetadataValue(ctx, ci.header)
+	if !ok {
+		return ""
+	}
+	const prefix = "bearer "
+	if len(value) < len(prefix) || !strings.EqualFold(value[:len(prefix)], prefix) {
+		return ""
+	}
+	fields := strings.Split(value[len(prefix):], ".")
+	if len(fields) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(fields[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+func getTLSClientCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil, false
+	}
+	return tlsInfo.State.PeerCertificates[0], true
+}
+
+type tlsClientCertificateSubjectClientIdentifier struct{}
+
+func (ci tlsClientCertificateSubjectClientIdentifier) IdentifyClient(ctx context.Context) string {
+	cert, ok := getTLSClientCertificate(ctx)
+	if !ok {
+		return ""
+	}
+	return cert.Subject.String()
+}
+
+// TLSClientCertificateSubjectClientIdentifier is a ClientIdentifier
+// that uses the subject of the TLS client certificate presented by the
+// client as its identity. As the certificate is not validated, this
+// should be combined with TLS client certificate authentication.
+var TLSClientCertificateSubjectClientIdentifier ClientIdentifier = tlsClientCertificateSubjectClientIdentifier{}
+
+type tlsClientCertificateSPIFFEIDClientIdentifier struct{}
+
+func (ci tlsClientCertificateSPIFFEIDClientIdentifier) IdentifyClient(ctx context.Context) string {
+	cert, ok := getTLSClientCertificate(ctx)
+	if !ok {
+		return ""
+	}
+	// X.509 SVIDs contain exactly one URI SAN, which is the SPIFFE
+	// ID. Be lenient and skip any URIs having other schemes.
+	for _, uri := range cert.URIs {
+		if strings.EqualFold(uri.Scheme, "spiffe") {
+			return uri.String()
+		}
+	}
+	return ""
+}
+
+// TLSClientCertificateSPIFFEIDClientIdentifier is a ClientIdentifier
+// that uses the SPIFFE ID contained in the URI Subject Alternative
+// Name (SAN) of the TLS client certificate as the identity of the
+// client (e.g., "spiffe://example.com/ci"). As the certificate is not
+// validated, this should be combined with TLS client certificate
+// authentication.
+var TLSClientCertificateSPIFFEIDClientIdentifier ClientIdentifier = tlsClientCertificateSPIFFEIDClientIdentifier{}
+
+type tlsClientCertificateDNSNameClientIdentifier struct{}
+
+func (ci tlsClientCertificateDNSNameClientIdentifier) IdentifyClient(ctx context.Context) string {
+	cert, ok := getTLSClientCertificate(ctx)
+	if !ok || len(cert.DNSNames) == 0 {
+		return ""
+	}
+	return cert.DNSNames[0]
+}
+
+// TLSClientCertificateDNSNameClientIdentifier is a ClientIdentifier
+// that uses the first DNS name Subject Alternative Name (SAN) of the
+// TLS client certificate as the identity of the client. As the
+// certificate is not validated, this should be combined with TLS
+// client certificate authentication.
+var TLSClientCertificateDNSNameClientIdentifier ClientIdentifier = tlsClientCertificateDNSNameClientIdentifier{}
@@ -0,0 +1,142 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/buildbarn/bb-storage/pkg/logging"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/google/uuid"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// correlationIDMetadataKey is the name of the gRPC metadata header
+// through which correlation IDs are propagated between services.
+const correlationIDMetadataKey = "correlation-id"
+
+// parseClientCorrelationID validates a correlation ID provided by a
+// client. As correlation IDs are included in log messages and errors,
+// only UUIDs in their canonical form are accepted.
+func parseClientCorrelationID(correlationID string) (string, bool) {
+	if len(correlationID) != len(uuid.Nil.String()) {
+		return "", false
+	}
+	parsed, err := uuid.Parse(correlationID)
+	if err != nil {
+		return "", false
+	}
+	return parsed.String(), true
+}
+
+// getCorrelationIDFromIncomingContext determines the correlation ID of
+// an incoming request. Correlation IDs provided by other services take
+// precedence, followed by the tool invocation ID in the REv2 request
+// metadata. This causes all requests that are part of the same build
+// to share a correlation ID. If neither is present or valid, a
+// correlation ID is generated.
+func getCorrelationIDFromIncomingContext(ctx context.Context, uuidGenerator util.UUIDGenerator) (string, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(correlationIDMetadataKey); len(values) > 0 {
+			if correlationID, ok := parseClientCorrelationID(values[0]); ok {
+				return correlationID, nil
+			}
+		}
+	}
+	if rmd, ok := getRequestMetadataFromIncomingContext(ctx); ok {
+		if correlationID, ok := parseClientCorrelationID(rmd.ToolInvocationId); ok {
+			return correlationID, nil
+		}
+	}
+	correlationID, err := uuidGenerator()
+	if err != nil {
+		return "", util.StatusWrap(err, "Failed to generate correlation ID")
+	}
+	return correlationID.String(), nil
+}
+
+// annotateErrorWithCorrelationID prefixes the message of an error
+// returned by a gRPC server with the correlation ID of the request.
+// Errors that already contain the correlation ID (e.g., because they
+// were returned by another service that received the same correlation
+// ID) are left alone.
+func annotateErrorWithCorrelationID(err error, correlationID string) error {
+	if err == nil || strings.Contains(status.Convert(err).Message(), correlationID) {
+		return err
+	}
+	return util.StatusWrapf(err, "Correlation ID %#v", correlationID)
+}
+
+// NewCorrelationIDUnaryServerInterceptor creates a gRPC request
+// interceptor for unary calls that annotates the context of every
+// request with a correlation ID. The correlation ID is included in log
+// messages written while processing the request, and in errors
+// returned by the server.
+func NewCorrelationIDUnaryServerInterceptor(uuidGenerator util.UUIDGenerator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		correlationID, err := getCorrelationIDFromIncomingContext(ctx, uuidGenerator)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := handler(logging.NewContextWithCorrelationID(ctx, correlationID), req)
+		return resp, annotateErrorWithCorrelationID(err, correlationID)
+	}
+}
+
+// NewCorrelationIDStreamServerInterceptor creates a gRPC request
+// interceptor for streaming calls that annotates the context of every
+// request with a correlation ID. The correlation ID is included in log
+// messages written while processing the request, and in errors
+// returned by the server.
+func NewCorrelationIDStreamServerInterceptor(uuidGenerator util.UUIDGenerator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		correlationID, err := getCorrelationIDFromIncomingContext(ss.Context(), uuidGenerator)
+		if err != nil {
+			return err
+		}
+		return annotateErrorWithCorrelationID(
+			handler(srv, &correlationIDServerStream{
+				ServerStream: ss,
+				ctx:          logging.NewContextWithCorrelationID(ss.Context(), correlationID),
+			}),
+			correlationID)
+	}
+}
+
+type correlationIDServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *correlationIDServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// appendCorrelationIDToOutgoingContext adds the correlation ID stored
+// in a context to the outgoing metadata headers, if any.
+func appendCorrelationIDToOutgoingContext(ctx context.Context) context.Context {
+	if correlationID := logging.GetCorrelationIDFromContext(ctx); correlationID != "" {
+		return metadata.AppendToOutgoingContext(ctx, correlationIDMetadataKey, correlationID)
+	}
+	return ctx
+}
+
+// NewCorrelationIDUnaryClientInterceptor creates a gRPC request
+// interceptor for unary calls that propagates the correlation ID of
+// the request being processed to other services.
+func NewCorrelationIDUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, resp interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(appendCorrelationIDToOutgoingContext(ctx), method, req, resp, cc, opts...)
+	}
+}
+
+// NewCorrelationIDStreamClientInterceptor creates a gRPC request
+// interceptor for streaming calls that propagates the correlation ID
+// of the request being processed to other services.
+func NewCorrelationIDStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(appendCorrelationIDToOutgoingContext(ctx), desc, cc, method, opts...)
+	}
+}
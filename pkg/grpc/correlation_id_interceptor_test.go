@@ -0,0 +1,132 @@
+package grpc_test
+
+import (
+	"context"
+	"testing"
+
+	remoteexecution "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/buildbarn/bb-storage/internal/mock"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	"github.com/buildbarn/bb-storage/pkg/logging"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestCorrelationIDUnaryServerInterceptor(t *testing.T) {
+	ctx := context.Background()
+
+	interceptor := bb_grpc.NewCorrelationIDUnaryServerInterceptor(func() (uuid.UUID, error) {
+		return uuid.MustParse("36ebab65-3c4e-4d74-a01b-14bdac1d8c94"), nil
+	})
+	req := &emptypb.Empty{}
+	resp := &emptypb.Empty{}
+
+	// newHandler creates a handler that checks whether the request
+	// is annotated with a given correlation ID.
+	newHandler := func(expectedCorrelationID string, err error) grpc.UnaryHandler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			require.Equal(t, expectedCorrelationID, logging.GetCorrelationIDFromContext(ctx))
+			if err != nil {
+				return nil, err
+			}
+			return resp, nil
+		}
+	}
+
+	t.Run("Generated", func(t *testing.T) {
+		// Requests without any metadata should be assigned a
+		// newly generated correlation ID.
+		r, err := interceptor(ctx, req, nil, newHandler("36ebab65-3c4e-4d74-a01b-14bdac1d8c94", nil))
+		require.NoError(t, err)
+		require.Equal(t, resp, r)
+	})
+
+	t.Run("ToolInvocationID", func(t *testing.T) {
+		// The tool invocation ID in the REv2 request metadata
+		// should be used as the correlation ID.
+		requestMetadata, err := proto.Marshal(&remoteexecution.RequestMetadata{
+			ToolInvocationId: "a7b4e5e5-d41d-4b6b-a2d1-5e0e9c2f14a8",
+		})
+		require.NoError(t, err)
+		ctxWithMetadata := metadata.NewIncomingContext(ctx, metadata.Pairs(
+			"build.bazel.remote.execution.v2.requestmetadata-bin", string(requestMetadata)))
+
+		_, err = interceptor(ctxWithMetadata, req, nil, newHandler("a7b4e5e5-d41d-4b6b-a2d1-5e0e9c2f14a8", status.Error(codes.NotFound, "Object not found")))
+		testutil.RequireEqualStatus(t, status.Error(codes.NotFound, "Correlation ID \"a7b4e5e5-d41d-4b6b-a2d1-5e0e9c2f14a8\": Object not found"), err)
+	})
+
+	t.Run("Propagated", func(t *testing.T) {
+		// Correlation IDs provided by other services should be
+		// used as is. Errors that already contain the
+		// correlation ID should not be annotated again.
+		ctxWithMetadata := metadata.NewIncomingContext(ctx, metadata.Pairs("correlation-id", "e6f5c4b1-0f0a-4bd9-9bb2-9d2b4c1e7a3f"))
+
+		_, err := interceptor(ctxWithMetadata, req, nil, newHandler("e6f5c4b1-0f0a-4bd9-9bb2-9d2b4c1e7a3f", status.Error(codes.Unavailable, "Correlation ID \"e6f5c4b1-0f0a-4bd9-9bb2-9d2b4c1e7a3f\": Backend unavailable")))
+		testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "Correlation ID \"e6f5c4b1-0f0a-4bd9-9bb2-9d2b4c1e7a3f\": Backend unavailable"), err)
+	})
+
+	t.Run("InvalidPropagated", func(t *testing.T) {
+		// Correlation IDs that are not UUIDs in canonical form
+		// should be ignored, as they end up in log messages
+		// and errors.
+		ctxWithMetadata := metadata.NewIncomingContext(ctx, metadata.Pairs("correlation-id", "urn:uuid:e6f5c4b1-0f0a-4bd9-9bb2-9d2b4c1e7a3f"))
+
+		_, err := interceptor(ctxWithMetadata, req, nil, newHandler("36ebab65-3c4e-4d74-a01b-14bdac1d8c94", nil))
+		require.NoError(t, err)
+	})
+
+	t.Run("InvalidToolInvocationID", func(t *testing.T) {
+		requestMetadata, err := proto.Marshal(&remoteexecution.RequestMetadata{
+			ToolInvocationId: "\"Hello\": world",
+		})
+		require.NoError(t, err)
+		ctxWithMetadata := metadata.NewIncomingContext(ctx, metadata.Pairs(
+			"build.bazel.remote.execution.v2.requestmetadata-bin", string(requestMetadata)))
+
+		_, err = interceptor(ctxWithMetadata, req, nil, newHandler("36ebab65-3c4e-4d74-a01b-14bdac1d8c94", nil))
+		require.NoError(t, err)
+	})
+}
+
+func TestCorrelationIDUnaryClientInterceptor(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	interceptor := bb_grpc.NewCorrelationIDUnaryClientInterceptor()
+	invoker := mock.NewMockUnaryInvoker(ctrl)
+	req := &emptypb.Empty{}
+	resp := &emptypb.Empty{}
+
+	t.Run("NoCorrelationID", func(t *testing.T) {
+		// Outgoing requests should not be altered if the
+		// context is not annotated with a correlation ID.
+		invoker.EXPECT().Call(ctx, "SomeMethod", req, resp, nil)
+
+		require.NoError(t, interceptor(ctx, "SomeMethod", req, resp, nil, invoker.Call))
+	})
+
+	t.Run("CorrelationID", func(t *testing.T) {
+		invoker.EXPECT().Call(gomock.Any(), "SomeMethod", req, resp, nil).DoAndReturn(
+			func(ctx context.Context, method string, req, resp interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				md, ok := metadata.FromOutgoingContext(ctx)
+				require.True(t, ok)
+				require.Equal(
+					t,
+					metadata.New(map[string]string{
+						"correlation-id": "e6f5c4b1",
+					}),
+					md)
+				return nil
+			})
+
+		require.NoError(t, interceptor(logging.NewContextWithCorrelationID(ctx, "e6f5c4b1"), "SomeMethod", req, resp, nil, invoker.Call))
+	})
+}
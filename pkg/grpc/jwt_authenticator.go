@@ -0,0 +1,40 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/buildbarn/bb-storage/pkg/jwt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type jwtAuthenticator struct {
+	authorizationHeaderParser *jwt.AuthorizationHeaderParser
+	claimsMatcher             *jwt.ClaimsMatcher
+}
+
+// NewJWTAuthenticator creates an Authenticator that only grants access
+// in case the client provides a valid JSON Web Token in the
+// "Authorization" header, whose claims are accepted by a ClaimsMatcher.
+func NewJWTAuthenticator(authorizationHeaderParser *jwt.AuthorizationHeaderParser, claimsMatcher *jwt.ClaimsMatcher) Authenticator {
+	return &jwtAuthenticator{
+		authorizationHeaderParser: authorizationHeaderParser,
+		claimsMatcher:             claimsMatcher,
+	}
+}
+
+func (a *jwtAuthenticator) Authenticate(ctx context.Context) error {
+	header, ok := getFirstMetadataValue(ctx, "authorization")
+	if !ok {
+		return status.Error(codes.Unauthenticated, "No authorization header provided")
+	}
+	claims, err := a.authorizationHeaderParser.ParseAuthorizationHeader(header)
+	if err != nil {
+		return err
+	}
+	if !a.claimsMatcher.MatchClaims(claims) {
+		return status.Error(codes.Unauthenticated, "Token does not contain the required claims")
+	}
+	return nil
+}
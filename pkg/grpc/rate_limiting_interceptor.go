@@ -0,0 +1,350 @@
+package grpc
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
+	"github.com/buildbarn/bb-storage/pkg/reload"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// rateLimitingInterceptorMinimumPruneThreshold is the minimum number
+// of clients for which state is tracked before attempting to discard
+// state of clients that have been idle.
+const rateLimitingInterceptorMinimumPruneThreshold = 1024
+
+// tokenBucket is a simple implementation of the token bucket
+// algorithm. The number of tokens may become negative, which is used
+// to track transfers that exceeded the available capacity.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+}
+
+func newTokenBucket(rate float64, burst int64) tokenBucket {
+	// Permit at least a single second worth of tokens if no burst
+	// size is provided.
+	b := float64(burst)
+	if b <= 0 {
+		b = math.Max(rate, 1)
+	}
+	return tokenBucket{
+		rate:   rate,
+		burst:  b,
+		tokens: b,
+	}
+}
+
+func (tb *tokenBucket) isLimited() bool {
+	return tb.rate > 0
+}
+
+func (tb *tokenBucket) refill(elapsed time.Duration) {
+	tb.tokens = math.Min(tb.burst, tb.tokens+elapsed.Seconds()*tb.rate)
+}
+
+func (tb *tokenBucket) isFull() bool {
+	return !tb.isLimited() || tb.tokens >= tb.burst
+}
+
+// getDelay returns the amount of time it takes until the bucket
+// contains the provided number of tokens.
+func (tb *tokenBucket) getDelay(tokens float64) time.Duration {
+	if !tb.isLimited() || tb.tokens >= tokens {
+		return 0
+	}
+	return time.Duration((tokens - tb.tokens) / tb.rate * float64(time.Second))
+}
+
+type rateLimitingClientState struct {
+	requests   tokenBucket
+	bytes      tokenBucket
+	lastUpdate time.Time
+
+	// The number of requests of the client that are in progress.
+	// The state of clients with requests in progress may not be
+	// discarded, as these requests continue to transfer data.
+	activeRequests int
+}
+
+func (cs *rateLimitingClientState) refill(now time.Time) {
+	elapsed := now.Sub(cs.lastUpdate)
+	cs.requests.refill(elapsed)
+	cs.bytes.refill(elapsed)
+	cs.lastUpdate = now
+}
+
+// rateLimitingPolicy contains the parsed contents of a
+// RateLimitingPolicy configuration message.
+type rateLimitingPolicy struct {
+	clientIdentifier ClientIdentifier
+	defaultLimits    *configuration.RateLimits
+	tierLimits       map[string]*configuration.RateLimits
+}
+
+func newRateLimitingPolicy(policy *configuration.RateLimitingPolicy) (*rateLimitingPolicy, error) {
+	clientIdentifier, err := NewClientIdentifierFromConfiguration(policy.ClientIdentification)
+	if err != nil {
+		return nil, err
+	}
+	defaultLimits := policy.DefaultLimits
+	if defaultLimits == nil {
+		defaultLimits = &configuration.RateLimits{}
+	}
+	tierLimits := map[string]*configuration.RateLimits{}
+	for _, tier := range policy.Tiers {
+		limits := tier.Limits
+		if limits == nil {
+			limits = &configuration.RateLimits{}
+		}
+		for _, identity := range tier.Identities {
+			if _, ok := tierLimits[identity]; ok {
+				return nil, status.Errorf(codes.InvalidArgument, "Identity %#v is part of multiple rate limiting tiers", identity)
+			}
+			tierLimits[identity] = limits
+		}
+	}
+	return &rateLimitingPolicy{
+		clientIdentifier: clientIdentifier,
+		defaultLimits:    defaultLimits,
+		tierLimits:       tierLimits,
+	}, nil
+}
+
+// RateLimitingInterceptor is a gRPC server interceptor that limits the
+// rate at which individual clients may issue requests and transfer
+// data. Clients are identified using a ClientIdentifier, and may be
+// placed in tiers that have different limits.
+//
+// Requests that exceed the request rate are rejected with
+// RESOURCE_EXHAUSTED, including a RetryInfo message that indicates when
+// capacity becomes available once again. Messages that exceed the
+// transfer rate are delayed instead.
+type RateLimitingInterceptor struct {
+	clock clock.Clock
+
+	lock           sync.Mutex
+	policy         *rateLimitingPolicy
+	clients        map[string]*rateLimitingClientState
+	pruneThreshold int
+}
+
+// NewRateLimitingInterceptorFromConfiguration creates a
+// RateLimitingInterceptor based on a configuration file. The policy is
+// replaced when the configuration of the process is reloaded, by
+// registering the interceptor against the provided reload.Registry.
+func NewRateLimitingInterceptorFromConfiguration(policy *configuration.RateLimitingPolicy, clock clock.Clock, reloadRegistry *reload.Registry) (*RateLimitingInterceptor, error) {
+	parsedPolicy, err := newRateLimitingPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	i := &RateLimitingInterceptor{
+		clock:          clock,
+		policy:         parsedPolicy,
+		clients:        map[string]*rateLimitingClientState{},
+		pruneThreshold: rateLimitingInterceptorMinimumPruneThreshold,
+	}
+	reloadRegistry.Register(policy, func(newPolicy proto.Message) error {
+		return i.SetPolicy(newPolicy.(*configuration.RateLimitingPolicy))
+	})
+	return i, nil
+}
+
+// SetPolicy replaces the rate limiting policy. The state of all
+// clients is discarded, meaning that all clients start off with full
+// buckets.
+func (i *RateLimitingInterceptor) SetPolicy(policy *configuration.RateLimitingPolicy) error {
+	parsedPolicy, err := newRateLimitingPolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	i.lock.Lock()
+	i.policy = parsedPolicy
+	i.clients = map[string]*rateLimitingClientState{}
+	i.pruneThreshold = rateLimitingInterceptorMinimumPruneThreshold
+	i.lock.Unlock()
+	return nil
+}
+
+// pruneClients discards the state of clients whose buckets are full
+// and that have no requests in progress, as their state is identical
+// to that of clients that have not issued any requests. This prevents
+// unbounded growth of the map of clients.
+func (i *RateLimitingInterceptor) pruneClients(now time.Time) {
+	for identity, cs := range i.clients {
+		cs.refill(now)
+		if cs.activeRequests == 0 && cs.requests.isFull() && cs.bytes.isFull() {
+			delete(i.clients, identity)
+		}
+	}
+	i.pruneThreshold = 2 * len(i.clients)
+	if i.pruneThreshold < rateLimitingInterceptorMinimumPruneThreshold {
+		i.pruneThreshold = rateLimitingInterceptorMinimumPruneThreshold
+	}
+}
+
+// admit a request, consuming a token from the client's request
+// bucket. Requests are rejected if the client has no request tokens
+// left. Upon success, the request is marked as being in progress until
+// release() is called.
+func (i *RateLimitingInterceptor) admit(ctx context.Context) (*rateLimitingClientState, error) {
+	i.lock.Lock()
+	policy := i.policy
+	i.lock.Unlock()
+
+	identity := policy.clientIdentifier.IdentifyClient(ctx)
+	now := i.clock.Now()
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	cs, ok := i.clients[identity]
+	if !ok {
+		if len(i.clients) >= i.pruneThreshold {
+			i.pruneClients(now)
+		}
+		limits, ok := policy.tierLimits[identity]
+		if !ok {
+			limits = policy.defaultLimits
+		}
+		cs = &rateLimitingClientState{
+			requests:   newTokenBucket(limits.RequestsPerSecond, limits.RequestBurst),
+			bytes:      newTokenBucket(float64(limits.BytesPerSecond), limits.ByteBurst),
+			lastUpdate: now,
+		}
+		i.clients[identity] = cs
+	}
+	cs.refill(now)
+
+	if delay := cs.requests.getDelay(1); delay > 0 {
+		s, err := status.New(codes.ResourceExhausted, "Rate limit exceeded").WithDetails(&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(delay),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.Err()
+	}
+	if cs.requests.isLimited() {
+		cs.requests.tokens--
+	}
+	cs.activeRequests++
+	return cs, nil
+}
+
+// release marks a request that was admitted as being completed.
+func (i *RateLimitingInterceptor) release(cs *rateLimitingClientState) {
+	i.lock.Lock()
+	cs.activeRequests--
+	i.lock.Unlock()
+}
+
+// chargeMessage subtracts the size of a message that was transferred
+// from the client's byte bucket. If this causes the client to exceed
+// its capacity, it blocks until the capacity has been restored. This
+// throttles individual messages, causing large transfers to be spread
+// out over time.
+func (i *RateLimitingInterceptor) chargeMessage(ctx context.Context, cs *rateLimitingClientState, m interface{}) error {
+	if !cs.bytes.isLimited() {
+		return nil
+	}
+	message, ok := m.(proto.Message)
+	if !ok {
+		return nil
+	}
+	size := proto.Size(message)
+	now := i.clock.Now()
+
+	i.lock.Lock()
+	cs.refill(now)
+	cs.bytes.tokens -= float64(size)
+	delay := cs.bytes.getDelay(0)
+	i.lock.Unlock()
+
+	if delay > 0 {
+		timer, t := i.clock.NewTimer(delay)
+		select {
+		case <-t:
+		case <-ctx.Done():
+			timer.Stop()
+			return util.StatusFromContext(ctx)
+		}
+	}
+	return nil
+}
+
+// InterceptUnaryServer is a gRPC interceptor for unary calls that
+// enforces rate limits.
+func (i *RateLimitingInterceptor) InterceptUnaryServer(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	cs, err := i.admit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer i.release(cs)
+
+	if err := i.chargeMessage(ctx, cs, req); err != nil {
+		return nil, err
+	}
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.chargeMessage(ctx, cs, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+var _ grpc.UnaryServerInterceptor = (*RateLimitingInterceptor)(nil).InterceptUnaryServer
+
+// InterceptStreamServer is a gRPC interceptor for streaming calls
+// that enforces rate limits. Messages transferred as part of the stream
+// are accounted for while they are being sent and received.
+func (i *RateLimitingInterceptor) InterceptStreamServer(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	cs, err := i.admit(ss.Context())
+	if err != nil {
+		return err
+	}
+	defer i.release(cs)
+
+	return handler(srv, &rateLimitingServerStream{
+		ServerStream: ss,
+		interceptor:  i,
+		clientState:  cs,
+	})
+}
+
+var _ grpc.StreamServerInterceptor = (*RateLimitingInterceptor)(nil).InterceptStreamServer
+
+type rateLimitingServerStream struct {
+	grpc.ServerStream
+	interceptor *RateLimitingInterceptor
+	clientState *rateLimitingClientState
+}
+
+func (s *rateLimitingServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.interceptor.chargeMessage(s.Context(), s.clientState, m)
+}
+
+func (s *rateLimitingServerStream) SendMsg(m interface{}) error {
+	if err := s.interceptor.chargeMessage(s.Context(), s.clientState, m); err != nil {
+		return err
+	}
+	return s.ServerStream.SendMsg(m)
+}
@@ -0,0 +1,235 @@
+package grpc_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	bb_grpc "github.com/buildbarn/bb-storage/pkg/grpc"
+	configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
+	"github.com/buildbarn/bb-storage/pkg/reload"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func requireRateLimitExceeded(t *testing.T, retryDelay time.Duration, got error) {
+	want, err := status.New(codes.ResourceExhausted, "Rate limit exceeded").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(retryDelay),
+	})
+	require.NoError(t, err)
+	testutil.RequireEqualStatus(t, want.Err(), got)
+}
+
+func TestRateLimitingInterceptor(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	clock := mock.NewMockClock(ctrl)
+	interceptor, err := bb_grpc.NewRateLimitingInterceptorFromConfiguration(&configuration.RateLimitingPolicy{
+		ClientIdentification: &configuration.ClientIdentificationPolicy{
+			Policy: &configuration.ClientIdentificationPolicy_MetadataHeader{
+				MetadataHeader: "x-client-id",
+			},
+		},
+		DefaultLimits: &configuration.RateLimits{
+			RequestsPerSecond: 2,
+			RequestBurst:      2,
+		},
+		Tiers: []*configuration.RateLimitingTier{
+			{
+				Identities: []string{"bulk-uploader"},
+				Limits: &configuration.RateLimits{
+					BytesPerSecond: 100,
+					ByteBurst:      200,
+				},
+			},
+		},
+	}, clock, reload.NewRegistry())
+	require.NoError(t, err)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &durationpb.Duration{Seconds: 1000000}, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+
+	t.Run("RequestRate", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-client-id", "ci-pipeline"))
+
+		// The first two requests fit in the burst.
+		clock.EXPECT().Now().Return(time.Unix(1000, 0)).Times(2)
+		for i := 0; i < 2; i++ {
+			_, err := interceptor.InterceptUnaryServer(ctx, &durationpb.Duration{}, info, handler)
+			require.NoError(t, err)
+		}
+
+		// The third request should be rejected, as it takes
+		// half a second to obtain another token.
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		_, err := interceptor.InterceptUnaryServer(ctx, &durationpb.Duration{}, info, handler)
+		requireRateLimitExceeded(t, 500*time.Millisecond, err)
+
+		// Other clients should not be affected.
+		clock.EXPECT().Now().Return(time.Unix(1000, 0))
+		_, err = interceptor.InterceptUnaryServer(
+			metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-client-id", "developer")),
+			&durationpb.Duration{},
+			info,
+			handler)
+		require.NoError(t, err)
+
+		// After waiting, requests are permitted once again.
+		clock.EXPECT().Now().Return(time.Unix(1000, 500000000))
+		_, err = interceptor.InterceptUnaryServer(ctx, &durationpb.Duration{}, info, handler)
+		require.NoError(t, err)
+	})
+
+	t.Run("Bandwidth", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-client-id", "bulk-uploader"))
+		request := &durationpb.Duration{Seconds: 1000000, Nanos: 123}
+
+		// Requests are not limited for this tier. The request
+		// and response take 6 and 4 bytes, respectively,
+		// meaning that the burst is used up exactly after 20
+		// requests.
+		clock.EXPECT().Now().Return(time.Unix(2000, 0)).Times(20 * 3)
+		for i := 0; i < 20; i++ {
+			_, err := interceptor.InterceptUnaryServer(ctx, request, info, handler)
+			require.NoError(t, err)
+		}
+
+		// Subsequent requests are still admitted. Instead, every
+		// message is delayed until the client no longer
+		// exceeds its capacity.
+		clock.EXPECT().Now().Return(time.Unix(2000, 0)).Times(2)
+		requestTimer := make(chan time.Time, 1)
+		requestTimer <- time.Unix(2000, 60000000)
+		clock.EXPECT().NewTimer(60*time.Millisecond).Return(mock.NewMockTimer(ctrl), requestTimer)
+		clock.EXPECT().Now().Return(time.Unix(2000, 60000000))
+		responseTimer := make(chan time.Time, 1)
+		responseTimer <- time.Unix(2000, 100000000)
+		clock.EXPECT().NewTimer(40*time.Millisecond).Return(mock.NewMockTimer(ctrl), responseTimer)
+		_, err := interceptor.InterceptUnaryServer(ctx, request, info, handler)
+		require.NoError(t, err)
+	})
+
+	t.Run("BandwidthCanceled", func(t *testing.T) {
+		// Requests should be interrupted if they are canceled
+		// while being delayed.
+		ctx, cancel := context.WithCancel(metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-client-id", "bulk-uploader")))
+		cancel()
+		request := &durationpb.Duration{Seconds: 1000000, Nanos: 123}
+
+		clock.EXPECT().Now().Return(time.Unix(2000, 100000000)).Times(2)
+		timer := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(60*time.Millisecond).Return(timer, nil)
+		timer.EXPECT().Stop()
+		_, err := interceptor.InterceptUnaryServer(ctx, request, info, handler)
+		testutil.RequireEqualStatus(t, status.Error(codes.Canceled, "context canceled"), err)
+	})
+
+	t.Run("ActiveStreamNotPruned", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-client-id", "bulk-uploader"))
+		serverStream := mock.NewMockServerStream(ctrl)
+		serverStream.EXPECT().Context().Return(ctx).AnyTimes()
+		message := &wrapperspb.BytesValue{Value: make([]byte, 291)}
+
+		clock.EXPECT().Now().Return(time.Unix(2500, 0))
+		require.NoError(t, interceptor.InterceptStreamServer(
+			nil,
+			serverStream,
+			&grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"},
+			func(srv interface{}, stream grpc.ServerStream) error {
+				// Issue requests on behalf of many other
+				// clients, causing the state of clients
+				// whose buckets are full to be pruned.
+				clock.EXPECT().Now().Return(time.Unix(2500, 0)).Times(1022)
+				for i := 0; i < 1022; i++ {
+					_, err := interceptor.InterceptUnaryServer(
+						metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-client-id", fmt.Sprintf("client-%d", i))),
+						&durationpb.Duration{},
+						info,
+						handler)
+					require.NoError(t, err)
+				}
+
+				// Send a message of 294 bytes, which
+				// exceeds the burst by 94 bytes.
+				clock.EXPECT().Now().Return(time.Unix(2500, 0))
+				timer := make(chan time.Time, 1)
+				timer <- time.Unix(2501, 0)
+				clock.EXPECT().NewTimer(940*time.Millisecond).Return(mock.NewMockTimer(ctrl), timer)
+				serverStream.EXPECT().SendMsg(message)
+				return stream.SendMsg(message)
+			}))
+
+		// As the state of the client was not pruned while the
+		// stream was active, the data transferred through the
+		// stream should count towards subsequent requests.
+		clock.EXPECT().Now().Return(time.Unix(2500, 0)).Times(2)
+		timer := mock.NewMockTimer(ctrl)
+		clock.EXPECT().NewTimer(time.Second).Return(timer, nil)
+		timer.EXPECT().Stop()
+		ctxCanceled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := interceptor.InterceptUnaryServer(ctxCanceled, &durationpb.Duration{Seconds: 1000000, Nanos: 123}, info, handler)
+		testutil.RequireEqualStatus(t, status.Error(codes.Canceled, "context canceled"), err)
+	})
+
+	t.Run("SetPolicy", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-client-id", "ci-pipeline"))
+
+		// Exhaust the burst of the client.
+		clock.EXPECT().Now().Return(time.Unix(3000, 0)).Times(3)
+		for i := 0; i < 2; i++ {
+			_, err := interceptor.InterceptUnaryServer(ctx, &durationpb.Duration{}, info, handler)
+			require.NoError(t, err)
+		}
+		_, err := interceptor.InterceptUnaryServer(ctx, &durationpb.Duration{}, info, handler)
+		requireRateLimitExceeded(t, 500*time.Millisecond, err)
+
+		// Replacing the policy should discard the state of the
+		// client and apply the new limits.
+		require.NoError(t, interceptor.SetPolicy(&configuration.RateLimitingPolicy{
+			ClientIdentification: &configuration.ClientIdentificationPolicy{
+				Policy: &configuration.ClientIdentificationPolicy_MetadataHeader{
+					MetadataHeader: "x-client-id",
+				},
+			},
+			DefaultLimits: &configuration.RateLimits{
+				RequestsPerSecond: 1,
+				RequestBurst:      1,
+			},
+		}))
+		clock.EXPECT().Now().Return(time.Unix(3000, 0)).Times(2)
+		_, err = interceptor.InterceptUnaryServer(ctx, &durationpb.Duration{}, info, handler)
+		require.NoError(t, err)
+		_, err = interceptor.InterceptUnaryServer(ctx, &durationpb.Duration{}, info, handler)
+		requireRateLimitExceeded(t, time.Second, err)
+	})
+
+	t.Run("DuplicateIdentity", func(t *testing.T) {
+		_, err := bb_grpc.NewRateLimitingInterceptorFromConfiguration(&configuration.RateLimitingPolicy{
+			ClientIdentification: &configuration.ClientIdentificationPolicy{
+				Policy: &configuration.ClientIdentificationPolicy_MetadataHeader{
+					MetadataHeader: "x-client-id",
+				},
+			},
+			Tiers: []*configuration.RateLimitingTier{
+				{Identities: []string{"ci-pipeline"}},
+				{Identities: []string{"ci-pipeline"}},
+			},
+		}, clock, reload.NewRegistry())
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Identity \"ci-pipeline\" is part of multiple rate limiting tiers"), err)
+	})
+}
@@ -12,6 +12,26 @@ import (
 	"go.opencensus.io/trace"
 )
 
+// getRequestMetadataFromIncomingContext extracts the REv2 request
+// metadata from the metadata headers of an incoming request.
+func getRequestMetadataFromIncomingContext(ctx context.Context) (*remoteexecution.RequestMetadata, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	rmds := md.Get("build.bazel.remote.execution.v2.requestmetadata-bin")
+	if len(rmds) == 0 {
+		return nil, false
+	}
+
+	var rmd remoteexecution.RequestMetadata
+	if err := proto.Unmarshal([]byte(rmds[0]), &rmd); err != nil {
+		return nil, false
+	}
+	return &rmd, true
+}
+
 // NewRequestMetadataFetchingStatsHandler is meant to wrap ocgrpc.ServerHandler,
 // and exports additional information about the RPC from REAPI request metadata
 func NewRequestMetadataFetchingStatsHandler(base stats.Handler) stats.Handler {
@@ -36,22 +56,11 @@ func (rmfsh requestMetadataFetchingStatsHandler) TagRPC(ctx context.Context, rti
 		return ctx
 	}
 
-	md, ok := metadata.FromIncomingContext(ctx)
+	rmd, ok := getRequestMetadataFromIncomingContext(ctx)
 	if !ok {
 		return ctx
 	}
 
-	rmds := md.Get("build.bazel.remote.execution.v2.requestmetadata-bin")
-	if len(rmds) == 0 {
-		return ctx
-	}
-
-	var rmd remoteexecution.RequestMetadata
-
-	if err := proto.Unmarshal([]byte(rmds[0]), &rmd); err != nil {
-		return ctx
-	}
-
 	span.AddAttributes(
 		trace.StringAttribute("action_id", rmd.ActionId),
 		trace.StringAttribute("tool_invocation_id", rmd.ToolInvocationId),
@@ -4,8 +4,11 @@ import (
 	"net"
 	"os"
 
+	"github.com/buildbarn/bb-storage/pkg/clock"
 	configuration "github.com/buildbarn/bb-storage/pkg/proto/configuration/grpc"
+	"github.com/buildbarn/bb-storage/pkg/reload"
 	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/google/uuid"
 	"github.com/grpc-ecosystem/go-grpc-prometheus"
 
 	"google.golang.org/grpc"
@@ -31,7 +34,18 @@ func init() {
 // based on a configuration stored in a list of Protobuf messages. It
 // then lets all of these gRPC servers listen on the network addresses
 // of UNIX socket paths provided.
-func NewServersFromConfigurationAndServe(configurations []*configuration.ServerConfiguration, registrationFunc func(*grpc.Server)) error {
+//
+// If a ServerDrainer is provided, the gRPC servers are registered
+// against it, so that they may be stopped gracefully. Servers that are
+// stopped this way do not cause this function to return.
+//
+// If a ServingStatus is provided, it controls the status reported by
+// the health checking service. Otherwise, SERVING is reported.
+//
+// Rate limiting policies are registered against the provided
+// reload.Registry, so that they are replaced when the configuration of
+// the process is reloaded.
+func NewServersFromConfigurationAndServe(configurations []*configuration.ServerConfiguration, registrationFunc func(*grpc.Server), drainer *ServerDrainer, servingStatus *ServingStatus, reloadRegistry *reload.Registry) error {
 	serveErrors := make(chan error)
 
 	for _, configuration := range configurations {
@@ -41,14 +55,38 @@ func NewServersFromConfigurationAndServe(configurations []*configuration.ServerC
 			return err
 		}
 
+		var unaryInterceptors []grpc.UnaryServerInterceptor
+		var streamInterceptors []grpc.StreamServerInterceptor
+
+		// Optional: Annotate requests with correlation IDs.
+		if configuration.EnableCorrelationIds {
+			unaryInterceptors = append(unaryInterceptors, NewCorrelationIDUnaryServerInterceptor(uuid.NewRandom))
+			streamInterceptors = append(streamInterceptors, NewCorrelationIDStreamServerInterceptor(uuid.NewRandom))
+		}
+
+		unaryInterceptors = append(
+			unaryInterceptors,
+			grpc_prometheus.UnaryServerInterceptor,
+			NewAuthenticatingUnaryInterceptor(authenticator))
+		streamInterceptors = append(
+			streamInterceptors,
+			grpc_prometheus.StreamServerInterceptor,
+			NewAuthenticatingStreamInterceptor(authenticator))
+
+		// Optional: Rate limiting of individual clients.
+		if policy := configuration.RateLimitingPolicy; policy != nil {
+			rateLimitingInterceptor, err := NewRateLimitingInterceptorFromConfiguration(policy, clock.SystemClock, reloadRegistry)
+			if err != nil {
+				return util.StatusWrap(err, "Failed to create rate limiting interceptor")
+			}
+			unaryInterceptors = append(unaryInterceptors, rateLimitingInterceptor.InterceptUnaryServer)
+			streamInterceptors = append(streamInterceptors, rateLimitingInterceptor.InterceptStreamServer)
+		}
+
 		// Default server options.
 		serverOptions := []grpc.ServerOption{
-			grpc.ChainUnaryInterceptor(
-				grpc_prometheus.UnaryServerInterceptor,
-				NewAuthenticatingUnaryInterceptor(authenticator)),
-			grpc.ChainStreamInterceptor(
-				grpc_prometheus.StreamServerInterceptor,
-				NewAuthenticatingStreamInterceptor(authenticator)),
+			grpc.ChainUnaryInterceptor(unaryInterceptors...),
+			grpc.ChainStreamInterceptor(streamInterceptors...),
 			grpc.StatsHandler(NewRequestMetadataFetchingStatsHandler(&ocgrpc.ServerHandler{})),
 		}
 
@@ -84,12 +122,17 @@ func NewServersFromConfigurationAndServe(configurations []*configuration.ServerC
 		reflection.Register(s)
 		h := health.NewServer()
 		grpc_health_v1.RegisterHealthServer(s, h)
-		// TODO: Construct an API for the caller to indicate
-		// when it is healthy and set this.
-		h.SetServingStatus(configuration.HealthCheckService, grpc_health_v1.HealthCheckResponse_SERVING)
+		if servingStatus == nil {
+			h.SetServingStatus(configuration.HealthCheckService, grpc_health_v1.HealthCheckResponse_SERVING)
+		} else {
+			servingStatus.addHealthServer(h, configuration.HealthCheckService)
+		}
+		if drainer != nil {
+			drainer.addServer(s, h)
+		}
 
-		if len(configuration.ListenAddresses)+len(configuration.ListenPaths) == 0 {
-			return status.Error(codes.InvalidArgument, "GRPC server configured without any listen addresses or paths")
+		if len(configuration.ListenAddresses)+len(configuration.ListenPaths)+len(configuration.SystemdSocketNames) == 0 {
+			return status.Error(codes.InvalidArgument, "GRPC server configured without any listen addresses, paths or systemd sockets")
 		}
 
 		// TCP sockets.
@@ -98,7 +141,7 @@ func NewServersFromConfigurationAndServe(configurations []*configuration.ServerC
 			if err != nil {
 				return util.StatusWrapf(err, "Failed to create listening socket for %#v", listenAddress)
 			}
-			go func() { serveErrors <- s.Serve(sock) }()
+			go func() { serve(s, sock, serveErrors) }()
 		}
 
 		// UNIX sockets.
@@ -110,8 +153,34 @@ func NewServersFromConfigurationAndServe(configurations []*configuration.ServerC
 			if err != nil {
 				return util.StatusWrapf(err, "Failed to create listening socket for %#v", listenPath)
 			}
-			go func() { serveErrors <- s.Serve(sock) }()
+			if permissions := configuration.ListenPathPermissions; permissions != 0 {
+				if err := os.Chmod(listenPath, os.FileMode(permissions)); err != nil {
+					sock.Close()
+					return util.StatusWrapf(err, "Failed to set permissions of socket %#v", listenPath)
+				}
+			}
+			go func() { serve(s, sock, serveErrors) }()
+		}
+
+		// Sockets passed by systemd (socket activation).
+		for _, socketName := range configuration.SystemdSocketNames {
+			socks, err := getSystemdListeners(socketName)
+			if err != nil {
+				return util.StatusWrapf(err, "Failed to obtain systemd socket %#v", socketName)
+			}
+			for _, sock := range socks {
+				sock := sock
+				go func() { serve(s, sock, serveErrors) }()
+			}
 		}
 	}
 	return <-serveErrors
 }
+
+func serve(s *grpc.Server, sock net.Listener, serveErrors chan<- error) {
+	// Serve() returns nil if the server was stopped by
+	// ServerDrainer, which should not be reported as a failure.
+	if err := s.Serve(sock); err != nil {
+		serveErrors <- err
+	}
+}
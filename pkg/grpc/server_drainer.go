@@ -0,0 +1,87 @@
+package grpc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+)
+
+// ServerDrainer keeps track of gRPC servers created by
+// NewServersFromConfigurationAndServe(), so that they can be stopped
+// gracefully. This permits rolling restarts to be performed without
+// aborting requests that are in flight, such as large ByteStream
+// uploads.
+type ServerDrainer struct {
+	clock clock.Clock
+
+	lock          sync.Mutex
+	draining      bool
+	servers       []*grpc.Server
+	healthServers []*health.Server
+}
+
+// NewServerDrainer creates a ServerDrainer that does not track any
+// gRPC servers yet.
+func NewServerDrainer(clock clock.Clock) *ServerDrainer {
+	return &ServerDrainer{
+		clock: clock,
+	}
+}
+
+func (d *ServerDrainer) addServer(s *grpc.Server, h *health.Server) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.draining {
+		// Draining was requested while servers were still being
+		// created. Don't let this server accept any requests.
+		h.Shutdown()
+		s.Stop()
+		return
+	}
+	d.servers = append(d.servers, s)
+	d.healthServers = append(d.healthServers, h)
+}
+
+// Drain all gRPC servers. Health checks start to report NOT_SERVING,
+// and servers stop accepting new connections and streams. This function
+// blocks until all requests in flight have completed. Requests that
+// are still running after the grace period has elapsed are aborted.
+func (d *ServerDrainer) Drain(gracePeriod time.Duration) {
+	d.lock.Lock()
+	d.draining = true
+	servers, healthServers := d.servers, d.healthServers
+	d.lock.Unlock()
+
+	for _, h := range healthServers {
+		h.Shutdown()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(servers))
+	for _, s := range servers {
+		go func(s *grpc.Server) {
+			s.GracefulStop()
+			wg.Done()
+		}(s)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timer, t := d.clock.NewTimer(gracePeriod)
+	select {
+	case <-done:
+		timer.Stop()
+	case <-t:
+		for _, s := range servers {
+			s.Stop()
+		}
+		<-done
+	}
+}
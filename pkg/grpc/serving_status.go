@@ -0,0 +1,60 @@
+package grpc
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+type healthServerService struct {
+	server  *health.Server
+	service string
+}
+
+// ServingStatus can be used by applications to control the status that
+// is reported by the health checking service of gRPC servers created
+// by NewServersFromConfigurationAndServe(). This can be used to let
+// readiness probes reflect whether the application is capable of
+// processing requests (e.g., because storage backends are healthy).
+//
+// Servers initially report NOT_SERVING, until SetServing() is called.
+type ServingStatus struct {
+	lock          sync.Mutex
+	serving       bool
+	healthServers []healthServerService
+}
+
+// NewServingStatus creates a ServingStatus that reports NOT_SERVING.
+func NewServingStatus() *ServingStatus {
+	return &ServingStatus{}
+}
+
+func (ss *ServingStatus) getHealthCheckResponseStatus() grpc_health_v1.HealthCheckResponse_ServingStatus {
+	if ss.serving {
+		return grpc_health_v1.HealthCheckResponse_SERVING
+	}
+	return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+}
+
+func (ss *ServingStatus) addHealthServer(h *health.Server, service string) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.healthServers = append(ss.healthServers, healthServerService{
+		server:  h,
+		service: service,
+	})
+	h.SetServingStatus(service, ss.getHealthCheckResponseStatus())
+}
+
+// SetServing changes the status that is reported by the health checking
+// service of all gRPC servers.
+func (ss *ServingStatus) SetServing(serving bool) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.serving = serving
+	status := ss.getHealthCheckResponseStatus()
+	for _, h := range ss.healthServers {
+		h.server.SetServingStatus(h.service, status)
+	}
+}
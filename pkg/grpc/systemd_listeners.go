@@ -0,0 +1,86 @@
+package grpc
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// systemdListenFDsStart is the first file descriptor number that is
+// used by systemd to pass sockets, as described in sd_listen_fds(3).
+const systemdListenFDsStart = 3
+
+var (
+	systemdListenersOnce sync.Once
+	systemdListenersLock sync.Mutex
+	systemdListeners     map[string][]net.Listener
+	systemdListenersErr  error
+)
+
+// loadSystemdListeners converts the file descriptors passed to the
+// process by systemd to listeners, grouped by name. The environment
+// variables are unset afterwards, so that they are not inherited by
+// child processes.
+func loadSystemdListeners() (map[string][]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := map[string][]net.Listener{}
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		// Sockets were not passed to this process.
+		return listeners, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid number of file descriptors passed by systemd: %#v", os.Getenv("LISTEN_FDS"))
+	}
+	var names []string
+	if value := os.Getenv("LISTEN_FDNAMES"); value != "" {
+		names = strings.Split(value, ":")
+	}
+	for i := 0; i < count; i++ {
+		// systemd uses "unknown" for sockets without a name if
+		// LISTEN_FDNAMES is not set.
+		name := "unknown"
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(systemdListenFDsStart+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Failed to create listener for socket %#v passed by systemd", name)
+		}
+		listeners[name] = append(listeners[name], l)
+	}
+	return listeners, nil
+}
+
+// getSystemdListeners returns the listeners for sockets with a given
+// name that were passed to the process by systemd. Listeners can only
+// be obtained once, as they cannot be shared by multiple servers.
+func getSystemdListeners(name string) ([]net.Listener, error) {
+	systemdListenersOnce.Do(func() {
+		systemdListeners, systemdListenersErr = loadSystemdListeners()
+	})
+	if systemdListenersErr != nil {
+		return nil, systemdListenersErr
+	}
+
+	systemdListenersLock.Lock()
+	defer systemdListenersLock.Unlock()
+	listeners, ok := systemdListeners[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "No sockets named %#v were passed by systemd, or they are already in use", name)
+	}
+	delete(systemdListeners, name)
+	return listeners, nil
+}
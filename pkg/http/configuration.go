@@ -0,0 +1,92 @@
+package http
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/http"
+	"github.com/buildbarn/bb-storage/pkg/random"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NewClientFromConfiguration creates an HTTP client based on a
+// configuration file. If no configuration is provided, Go's default
+// HTTP client is returned.
+func NewClientFromConfiguration(configuration *pb.ClientConfiguration) (*http.Client, error) {
+	if configuration == nil {
+		return http.DefaultClient, nil
+	}
+
+	roundTripper := http.DefaultTransport
+	if len(configuration.HostCredentials) > 0 {
+		hostHeaders := map[string]http.Header{}
+		for _, credentials := range configuration.HostCredentials {
+			host := strings.ToLower(credentials.Host)
+			if host == "" {
+				return nil, status.Error(codes.InvalidArgument, "Host credentials do not specify a host")
+			}
+			if _, ok := hostHeaders[host]; ok {
+				return nil, status.Errorf(codes.InvalidArgument, "Credentials for host %#v are specified multiple times", host)
+			}
+			headers := http.Header{}
+			for name, value := range credentials.Headers {
+				headers.Set(name, value)
+			}
+			switch authentication := credentials.Authentication.(type) {
+			case *pb.HostCredentialsConfiguration_Basic:
+				headers.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString(
+					[]byte(authentication.Basic.Username+":"+authentication.Basic.Password)))
+			case *pb.HostCredentialsConfiguration_BearerToken:
+				headers.Set("Authorization", "Bearer "+authentication.BearerToken)
+			}
+			hostHeaders[host] = headers
+		}
+		roundTripper = NewCredentialsRoundTripper(roundTripper, hostHeaders)
+	}
+
+	if retry := configuration.Retry; retry != nil {
+		if retry.MaximumAttempts == 0 {
+			return nil, status.Error(codes.InvalidArgument, "Maximum number of attempts must be positive")
+		}
+		if err := retry.InitialBackoff.CheckValid(); err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to obtain initial backoff")
+		}
+		if err := retry.MaximumBackoff.CheckValid(); err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to obtain maximum backoff")
+		}
+		backoffMultiplier := 2.0
+		if retry.BackoffMultiplier != 0 {
+			if retry.BackoffMultiplier < 1 {
+				return nil, status.Error(codes.InvalidArgument, "Backoff multiplier must be at least 1")
+			}
+			backoffMultiplier = retry.BackoffMultiplier
+		}
+		roundTripper = NewRetryingRoundTripper(
+			roundTripper,
+			clock.SystemClock,
+			random.FastThreadSafeGenerator,
+			RetryPolicy{
+				MaximumAttempts:   int(retry.MaximumAttempts),
+				InitialBackoff:    retry.InitialBackoff.AsDuration(),
+				MaximumBackoff:    retry.MaximumBackoff.AsDuration(),
+				BackoffMultiplier: backoffMultiplier,
+			})
+	}
+
+	maximumRedirects := int(configuration.MaximumRedirects)
+	return &http.Client{
+		Transport: roundTripper,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maximumRedirects {
+				return fmt.Errorf("stopped after %d redirects", maximumRedirects)
+			}
+			return nil
+		},
+	}, nil
+}
@@ -0,0 +1,44 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+)
+
+type credentialsRoundTripper struct {
+	base        http.RoundTripper
+	hostHeaders map[string]http.Header
+}
+
+// NewCredentialsRoundTripper creates a decorator for http.RoundTripper
+// that attaches headers containing credentials to requests, based on
+// the host to which the request is sent. Keys of hostHeaders are host
+// names in lower case, optionally followed by a port number. Host
+// names with a port number take precedence over ones without.
+//
+// As credentials are selected for every request individually, they
+// are not leaked to other hosts when a redirect is followed.
+func NewCredentialsRoundTripper(base http.RoundTripper, hostHeaders map[string]http.Header) http.RoundTripper {
+	return &credentialsRoundTripper{
+		base:        base,
+		hostHeaders: hostHeaders,
+	}
+}
+
+func (rt *credentialsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	headers, ok := rt.hostHeaders[strings.ToLower(req.URL.Host)]
+	if !ok {
+		headers, ok = rt.hostHeaders[strings.ToLower(req.URL.Hostname())]
+		if !ok {
+			return rt.base.RoundTrip(req)
+		}
+	}
+
+	// RoundTrippers are not permitted to modify the request that is
+	// provided. Attach the headers to a copy.
+	newReq := req.Clone(req.Context())
+	for name, values := range headers {
+		newReq.Header[name] = values
+	}
+	return rt.base.RoundTrip(newReq)
+}
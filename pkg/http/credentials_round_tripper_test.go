@@ -0,0 +1,90 @@
+package http_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	bb_http "github.com/buildbarn/bb-storage/pkg/http"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialsRoundTripper(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	baseRoundTripper := mock.NewMockRoundTripper(ctrl)
+	roundTripper := bb_http.NewCredentialsRoundTripper(
+		baseRoundTripper,
+		map[string]http.Header{
+			"artifacts.example.com": {
+				"Authorization": []string{"Bearer token1"},
+			},
+			"artifacts.example.com:8443": {
+				"Authorization": []string{"Bearer token2"},
+			},
+			"mirror.example.com": {
+				"Private-Token": []string{"token3"},
+			},
+		})
+
+	t.Run("NoCredentials", func(t *testing.T) {
+		// Requests for hosts for which no credentials are
+		// configured should be forwarded unmodified.
+		req, err := http.NewRequest(http.MethodGet, "https://other.example.com/file.tar.gz", nil)
+		require.NoError(t, err)
+		resp := &http.Response{StatusCode: http.StatusOK}
+		baseRoundTripper.EXPECT().RoundTrip(req).Return(resp, nil)
+
+		actualResp, err := roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, resp, actualResp)
+	})
+
+	t.Run("HostName", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "https://Artifacts.Example.com/file.tar.gz", nil)
+		require.NoError(t, err)
+		resp := &http.Response{StatusCode: http.StatusOK}
+		baseRoundTripper.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(newReq *http.Request) (*http.Response, error) {
+			require.Equal(t, "Bearer token1", newReq.Header.Get("Authorization"))
+			return resp, nil
+		})
+
+		actualResp, err := roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, resp, actualResp)
+
+		// The original request should not have been modified.
+		require.Empty(t, req.Header.Get("Authorization"))
+	})
+
+	t.Run("HostNameAndPort", func(t *testing.T) {
+		// Credentials for a specific port take precedence.
+		req, err := http.NewRequest(http.MethodGet, "https://artifacts.example.com:8443/file.tar.gz", nil)
+		require.NoError(t, err)
+		resp := &http.Response{StatusCode: http.StatusOK}
+		baseRoundTripper.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(newReq *http.Request) (*http.Response, error) {
+			require.Equal(t, "Bearer token2", newReq.Header.Get("Authorization"))
+			return resp, nil
+		})
+
+		actualResp, err := roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, resp, actualResp)
+	})
+
+	t.Run("CustomHeader", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "https://mirror.example.com:8080/file.tar.gz", nil)
+		require.NoError(t, err)
+		resp := &http.Response{StatusCode: http.StatusOK}
+		baseRoundTripper.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(newReq *http.Request) (*http.Response, error) {
+			require.Equal(t, "token3", newReq.Header.Get("Private-Token"))
+			require.Empty(t, newReq.Header.Get("Authorization"))
+			return resp, nil
+		})
+
+		actualResp, err := roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, resp, actualResp)
+	})
+}
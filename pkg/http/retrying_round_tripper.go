@@ -0,0 +1,108 @@
+package http
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/random"
+)
+
+// RetryPolicy describes how often requests performed by
+// RetryingRoundTripper are retried.
+type RetryPolicy struct {
+	// The maximum number of attempts, including the initial one.
+	MaximumAttempts int
+	// The delay before the first retry. Subsequent delays are
+	// multiplied by BackoffMultiplier, up to MaximumBackoff.
+	InitialBackoff    time.Duration
+	MaximumBackoff    time.Duration
+	BackoffMultiplier float64
+}
+
+type retryingRoundTripper struct {
+	base                  http.RoundTripper
+	clock                 clock.Clock
+	randomNumberGenerator random.ThreadSafeGenerator
+	policy                RetryPolicy
+}
+
+// NewRetryingRoundTripper creates a decorator for http.RoundTripper
+// that retries requests that fail due to transport errors, or that
+// fail with HTTP status 429 ("Too Many Requests") or 5xx. Between
+// attempts, it waits according to an exponential backoff with full
+// jitter, similar to RetryingBlobAccess.
+//
+// Requests having a body are only retried if the body can be obtained
+// again through Request.GetBody. If all attempts fail, the response
+// or error of the last attempt is returned.
+func NewRetryingRoundTripper(base http.RoundTripper, clock clock.Clock, randomNumberGenerator random.ThreadSafeGenerator, policy RetryPolicy) http.RoundTripper {
+	return &retryingRoundTripper{
+		base:                  base,
+		clock:                 clock,
+		randomNumberGenerator: randomNumberGenerator,
+		policy:                policy,
+	}
+}
+
+func isRetryableResponse(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// waitForBackoff waits for the backoff delay to pass after a given
+// number of attempts have failed. It returns false if the request is
+// canceled in the meantime.
+func (rt *retryingRoundTripper) waitForBackoff(req *http.Request, attempts int) bool {
+	backoff := float64(rt.policy.InitialBackoff)
+	for i := 1; i < attempts; i++ {
+		backoff *= rt.policy.BackoffMultiplier
+	}
+	if maximumBackoff := float64(rt.policy.MaximumBackoff); backoff > maximumBackoff {
+		backoff = maximumBackoff
+	}
+	var delay time.Duration
+	if backoff >= 1 {
+		delay = time.Duration(rt.randomNumberGenerator.Uint64() % uint64(backoff))
+	}
+
+	timer, timerChannel := rt.clock.NewTimer(delay)
+	select {
+	case <-timerChannel:
+		return true
+	case <-req.Context().Done():
+		timer.Stop()
+		return false
+	}
+}
+
+func (rt *retryingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	attemptReq := req
+	for attempts := 1; ; attempts++ {
+		resp, err := rt.base.RoundTrip(attemptReq)
+		if !replayable || attempts >= rt.policy.MaximumAttempts || !isRetryableResponse(resp, err) {
+			return resp, err
+		}
+
+		// Discard the response of the failed attempt, so that the
+		// underlying connection may be reused.
+		if err == nil {
+			io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+		}
+		if !rt.waitForBackoff(req, attempts) {
+			return nil, req.Context().Err()
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package http_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	bb_http "github.com/buildbarn/bb-storage/pkg/http"
+	"github.com/buildbarn/bb-storage/pkg/random"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryingRoundTripper(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	baseRoundTripper := mock.NewMockRoundTripper(ctrl)
+	mockClock := mock.NewMockClock(ctrl)
+	roundTripper := bb_http.NewRetryingRoundTripper(
+		baseRoundTripper,
+		mockClock,
+		random.FastThreadSafeGenerator,
+		bb_http.RetryPolicy{
+			MaximumAttempts:   3,
+			InitialBackoff:    time.Second,
+			MaximumBackoff:    3 * time.Second,
+			BackoffMultiplier: 4,
+		})
+
+	// expectBackoff expects a single backoff delay to be applied,
+	// which should be bounded by the provided duration.
+	expectBackoff := func(maximumDelay time.Duration) {
+		timer := mock.NewMockTimer(ctrl)
+		mockClock.EXPECT().NewTimer(gomock.Any()).DoAndReturn(func(d time.Duration) (clock.Timer, <-chan time.Time) {
+			require.True(t, d >= 0 && d < maximumDelay)
+			ch := make(chan time.Time, 1)
+			ch <- time.Unix(1000, 0)
+			return timer, ch
+		})
+	}
+
+	newResponse := func(statusCode int) *http.Response {
+		return &http.Response{
+			StatusCode: statusCode,
+			Body:       ioutil.NopCloser(bytes.NewBufferString("Hello")),
+		}
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "https://example.com/file.tar.gz", nil)
+		require.NoError(t, err)
+		resp := newResponse(http.StatusOK)
+		baseRoundTripper.EXPECT().RoundTrip(req).Return(resp, nil)
+
+		actualResp, err := roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, resp, actualResp)
+	})
+
+	t.Run("NonRetryableStatus", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "https://example.com/file.tar.gz", nil)
+		require.NoError(t, err)
+		resp := newResponse(http.StatusNotFound)
+		baseRoundTripper.EXPECT().RoundTrip(req).Return(resp, nil)
+
+		actualResp, err := roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, resp, actualResp)
+	})
+
+	t.Run("SuccessAfterRetry", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "https://example.com/file.tar.gz", nil)
+		require.NoError(t, err)
+		resp := newResponse(http.StatusOK)
+		gomock.InOrder(
+			baseRoundTripper.EXPECT().RoundTrip(req).Return(nil, errors.New("connection reset by peer")),
+			baseRoundTripper.EXPECT().RoundTrip(req).Return(newResponse(http.StatusServiceUnavailable), nil),
+			baseRoundTripper.EXPECT().RoundTrip(req).Return(resp, nil))
+		expectBackoff(time.Second)
+		expectBackoff(3 * time.Second)
+
+		actualResp, err := roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, resp, actualResp)
+	})
+
+	t.Run("AttemptsExhausted", func(t *testing.T) {
+		// The response of the last attempt should be returned.
+		req, err := http.NewRequest(http.MethodGet, "https://example.com/file.tar.gz", nil)
+		require.NoError(t, err)
+		resp := newResponse(http.StatusTooManyRequests)
+		gomock.InOrder(
+			baseRoundTripper.EXPECT().RoundTrip(req).Return(newResponse(http.StatusBadGateway), nil),
+			baseRoundTripper.EXPECT().RoundTrip(req).Return(newResponse(http.StatusTooManyRequests), nil),
+			baseRoundTripper.EXPECT().RoundTrip(req).Return(resp, nil))
+		expectBackoff(time.Second)
+		expectBackoff(3 * time.Second)
+
+		actualResp, err := roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, resp, actualResp)
+	})
+
+	t.Run("ReplayedBody", func(t *testing.T) {
+		// Request bodies should be obtained again when retrying.
+		req, err := http.NewRequest(http.MethodPost, "https://example.com/upload", bytes.NewBufferString("Payload"))
+		require.NoError(t, err)
+		resp := newResponse(http.StatusOK)
+		gomock.InOrder(
+			baseRoundTripper.EXPECT().RoundTrip(req).Return(nil, errors.New("connection reset by peer")),
+			baseRoundTripper.EXPECT().RoundTrip(gomock.Any()).DoAndReturn(func(newReq *http.Request) (*http.Response, error) {
+				body, err := ioutil.ReadAll(newReq.Body)
+				require.NoError(t, err)
+				require.Equal(t, []byte("Payload"), body)
+				return resp, nil
+			}))
+		expectBackoff(time.Second)
+
+		actualResp, err := roundTripper.RoundTrip(req)
+		require.NoError(t, err)
+		require.Equal(t, resp, actualResp)
+	})
+
+	t.Run("NonReplayableBody", func(t *testing.T) {
+		// Requests whose body cannot be obtained again should
+		// only be attempted once.
+		req, err := http.NewRequest(http.MethodPost, "https://example.com/upload", nil)
+		require.NoError(t, err)
+		req.Body = ioutil.NopCloser(bytes.NewBufferString("Payload"))
+		baseRoundTripper.EXPECT().RoundTrip(req).Return(nil, errors.New("connection reset by peer"))
+
+		_, err = roundTripper.RoundTrip(req)
+		require.Equal(t, errors.New("connection reset by peer"), err)
+	})
+}
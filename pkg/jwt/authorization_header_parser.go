@@ -0,0 +1,168 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Claims contained in the payload of a JSON Web Token.
+type Claims map[string]interface{}
+
+type cachedClaims struct {
+	expirationTime time.Time
+	claims         Claims
+}
+
+// AuthorizationHeaderParser extracts JSON Web Tokens from
+// "Authorization" headers having the form "Bearer ${token}". Tokens are
+// only accepted if their signature is valid, and if the current time
+// lies within the token's validity period.
+//
+// As clients tend to send the same token along with every request,
+// the claims of tokens that are accepted are cached. This prevents
+// signatures from being validated over and over again.
+type AuthorizationHeaderParser struct {
+	clock              clock.Clock
+	signatureValidator SignatureValidator
+	cacheSize          int
+	cacheDuration      time.Duration
+
+	lock        sync.Mutex
+	cache       map[string]cachedClaims
+	evictionSet eviction.Set
+}
+
+// NewAuthorizationHeaderParser creates an AuthorizationHeaderParser
+// that validates the signatures of tokens using a SignatureValidator.
+// The claims of at most cacheSize tokens are cached for cacheDuration,
+// which bounds the amount of time it takes for keys that are removed
+// from the SignatureValidator to no longer be trusted.
+func NewAuthorizationHeaderParser(clock clock.Clock, signatureValidator SignatureValidator, cacheSize int, cacheDuration time.Duration, evictionSet eviction.Set) *AuthorizationHeaderParser {
+	return &AuthorizationHeaderParser{
+		clock:              clock,
+		signatureValidator: signatureValidator,
+		cacheSize:          cacheSize,
+		cacheDuration:      cacheDuration,
+
+		cache:       map[string]cachedClaims{},
+		evictionSet: evictionSet,
+	}
+}
+
+func getTimestampClaim(claims Claims, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false, status.Errorf(codes.Unauthenticated, "Claim %#v is not a number", name)
+	}
+	return time.Unix(0, int64(seconds*1e9)), true, nil
+}
+
+// ParseAuthorizationHeader parses the value of an "Authorization"
+// header, returning the claims of the JSON Web Token it contains.
+func (p *AuthorizationHeaderParser) ParseAuthorizationHeader(header string) (Claims, error) {
+	const prefix = "bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return nil, status.Error(codes.Unauthenticated, "Authorization header does not contain a bearer token")
+	}
+	token := header[len(prefix):]
+
+	p.lock.Lock()
+	entry, ok := p.cache[token]
+	if ok {
+		p.evictionSet.Touch(token)
+	}
+	p.lock.Unlock()
+	if ok {
+		if now := p.clock.Now(); now.Before(entry.expirationTime) {
+			if err := checkValidityPeriod(entry.claims, now); err != nil {
+				return nil, err
+			}
+			return entry.claims, nil
+		}
+	}
+
+	// Tokens consist of a header, payload and signature, separated
+	// by periods.
+	fields := strings.Split(token, ".")
+	if len(fields) != 3 {
+		return nil, status.Error(codes.Unauthenticated, "Bearer token is not a JSON Web Token")
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(fields[0])
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Failed to decode token header")
+	}
+	var tokenHeader struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := json.Unmarshal(rawHeader, &tokenHeader); err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Failed to parse token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(fields[2])
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Failed to decode token signature")
+	}
+	if !p.signatureValidator.ValidateSignature(tokenHeader.Algorithm, tokenHeader.KeyID, fields[0]+"."+fields[1], signature) {
+		return nil, status.Error(codes.Unauthenticated, "Token signature is invalid")
+	}
+
+	rawPayload, err := base64.RawURLEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Failed to decode token payload")
+	}
+	var claims Claims
+	if err := json.Unmarshal(rawPayload, &claims); err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Failed to parse token payload")
+	}
+
+	// Check the validity period of the token.
+	now := p.clock.Now()
+	if err := checkValidityPeriod(claims, now); err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	if _, ok := p.cache[token]; !ok {
+		// Free up space to insert the token.
+		if len(p.cache) >= p.cacheSize {
+			delete(p.cache, p.evictionSet.Peek())
+			p.evictionSet.Remove()
+		}
+		p.evictionSet.Insert(token)
+	}
+	p.cache[token] = cachedClaims{
+		expirationTime: now.Add(p.cacheDuration),
+		claims:         claims,
+	}
+	p.lock.Unlock()
+	return claims, nil
+}
+
+// checkValidityPeriod returns an error if the current time does not
+// lie within the validity period of a token.
+func checkValidityPeriod(claims Claims, now time.Time) error {
+	if expirationTime, ok, err := getTimestampClaim(claims, "exp"); err != nil {
+		return err
+	} else if ok && !now.Before(expirationTime) {
+		return status.Error(codes.Unauthenticated, "Token has expired")
+	}
+	if notBeforeTime, ok, err := getTimestampClaim(claims, "nbf"); err != nil {
+		return err
+	} else if ok && now.Before(notBeforeTime) {
+		return status.Error(codes.Unauthenticated, "Token is not valid yet")
+	}
+	return nil
+}
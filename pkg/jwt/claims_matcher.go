@@ -0,0 +1,71 @@
+package jwt
+
+import (
+	"strconv"
+)
+
+// ClaimsMatcher determines whether the claims of a JSON Web Token
+// satisfy a set of requirements. For every required claim, a list of
+// accepted values is provided. Claims whose value is a list (e.g.,
+// "aud" or "groups") match if any of their elements is accepted.
+type ClaimsMatcher struct {
+	requiredClaims map[string]map[string]struct{}
+}
+
+// NewClaimsMatcher creates a ClaimsMatcher. A ClaimsMatcher that
+// requires no claims matches all tokens.
+func NewClaimsMatcher(requiredClaims map[string][]string) *ClaimsMatcher {
+	cm := &ClaimsMatcher{
+		requiredClaims: make(map[string]map[string]struct{}, len(requiredClaims)),
+	}
+	for name, values := range requiredClaims {
+		acceptedValues := make(map[string]struct{}, len(values))
+		for _, value := range values {
+			acceptedValues[value] = struct{}{}
+		}
+		cm.requiredClaims[name] = acceptedValues
+	}
+	return cm
+}
+
+func claimValueToString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	default:
+		return "", false
+	}
+}
+
+func matchClaimValue(value interface{}, acceptedValues map[string]struct{}) bool {
+	if list, ok := value.([]interface{}); ok {
+		for _, element := range list {
+			if matchClaimValue(element, acceptedValues) {
+				return true
+			}
+		}
+		return false
+	}
+	s, ok := claimValueToString(value)
+	if !ok {
+		return false
+	}
+	_, ok = acceptedValues[s]
+	return ok
+}
+
+// MatchClaims returns whether the claims of a token satisfy all of the
+// requirements.
+func (cm *ClaimsMatcher) MatchClaims(claims Claims) bool {
+	for name, acceptedValues := range cm.requiredClaims {
+		value, ok := claims[name]
+		if !ok || !matchClaimValue(value, acceptedValues) {
+			return false
+		}
+	}
+	return true
+}
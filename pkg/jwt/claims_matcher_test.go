@@ -0,0 +1,52 @@
+package jwt_test
+
+import (
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimsMatcher(t *testing.T) {
+	claimsMatcher := jwt.NewClaimsMatcher(map[string][]string{
+		"iss":            {"https://accounts.example.com"},
+		"groups":         {"admins", "developers"},
+		"email_verified": {"true"},
+	})
+
+	t.Run("Match", func(t *testing.T) {
+		require.True(t, claimsMatcher.MatchClaims(jwt.Claims{
+			"iss":            "https://accounts.example.com",
+			"groups":         []interface{}{"users", "developers"},
+			"email_verified": true,
+			"sub":            "alice",
+		}))
+	})
+
+	t.Run("MissingClaim", func(t *testing.T) {
+		require.False(t, claimsMatcher.MatchClaims(jwt.Claims{
+			"iss":    "https://accounts.example.com",
+			"groups": []interface{}{"developers"},
+		}))
+	})
+
+	t.Run("MismatchingValue", func(t *testing.T) {
+		require.False(t, claimsMatcher.MatchClaims(jwt.Claims{
+			"iss":            "https://evil.example.com",
+			"groups":         []interface{}{"developers"},
+			"email_verified": true,
+		}))
+	})
+
+	t.Run("MismatchingList", func(t *testing.T) {
+		require.False(t, claimsMatcher.MatchClaims(jwt.Claims{
+			"iss":            "https://accounts.example.com",
+			"groups":         []interface{}{"users"},
+			"email_verified": true,
+		}))
+	})
+
+	t.Run("NoRequirements", func(t *testing.T) {
+		require.True(t, jwt.NewClaimsMatcher(nil).MatchClaims(jwt.Claims{}))
+	})
+}
@@ -0,0 +1,75 @@
+package jwt
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/eviction"
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/jwt"
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// The number of tokens whose claims are cached by
+	// AuthorizationHeaderParser, and the amount of time for which
+	// they are cached.
+	tokenCacheSize     = 1000
+	tokenCacheDuration = time.Minute
+)
+
+// NewAuthorizationHeaderParserFromConfiguration creates an
+// AuthorizationHeaderParser based on a configuration file. If keys are
+// downloaded from a URL, they are refreshed in the background.
+func NewAuthorizationHeaderParserFromConfiguration(configuration *pb.AuthorizationHeaderParserConfiguration) (*AuthorizationHeaderParser, error) {
+	if configuration == nil {
+		return nil, status.Error(codes.InvalidArgument, "No authorization header parser configuration provided")
+	}
+	var signatureValidator SignatureValidator
+	switch keySet := configuration.KeySet.(type) {
+	case *pb.AuthorizationHeaderParserConfiguration_JwksInline:
+		var err error
+		signatureValidator, err = NewSignatureValidatorFromJSONWebKeySet([]byte(keySet.JwksInline))
+		if err != nil {
+			return nil, err
+		}
+	case *pb.AuthorizationHeaderParserConfiguration_JwksUrl:
+		refreshInterval := configuration.JwksRefreshInterval
+		if err := refreshInterval.CheckValid(); err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to obtain JSON Web Key Set refresh interval")
+		}
+		refreshIntervalDuration := refreshInterval.AsDuration()
+		if refreshIntervalDuration <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "JSON Web Key Set refresh interval must be positive")
+		}
+		refreshingSignatureValidator := NewRefreshingSignatureValidator(http.DefaultClient, keySet.JwksUrl)
+		go func() {
+			for {
+				ctx, cancel := context.WithTimeout(context.Background(), refreshIntervalDuration)
+				if err := refreshingSignatureValidator.Refresh(ctx); err != nil {
+					util.DefaultErrorLogger.Log(util.StatusWrapf(err, "Failed to refresh JSON Web Key Set from %#v", keySet.JwksUrl))
+				}
+				cancel()
+				time.Sleep(refreshIntervalDuration)
+			}
+		}()
+		signatureValidator = refreshingSignatureValidator
+	default:
+		return nil, status.Error(codes.InvalidArgument, "No JSON Web Key Set provided")
+	}
+	return NewAuthorizationHeaderParser(clock.SystemClock, signatureValidator, tokenCacheSize, tokenCacheDuration, eviction.NewLRUSet()), nil
+}
+
+// NewClaimsMatcherFromConfiguration creates a ClaimsMatcher based on
+// a configuration file.
+func NewClaimsMatcherFromConfiguration(requiredClaims map[string]*pb.ClaimValues) *ClaimsMatcher {
+	claims := make(map[string][]string, len(requiredClaims))
+	for name, values := range requiredClaims {
+		claims[name] = values.GetValues()
+	}
+	return NewClaimsMatcher(claims)
+}
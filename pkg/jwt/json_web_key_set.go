@@ -0,0 +1,207 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+
+	// Register hash functions used by the signature algorithms.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// jsonWebKey is the JSON representation of a key, as described in RFC
+// 7517. Only the fields needed to reconstruct public keys are parsed.
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+
+	// RSA public keys.
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// Elliptic curve and Edwards-curve public keys.
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+type publicKey struct {
+	keyID string
+	key   crypto.PublicKey
+}
+
+type jsonWebKeySetSignatureValidator struct {
+	keys []publicKey
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k *jsonWebKey) getPublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid modulus")
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid exponent")
+		}
+		if !e.IsInt64() || e.Int64() <= 1 || e.Int64() > 1<<31-1 {
+			return nil, status.Error(codes.InvalidArgument, "Exponent is out of range")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "Unsupported elliptic curve %#v", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid X coordinate")
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid Y coordinate")
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, status.Error(codes.InvalidArgument, "Point is not on the elliptic curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, status.Errorf(codes.InvalidArgument, "Unsupported Edwards curve %#v", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Invalid public key")
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, status.Error(codes.InvalidArgument, "Public key has an incorrect size")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "Unsupported key type %#v", k.KeyType)
+	}
+}
+
+// NewSignatureValidatorFromJSONWebKeySet creates a SignatureValidator
+// that validates signatures against the public keys contained in a
+// JSON Web Key Set (JWKS), as described in RFC 7517. Keys of types that
+// are not supported and keys that are not used for signing are
+// ignored, as identity providers may publish them for other purposes.
+//
+// The following signature algorithms are supported: RS256, RS384,
+// RS512, ES256, ES384, ES512 and EdDSA.
+func NewSignatureValidatorFromJSONWebKeySet(data []byte) (SignatureValidator, error) {
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		return nil, util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to parse JSON Web Key Set")
+	}
+	sv := &jsonWebKeySetSignatureValidator{}
+	for _, key := range keySet.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if key.KeyType != "RSA" && key.KeyType != "EC" && key.KeyType != "OKP" {
+			continue
+		}
+		pk, err := key.getPublicKey()
+		if err != nil {
+			return nil, util.StatusWrapf(err, "Invalid key %#v", key.KeyID)
+		}
+		sv.keys = append(sv.keys, publicKey{
+			keyID: key.KeyID,
+			key:   pk,
+		})
+	}
+	return sv, nil
+}
+
+func verifyRSA(key crypto.PublicKey, hash crypto.Hash, headerAndPayload string, signature []byte) bool {
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return false
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(headerAndPayload))
+	return rsa.VerifyPKCS1v15(rsaKey, hash, hasher.Sum(nil), signature) == nil
+}
+
+func verifyECDSA(key crypto.PublicKey, curve elliptic.Curve, hash crypto.Hash, headerAndPayload string, signature []byte) bool {
+	ecdsaKey, ok := key.(*ecdsa.PublicKey)
+	if !ok || ecdsaKey.Curve != curve {
+		return false
+	}
+	// Signatures are the concatenation of R and S, both having the
+	// size of the curve's order.
+	size := (curve.Params().BitSize + 7) / 8
+	if len(signature) != 2*size {
+		return false
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(headerAndPayload))
+	return ecdsa.Verify(
+		ecdsaKey,
+		hasher.Sum(nil),
+		new(big.Int).SetBytes(signature[:size]),
+		new(big.Int).SetBytes(signature[size:]))
+}
+
+func verifySignature(algorithm string, key crypto.PublicKey, headerAndPayload string, signature []byte) bool {
+	switch algorithm {
+	case "RS256":
+		return verifyRSA(key, crypto.SHA256, headerAndPayload, signature)
+	case "RS384":
+		return verifyRSA(key, crypto.SHA384, headerAndPayload, signature)
+	case "RS512":
+		return verifyRSA(key, crypto.SHA512, headerAndPayload, signature)
+	case "ES256":
+		return verifyECDSA(key, elliptic.P256(), crypto.SHA256, headerAndPayload, signature)
+	case "ES384":
+		return verifyECDSA(key, elliptic.P384(), crypto.SHA384, headerAndPayload, signature)
+	case "ES512":
+		return verifyECDSA(key, elliptic.P521(), crypto.SHA512, headerAndPayload, signature)
+	case "EdDSA":
+		ed25519Key, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(ed25519Key, []byte(headerAndPayload), signature)
+	default:
+		return false
+	}
+}
+
+func (sv *jsonWebKeySetSignatureValidator) ValidateSignature(algorithm, keyID, headerAndPayload string, signature []byte) bool {
+	for _, key := range sv.keys {
+		if (keyID == "" || key.keyID == keyID) && verifySignature(algorithm, key.key, headerAndPayload, signature) {
+			return true
+		}
+	}
+	return false
+}
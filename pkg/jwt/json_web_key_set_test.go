@@ -0,0 +1,94 @@
+package jwt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/jwt"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const headerAndPayload = "eyJhbGciOiJFUzI1NiIsImtpZCI6ImtleTEifQ.eyJzdWIiOiJhbGljZSJ9"
+
+func TestSignatureValidatorFromJSONWebKeySet(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ed25519PublicKey, ed25519PrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signatureValidator, err := jwt.NewSignatureValidatorFromJSONWebKeySet([]byte(fmt.Sprintf(`{
+		"keys": [
+			{"kty": "EC", "kid": "ec", "use": "sig", "crv": "P-256", "x": %#v, "y": %#v},
+			{"kty": "RSA", "kid": "rsa", "n": %#v, "e": "AQAB"},
+			{"kty": "OKP", "kid": "ed25519", "crv": "Ed25519", "x": %#v},
+			{"kty": "RSA", "kid": "encryption", "use": "enc", "n": "", "e": ""},
+			{"kty": "oct", "kid": "symmetric", "k": "c2VjcmV0"}
+		]
+	}`,
+		base64.RawURLEncoding.EncodeToString(ecdsaKey.X.Bytes()),
+		base64.RawURLEncoding.EncodeToString(ecdsaKey.Y.Bytes()),
+		base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(ed25519PublicKey))))
+	require.NoError(t, err)
+
+	hash := crypto.SHA256.New()
+	hash.Write([]byte(headerAndPayload))
+	digest := hash.Sum(nil)
+
+	t.Run("ES256", func(t *testing.T) {
+		r, s, err := ecdsa.Sign(rand.Reader, ecdsaKey, digest)
+		require.NoError(t, err)
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+
+		require.True(t, signatureValidator.ValidateSignature("ES256", "ec", headerAndPayload, signature))
+		require.True(t, signatureValidator.ValidateSignature("ES256", "", headerAndPayload, signature))
+		require.False(t, signatureValidator.ValidateSignature("ES256", "rsa", headerAndPayload, signature))
+		require.False(t, signatureValidator.ValidateSignature("ES384", "ec", headerAndPayload, signature))
+		require.False(t, signatureValidator.ValidateSignature("ES256", "ec", headerAndPayload+"x", signature))
+	})
+
+	t.Run("RS256", func(t *testing.T) {
+		signature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest)
+		require.NoError(t, err)
+
+		require.True(t, signatureValidator.ValidateSignature("RS256", "rsa", headerAndPayload, signature))
+		require.False(t, signatureValidator.ValidateSignature("RS512", "rsa", headerAndPayload, signature))
+		require.False(t, signatureValidator.ValidateSignature("none", "rsa", headerAndPayload, signature))
+	})
+
+	t.Run("EdDSA", func(t *testing.T) {
+		signature := ed25519.Sign(ed25519PrivateKey, []byte(headerAndPayload))
+
+		require.True(t, signatureValidator.ValidateSignature("EdDSA", "ed25519", headerAndPayload, signature))
+		require.False(t, signatureValidator.ValidateSignature("EdDSA", "ec", headerAndPayload, signature))
+	})
+}
+
+func TestSignatureValidatorFromJSONWebKeySetInvalid(t *testing.T) {
+	t.Run("InvalidJSON", func(t *testing.T) {
+		_, err := jwt.NewSignatureValidatorFromJSONWebKeySet([]byte("{"))
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("PointNotOnCurve", func(t *testing.T) {
+		_, err := jwt.NewSignatureValidatorFromJSONWebKeySet([]byte(`{
+			"keys": [{"kty": "EC", "kid": "ec", "crv": "P-256", "x": "AQ", "y": "AQ"}]
+		}`))
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Invalid key \"ec\": Point is not on the elliptic curve"), err)
+	})
+}
@@ -0,0 +1,85 @@
+package jwt
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maximumJSONWebKeySetSizeBytes limits the size of JSON Web Key Sets
+// that are downloaded, to prevent unbounded memory usage.
+const maximumJSONWebKeySetSizeBytes = 1 << 20
+
+// RefreshingSignatureValidator is a SignatureValidator that validates
+// signatures against a JSON Web Key Set (JWKS) that is downloaded from
+// a URL, such as the "jwks_uri" published by an OpenID Connect
+// identity provider. Refresh() needs to be called periodically to pick
+// up keys that are rotated.
+//
+// Signatures are not considered valid until the key set has been
+// downloaded successfully. If downloading fails afterwards, the
+// previously downloaded key set continues to be used.
+type RefreshingSignatureValidator struct {
+	httpClient *http.Client
+	url        string
+
+	lock      sync.RWMutex
+	validator SignatureValidator
+}
+
+// NewRefreshingSignatureValidator creates a
+// RefreshingSignatureValidator that has not downloaded any keys yet.
+func NewRefreshingSignatureValidator(httpClient *http.Client, url string) *RefreshingSignatureValidator {
+	return &RefreshingSignatureValidator{
+		httpClient: httpClient,
+		url:        url,
+	}
+}
+
+// Refresh the JSON Web Key Set by downloading it.
+func (sv *RefreshingSignatureValidator) Refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sv.url, nil)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to create request")
+	}
+	resp, err := sv.httpClient.Do(req)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Unavailable, "Failed to download JSON Web Key Set")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return status.Errorf(codes.Unavailable, "Downloading JSON Web Key Set failed with HTTP status %#v", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maximumJSONWebKeySetSizeBytes+1))
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Unavailable, "Failed to read JSON Web Key Set")
+	}
+	if len(data) > maximumJSONWebKeySetSizeBytes {
+		return status.Errorf(codes.InvalidArgument, "JSON Web Key Set exceeds the maximum size of %d bytes", maximumJSONWebKeySetSizeBytes)
+	}
+	validator, err := NewSignatureValidatorFromJSONWebKeySet(data)
+	if err != nil {
+		return err
+	}
+
+	sv.lock.Lock()
+	sv.validator = validator
+	sv.lock.Unlock()
+	return nil
+}
+
+// ValidateSignature validates a signature against the most recently
+// downloaded JSON Web Key Set.
+func (sv *RefreshingSignatureValidator) ValidateSignature(algorithm, keyID, headerAndPayload string, signature []byte) bool {
+	sv.lock.RLock()
+	validator := sv.validator
+	sv.lock.RUnlock()
+	return validator != nil && validator.ValidateSignature(algorithm, keyID, headerAndPayload, signature)
+}
@@ -0,0 +1,12 @@
+package jwt
+
+// SignatureValidator is used by AuthorizationHeaderParser to validate
+// the signature of a JSON Web Token.
+type SignatureValidator interface {
+	// ValidateSignature returns whether the signature of a token is
+	// valid. The algorithm and key ID are obtained from the token's
+	// header. The key ID may be empty. headerAndPayload contains
+	// the first two fields of the token, including the separating
+	// period.
+	ValidateSignature(algorithm, keyID, headerAndPayload string, signature []byte) bool
+}
@@ -0,0 +1,25 @@
+package logging
+
+import (
+	"context"
+)
+
+type correlationIDKey struct{}
+
+// NewContextWithCorrelationID returns a copy of a context that is
+// annotated with a correlation ID. Correlation IDs are identifiers of
+// requests that are included in log messages and errors, so that the
+// processing of a single request may be traced across services.
+func NewContextWithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, correlationID)
+}
+
+// GetCorrelationIDFromContext returns the correlation ID with which a
+// context is annotated. If the context is not annotated with a
+// correlation ID, the empty string is returned.
+func GetCorrelationIDFromContext(ctx context.Context) string {
+	if correlationID, ok := ctx.Value(correlationIDKey{}).(string); ok {
+		return correlationID
+	}
+	return ""
+}
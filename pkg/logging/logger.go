@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	jsonOutputLock sync.Mutex
+	jsonOutput     io.Writer
+)
+
+// jsonLogEntry is the format of a log message when JSON logging is
+// enabled. Every log message is written as a single line.
+type jsonLogEntry struct {
+	Time          string `json:"time"`
+	Message       string `json:"message"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+func writeJSONLogEntry(message, correlationID string) {
+	data, err := json.Marshal(jsonLogEntry{
+		Time:          time.Now().UTC().Format(time.RFC3339Nano),
+		Message:       message,
+		CorrelationID: correlationID,
+	})
+	if err != nil {
+		// Strings can always be marshaled, so this should
+		// never happen.
+		panic(err)
+	}
+	jsonOutputLock.Lock()
+	jsonOutput.Write(append(data, '\n'))
+	jsonOutputLock.Unlock()
+}
+
+// jsonLogWriter is passed to log.SetOutput() when JSON logging is
+// enabled, so that messages written through Go's standard logging
+// package are converted to JSON as well.
+type jsonLogWriter struct{}
+
+func (jsonLogWriter) Write(p []byte) (int, error) {
+	writeJSONLogEntry(string(bytes.TrimSuffix(p, []byte{'\n'})), "")
+	return len(p), nil
+}
+
+// SetJSONOutput causes all log messages to be written to a writer as
+// JSON objects, one per line. This includes messages written through
+// Go's standard logging package.
+func SetJSONOutput(w io.Writer) {
+	jsonOutputLock.Lock()
+	jsonOutput = w
+	jsonOutputLock.Unlock()
+
+	log.SetFlags(0)
+	log.SetOutput(jsonLogWriter{})
+}
+
+// Printf writes a log message that is related to the processing of a
+// request. If the provided context is annotated with a correlation ID,
+// it is included in the log message.
+func Printf(ctx context.Context, format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	correlationID := GetCorrelationIDFromContext(ctx)
+
+	jsonOutputLock.Lock()
+	useJSON := jsonOutput != nil
+	jsonOutputLock.Unlock()
+	if useJSON {
+		writeJSONLogEntry(message, correlationID)
+	} else if correlationID != "" {
+		log.Output(2, fmt.Sprintf("Correlation ID %#v: %s", correlationID, message))
+	} else {
+		log.Output(2, message)
+	}
+}
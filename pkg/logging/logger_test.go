@@ -0,0 +1,44 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONOutput(t *testing.T) {
+	var output bytes.Buffer
+	logging.SetJSONOutput(&output)
+
+	// Messages written through Go's standard logging package and
+	// messages related to requests should both be converted to
+	// JSON. Only the latter have a correlation ID.
+	log.Print("Hello")
+	logging.Printf(context.Background(), "Number %d", 42)
+	logging.Printf(logging.NewContextWithCorrelationID(context.Background(), "8d8d6b55-bd97-4ff4-ae4b-0c25d6fd7f4d"), "Blob %#v was malformed", "example")
+
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	require.Len(t, lines, 3)
+	var entries []map[string]string
+	for _, line := range lines {
+		var entry map[string]string
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		require.NotEmpty(t, entry["time"])
+		delete(entry, "time")
+		entries = append(entries, entry)
+	}
+	require.Equal(t, []map[string]string{
+		{"message": "Hello"},
+		{"message": "Number 42"},
+		{
+			"message":        "Blob \"example\" was malformed",
+			"correlation_id": "8d8d6b55-bd97-4ff4-ae4b-0c25d6fd7f4d",
+		},
+	}, entries)
+}
@@ -0,0 +1,26 @@
+package memcached
+
+import (
+	"context"
+)
+
+// MaximumKeyLength is the maximum length of keys that may be stored
+// in memcached.
+const MaximumKeyLength = 250
+
+// Client of memcached. Implementations of this interface may either
+// communicate with a single server, or distribute keys across multiple
+// servers.
+type Client interface {
+	// Get the value associated with a key. A NOT_FOUND error is
+	// returned if the key is absent.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set the value associated with a key. The expiration is
+	// provided in seconds, where zero means that the key never
+	// expires.
+	Set(ctx context.Context, key string, value []byte, expiration uint32) error
+	// Delete a key. Deleting keys that are absent is not an error.
+	Delete(ctx context.Context, key string) error
+	// FindMissing returns the subset of keys that are absent.
+	FindMissing(ctx context.Context, keys []string) ([]string, error)
+}
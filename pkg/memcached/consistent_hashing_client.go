@@ -0,0 +1,109 @@
+package memcached
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type ringEntry struct {
+	hash   uint64
+	client Client
+}
+
+type consistentHashingClient struct {
+	ring []ringEntry
+}
+
+// NewConsistentHashingClient creates a Client that distributes keys
+// across multiple backends using consistent hashing. Every backend is
+// placed on a hash ring at a number of points that is derived from its
+// name. This ensures that adding or removing a backend only causes a
+// small fraction of keys to be relocated.
+func NewConsistentHashingClient(clients map[string]Client, pointsPerClient int) Client {
+	ring := make([]ringEntry, 0, len(clients)*pointsPerClient)
+	for name, client := range clients {
+		for i := 0; i < pointsPerClient; i++ {
+			ring = append(ring, ringEntry{
+				hash:   hashString(fmt.Sprintf("%s-%d", name, i)),
+				client: client,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+	return &consistentHashingClient{
+		ring: ring,
+	}
+}
+
+// hashString computes the position of a string on the hash ring.
+// SHA-256 is used, as simpler hash functions like FNV tend to cluster
+// keys that only differ in their last characters.
+func hashString(s string) uint64 {
+	h := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(h[:])
+}
+
+func (cc *consistentHashingClient) getClient(key string) Client {
+	hash := hashString(key)
+	i := sort.Search(len(cc.ring), func(i int) bool {
+		return cc.ring[i].hash >= hash
+	})
+	if i == len(cc.ring) {
+		i = 0
+	}
+	return cc.ring[i].client
+}
+
+func (cc *consistentHashingClient) Get(ctx context.Context, key string) ([]byte, error) {
+	return cc.getClient(key).Get(ctx, key)
+}
+
+func (cc *consistentHashingClient) Set(ctx context.Context, key string, value []byte, expiration uint32) error {
+	return cc.getClient(key).Set(ctx, key, value, expiration)
+}
+
+func (cc *consistentHashingClient) Delete(ctx context.Context, key string) error {
+	return cc.getClient(key).Delete(ctx, key)
+}
+
+func (cc *consistentHashingClient) FindMissing(ctx context.Context, keys []string) ([]string, error) {
+	// Partition the keys by backend and query all backends in
+	// parallel.
+	keysPerClient := map[Client][]string{}
+	for _, key := range keys {
+		client := cc.getClient(key)
+		keysPerClient[client] = append(keysPerClient[client], key)
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var missing []string
+	var findErr error
+	for client, clientKeys := range keysPerClient {
+		wg.Add(1)
+		go func(client Client, clientKeys []string) {
+			defer wg.Done()
+			clientMissing, err := client.FindMissing(ctx, clientKeys)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if findErr == nil {
+					findErr = err
+				}
+				return
+			}
+			missing = append(missing, clientMissing...)
+		}(client, clientKeys)
+	}
+	wg.Wait()
+	if findErr != nil {
+		return nil, findErr
+	}
+	return missing, nil
+}
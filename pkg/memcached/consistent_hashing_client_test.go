@@ -0,0 +1,93 @@
+package memcached_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/memcached"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConsistentHashingClient(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	server1 := mock.NewMockClient(ctrl)
+	server2 := mock.NewMockClient(ctrl)
+	client := memcached.NewConsistentHashingClient(map[string]memcached.Client{
+		"server1:11211": server1,
+		"server2:11211": server2,
+	}, 100)
+
+	t.Run("Distribution", func(t *testing.T) {
+		// Keys should be spread across both servers, and the
+		// same key should always end up at the same server.
+		servers := map[string]string{}
+		server1.EXPECT().Delete(ctx, gomock.Any()).DoAndReturn(func(ctx context.Context, key string) error {
+			require.NotEqual(t, "server2", servers[key])
+			servers[key] = "server1"
+			return nil
+		}).AnyTimes()
+		server2.EXPECT().Delete(ctx, gomock.Any()).DoAndReturn(func(ctx context.Context, key string) error {
+			require.NotEqual(t, "server1", servers[key])
+			servers[key] = "server2"
+			return nil
+		}).AnyTimes()
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("key%d", i)
+			require.NoError(t, client.Delete(ctx, key))
+			require.NoError(t, client.Delete(ctx, key))
+		}
+
+		counts := map[string]int{}
+		for _, server := range servers {
+			counts[server]++
+		}
+		require.Less(t, 250, counts["server1"])
+		require.Less(t, 250, counts["server2"])
+	})
+
+	t.Run("FindMissingSuccess", func(t *testing.T) {
+		// Requests should be partitioned by server, and the
+		// results should be merged.
+		var keys1, keys2 []string
+		server1.EXPECT().FindMissing(ctx, gomock.Any()).DoAndReturn(func(ctx context.Context, keys []string) ([]string, error) {
+			keys1 = keys
+			return keys[:1], nil
+		})
+		server2.EXPECT().FindMissing(ctx, gomock.Any()).DoAndReturn(func(ctx context.Context, keys []string) ([]string, error) {
+			keys2 = keys
+			return keys[:1], nil
+		})
+
+		var keys []string
+		for i := 0; i < 20; i++ {
+			keys = append(keys, fmt.Sprintf("key%d", i))
+		}
+		missing, err := client.FindMissing(ctx, keys)
+		require.NoError(t, err)
+		require.Equal(t, 20, len(keys1)+len(keys2))
+		sort.Strings(missing)
+		expected := []string{keys1[0], keys2[0]}
+		sort.Strings(expected)
+		require.Equal(t, expected, missing)
+	})
+
+	t.Run("FindMissingFailure", func(t *testing.T) {
+		server1.EXPECT().FindMissing(ctx, gomock.Any()).Return(nil, status.Error(codes.Unavailable, "Server offline"))
+		server2.EXPECT().FindMissing(ctx, gomock.Any()).Return(nil, nil)
+
+		var keys []string
+		for i := 0; i < 20; i++ {
+			keys = append(keys, fmt.Sprintf("key%d", i))
+		}
+		_, err := client.FindMissing(ctx, keys)
+		require.Equal(t, status.Error(codes.Unavailable, "Server offline"), err)
+	})
+}
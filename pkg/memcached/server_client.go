@@ -0,0 +1,301 @@
+package memcached
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Opcodes of the memcached binary protocol. More details:
+// https://github.com/memcached/memcached/wiki/BinaryProtocolRevamped
+const (
+	opcodeGet    = 0x00
+	opcodeSet    = 0x01
+	opcodeDelete = 0x04
+	opcodeNoop   = 0x0a
+	opcodeGetQ   = 0x09
+
+	magicRequest  = 0x80
+	magicResponse = 0x81
+
+	statusNoError       = 0x0000
+	statusKeyNotFound   = 0x0001
+	statusValueTooLarge = 0x0003
+
+	headerSizeBytes = 24
+)
+
+type response struct {
+	opcode uint8
+	status uint16
+	opaque uint32
+	value  []byte
+}
+
+// connection to a memcached server, with buffering applied in both
+// directions.
+type connection struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func (c *connection) writeRequest(opcode uint8, opaque uint32, key string, extras, value []byte) error {
+	var header [headerSizeBytes]byte
+	header[0] = magicRequest
+	header[1] = opcode
+	binary.BigEndian.PutUint16(header[2:], uint16(len(key)))
+	header[4] = uint8(len(extras))
+	binary.BigEndian.PutUint32(header[8:], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:], opaque)
+	if _, err := c.writer.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := c.writer.Write(extras); err != nil {
+		return err
+	}
+	if _, err := c.writer.WriteString(key); err != nil {
+		return err
+	}
+	_, err := c.writer.Write(value)
+	return err
+}
+
+func (c *connection) readResponse() (response, error) {
+	var header [headerSizeBytes]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return response{}, err
+	}
+	if header[0] != magicResponse {
+		return response{}, status.Errorf(codes.Internal, "Received response with invalid magic 0x%02x", header[0])
+	}
+	keyLength := int(binary.BigEndian.Uint16(header[2:]))
+	extrasLength := int(header[4])
+	body := make([]byte, binary.BigEndian.Uint32(header[8:]))
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return response{}, err
+	}
+	if keyLength+extrasLength > len(body) {
+		return response{}, status.Error(codes.Internal, "Received response with invalid body length")
+	}
+	return response{
+		opcode: header[1],
+		status: binary.BigEndian.Uint16(header[6:]),
+		opaque: binary.BigEndian.Uint32(header[12:]),
+		value:  body[extrasLength+keyLength:],
+	}, nil
+}
+
+type serverClient struct {
+	address         string
+	dialTimeout     time.Duration
+	idleConnections chan *connection
+}
+
+// NewServerClient creates a Client that communicates with a single
+// memcached server using the binary protocol. Connections are
+// established on demand. Up to maximumIdleConnections connections are
+// kept open for reuse.
+func NewServerClient(address string, dialTimeout time.Duration, maximumIdleConnections int) Client {
+	return &serverClient{
+		address:         address,
+		dialTimeout:     dialTimeout,
+		idleConnections: make(chan *connection, maximumIdleConnections),
+	}
+}
+
+// withConnection runs a function against a connection to the server.
+// Connections are only reused if the function completes successfully,
+// as failures may leave the connection in an unknown state.
+func (sc *serverClient) withConnection(ctx context.Context, f func(c *connection) error) error {
+	var c *connection
+	select {
+	case c = <-sc.idleConnections:
+	default:
+		dialer := net.Dialer{Timeout: sc.dialTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", sc.address)
+		if err != nil {
+			return util.StatusWrapfWithCode(err, codes.Unavailable, "Failed to connect to %#v", sc.address)
+		}
+		c = &connection{
+			conn:   conn,
+			reader: bufio.NewReader(conn),
+			writer: bufio.NewWriter(conn),
+		}
+	}
+
+	// Apply the context's deadline and cancelation to the I/O
+	// performed against the connection.
+	deadline, _ := ctx.Deadline()
+	c.conn.SetDeadline(deadline)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+		close(stopped)
+	}()
+	err := f(c)
+	close(done)
+	<-stopped
+
+	if err != nil {
+		c.conn.Close()
+		if ctxErr := util.StatusFromContext(ctx); ctxErr != nil {
+			return ctxErr
+		}
+		if _, ok := status.FromError(err); !ok {
+			err = util.StatusWrapfWithCode(err, codes.Unavailable, "Failed to communicate with %#v", sc.address)
+		}
+		return err
+	}
+	select {
+	case sc.idleConnections <- c:
+	default:
+		c.conn.Close()
+	}
+	return nil
+}
+
+func newStatusError(statusCode uint16, value []byte) error {
+	switch statusCode {
+	case statusKeyNotFound:
+		return status.Error(codes.NotFound, "Key not found")
+	case statusValueTooLarge:
+		return status.Error(codes.InvalidArgument, "Value too large")
+	default:
+		return status.Errorf(codes.Unavailable, "Server returned status 0x%04x: %s", statusCode, value)
+	}
+}
+
+func (sc *serverClient) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	var statusErr error
+	if err := sc.withConnection(ctx, func(c *connection) error {
+		if err := c.writeRequest(opcodeGet, 0, key, nil, nil); err != nil {
+			return err
+		}
+		if err := c.writer.Flush(); err != nil {
+			return err
+		}
+		resp, err := c.readResponse()
+		if err != nil {
+			return err
+		}
+		if resp.status == statusNoError {
+			value = resp.value
+		} else {
+			statusErr = newStatusError(resp.status, resp.value)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return value, statusErr
+}
+
+func (sc *serverClient) Set(ctx context.Context, key string, value []byte, expiration uint32) error {
+	var statusErr error
+	if err := sc.withConnection(ctx, func(c *connection) error {
+		var extras [8]byte
+		binary.BigEndian.PutUint32(extras[4:], expiration)
+		if err := c.writeRequest(opcodeSet, 0, key, extras[:], value); err != nil {
+			return err
+		}
+		if err := c.writer.Flush(); err != nil {
+			return err
+		}
+		resp, err := c.readResponse()
+		if err != nil {
+			return err
+		}
+		if resp.status != statusNoError {
+			statusErr = newStatusError(resp.status, resp.value)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return statusErr
+}
+
+func (sc *serverClient) Delete(ctx context.Context, key string) error {
+	var statusErr error
+	if err := sc.withConnection(ctx, func(c *connection) error {
+		if err := c.writeRequest(opcodeDelete, 0, key, nil, nil); err != nil {
+			return err
+		}
+		if err := c.writer.Flush(); err != nil {
+			return err
+		}
+		resp, err := c.readResponse()
+		if err != nil {
+			return err
+		}
+		if resp.status != statusNoError && resp.status != statusKeyNotFound {
+			statusErr = newStatusError(resp.status, resp.value)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return statusErr
+}
+
+func (sc *serverClient) FindMissing(ctx context.Context, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	// Memcached does not provide a command for checking the
+	// existence of keys. Issue quiet GET commands, which only
+	// yield a response if the key exists, followed by a NOOP to
+	// determine when all responses have been received.
+	found := make([]bool, len(keys))
+	if err := sc.withConnection(ctx, func(c *connection) error {
+		for i, key := range keys {
+			if err := c.writeRequest(opcodeGetQ, uint32(i), key, nil, nil); err != nil {
+				return err
+			}
+		}
+		if err := c.writeRequest(opcodeNoop, uint32(len(keys)), "", nil, nil); err != nil {
+			return err
+		}
+		if err := c.writer.Flush(); err != nil {
+			return err
+		}
+		for {
+			resp, err := c.readResponse()
+			if err != nil {
+				return err
+			}
+			if resp.opcode == opcodeNoop {
+				return nil
+			}
+			if resp.status == statusNoError && resp.opaque < uint32(len(keys)) {
+				found[resp.opaque] = true
+			}
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for i, key := range keys {
+		if !found[i] {
+			missing = append(missing, key)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,161 @@
+package memcached_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/memcached"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeServer implements a subset of the memcached binary protocol,
+// storing values in a map.
+type fakeServer struct {
+	listener net.Listener
+
+	lock   sync.Mutex
+	values map[string][]byte
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeServer{
+		listener: listener,
+		values:   map[string][]byte{},
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handleConnection(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *fakeServer) handleConnection(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var header [24]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		opcode := header[1]
+		keyLength := int(binary.BigEndian.Uint16(header[2:]))
+		extrasLength := int(header[4])
+		body := make([]byte, binary.BigEndian.Uint32(header[8:]))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		key := string(body[extrasLength : extrasLength+keyLength])
+		value := body[extrasLength+keyLength:]
+
+		s.lock.Lock()
+		var responseStatus uint16
+		var responseExtras, responseValue []byte
+		quiet := false
+		switch opcode {
+		case 0x00, 0x09:
+			if v, ok := s.values[key]; ok {
+				responseExtras = make([]byte, 4)
+				responseValue = v
+			} else {
+				responseStatus = 0x0001
+				quiet = opcode == 0x09
+			}
+		case 0x01:
+			if len(value) > 10 {
+				responseStatus = 0x0003
+			} else {
+				s.values[key] = append([]byte(nil), value...)
+			}
+		case 0x04:
+			if _, ok := s.values[key]; ok {
+				delete(s.values, key)
+			} else {
+				responseStatus = 0x0001
+			}
+		}
+		s.lock.Unlock()
+		if quiet {
+			continue
+		}
+
+		var response [24]byte
+		response[0] = 0x81
+		response[1] = opcode
+		response[4] = uint8(len(responseExtras))
+		binary.BigEndian.PutUint16(response[6:], responseStatus)
+		binary.BigEndian.PutUint32(response[8:], uint32(len(responseExtras)+len(responseValue)))
+		copy(response[12:16], header[12:16])
+		if _, err := conn.Write(append(append(response[:], responseExtras...), responseValue...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestServerClient(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeServer(t)
+	client := memcached.NewServerClient(server.listener.Addr().String(), time.Second, 2)
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		_, err := client.Get(ctx, "foo")
+		require.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("SetTooLarge", func(t *testing.T) {
+		err := client.Set(ctx, "foo", []byte("Hello world"), 0)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("SetSuccess", func(t *testing.T) {
+		require.NoError(t, client.Set(ctx, "foo", []byte("Hello"), 0))
+		require.NoError(t, client.Set(ctx, "bar", []byte("World"), 60))
+	})
+
+	t.Run("GetSuccess", func(t *testing.T) {
+		value, err := client.Get(ctx, "foo")
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello"), value)
+	})
+
+	t.Run("FindMissing", func(t *testing.T) {
+		missing, err := client.FindMissing(ctx, []string{"foo", "baz", "bar", "qux"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"baz", "qux"}, missing)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, client.Delete(ctx, "foo"))
+		require.NoError(t, client.Delete(ctx, "foo"))
+
+		_, err := client.Get(ctx, "foo")
+		require.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
+
+func TestServerClientUnavailable(t *testing.T) {
+	// Connecting to a server that is not running should yield
+	// UNAVAILABLE errors.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+
+	client := memcached.NewServerClient(address, time.Second, 2)
+	_, err = client.Get(context.Background(), "foo")
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,299 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// This file contains the subset of the OpenTelemetry Protocol (OTLP)
+// data model that is needed to export traces and metrics, using the
+// JSON encoding of OTLP/HTTP. This encoding is identical to the
+// Protobuf JSON mapping of the OTLP messages, except that trace and
+// span IDs are encoded as hexadecimal strings.
+//
+// The OTLP Protobuf messages could have been used directly, but this
+// would require adding a dependency on the OpenTelemetry libraries to
+// all Buildbarn binaries, while they continue to use OpenCensus and
+// Prometheus for instrumentation.
+
+// uint64String is an integer that is encoded as a JSON string, as
+// required by the Protobuf JSON mapping for 64-bit integers.
+type uint64String uint64
+
+func (v uint64String) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + strconv.FormatUint(uint64(v), 10) + `"`), nil
+}
+
+// float64Value is a floating point number that encodes non-finite
+// values as JSON strings, as required by the Protobuf JSON mapping.
+// Go's JSON encoder refuses to encode these values otherwise.
+type float64Value float64
+
+func (v float64Value) MarshalJSON() ([]byte, error) {
+	f := float64(v)
+	switch {
+	case math.IsNaN(f):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(f, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(f, -1):
+		return []byte(`"-Infinity"`), nil
+	default:
+		return json.Marshal(f)
+	}
+}
+
+func timeUnixNano(t time.Time) uint64String {
+	return uint64String(t.UnixNano())
+}
+
+type anyValue struct {
+	StringValue *string       `json:"stringValue,omitempty"`
+	BoolValue   *bool         `json:"boolValue,omitempty"`
+	IntValue    *uint64String `json:"intValue,omitempty"`
+	DoubleValue *float64Value `json:"doubleValue,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+func newStringKeyValue(key, value string) keyValue {
+	return keyValue{Key: key, Value: anyValue{StringValue: &value}}
+}
+
+// newKeyValues converts a map of OpenCensus attributes to a list of
+// OTLP attributes, sorted by key.
+func newKeyValues(attributes map[string]interface{}) []keyValue {
+	keyValues := make([]keyValue, 0, len(attributes))
+	for key, value := range attributes {
+		kv := keyValue{Key: key}
+		switch v := value.(type) {
+		case string:
+			kv.Value.StringValue = &v
+		case bool:
+			kv.Value.BoolValue = &v
+		case int64:
+			i := uint64String(v)
+			kv.Value.IntValue = &i
+		case float64:
+			f := float64Value(v)
+			kv.Value.DoubleValue = &f
+		default:
+			s := fmt.Sprint(v)
+			kv.Value.StringValue = &s
+		}
+		keyValues = append(keyValues, kv)
+	}
+	sort.Slice(keyValues, func(i, j int) bool {
+		return keyValues[i].Key < keyValues[j].Key
+	})
+	return keyValues
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes,omitempty"`
+}
+
+func newResource(attributes map[string]string) resource {
+	var r resource
+	for key, value := range attributes {
+		r.Attributes = append(r.Attributes, newStringKeyValue(key, value))
+	}
+	sort.Slice(r.Attributes, func(i, j int) bool {
+		return r.Attributes[i].Key < r.Attributes[j].Key
+	})
+	return r
+}
+
+type instrumentationScope struct {
+	Name string `json:"name"`
+}
+
+// scope is the instrumentation scope that is attached to all data
+// exported by this package.
+var scope = instrumentationScope{Name: "github.com/buildbarn/bb-storage/pkg/otlp"}
+
+// Messages for exporting traces.
+
+type exportTraceServiceRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type scopeSpans struct {
+	Scope instrumentationScope `json:"scope"`
+	Spans []span               `json:"spans"`
+}
+
+const (
+	spanKindUnspecified = 0
+	spanKindServer      = 2
+	spanKindClient      = 3
+
+	statusCodeUnset = 0
+	statusCodeError = 2
+)
+
+type span struct {
+	TraceID                string       `json:"traceId"`
+	SpanID                 string       `json:"spanId"`
+	TraceState             string       `json:"traceState,omitempty"`
+	ParentSpanID           string       `json:"parentSpanId,omitempty"`
+	Name                   string       `json:"name"`
+	Kind                   int          `json:"kind"`
+	StartTimeUnixNano      uint64String `json:"startTimeUnixNano"`
+	EndTimeUnixNano        uint64String `json:"endTimeUnixNano"`
+	Attributes             []keyValue   `json:"attributes,omitempty"`
+	DroppedAttributesCount int          `json:"droppedAttributesCount,omitempty"`
+	Events                 []spanEvent  `json:"events,omitempty"`
+	DroppedEventsCount     int          `json:"droppedEventsCount,omitempty"`
+	Links                  []spanLink   `json:"links,omitempty"`
+	DroppedLinksCount      int          `json:"droppedLinksCount,omitempty"`
+	Status                 spanStatus   `json:"status"`
+}
+
+type spanEvent struct {
+	TimeUnixNano uint64String `json:"timeUnixNano"`
+	Name         string       `json:"name"`
+	Attributes   []keyValue   `json:"attributes,omitempty"`
+}
+
+type spanLink struct {
+	TraceID    string     `json:"traceId"`
+	SpanID     string     `json:"spanId"`
+	Attributes []keyValue `json:"attributes,omitempty"`
+}
+
+type spanStatus struct {
+	Message string `json:"message,omitempty"`
+	Code    int    `json:"code"`
+}
+
+// Messages for exporting metrics.
+
+type exportMetricsServiceRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type scopeMetrics struct {
+	Scope   instrumentationScope `json:"scope"`
+	Metrics []metric             `json:"metrics"`
+}
+
+const aggregationTemporalityCumulative = 2
+
+type metric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Gauge       *gauge     `json:"gauge,omitempty"`
+	Sum         *sum       `json:"sum,omitempty"`
+	Histogram   *histogram `json:"histogram,omitempty"`
+	Summary     *summary   `json:"summary,omitempty"`
+}
+
+type gauge struct {
+	DataPoints []numberDataPoint `json:"dataPoints"`
+}
+
+type sum struct {
+	DataPoints             []numberDataPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type numberDataPoint struct {
+	Attributes        []keyValue   `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64String `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      uint64String `json:"timeUnixNano"`
+	AsDouble          float64Value `json:"asDouble"`
+}
+
+type histogram struct {
+	DataPoints             []histogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type histogramDataPoint struct {
+	Attributes        []keyValue     `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64String   `json:"startTimeUnixNano"`
+	TimeUnixNano      uint64String   `json:"timeUnixNano"`
+	Count             uint64String   `json:"count"`
+	Sum               float64Value   `json:"sum"`
+	BucketCounts      []uint64String `json:"bucketCounts"`
+	ExplicitBounds    []float64Value `json:"explicitBounds"`
+}
+
+type summary struct {
+	DataPoints []summaryDataPoint `json:"dataPoints"`
+}
+
+type summaryDataPoint struct {
+	Attributes        []keyValue      `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64String    `json:"startTimeUnixNano"`
+	TimeUnixNano      uint64String    `json:"timeUnixNano"`
+	Count             uint64String    `json:"count"`
+	Sum               float64Value    `json:"sum"`
+	QuantileValues    []quantileValue `json:"quantileValues"`
+}
+
+type quantileValue struct {
+	Quantile float64Value `json:"quantile"`
+	Value    float64Value `json:"value"`
+}
+
+// HTTPClient is an interface around Go's standard HTTP client type. It
+// has been added to aid unit testing.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+var _ HTTPClient = &http.Client{}
+
+// sendRequest sends an OTLP export request to an OTLP/HTTP endpoint.
+func sendRequest(ctx context.Context, httpClient HTTPClient, endpointURL string, request interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Internal, "Failed to marshal request")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpointURL, bytes.NewReader(body))
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.InvalidArgument, "Failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return util.StatusWrapWithCode(err, codes.Unavailable, "HTTP request failed")
+	}
+	defer resp.Body.Close()
+	// Drain the response body, so that the connection can be reused.
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return status.Errorf(codes.Unavailable, "HTTP request failed with status %#v", resp.Status)
+	}
+	return nil
+}
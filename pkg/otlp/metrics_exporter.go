@@ -0,0 +1,158 @@
+package otlp
+
+import (
+	"context"
+	"math"
+
+	"github.com/buildbarn/bb-storage/pkg/clock"
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// MetricsExporter converts metrics collected through Prometheus to the
+// OpenTelemetry Protocol (OTLP), and sends them to a service accepting
+// OTLP, such as the OpenTelemetry Collector. It can be used as an
+// alternative to letting the Prometheus server scrape metrics, or to
+// pushing them to a Prometheus Pushgateway.
+//
+// Prometheus counters, histograms and summaries are cumulative since
+// the start of the process. The time at which the MetricsExporter is
+// created is used as their start time.
+type MetricsExporter struct {
+	httpClient  HTTPClient
+	endpointURL string
+	resource    resource
+	gatherer    prometheus.Gatherer
+	clock       clock.Clock
+	startTime   uint64String
+}
+
+// NewMetricsExporter creates a MetricsExporter that sends metrics
+// obtained from a Prometheus gatherer to a given OTLP/HTTP endpoint.
+func NewMetricsExporter(httpClient HTTPClient, endpointURL string, resourceAttributes map[string]string, gatherer prometheus.Gatherer, clock clock.Clock) *MetricsExporter {
+	return &MetricsExporter{
+		httpClient:  httpClient,
+		endpointURL: endpointURL,
+		resource:    newResource(resourceAttributes),
+		gatherer:    gatherer,
+		clock:       clock,
+		startTime:   timeUnixNano(clock.Now()),
+	}
+}
+
+func convertLabels(labels []*dto.LabelPair) []keyValue {
+	var keyValues []keyValue
+	for _, label := range labels {
+		keyValues = append(keyValues, newStringKeyValue(label.GetName(), label.GetValue()))
+	}
+	return keyValues
+}
+
+func (me *MetricsExporter) convertMetricFamily(metricFamily *dto.MetricFamily, now uint64String) metric {
+	m := metric{
+		Name:        metricFamily.GetName(),
+		Description: metricFamily.GetHelp(),
+	}
+	switch metricFamily.GetType() {
+	case dto.MetricType_COUNTER:
+		m.Sum = &sum{
+			AggregationTemporality: aggregationTemporalityCumulative,
+			IsMonotonic:            true,
+		}
+		for _, sample := range metricFamily.GetMetric() {
+			m.Sum.DataPoints = append(m.Sum.DataPoints, numberDataPoint{
+				Attributes:        convertLabels(sample.GetLabel()),
+				StartTimeUnixNano: me.startTime,
+				TimeUnixNano:      now,
+				AsDouble:          float64Value(sample.GetCounter().GetValue()),
+			})
+		}
+	case dto.MetricType_HISTOGRAM:
+		m.Histogram = &histogram{
+			AggregationTemporality: aggregationTemporalityCumulative,
+		}
+		for _, sample := range metricFamily.GetMetric() {
+			h := sample.GetHistogram()
+			dataPoint := histogramDataPoint{
+				Attributes:        convertLabels(sample.GetLabel()),
+				StartTimeUnixNano: me.startTime,
+				TimeUnixNano:      now,
+				Count:             uint64String(h.GetSampleCount()),
+				Sum:               float64Value(h.GetSampleSum()),
+			}
+			// Prometheus buckets contain cumulative counts,
+			// while OTLP buckets contain the counts of the
+			// individual buckets. OTLP also has an implicit
+			// bucket for values exceeding all bounds.
+			var previousCount uint64
+			for _, bucket := range h.GetBucket() {
+				if math.IsInf(bucket.GetUpperBound(), 1) {
+					break
+				}
+				dataPoint.BucketCounts = append(dataPoint.BucketCounts, uint64String(bucket.GetCumulativeCount()-previousCount))
+				dataPoint.ExplicitBounds = append(dataPoint.ExplicitBounds, float64Value(bucket.GetUpperBound()))
+				previousCount = bucket.GetCumulativeCount()
+			}
+			dataPoint.BucketCounts = append(dataPoint.BucketCounts, uint64String(h.GetSampleCount()-previousCount))
+			m.Histogram.DataPoints = append(m.Histogram.DataPoints, dataPoint)
+		}
+	case dto.MetricType_SUMMARY:
+		m.Summary = &summary{}
+		for _, sample := range metricFamily.GetMetric() {
+			s := sample.GetSummary()
+			dataPoint := summaryDataPoint{
+				Attributes:        convertLabels(sample.GetLabel()),
+				StartTimeUnixNano: me.startTime,
+				TimeUnixNano:      now,
+				Count:             uint64String(s.GetSampleCount()),
+				Sum:               float64Value(s.GetSampleSum()),
+			}
+			for _, quantile := range s.GetQuantile() {
+				dataPoint.QuantileValues = append(dataPoint.QuantileValues, quantileValue{
+					Quantile: float64Value(quantile.GetQuantile()),
+					Value:    float64Value(quantile.GetValue()),
+				})
+			}
+			m.Summary.DataPoints = append(m.Summary.DataPoints, dataPoint)
+		}
+	default:
+		// Gauges and untyped metrics.
+		m.Gauge = &gauge{}
+		for _, sample := range metricFamily.GetMetric() {
+			value := sample.GetGauge().GetValue()
+			if u := sample.GetUntyped(); u != nil {
+				value = u.GetValue()
+			}
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, numberDataPoint{
+				Attributes:   convertLabels(sample.GetLabel()),
+				TimeUnixNano: now,
+				AsDouble:     float64Value(value),
+			})
+		}
+	}
+	return m
+}
+
+// Push the current values of all metrics to the OTLP/HTTP endpoint.
+func (me *MetricsExporter) Push(ctx context.Context) error {
+	metricFamilies, err := me.gatherer.Gather()
+	if err != nil {
+		return util.StatusWrap(err, "Failed to gather metrics")
+	}
+	now := timeUnixNano(me.clock.Now())
+	metrics := make([]metric, 0, len(metricFamilies))
+	for _, metricFamily := range metricFamilies {
+		metrics = append(metrics, me.convertMetricFamily(metricFamily, now))
+	}
+	return sendRequest(ctx, me.httpClient, me.endpointURL, &exportMetricsServiceRequest{
+		ResourceMetrics: []resourceMetrics{{
+			Resource: me.resource,
+			ScopeMetrics: []scopeMetrics{{
+				Scope:   scope,
+				Metrics: metrics,
+			}},
+		}},
+	})
+}
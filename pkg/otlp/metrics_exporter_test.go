@@ -0,0 +1,121 @@
+package otlp_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/otlp"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsExporter(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "requests_total",
+			Help: "Number of requests.",
+		},
+		[]string{"method"})
+	registry.MustRegister(counter)
+	counter.WithLabelValues("Get").Add(5)
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "connections",
+		Help: "Number of connections.",
+	})
+	registry.MustRegister(gauge)
+	gauge.Set(3)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "request_duration_seconds",
+		Help:    "Duration of requests.",
+		Buckets: []float64{0.1, 1},
+	})
+	registry.MustRegister(histogram)
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(0.7)
+	histogram.Observe(2)
+
+	httpClient := mock.NewMockHTTPClient(ctrl)
+	clock := mock.NewMockClock(ctrl)
+	clock.EXPECT().Now().Return(time.Unix(1600000000, 0))
+	me := otlp.NewMetricsExporter(httpClient, "http://otel-collector:4318/v1/metrics", map[string]string{
+		"service.name": "bb_storage",
+	}, registry, clock)
+
+	clock.EXPECT().Now().Return(time.Unix(1600000060, 0))
+	httpClient.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+		require.Equal(t, "http://otel-collector:4318/v1/metrics", req.URL.String())
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		require.JSONEq(t, `{
+			"resourceMetrics": [{
+				"resource": {
+					"attributes": [
+						{"key": "service.name", "value": {"stringValue": "bb_storage"}}
+					]
+				},
+				"scopeMetrics": [{
+					"scope": {"name": "github.com/buildbarn/bb-storage/pkg/otlp"},
+					"metrics": [
+						{
+							"name": "connections",
+							"description": "Number of connections.",
+							"gauge": {
+								"dataPoints": [{
+									"timeUnixNano": "1600000060000000000",
+									"asDouble": 3
+								}]
+							}
+						},
+						{
+							"name": "request_duration_seconds",
+							"description": "Duration of requests.",
+							"histogram": {
+								"dataPoints": [{
+									"startTimeUnixNano": "1600000000000000000",
+									"timeUnixNano": "1600000060000000000",
+									"count": "4",
+									"sum": 3.25,
+									"bucketCounts": ["1", "2", "1"],
+									"explicitBounds": [0.1, 1]
+								}],
+								"aggregationTemporality": 2
+							}
+						},
+						{
+							"name": "requests_total",
+							"description": "Number of requests.",
+							"sum": {
+								"dataPoints": [{
+									"attributes": [
+										{"key": "method", "value": {"stringValue": "Get"}}
+									],
+									"startTimeUnixNano": "1600000000000000000",
+									"timeUnixNano": "1600000060000000000",
+									"asDouble": 5
+								}],
+								"aggregationTemporality": 2,
+								"isMonotonic": true
+							}
+						}
+					]
+				}]
+			}]
+		}`, string(body))
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+		}, nil
+	})
+	require.NoError(t, me.Push(ctx))
+}
@@ -0,0 +1,125 @@
+package otlp
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+
+	"go.opencensus.io/trace"
+)
+
+// TraceExporter is an OpenCensus trace exporter that forwards spans to
+// a service accepting the OpenTelemetry Protocol (OTLP), such as the
+// OpenTelemetry Collector.
+//
+// Spans are buffered in memory until Flush() is called. To prevent
+// unbounded memory usage when the service is unreachable, spans are
+// discarded if the buffer is full.
+type TraceExporter struct {
+	httpClient        HTTPClient
+	endpointURL       string
+	resource          resource
+	maximumBufferSize int
+
+	lock  sync.Mutex
+	spans []span
+}
+
+var _ trace.Exporter = (*TraceExporter)(nil)
+
+// NewTraceExporter creates a TraceExporter that sends spans to a given
+// OTLP/HTTP endpoint.
+func NewTraceExporter(httpClient HTTPClient, endpointURL string, resourceAttributes map[string]string, maximumBufferSize int) *TraceExporter {
+	return &TraceExporter{
+		httpClient:        httpClient,
+		endpointURL:       endpointURL,
+		resource:          newResource(resourceAttributes),
+		maximumBufferSize: maximumBufferSize,
+	}
+}
+
+func convertSpanKind(spanKind int) int {
+	switch spanKind {
+	case trace.SpanKindServer:
+		return spanKindServer
+	case trace.SpanKindClient:
+		return spanKindClient
+	default:
+		return spanKindUnspecified
+	}
+}
+
+func convertSpan(sd *trace.SpanData) span {
+	s := span{
+		TraceID:                hex.EncodeToString(sd.TraceID[:]),
+		SpanID:                 hex.EncodeToString(sd.SpanID[:]),
+		Name:                   sd.Name,
+		Kind:                   convertSpanKind(sd.SpanKind),
+		StartTimeUnixNano:      timeUnixNano(sd.StartTime),
+		EndTimeUnixNano:        timeUnixNano(sd.EndTime),
+		Attributes:             newKeyValues(sd.Attributes),
+		DroppedAttributesCount: sd.DroppedAttributeCount,
+		DroppedEventsCount:     sd.DroppedAnnotationCount + sd.DroppedMessageEventCount,
+		DroppedLinksCount:      sd.DroppedLinkCount,
+		Status: spanStatus{
+			Message: sd.Message,
+			Code:    statusCodeUnset,
+		},
+	}
+	if sd.ParentSpanID != (trace.SpanID{}) {
+		s.ParentSpanID = hex.EncodeToString(sd.ParentSpanID[:])
+	}
+	if sd.Code != 0 {
+		// OpenCensus uses gRPC status codes, while OTLP only
+		// distinguishes between success and failure.
+		s.Status.Code = statusCodeError
+	}
+	for _, annotation := range sd.Annotations {
+		s.Events = append(s.Events, spanEvent{
+			TimeUnixNano: timeUnixNano(annotation.Time),
+			Name:         annotation.Message,
+			Attributes:   newKeyValues(annotation.Attributes),
+		})
+	}
+	for _, link := range sd.Links {
+		s.Links = append(s.Links, spanLink{
+			TraceID:    hex.EncodeToString(link.TraceID[:]),
+			SpanID:     hex.EncodeToString(link.SpanID[:]),
+			Attributes: newKeyValues(link.Attributes),
+		})
+	}
+	return s
+}
+
+// ExportSpan adds a span to the buffer of spans that are sent upon the
+// next call to Flush().
+func (te *TraceExporter) ExportSpan(sd *trace.SpanData) {
+	s := convertSpan(sd)
+	te.lock.Lock()
+	if len(te.spans) < te.maximumBufferSize {
+		te.spans = append(te.spans, s)
+	}
+	te.lock.Unlock()
+}
+
+// Flush all buffered spans to the OTLP/HTTP endpoint. Spans are
+// discarded, even if sending them fails.
+func (te *TraceExporter) Flush(ctx context.Context) error {
+	te.lock.Lock()
+	spans := te.spans
+	te.spans = nil
+	te.lock.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+	return sendRequest(ctx, te.httpClient, te.endpointURL, &exportTraceServiceRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: te.resource,
+			ScopeSpans: []scopeSpans{{
+				Scope: scope,
+				Spans: spans,
+			}},
+		}},
+	})
+}
@@ -0,0 +1,127 @@
+package otlp_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/buildbarn/bb-storage/internal/mock"
+	"github.com/buildbarn/bb-storage/pkg/otlp"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+
+	"go.opencensus.io/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestTraceExporter(t *testing.T) {
+	ctrl, ctx := gomock.WithContext(context.Background(), t)
+
+	httpClient := mock.NewMockHTTPClient(ctrl)
+	te := otlp.NewTraceExporter(httpClient, "http://otel-collector:4318/v1/traces", map[string]string{
+		"service.name": "bb_storage",
+	}, 2)
+
+	exampleSpan := &trace.SpanData{
+		SpanContext: trace.SpanContext{
+			TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+			SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		},
+		ParentSpanID: trace.SpanID{0x53, 0x99, 0x5c, 0x3f, 0x42, 0xcd, 0x8a, 0xd8},
+		SpanKind:     trace.SpanKindServer,
+		Name:         "build.bazel.remote.execution.v2.ContentAddressableStorage/FindMissingBlobs",
+		StartTime:    time.Unix(1600000000, 0),
+		EndTime:      time.Unix(1600000001, 500000000),
+		Attributes: map[string]interface{}{
+			"count":   int64(3),
+			"success": false,
+		},
+		Annotations: []trace.Annotation{
+			{
+				Time:    time.Unix(1600000001, 0),
+				Message: "Contacting backend",
+			},
+		},
+		Status: trace.Status{
+			Code:    int32(codes.Unavailable),
+			Message: "Backend unavailable",
+		},
+	}
+
+	t.Run("Empty", func(t *testing.T) {
+		// Flushing without any spans being buffered should not
+		// cause any requests to be sent.
+		require.NoError(t, te.Flush(ctx))
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// As the buffer can only hold two spans, the third span
+		// should be discarded.
+		te.ExportSpan(exampleSpan)
+		te.ExportSpan(exampleSpan)
+		te.ExportSpan(exampleSpan)
+
+		const expectedSpan = `{
+			"traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+			"spanId": "00f067aa0ba902b7",
+			"parentSpanId": "53995c3f42cd8ad8",
+			"name": "build.bazel.remote.execution.v2.ContentAddressableStorage/FindMissingBlobs",
+			"kind": 2,
+			"startTimeUnixNano": "1600000000000000000",
+			"endTimeUnixNano": "1600000001500000000",
+			"attributes": [
+				{"key": "count", "value": {"intValue": "3"}},
+				{"key": "success", "value": {"boolValue": false}}
+			],
+			"events": [
+				{"timeUnixNano": "1600000001000000000", "name": "Contacting backend"}
+			],
+			"status": {"message": "Backend unavailable", "code": 2}
+		}`
+		httpClient.EXPECT().Do(gomock.Any()).DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			require.Equal(t, http.MethodPost, req.Method)
+			require.Equal(t, "http://otel-collector:4318/v1/traces", req.URL.String())
+			require.Equal(t, "application/json", req.Header.Get("Content-Type"))
+			body, err := ioutil.ReadAll(req.Body)
+			require.NoError(t, err)
+			require.JSONEq(t, `{
+				"resourceSpans": [{
+					"resource": {
+						"attributes": [
+							{"key": "service.name", "value": {"stringValue": "bb_storage"}}
+						]
+					},
+					"scopeSpans": [{
+						"scope": {"name": "github.com/buildbarn/bb-storage/pkg/otlp"},
+						"spans": [`+expectedSpan+`, `+expectedSpan+`]
+					}]
+				}]
+			}`, string(body))
+			return &http.Response{
+				Status:     "200 OK",
+				StatusCode: http.StatusOK,
+				Body:       ioutil.NopCloser(bytes.NewBufferString("{}")),
+			}, nil
+		})
+		require.NoError(t, te.Flush(ctx))
+
+		// Spans should not be sent more than once.
+		require.NoError(t, te.Flush(ctx))
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		te.ExportSpan(exampleSpan)
+
+		httpClient.EXPECT().Do(gomock.Any()).Return(&http.Response{
+			Status:     "503 Service Unavailable",
+			StatusCode: http.StatusServiceUnavailable,
+			Body:       ioutil.NopCloser(bytes.NewBuffer(nil)),
+		}, nil)
+		testutil.RequireEqualStatus(t, status.Error(codes.Unavailable, "HTTP request failed with status \"503 Service Unavailable\""), te.Flush(ctx))
+	})
+}
@@ -0,0 +1,87 @@
+package reload
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ConfigurationReloader reloads the configuration of the process by
+// rereading its configuration file, and applying the resulting
+// configuration to all components that have been registered against a
+// Registry.
+//
+// Reloads can be triggered by calling Reload(), sending SIGHUP to the
+// process, or sending a POST request to the HTTP handler.
+type ConfigurationReloader struct {
+	path          string
+	configuration proto.Message
+	registry      *Registry
+
+	lock sync.Mutex
+}
+
+// NewConfigurationReloader creates a ConfigurationReloader for a
+// configuration file. The configuration message must be the one that
+// was used to construct the components registered against the
+// Registry.
+func NewConfigurationReloader(path string, configuration proto.Message, registry *Registry) *ConfigurationReloader {
+	return &ConfigurationReloader{
+		path:          path,
+		configuration: configuration,
+		registry:      registry,
+	}
+}
+
+// Reload the configuration file and apply it.
+func (cr *ConfigurationReloader) Reload() error {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	newConfiguration := cr.configuration.ProtoReflect().New().Interface()
+	if err := util.UnmarshalConfigurationFromFile(cr.path, newConfiguration); err != nil {
+		return util.StatusWrapf(err, "Failed to read configuration from %s", cr.path)
+	}
+	return cr.registry.Apply(cr.configuration, newConfiguration)
+}
+
+func (cr *ConfigurationReloader) reloadAndLog() error {
+	if err := cr.Reload(); err != nil {
+		log.Print("Failed to reload configuration: ", err)
+		return err
+	}
+	log.Print("Reloaded configuration")
+	return nil
+}
+
+// ReloadOnSIGHUP launches a goroutine that reloads the configuration
+// every time the process receives SIGHUP.
+func (cr *ConfigurationReloader) ReloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			cr.reloadAndLog()
+		}
+	}()
+}
+
+// ServeHTTP reloads the configuration upon receipt of a POST request.
+func (cr *ConfigurationReloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := cr.reloadAndLog(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,126 @@
+package reload
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ApplyFunc is called when the configuration of the process is
+// reloaded. It is provided with the message in the new configuration
+// that is at the same position as the message against which it was
+// registered.
+type ApplyFunc func(newConfiguration proto.Message) error
+
+type registration struct {
+	configuration proto.Message
+	apply         ApplyFunc
+}
+
+// Registry keeps track of components that can be reconfigured when the
+// configuration of the process is reloaded.
+type Registry struct {
+	lock          sync.Mutex
+	registrations []registration
+}
+
+// NewRegistry creates a Registry that does not have any components
+// registered yet.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register a function that reconfigures a component when the
+// configuration of the process is reloaded. The configuration message
+// must be the one from which the component was constructed, as the
+// position of this message in the configuration is used to look up
+// its replacement.
+//
+// Only components that explicitly call this function can be
+// reconfigured at runtime. Changes to all other parts of the
+// configuration are ignored until the process is restarted.
+func (r *Registry) Register(configuration proto.Message, apply ApplyFunc) {
+	r.lock.Lock()
+	r.registrations = append(r.registrations, registration{
+		configuration: configuration,
+		apply:         apply,
+	})
+	r.lock.Unlock()
+}
+
+// walkMessages calls a function for every message contained in a
+// message, including the message itself. Messages are identified by a
+// path of field names, list indices and map keys.
+func walkMessages(m protoreflect.Message, path string, f func(path string, m protoreflect.Message)) {
+	f(path, m)
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		fieldPath := path + "." + string(fd.Name())
+		switch {
+		case fd.IsList():
+			if fd.Message() != nil {
+				l := v.List()
+				for i := 0; i < l.Len(); i++ {
+					walkMessages(l.Get(i).Message(), fmt.Sprintf("%s[%d]", fieldPath, i), f)
+				}
+			}
+		case fd.IsMap():
+			if fd.MapValue().Message() != nil {
+				v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+					walkMessages(v.Message(), fmt.Sprintf("%s[%#v]", fieldPath, k.String()), f)
+					return true
+				})
+			}
+		case fd.Message() != nil:
+			walkMessages(v.Message(), fieldPath, f)
+		}
+		return true
+	})
+}
+
+// Apply a new configuration to all components that have been
+// registered through Register(). The old configuration must be the
+// configuration from which the components were constructed. Its
+// structure is used to determine which message in the new
+// configuration is used to reconfigure each component.
+//
+// All components are reconfigured, even if reconfiguring some of them
+// fails. In that case the first error is returned.
+func (r *Registry) Apply(oldConfiguration, newConfiguration proto.Message) error {
+	oldPaths := map[proto.Message]string{}
+	walkMessages(oldConfiguration.ProtoReflect(), "", func(path string, m protoreflect.Message) {
+		oldPaths[m.Interface()] = path
+	})
+	newMessages := map[string]protoreflect.Message{}
+	walkMessages(newConfiguration.ProtoReflect(), "", func(path string, m protoreflect.Message) {
+		newMessages[path] = m
+	})
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var firstErr error
+	for _, entry := range r.registrations {
+		// Skip components that were not constructed from the
+		// old configuration.
+		path, ok := oldPaths[entry.configuration]
+		if !ok {
+			continue
+		}
+		var err error
+		if m, ok := newMessages[path]; !ok {
+			err = status.Error(codes.InvalidArgument, "Message is no longer present in the new configuration")
+		} else {
+			err = entry.apply(m.Interface())
+		}
+		if err != nil && firstErr == nil {
+			firstErr = util.StatusWrapf(err, "Failed to reload configuration at %#v", path)
+		}
+	}
+	return firstErr
+}
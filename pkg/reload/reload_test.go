@@ -0,0 +1,79 @@
+package reload_test
+
+import (
+	"testing"
+
+	pb "github.com/buildbarn/bb-storage/pkg/proto/configuration/blobstore"
+	"github.com/buildbarn/bb-storage/pkg/reload"
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func newShardingConfiguration(drained ...bool) *pb.BlobAccessConfiguration {
+	sharding := &pb.ShardingBlobAccessConfiguration{}
+	for _, d := range drained {
+		sharding.Shards = append(sharding.Shards, &pb.ShardingBlobAccessConfiguration_Shard{
+			Backend: &pb.BlobAccessConfiguration{},
+			Weight:  1,
+			Drained: d,
+		})
+	}
+	return &pb.BlobAccessConfiguration{
+		Backend: &pb.BlobAccessConfiguration_Sharding{
+			Sharding: sharding,
+		},
+	}
+}
+
+func TestApply(t *testing.T) {
+	oldConfiguration := newShardingConfiguration(false, false)
+	oldShard := oldConfiguration.GetSharding().Shards[1]
+	registry := reload.NewRegistry()
+	var applied []proto.Message
+	registry.Register(oldShard, func(newConfiguration proto.Message) error {
+		applied = append(applied, newConfiguration)
+		return nil
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		// The message at the same position in the new
+		// configuration should be provided.
+		newConfiguration := newShardingConfiguration(false, true)
+		require.NoError(t, registry.Apply(oldConfiguration, newConfiguration))
+		require.Len(t, applied, 1)
+		require.Same(t, newConfiguration.GetSharding().Shards[1], applied[0])
+	})
+
+	t.Run("UnrelatedConfiguration", func(t *testing.T) {
+		// Components that were not constructed from the old
+		// configuration should not be reconfigured.
+		require.NoError(t, registry.Apply(newShardingConfiguration(false, false), newShardingConfiguration(false, true)))
+		require.Len(t, applied, 1)
+	})
+
+	t.Run("MessageRemoved", func(t *testing.T) {
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.InvalidArgument, "Failed to reload configuration at \".sharding.shards[1]\": Message is no longer present in the new configuration"),
+			registry.Apply(oldConfiguration, newShardingConfiguration(false)))
+		require.Len(t, applied, 1)
+	})
+
+	t.Run("ApplyFailure", func(t *testing.T) {
+		oldBackend := oldConfiguration.GetSharding().Shards[0].Backend
+		registry.Register(oldBackend, func(newConfiguration proto.Message) error {
+			return status.Error(codes.FailedPrecondition, "Backend cannot be changed")
+		})
+		testutil.RequireEqualStatus(
+			t,
+			status.Error(codes.FailedPrecondition, "Failed to reload configuration at \".sharding.shards[0].backend\": Backend cannot be changed"),
+			registry.Apply(oldConfiguration, newShardingConfiguration(false, false)))
+
+		// Other components should still be reconfigured.
+		require.Len(t, applied, 2)
+	})
+}
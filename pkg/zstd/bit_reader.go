@@ -0,0 +1,112 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// loadBits extracts n bits from a byte slice, starting at bit offset
+// start. Bits are numbered starting at the least significant bit of
+// the first byte. Bits beyond the bounds of the byte slice are zero.
+func loadBits(data []byte, start int, n uint) uint64 {
+	if n == 0 {
+		return 0
+	}
+	if start < 0 {
+		if int(n)+start <= 0 {
+			return 0
+		}
+		return loadBits(data, 0, uint(int(n)+start)) << uint(-start)
+	}
+	byteIndex := start / 8
+	var w uint64
+	if byteIndex+8 <= len(data) {
+		w = binary.LittleEndian.Uint64(data[byteIndex:])
+	} else {
+		for i := len(data) - 1; i >= byteIndex; i-- {
+			w = w<<8 | uint64(data[i])
+		}
+	}
+	return (w >> uint(start%8)) & (1<<n - 1)
+}
+
+// backwardBitReader reads bits from a bitstream that was written in
+// forward direction, starting at its end. This is the format used by
+// both Huffman and FSE encoded streams. The final byte of the stream
+// contains a marker bit that indicates where the stream starts.
+//
+// Reading past the start of the stream is permitted, yielding zero
+// bits. Callers can detect this by calling overflowed().
+type backwardBitReader struct {
+	data []byte
+	pos  int
+}
+
+func newBackwardBitReader(data []byte) (backwardBitReader, error) {
+	if len(data) == 0 {
+		return backwardBitReader{}, status.Error(codes.InvalidArgument, "Bitstream is empty")
+	}
+	last := data[len(data)-1]
+	if last == 0 {
+		return backwardBitReader{}, status.Error(codes.InvalidArgument, "Bitstream does not end with a marker bit")
+	}
+	return backwardBitReader{
+		data: data,
+		pos:  (len(data)-1)*8 + bits.Len8(last) - 1,
+	}, nil
+}
+
+func (br *backwardBitReader) read(n uint) uint64 {
+	br.pos -= int(n)
+	return loadBits(br.data, br.pos, n)
+}
+
+func (br *backwardBitReader) peek(n uint) uint64 {
+	return loadBits(br.data, br.pos-int(n), n)
+}
+
+func (br *backwardBitReader) skip(n uint) {
+	br.pos -= int(n)
+}
+
+func (br *backwardBitReader) overflowed() bool {
+	return br.pos < 0
+}
+
+func (br *backwardBitReader) finished() bool {
+	return br.pos == 0
+}
+
+// forwardBitReader reads bits from a bitstream in forward direction.
+// This is the format used to store FSE table descriptions.
+type forwardBitReader struct {
+	data []byte
+	pos  int
+}
+
+func (br *forwardBitReader) read(n uint) uint64 {
+	v := loadBits(br.data, br.pos, n)
+	br.pos += int(n)
+	return v
+}
+
+func (br *forwardBitReader) peek(n uint) uint64 {
+	return loadBits(br.data, br.pos, n)
+}
+
+func (br *forwardBitReader) skip(n uint) {
+	br.pos += int(n)
+}
+
+func (br *forwardBitReader) overflowed() bool {
+	return br.pos > len(br.data)*8
+}
+
+// bytesConsumed returns the number of bytes that have been read
+// from the bitstream, rounding partially read bytes up.
+func (br *forwardBitReader) bytesConsumed() int {
+	return (br.pos + 7) / 8
+}
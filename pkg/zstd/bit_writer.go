@@ -0,0 +1,30 @@
+package zstd
+
+// bitWriter writes a bitstream in forward direction, so that it may
+// be read back by backwardBitReader. Bits are appended to a byte
+// slice, starting at the least significant bit of every byte.
+type bitWriter struct {
+	out       []byte
+	container uint64
+	nBits     uint
+}
+
+func (bw *bitWriter) addBits(value uint64, n uint) {
+	bw.container |= (value & (1<<n - 1)) << bw.nBits
+	bw.nBits += n
+	for bw.nBits >= 8 {
+		bw.out = append(bw.out, byte(bw.container))
+		bw.container >>= 8
+		bw.nBits -= 8
+	}
+}
+
+// close the bitstream by appending the marker bit that is used by
+// the reader to determine where the bitstream starts.
+func (bw *bitWriter) close() []byte {
+	bw.addBits(1, 1)
+	if bw.nBits > 0 {
+		bw.out = append(bw.out, byte(bw.container))
+	}
+	return bw.out
+}
@@ -0,0 +1,260 @@
+package zstd
+
+import (
+	"math/bits"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// readFSETableDescription parses the normalized probabilities of an
+// FSE table, as described in RFC 8878, section 4.1.1. It returns the
+// probabilities, the table's accuracy log and the number of bytes
+// consumed. A probability of -1 denotes a "less than 1" probability.
+func readFSETableDescription(data []byte, maximumSymbol int, maximumAccuracyLog uint) ([]int16, uint, int, error) {
+	br := forwardBitReader{data: data}
+	accuracyLog := uint(br.read(4)) + 5
+	if accuracyLog > maximumAccuracyLog {
+		return nil, 0, 0, status.Errorf(codes.InvalidArgument, "FSE table has accuracy log %d, while at most %d is permitted", accuracyLog, maximumAccuracyLog)
+	}
+
+	probabilities := make([]int16, maximumSymbol+1)
+	remaining := 1<<accuracyLog + 1
+	threshold := 1 << accuracyLog
+	nBits := accuracyLog + 1
+	symbol := 0
+	previousZero := false
+	for remaining > 1 {
+		if previousZero {
+			// Zero probabilities are followed by a repeat
+			// flag, indicating the number of additional
+			// zero probabilities.
+			for {
+				repeat := int(br.read(2))
+				symbol += repeat
+				if repeat != 3 {
+					break
+				}
+			}
+		}
+		if symbol > maximumSymbol || br.overflowed() {
+			return nil, 0, 0, status.Error(codes.InvalidArgument, "FSE table description is corrupted")
+		}
+
+		maximum := 2*threshold - 1 - remaining
+		count := int(br.peek(nBits))
+		if count&(threshold-1) < maximum {
+			count &= threshold - 1
+			br.skip(nBits - 1)
+		} else {
+			count &= 2*threshold - 1
+			if count >= threshold {
+				count -= maximum
+			}
+			br.skip(nBits)
+		}
+		count--
+
+		if count < 0 {
+			remaining--
+		} else {
+			remaining -= count
+		}
+		if remaining < 1 {
+			return nil, 0, 0, status.Error(codes.InvalidArgument, "FSE table description is corrupted")
+		}
+		probabilities[symbol] = int16(count)
+		symbol++
+		previousZero = count == 0
+		for remaining < threshold {
+			nBits--
+			threshold >>= 1
+		}
+	}
+	if br.overflowed() {
+		return nil, 0, 0, status.Error(codes.InvalidArgument, "FSE table description is truncated")
+	}
+	return probabilities, accuracyLog, br.bytesConsumed(), nil
+}
+
+// spreadFSESymbols distributes symbols across the states of an FSE
+// table, as described in RFC 8878, section 4.1.1. Symbols with a "less
+// than 1" probability are placed at the end of the table.
+func spreadFSESymbols(probabilities []int16, accuracyLog uint) ([]uint8, bool) {
+	tableSize := 1 << accuracyLog
+	symbols := make([]uint8, tableSize)
+	highThreshold := tableSize - 1
+	for symbol, probability := range probabilities {
+		if probability == -1 {
+			symbols[highThreshold] = uint8(symbol)
+			highThreshold--
+		}
+	}
+
+	position := 0
+	step := tableSize>>1 + tableSize>>3 + 3
+	mask := tableSize - 1
+	for symbol, probability := range probabilities {
+		for i := 0; i < int(probability); i++ {
+			symbols[position] = uint8(symbol)
+			position = (position + step) & mask
+			for position > highThreshold {
+				position = (position + step) & mask
+			}
+		}
+	}
+	return symbols, position == 0
+}
+
+type fseDecodingEntry struct {
+	symbol   uint8
+	nBits    uint8
+	baseline uint16
+}
+
+// fseDecodingTable is a table for decoding FSE compressed symbols.
+// The state of the decoder is an index in this table.
+type fseDecodingTable struct {
+	accuracyLog uint
+	entries     []fseDecodingEntry
+}
+
+func newFSEDecodingTable(probabilities []int16, accuracyLog uint) (*fseDecodingTable, error) {
+	symbols, ok := spreadFSESymbols(probabilities, accuracyLog)
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "FSE table has invalid probabilities")
+	}
+
+	next := make([]int, len(probabilities))
+	for symbol, probability := range probabilities {
+		if probability == -1 {
+			next[symbol] = 1
+		} else {
+			next[symbol] = int(probability)
+		}
+	}
+
+	tableSize := 1 << accuracyLog
+	entries := make([]fseDecodingEntry, tableSize)
+	for state, symbol := range symbols {
+		nextState := next[symbol]
+		next[symbol]++
+		nBits := accuracyLog + 1 - uint(bits.Len(uint(nextState)))
+		entries[state] = fseDecodingEntry{
+			symbol:   symbol,
+			nBits:    uint8(nBits),
+			baseline: uint16(nextState<<nBits - tableSize),
+		}
+	}
+	return &fseDecodingTable{
+		accuracyLog: accuracyLog,
+		entries:     entries,
+	}, nil
+}
+
+// newRLEFSEDecodingTable creates an FSE decoding table that always
+// yields the same symbol, without consuming any bits.
+func newRLEFSEDecodingTable(symbol uint8) *fseDecodingTable {
+	return &fseDecodingTable{
+		entries: []fseDecodingEntry{{symbol: symbol}},
+	}
+}
+
+func mustNewFSEDecodingTable(probabilities []int16, accuracyLog uint) *fseDecodingTable {
+	t, err := newFSEDecodingTable(probabilities, accuracyLog)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+type fseSymbolTransform struct {
+	deltaFindState int32
+	deltaNBits     uint32
+}
+
+// fseEncodingTable is a table for encoding symbols using FSE. It is the
+// inverse of fseDecodingTable, using the same construction as the
+// reference implementation.
+type fseEncodingTable struct {
+	accuracyLog uint
+	states      []uint16
+	transforms  []fseSymbolTransform
+}
+
+func mustNewFSEEncodingTable(probabilities []int16, accuracyLog uint) *fseEncodingTable {
+	symbols, ok := spreadFSESymbols(probabilities, accuracyLog)
+	if !ok {
+		panic("FSE table has invalid probabilities")
+	}
+
+	tableSize := 1 << accuracyLog
+	cumulative := make([]int, len(probabilities)+1)
+	for symbol, probability := range probabilities {
+		if probability == -1 {
+			cumulative[symbol+1] = cumulative[symbol] + 1
+		} else {
+			cumulative[symbol+1] = cumulative[symbol] + int(probability)
+		}
+	}
+	states := make([]uint16, tableSize)
+	for state, symbol := range symbols {
+		states[cumulative[symbol]] = uint16(tableSize + state)
+		cumulative[symbol]++
+	}
+
+	transforms := make([]fseSymbolTransform, len(probabilities))
+	total := 0
+	for symbol, probability := range probabilities {
+		switch probability {
+		case 0:
+			transforms[symbol].deltaNBits = uint32(accuracyLog+1)<<16 - uint32(tableSize)
+		case -1, 1:
+			transforms[symbol] = fseSymbolTransform{
+				deltaFindState: int32(total - 1),
+				deltaNBits:     uint32(accuracyLog)<<16 - uint32(tableSize),
+			}
+			total++
+		default:
+			maximumBitsOut := accuracyLog + 1 - uint(bits.Len(uint(probability-1)))
+			minimumStatePlus := uint32(probability) << maximumBitsOut
+			transforms[symbol] = fseSymbolTransform{
+				deltaFindState: int32(total - int(probability)),
+				deltaNBits:     uint32(maximumBitsOut)<<16 - minimumStatePlus,
+			}
+			total += int(probability)
+		}
+	}
+	return &fseEncodingTable{
+		accuracyLog: accuracyLog,
+		states:      states,
+		transforms:  transforms,
+	}
+}
+
+// fseEncoder holds the state of a single FSE encoded stream. Symbols
+// are encoded in reverse order, as the decoder reads the bitstream
+// backwards.
+type fseEncoder struct {
+	table *fseEncodingTable
+	state uint32
+}
+
+func (e *fseEncoder) init(table *fseEncodingTable, symbol uint8) {
+	transform := table.transforms[symbol]
+	nBitsOut := (transform.deltaNBits + 1<<15) >> 16
+	value := nBitsOut<<16 - transform.deltaNBits
+	e.table = table
+	e.state = uint32(table.states[int32(value>>nBitsOut)+transform.deltaFindState])
+}
+
+func (e *fseEncoder) encode(bw *bitWriter, symbol uint8) {
+	transform := e.table.transforms[symbol]
+	nBitsOut := (e.state + transform.deltaNBits) >> 16
+	bw.addBits(uint64(e.state), uint(nBitsOut))
+	e.state = uint32(e.table.states[int32(e.state>>nBitsOut)+transform.deltaFindState])
+}
+
+func (e *fseEncoder) flush(bw *bitWriter) {
+	bw.addBits(uint64(e.state), e.table.accuracyLog)
+}
@@ -0,0 +1,312 @@
+package zstd
+
+import (
+	"math/bits"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	huffmanMaximumBits           = 11
+	huffmanWeightsMaximumAccLog  = 6
+	huffmanWeightsMaximumSymbols = 255
+)
+
+type huffmanDecodingEntry struct {
+	symbol uint8
+	nBits  uint8
+}
+
+// huffmanDecodingTable is a table for decoding Huffman coded literals.
+// It is indexed by the next maximumBits bits of the bitstream.
+type huffmanDecodingTable struct {
+	maximumBits uint
+	entries     []huffmanDecodingEntry
+}
+
+// readHuffmanTable parses a Huffman tree description, as described
+// in RFC 8878, section 4.2.1. It returns the decoding table and the
+// number of bytes consumed.
+func readHuffmanTable(data []byte) (*huffmanDecodingTable, int, error) {
+	if len(data) == 0 {
+		return nil, 0, status.Error(codes.InvalidArgument, "Huffman tree description is truncated")
+	}
+	var weights [256]uint8
+	var weightsCount int
+	var consumed int
+	if header := int(data[0]); header >= 128 {
+		// Weights are stored directly as 4-bit values.
+		weightsCount = header - 127
+		consumed = 1 + (weightsCount+1)/2
+		if len(data) < consumed {
+			return nil, 0, status.Error(codes.InvalidArgument, "Huffman tree description is truncated")
+		}
+		for i := 0; i < weightsCount; i++ {
+			b := data[1+i/2]
+			if i%2 == 0 {
+				weights[i] = b >> 4
+			} else {
+				weights[i] = b & 0xf
+			}
+		}
+	} else {
+		// Weights are compressed using FSE, using two
+		// interleaved states.
+		consumed = 1 + header
+		if len(data) < consumed {
+			return nil, 0, status.Error(codes.InvalidArgument, "Huffman tree description is truncated")
+		}
+		var err error
+		weightsCount, err = decodeHuffmanWeights(data[1:consumed], &weights)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// Derive the weight of the last symbol, which is implied by
+	// the weights of all other symbols.
+	total := 0
+	for _, weight := range weights[:weightsCount] {
+		if weight > huffmanMaximumBits {
+			return nil, 0, status.Errorf(codes.InvalidArgument, "Huffman weight %d exceeds the maximum of %d", weight, huffmanMaximumBits)
+		}
+		if weight > 0 {
+			total += 1 << (weight - 1)
+		}
+	}
+	if total == 0 {
+		return nil, 0, status.Error(codes.InvalidArgument, "Huffman tree description has no weights")
+	}
+	maximumBits := uint(bits.Len(uint(total)))
+	if maximumBits > huffmanMaximumBits {
+		return nil, 0, status.Errorf(codes.InvalidArgument, "Huffman codes are %d bits in size, while at most %d bits are permitted", maximumBits, huffmanMaximumBits)
+	}
+	rest := 1<<maximumBits - total
+	if rest&(rest-1) != 0 {
+		return nil, 0, status.Error(codes.InvalidArgument, "Huffman weights do not form a complete tree")
+	}
+	weights[weightsCount] = uint8(bits.Len(uint(rest)))
+	symbolsCount := weightsCount + 1
+
+	// Assign codes in order of increasing weight, followed by
+	// symbol value. Symbols with the lowest weight have the longest
+	// codes, and are thus placed at the start of the table.
+	var rankStart [huffmanMaximumBits + 2]int
+	for _, weight := range weights[:symbolsCount] {
+		if weight > 0 {
+			rankStart[weight+1] += 1 << (weight - 1)
+		}
+	}
+	for weight := 1; weight < len(rankStart); weight++ {
+		rankStart[weight] += rankStart[weight-1]
+	}
+	entries := make([]huffmanDecodingEntry, 1<<maximumBits)
+	for symbol, weight := range weights[:symbolsCount] {
+		if weight > 0 {
+			start := rankStart[weight]
+			length := 1 << (weight - 1)
+			entry := huffmanDecodingEntry{
+				symbol: uint8(symbol),
+				nBits:  uint8(maximumBits + 1 - uint(weight)),
+			}
+			for i := start; i < start+length; i++ {
+				entries[i] = entry
+			}
+			rankStart[weight] += length
+		}
+	}
+	return &huffmanDecodingTable{
+		maximumBits: maximumBits,
+		entries:     entries,
+	}, consumed, nil
+}
+
+// decodeHuffmanWeights decodes FSE compressed Huffman weights. Two
+// states are used, which take turns decoding symbols until the
+// bitstream is exhausted.
+func decodeHuffmanWeights(data []byte, weights *[256]uint8) (int, error) {
+	probabilities, accuracyLog, consumed, err := readFSETableDescription(data, huffmanMaximumBits, huffmanWeightsMaximumAccLog)
+	if err != nil {
+		return 0, err
+	}
+	table, err := newFSEDecodingTable(probabilities, accuracyLog)
+	if err != nil {
+		return 0, err
+	}
+	br, err := newBackwardBitReader(data[consumed:])
+	if err != nil {
+		return 0, err
+	}
+
+	states := [2]uint64{br.read(accuracyLog), br.read(accuracyLog)}
+	count := 0
+	for i := 0; ; i ^= 1 {
+		if count >= huffmanWeightsMaximumSymbols {
+			return 0, status.Error(codes.InvalidArgument, "Too many Huffman weights")
+		}
+		entry := &table.entries[states[i]]
+		weights[count] = entry.symbol
+		count++
+		states[i] = uint64(entry.baseline) + br.read(uint(entry.nBits))
+		if br.overflowed() {
+			// The other state still holds a final symbol.
+			if count >= huffmanWeightsMaximumSymbols {
+				return 0, status.Error(codes.InvalidArgument, "Too many Huffman weights")
+			}
+			weights[count] = table.entries[states[i^1]].symbol
+			return count + 1, nil
+		}
+	}
+}
+
+// decodeStream decodes a single Huffman coded bitstream, filling the
+// output slice entirely. The bitstream must be consumed exactly.
+func (t *huffmanDecodingTable) decodeStream(data, out []byte) error {
+	br, err := newBackwardBitReader(data)
+	if err != nil {
+		return err
+	}
+	for i := range out {
+		entry := t.entries[br.peek(t.maximumBits)]
+		out[i] = entry.symbol
+		br.skip(uint(entry.nBits))
+	}
+	if !br.finished() {
+		return status.Error(codes.InvalidArgument, "Huffman coded stream has an incorrect length")
+	}
+	return nil
+}
+
+// huffmanEncodingTable contains the codes that are used to encode
+// literals, together with the weights from which the decoder derives
+// the same codes.
+type huffmanEncodingTable struct {
+	codes        [256]uint16
+	nBits        [256]uint8
+	weights      [256]uint8
+	weightsCount int
+}
+
+// newHuffmanEncodingTable creates a table for encoding a sequence of
+// literals. Weights are always stored directly as 4-bit values, which
+// is only possible if the highest symbol value is at most 128. This
+// is the case for textual data, which is where Huffman coding is most
+// effective. False is returned if the literals cannot be encoded.
+func newHuffmanEncodingTable(literals []byte) (*huffmanEncodingTable, bool) {
+	var counts [256]int
+	for _, literal := range literals {
+		counts[literal]++
+	}
+	var symbols []int
+	for symbol, count := range counts {
+		if count > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	if len(symbols) < 2 || symbols[len(symbols)-1] > 128 {
+		return nil, false
+	}
+
+	// Compute code lengths, halving the symbol counts until no
+	// code exceeds the maximum length permitted by the format.
+	t := &huffmanEncodingTable{weightsCount: symbols[len(symbols)-1]}
+	maximumBits := getHuffmanCodeLengths(&counts, symbols, &t.nBits)
+	for maximumBits > huffmanMaximumBits {
+		for _, symbol := range symbols {
+			counts[symbol] = (counts[symbol] + 1) / 2
+		}
+		maximumBits = getHuffmanCodeLengths(&counts, symbols, &t.nBits)
+	}
+
+	// Assign codes in the same order as readHuffmanTable().
+	var rankStart [huffmanMaximumBits + 2]int
+	for _, symbol := range symbols {
+		weight := uint8(maximumBits + 1 - uint(t.nBits[symbol]))
+		t.weights[symbol] = weight
+		rankStart[weight+1] += 1 << (weight - 1)
+	}
+	for weight := 1; weight < len(rankStart); weight++ {
+		rankStart[weight] += rankStart[weight-1]
+	}
+	for _, symbol := range symbols {
+		weight := t.weights[symbol]
+		t.codes[symbol] = uint16(rankStart[weight] >> (weight - 1))
+		rankStart[weight] += 1 << (weight - 1)
+	}
+	return t, true
+}
+
+// getHuffmanCodeLengths computes the lengths of the codes of an
+// optimal prefix code for a set of symbols, returning the length of
+// the longest code. Symbols must be provided in increasing order.
+func getHuffmanCodeLengths(counts *[256]int, symbols []int, nBits *[256]uint8) uint {
+	// Sort leaves by count. Internal nodes are created in order of
+	// increasing count, meaning that the two nodes with the lowest
+	// count can be obtained by merging both lists.
+	leaves := append([]int(nil), symbols...)
+	sort.SliceStable(leaves, func(i, j int) bool {
+		return counts[leaves[i]] < counts[leaves[j]]
+	})
+	n := len(leaves)
+	nodeCounts := make([]int, 2*n-1)
+	parents := make([]int, 2*n-1)
+	for i, symbol := range leaves {
+		nodeCounts[i] = counts[symbol]
+	}
+	nextLeaf, nextInternal := 0, n
+	for node := n; node < len(nodeCounts); node++ {
+		for i := 0; i < 2; i++ {
+			child := nextInternal
+			if nextLeaf < n && (nextInternal == node || nodeCounts[nextLeaf] <= nodeCounts[nextInternal]) {
+				child = nextLeaf
+				nextLeaf++
+			} else {
+				nextInternal++
+			}
+			nodeCounts[node] += nodeCounts[child]
+			parents[child] = node
+		}
+	}
+
+	// Derive the depth of all nodes, starting at the root.
+	depths := make([]uint8, 2*n-1)
+	maximumBits := uint(0)
+	for node := len(depths) - 2; node >= 0; node-- {
+		depths[node] = depths[parents[node]] + 1
+		if node < n {
+			nBits[leaves[node]] = depths[node]
+			if uint(depths[node]) > maximumBits {
+				maximumBits = uint(depths[node])
+			}
+		}
+	}
+	return maximumBits
+}
+
+// appendDescription appends a Huffman tree description to a byte
+// slice, as described in RFC 8878, section 4.2.1.
+func (t *huffmanEncodingTable) appendDescription(out []byte) []byte {
+	out = append(out, byte(127+t.weightsCount))
+	for i := 0; i < t.weightsCount; i += 2 {
+		b := t.weights[i] << 4
+		if i+1 < t.weightsCount {
+			b |= t.weights[i+1]
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// appendStream appends a single Huffman coded bitstream to a byte
+// slice. Literals are encoded in reverse order, as the decoder reads
+// the bitstream backwards.
+func (t *huffmanEncodingTable) appendStream(out, literals []byte) []byte {
+	bw := bitWriter{out: out}
+	for i := len(literals) - 1; i >= 0; i-- {
+		literal := literals[i]
+		bw.addBits(uint64(t.codes[literal]), uint(t.nBits[literal]))
+	}
+	return bw.close()
+}
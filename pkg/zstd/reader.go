@@ -0,0 +1,598 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/buildbarn/bb-storage/pkg/util"
+	"github.com/cespare/xxhash/v2"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	frameMagicNumber          = 0xfd2fb528
+	skippableFrameMagicNumber = 0x184d2a50
+	skippableFrameMagicMask   = 0xfffffff0
+
+	blockMaximumSizeBytes = 128 * 1024
+
+	blockTypeRaw        = 0
+	blockTypeRLE        = 1
+	blockTypeCompressed = 2
+
+	literalsBlockTypeRaw        = 0
+	literalsBlockTypeRLE        = 1
+	literalsBlockTypeCompressed = 2
+	literalsBlockTypeTreeless   = 3
+
+	compressionModePredefined = 0
+	compressionModeRLE        = 1
+	compressionModeFSE        = 2
+	compressionModeRepeat     = 3
+)
+
+type reader struct {
+	r                      io.Reader
+	maximumWindowSizeBytes uint64
+	err                    error
+	scratch                [8]byte
+
+	// State of the frame that is currently being decoded.
+	inFrame         bool
+	windowSizeBytes uint64
+	hasContentSize  bool
+	contentSize     uint64
+	checksum        *xxhash.Digest
+
+	// Data that has been decoded as part of the current frame.
+	// Only the trailing part that is within the window is
+	// retained. The part starting at outputOffset has not been
+	// returned by Read() yet.
+	history        []byte
+	historyOffset  uint64
+	outputOffset   int
+	block          []byte
+	literals       []byte
+	literalsBuffer []byte
+	huffmanTable   *huffmanDecodingTable
+	sequenceTables [3]*fseDecodingTable
+	repeatOffsets  [3]uint32
+}
+
+// NewReader creates a decompressor for data stored in the Zstandard
+// format, as described in RFC 8878. The input may consist of multiple
+// frames, whose decompressed contents are concatenated.
+//
+// Frames that require a window larger than maximumWindowSizeBytes are
+// rejected, as the decompressor needs to keep the contents of the
+// window in memory. Dictionaries are not supported.
+//
+// As this decompressor is used to process data provided by untrusted
+// clients, all sizes stored in the input are validated before memory
+// is allocated. Memory usage is bounded by twice the window size, plus
+// the maximum block size of 128 KiB. The decompressor is tested
+// against a corpus of inputs obtained by fuzzing, starting with frames
+// generated by the reference implementation.
+func NewReader(r io.Reader, maximumWindowSizeBytes int) io.Reader {
+	return &reader{
+		r:                      r,
+		maximumWindowSizeBytes: uint64(maximumWindowSizeBytes),
+	}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for {
+		if r.outputOffset < len(r.history) {
+			n := copy(p, r.history[r.outputOffset:])
+			r.outputOffset += n
+			return n, nil
+		}
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.inFrame {
+			r.err = r.readBlock()
+		} else {
+			r.err = r.readFrameHeader()
+		}
+	}
+}
+
+// readFull reads data from the underlying stream, treating the end of
+// the stream as an error.
+func (r *reader) readFull(p []byte) error {
+	if _, err := io.ReadFull(r.r, p); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return status.Error(codes.InvalidArgument, "Zstandard stream is truncated")
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *reader) readLittleEndian(n int) (uint64, error) {
+	if err := r.readFull(r.scratch[:n]); err != nil {
+		return 0, err
+	}
+	var v uint64
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(r.scratch[i])
+	}
+	return v, nil
+}
+
+func (r *reader) readFrameHeader() error {
+	// Only permit the stream to end at frame boundaries.
+	if _, err := io.ReadFull(r.r, r.scratch[:4]); err == io.EOF {
+		return io.EOF
+	} else if err == io.ErrUnexpectedEOF {
+		return status.Error(codes.InvalidArgument, "Zstandard stream is truncated")
+	} else if err != nil {
+		return err
+	}
+
+	magicNumber := binary.LittleEndian.Uint32(r.scratch[:])
+	if magicNumber&skippableFrameMagicMask == skippableFrameMagicNumber {
+		frameSize, err := r.readLittleEndian(4)
+		if err != nil {
+			return err
+		}
+		if _, err := io.CopyN(ioutil.Discard, r.r, int64(frameSize)); err != nil {
+			if err == io.EOF {
+				return status.Error(codes.InvalidArgument, "Zstandard stream is truncated")
+			}
+			return err
+		}
+		return nil
+	}
+	if magicNumber != frameMagicNumber {
+		return status.Errorf(codes.InvalidArgument, "Zstandard frame has invalid magic number 0x%08x", magicNumber)
+	}
+
+	frameHeaderDescriptor, err := r.readLittleEndian(1)
+	if err != nil {
+		return err
+	}
+	if frameHeaderDescriptor&0x08 != 0 {
+		return status.Error(codes.InvalidArgument, "Zstandard frame header has reserved bit set")
+	}
+	singleSegment := frameHeaderDescriptor&0x20 != 0
+	if !singleSegment {
+		windowDescriptor, err := r.readLittleEndian(1)
+		if err != nil {
+			return err
+		}
+		windowBase := uint64(1) << (10 + windowDescriptor>>3)
+		r.windowSizeBytes = windowBase + windowBase/8*(windowDescriptor&7)
+	}
+	if dictionaryID, err := r.readLittleEndian([...]int{0, 1, 2, 4}[frameHeaderDescriptor&3]); err != nil {
+		return err
+	} else if dictionaryID != 0 {
+		return status.Errorf(codes.InvalidArgument, "Zstandard frame uses dictionary %d, while dictionaries are not supported", dictionaryID)
+	}
+	contentSizeFieldSize := [...]int{0, 2, 4, 8}[frameHeaderDescriptor>>6]
+	if contentSizeFieldSize == 0 && singleSegment {
+		contentSizeFieldSize = 1
+	}
+	r.hasContentSize = contentSizeFieldSize > 0
+	if r.contentSize, err = r.readLittleEndian(contentSizeFieldSize); err != nil {
+		return err
+	}
+	if contentSizeFieldSize == 2 {
+		r.contentSize += 256
+	}
+	if singleSegment {
+		r.windowSizeBytes = r.contentSize
+	}
+	if r.windowSizeBytes > r.maximumWindowSizeBytes {
+		return status.Errorf(codes.InvalidArgument, "Zstandard frame requires a window of %d bytes, while at most %d bytes are permitted", r.windowSizeBytes, r.maximumWindowSizeBytes)
+	}
+
+	if frameHeaderDescriptor&0x04 != 0 {
+		r.checksum = xxhash.New()
+	} else {
+		r.checksum = nil
+	}
+	r.inFrame = true
+	r.history = r.history[:0]
+	r.historyOffset = 0
+	r.outputOffset = 0
+	r.huffmanTable = nil
+	r.sequenceTables = [3]*fseDecodingTable{}
+	r.repeatOffsets = [3]uint32{1, 4, 8}
+	return nil
+}
+
+func (r *reader) readBlock() error {
+	// Discard data that has been returned by Read() and is no
+	// longer part of the window. This is done lazily, so that the
+	// cost of copying is amortized.
+	if historySize := uint64(len(r.history)); historySize > r.windowSizeBytes {
+		if excess := historySize - r.windowSizeBytes; excess >= r.windowSizeBytes && excess >= blockMaximumSizeBytes {
+			r.history = r.history[:copy(r.history, r.history[excess:])]
+			r.historyOffset += excess
+			r.outputOffset = len(r.history)
+		}
+	}
+
+	blockHeader, err := r.readLittleEndian(3)
+	if err != nil {
+		return err
+	}
+	lastBlock := blockHeader&1 != 0
+	blockSize := int(blockHeader >> 3)
+	if blockSize > blockMaximumSizeBytes {
+		return status.Errorf(codes.InvalidArgument, "Zstandard block is %d bytes in size, while at most %d bytes are permitted", blockSize, blockMaximumSizeBytes)
+	}
+
+	blockStart := len(r.history)
+	switch blockType := (blockHeader >> 1) & 3; blockType {
+	case blockTypeRaw:
+		r.history = append(r.history, make([]byte, blockSize)...)
+		if err := r.readFull(r.history[blockStart:]); err != nil {
+			return err
+		}
+	case blockTypeRLE:
+		if err := r.readFull(r.scratch[:1]); err != nil {
+			return err
+		}
+		for i := 0; i < blockSize; i++ {
+			r.history = append(r.history, r.scratch[0])
+		}
+	case blockTypeCompressed:
+		if cap(r.block) < blockSize {
+			r.block = make([]byte, blockSize)
+		}
+		r.block = r.block[:blockSize]
+		if err := r.readFull(r.block); err != nil {
+			return err
+		}
+		if err := r.decodeCompressedBlock(r.block, blockStart); err != nil {
+			return err
+		}
+		if len(r.history)-blockStart > blockMaximumSizeBytes {
+			return status.Errorf(codes.InvalidArgument, "Zstandard block decompresses to %d bytes, while at most %d bytes are permitted", len(r.history)-blockStart, blockMaximumSizeBytes)
+		}
+	default:
+		return status.Error(codes.InvalidArgument, "Zstandard block has reserved type")
+	}
+
+	if r.checksum != nil {
+		r.checksum.Write(r.history[blockStart:])
+	}
+	frameSize := r.historyOffset + uint64(len(r.history))
+	if r.hasContentSize && frameSize > r.contentSize {
+		return status.Errorf(codes.InvalidArgument, "Zstandard frame decompresses to more than %d bytes, which is the content size stored in the frame header", r.contentSize)
+	}
+	if lastBlock {
+		if r.hasContentSize && frameSize != r.contentSize {
+			return status.Errorf(codes.InvalidArgument, "Zstandard frame decompresses to %d bytes, while the frame header states a content size of %d bytes", frameSize, r.contentSize)
+		}
+		if r.checksum != nil {
+			expectedChecksum, err := r.readLittleEndian(4)
+			if err != nil {
+				return err
+			}
+			if actualChecksum := r.checksum.Sum64() & 0xffffffff; actualChecksum != expectedChecksum {
+				return status.Errorf(codes.InvalidArgument, "Zstandard frame has checksum 0x%08x, while 0x%08x was expected", actualChecksum, expectedChecksum)
+			}
+		}
+		r.inFrame = false
+	}
+	return nil
+}
+
+func (r *reader) decodeCompressedBlock(block []byte, blockStart int) error {
+	consumed, err := r.decodeLiterals(block)
+	if err != nil {
+		return util.StatusWrap(err, "Failed to decode literals section")
+	}
+	if err := r.decodeSequences(block[consumed:], blockStart); err != nil {
+		return util.StatusWrap(err, "Failed to decode sequences section")
+	}
+	return nil
+}
+
+// decodeLiterals decodes the literals section of a compressed block,
+// as described in RFC 8878, section 3.1.1.3.1.
+func (r *reader) decodeLiterals(block []byte) (int, error) {
+	if len(block) == 0 {
+		return 0, status.Error(codes.InvalidArgument, "Literals section header is truncated")
+	}
+	literalsBlockType := block[0] & 3
+	sizeFormat := (block[0] >> 2) & 3
+	switch literalsBlockType {
+	case literalsBlockTypeRaw, literalsBlockTypeRLE:
+		var headerSize, regeneratedSize int
+		switch sizeFormat {
+		case 0, 2:
+			headerSize, regeneratedSize = 1, int(block[0]>>3)
+		case 1:
+			if len(block) < 2 {
+				return 0, status.Error(codes.InvalidArgument, "Literals section header is truncated")
+			}
+			headerSize, regeneratedSize = 2, int(block[0]>>4)|int(block[1])<<4
+		case 3:
+			if len(block) < 3 {
+				return 0, status.Error(codes.InvalidArgument, "Literals section header is truncated")
+			}
+			headerSize, regeneratedSize = 3, int(block[0]>>4)|int(block[1])<<4|int(block[2])<<12
+		}
+		if regeneratedSize > blockMaximumSizeBytes {
+			return 0, status.Errorf(codes.InvalidArgument, "Literals section is %d bytes in size, while at most %d bytes are permitted", regeneratedSize, blockMaximumSizeBytes)
+		}
+		if literalsBlockType == literalsBlockTypeRaw {
+			if len(block) < headerSize+regeneratedSize {
+				return 0, status.Error(codes.InvalidArgument, "Literals section is truncated")
+			}
+			r.literals = block[headerSize : headerSize+regeneratedSize]
+			return headerSize + regeneratedSize, nil
+		}
+		if len(block) < headerSize+1 {
+			return 0, status.Error(codes.InvalidArgument, "Literals section is truncated")
+		}
+		r.literalsBuffer = r.literalsBuffer[:0]
+		for i := 0; i < regeneratedSize; i++ {
+			r.literalsBuffer = append(r.literalsBuffer, block[headerSize])
+		}
+		r.literals = r.literalsBuffer
+		return headerSize + 1, nil
+	default:
+		headerSize, sizeBits, streamsCount := 3, uint(10), 4
+		switch sizeFormat {
+		case 0:
+			streamsCount = 1
+		case 2:
+			headerSize, sizeBits = 4, 14
+		case 3:
+			headerSize, sizeBits = 5, 18
+		}
+		if len(block) < headerSize {
+			return 0, status.Error(codes.InvalidArgument, "Literals section header is truncated")
+		}
+		header := loadBits(block[:headerSize], 0, uint(headerSize)*8)
+		regeneratedSize := int((header >> 4) & (1<<sizeBits - 1))
+		compressedSize := int((header >> (4 + sizeBits)) & (1<<sizeBits - 1))
+		if regeneratedSize > blockMaximumSizeBytes {
+			return 0, status.Errorf(codes.InvalidArgument, "Literals section is %d bytes in size, while at most %d bytes are permitted", regeneratedSize, blockMaximumSizeBytes)
+		}
+		if len(block) < headerSize+compressedSize {
+			return 0, status.Error(codes.InvalidArgument, "Literals section is truncated")
+		}
+		data := block[headerSize : headerSize+compressedSize]
+
+		if literalsBlockType == literalsBlockTypeCompressed {
+			table, consumed, err := readHuffmanTable(data)
+			if err != nil {
+				return 0, err
+			}
+			r.huffmanTable = table
+			data = data[consumed:]
+		} else if r.huffmanTable == nil {
+			return 0, status.Error(codes.InvalidArgument, "Literals section reuses Huffman table, while no previous table exists")
+		}
+
+		if cap(r.literalsBuffer) < regeneratedSize {
+			r.literalsBuffer = make([]byte, regeneratedSize)
+		}
+		r.literals = r.literalsBuffer[:regeneratedSize]
+		if streamsCount == 1 {
+			return headerSize + compressedSize, r.huffmanTable.decodeStream(data, r.literals)
+		}
+
+		// Four streams, preceded by a jump table containing
+		// the sizes of the first three streams.
+		if len(data) < 6 {
+			return 0, status.Error(codes.InvalidArgument, "Jump table is truncated")
+		}
+		streamSizes := [4]int{
+			int(binary.LittleEndian.Uint16(data[0:])),
+			int(binary.LittleEndian.Uint16(data[2:])),
+			int(binary.LittleEndian.Uint16(data[4:])),
+		}
+		data = data[6:]
+		streamSizes[3] = len(data) - streamSizes[0] - streamSizes[1] - streamSizes[2]
+		if streamSizes[3] < 0 {
+			return 0, status.Error(codes.InvalidArgument, "Jump table contains stream sizes that exceed the size of the literals section")
+		}
+		segmentSize := (regeneratedSize + 3) / 4
+		if 3*segmentSize > regeneratedSize {
+			return 0, status.Error(codes.InvalidArgument, "Literals section is too small to be split into four streams")
+		}
+		out := r.literals
+		for i, streamSize := range streamSizes {
+			outSize := segmentSize
+			if i == 3 {
+				outSize = len(out)
+			}
+			if err := r.huffmanTable.decodeStream(data[:streamSize], out[:outSize]); err != nil {
+				return 0, util.StatusWrapf(err, "Stream %d", i+1)
+			}
+			data = data[streamSize:]
+			out = out[outSize:]
+		}
+		return headerSize + compressedSize, nil
+	}
+}
+
+// readSequenceTable obtains the FSE decoding table for one of the
+// symbol types used by the sequences section.
+func (r *reader) readSequenceTable(data []byte, index int, mode byte, predefined *fseDecodingTable, maximumSymbol int, maximumAccuracyLog uint) (int, error) {
+	switch mode {
+	case compressionModePredefined:
+		r.sequenceTables[index] = predefined
+		return 0, nil
+	case compressionModeRLE:
+		if len(data) < 1 {
+			return 0, status.Error(codes.InvalidArgument, "RLE symbol is truncated")
+		}
+		if int(data[0]) > maximumSymbol {
+			return 0, status.Errorf(codes.InvalidArgument, "RLE symbol %d exceeds the maximum of %d", data[0], maximumSymbol)
+		}
+		r.sequenceTables[index] = newRLEFSEDecodingTable(data[0])
+		return 1, nil
+	case compressionModeFSE:
+		probabilities, accuracyLog, consumed, err := readFSETableDescription(data, maximumSymbol, maximumAccuracyLog)
+		if err != nil {
+			return 0, err
+		}
+		table, err := newFSEDecodingTable(probabilities, accuracyLog)
+		if err != nil {
+			return 0, err
+		}
+		r.sequenceTables[index] = table
+		return consumed, nil
+	default:
+		if r.sequenceTables[index] == nil {
+			return 0, status.Error(codes.InvalidArgument, "Sequences section reuses FSE table, while no previous table exists")
+		}
+		return 0, nil
+	}
+}
+
+// decodeSequences decodes the sequences section of a compressed
+// block and executes the sequences, as described in RFC 8878,
+// sections 3.1.1.3.2 and 3.1.1.4.
+func (r *reader) decodeSequences(data []byte, blockStart int) error {
+	if len(data) == 0 {
+		return status.Error(codes.InvalidArgument, "Sequences section header is truncated")
+	}
+	var sequencesCount, consumed int
+	switch b := int(data[0]); {
+	case b < 128:
+		sequencesCount, consumed = b, 1
+	case b < 255:
+		if len(data) < 2 {
+			return status.Error(codes.InvalidArgument, "Sequences section header is truncated")
+		}
+		sequencesCount, consumed = (b-128)<<8+int(data[1]), 2
+	default:
+		if len(data) < 3 {
+			return status.Error(codes.InvalidArgument, "Sequences section header is truncated")
+		}
+		sequencesCount, consumed = int(data[1])+int(data[2])<<8+0x7f00, 3
+	}
+	data = data[consumed:]
+	if sequencesCount == 0 {
+		if len(data) != 0 {
+			return status.Error(codes.InvalidArgument, "Sequences section contains trailing data")
+		}
+		r.history = append(r.history, r.literals...)
+		return nil
+	}
+
+	if len(data) == 0 {
+		return status.Error(codes.InvalidArgument, "Sequences section header is truncated")
+	}
+	modes := data[0]
+	if modes&3 != 0 {
+		return status.Error(codes.InvalidArgument, "Sequences section header has reserved bits set")
+	}
+	data = data[1:]
+	for i, table := range []struct {
+		mode               byte
+		predefined         *fseDecodingTable
+		maximumSymbol      int
+		maximumAccuracyLog uint
+	}{
+		{modes >> 6, predefinedLiteralsLengthDecodingTable, len(literalsLengthCodes) - 1, literalsLengthMaximumAccuracyLog},
+		{(modes >> 4) & 3, predefinedOffsetDecodingTable, offsetCodeMaximum, offsetMaximumAccuracyLog},
+		{(modes >> 2) & 3, predefinedMatchLengthDecodingTable, len(matchLengthCodes) - 1, matchLengthMaximumAccuracyLog},
+	} {
+		consumed, err := r.readSequenceTable(data, i, table.mode, table.predefined, table.maximumSymbol, table.maximumAccuracyLog)
+		if err != nil {
+			return err
+		}
+		data = data[consumed:]
+	}
+
+	br, err := newBackwardBitReader(data)
+	if err != nil {
+		return err
+	}
+	literalsLengthTable, offsetTable, matchLengthTable := r.sequenceTables[0], r.sequenceTables[1], r.sequenceTables[2]
+	literalsLengthState := br.read(literalsLengthTable.accuracyLog)
+	offsetState := br.read(offsetTable.accuracyLog)
+	matchLengthState := br.read(matchLengthTable.accuracyLog)
+	literals := r.literals
+	for i := 0; i < sequencesCount; i++ {
+		literalsLengthEntry := &literalsLengthTable.entries[literalsLengthState]
+		offsetEntry := &offsetTable.entries[offsetState]
+		matchLengthEntry := &matchLengthTable.entries[matchLengthState]
+
+		// Extract the values of the sequence.
+		offsetCode := uint(offsetEntry.symbol)
+		offsetValue := uint32(1)<<offsetCode + uint32(br.read(offsetCode))
+		matchLengthCode := matchLengthCodes[matchLengthEntry.symbol]
+		matchLength := int(matchLengthCode.baseline) + int(br.read(uint(matchLengthCode.nBits)))
+		literalsLengthCode := literalsLengthCodes[literalsLengthEntry.symbol]
+		literalsLength := int(literalsLengthCode.baseline) + int(br.read(uint(literalsLengthCode.nBits)))
+
+		// Resolve repeated offsets.
+		var offset uint32
+		if offsetValue > 3 {
+			offset = offsetValue - 3
+			r.repeatOffsets = [3]uint32{offset, r.repeatOffsets[0], r.repeatOffsets[1]}
+		} else {
+			if literalsLength == 0 {
+				offsetValue++
+			}
+			switch offsetValue {
+			case 1:
+				offset = r.repeatOffsets[0]
+			case 2:
+				offset = r.repeatOffsets[1]
+				r.repeatOffsets = [3]uint32{offset, r.repeatOffsets[0], r.repeatOffsets[2]}
+			case 3:
+				offset = r.repeatOffsets[2]
+				r.repeatOffsets = [3]uint32{offset, r.repeatOffsets[0], r.repeatOffsets[1]}
+			default:
+				offset = r.repeatOffsets[0] - 1
+				r.repeatOffsets = [3]uint32{offset, r.repeatOffsets[0], r.repeatOffsets[1]}
+			}
+		}
+
+		// Execute the sequence.
+		if literalsLength > len(literals) {
+			return status.Errorf(codes.InvalidArgument, "Sequence %d has a literals length of %d bytes, while only %d bytes of literals remain", i, literalsLength, len(literals))
+		}
+		r.history = append(r.history, literals[:literalsLength]...)
+		literals = literals[literalsLength:]
+		if offset == 0 || uint64(offset) > r.windowSizeBytes || int(offset) > len(r.history) {
+			return status.Errorf(codes.InvalidArgument, "Sequence %d has invalid offset %d", i, offset)
+		}
+		if len(r.history)-blockStart+matchLength > blockMaximumSizeBytes {
+			return status.Errorf(codes.InvalidArgument, "Sequence %d has a match length of %d bytes, which exceeds the maximum block size", i, matchLength)
+		}
+		for matchLength > 0 {
+			// Copy the match in chunks no larger than the
+			// offset, so that overlapping matches repeat
+			// the data correctly.
+			start := len(r.history) - int(offset)
+			n := matchLength
+			if n > int(offset) {
+				n = int(offset)
+			}
+			r.history = append(r.history, r.history[start:start+n]...)
+			matchLength -= n
+		}
+
+		// Update the states for the next sequence.
+		if i+1 < sequencesCount {
+			literalsLengthState = uint64(literalsLengthEntry.baseline) + br.read(uint(literalsLengthEntry.nBits))
+			matchLengthState = uint64(matchLengthEntry.baseline) + br.read(uint(matchLengthEntry.nBits))
+			offsetState = uint64(offsetEntry.baseline) + br.read(uint(offsetEntry.nBits))
+		}
+		if br.overflowed() {
+			return status.Error(codes.InvalidArgument, "Sequences bitstream is truncated")
+		}
+	}
+	if !br.finished() {
+		return status.Error(codes.InvalidArgument, "Sequences bitstream contains trailing data")
+	}
+	r.history = append(r.history, literals...)
+	return nil
+}
@@ -0,0 +1,160 @@
+package zstd_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/buildbarn/bb-storage/pkg/zstd"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func mustDecodeHex(s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// Frames generated by the reference implementation.
+var (
+	exampleRawFrame = mustDecodeHex("28b52ffd240d69000048656c6c6f2c20776f726c642176946f8b")
+
+	// Squares of 0 to 199, compressed at level 19. This frame
+	// contains Huffman coded literals and sequences that are
+	// encoded using FSE tables stored in the block.
+	exampleCompressedFrame = mustDecodeHex(
+		"28b52ffd641e03a50e00ea413c0709b0eb244988085692796d0071006f00b315" +
+			"03504c935828e293c6148ca71013af1f5817a84ea00e309d205d20fa413b917d" +
+			"0a9de2f88971a1e234e200c4d9e122c28f82d3954fcb69c8cf804b81936e809b" +
+			"628bd5d79a8a9ed254f37516932932804c8aa5f159a7e9d34ec78fb808673900" +
+			"a72df4c9144f277ecb1c02e56c9403b09c66e442447e22e414259f0239d1801f" +
+			"107001014e287000004eb05b80ee03b989bda77053987d42b690d8b4da00b4d9" +
+			"ab45aa3e9a9a2eea696b1aa8cf8c96164d1a1a40684a6931a0af3753d53ca599" +
+			"4ae66bcc629c2930039c4c2a594af299c834214f2bd3817c74b18845d2a7209d" +
+			"58d10f24ba00a313ac1d60da096d1738f603622706fb14d8290afa8940172274" +
+			"9a1d07a0e36c1c1781e347659c4e8c4f6b9ce68a9f5171a91427b138c011a710" +
+			"71b1207e25712a1b3ea5e15431fccae162269c42c201443889c2a517fc8c82d3" +
+			"48f06983d35df951958b4c13e862815e7c601613c5e229584c41159fb46221a3" +
+			"982614039062b6261689898f4e62ba91785a89692ce2338958ca8849d518401a" +
+			"53a0b11831be0663aa83784a105315e2eb5d179beb945e0738eba4b12eadf533" +
+			"ab4e33d5a7ad4e67d48f86ba48a9b3371d80a6d33a5de8a49f90748a080028bc" +
+			"febf")
+)
+
+func getExampleCompressedFrameContents() []byte {
+	var b bytes.Buffer
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&b, "%d,", i*i)
+	}
+	return b.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		// A stream without any frames decompresses to no data.
+		data, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(nil), 1<<20))
+		require.NoError(t, err)
+		require.Empty(t, data)
+	})
+
+	t.Run("RawBlock", func(t *testing.T) {
+		data, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(exampleRawFrame), 1<<20))
+		require.NoError(t, err)
+		require.Equal(t, []byte("Hello, world!"), data)
+	})
+
+	t.Run("CompressedBlock", func(t *testing.T) {
+		data, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(exampleCompressedFrame), 1<<20))
+		require.NoError(t, err)
+		require.Equal(t, getExampleCompressedFrameContents(), data)
+	})
+
+	t.Run("MultipleFrames", func(t *testing.T) {
+		// Frames should be concatenated, while skippable
+		// frames are ignored.
+		var stream []byte
+		stream = append(stream, exampleRawFrame...)
+		stream = append(stream, 0x5e, 0x2a, 0x4d, 0x18, 0x03, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03)
+		stream = append(stream, exampleCompressedFrame...)
+
+		data, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(stream), 1<<20))
+		require.NoError(t, err)
+		require.Equal(t, append([]byte("Hello, world!"), getExampleCompressedFrameContents()...), data)
+	})
+
+	t.Run("InvalidMagicNumber", func(t *testing.T) {
+		_, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader([]byte("Hello, world!")), 1<<20))
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Zstandard frame has invalid magic number 0x6c6c6548"), err)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		_, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(exampleRawFrame[:len(exampleRawFrame)-1]), 1<<20))
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Zstandard stream is truncated"), err)
+	})
+
+	t.Run("ChecksumMismatch", func(t *testing.T) {
+		frame := append([]byte(nil), exampleRawFrame...)
+		frame[len(frame)-1] ^= 0xff
+
+		_, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(frame), 1<<20))
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Zstandard frame has checksum 0x8b6f9476, while 0x746f9476 was expected"), err)
+	})
+
+	t.Run("CorruptedBlock", func(t *testing.T) {
+		frame := append([]byte(nil), exampleCompressedFrame...)
+		frame[len(frame)-8] ^= 0xff
+
+		_, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(frame), 1<<20))
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("WindowTooLarge", func(t *testing.T) {
+		// Frames requiring a window of 64 MiB should be
+		// rejected, as only 1 MiB is permitted.
+		_, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader([]byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x80}), 1<<20))
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Zstandard frame requires a window of 67108864 bytes, while at most 1048576 bytes are permitted"), err)
+	})
+}
+
+func TestReaderCorpus(t *testing.T) {
+	// Inputs obtained by fuzzing the decompressor, starting with
+	// frames generated by the reference implementation at various
+	// compression levels. Decompressing these should never cause a
+	// panic, and any errors should be reported as INVALID_ARGUMENT.
+	entries, err := ioutil.ReadDir("testdata/corpus")
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+
+	for _, entry := range entries {
+		t.Run(entry.Name(), func(t *testing.T) {
+			input, err := ioutil.ReadFile(filepath.Join("testdata/corpus", entry.Name()))
+			require.NoError(t, err)
+
+			data, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(input), 1<<20))
+			if err != nil {
+				require.Equal(t, codes.InvalidArgument, status.Code(err), err.Error())
+				return
+			}
+
+			// Data that decompresses successfully should
+			// survive a round trip through the compressor.
+			var b bytes.Buffer
+			w := zstd.NewWriter(&b)
+			_, err = w.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			roundTripped, err := ioutil.ReadAll(zstd.NewReader(&b, 1<<20))
+			require.NoError(t, err)
+			require.True(t, bytes.Equal(data, roundTripped))
+		})
+	}
+}
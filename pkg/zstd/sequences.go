@@ -0,0 +1,83 @@
+package zstd
+
+// Literals length and match length codes, each consisting of a
+// baseline value and a number of additional bits, as described in RFC
+// 8878, section 3.1.1.3.2.1.1.
+type lengthCode struct {
+	baseline uint32
+	nBits    uint8
+}
+
+var literalsLengthCodes = [...]lengthCode{
+	{0, 0}, {1, 0}, {2, 0}, {3, 0}, {4, 0}, {5, 0}, {6, 0}, {7, 0},
+	{8, 0}, {9, 0}, {10, 0}, {11, 0}, {12, 0}, {13, 0}, {14, 0}, {15, 0},
+	{16, 1}, {18, 1}, {20, 1}, {22, 1}, {24, 2}, {28, 2}, {32, 3}, {40, 3},
+	{48, 4}, {64, 6}, {128, 7}, {256, 8}, {512, 9}, {1024, 10}, {2048, 11}, {4096, 12},
+	{8192, 13}, {16384, 14}, {32768, 15}, {65536, 16},
+}
+
+var matchLengthCodes = [...]lengthCode{
+	{3, 0}, {4, 0}, {5, 0}, {6, 0}, {7, 0}, {8, 0}, {9, 0}, {10, 0},
+	{11, 0}, {12, 0}, {13, 0}, {14, 0}, {15, 0}, {16, 0}, {17, 0}, {18, 0},
+	{19, 0}, {20, 0}, {21, 0}, {22, 0}, {23, 0}, {24, 0}, {25, 0}, {26, 0},
+	{27, 0}, {28, 0}, {29, 0}, {30, 0}, {31, 0}, {32, 0}, {33, 0}, {34, 0},
+	{35, 1}, {37, 1}, {39, 1}, {41, 1}, {43, 2}, {47, 2}, {51, 3}, {59, 3},
+	{67, 4}, {83, 4}, {99, 5}, {131, 7}, {259, 8}, {515, 9}, {1027, 10}, {2051, 11},
+	{4099, 12}, {8195, 13}, {16387, 14}, {32771, 15}, {65539, 16},
+}
+
+const (
+	offsetCodeMaximum = 31
+
+	literalsLengthMaximumAccuracyLog = 9
+	matchLengthMaximumAccuracyLog    = 9
+	offsetMaximumAccuracyLog         = 8
+)
+
+// Default distributions, used when the predefined compression mode is
+// selected. These are described in RFC 8878, section 3.1.1.3.2.2.
+var (
+	predefinedLiteralsLengthProbabilities = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	predefinedMatchLengthProbabilities = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	predefinedOffsetProbabilities = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+
+	predefinedLiteralsLengthDecodingTable = mustNewFSEDecodingTable(predefinedLiteralsLengthProbabilities, 6)
+	predefinedMatchLengthDecodingTable    = mustNewFSEDecodingTable(predefinedMatchLengthProbabilities, 6)
+	predefinedOffsetDecodingTable         = mustNewFSEDecodingTable(predefinedOffsetProbabilities, 5)
+
+	predefinedLiteralsLengthEncodingTable = mustNewFSEEncodingTable(predefinedLiteralsLengthProbabilities, 6)
+	predefinedMatchLengthEncodingTable    = mustNewFSEEncodingTable(predefinedMatchLengthProbabilities, 6)
+	predefinedOffsetEncodingTable         = mustNewFSEEncodingTable(predefinedOffsetProbabilities, 5)
+)
+
+// getLengthCode returns the code for a literals length or match
+// length, which is the last code whose baseline does not exceed the
+// length.
+func getLengthCode(codes []lengthCode, length uint32) uint8 {
+	code := len(codes) - 1
+	for codes[code].baseline > length {
+		code--
+	}
+	return uint8(code)
+}
+
+// sequence of literals, followed by a match. The offset is stored in
+// the form in which it is encoded, meaning that values 1 to 3 refer to
+// repeated offsets.
+type sequence struct {
+	literalsLength uint32
+	matchLength    uint32
+	offsetValue    uint32
+}
@@ -0,0 +1,379 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"io"
+	"math/bits"
+
+	"github.com/cespare/xxhash/v2"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MinimumLevel is the lowest compression level supported by
+	// NewWriterLevel(), which yields the fastest compression.
+	MinimumLevel = 1
+	// MaximumLevel is the highest compression level supported by
+	// NewWriterLevel(), which yields the best compression ratio.
+	MaximumLevel = 3
+	// DefaultLevel is the compression level used by NewWriter().
+	DefaultLevel = MinimumLevel
+
+	writerHashLog            = 15
+	writerMinimumMatchLength = 4
+
+	// Frame header descriptor that enables content checksums,
+	// followed by a window descriptor for a 128 KiB window.
+	writerFrameHeaderDescriptor = 0x04
+	writerWindowDescriptor      = (17 - 10) << 3
+)
+
+type writer struct {
+	w         io.Writer
+	input     []byte
+	output    []byte
+	checksum  *xxhash.Digest
+	lazyDepth int
+	hashTable [1 << writerHashLog]int32
+	sequences []sequence
+	literals  []byte
+}
+
+// NewWriter creates a compressor that writes data in the Zstandard
+// format, as described in RFC 8878, using DefaultLevel. All data is
+// written as a single frame, which is completed when Close() is
+// called.
+//
+// This compressor favours speed and simplicity over compression
+// ratio. Matches are only searched for within blocks of 128 KiB using a
+// single hash table. Literals are Huffman coded if their symbol values
+// are at most 128, which is the case for textual data. Sequences are
+// encoded using the predefined FSE tables.
+func NewWriter(w io.Writer) io.WriteCloser {
+	return newWriter(w, DefaultLevel)
+}
+
+// NewWriterLevel is identical to NewWriter(), except that it permits
+// specifying the compression level, which must be between MinimumLevel
+// and MaximumLevel. At higher levels, the compressor performs lazy
+// matching: before accepting a match, it checks whether a longer match
+// starts at one of the level-1 positions that follow. This improves
+// the compression ratio at the cost of speed.
+func NewWriterLevel(w io.Writer, level int) (io.WriteCloser, error) {
+	if level < MinimumLevel || level > MaximumLevel {
+		return nil, status.Errorf(codes.InvalidArgument, "Zstandard compression level must be between %d and %d, not %d", MinimumLevel, MaximumLevel, level)
+	}
+	return newWriter(w, level), nil
+}
+
+func newWriter(w io.Writer, level int) io.WriteCloser {
+	output := make([]byte, 6, 2*blockMaximumSizeBytes)
+	binary.LittleEndian.PutUint32(output, frameMagicNumber)
+	output[4] = writerFrameHeaderDescriptor
+	output[5] = writerWindowDescriptor
+	return &writer{
+		w:         w,
+		input:     make([]byte, 0, blockMaximumSizeBytes),
+		output:    output,
+		checksum:  xxhash.New(),
+		lazyDepth: level - MinimumLevel,
+	}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	nTotal := 0
+	for len(p) > 0 {
+		// Only emit a block once more data is provided, as
+		// the final block needs to be marked as such.
+		if len(w.input) == cap(w.input) {
+			if err := w.flushBlock(false); err != nil {
+				return nTotal, err
+			}
+		}
+		n := copy(w.input[len(w.input):cap(w.input)], p)
+		w.input = w.input[:len(w.input)+n]
+		p = p[n:]
+		nTotal += n
+	}
+	return nTotal, nil
+}
+
+func (w *writer) Close() error {
+	return w.flushBlock(true)
+}
+
+func (w *writer) flushBlock(lastBlock bool) error {
+	w.checksum.Write(w.input)
+	w.encodeBlock(w.input, lastBlock)
+	if lastBlock {
+		var checksum [4]byte
+		binary.LittleEndian.PutUint32(checksum[:], uint32(w.checksum.Sum64()))
+		w.output = append(w.output, checksum[:]...)
+	}
+	_, err := w.w.Write(w.output)
+	w.input = w.input[:0]
+	w.output = w.output[:0]
+	return err
+}
+
+func getBlockHeader(lastBlock bool, blockType, blockSize int) [3]byte {
+	header := blockType<<1 | blockSize<<3
+	if lastBlock {
+		header |= 1
+	}
+	return [...]byte{byte(header), byte(header >> 8), byte(header >> 16)}
+}
+
+// encodeBlock appends a single block to the output, picking
+// whichever block type yields the smallest output.
+func (w *writer) encodeBlock(src []byte, lastBlock bool) {
+	if len(src) > 1 && isRun(src) {
+		header := getBlockHeader(lastBlock, blockTypeRLE, len(src))
+		w.output = append(append(w.output, header[:]...), src[0])
+		return
+	}
+
+	// Attempt to compress the block, leaving space for the block
+	// header. Fall back to storing the block in raw form if
+	// compression does not reduce its size.
+	blockStart := len(w.output)
+	w.output = append(w.output, 0, 0, 0)
+	w.compressBlock(src)
+	if compressedSize := len(w.output) - blockStart - 3; compressedSize < len(src) {
+		header := getBlockHeader(lastBlock, blockTypeCompressed, compressedSize)
+		copy(w.output[blockStart:], header[:])
+		return
+	}
+	header := getBlockHeader(lastBlock, blockTypeRaw, len(src))
+	w.output = append(append(w.output[:blockStart], header[:]...), src...)
+}
+
+// isRun returns whether all bytes in a byte slice are identical.
+func isRun(src []byte) bool {
+	for _, b := range src[1:] {
+		if b != src[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// findSequences splits the input of a block into sequences, using a
+// greedy search for matches of at least writerMinimumMatchLength bytes.
+func (w *writer) findSequences(src []byte) {
+	w.sequences = w.sequences[:0]
+	w.literals = w.literals[:0]
+	for i := range w.hashTable {
+		w.hashTable[i] = 0
+	}
+
+	literalsStart := 0
+	for i := 0; i+writerMinimumMatchLength <= len(src); {
+		value := binary.LittleEndian.Uint32(src[i:])
+		candidate := w.swapHashTableEntry(value, i)
+		if candidate < 0 || binary.LittleEndian.Uint32(src[candidate:]) != value {
+			// Skip ahead faster as the number of
+			// consecutive literals increases.
+			i += 1 + (i-literalsStart)>>6
+			continue
+		}
+
+		// Extend the match forwards. If lazy matching is
+		// enabled, prefer longer matches starting at the
+		// positions that follow, if any.
+		matchLength := getForwardMatchLength(src, i, candidate)
+		for depth := 0; depth < w.lazyDepth && i+1+writerMinimumMatchLength <= len(src); depth++ {
+			nextValue := binary.LittleEndian.Uint32(src[i+1:])
+			nextCandidate := w.swapHashTableEntry(nextValue, i+1)
+			if nextCandidate < 0 || binary.LittleEndian.Uint32(src[nextCandidate:]) != nextValue {
+				break
+			}
+			nextMatchLength := getForwardMatchLength(src, i+1, nextCandidate)
+			if nextMatchLength <= matchLength {
+				break
+			}
+			i, candidate, matchLength = i+1, nextCandidate, nextMatchLength
+		}
+
+		// Extend the match backwards.
+		for i > literalsStart && candidate > 0 && src[i-1] == src[candidate-1] {
+			i--
+			candidate--
+			matchLength++
+		}
+
+		w.literals = append(w.literals, src[literalsStart:i]...)
+		w.sequences = append(w.sequences, sequence{
+			literalsLength: uint32(i - literalsStart),
+			matchLength:    uint32(matchLength),
+			offsetValue:    uint32(i-candidate) + 3,
+		})
+		i += matchLength
+		literalsStart = i
+
+		// Register a position near the end of the match, as
+		// it is likely the start of another match.
+		if i-2+writerMinimumMatchLength <= len(src) {
+			w.swapHashTableEntry(binary.LittleEndian.Uint32(src[i-2:]), i-2)
+		}
+	}
+	w.literals = append(w.literals, src[literalsStart:]...)
+}
+
+// swapHashTableEntry stores the position of a four byte value in the
+// hash table, returning the position previously stored for values
+// with the same hash, or -1 if none.
+func (w *writer) swapHashTableEntry(value uint32, position int) int {
+	hash := (value * 2654435761) >> (32 - writerHashLog)
+	candidate := int(w.hashTable[hash]) - 1
+	w.hashTable[hash] = int32(position + 1)
+	return candidate
+}
+
+// getForwardMatchLength returns the length of the match between the
+// data at two positions, which are known to share the first
+// writerMinimumMatchLength bytes.
+func getForwardMatchLength(src []byte, i, candidate int) int {
+	matchLength := writerMinimumMatchLength
+	for i+matchLength < len(src) && src[candidate+matchLength] == src[i+matchLength] {
+		matchLength++
+	}
+	return matchLength
+}
+
+// compressBlock appends the contents of a compressed block to the
+// output. If no matches were found, the block only consists of
+// literals, which may still be reduced in size by Huffman coding.
+func (w *writer) compressBlock(src []byte) {
+	w.findSequences(src)
+	w.appendLiterals()
+	if len(w.sequences) == 0 {
+		w.output = append(w.output, 0)
+		return
+	}
+
+	// Sequences section header, using the predefined FSE tables
+	// for all symbol types.
+	switch sequencesCount := len(w.sequences); {
+	case sequencesCount < 0x80:
+		w.output = append(w.output, byte(sequencesCount))
+	case sequencesCount < 0x7f00:
+		w.output = append(w.output, byte(sequencesCount>>8+0x80), byte(sequencesCount))
+	default:
+		w.output = append(w.output, 0xff, byte(sequencesCount-0x7f00), byte((sequencesCount-0x7f00)>>8))
+	}
+	w.output = append(w.output, compressionModePredefined<<6|compressionModePredefined<<4|compressionModePredefined<<2)
+
+	// Sequences are encoded in reverse order, as the decoder
+	// reads the bitstream backwards.
+	bw := bitWriter{out: w.output}
+	last := &w.sequences[len(w.sequences)-1]
+	literalsLengthCode, matchLengthCode, offsetCode := getSequenceCodes(last)
+	var literalsLengthEncoder, matchLengthEncoder, offsetEncoder fseEncoder
+	matchLengthEncoder.init(predefinedMatchLengthEncodingTable, matchLengthCode)
+	offsetEncoder.init(predefinedOffsetEncodingTable, offsetCode)
+	literalsLengthEncoder.init(predefinedLiteralsLengthEncodingTable, literalsLengthCode)
+	addSequenceExtraBits(&bw, last, literalsLengthCode, matchLengthCode, offsetCode)
+	for i := len(w.sequences) - 2; i >= 0; i-- {
+		s := &w.sequences[i]
+		literalsLengthCode, matchLengthCode, offsetCode := getSequenceCodes(s)
+		offsetEncoder.encode(&bw, offsetCode)
+		matchLengthEncoder.encode(&bw, matchLengthCode)
+		literalsLengthEncoder.encode(&bw, literalsLengthCode)
+		addSequenceExtraBits(&bw, s, literalsLengthCode, matchLengthCode, offsetCode)
+	}
+	matchLengthEncoder.flush(&bw)
+	offsetEncoder.flush(&bw)
+	literalsLengthEncoder.flush(&bw)
+	w.output = bw.close()
+}
+
+// appendLiterals appends the literals section of a compressed block
+// to the output. Literals are Huffman coded if doing so reduces their
+// size. Otherwise they are stored in raw form.
+func (w *writer) appendLiterals() {
+	literals := w.literals
+	if len(literals) > 1 && isRun(literals) {
+		w.appendLiteralsHeader(literalsBlockTypeRLE, len(literals))
+		w.output = append(w.output, literals[0])
+		return
+	}
+
+	if table, ok := newHuffmanEncodingTable(literals); ok {
+		// Small sections use a single stream, while larger
+		// sections are split into four streams that are
+		// preceded by a jump table, permitting the decoder to
+		// decode them in parallel.
+		sectionStart := len(w.output)
+		headerSize, sizeFormat, sizeBits := 3, 0, uint(10)
+		switch regeneratedSize := len(literals); {
+		case regeneratedSize >= 1<<14:
+			headerSize, sizeFormat, sizeBits = 5, 3, 18
+		case regeneratedSize >= 1<<10:
+			headerSize, sizeFormat, sizeBits = 4, 2, 14
+		}
+		w.output = append(w.output, make([]byte, headerSize)...)
+		w.output = table.appendDescription(w.output)
+		if sizeFormat == 0 {
+			w.output = table.appendStream(w.output, literals)
+		} else {
+			jumpTableStart := len(w.output)
+			w.output = append(w.output, make([]byte, 6)...)
+			segmentSize := (len(literals) + 3) / 4
+			for i := 0; i < 4; i++ {
+				streamStart := len(w.output)
+				segment := literals
+				if i < 3 {
+					segment = literals[:segmentSize]
+					literals = literals[segmentSize:]
+				}
+				w.output = table.appendStream(w.output, segment)
+				if i < 3 {
+					binary.LittleEndian.PutUint16(w.output[jumpTableStart+2*i:], uint16(len(w.output)-streamStart))
+				}
+			}
+		}
+
+		compressedSize := len(w.output) - sectionStart - headerSize
+		if compressedSize < len(w.literals) && compressedSize < 1<<sizeBits {
+			header := uint64(literalsBlockTypeCompressed) | uint64(sizeFormat)<<2 | uint64(len(w.literals))<<4 | uint64(compressedSize)<<(4+sizeBits)
+			for i := 0; i < headerSize; i++ {
+				w.output[sectionStart+i] = byte(header >> (8 * uint(i)))
+			}
+			return
+		}
+		w.output = w.output[:sectionStart]
+	}
+
+	w.appendLiteralsHeader(literalsBlockTypeRaw, len(w.literals))
+	w.output = append(w.output, w.literals...)
+}
+
+// appendLiteralsHeader appends the header of a literals section
+// containing raw or RLE literals to the output.
+func (w *writer) appendLiteralsHeader(literalsBlockType, regeneratedSize int) {
+	switch {
+	case regeneratedSize < 1<<5:
+		w.output = append(w.output, byte(literalsBlockType|regeneratedSize<<3))
+	case regeneratedSize < 1<<12:
+		w.output = append(w.output, byte(literalsBlockType|1<<2|regeneratedSize<<4), byte(regeneratedSize>>4))
+	default:
+		w.output = append(w.output, byte(literalsBlockType|3<<2|regeneratedSize<<4), byte(regeneratedSize>>4), byte(regeneratedSize>>12))
+	}
+}
+
+func getSequenceCodes(s *sequence) (uint8, uint8, uint8) {
+	return getLengthCode(literalsLengthCodes[:], s.literalsLength),
+		getLengthCode(matchLengthCodes[:], s.matchLength),
+		uint8(bits.Len32(s.offsetValue) - 1)
+}
+
+func addSequenceExtraBits(bw *bitWriter, s *sequence, literalsLengthCode, matchLengthCode, offsetCode uint8) {
+	literalsLength := literalsLengthCodes[literalsLengthCode]
+	bw.addBits(uint64(s.literalsLength-literalsLength.baseline), uint(literalsLength.nBits))
+	matchLength := matchLengthCodes[matchLengthCode]
+	bw.addBits(uint64(s.matchLength-matchLength.baseline), uint(matchLength.nBits))
+	bw.addBits(uint64(s.offsetValue), uint(offsetCode))
+}
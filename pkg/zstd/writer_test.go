@@ -0,0 +1,120 @@
+package zstd_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/buildbarn/bb-storage/pkg/testutil"
+	"github.com/buildbarn/bb-storage/pkg/zstd"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func compress(t *testing.T, data []byte, writeSize int) []byte {
+	var b bytes.Buffer
+	w := zstd.NewWriter(&b)
+	for len(data) > 0 {
+		n := writeSize
+		if n > len(data) {
+			n = len(data)
+		}
+		_, err := w.Write(data[:n])
+		require.NoError(t, err)
+		data = data[n:]
+	}
+	require.NoError(t, w.Close())
+	return b.Bytes()
+}
+
+func TestWriter(t *testing.T) {
+	var text bytes.Buffer
+	for i := 0; text.Len() < 1000000; i++ {
+		fmt.Fprintf(&text, "Line %d contains the value %d\n", i, i*i%9973)
+	}
+	random := make([]byte, 300000)
+	rand.New(rand.NewSource(123)).Read(random)
+	encoded := []byte(base64.StdEncoding.EncodeToString(random))
+
+	for _, tc := range []struct {
+		name                  string
+		data                  []byte
+		maximumCompressedSize int
+	}{
+		{"Empty", nil, 13},
+		{"Small", []byte("Hello"), 18},
+		{"Run", bytes.Repeat([]byte{0x42}, 300000), 26},
+		{"Text", text.Bytes(), text.Len() / 2},
+		{"Random", random, len(random) + 32},
+		// Base64 encoded random data contains few matches, but
+		// only uses 64 symbols. Huffman coding literals should
+		// allow storing every symbol in about six bits.
+		{"Base64", encoded, len(encoded)*6/8 + 1000},
+		{"Mixed", append(append(append([]byte(nil), text.Bytes()[:200000]...), random...), text.Bytes()...), len(random) + text.Len()/2 + 100000},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Compressed data should be decompressible,
+			// regardless of the size of the writes.
+			for _, writeSize := range []int{1000, 1 << 20} {
+				compressed := compress(t, tc.data, writeSize)
+				require.LessOrEqual(t, len(compressed), tc.maximumCompressedSize)
+
+				data, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(compressed), 1<<20))
+				require.NoError(t, err)
+				require.Equal(t, len(tc.data), len(data))
+				require.True(t, bytes.Equal(tc.data, data))
+			}
+		})
+	}
+}
+
+func TestWriterLevel(t *testing.T) {
+	var text bytes.Buffer
+	for i := 0; text.Len() < 1000000; i++ {
+		fmt.Fprintf(&text, "Line %d contains the value %d\n", i, i*i%9973)
+	}
+
+	compressLevel := func(t *testing.T, level int) []byte {
+		var b bytes.Buffer
+		w, err := zstd.NewWriterLevel(&b, level)
+		require.NoError(t, err)
+		_, err = w.Write(text.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		data, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(b.Bytes()), 1<<20))
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(text.Bytes(), data))
+		return b.Bytes()
+	}
+
+	t.Run("TooLow", func(t *testing.T) {
+		_, err := zstd.NewWriterLevel(ioutil.Discard, 0)
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Zstandard compression level must be between 1 and 3, not 0"), err)
+	})
+
+	t.Run("TooHigh", func(t *testing.T) {
+		_, err := zstd.NewWriterLevel(ioutil.Discard, 4)
+		testutil.RequireEqualStatus(t, status.Error(codes.InvalidArgument, "Zstandard compression level must be between 1 and 3, not 4"), err)
+	})
+
+	t.Run("DefaultLevel", func(t *testing.T) {
+		// NewWriter() should behave identically to
+		// NewWriterLevel() with the default level.
+		require.Equal(t, compress(t, text.Bytes(), 1<<20), compressLevel(t, zstd.DefaultLevel))
+	})
+
+	t.Run("HigherLevelsCompressBetter", func(t *testing.T) {
+		previous := compressLevel(t, zstd.MinimumLevel)
+		for level := zstd.MinimumLevel + 1; level <= zstd.MaximumLevel; level++ {
+			current := compressLevel(t, level)
+			require.Less(t, len(current), len(previous))
+			previous = current
+		}
+	})
+}